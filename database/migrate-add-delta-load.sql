@@ -0,0 +1,132 @@
+-- Migration: Delta-aware loads (DB_LOAD_MODE=delta)
+-- Run this on existing database to add new columns without dropping data
+
+BEGIN;
+
+ALTER TABLE scans
+ADD COLUMN IF NOT EXISTS load_mode VARCHAR(10) DEFAULT 'full';
+
+ALTER TABLE scans
+ADD COLUMN IF NOT EXISTS snapshot_scan_id INT;
+
+ALTER TABLE vulnerability_lifecycle
+ADD COLUMN IF NOT EXISTS vuln_id INT REFERENCES vulnerabilities(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_lifecycle_vuln ON vulnerability_lifecycle(vuln_id);
+
+-- Point each finding at its row in the image's latest scan
+UPDATE vulnerability_lifecycle l
+SET vuln_id = v.id
+FROM vulnerabilities v
+WHERE v.image_id = l.image_id
+  AND v.cve_id = l.cve_id
+  AND v.package_name = l.package_name
+  AND v.package_version IS NOT DISTINCT FROM l.package_version
+  AND v.scan_id = (
+      SELECT MAX(id) FROM scans WHERE image_id = l.image_id AND scan_status = 'completed'
+  );
+
+-- Findings missing from the latest scan were fixed by it (earlier loads never closed them)
+UPDATE vulnerability_lifecycle l
+SET status = 'fixed',
+    fixed_in_scan_id = s.id,
+    fixed_date = s.scan_date,
+    days_to_fix = EXTRACT(DAY FROM s.scan_date - l.first_seen_date),
+    updated_at = NOW()
+FROM scans s
+WHERE l.status = 'active'
+  AND l.vuln_id IS NULL
+  AND s.id = (SELECT MAX(id) FROM scans WHERE image_id = l.image_id AND scan_status = 'completed');
+
+-- The latest-scan views now read the active findings from the lifecycle table
+
+-- Current vulnerabilities by image (latest scan). Findings are resolved through the
+-- lifecycle table so images loaded with DB_LOAD_MODE=delta, whose latest scan only
+-- stores the findings that changed, are covered too.
+CREATE OR REPLACE VIEW current_vulnerabilities AS
+SELECT
+    i.image_name,
+    i.image_tag,
+    i.image_variant,
+    v.cve_id,
+    v.package_name,
+    v.package_version,
+    v.severity,
+    v.found_by,
+    v.cvss_score,
+    s.scan_date,
+    s.id as scan_id
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+JOIN images i ON l.image_id = i.id
+JOIN scans s ON s.id = (
+    SELECT MAX(id)
+    FROM scans
+    WHERE image_id = l.image_id AND scan_status = 'completed'
+)
+WHERE l.status <> 'fixed';
+
+-- Top CVEs across all images
+CREATE OR REPLACE VIEW top_cves AS
+SELECT
+    v.cve_id,
+    v.severity,
+    COUNT(DISTINCT v.image_id) as affected_images,
+    COUNT(DISTINCT v.package_name) as affected_packages,
+    MAX(v.cvss_score) as max_cvss_score,
+    MIN(v.first_detected) as first_detected,
+    MAX(v.last_detected) as last_detected
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+WHERE l.status <> 'fixed'
+GROUP BY v.cve_id, v.severity
+ORDER BY affected_images DESC, max_cvss_score DESC;
+
+-- Scanner comparison statistics
+CREATE OR REPLACE VIEW scanner_comparison AS
+SELECT
+    i.image_name,
+    i.image_tag,
+    i.image_variant,
+    s.scan_date,
+    SUM(CASE WHEN v.found_by = 'trivy' THEN 1 ELSE 0 END) as trivy_only,
+    SUM(CASE WHEN v.found_by = 'grype' THEN 1 ELSE 0 END) as grype_only,
+    SUM(CASE WHEN v.found_by LIKE '%,%' THEN 1 ELSE 0 END) as both_tools,
+    COUNT(*) as total
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+JOIN images i ON l.image_id = i.id
+JOIN scans s ON s.id = (
+    SELECT MAX(id)
+    FROM scans
+    WHERE image_id = l.image_id AND scan_status = 'completed'
+)
+WHERE l.status <> 'fixed'
+GROUP BY i.image_name, i.image_tag, i.image_variant, s.scan_date;
+
+-- Latest scan breakdown by category
+CREATE OR REPLACE VIEW latest_vulnerability_breakdown_by_category AS
+SELECT
+    i.image_name,
+    i.image_tag,
+    i.image_variant,
+    s.scan_date,
+    v.package_category,
+    COUNT(*) as total_vulnerabilities,
+    COUNT(CASE WHEN v.severity = 'CRITICAL' THEN 1 END) as critical_count,
+    COUNT(CASE WHEN v.severity = 'HIGH' THEN 1 END) as high_count,
+    COUNT(CASE WHEN v.severity = 'MEDIUM' THEN 1 END) as medium_count,
+    COUNT(CASE WHEN v.severity = 'LOW' THEN 1 END) as low_count
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+JOIN images i ON l.image_id = i.id
+JOIN scans s ON s.id = (
+    SELECT MAX(id)
+    FROM scans
+    WHERE image_id = l.image_id AND scan_status = 'completed'
+)
+WHERE l.status <> 'fixed'
+GROUP BY i.image_name, i.image_tag, i.image_variant, s.scan_date, v.package_category
+ORDER BY i.image_name, v.package_category;
+
+COMMIT;
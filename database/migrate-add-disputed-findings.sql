@@ -0,0 +1,18 @@
+-- Migration: Track findings flagged as likely false positives by the scheduler
+-- Run this on existing database to add new columns without dropping data
+
+BEGIN;
+
+ALTER TABLE vulnerabilities
+ADD COLUMN IF NOT EXISTS disputed BOOLEAN DEFAULT FALSE;
+
+ALTER TABLE vulnerabilities
+ADD COLUMN IF NOT EXISTS dispute_reasons JSONB;
+
+-- Disputed findings are excluded from the per-severity counts
+ALTER TABLE scans
+ADD COLUMN IF NOT EXISTS disputed_count INT DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_vulns_disputed ON vulnerabilities(disputed);
+
+COMMIT;
@@ -0,0 +1,20 @@
+-- Migration: Record the images excluded from each cycle by scheduler exclusion rules
+-- Run this on existing database to add new tables without dropping data
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS image_exclusions (
+    id SERIAL PRIMARY KEY,
+    image_variant VARCHAR(50) NOT NULL,
+    image_ref VARCHAR(500) NOT NULL, -- full image reference, e.g. postgres:17
+    rule VARCHAR(500) NOT NULL, -- pattern of the matching rule
+    reason TEXT NOT NULL,
+    expires_at TIMESTAMPTZ,
+    run_id VARCHAR(64),
+    excluded_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_image_exclusions_variant ON image_exclusions(image_variant, excluded_at DESC);
+CREATE INDEX IF NOT EXISTS idx_image_exclusions_run_id ON image_exclusions(run_id);
+
+COMMIT;
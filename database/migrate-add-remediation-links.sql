@@ -0,0 +1,9 @@
+-- Migration: Store links to upstream fix commits, advisories and changelogs per finding
+-- Run this on existing database to add new columns without dropping data
+
+BEGIN;
+
+ALTER TABLE vulnerabilities
+ADD COLUMN IF NOT EXISTS remediation_links JSONB;
+
+COMMIT;
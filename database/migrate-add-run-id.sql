@@ -0,0 +1,11 @@
+-- Migration: Record the scheduler run ID on each scan
+-- Run this on existing database to add new columns without dropping data
+
+BEGIN;
+
+ALTER TABLE scans
+ADD COLUMN IF NOT EXISTS run_id VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_scans_run_id ON scans(run_id);
+
+COMMIT;
@@ -0,0 +1,27 @@
+-- Migration: Add the scan job queue used by external workers (QUEUE_MODE=postgres)
+-- Run this on existing database to add new tables without dropping data
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS scan_jobs (
+    id SERIAL PRIMARY KEY,
+    run_id VARCHAR(64) NOT NULL,
+    variant VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued', -- queued, running, succeeded, failed, cancelled
+    deadline TIMESTAMPTZ, -- end of the cycle time budget
+    priority_images JSONB, -- images to scan first
+    worker VARCHAR(255),
+    attempts INT NOT NULL DEFAULT 0,
+    result JSONB, -- outcome reported by the worker
+    error TEXT,
+    enqueued_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMPTZ,
+    heartbeat_at TIMESTAMPTZ,
+    progress JSONB, -- image progress of the scan step, reported with the heartbeats
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_scan_jobs_status ON scan_jobs(status, enqueued_at);
+CREATE INDEX IF NOT EXISTS idx_scan_jobs_run_id ON scan_jobs(run_id);
+
+COMMIT;
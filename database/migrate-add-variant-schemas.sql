@@ -0,0 +1,80 @@
+-- Migration: Variant catalog and optional per-variant schemas
+-- Run this on existing database to add new objects without dropping data.
+-- Existing rows stay in the public schema.
+
+BEGIN;
+
+-- Variant catalog: shared metadata about every variant and where its data lives.
+-- With DB_SCHEMA_PER_VARIANT=true each variant is stored in its own schema
+-- (variant_<name>) so access can be granted, and data dropped, per variant.
+CREATE TABLE IF NOT EXISTS variant_catalog (
+    variant VARCHAR(50) PRIMARY KEY,
+    schema_name VARCHAR(63) NOT NULL DEFAULT 'public',
+    created_at TIMESTAMP DEFAULT NOW(),
+    last_loaded_at TIMESTAMP,
+    last_run_id VARCHAR(64)
+);
+
+-- Create (or reuse) the schema holding a variant's tables and views
+CREATE OR REPLACE FUNCTION create_variant_schema(p_variant TEXT) RETURNS TEXT AS $$
+DECLARE
+    v_schema TEXT := 'variant_' || replace(lower(p_variant), '-', '_');
+    v_path TEXT := current_setting('search_path');
+    v_table TEXT;
+    v_view RECORD;
+    v_def TEXT;
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = v_schema) THEN
+        INSERT INTO public.variant_catalog (variant, schema_name) VALUES (p_variant, v_schema)
+        ON CONFLICT (variant) DO UPDATE SET schema_name = EXCLUDED.schema_name;
+        RETURN v_schema;
+    END IF;
+
+    EXECUTE format('CREATE SCHEMA %I', v_schema);
+
+    -- Same columns, defaults, constraints and indexes as the shared tables
+    FOREACH v_table IN ARRAY ARRAY['images', 'scans', 'vulnerabilities', 'vulnerability_lifecycle', 'scan_comparisons'] LOOP
+        EXECUTE format('CREATE TABLE %I.%I (LIKE public.%I INCLUDING ALL)', v_schema, v_table, v_table);
+    END LOOP;
+
+    -- LIKE does not copy foreign keys
+    EXECUTE format('ALTER TABLE %1$I.scans ADD FOREIGN KEY (image_id) REFERENCES %1$I.images(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.vulnerabilities ADD FOREIGN KEY (scan_id) REFERENCES %1$I.scans(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.vulnerabilities ADD FOREIGN KEY (image_id) REFERENCES %1$I.images(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.vulnerability_lifecycle ADD FOREIGN KEY (image_id) REFERENCES %1$I.images(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.scan_comparisons ADD FOREIGN KEY (image_id) REFERENCES %1$I.images(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.scan_comparisons ADD FOREIGN KEY (previous_scan_id) REFERENCES %1$I.scans(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.scan_comparisons ADD FOREIGN KEY (current_scan_id) REFERENCES %1$I.scans(id) ON DELETE CASCADE', v_schema);
+
+    -- Recreate the reporting views on top of the variant's tables: the definitions
+    -- are read with only public on the path (so table names come back unqualified)
+    -- and created with the variant schema first on the path
+    FOR v_view IN SELECT viewname FROM pg_views WHERE schemaname = 'public' ORDER BY viewname LOOP
+        PERFORM set_config('search_path', 'public', true);
+        v_def := pg_get_viewdef(format('public.%I', v_view.viewname)::regclass);
+        PERFORM set_config('search_path', format('%I, public', v_schema), true);
+        EXECUTE format('CREATE VIEW %I.%I AS %s', v_schema, v_view.viewname, v_def);
+    END LOOP;
+    PERFORM set_config('search_path', v_path, true);
+
+    INSERT INTO public.variant_catalog (variant, schema_name) VALUES (p_variant, v_schema)
+    ON CONFLICT (variant) DO UPDATE SET schema_name = EXCLUDED.schema_name;
+    RETURN v_schema;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Drop a variant's schema and all of its data, and remove it from the catalog
+CREATE OR REPLACE FUNCTION drop_variant_schema(p_variant TEXT) RETURNS VOID AS $$
+DECLARE
+    v_schema TEXT;
+BEGIN
+    SELECT schema_name INTO v_schema FROM public.variant_catalog WHERE variant = p_variant;
+    IF v_schema IS NULL OR v_schema = 'public' THEN
+        RAISE EXCEPTION 'variant % is not stored in its own schema', p_variant;
+    END IF;
+    EXECUTE format('DROP SCHEMA %I CASCADE', v_schema);
+    DELETE FROM public.variant_catalog WHERE variant = p_variant;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMIT;
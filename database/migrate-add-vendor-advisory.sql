@@ -0,0 +1,13 @@
+-- Migration: Add vendor_advisory column populated by the scheduler's advisory cross-check
+-- Run this on existing database to add new columns without dropping data
+
+BEGIN;
+
+-- Vendor feed status for each finding: {"Source", "Status", "FixedVersion"}
+-- Status is one of: fixed, not-affected, unacknowledged
+ALTER TABLE vulnerabilities
+ADD COLUMN IF NOT EXISTS vendor_advisory JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_vulns_vendor_status ON vulnerabilities((vendor_advisory->>'Status'));
+
+COMMIT;
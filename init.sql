@@ -7,7 +7,7 @@ CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 -- Images table: Core information about container images
 CREATE TABLE IF NOT EXISTS images (
     id SERIAL PRIMARY KEY,
-    image_name VARCHAR(255) UNIQUE NOT NULL,
+    image_name VARCHAR(255) NOT NULL,
     image_tag VARCHAR(100) NOT NULL,
     full_name VARCHAR(512) NOT NULL, -- image_name:image_tag
     image_variant VARCHAR(50) DEFAULT 'baseline', -- 'baseline' or 'chainguard'
@@ -19,9 +19,10 @@ CREATE TABLE IF NOT EXISTS images (
     os VARCHAR(100),
     os_version VARCHAR(100),
     docker_metadata JSONB, -- Full Docker inspect output
+    platform VARCHAR(50) NOT NULL DEFAULT '', -- e.g. linux/arm64 when scanned per platform (SCAN_PLATFORMS)
     first_scanned TIMESTAMP DEFAULT NOW(),
     last_scanned TIMESTAMP DEFAULT NOW(),
-    CONSTRAINT unique_image_tag_variant UNIQUE(image_name, image_tag, image_variant)
+    CONSTRAINT unique_image_tag_variant_platform UNIQUE(image_name, image_tag, image_variant, platform)
 );
 
 -- Scans table: Individual scan execution records
@@ -29,6 +30,7 @@ CREATE TABLE IF NOT EXISTS scans (
     id SERIAL PRIMARY KEY,
     scan_uuid UUID UNIQUE NOT NULL DEFAULT uuid_generate_v4(),
     scan_batch_id UUID, -- Groups all images scanned in one script run
+    run_id VARCHAR(64), -- Scheduler cycle that produced the scan (matches /reports/logs/{variant}/{run_id})
     image_id INT NOT NULL REFERENCES images(id) ON DELETE CASCADE,
     image_variant VARCHAR(50) DEFAULT 'baseline', -- 'baseline' or 'chainguard'
     scan_date TIMESTAMP DEFAULT NOW(),
@@ -42,12 +44,19 @@ CREATE TABLE IF NOT EXISTS scans (
     trivy_only_count INT DEFAULT 0,
     grype_only_count INT DEFAULT 0,
     both_tools_count INT DEFAULT 0,
+    disputed_count INT DEFAULT 0, -- Likely false positives, excluded from the severity counts
+    kev_count INT DEFAULT 0, -- Findings listed in CISA's Known Exploited Vulnerabilities catalog
+    fixable_count INT DEFAULT 0, -- Counted findings with a fixed version available
+    no_fix_count INT DEFAULT 0, -- Counted findings without a fix
     scan_duration_seconds INT,
     scan_status VARCHAR(50) DEFAULT 'completed', -- completed, failed, in_progress
     trivy_raw_output JSONB, -- Full Trivy scan JSON
     grype_raw_output JSONB, -- Full Grype scan JSON
     merged_output JSONB, -- Merged scan JSON
-    scan_metadata JSONB, -- Environment, config, etc
+    scan_metadata JSONB, -- Environment, config, etc; scanner_dbs: the Trivy/Grype databases used
+    load_mode VARCHAR(10) DEFAULT 'full', -- full: every finding stored; delta: only findings that changed
+    snapshot_scan_id INT, -- Latest full load of the image that a delta load builds on
+    image_digest VARCHAR(100), -- Digest of the scanned image; a run loaded again updates the scan with the same run, image and digest
     created_at TIMESTAMP DEFAULT NOW()
 );
 
@@ -79,6 +88,13 @@ CREATE TABLE IF NOT EXISTS vulnerabilities (
     cvss_v3_score DECIMAL(3,1),
     exploit_available BOOLEAN DEFAULT FALSE,
     patch_available BOOLEAN,
+    vendor_advisory JSONB, -- Vendor feed status: {"Source", "Status", "FixedVersion"}
+    disputed BOOLEAN DEFAULT FALSE, -- Flagged as a likely false positive by the scheduler
+    dispute_reasons JSONB, -- Heuristics/rules that flagged the finding
+    remediation_links JSONB, -- Fix commits, advisories and changelogs from the references: [{type, url}]
+    epss_score DECIMAL(6,5), -- EPSS probability of exploitation in the next 30 days
+    epss_percentile DECIMAL(6,5),
+    kev JSONB, -- CISA KEV entry: {"DateAdded", "DueDate", "RansomwareUse", ...}
     created_at TIMESTAMP DEFAULT NOW(),
     package_category VARCHAR(20) DEFAULT 'unknown',
     CONSTRAINT unique_scan_vuln UNIQUE(scan_id, cve_id, package_name, package_version)
@@ -99,6 +115,7 @@ CREATE TABLE IF NOT EXISTS vulnerability_lifecycle (
     fixed_in_scan_id INT REFERENCES scans(id),
     fixed_date TIMESTAMP,
     days_to_fix INT,
+    vuln_id INT REFERENCES vulnerabilities(id) ON DELETE SET NULL, -- Row holding the finding's current details
     created_at TIMESTAMP DEFAULT NOW(),
     updated_at TIMESTAMP DEFAULT NOW(),
     CONSTRAINT unique_lifecycle UNIQUE(image_id, cve_id, package_name, package_version)
@@ -138,6 +155,8 @@ CREATE INDEX IF NOT EXISTS idx_scans_status ON scans(scan_status);
 CREATE INDEX IF NOT EXISTS idx_scans_date ON scans(scan_date DESC);
 CREATE INDEX IF NOT EXISTS idx_scans_variant ON scans(image_variant);
 CREATE INDEX IF NOT EXISTS idx_scans_batch ON scans(scan_batch_id);
+CREATE INDEX IF NOT EXISTS idx_scans_run_id ON scans(run_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scans_run_image_digest ON scans(run_id, image_id, image_digest);
 
 CREATE INDEX IF NOT EXISTS idx_images_variant ON images(image_variant);
 
@@ -147,6 +166,8 @@ CREATE INDEX IF NOT EXISTS idx_vulns_cve ON vulnerabilities(cve_id);
 CREATE INDEX IF NOT EXISTS idx_vulns_severity ON vulnerabilities(severity);
 CREATE INDEX IF NOT EXISTS idx_vulns_package ON vulnerabilities(package_name);
 CREATE INDEX IF NOT EXISTS idx_vulns_found_by ON vulnerabilities(found_by);
+CREATE INDEX IF NOT EXISTS idx_vulns_disputed ON vulnerabilities(disputed);
+CREATE INDEX IF NOT EXISTS idx_vulns_vendor_status ON vulnerabilities((vendor_advisory->>'Status'));
 CREATE INDEX IF NOT EXISTS idx_vulns_image_date ON vulnerabilities(image_id, last_detected DESC);
 CREATE INDEX IF NOT EXISTS idx_vulns_package_category ON vulnerabilities(package_category);
 
@@ -154,12 +175,15 @@ CREATE INDEX IF NOT EXISTS idx_lifecycle_image ON vulnerability_lifecycle(image_
 CREATE INDEX IF NOT EXISTS idx_lifecycle_cve ON vulnerability_lifecycle(cve_id);
 CREATE INDEX IF NOT EXISTS idx_lifecycle_status ON vulnerability_lifecycle(status);
 CREATE INDEX IF NOT EXISTS idx_lifecycle_dates ON vulnerability_lifecycle(first_seen_date, last_seen_date);
+CREATE INDEX IF NOT EXISTS idx_lifecycle_vuln ON vulnerability_lifecycle(vuln_id);
 
 CREATE INDEX IF NOT EXISTS idx_comparisons_image ON scan_comparisons(image_id, comparison_date DESC);
 
 -- Useful views for Grafana
 
--- Current vulnerabilities by image (latest scan)
+-- Current vulnerabilities by image (latest scan). Findings are resolved through the
+-- lifecycle table so images loaded with DB_LOAD_MODE=delta, whose latest scan only
+-- stores the findings that changed, are covered too.
 CREATE OR REPLACE VIEW current_vulnerabilities AS
 SELECT
     i.image_name,
@@ -173,15 +197,15 @@ SELECT
     v.cvss_score,
     s.scan_date,
     s.id as scan_id
-FROM vulnerabilities v
-JOIN scans s ON v.scan_id = s.id
-JOIN images i ON v.image_id = i.id
-WHERE s.id IN (
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+JOIN images i ON l.image_id = i.id
+JOIN scans s ON s.id = (
     SELECT MAX(id)
     FROM scans
-    WHERE scan_status = 'completed'
-    GROUP BY image_id
-);
+    WHERE image_id = l.image_id AND scan_status = 'completed'
+)
+WHERE l.status <> 'fixed';
 
 -- Vulnerability trends over time
 CREATE OR REPLACE VIEW vulnerability_trends AS
@@ -214,14 +238,9 @@ SELECT
     MAX(v.cvss_score) as max_cvss_score,
     MIN(v.first_detected) as first_detected,
     MAX(v.last_detected) as last_detected
-FROM vulnerabilities v
-JOIN scans s ON v.scan_id = s.id
-WHERE s.id IN (
-    SELECT MAX(id)
-    FROM scans
-    WHERE scan_status = 'completed'
-    GROUP BY image_id
-)
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+WHERE l.status <> 'fixed'
 GROUP BY v.cve_id, v.severity
 ORDER BY affected_images DESC, max_cvss_score DESC;
 
@@ -236,15 +255,15 @@ SELECT
     SUM(CASE WHEN v.found_by = 'grype' THEN 1 ELSE 0 END) as grype_only,
     SUM(CASE WHEN v.found_by LIKE '%,%' THEN 1 ELSE 0 END) as both_tools,
     COUNT(*) as total
-FROM vulnerabilities v
-JOIN scans s ON v.scan_id = s.id
-JOIN images i ON v.image_id = i.id
-WHERE s.id IN (
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+JOIN images i ON l.image_id = i.id
+JOIN scans s ON s.id = (
     SELECT MAX(id)
     FROM scans
-    WHERE scan_status = 'completed'
-    GROUP BY image_id
+    WHERE image_id = l.image_id AND scan_status = 'completed'
 )
+WHERE l.status <> 'fixed'
 GROUP BY i.image_name, i.image_tag, i.image_variant, s.scan_date;
 
 -- Vulnerability breakdown by category
@@ -269,12 +288,6 @@ ORDER BY s.scan_date DESC, i.image_name, v.package_category;
 
 -- Latest scan breakdown by category
 CREATE OR REPLACE VIEW latest_vulnerability_breakdown_by_category AS
-WITH latest_scans AS (
-    SELECT image_id, MAX(id) as latest_scan_id
-    FROM scans
-    WHERE scan_status = 'completed'
-    GROUP BY image_id
-)
 SELECT
     i.image_name,
     i.image_tag,
@@ -286,13 +299,194 @@ SELECT
     COUNT(CASE WHEN v.severity = 'HIGH' THEN 1 END) as high_count,
     COUNT(CASE WHEN v.severity = 'MEDIUM' THEN 1 END) as medium_count,
     COUNT(CASE WHEN v.severity = 'LOW' THEN 1 END) as low_count
-FROM vulnerabilities v
-JOIN latest_scans ls ON v.scan_id = ls.latest_scan_id
-JOIN scans s ON v.scan_id = s.id
-JOIN images i ON v.image_id = i.id
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+JOIN images i ON l.image_id = i.id
+JOIN scans s ON s.id = (
+    SELECT MAX(id)
+    FROM scans
+    WHERE image_id = l.image_id AND scan_status = 'completed'
+)
+WHERE l.status <> 'fixed'
 GROUP BY i.image_name, i.image_tag, i.image_variant, s.scan_date, v.package_category
 ORDER BY i.image_name, v.package_category;
 
+-- Variant catalog: shared metadata about every variant and where its data lives.
+-- With DB_SCHEMA_PER_VARIANT=true each variant is stored in its own schema
+-- (variant_<name>) so access can be granted, and data dropped, per variant.
+CREATE TABLE IF NOT EXISTS variant_catalog (
+    variant VARCHAR(50) PRIMARY KEY,
+    schema_name VARCHAR(63) NOT NULL DEFAULT 'public',
+    created_at TIMESTAMP DEFAULT NOW(),
+    last_loaded_at TIMESTAMP,
+    last_run_id VARCHAR(64)
+);
+
+-- Create (or reuse) the schema holding a variant's tables and views
+CREATE OR REPLACE FUNCTION create_variant_schema(p_variant TEXT) RETURNS TEXT AS $$
+DECLARE
+    v_schema TEXT := 'variant_' || replace(lower(p_variant), '-', '_');
+    v_path TEXT := current_setting('search_path');
+    v_table TEXT;
+    v_view RECORD;
+    v_def TEXT;
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = v_schema) THEN
+        INSERT INTO public.variant_catalog (variant, schema_name) VALUES (p_variant, v_schema)
+        ON CONFLICT (variant) DO UPDATE SET schema_name = EXCLUDED.schema_name;
+        RETURN v_schema;
+    END IF;
+
+    EXECUTE format('CREATE SCHEMA %I', v_schema);
+
+    -- Same columns, defaults, constraints and indexes as the shared tables
+    FOREACH v_table IN ARRAY ARRAY['images', 'scans', 'vulnerabilities', 'vulnerability_lifecycle', 'scan_comparisons'] LOOP
+        EXECUTE format('CREATE TABLE %I.%I (LIKE public.%I INCLUDING ALL)', v_schema, v_table, v_table);
+    END LOOP;
+
+    -- LIKE does not copy foreign keys
+    EXECUTE format('ALTER TABLE %1$I.scans ADD FOREIGN KEY (image_id) REFERENCES %1$I.images(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.vulnerabilities ADD FOREIGN KEY (scan_id) REFERENCES %1$I.scans(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.vulnerabilities ADD FOREIGN KEY (image_id) REFERENCES %1$I.images(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.vulnerability_lifecycle ADD FOREIGN KEY (image_id) REFERENCES %1$I.images(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.vulnerability_lifecycle ADD FOREIGN KEY (vuln_id) REFERENCES %1$I.vulnerabilities(id) ON DELETE SET NULL', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.scan_comparisons ADD FOREIGN KEY (image_id) REFERENCES %1$I.images(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.scan_comparisons ADD FOREIGN KEY (previous_scan_id) REFERENCES %1$I.scans(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.scan_comparisons ADD FOREIGN KEY (current_scan_id) REFERENCES %1$I.scans(id) ON DELETE CASCADE', v_schema);
+
+    -- Recreate the reporting views on top of the variant's tables: the definitions
+    -- are read with only public on the path (so table names come back unqualified)
+    -- and created with the variant schema first on the path
+    FOR v_view IN SELECT viewname FROM pg_views WHERE schemaname = 'public' ORDER BY viewname LOOP
+        PERFORM set_config('search_path', 'public', true);
+        v_def := pg_get_viewdef(format('public.%I', v_view.viewname)::regclass);
+        PERFORM set_config('search_path', format('%I, public', v_schema), true);
+        EXECUTE format('CREATE VIEW %I.%I AS %s', v_schema, v_view.viewname, v_def);
+    END LOOP;
+    PERFORM set_config('search_path', v_path, true);
+
+    INSERT INTO public.variant_catalog (variant, schema_name) VALUES (p_variant, v_schema)
+    ON CONFLICT (variant) DO UPDATE SET schema_name = EXCLUDED.schema_name;
+    RETURN v_schema;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Drop a variant's schema and all of its data, and remove it from the catalog
+CREATE OR REPLACE FUNCTION drop_variant_schema(p_variant TEXT) RETURNS VOID AS $$
+DECLARE
+    v_schema TEXT;
+BEGIN
+    SELECT schema_name INTO v_schema FROM public.variant_catalog WHERE variant = p_variant;
+    IF v_schema IS NULL OR v_schema = 'public' THEN
+        RAISE EXCEPTION 'variant % is not stored in its own schema', p_variant;
+    END IF;
+    EXECUTE format('DROP SCHEMA %I CASCADE', v_schema);
+    DELETE FROM public.variant_catalog WHERE variant = p_variant;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Scan job queue: with QUEUE_MODE=postgres the scheduler enqueues one job per
+-- variant and `scheduler worker` processes claim them with FOR UPDATE SKIP LOCKED
+CREATE TABLE IF NOT EXISTS scan_jobs (
+    id SERIAL PRIMARY KEY,
+    run_id VARCHAR(64) NOT NULL,
+    variant VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued', -- queued, running, succeeded, failed, cancelled
+    deadline TIMESTAMPTZ, -- end of the cycle time budget
+    priority_images JSONB, -- images to scan first
+    worker VARCHAR(255),
+    attempts INT NOT NULL DEFAULT 0,
+    result JSONB, -- outcome reported by the worker
+    error TEXT,
+    enqueued_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMPTZ,
+    heartbeat_at TIMESTAMPTZ,
+    progress JSONB, -- image progress of the scan step, reported with the heartbeats
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_scan_jobs_status ON scan_jobs(status, enqueued_at);
+CREATE INDEX IF NOT EXISTS idx_scan_jobs_run_id ON scan_jobs(run_id);
+
+-- Image exclusions: images kept out of a cycle by an exclusion rule of the scheduler
+-- config, recorded per cycle so excluded images stay visible next to the scanned ones
+CREATE TABLE IF NOT EXISTS image_exclusions (
+    id SERIAL PRIMARY KEY,
+    image_variant VARCHAR(50) NOT NULL,
+    image_ref VARCHAR(500) NOT NULL, -- full image reference, e.g. postgres:17
+    rule VARCHAR(500) NOT NULL, -- pattern of the matching rule
+    reason TEXT NOT NULL,
+    expires_at TIMESTAMPTZ,
+    run_id VARCHAR(64),
+    excluded_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_image_exclusions_variant ON image_exclusions(image_variant, excluded_at DESC);
+CREATE INDEX IF NOT EXISTS idx_image_exclusions_run_id ON image_exclusions(run_id);
+
+-- Secret findings: secrets found in the images by the scheduler's secrets step
+-- (SECRET_SCANNING), recorded per cycle next to the vulnerability scans
+CREATE TABLE IF NOT EXISTS secret_findings (
+    id SERIAL PRIMARY KEY,
+    image_variant VARCHAR(50) NOT NULL,
+    image_ref VARCHAR(500) NOT NULL, -- full image reference, e.g. postgres:17
+    target TEXT NOT NULL, -- file the secret was found in
+    rule_id VARCHAR(100) NOT NULL, -- Trivy rule, e.g. aws-access-key-id
+    category VARCHAR(100),
+    severity VARCHAR(20) NOT NULL,
+    title TEXT,
+    start_line INT,
+    end_line INT,
+    match_text TEXT, -- offending line with the secret masked
+    run_id VARCHAR(64),
+    found_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_secret_findings_variant ON secret_findings(image_variant, found_at DESC);
+CREATE INDEX IF NOT EXISTS idx_secret_findings_run_id ON secret_findings(run_id);
+
+-- Config findings: failed checks of the Kubernetes manifests and Dockerfiles scanned by
+-- the scheduler's misconfig step (CONFIG_SCAN_PATHS), kept apart from image findings
+CREATE TABLE IF NOT EXISTS config_findings (
+    id SERIAL PRIMARY KEY,
+    image_variant VARCHAR(50) NOT NULL,
+    category VARCHAR(50) NOT NULL, -- kind of file, e.g. kubernetes or dockerfile
+    scanner VARCHAR(20) NOT NULL, -- trivy or checkov
+    file_path TEXT NOT NULL,
+    check_id VARCHAR(100) NOT NULL, -- e.g. KSV001 or CKV_K8S_20
+    title TEXT,
+    severity VARCHAR(20) NOT NULL,
+    message TEXT,
+    resolution TEXT,
+    resource TEXT,
+    start_line INT,
+    run_id VARCHAR(64),
+    found_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_config_findings_variant ON config_findings(image_variant, found_at DESC);
+CREATE INDEX IF NOT EXISTS idx_config_findings_run_id ON config_findings(run_id);
+
+-- License findings: licenses of the packages and files in the images, found by the
+-- scheduler's licenses step (LICENSE_SCANNING); denied marks LICENSE_DENYLIST matches
+CREATE TABLE IF NOT EXISTS license_findings (
+    id SERIAL PRIMARY KEY,
+    image_variant VARCHAR(50) NOT NULL,
+    image_ref VARCHAR(500) NOT NULL,
+    package_name VARCHAR(255), -- unset for licenses found in files
+    file_path TEXT,
+    license VARCHAR(255) NOT NULL, -- SPDX identifier, e.g. AGPL-3.0-only
+    category VARCHAR(50), -- Trivy classification, e.g. restricted or notice
+    severity VARCHAR(20),
+    denied BOOLEAN NOT NULL DEFAULT FALSE, -- matched LICENSE_DENYLIST
+    run_id VARCHAR(64),
+    found_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_license_findings_variant ON license_findings(image_variant, found_at DESC);
+CREATE INDEX IF NOT EXISTS idx_license_findings_run_id ON license_findings(run_id);
+CREATE INDEX IF NOT EXISTS idx_license_findings_denied ON license_findings(denied) WHERE denied;
+
 -- Grant permissions
 GRANT ALL PRIVILEGES ON DATABASE vulndb TO vulnuser;
 GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA public TO vulnuser;
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	maxActivityEvents = 50
+	// maxActivityHistory is how many finished cycles the activity keeps
+	maxActivityHistory = 20
+	// upcomingRuns is how many scheduled cycles of each schedule are listed
+	upcomingRuns = 3
+)
+
+// Image statuses reported while a variant is scanned
+const (
+	imagePending  = "pending"
+	imageScanning = "scanning"
+	imageScanned  = "scanned"
+	// imageUnchanged keeps the report of its last scan (SKIP_UNCHANGED_IMAGES)
+	imageUnchanged = "unchanged"
+	imageSkipped   = "skipped"
+	imageExcluded  = "excluded"
+	imageFailed    = "failed"
+
+	// variantSkipped marks a variant not started before the cycle deadline
+	variantSkipped = "skipped"
+)
+
+// activity tracks what the daemon is doing right now, for GET /scheduler/activity
+// and `scheduler top`
+var activity = &activityTracker{}
+
+// ActivityEvent is a notable moment of the current or a recent cycle
+type ActivityEvent struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// ImageActivity is the scan status of one image of a variant
+type ImageActivity struct {
+	Image           string `json:"image"`
+	Status          string `json:"status"`
+	Vulnerabilities *int   `json:"vulnerabilities,omitempty"`
+}
+
+// VariantActivity is the progress of one variant of the current cycle. Status follows
+// the scan job statuses (queued, running, succeeded, failed, cancelled) plus skipped.
+type VariantActivity struct {
+	Variant   string          `json:"variant"`
+	Status    string          `json:"status"`
+	Step      string          `json:"step,omitempty"`
+	StartedAt *time.Time      `json:"started_at,omitempty"`
+	Images    []ImageActivity `json:"images,omitempty"`
+	// Progress counts the images of the scan step that are done, also for scans run
+	// by `scheduler worker` processes, whose images aren't listed
+	Progress *ScanProgress `json:"progress,omitempty"`
+}
+
+// RunActivity is the cycle in progress, or the last one once it finished
+type RunActivity struct {
+	RunID      string             `json:"run_id"`
+	StartedAt  time.Time          `json:"started_at"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+	Status     string             `json:"status,omitempty"`
+	Deadline   *time.Time         `json:"deadline,omitempty"`
+	Variants   []*VariantActivity `json:"variants"`
+}
+
+// CycleSummary is the outcome of a finished cycle
+type CycleSummary struct {
+	RunID      string    `json:"run_id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Status     string    `json:"status"`
+	Variants   []string  `json:"variants"`
+	Failed     []string  `json:"failed,omitempty"`
+}
+
+// UpcomingRun is a scheduled cycle, of SCAN_SCHEDULE or of a tenant with a schedule
+// of its own
+type UpcomingRun struct {
+	Time     time.Time `json:"time"`
+	Schedule string    `json:"schedule"`
+	Tenant   string    `json:"tenant,omitempty"`
+	Variants []string  `json:"variants"`
+}
+
+// ActivitySnapshot is the response of GET /scheduler/activity
+type ActivitySnapshot struct {
+	Time       time.Time    `json:"time"`
+	Paused     bool         `json:"paused"`
+	Leader     bool         `json:"leader"`
+	QueueMode  string       `json:"queue_mode"`
+	QueueDepth int          `json:"queue_depth"`
+	NextRun    time.Time    `json:"next_run"`
+	Run        *RunActivity `json:"run"`
+	// History lists the cycles finished since the scheduler started, newest first
+	History []CycleSummary `json:"history"`
+	// Upcoming lists the next scheduled cycles, soonest first; empty while paused
+	Upcoming []UpcomingRun   `json:"upcoming"`
+	Events   []ActivityEvent `json:"events"`
+}
+
+type activityTracker struct {
+	mu      sync.Mutex
+	run     *RunActivity
+	history []CycleSummary
+	events  []ActivityEvent
+}
+
+// Event records a message in the recent events list
+func (a *activityTracker) Event(format string, args ...any) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.addEvent(fmt.Sprintf(format, args...))
+}
+
+func (a *activityTracker) addEvent(msg string) {
+	a.events = append(a.events, ActivityEvent{Time: time.Now().UTC(), Message: msg})
+	if len(a.events) > maxActivityEvents {
+		a.events = a.events[len(a.events)-maxActivityEvents:]
+	}
+}
+
+// StartRun begins tracking a cycle with all of its variants queued
+func (a *activityTracker) StartRun(runID string, startedAt time.Time, variants []string, deadline time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	run := &RunActivity{RunID: runID, StartedAt: startedAt}
+	if !deadline.IsZero() {
+		run.Deadline = &deadline
+	}
+	for _, v := range variants {
+		run.Variants = append(run.Variants, &VariantActivity{Variant: v, Status: jobQueued})
+	}
+	a.run = run
+	a.addEvent(fmt.Sprintf("Cycle %s started (%d variant(s))", runID, len(variants)))
+}
+
+// FinishRun marks the tracked cycle as finished
+func (a *activityTracker) FinishRun(cycle *CycleResult) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.run == nil || a.run.RunID != cycle.RunID {
+		return
+	}
+	finished := cycle.FinishedAt
+	a.run.FinishedAt = &finished
+	a.run.Status = cycle.Status
+	summary := CycleSummary{RunID: cycle.RunID, StartedAt: cycle.StartedAt, FinishedAt: finished, Status: cycle.Status, Failed: cycle.Failed()}
+	for _, v := range cycle.Variants {
+		summary.Variants = append(summary.Variants, v.Variant)
+	}
+	a.history = append([]CycleSummary{summary}, a.history...)
+	if len(a.history) > maxActivityHistory {
+		a.history = a.history[:maxActivityHistory]
+	}
+	a.addEvent(fmt.Sprintf("Cycle %s finished: %s", cycle.RunID, cycle.Status))
+}
+
+// Running reports whether a tracked cycle has not finished yet
+func (a *activityTracker) Running() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.run != nil && a.run.FinishedAt == nil
+}
+
+// variant returns the tracked variant of the current cycle, or nil. Callers hold a.mu.
+func (a *activityTracker) variant(name string) *VariantActivity {
+	if a.run == nil {
+		return nil
+	}
+	for _, v := range a.run.Variants {
+		if v.Variant == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// SetVariantStatus records a variant's job status, noting changes as events
+func (a *activityTracker) SetVariantStatus(variant, status string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	v := a.variant(variant)
+	if v == nil || v.Status == status {
+		return
+	}
+	v.Status = status
+	if status == jobRunning && v.StartedAt == nil {
+		now := time.Now().UTC()
+		v.StartedAt = &now
+	}
+	if status != jobRunning {
+		v.Step = ""
+	}
+	a.addEvent(fmt.Sprintf("[%s] %s", variant, status))
+}
+
+// SetImages lists the images a variant is about to scan, all pending, followed by
+// those excluded by rule
+func (a *activityTracker) SetImages(variant string, images, excluded []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	v := a.variant(variant)
+	if v == nil {
+		return
+	}
+	v.Images = make([]ImageActivity, 0, len(images)+len(excluded))
+	v.Progress = nil
+	for _, image := range images {
+		v.Images = append(v.Images, ImageActivity{Image: image, Status: imagePending})
+	}
+	for _, image := range excluded {
+		v.Images = append(v.Images, ImageActivity{Image: image, Status: imageExcluded})
+	}
+}
+
+// SetStep records the pipeline step a variant is running
+func (a *activityTracker) SetStep(variant, step string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if v := a.variant(variant); v != nil {
+		v.Step = step
+	}
+}
+
+// SetImageStatus updates one image of a variant; vulns is recorded when not nil
+func (a *activityTracker) SetImageStatus(variant, image, status string, vulns *int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	v := a.variant(variant)
+	if v == nil {
+		return
+	}
+	for i := range v.Images {
+		if v.Images[i].Image == image {
+			v.Images[i].Status = status
+			if vulns != nil {
+				v.Images[i].Vulnerabilities = vulns
+			}
+			break
+		}
+	}
+	switch status {
+	case imageScanned:
+		if vulns != nil {
+			a.addEvent(fmt.Sprintf("[%s] %s scanned: %d vulnerabilities", variant, image, *vulns))
+		}
+	case imageSkipped:
+		a.addEvent(fmt.Sprintf("[%s] %s skipped (cycle time budget)", variant, image))
+	case imageFailed:
+		a.addEvent(fmt.Sprintf("[%s] %s failed to scan", variant, image))
+	}
+}
+
+// SetProgress records the image progress of a variant's scan step
+func (a *activityTracker) SetProgress(variant string, progress ScanProgress) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if v := a.variant(variant); v != nil {
+		v.Progress = &progress
+	}
+}
+
+// Snapshot returns a copy of the tracked state
+func (a *activityTracker) Snapshot() ActivitySnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	snap := ActivitySnapshot{
+		Time:    time.Now().UTC(),
+		History: append([]CycleSummary{}, a.history...),
+		Events:  append([]ActivityEvent{}, a.events...),
+	}
+	if a.run != nil {
+		run := *a.run
+		run.Variants = make([]*VariantActivity, len(a.run.Variants))
+		for i, v := range a.run.Variants {
+			vc := *v
+			vc.Images = append([]ImageActivity(nil), v.Images...)
+			if v.Progress != nil {
+				progress := *v.Progress
+				vc.Progress = &progress
+			}
+			run.Variants[i] = &vc
+			if run.FinishedAt == nil && v.Status == jobQueued {
+				snap.QueueDepth++
+			}
+		}
+		snap.Run = &run
+	}
+	return snap
+}
+
+// activityHandler serves GET /scheduler/activity
+func activityHandler(s *Scheduler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		snap := activity.Snapshot()
+		snap.Paused = s.Paused()
+		snap.Leader = s.elector.IsLeader()
+		snap.QueueMode = s.Config().QueueMode
+		snap.NextRun = s.NextRun()
+		snap.Upcoming = s.UpcomingRuns(upcomingRuns)
+		writeJSON(w, http.StatusOK, snap)
+	})
+}
+
+// Markers printed by scan-vulnerabilities.sh for each image
+var (
+	scanningImageLine  = regexp.MustCompile(`🔍 Scanning (\S+) with Trivy`)
+	mergedImageLine    = regexp.MustCompile(`✅ Merged (\S+): (\d+) vulnerabilities`)
+	failedImageLine    = regexp.MustCompile(`❌ Failed to scan (\S+): `)
+	skippedImageLine   = regexp.MustCompile(`skipping (\S+)$`)
+	unchangedImageLine = regexp.MustCompile(`♻️  (\S+) is unchanged since its last scan`)
+	// imageCountLine announces the images to scan, counted by the scheduler unless
+	// it couldn't list them
+	imageCountLine = regexp.MustCompile(`^Scanning (\d+) images\.\.\.$`)
+)
+
+// scanProgressWriter follows the scan step's output to update per-image statuses
+// and log the step's progress as images finish
+type scanProgressWriter struct {
+	j        *ScanJob
+	buf      []byte
+	progress ScanProgress
+	finished map[string]bool
+}
+
+func newScanProgressWriter(j *ScanJob) *scanProgressWriter {
+	p := &scanProgressWriter{j: j, progress: ScanProgress{Total: len(j.Images)}, finished: make(map[string]bool)}
+	jobProgress.Store(jobProgressKey{j.RunID, j.Variant}, p.progress)
+	activity.SetProgress(j.Variant, p.progress)
+	return p
+}
+
+func (p *scanProgressWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.line(string(p.buf[:i]))
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *scanProgressWriter) line(line string) {
+	variant := p.j.Variant
+	if m := imageCountLine.FindStringSubmatch(line); m != nil && p.progress.Total == 0 {
+		p.progress.Total, _ = strconv.Atoi(m[1])
+		return
+	}
+	if m := scanningImageLine.FindStringSubmatch(line); m != nil {
+		activity.SetImageStatus(variant, m[1], imageScanning, nil)
+		return
+	}
+	// Images can be scanned concurrently, so the result lines name their image
+	if m := mergedImageLine.FindStringSubmatch(line); m != nil {
+		n, _ := strconv.Atoi(m[2])
+		activity.SetImageStatus(variant, m[1], imageScanned, &n)
+		p.finish(m[1], imageScanned)
+		return
+	}
+	if m := failedImageLine.FindStringSubmatch(line); m != nil {
+		activity.SetImageStatus(variant, m[1], imageFailed, nil)
+		p.finish(m[1], imageFailed)
+		return
+	}
+	if m := skippedImageLine.FindStringSubmatch(line); m != nil {
+		activity.SetImageStatus(variant, m[1], imageSkipped, nil)
+		p.finish(m[1], imageSkipped)
+		return
+	}
+	if m := unchangedImageLine.FindStringSubmatch(line); m != nil {
+		activity.SetImageStatus(variant, m[1], imageUnchanged, nil)
+		p.finish(m[1], imageUnchanged)
+	}
+}
+
+// finish counts an image that reached a final status and logs the step's progress
+func (p *scanProgressWriter) finish(image, status string) {
+	if p.finished[image] {
+		return
+	}
+	p.finished[image] = true
+	p.progress.count(status)
+	jobProgress.Store(jobProgressKey{p.j.RunID, p.j.Variant}, p.progress)
+	activity.SetProgress(p.j.Variant, p.progress)
+	p.j.Log.Printf("[%s] 📊 %s", p.j.Variant, p.progress)
+}
+
+// Close stops reporting the step's progress to the job queue
+func (p *scanProgressWriter) Close() {
+	jobProgress.Delete(jobProgressKey{p.j.RunID, p.j.Variant})
+}
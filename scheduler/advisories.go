@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Vendor advisory statuses attached to findings
+const (
+	advisoryFixed          = "fixed"
+	advisoryNotAffected    = "not-affected"
+	advisoryUnacknowledged = "unacknowledged"
+)
+
+// secdbFeed is the security database format published by Chainguard, Wolfi and Alpine
+// (e.g. https://packages.cgr.dev/chainguard/security.json). Each package lists the
+// CVEs fixed per version; CVEs listed under version "0" do not affect the package.
+type secdbFeed struct {
+	Packages []struct {
+		Pkg struct {
+			Name     string              `json:"name"`
+			Secfixes map[string][]string `json:"secfixes"`
+		} `json:"pkg"`
+	} `json:"packages"`
+}
+
+// AdvisoryFeed indexes a vendor feed by package and CVE
+type AdvisoryFeed struct {
+	Source string
+	// fixes maps package name -> CVE -> fixed version ("0" when not affected)
+	fixes map[string]map[string]string
+}
+
+// VendorAdvisory is the annotation written to each matching finding
+type VendorAdvisory struct {
+	Source       string `json:"Source"`
+	Status       string `json:"Status"`
+	FixedVersion string `json:"FixedVersion,omitempty"`
+}
+
+// Lookup returns the vendor's position on a CVE for a package, if the feed covers the package
+func (f *AdvisoryFeed) Lookup(pkg, cve string) (VendorAdvisory, bool) {
+	cves, ok := f.fixes[pkg]
+	if !ok {
+		return VendorAdvisory{}, false
+	}
+
+	adv := VendorAdvisory{Source: f.Source, Status: advisoryUnacknowledged}
+	if version, ok := cves[cve]; ok {
+		if version == "0" {
+			adv.Status = advisoryNotAffected
+		} else {
+			adv.Status = advisoryFixed
+			adv.FixedVersion = version
+		}
+	}
+	return adv, true
+}
+
+// loadAdvisoryFeed fetches a feed, using a cached copy under /reports/cache while it is fresh
+// and falling back to a stale copy when the feed can't be downloaded
+func loadAdvisoryFeed(feedURL string, ttl time.Duration) (*AdvisoryFeed, error) {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid advisory feed URL %q: %w", feedURL, err)
+	}
+
+	data, err := fetchCached(feedURL, "advisories", ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	var feed secdbFeed
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return nil, fmt.Errorf("failed to parse advisory feed %s: %w", feedURL, err)
+	}
+
+	af := &AdvisoryFeed{Source: u.Host + u.Path, fixes: make(map[string]map[string]string)}
+	for _, p := range feed.Packages {
+		cves := af.fixes[p.Pkg.Name]
+		if cves == nil {
+			cves = make(map[string]string)
+			af.fixes[p.Pkg.Name] = cves
+		}
+		for version, ids := range p.Pkg.Secfixes {
+			for _, id := range ids {
+				cves[id] = version
+			}
+		}
+	}
+	return af, nil
+}
+
+// AdvisorySummary is written to /reports/{variant}/advisories.json after each scan
+type AdvisorySummary struct {
+	Variant     string         `json:"variant"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	Feeds       []string       `json:"feeds"`
+	Statuses    map[string]int `json:"statuses"`
+	Uncovered   int            `json:"uncovered"`
+}
+
+// annotateVendorAdvisories cross-checks a variant's merged findings against the
+// configured vendor feeds and records the vendor's status on each finding
+func annotateVendorAdvisories(variant string, feedURLs []string, ttl time.Duration) (*AdvisorySummary, error) {
+	var feeds []*AdvisoryFeed
+	for _, u := range feedURLs {
+		feed, err := loadAdvisoryFeed(u, ttl)
+		if err != nil {
+			log.Printf("[%s] ⚠️  Skipping advisory feed: %v", variant, err)
+			continue
+		}
+		feeds = append(feeds, feed)
+	}
+	if len(feeds) == 0 {
+		return nil, fmt.Errorf("no advisory feeds could be loaded")
+	}
+
+	summary := &AdvisorySummary{
+		Variant:     variant,
+		GeneratedAt: time.Now().UTC(),
+		Statuses:    map[string]int{advisoryFixed: 0, advisoryNotAffected: 0, advisoryUnacknowledged: 0},
+	}
+	for _, f := range feeds {
+		summary.Feeds = append(summary.Feeds, f.Source)
+	}
+
+	files, err := mergedReportFiles(variant)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		err := rewriteMergedReport(file, func(_, vuln map[string]any) {
+			pkg, cve := stringField(vuln, "PkgName"), stringField(vuln, "VulnerabilityID")
+			for _, feed := range feeds {
+				if adv, ok := feed.Lookup(pkg, cve); ok {
+					vuln["VendorAdvisory"] = adv
+					summary.Statuses[adv.Status]++
+					return
+				}
+			}
+			delete(vuln, "VendorAdvisory")
+			summary.Uncovered++
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(variantDir(variant), "advisories.json"), data, 0o644); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// fetchCached downloads a feed, reusing the copy cached under /reports/cache/{dir} while
+// it is younger than ttl and falling back to a stale copy when the download fails.
+// With OFFLINE the cached copy is used whatever its age.
+func fetchCached(feedURL, dir string, ttl time.Duration) ([]byte, error) {
+	ext := ".json"
+	if u, err := url.Parse(feedURL); err == nil && path.Ext(u.Path) != "" {
+		ext = path.Ext(u.Path)
+	}
+	sum := sha256.Sum256([]byte(feedURL))
+	cacheFile := filepath.Join(reportsPath, "cache", dir, hex.EncodeToString(sum[:8])+ext)
+
+	data, err := readCache(cacheFile, ttl)
+	if err == nil {
+		return data, nil
+	}
+	if offlineMode {
+		// A copy of any age beats none; feeds are refreshed by copying them into the cache
+		data, err := os.ReadFile(cacheFile)
+		if err != nil {
+			return nil, fmt.Errorf("offline and %s is not cached in %s", feedURL, cacheFile)
+		}
+		return data, nil
+	}
+	data, err = download(feedURL)
+	if err != nil {
+		stale, staleErr := os.ReadFile(cacheFile)
+		if staleErr != nil {
+			return nil, err
+		}
+		log.Printf("⚠️  Using stale cached copy of %s: %v", feedURL, err)
+		return stale, nil
+	}
+	if err := writeCache(cacheFile, data); err != nil {
+		log.Printf("⚠️  Could not cache %s: %v", feedURL, err)
+	}
+	return data, nil
+}
+
+// readCache returns the cached file contents if it is younger than ttl
+func readCache(path string, ttl time.Duration) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(info.ModTime()) > ttl {
+		return nil, fmt.Errorf("cache expired")
+	}
+	return os.ReadFile(path)
+}
+
+func writeCache(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+var httpClient = &http.Client{Timeout: 2 * time.Minute}
+
+// download fetches a URL, treating non-2xx responses as errors
+func download(u string) ([]byte, error) {
+	resp, err := httpClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
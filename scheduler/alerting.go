@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Values for AlertConfig.Provider
+const (
+	alertPagerDuty = "pagerduty"
+	alertOpsgenie  = "opsgenie"
+)
+
+// Default alerting API endpoints, overridable with ALERT_API_URL (e.g. Opsgenie's EU instance)
+const (
+	defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	defaultOpsgenieURL  = "https://api.opsgenie.com"
+)
+
+// Dedup keys of the alerts the scheduler raises and resolves
+const (
+	alertCycleFailures = "vuln-demo-cycle-failures"
+	alertStaleScans    = "vuln-demo-stale-scans"
+)
+
+// stalenessCheckInterval is how often the daemon checks the age of the last successful cycle
+const stalenessCheckInterval = 5 * time.Minute
+
+// AlertConfig pages on-call through PagerDuty or Opsgenie when the pipeline is unhealthy
+type AlertConfig struct {
+	// Provider is "pagerduty" or "opsgenie"; alerting is disabled when empty
+	Provider string
+	// Key is the PagerDuty Events v2 routing key or the Opsgenie API key
+	Key    string
+	APIURL string
+	// AfterFailures pages when this many cycles in a row failed or partially failed
+	AfterFailures int
+	// StaleAfter pages when the last fully successful cycle is older than this (0 disables)
+	StaleAfter time.Duration
+}
+
+// Enabled reports whether alerts are sent
+func (c AlertConfig) Enabled() bool {
+	return c.Provider != ""
+}
+
+// alert is raised, or resolved, under a stable dedup key
+type alert struct {
+	Key     string
+	Summary string
+	Details map[string]any
+}
+
+// recordCycleOutcome counts consecutive failed cycles, pages once the count reaches
+// ALERT_AFTER_FAILURES and resolves the alerts after a successful cycle
+func recordCycleOutcome(cfg AlertConfig, cycle *CycleResult) {
+	var failures int
+	err := updateState(func(state *SchedulerState) {
+		if cycle.Success {
+			state.ConsecutiveFailures = 0
+		} else {
+			state.ConsecutiveFailures++
+		}
+		failures = state.ConsecutiveFailures
+	})
+	if err != nil {
+		log.Printf("⚠️  Could not persist the consecutive failure count: %v", err)
+	}
+	if !cfg.Enabled() {
+		return
+	}
+
+	if cycle.Success {
+		resolveAlert(cfg, alertCycleFailures)
+		resolveAlert(cfg, alertStaleScans)
+		return
+	}
+	if failures >= cfg.AfterFailures {
+		triggerAlert(cfg, alert{
+			Key:     alertCycleFailures,
+			Summary: fmt.Sprintf("Vulnerability scan cycles failing: %d in a row", failures),
+			Details: map[string]any{
+				"consecutive_failures": failures,
+				"last_run_id":          cycle.RunID,
+				"last_status":          cycle.Status,
+				"failed_variants":      cycle.Failed(),
+			},
+		})
+	}
+}
+
+// checkStaleness pages when the last fully successful cycle is older than ALERT_STALE_AFTER
+func checkStaleness(cfg AlertConfig, since time.Time) {
+	if !cfg.Enabled() || cfg.StaleAfter <= 0 {
+		return
+	}
+	state, err := loadState()
+	if err != nil {
+		log.Printf("⚠️  Could not check the age of the last successful scan: %v", err)
+		return
+	}
+	// Without a successful cycle yet, the window starts when the scheduler did
+	last := state.LastSuccessfulRun
+	if last.IsZero() {
+		last = since
+	}
+	age := time.Since(last)
+	if age < cfg.StaleAfter {
+		return
+	}
+	details := map[string]any{"stale_after": cfg.StaleAfter.String(), "age": age.Round(time.Minute).String()}
+	if !state.LastSuccessfulRun.IsZero() {
+		details["last_successful_run"] = state.LastSuccessfulRun.Format(time.RFC3339)
+	}
+	triggerAlert(cfg, alert{
+		Key:     alertStaleScans,
+		Summary: fmt.Sprintf("No successful vulnerability scan for %s", age.Round(time.Minute)),
+		Details: details,
+	})
+}
+
+// WatchStaleness checks the age of the last successful cycle until the process exits
+func (s *Scheduler) WatchStaleness() {
+	started := time.Now()
+	ticker := time.NewTicker(stalenessCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		// Paused scheduling and standby replicas are expected not to scan
+		if s.Paused() || !s.elector.IsLeader() {
+			continue
+		}
+		checkStaleness(s.Config().Alerts, started)
+	}
+}
+
+// triggerAlert raises an alert unless it is already open
+func triggerAlert(cfg AlertConfig, a alert) {
+	if alertOpen(a.Key) {
+		return
+	}
+	if err := sendAlert(cfg, a, false); err != nil {
+		log.Printf("⚠️  Failed to raise %s alert: %v", cfg.Provider, err)
+		return
+	}
+	setAlertOpen(a.Key, true)
+	log.Printf("📟 Raised %s alert: %s", cfg.Provider, a.Summary)
+}
+
+// resolveAlert resolves an open alert
+func resolveAlert(cfg AlertConfig, key string) {
+	if !alertOpen(key) {
+		return
+	}
+	if err := sendAlert(cfg, alert{Key: key}, true); err != nil {
+		log.Printf("⚠️  Failed to resolve %s alert %s: %v", cfg.Provider, key, err)
+		return
+	}
+	setAlertOpen(key, false)
+	log.Printf("📟 Resolved %s alert %s", cfg.Provider, key)
+}
+
+func alertOpen(key string) bool {
+	state, err := loadState()
+	return err == nil && state.OpenAlerts[key] != nil
+}
+
+func setAlertOpen(key string, open bool) {
+	err := updateState(func(state *SchedulerState) {
+		if !open {
+			delete(state.OpenAlerts, key)
+			return
+		}
+		if state.OpenAlerts == nil {
+			state.OpenAlerts = make(map[string]*time.Time)
+		}
+		now := time.Now().UTC()
+		state.OpenAlerts[key] = &now
+	})
+	if err != nil {
+		log.Printf("⚠️  Could not persist alert state: %v", err)
+	}
+}
+
+// sendAlert triggers or resolves an alert through the configured provider
+func sendAlert(cfg AlertConfig, a alert, resolve bool) error {
+	switch cfg.Provider {
+	case alertPagerDuty:
+		action := "trigger"
+		if resolve {
+			action = "resolve"
+		}
+		event := map[string]any{"routing_key": cfg.Key, "event_action": action, "dedup_key": a.Key}
+		if !resolve {
+			event["payload"] = map[string]any{
+				"summary":        a.Summary,
+				"source":         "vuln-demo-scheduler",
+				"severity":       "error",
+				"custom_details": a.Details,
+			}
+		}
+		return postAlert(cfg, cfg.APIURL, event)
+	case alertOpsgenie:
+		base := strings.TrimSuffix(cfg.APIURL, "/") + "/v2/alerts"
+		if resolve {
+			return postAlert(cfg, base+"/"+url.PathEscape(a.Key)+"/close?identifierType=alias", map[string]any{"source": "vuln-demo-scheduler"})
+		}
+		details := make(map[string]string, len(a.Details))
+		for k, v := range a.Details {
+			details[k] = fmt.Sprint(v)
+		}
+		return postAlert(cfg, base, map[string]any{
+			"message":  a.Summary,
+			"alias":    a.Key,
+			"source":   "vuln-demo-scheduler",
+			"priority": "P2",
+			"details":  details,
+		})
+	}
+	return fmt.Errorf("unknown alert provider %q", cfg.Provider)
+}
+
+func postAlert(cfg AlertConfig, u string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Provider == alertOpsgenie {
+		req.Header.Set("Authorization", "GenieKey "+cfg.Key)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+const defaultAPIAddr = ":8080"
+
+// startAPIServer serves the scheduler HTTP API in the background, over TLS when the
+// API has a certificate
+func startAPIServer(addr string, mux *http.ServeMux, auth APIAuthConfig) error {
+	if auth.TLSCert == "" {
+		go func() {
+			log.Printf("HTTP API listening on %s", addr)
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				log.Fatalf("HTTP API server failed: %v", err)
+			}
+		}()
+		return nil
+	}
+
+	tlsConfig, err := auth.tlsConfig()
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: addr, Handler: mux, TLSConfig: tlsConfig}
+	go func() {
+		log.Printf("HTTPS API listening on %s", addr)
+		if err := srv.ListenAndServeTLS(auth.TLSCert, auth.TLSKey); err != nil {
+			log.Fatalf("HTTP API server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("⚠️  Failed to write API response: %v", err)
+	}
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxAPICacheEntries bounds the cached responses: every query string is an entry of
+// its own, so a client varying them would otherwise grow the cache without end
+const maxAPICacheEntries = 1000
+
+// apiCache holds responses of the read endpoints; it is emptied whenever a scan
+// publishes new results
+var apiCache = newResponseCache(0)
+
+type cachedResponse struct {
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// responseCache is an in-memory TTL cache for GET responses, keyed by request URI
+type responseCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedResponse
+	// generation changes on every invalidation so responses computed before it aren't stored
+	generation int
+	// swept is when expired entries were last dropped
+	swept time.Time
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{ttl: ttl, entries: make(map[string]cachedResponse)}
+}
+
+// SetTTL changes how long responses are kept; zero disables caching
+func (c *responseCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	c.entries = make(map[string]cachedResponse)
+	c.generation++
+}
+
+// Invalidate drops every cached response
+func (c *responseCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cachedResponse)
+	c.generation++
+}
+
+// Wrap serves successful GET responses of h from the cache while they are fresh
+func (c *responseCache) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		ttl, generation := c.ttl, c.generation
+		entry, ok := c.entries[r.URL.RequestURI()]
+		c.mu.Unlock()
+
+		if ttl <= 0 || r.Method != http.MethodGet {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if ok && time.Now().Before(entry.expires) {
+			for k, v := range entry.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("X-Cache", "HIT")
+			w.WriteHeader(http.StatusOK)
+			w.Write(entry.body)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		w.Header().Set("X-Cache", "MISS")
+		h.ServeHTTP(rec, r)
+		if rec.status == http.StatusOK {
+			c.mu.Lock()
+			c.sweep(ttl)
+			if c.generation == generation && len(c.entries) < maxAPICacheEntries {
+				c.entries[r.URL.RequestURI()] = cachedResponse{
+					header:  w.Header().Clone(),
+					body:    rec.body.Bytes(),
+					expires: time.Now().Add(ttl),
+				}
+			}
+			c.mu.Unlock()
+		}
+	})
+}
+
+// sweep drops the expired entries, at most once per TTL unless the cache is full.
+// Callers hold c.mu.
+func (c *responseCache) sweep(ttl time.Duration) {
+	now := time.Now()
+	if now.Sub(c.swept) < ttl && len(c.entries) < maxAPICacheEntries {
+		return
+	}
+	c.swept = now
+	for uri, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, uri)
+		}
+	}
+}
+
+// recordingWriter passes a response through while keeping a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
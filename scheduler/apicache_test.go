@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheBoundsEntries(t *testing.T) {
+	c := newResponseCache(time.Hour)
+	h := c.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.RawQuery)
+	}))
+	get := func(uri string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, uri, nil))
+		return w
+	}
+
+	for i := 0; i < maxAPICacheEntries+50; i++ {
+		get(fmt.Sprintf("/summary?n=%d", i))
+	}
+	if got := len(c.entries); got != maxAPICacheEntries {
+		t.Errorf("cache holds %d entries, want at most %d", got, maxAPICacheEntries)
+	}
+	if w := get("/summary?n=1"); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "n=1" {
+		t.Errorf("cached response: X-Cache %q, body %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+}
+
+func TestResponseCacheEvictsExpiredEntries(t *testing.T) {
+	c := newResponseCache(10 * time.Millisecond)
+	h := c.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 20; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, fmt.Sprintf("/findings/x?page=%d", i), nil))
+	}
+	time.Sleep(20 * time.Millisecond)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/findings/x?page=new", nil))
+	if got := len(c.entries); got != 1 {
+		t.Errorf("cache holds %d entries after the others expired, want 1", got)
+	}
+}
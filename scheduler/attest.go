@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultPredicateType identifies the scheduler's merged scan reports as in-toto
+// predicates
+const defaultPredicateType = "https://github.com/vuln-demo/scheduler/scan-report/v1"
+
+// attestationsFile records the attestations of a variant's images
+const attestationsFile = "attestations.json"
+
+// AttestationConfig controls the attest step, which attaches each image's scan report
+// to the image in the registry as a signed in-toto attestation
+type AttestationConfig struct {
+	Enabled bool
+	// Key is the cosign signing key: a key file or a KMS URI like awskms:///alias/scans.
+	// Without one cosign signs keyless, with a Sigstore identity token.
+	Key string
+	// PredicateType is the in-toto predicate type consumers verify against
+	PredicateType string
+}
+
+// Attestation records an image's last attestation
+type Attestation struct {
+	Subject       string    `json:"subject"`
+	PredicateType string    `json:"predicate_type"`
+	RunID         string    `json:"run_id"`
+	AttestedAt    time.Time `json:"attested_at"`
+}
+
+// attestStep signs each freshly scanned image's merged report with cosign and pushes
+// it to the registry as an attestation of the image (ATTEST_RESULTS)
+type attestStep struct{}
+
+func (attestStep) Title() string            { return "Attesting scan results with cosign" }
+func (attestStep) Enabled(cfg *Config) bool { return cfg.Attestation.Enabled }
+func (attestStep) Required() bool           { return false }
+
+func (attestStep) Plan(j *ScanJob) {
+	signer := "keyless"
+	if j.Config.Attestation.Key != "" {
+		signer = "key " + j.Config.Attestation.Key
+	}
+	j.Log.Printf("[%s] 🧪 Would attest the scan reports of %d image(s) with cosign (%s, type %s)",
+		j.Variant, len(j.Images), signer, j.Config.Attestation.PredicateType)
+}
+
+func (attestStep) Run(j *ScanJob) error {
+	images, err := j.scannedImages()
+	if err != nil {
+		return err
+	}
+	authEnv, cleanupAuth, err := j.registryAuthEnv()
+	if err != nil {
+		return fmt.Errorf("registry credentials for %s: %w", j.Variant, err)
+	}
+	defer cleanupAuth()
+
+	attestations, err := readAttestations(j.Variant)
+	if err != nil {
+		j.Log.Printf("[%s] ⚠️  %v", j.Variant, err)
+		attestations = make(map[string]Attestation)
+	}
+	cfg := j.Config.Attestation
+	var failed []string
+	for _, image := range images {
+		subject := attestationSubject(image, append(authEnv, j.Env...))
+		// Images scanned per platform get an attestation of each platform's report
+		reports, err := imageReportFiles(j.Variant, image)
+		if err != nil {
+			return err
+		}
+		var attestErr error
+		for _, report := range reports {
+			args := []string{"attest", "--yes", "--type", cfg.PredicateType, "--predicate", report}
+			if cfg.Key != "" {
+				args = append(args, "--key", cfg.Key)
+			}
+			cmd := exec.Command("cosign", append(args, subject)...)
+			cmd.Env = j.commandEnv(authEnv...)
+			step := "attest-" + strings.TrimSuffix(filepath.Base(report), "_scan.json")
+			if attestErr = j.runLogged(step, cmd); attestErr != nil {
+				break
+			}
+		}
+		if attestErr != nil {
+			j.Log.Printf("[%s] ⚠️  Could not attest %s: %v", j.Variant, image, attestErr)
+			failed = append(failed, image)
+			continue
+		}
+		attestations[image] = Attestation{Subject: subject, PredicateType: cfg.PredicateType, RunID: j.RunID, AttestedAt: time.Now().UTC()}
+	}
+
+	if err := writeJSONFile(filepath.Join(variantDir(j.Variant), attestationsFile), attestations); err != nil {
+		j.Log.Printf("[%s] ⚠️  Could not record the attestations: %v", j.Variant, err)
+	}
+	j.Log.Printf("[%s] ✅ Attested the scan reports of %d image(s)", j.Variant, len(images)-len(failed))
+	if len(failed) > 0 {
+		return fmt.Errorf("attestation failed for %d image(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// attestationSubject pins an image to the digest its tag points to in the registry,
+// so the attestation can't follow the tag to a later push. Images whose digest can't
+// be resolved are left to cosign, which resolves the tag itself.
+func attestationSubject(image string, env []string) string {
+	if digest := registryDigest(image, env); digest != "" {
+		return imageRepository(image) + "@" + digest
+	}
+	return image
+}
+
+// imageRepository strips the tag or digest from an image reference, keeping a
+// registry port: registry:5000/app:1.2 becomes registry:5000/app
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// readAttestations returns the last attestation of each of a variant's images
+func readAttestations(variant string) (map[string]Attestation, error) {
+	attestations := make(map[string]Attestation)
+	data, err := os.ReadFile(filepath.Join(variantDir(variant), attestationsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return attestations, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &attestations); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", attestationsFile, err)
+	}
+	return attestations, nil
+}
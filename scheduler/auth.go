@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// API roles: read reaches the read endpoints, operator also the ones that change
+// what the scheduler does (pause, resume, sandbox scans, triggered scans)
+const (
+	roleRead     = "read"
+	roleOperator = "operator"
+)
+
+// APIAuthConfig protects the HTTP and gRPC APIs with bearer tokens and/or client
+// certificates. The APIs stay open without any token or client CA.
+type APIAuthConfig struct {
+	ReadTokens     []string
+	OperatorTokens []string
+	// AnonymousRead leaves the read endpoints open (e.g. for badges embedded in READMEs),
+	// protecting only the operator ones
+	AnonymousRead bool
+	// TLSCert and TLSKey serve the HTTP API over TLS, which client certificates need
+	TLSCert string
+	TLSKey  string
+	// ClientCA verifies client certificates; verified clients get read access, and
+	// operator access when their common name is in OperatorSubjects
+	ClientCA         string
+	OperatorSubjects []string
+}
+
+// apiAuthConfigFromEnv reads the API_* authentication settings
+func apiAuthConfigFromEnv() APIAuthConfig {
+	return APIAuthConfig{
+		ReadTokens:       envList("API_READ_TOKENS"),
+		OperatorTokens:   envList("API_OPERATOR_TOKENS"),
+		AnonymousRead:    envBool("API_ANONYMOUS_READ"),
+		TLSCert:          envString("API_TLS_CERT", ""),
+		TLSKey:           envString("API_TLS_KEY", ""),
+		ClientCA:         envString("API_CLIENT_CA", ""),
+		OperatorSubjects: envList("API_OPERATOR_SUBJECTS"),
+	}
+}
+
+// Enabled reports whether requests have to authenticate
+func (ac APIAuthConfig) Enabled() bool {
+	return len(ac.ReadTokens) > 0 || len(ac.OperatorTokens) > 0 || ac.ClientCA != ""
+}
+
+// Validate reports settings that can't work together
+func (ac APIAuthConfig) Validate() []error {
+	var errs []error
+	if (ac.TLSCert == "") != (ac.TLSKey == "") {
+		errs = append(errs, errors.New("API_TLS_CERT and API_TLS_KEY must be set together"))
+	}
+	if ac.ClientCA != "" && ac.TLSCert == "" {
+		errs = append(errs, errors.New("API_CLIENT_CA needs API_TLS_CERT and API_TLS_KEY: client certificates need TLS"))
+	}
+	if len(ac.OperatorSubjects) > 0 && ac.ClientCA == "" {
+		errs = append(errs, errors.New("API_OPERATOR_SUBJECTS needs an API_CLIENT_CA"))
+	}
+	if ac.AnonymousRead && len(ac.OperatorTokens) == 0 && len(ac.OperatorSubjects) == 0 {
+		errs = append(errs, errors.New("API_ANONYMOUS_READ needs API_OPERATOR_TOKENS or API_OPERATOR_SUBJECTS to protect the operator endpoints"))
+	}
+	return errs
+}
+
+// tlsConfig returns the TLS settings of a listener, asking for (but not requiring)
+// client certificates when a client CA is configured, so probes still reach /readyz
+func (ac APIAuthConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ac.ClientCA == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(ac.ClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API_CLIENT_CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("API_CLIENT_CA %s holds no PEM certificates", ac.ClientCA)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return config, nil
+}
+
+// role returns the role a request authenticates as, or "" without valid credentials
+func (ac APIAuthConfig) role(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if tokenIn(token, ac.OperatorTokens) {
+			return roleOperator
+		}
+		if tokenIn(token, ac.ReadTokens) {
+			return roleRead
+		}
+		return ""
+	}
+	if ac.ClientCA != "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if slices.Contains(ac.OperatorSubjects, r.TLS.VerifiedChains[0][0].Subject.CommonName) {
+			return roleOperator
+		}
+		return roleRead
+	}
+	if ac.AnonymousRead {
+		return roleRead
+	}
+	return ""
+}
+
+// tokenIn compares token to each configured token in constant time
+func tokenIn(token string, tokens []string) bool {
+	found := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+// authorize reports whether a request may use an endpoint needing the given role,
+// and the status code to refuse it with otherwise
+func (ac APIAuthConfig) authorize(r *http.Request, need string) (bool, int) {
+	if !ac.Enabled() {
+		return true, http.StatusOK
+	}
+	switch ac.role(r) {
+	case roleOperator:
+		return true, http.StatusOK
+	case roleRead:
+		if need == roleRead {
+			return true, http.StatusOK
+		}
+		return false, http.StatusForbidden
+	}
+	return false, http.StatusUnauthorized
+}
+
+// Require wraps an API handler so only requests authenticated with the given role
+// (or operator) reach it
+func (ac APIAuthConfig) Require(need string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, status := ac.authorize(r, need)
+		switch {
+		case ok:
+			h.ServeHTTP(w, r)
+		case status == http.StatusForbidden:
+			writeError(w, status, "this endpoint needs operator access")
+		default:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scheduler"`)
+			writeError(w, status, "authentication required")
+		}
+	})
+}
+
+// describeAPIAuth summarizes the configured credentials for the startup log
+func describeAPIAuth(ac APIAuthConfig) string {
+	var parts []string
+	if n := len(ac.OperatorTokens); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d operator token(s)", n))
+	}
+	if n := len(ac.ReadTokens); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d read token(s)", n))
+	}
+	if ac.ClientCA != "" {
+		parts = append(parts, fmt.Sprintf("client certificates, %d operator subject(s)", len(ac.OperatorSubjects)))
+	}
+	if ac.AnonymousRead {
+		parts = append(parts, "anonymous read")
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// goldenBaselineFile is a variant's golden baseline, in its reports directory
+const goldenBaselineFile = ".golden-baseline.json"
+
+// runFindingsFile snapshots a variant's findings next to the step logs of each run,
+// so any run whose logs are kept can be marked as the golden baseline
+const runFindingsFile = "findings.json"
+
+// What a regression against the golden baseline does (GOLDEN_BASELINE_MODE)
+const (
+	baselineAlert = "alert" // log it, publish an event and flag it in the cycle email
+	baselineFail  = "fail"  // also fail the variant
+)
+
+// GoldenBaselineConfig sets how runs are compared against a variant's golden baseline
+type GoldenBaselineConfig struct {
+	// Mode is "alert" or "fail"
+	Mode string
+	// MinSeverity is the least severe finding compared; less severe ones may come and go
+	MinSeverity string
+}
+
+// goldenBaselineConfigFromEnv reads GOLDEN_BASELINE_MODE and GOLDEN_BASELINE_MIN_SEVERITY
+func goldenBaselineConfigFromEnv() GoldenBaselineConfig {
+	return GoldenBaselineConfig{
+		Mode:        envString("GOLDEN_BASELINE_MODE", baselineAlert),
+		MinSeverity: strings.ToUpper(envString("GOLDEN_BASELINE_MIN_SEVERITY", "HIGH")),
+	}
+}
+
+// Validate reports an unknown mode or severity
+func (bc GoldenBaselineConfig) Validate() []error {
+	var errs []error
+	if bc.Mode != baselineAlert && bc.Mode != baselineFail {
+		errs = append(errs, fmt.Errorf("GOLDEN_BASELINE_MODE must be %q or %q, got %q", baselineAlert, baselineFail, bc.Mode))
+	}
+	if severityRank(bc.MinSeverity) == len(severityOrder) {
+		errs = append(errs, fmt.Errorf("GOLDEN_BASELINE_MIN_SEVERITY must be one of %s, got %q", strings.Join(severityOrder, ", "), bc.MinSeverity))
+	}
+	return errs
+}
+
+// BaselineFinding is one finding of a run, as compared against the golden baseline
+type BaselineFinding struct {
+	Image    string `json:"image"`
+	CVE      string `json:"cve"`
+	Package  string `json:"package"`
+	Severity string `json:"severity"`
+}
+
+// RunFindings is the snapshot of a variant's findings after a run, disputed ones left out
+type RunFindings struct {
+	Variant    string            `json:"variant"`
+	RunID      string            `json:"run_id"`
+	RecordedAt time.Time         `json:"recorded_at"`
+	Findings   []BaselineFinding `json:"findings"`
+}
+
+// GoldenBaseline is the run a variant's later runs are compared against
+type GoldenBaseline struct {
+	Variant  string    `json:"variant"`
+	RunID    string    `json:"run_id"`
+	MarkedAt time.Time `json:"marked_at"`
+	// MarkedBy is the API client or "cli"
+	MarkedBy   string            `json:"marked_by,omitempty"`
+	Severities map[string]int    `json:"severities"`
+	Findings   []BaselineFinding `json:"findings"`
+}
+
+// SeverityIncrease is a severity with more findings than in the golden baseline
+type SeverityIncrease struct {
+	Severity string `json:"severity"`
+	Count    int    `json:"count"`
+	Baseline int    `json:"baseline"`
+}
+
+// BaselineRegression is how a run's findings got worse than the golden baseline's
+type BaselineRegression struct {
+	BaselineRunID string `json:"baseline_run_id"`
+	// NewFindings are findings whose CVE and package the baseline didn't have
+	NewFindings []BaselineFinding  `json:"new_findings,omitempty"`
+	Increases   []SeverityIncrease `json:"severity_increases,omitempty"`
+}
+
+func (r *BaselineRegression) String() string {
+	var parts []string
+	if n := len(r.NewFindings); n > 0 {
+		var cves []string
+		for _, f := range r.NewFindings {
+			if !slices.Contains(cves, f.CVE) {
+				cves = append(cves, f.CVE)
+			}
+		}
+		if len(cves) > 5 {
+			cves = append(cves[:5], "...")
+		}
+		parts = append(parts, fmt.Sprintf("%d new finding(s) (%s)", n, strings.Join(cves, ", ")))
+	}
+	for _, inc := range r.Increases {
+		parts = append(parts, fmt.Sprintf("%s %d (baseline %d)", inc.Severity, inc.Count, inc.Baseline))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// baselineFindings converts a variant's findings for the snapshot, leaving out disputed ones
+func baselineFindings(findings []Finding) []BaselineFinding {
+	out := make([]BaselineFinding, 0, len(findings))
+	for _, f := range findings {
+		if f.Disputed {
+			continue
+		}
+		out = append(out, BaselineFinding{Image: f.Image, CVE: f.CVE, Package: f.Package, Severity: f.Severity})
+	}
+	return out
+}
+
+// recordRunFindings snapshots the findings of a variant's run in the run's log directory
+func recordRunFindings(dir, variant, runID string, findings []Finding) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	snapshot := RunFindings{Variant: variant, RunID: runID, RecordedAt: time.Now().UTC(), Findings: baselineFindings(findings)}
+	return writeJSONFile(filepath.Join(dir, runFindingsFile), snapshot)
+}
+
+// readRunFindings returns the findings snapshot of a variant's run. Without a run
+// ID, the latest run with a snapshot is read.
+func readRunFindings(variant, runID string) (*RunFindings, error) {
+	if runID == "" {
+		entries, err := os.ReadDir(filepath.Join(reportsPath, "logs", variant))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		// Run IDs start with their UTC start time, so they sort chronologically
+		for i := len(entries) - 1; i >= 0; i-- {
+			if _, err := os.Stat(filepath.Join(runLogDir(variant, entries[i].Name()), runFindingsFile)); err == nil {
+				runID = entries[i].Name()
+				break
+			}
+		}
+		if runID == "" {
+			return nil, fmt.Errorf("no completed run of %s to mark; scan it first", variant)
+		}
+	}
+	if !runIDDir.MatchString(runID) {
+		return nil, fmt.Errorf("invalid run ID %q", runID)
+	}
+	data, err := os.ReadFile(filepath.Join(runLogDir(variant, runID), runFindingsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no findings recorded for run %s of %s: it failed, predates golden baselines or was pruned", runID, variant)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshot RunFindings
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse the findings of run %s: %w", runID, err)
+	}
+	return &snapshot, nil
+}
+
+// readGoldenBaseline returns a variant's golden baseline, or nil when none is marked
+func readGoldenBaseline(variant string) (*GoldenBaseline, error) {
+	data, err := os.ReadFile(filepath.Join(variantDir(variant), goldenBaselineFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var baseline GoldenBaseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", goldenBaselineFile, err)
+	}
+	return &baseline, nil
+}
+
+// markGoldenBaseline makes a run, or the latest one, the golden baseline of a variant
+func markGoldenBaseline(variant, runID, by string) (*GoldenBaseline, error) {
+	snapshot, err := readRunFindings(variant, runID)
+	if err != nil {
+		return nil, err
+	}
+	baseline := &GoldenBaseline{
+		Variant:    variant,
+		RunID:      snapshot.RunID,
+		MarkedAt:   time.Now().UTC(),
+		MarkedBy:   by,
+		Severities: make(map[string]int),
+		Findings:   snapshot.Findings,
+	}
+	for _, f := range snapshot.Findings {
+		baseline.Severities[f.Severity]++
+	}
+	if err := os.MkdirAll(variantDir(variant), 0o755); err != nil {
+		return nil, err
+	}
+	if err := writeJSONFile(filepath.Join(variantDir(variant), goldenBaselineFile), baseline); err != nil {
+		return nil, err
+	}
+	return baseline, nil
+}
+
+// clearGoldenBaseline stops comparing a variant's runs against a golden baseline,
+// returning false when none was marked
+func clearGoldenBaseline(variant string) (bool, error) {
+	err := os.Remove(filepath.Join(variantDir(variant), goldenBaselineFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// compareToBaseline returns how findings regressed against the baseline, or nil.
+// Findings are matched by CVE and package, so renaming or retagging an image
+// doesn't make its findings new; more findings of a known CVE show as an increase.
+func (bc GoldenBaselineConfig) compareToBaseline(baseline *GoldenBaseline, findings []BaselineFinding) *BaselineRegression {
+	compared := func(severity string) bool { return severityRank(severity) <= severityRank(bc.MinSeverity) }
+	known := make(map[[2]string]bool)
+	for _, f := range baseline.Findings {
+		known[[2]string{f.CVE, f.Package}] = true
+	}
+
+	r := &BaselineRegression{BaselineRunID: baseline.RunID}
+	counts := make(map[string]int)
+	for _, f := range findings {
+		if !compared(f.Severity) {
+			continue
+		}
+		counts[f.Severity]++
+		if !known[[2]string{f.CVE, f.Package}] {
+			r.NewFindings = append(r.NewFindings, f)
+		}
+	}
+	for _, sev := range severityOrder {
+		if compared(sev) && counts[sev] > baseline.Severities[sev] {
+			r.Increases = append(r.Increases, SeverityIncrease{Severity: sev, Count: counts[sev], Baseline: baseline.Severities[sev]})
+		}
+	}
+	if len(r.NewFindings) == 0 && len(r.Increases) == 0 {
+		return nil
+	}
+	return r
+}
+
+// checkGoldenBaseline snapshots the findings of a variant's completed run and
+// compares them against the variant's golden baseline, if one is marked. In fail
+// mode a regression fails the variant.
+func checkGoldenBaseline(cfg *Config, result *VariantResult, runID, logDir string, logger *log.Logger) {
+	variant := result.Variant
+	findings, err := variantFindings(variant)
+	if err != nil {
+		logger.Printf("⚠️  Could not read the findings of %s for the golden baseline: %v", variant, err)
+		return
+	}
+	if err := recordRunFindings(logDir, variant, runID, findings); err != nil {
+		logger.Printf("⚠️  Could not record the findings of %s: %v", variant, err)
+	}
+
+	baseline, err := readGoldenBaseline(variant)
+	if err != nil {
+		logger.Printf("⚠️  Could not read the golden baseline of %s: %v", variant, err)
+		return
+	}
+	if baseline == nil {
+		return
+	}
+	regression := cfg.GoldenBaseline.compareToBaseline(baseline, baselineFindings(findings))
+	if regression == nil {
+		logger.Printf("[%s] 🏅 No regression against golden baseline run %s", variant, baseline.RunID)
+		return
+	}
+	result.BaselineRegression = regression
+	logger.Printf("[%s] 🚨 Regressed against golden baseline run %s: %s", variant, baseline.RunID, regression)
+	publishEvent(eventBaselineRegressed, runID, variant, regression)
+	activity.Event("[%s] Regressed against golden baseline run %s", variant, baseline.RunID)
+	if cfg.GoldenBaseline.Mode == baselineFail && result.Success {
+		result.Success = false
+		result.Error = fmt.Sprintf("regressed against golden baseline run %s: %s", baseline.RunID, regression)
+	}
+}
+
+// baselineCommand implements `scheduler baseline set|show|clear`
+func baselineCommand(cfg *Config, args []string) int {
+	if len(args) == 0 || !slices.Contains([]string{"set", "show", "clear"}, args[0]) {
+		fmt.Fprintln(os.Stderr, "Usage: scheduler baseline set|show|clear [flags]")
+		return exitUsage
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("baseline "+action, flag.ContinueOnError)
+	var variants variantList
+	fs.Var(&variants, "variant", "variant (repeatable or comma-separated; show defaults to all)")
+	runID := fs.String("run", "", "run to mark (set; default: the variant's latest completed run)")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		return exitUsage
+	}
+	for _, v := range variants {
+		if !slices.Contains(cfg.VariantNames(), v) {
+			fmt.Fprintf(os.Stderr, "❌ Unknown variant %q\n", v)
+			return exitUsage
+		}
+	}
+	if len(variants) == 0 {
+		if action != "show" {
+			fmt.Fprintf(os.Stderr, "❌ baseline %s needs --variant\n", action)
+			return exitUsage
+		}
+		variants = cfg.VariantNames()
+	}
+	if *runID != "" && len(variants) > 1 {
+		fmt.Fprintln(os.Stderr, "❌ --run marks a run of a single variant")
+		return exitUsage
+	}
+
+	switch action {
+	case "set":
+		var marked []*GoldenBaseline
+		for _, v := range variants {
+			baseline, err := markGoldenBaseline(v, *runID, "cli")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+				return exitFailure
+			}
+			marked = append(marked, baseline)
+		}
+		if *format == "json" {
+			if err := writeIndentedJSON(os.Stdout, marked); err != nil {
+				return exitFailure
+			}
+			return exitSuccess
+		}
+		for _, b := range marked {
+			fmt.Printf("📌 Run %s is the golden baseline of %s: %s\n", b.RunID, b.Variant, describeBaselineSeverities(b))
+		}
+	case "clear":
+		for _, v := range variants {
+			cleared, err := clearGoldenBaseline(v)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+				return exitFailure
+			}
+			if cleared {
+				fmt.Printf("%s: golden baseline cleared\n", v)
+			} else {
+				fmt.Printf("%s: no golden baseline\n", v)
+			}
+		}
+	case "show":
+		baselines := make(map[string]*GoldenBaseline)
+		for _, v := range variants {
+			baseline, err := readGoldenBaseline(v)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %s: %v\n", v, err)
+				return exitFailure
+			}
+			baselines[v] = baseline
+		}
+		if *format == "json" {
+			if err := writeIndentedJSON(os.Stdout, baselines); err != nil {
+				return exitFailure
+			}
+			return exitSuccess
+		}
+		writeBaselinesText(os.Stdout, variants, baselines)
+	}
+	return exitSuccess
+}
+
+// describeBaselineSeverities renders a baseline's counts, e.g. "CRITICAL 0, HIGH 2, MEDIUM 14, LOW 30"
+func describeBaselineSeverities(b *GoldenBaseline) string {
+	parts := make([]string, 0, len(severityOrder))
+	for _, sev := range severityOrder {
+		if sev == "UNKNOWN" && b.Severities[sev] == 0 {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s %d", sev, b.Severities[sev]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// writeBaselinesText lists the golden baseline of each variant
+func writeBaselinesText(w io.Writer, variants []string, baselines map[string]*GoldenBaseline) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VARIANT\tRUN\tMARKED\tFINDINGS")
+	sort.Strings(variants)
+	for _, v := range variants {
+		b := baselines[v]
+		if b == nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\n", v)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", v, b.RunID, b.MarkedAt.Format(time.RFC3339), describeBaselineSeverities(b))
+	}
+	tw.Flush()
+}
+
+// BaselineRequest is the optional body of PUT /baseline/{variant}
+type BaselineRequest struct {
+	// RunID is the run to mark; the variant's latest completed run when empty
+	RunID string `json:"run_id,omitempty"`
+}
+
+// baselineHandler serves /baseline/{variant}: GET returns the golden baseline, PUT
+// marks a run as the baseline and DELETE clears it. Changes need the operator role.
+func baselineHandler(s *Scheduler, read, operator func(http.Handler) http.Handler) http.Handler {
+	get := read(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		variant := strings.TrimPrefix(r.URL.Path, "/baseline/")
+		baseline, err := readGoldenBaseline(variant)
+		switch {
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err.Error())
+		case baseline == nil:
+			writeError(w, http.StatusNotFound, "no golden baseline marked")
+		default:
+			writeJSON(w, http.StatusOK, baseline)
+		}
+	}))
+	change := operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		variant := strings.TrimPrefix(r.URL.Path, "/baseline/")
+		if r.Method == http.MethodDelete {
+			cleared, err := clearGoldenBaseline(variant)
+			switch {
+			case err != nil:
+				writeError(w, http.StatusInternalServerError, err.Error())
+			case !cleared:
+				writeError(w, http.StatusNotFound, "no golden baseline marked")
+			default:
+				log.Printf("📌 Golden baseline of %s cleared by %s", variant, clientID(r))
+				w.WriteHeader(http.StatusNoContent)
+			}
+			return
+		}
+
+		// The body is optional: no body marks the latest run
+		var req BaselineRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, scanTriggerMaxBodySize)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		baseline, err := markGoldenBaseline(variant, req.RunID, clientID(r))
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		log.Printf("📌 Run %s marked as the golden baseline of %s by %s", baseline.RunID, variant, clientID(r))
+		activity.Event("[%s] Run %s marked as the golden baseline", variant, baseline.RunID)
+		writeJSON(w, http.StatusOK, baseline)
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.knownVariant(strings.TrimPrefix(r.URL.Path, "/baseline/")) {
+			writeError(w, http.StatusNotFound, "unknown variant")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			get.ServeHTTP(w, r)
+		case http.MethodPut, http.MethodDelete:
+			change.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			writeError(w, http.StatusMethodNotAllowed, "use GET, PUT or DELETE")
+		}
+	})
+}
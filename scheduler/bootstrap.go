@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+const defaultBootstrapRegistry = "cgr.dev/chainguard"
+
+// imageLine matches the image of a compose service or of a Kubernetes container,
+// including the "- image:" form of container lists
+var imageLine = regexp.MustCompile(`^\s*(?:-\s+)?image:\s*(.+)$`)
+
+// buildLine matches the build section of a compose service, whose image is built locally
+var buildLine = regexp.MustCompile(`^\s*build:`)
+
+// composeVar matches ${VAR}, ${VAR:-default} and ${VAR-default} in compose files
+var composeVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::?-([^}]*))?\}`)
+
+// chainguardNames maps upstream image names to the Chainguard image replacing them
+var chainguardNames = map[string]string{
+	"golang":          "go",
+	"mongo":           "mongodb",
+	"alpine":          "wolfi-base",
+	"debian":          "wolfi-base",
+	"ubuntu":          "wolfi-base",
+	"openjdk":         "jdk",
+	"eclipse-temurin": "jdk",
+	"amazoncorretto":  "jdk",
+}
+
+// distroTagSuffix matches the base distribution part of tags like 17-alpine or 3.12-slim-bookworm
+var distroTagSuffix = regexp.MustCompile(`-(alpine|slim|bookworm|bullseye|buster|jammy|focal|noble|ubi\d*)(\b.*)?$`)
+
+// ImageMapping pairs an image found in a manifest with its suggested Chainguard counterpart.
+// Images built locally keep their reference: their Dockerfile needs a Chainguard base instead.
+type ImageMapping struct {
+	Image      string `json:"image"`
+	Chainguard string `json:"chainguard"`
+	Built      bool   `json:"built,omitempty"`
+}
+
+// bootstrapCommand implements `scheduler bootstrap`: it extracts the image references
+// of docker-compose files or Kubernetes manifests and prints a config file snippet with
+// a variant of those images and one of their suggested Chainguard counterparts
+func bootstrapCommand(args []string) int {
+	fs := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: scheduler bootstrap [flags] FILE|DIR...")
+		fs.PrintDefaults()
+	}
+	baseline := fs.String("baseline-name", "baseline", "name of the variant with the images as found")
+	chainguard := fs.String("chainguard-name", "chainguard", "name of the variant with the suggested Chainguard images")
+	registry := fs.String("registry", defaultBootstrapRegistry, "registry of the suggested Chainguard images")
+	keepTags := fs.Bool("keep-tags", false, "keep the version of each image instead of suggesting :latest (for registries with versioned tags)")
+	output := fs.String("output", "", "write the config snippet to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	for _, name := range []string{*baseline, *chainguard} {
+		if !variantNamePattern.MatchString(name) {
+			fmt.Fprintf(os.Stderr, "❌ Invalid variant name %q: use lowercase letters, digits and dashes\n", name)
+			return exitUsage
+		}
+	}
+	if *baseline == *chainguard {
+		fmt.Fprintln(os.Stderr, "❌ --baseline-name and --chainguard-name must differ")
+		return exitUsage
+	}
+
+	images, built, err := manifestImages(fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return exitFailure
+	}
+	if len(images) == 0 {
+		fmt.Fprintln(os.Stderr, "❌ No image references found")
+		return exitFailure
+	}
+
+	mappings := make([]ImageMapping, 0, len(images))
+	suggested := make([]string, 0, len(images))
+	seen := make(map[string]bool)
+	for _, image := range images {
+		m := ImageMapping{Image: image, Chainguard: image, Built: built[image]}
+		if !m.Built {
+			m.Chainguard = chainguardCounterpart(*registry, image, *keepTags)
+		}
+		mappings = append(mappings, m)
+		if !seen[m.Chainguard] {
+			seen[m.Chainguard] = true
+			suggested = append(suggested, m.Chainguard)
+		}
+	}
+
+	// The mapping is a suggestion: show it for review next to the generated config
+	fmt.Fprintf(os.Stderr, "Found %d image(s). Review the suggested counterparts before use:\n\n", len(images))
+	tw := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  IMAGE\tSUGGESTED CHAINGUARD IMAGE")
+	for _, m := range mappings {
+		note := ""
+		if m.Built {
+			note = "(built locally: rebuild it FROM a Chainguard image)"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", m.Image, m.Chainguard, note)
+	}
+	tw.Flush()
+	fmt.Fprintln(os.Stderr)
+
+	snippet := struct {
+		Variants []VariantConfig `json:"variants"`
+	}{Variants: []VariantConfig{
+		{Name: *baseline, Images: images},
+		{Name: *chainguard, Images: suggested},
+	}}
+
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return exitFailure
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := writeIndentedJSON(w, snippet); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to write config: %v\n", err)
+		return exitFailure
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "✅ Wrote %s: merge its variants into CONFIG_FILE\n", *output)
+	}
+	return exitSuccess
+}
+
+// manifestImages returns the distinct image references of the given files, and of
+// the .yml/.yaml files under the given directories, in the order they appear, and
+// those built by a compose service
+func manifestImages(paths []string) ([]string, map[string]bool, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ext := filepath.Ext(path); !d.IsDir() && (ext == ".yml" || ext == ".yaml") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var images []string
+	seen := make(map[string]bool)
+	built := make(map[string]bool)
+	for _, file := range files {
+		found, err := fileImages(file, built)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for _, image := range found {
+			if !seen[image] {
+				seen[image] = true
+				images = append(images, image)
+			}
+		}
+	}
+	return images, built, nil
+}
+
+// fileImages reads the image references of one YAML file, adding those of services
+// with a build section to built. Compose variables are resolved from the environment
+// or their default; references that stay templated (e.g. Helm values) are reported
+// and skipped.
+func fileImages(file string, built map[string]bool) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Keys of the same mapping share the line that opens it: parents tracks the open
+	// lines by indentation, so image and build keys of one service find each other
+	type openLine struct{ indent, n int }
+	var parents []openLine
+	images := make(map[int]string)
+	building := make(map[int]bool)
+	var order []string
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		parent := 0
+		if len(parents) > 0 {
+			parent = parents[len(parents)-1].n
+		}
+		parents = append(parents, openLine{indent, n})
+
+		if buildLine.MatchString(line) {
+			building[parent] = true
+			if image, ok := images[parent]; ok {
+				built[image] = true
+			}
+			continue
+		}
+		m := imageLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		value := m[1]
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		value = composeVar.ReplaceAllStringFunc(value, func(ref string) string {
+			sub := composeVar.FindStringSubmatch(ref)
+			if v, ok := os.LookupEnv(sub[1]); ok && v != "" {
+				return v
+			}
+			return sub[2]
+		})
+		if value == "" || strings.ContainsAny(value, "${} ") {
+			fmt.Fprintf(os.Stderr, "⚠️  %s:%d: skipping unresolved image %q\n", file, n, m[1])
+			continue
+		}
+		images[parent] = value
+		if building[parent] {
+			built[value] = true
+		}
+		order = append(order, value)
+	}
+	return order, scanner.Err()
+}
+
+// chainguardCounterpart suggests the Chainguard image replacing image: the image name
+// without its registry and namespace, renamed where Chainguard uses another name
+func chainguardCounterpart(registry, image string, keepTag bool) string {
+	ref, _, _ := strings.Cut(image, "@")
+	name, tag := ref, ""
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, tag = ref[:i], ref[i+1:]
+	}
+	registry = strings.TrimSuffix(registry, "/")
+	if strings.HasPrefix(name, "cgr.dev/") || strings.HasPrefix(name, registry+"/") {
+		return image
+	}
+
+	name = name[strings.LastIndex(name, "/")+1:]
+	if renamed, ok := chainguardNames[name]; ok {
+		name = renamed
+	}
+	tag = distroTagSuffix.ReplaceAllString(tag, "")
+	if !keepTag || tag == "" {
+		tag = "latest"
+	}
+	return registry + "/" + name + ":" + tag
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// skippedImagesFile is written by scan-vulnerabilities.sh for images it did not
+// start before SCAN_DEADLINE
+const skippedImagesFile = ".skipped-images"
+
+// listVariantImages returns the images configured for a variant, or asks the scan
+// script which images belong to it
+func listVariantImages(cfg *Config, variant string) ([]string, error) {
+	if images := cfg.VariantImages(variant); len(images) > 0 {
+		return append([]string(nil), images...), nil
+	}
+	out, err := cfg.scriptCommand(stepScan, "scan-vulnerabilities.sh", "--list", variant).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images for %s: %w", variant, err)
+	}
+	return splitLines(out), nil
+}
+
+// readSkippedImages returns the images the last scan of a variant skipped
+func readSkippedImages(variant string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(variantDir(variant), skippedImagesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(data), nil
+}
+
+func splitLines(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// pendingSkippedImages returns the images skipped by the previous cycle, per variant
+func pendingSkippedImages() map[string][]string {
+	state, err := loadState()
+	if err != nil {
+		log.Printf("⚠️  Could not load skipped images from scheduler state: %v", err)
+		return nil
+	}
+	return state.SkippedImages
+}
+
+// prioritizeVariants moves variants with images left over from the previous cycle to the front
+func prioritizeVariants(variants []string, pending map[string][]string) []string {
+	ordered := append([]string(nil), variants...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return len(pending[ordered[i]]) > 0 && len(pending[ordered[j]]) == 0
+	})
+	return ordered
+}
+
+// recordSkippedImages persists the images each variant skipped so the next cycle scans them first
+func recordSkippedImages(results []VariantResult) {
+	err := updateState(func(state *SchedulerState) {
+		if state.SkippedImages == nil {
+			state.SkippedImages = make(map[string][]string)
+		}
+		for _, r := range results {
+			if len(r.Skipped) > 0 {
+				state.SkippedImages[r.Variant] = r.Skipped
+			} else {
+				delete(state.SkippedImages, r.Variant)
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("⚠️  Could not persist skipped images: %v", err)
+	}
+}
+
+// mergeImageLists returns the images of both lists in order, without duplicates
+func mergeImageLists(first, second []string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, image := range append(append([]string(nil), first...), second...) {
+		if !seen[image] {
+			seen[image] = true
+			merged = append(merged, image)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// passEnvPattern matches an allowlist entry: a variable name, or a prefix ending in *
+var passEnvPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\*?$`)
+
+// baseEnvAllowlist lists the variables of the scheduler's environment every pipeline
+// command and hook inherits: the basics of a process, Python, proxies and CA bundles
+var baseEnvAllowlist = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TMPDIR", "TZ", "LANG", "LANGUAGE", "LC_*", "TERM",
+	"PYTHON*", "VIRTUAL_ENV",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+	"SSL_CERT_FILE", "SSL_CERT_DIR", "REQUESTS_CA_BUNDLE", "XDG_*",
+	"DOCKER_HOST", "DOCKER_CONFIG", "DOCKER_CERT_PATH", "DOCKER_TLS_VERIFY", "CONTAINERD_ADDRESS",
+}
+
+// registryEnvAllowlist lists what registry credential helpers read, e.g. ecr-login
+// and gcloud, for the steps that pull images
+var registryEnvAllowlist = []string{"AWS_*", "GOOGLE_APPLICATION_CREDENTIALS", "CLOUDSDK_*", "AZURE_*"}
+
+// stepEnvAllowlist adds the variables the tools of each step read, e.g. libpq's PG*
+// for the load script. Settings the scheduler passes explicitly, such as the DB_* of
+// the load script, are not inherited.
+var stepEnvAllowlist = map[string][]string{
+	stepScan:      append([]string{"OFFLINE", "TRIVY_*", "GRYPE_*"}, registryEnvAllowlist...),
+	stepSecrets:   append([]string{"TRIVY_*"}, registryEnvAllowlist...),
+	stepMisconfig: {"TRIVY_*", "CHECKOV_*", "BC_*"},
+	stepPublish:   append([]string{"PG*", "TRIVY_*"}, registryEnvAllowlist...),
+	stepAttest:    append([]string{"COSIGN_*", "SIGSTORE_*", "TUF_ROOT"}, registryEnvAllowlist...),
+}
+
+// validatePassEnv reports allowlist entries that are neither a name nor a prefix*
+func validatePassEnv(field string, patterns []string) []error {
+	var errs []error
+	for _, p := range patterns {
+		if !passEnvPattern.MatchString(p) {
+			errs = append(errs, fmt.Errorf("%s has an invalid variable name or prefix %q", field, p))
+		}
+	}
+	return errs
+}
+
+// passEnv returns the allowlist of a step's commands, or of hooks with an empty step:
+// the base list, the step's own, STEP_PASS_ENV and the configured extra entries
+func (c *Config) passEnv(step string, extra []string) []string {
+	patterns := append([]string(nil), baseEnvAllowlist...)
+	patterns = append(patterns, stepEnvAllowlist[step]...)
+	patterns = append(patterns, c.PassEnv...)
+	return append(patterns, extra...)
+}
+
+// inheritedEnv returns the variables of the scheduler's environment the allowlist
+// lets through
+func inheritedEnv(patterns []string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if envAllowed(name, patterns) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+func envAllowed(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == p {
+			return true
+		}
+	}
+	return false
+}
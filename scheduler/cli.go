@@ -0,0 +1,657 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: scheduler [--scripts-path DIR] [--reports-path DIR] <command> [flags]
+
+Commands:
+  serve              Run the scheduler daemon (default when no command is given)
+  scan               Run a single scan cycle and print a JSON summary
+  worker             Run scan jobs enqueued by a scheduler with QUEUE_MODE=postgres
+  report generate    Summarize the latest scan reports per variant
+  report disagreements
+                     Aggregate Trivy/Grype disagreements over time
+  report export      Export the latest findings and comparison as CSV or Excel
+  diff               Compare the latest reports of two variants
+  top                Interactive console of a running scheduler (progress, runs, events)
+  integrity check    Check the database for drift between scans, findings and counts
+  retention prune    Delete results older than the retention period
+  reload             Retry loading the reports quarantined by a failed database load
+  migrate up|status  Apply or list the database schema migrations
+  config validate    Validate the configuration and environment, then exit
+  bootstrap          Generate variant image lists from docker-compose or Kubernetes manifests
+  baseline set|show|clear
+                     Mark, show or clear the golden baseline run of a variant
+
+Run 'scheduler <command> -h' for command flags.
+`
+
+// remoteCommands don't touch the local scripts or reports, so they run without
+// REPORTS_PATH and SCRIPTS_PATH, e.g. `scheduler top` on a workstation
+var remoteCommands = map[string]bool{
+	"top":       true,
+	"bootstrap": true,
+}
+
+// runCommand dispatches CLI arguments to a subcommand and returns the exit code
+func runCommand(args []string) int {
+	args, err := parsePathFlags(args)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Print(usage)
+		return exitSuccess
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n\n%s", err, usage)
+		return exitUsage
+	}
+	cfg, cfgErr := loadConfig()
+
+	cmd := "serve"
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	// Every command except config validate needs a parseable configuration
+	if cfgErr != nil && cmd != "config" {
+		fmt.Fprintf(os.Stderr, "❌ Invalid configuration:\n%v\n", cfgErr)
+		return 2
+	}
+
+	if cfgErr == nil && !remoteCommands[cmd] {
+		cleanup, err := setupPaths(cfg)
+		switch {
+		case err == nil:
+			defer cleanup()
+			setupOffline(cfg)
+		case cmd == "config":
+			// Reported by the environment checks, which --skip-environment skips in CI
+			fmt.Fprintf(os.Stderr, "⚠️  %v\n", err)
+		default:
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return exitFailure
+		}
+	}
+
+	switch cmd {
+	case "serve":
+		return serve(cfg)
+	case "scan", "run":
+		return runOnce(cfg, args)
+	case "worker":
+		return workerCommand(cfg, args)
+	case "report":
+		if len(args) > 0 && args[0] == "generate" {
+			return reportGenerate(cfg, args[1:])
+		}
+		if len(args) > 0 && args[0] == "disagreements" {
+			return reportDisagreements(cfg, args[1:])
+		}
+		if len(args) > 0 && args[0] == "export" {
+			return reportExport(cfg, args[1:])
+		}
+		fmt.Fprintln(os.Stderr, "Usage: scheduler report generate|disagreements|export [flags]")
+		return 2
+	case "diff":
+		return diffCommand(args)
+	case "top":
+		return topCommand(cfg, args)
+	case "integrity":
+		return integrityCommand(cfg, args)
+	case "retention":
+		return retentionCommand(cfg, args)
+	case "reload":
+		return reloadCommand(cfg, args)
+	case "migrate":
+		return migrateCommand(cfg, args)
+	case "bootstrap":
+		return bootstrapCommand(args)
+	case "baseline":
+		return baselineCommand(cfg, args)
+	case "config":
+		if len(args) == 0 || args[0] != "validate" {
+			fmt.Fprintln(os.Stderr, "Usage: scheduler config validate")
+			return 2
+		}
+		return configValidate(cfg, cfgErr, args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", cmd, usage)
+		return 2
+	}
+}
+
+// variantList collects repeated or comma-separated --variant flags
+type variantList []string
+
+func (v *variantList) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *variantList) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			*v = append(*v, name)
+		}
+	}
+	return nil
+}
+
+// runOnce implements `scheduler scan`: a single scan cycle for CI pipelines.
+// Logs and script output go to stderr so stdout only carries the JSON summary.
+// Returns 0 when every variant succeeded, 3 when only some did, 1 when all failed
+// and 2 on usage errors.
+func runOnce(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	var variants variantList
+	fs.Var(&variants, "variant", "variant to scan (repeatable or comma-separated, default: all)")
+	tenant := fs.String("tenant", "", "scan the variants of this tenant, besides any --variant")
+	once := fs.Bool("once", true, "run a single scan cycle and exit")
+	summaryFile := fs.String("summary-file", "", "also write the JSON summary to this file")
+	fs.BoolVar(&cfg.DryRun, "dry-run", cfg.DryRun, "log the commands and images the cycle would run instead of scanning (DRY_RUN)")
+	fs.BoolVar(&cfg.ForceRescan, "force", cfg.ForceRescan, "rescan images even if unchanged since their last scan (FORCE_RESCAN)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if !*once {
+		fmt.Fprintln(os.Stderr, "scan only supports --once; use 'scheduler serve' for daemon mode")
+		return exitUsage
+	}
+	if *tenant != "" {
+		t := cfg.Tenant(*tenant)
+		if t == nil {
+			fmt.Fprintf(os.Stderr, "❌ Unknown tenant %q\n", *tenant)
+			return exitUsage
+		}
+		for _, v := range t.Variants {
+			if !slices.Contains(variants, v) {
+				variants = append(variants, v)
+			}
+		}
+	}
+	if len(variants) == 0 {
+		variants = cfg.VariantNames()
+	}
+
+	log.SetOutput(os.Stderr)
+	stepOutput = os.Stderr
+	setupTracing(cfg.Tracing)
+	setupEvents(cfg.Events)
+	stop := stopStepsOnSignal()
+
+	heartbeatCycleStart(cfg.Heartbeat, cfg.DryRun)
+	done := make(chan *CycleResult, 1)
+	go func() { done <- RunFullScanCycle(cfg, variants, newRunID(time.Now())) }()
+	var summary *CycleResult
+	select {
+	case summary = <-done:
+	case <-stop.Done():
+		log.Println("❌ Scan cycle interrupted")
+		return shutdownExitCode(stop)
+	}
+	if !summary.Success && !summary.DryRun {
+		summary.Diagnostics = collectDiagnostics(cfg, summary.RunID, summary.Variants)
+	}
+	heartbeatCycleEnd(cfg.Heartbeat, summary)
+	pushCycleMetrics(cfg.MetricsPush, summary)
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		log.Printf("❌ Failed to encode summary: %v", err)
+		return exitFailure
+	}
+	fmt.Println(string(data))
+
+	if *summaryFile != "" {
+		if err := os.WriteFile(*summaryFile, append(data, '\n'), 0o644); err != nil {
+			log.Printf("❌ Failed to write summary file: %v", err)
+			return exitFailure
+		}
+	}
+
+	return summary.ExitCode()
+}
+
+// openOutput returns stdout or the named file for command output
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// reportGenerate implements `scheduler report generate`
+func reportGenerate(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("report generate", flag.ContinueOnError)
+	var variants variantList
+	fs.Var(&variants, "variant", "variant to include (repeatable or comma-separated, default: all)")
+	format := fs.String("format", "text", "output format: text, json or markdown")
+	output := fs.String("output", "", "write the report to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(variants) == 0 {
+		variants = cfg.VariantNames()
+	}
+
+	var reports []*VariantReport
+	for _, v := range variants {
+		vr, err := buildVariantReport(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to read %s reports: %v\n", v, err)
+			return 1
+		}
+		reports = append(reports, vr)
+	}
+
+	out, err := openOutput(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	defer out.Close()
+
+	switch *format {
+	case "json":
+		err = writeIndentedJSON(out, reports)
+	case "markdown":
+		err = writeVariantReportsMarkdown(out, reports)
+	case "text":
+		err = writeVariantReportsText(out, reports)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to write report: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// reportExport implements `scheduler report export`: the findings of the latest
+// reports, and optionally a variant comparison, as CSV or an Excel workbook
+func reportExport(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("report export", flag.ContinueOnError)
+	var variants variantList
+	fs.Var(&variants, "variant", "variant to include (repeatable or comma-separated, default: all)")
+	format := fs.String("format", exportCSV, "output format: csv or xlsx")
+	output := fs.String("output", "", "write the export to this file instead of stdout")
+	comparison := fs.Bool("comparison", false, "csv: export the comparison instead of the findings; xlsx: add it as a second sheet")
+	from := fs.String("from", exportComparisonFrom, "variant to compare from")
+	to := fs.String("to", exportComparisonTo, "variant to compare to")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != exportCSV && *format != exportXLSX {
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		return 2
+	}
+	if len(variants) == 0 {
+		variants = cfg.VariantNames()
+	}
+	if !*comparison {
+		*from, *to = "", ""
+	}
+
+	tables, err := exportTables(variants, *from, *to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	out, err := openOutput(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	defer out.Close()
+
+	switch {
+	case *format == exportXLSX:
+		err = writeTablesXLSX(out, tables...)
+	case *comparison:
+		err = writeTableCSV(out, tables[len(tables)-1])
+	default:
+		err = writeTableCSV(out, tables[0])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to write export: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// DisagreementAggregate summarizes scanner disagreements of a variant over a window
+type DisagreementAggregate struct {
+	Variant string `json:"variant"`
+	Cycles  int    `json:"cycles"`
+	DisagreementStats
+	DisagreementPct float64 `json:"disagreement_percent"`
+}
+
+// reportDisagreements implements `scheduler report disagreements`
+func reportDisagreements(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("report disagreements", flag.ContinueOnError)
+	var variants variantList
+	fs.Var(&variants, "variant", "variant to include (repeatable or comma-separated, default: all)")
+	window := fs.String("window", "30d", "look-back window, e.g. 7d or 12h")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(variants) == 0 {
+		variants = cfg.VariantNames()
+	}
+	d, err := parseWindow(*window)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	entries, err := readDisagreementHistory(time.Now().Add(-d))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to read disagreement history: %v\n", err)
+		return 1
+	}
+
+	var aggregates []DisagreementAggregate
+	for _, v := range variants {
+		agg := DisagreementAggregate{Variant: v}
+		for _, e := range entries {
+			if e.Variant != v {
+				continue
+			}
+			agg.Cycles++
+			agg.Agreed += e.Agreed
+			agg.TrivyOnly += e.TrivyOnly
+			agg.GrypeOnly += e.GrypeOnly
+			agg.SeverityMismatch += e.SeverityMismatch
+		}
+		disagreed := agg.TrivyOnly + agg.GrypeOnly + agg.SeverityMismatch
+		if total := disagreed + agg.Agreed; total > 0 {
+			agg.DisagreementPct = float64(disagreed) / float64(total) * 100
+		}
+		aggregates = append(aggregates, agg)
+	}
+
+	switch *format {
+	case "json":
+		err = writeIndentedJSON(os.Stdout, aggregates)
+	case "text":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "VARIANT\tCYCLES\tAGREED\tTRIVY-ONLY\tGRYPE-ONLY\tSEVERITY MISMATCH\tDISAGREEMENT\n")
+		for _, a := range aggregates {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%.1f%%\n",
+				a.Variant, a.Cycles, a.Agreed, a.TrivyOnly, a.GrypeOnly, a.SeverityMismatch, a.DisagreementPct)
+		}
+		err = tw.Flush()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to write report: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// diffCommand implements `scheduler diff`
+func diffCommand(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	from := fs.String("from", "baseline", "variant to compare from")
+	to := fs.String("to", "chainguard", "variant to compare to")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	diff, err := buildDiffReport(*from, *to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to compare %s and %s: %v\n", *from, *to, err)
+		return 1
+	}
+
+	switch *format {
+	case "json":
+		err = writeIndentedJSON(os.Stdout, diff)
+	case "text":
+		err = writeDiffText(os.Stdout, diff)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to write diff: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// configValidate implements `scheduler config validate`: static configuration
+// checks followed by the same environment checks the daemon runs at startup
+func configValidate(cfg *Config, loadErr error, args []string) int {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	skipEnv := fs.Bool("skip-environment", false, "only validate configuration values, skip script/tool/database checks")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	err := loadErr
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Invalid configuration:\n%v\n", err)
+		return 1
+	}
+
+	if cfg.ConfigFile != "" {
+		fmt.Printf("Config file:   %s\n", cfg.ConfigFile)
+	}
+	fmt.Printf("Scan schedule: %s\n", cfg.Schedule)
+	fmt.Printf("Variants:      %s\n", strings.Join(cfg.VariantNames(), ", "))
+
+	if !*skipEnv {
+		fmt.Println()
+		checks := runPreflightChecks(cfg, cfg.QueueMode != queuePostgres)
+		for _, c := range checks {
+			if c.Err != nil {
+				fmt.Printf("❌ %s: %v\n", c.Name, c.Err)
+			} else {
+				fmt.Printf("✅ %s\n", c.Name)
+			}
+		}
+		if preflightError(checks) != nil {
+			fmt.Println()
+			fmt.Println("❌ Environment checks failed")
+			return 1
+		}
+		fmt.Println()
+	}
+
+	fmt.Println("✅ Configuration is valid")
+	return 0
+}
+
+func writeIndentedJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeVariantReportsText(w io.Writer, reports []*VariantReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, vr := range reports {
+		fmt.Fprintf(tw, "%s (%d images, %d vulnerabilities%s)\n", strings.ToUpper(vr.Variant), len(vr.Images), vr.Total, excludedCount(vr))
+		fmt.Fprintln(tw, "  IMAGE\tCRITICAL\tHIGH\tMEDIUM\tLOW\tTOTAL\tFIXABLE")
+		for _, img := range vr.Images {
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t%d\t%d\t%d\t%d\n", img.Label(),
+				img.Severities["CRITICAL"], img.Severities["HIGH"], img.Severities["MEDIUM"], img.Severities["LOW"], img.Total,
+				img.FixAvailability[fixAvailable])
+		}
+		for _, e := range vr.Excluded {
+			fmt.Fprintf(tw, "  %s\texcluded: %s\n", e.Image, describeExclusion(e))
+		}
+		if len(vr.Secrets) > 0 {
+			fmt.Fprintf(tw, "\n  SECRETS (%d)\n", len(vr.Secrets))
+			fmt.Fprintln(tw, "  IMAGE\tSEVERITY\tRULE\tLOCATION")
+			for _, s := range vr.Secrets {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", s.Image, s.Severity, s.RuleID, secretLocation(s))
+			}
+		}
+		if len(vr.Misconfigurations) > 0 {
+			fmt.Fprintf(tw, "\n  MISCONFIGURATIONS (%d)\n", len(vr.Misconfigurations))
+			fmt.Fprintln(tw, "  FILE\tSEVERITY\tCHECK\tTITLE")
+			for _, m := range vr.Misconfigurations {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", configLocation(m), m.Severity, m.CheckID, m.Title)
+			}
+		}
+		if len(vr.LicenseViolations) > 0 {
+			fmt.Fprintf(tw, "\n  LICENSE VIOLATIONS (%d)\n", len(vr.LicenseViolations))
+			fmt.Fprintln(tw, "  IMAGE\tLICENSE\tPACKAGE")
+			for _, l := range vr.LicenseViolations {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\n", l.Image, l.License, licensedItem(l))
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func writeVariantReportsMarkdown(w io.Writer, reports []*VariantReport) error {
+	fmt.Fprintf(w, "# Vulnerability Scan Report\n\nGenerated: %s\n", time.Now().UTC().Format(time.RFC3339))
+	for _, vr := range reports {
+		fmt.Fprintf(w, "\n## %s\n\n%d images, %d vulnerabilities (%d with a fix available)%s\n\n",
+			vr.Variant, len(vr.Images), vr.Total, vr.FixAvailability[fixAvailable], excludedCount(vr))
+		fmt.Fprintln(w, "| Image | Critical | High | Medium | Low | Total | Fixable |")
+		fmt.Fprintln(w, "|-------|----------|------|--------|-----|-------|---------|")
+		for _, img := range vr.Images {
+			fmt.Fprintf(w, "| `%s` | %d | %d | %d | %d | %d | %d |\n", img.Label(),
+				img.Severities["CRITICAL"], img.Severities["HIGH"], img.Severities["MEDIUM"], img.Severities["LOW"], img.Total,
+				img.FixAvailability[fixAvailable])
+		}
+		for _, e := range vr.Excluded {
+			fmt.Fprintf(w, "| `%s` | excluded | | | | | |\n", e.Image)
+		}
+		if len(vr.Excluded) > 0 {
+			fmt.Fprintln(w, "\nExcluded images:")
+			for _, e := range vr.Excluded {
+				fmt.Fprintf(w, "\n- `%s`: %s", e.Image, describeExclusion(e))
+			}
+			fmt.Fprintln(w)
+		}
+		if len(vr.Secrets) > 0 {
+			fmt.Fprintf(w, "\n### Secrets\n\n%d secret(s) found in the images\n\n", len(vr.Secrets))
+			fmt.Fprintln(w, "| Image | Severity | Secret | Location | Match |")
+			fmt.Fprintln(w, "|-------|----------|--------|----------|-------|")
+			for _, s := range vr.Secrets {
+				fmt.Fprintf(w, "| `%s` | %s | %s | `%s` | `%s` |\n", s.Image, s.Severity, s.Title, secretLocation(s),
+					strings.ReplaceAll(s.Match, "|", "\\|"))
+			}
+		}
+		if len(vr.Misconfigurations) > 0 {
+			fmt.Fprintf(w, "\n### Misconfigurations\n\n%d failed check(s) in the variant's manifests\n\n", len(vr.Misconfigurations))
+			fmt.Fprintln(w, "| File | Type | Severity | Check | Title |")
+			fmt.Fprintln(w, "|------|------|----------|-------|-------|")
+			for _, m := range vr.Misconfigurations {
+				fmt.Fprintf(w, "| `%s` | %s | %s | %s | %s |\n", configLocation(m), m.Type, m.Severity, m.CheckID,
+					strings.ReplaceAll(m.Title, "|", "\\|"))
+			}
+		}
+		if len(vr.LicenseViolations) > 0 {
+			fmt.Fprintf(w, "\n### License Violations\n\n%d package(s) or file(s) under a denied license\n\n", len(vr.LicenseViolations))
+			fmt.Fprintln(w, "| Image | License | Package |")
+			fmt.Fprintln(w, "|-------|---------|---------|")
+			for _, l := range vr.LicenseViolations {
+				fmt.Fprintf(w, "| `%s` | %s | `%s` |\n", l.Image, l.License, licensedItem(l))
+			}
+		}
+	}
+	return nil
+}
+
+// licensedItem is the package a license applies to, or the file it was found in
+func licensedItem(l LicenseFinding) string {
+	if l.Package != "" {
+		return l.Package
+	}
+	return l.File
+}
+
+// configLocation is the file and line of a misconfiguration
+func configLocation(m ConfigFinding) string {
+	if m.Line == 0 {
+		return m.File
+	}
+	return fmt.Sprintf("%s:%d", m.File, m.Line)
+}
+
+// secretLocation is the file and line of a secret, e.g. /app/.env:3
+func secretLocation(s SecretFinding) string {
+	if s.Line == 0 {
+		return s.Target
+	}
+	return fmt.Sprintf("%s:%d", s.Target, s.Line)
+}
+
+// excludedCount is the ", N excluded" suffix of a report heading
+func excludedCount(vr *VariantReport) string {
+	if len(vr.Excluded) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %d excluded", len(vr.Excluded))
+}
+
+// describeExclusion explains why an image was excluded and until when
+func describeExclusion(e ExcludedImage) string {
+	if e.Expires == nil {
+		return e.Reason
+	}
+	return fmt.Sprintf("%s (until %s)", e.Reason, e.Expires.Format("2006-01-02"))
+}
+
+func writeDiffText(w io.Writer, diff *DiffReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "SEVERITY\t%s\t%s\n", strings.ToUpper(diff.From), strings.ToUpper(diff.To))
+	for _, sev := range severityOrder {
+		d := diff.Severities[sev]
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", sev, d.From, d.To)
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t%d\n", diff.FromTotal, diff.ToTotal)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nReduction: %.1f%%\n", diff.ReductionPct)
+	fmt.Fprintf(w, "Unique CVEs only in %s: %d, only in %s: %d, in both: %d\n",
+		diff.From, diff.OnlyInFrom, diff.To, diff.OnlyInTo, diff.Common)
+	if len(diff.FromExcluded) > 0 {
+		fmt.Fprintf(w, "Excluded from %s: %s\n", diff.From, strings.Join(diff.FromExcluded, ", "))
+	}
+	if len(diff.ToExcluded) > 0 {
+		fmt.Fprintf(w, "Excluded from %s: %s\n", diff.To, strings.Join(diff.ToExcluded, ", "))
+	}
+	if diff.FromLicenseViolations > 0 || diff.ToLicenseViolations > 0 {
+		fmt.Fprintf(w, "License violations in %s: %d, in %s: %d\n", diff.From, diff.FromLicenseViolations, diff.To, diff.ToLicenseViolations)
+	}
+	return nil
+}
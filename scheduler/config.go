@@ -0,0 +1,842 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const defaultSchedule = "0 2 * * *" // Daily at 2 AM UTC
+
+// Database load modes (DB_LOAD_MODE)
+const (
+	loadFull  = "full"
+	loadDelta = "delta"
+)
+
+// Severity policies for findings the scanners rate differently (SEVERITY_POLICY)
+const (
+	severityHighest = "highest"
+	severityTrivy   = "trivy"
+	severityGrype   = "grype"
+)
+
+// Database backends (DB_BACKEND)
+const (
+	backendPostgres = "postgres"
+	backendSQLite   = "sqlite"
+
+	// defaultSQLiteFile is the SQLite database under the reports directory
+	defaultSQLiteFile = "vulndb.sqlite"
+)
+
+// variantNamePattern restricts variant names to values that are safe in paths and arguments
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// DBConfig holds the database settings shared with the loader scripts
+type DBConfig struct {
+	// Backend is "postgres", or "sqlite" to load results into a local file
+	Backend string
+	// Path is the SQLite database file; empty for vulndb.sqlite in the reports directory
+	Path     string
+	Host     string
+	Port     int
+	Name     string
+	User     string
+	Password string
+	// SchemaPerVariant stores each variant in its own schema (variant_<name>)
+	SchemaPerVariant bool
+	// LoadMode is "full" to store every finding of every scan or "delta" to store only changes
+	LoadMode string
+	// AutoMigrate applies the embedded schema migrations at startup
+	AutoMigrate bool
+}
+
+// Env returns the settings as the DB_* environment variables read by the loader
+func (d DBConfig) Env() []string {
+	return []string{
+		"DB_HOST=" + d.Host,
+		"DB_PORT=" + strconv.Itoa(d.Port),
+		"DB_NAME=" + d.Name,
+		"DB_USER=" + d.User,
+		"DB_PASSWORD=" + d.Password,
+		"DB_SCHEMA_PER_VARIANT=" + strconv.FormatBool(d.SchemaPerVariant),
+		"DB_LOAD_MODE=" + d.LoadMode,
+		"DB_BACKEND=" + d.Backend,
+		"DB_PATH=" + d.SQLitePath(),
+	}
+}
+
+// SQLitePath returns the SQLite database file used with DB_BACKEND=sqlite
+func (d DBConfig) SQLitePath() string {
+	if d.Path != "" {
+		return d.Path
+	}
+	return filepath.Join(reportsPath, defaultSQLiteFile)
+}
+
+// requirePostgres reports features that read the database from the scheduler itself,
+// which only speaks Postgres
+func (d DBConfig) requirePostgres(feature string) error {
+	if d.Backend == backendSQLite {
+		return fmt.Errorf("%s needs DB_BACKEND=postgres", feature)
+	}
+	return nil
+}
+
+// VariantConfig describes an image variant scanned by each cycle
+type VariantConfig struct {
+	Name string `json:"name"`
+	// Images replaces the image list built into scan-vulnerabilities.sh for the variant
+	Images []string `json:"images,omitempty"`
+	// RegistryAuth supplies the credentials the variant's images are pulled with
+	RegistryAuth *RegistryAuth `json:"registry_auth,omitempty"`
+	// ConfigScanPaths replaces CONFIG_SCAN_PATHS for the variant's misconfig step
+	ConfigScanPaths []string `json:"config_scan_paths,omitempty"`
+	// Platforms replaces SCAN_PLATFORMS for the variant's images
+	Platforms []string `json:"platforms,omitempty"`
+	// Env adds environment variables to the variant's commands, e.g. DB_NAME for a
+	// database of its own
+	Env map[string]string `json:"env,omitempty"`
+	// SecretFiles maps environment variables to files whose contents become their
+	// values, e.g. a mounted Kubernetes secret holding DB_PASSWORD
+	SecretFiles map[string]string `json:"secret_files,omitempty"`
+}
+
+// NotificationConfig controls where cycle results are announced
+type NotificationConfig struct {
+	WebhookURL string `json:"webhook_url"`
+	// On is "failure" (default) to notify only about failed cycles, or "always"
+	On string `json:"on"`
+}
+
+// fileConfig is the JSON document read from CONFIG_FILE. Settings present in the
+// file override the environment and are re-read on SIGHUP or when the file changes.
+type fileConfig struct {
+	Schedule       string               `json:"schedule"`
+	Variants       []VariantConfig      `json:"variants"`
+	Notifications  *NotificationConfig  `json:"notifications"`
+	FalsePositives *FalsePositiveConfig `json:"false_positives"`
+	Sinks          []SinkConfig         `json:"sinks"`
+	Pipeline       []StepPolicy         `json:"pipeline"`
+	Hooks          *HooksConfig         `json:"hooks"`
+	Exclusions     []ImageExclusion     `json:"exclusions"`
+	// SeverityThresholds replaces SEVERITY_THRESHOLDS
+	SeverityThresholds SeverityThresholds `json:"severity_thresholds"`
+	// EmailRecipients replaces the EMAIL_TO recipient list
+	EmailRecipients []EmailRecipients `json:"email_recipients"`
+	// Tenants groups the variants by team
+	Tenants []TenantConfig `json:"tenants"`
+}
+
+// Config holds the scheduler settings shared by every subcommand
+type Config struct {
+	ConfigFile         string
+	Schedule           string
+	RunImmediately     bool
+	MissedRunTolerance time.Duration
+	MaxCycleDuration   time.Duration
+	ScanJitter         time.Duration
+	Variants           []VariantConfig
+	Notifications      NotificationConfig
+	FalsePositives     FalsePositiveConfig
+	Sinks              []SinkConfig
+	Pipeline           []StepPolicy
+	Hooks              HooksConfig
+	Exclusions         []ImageExclusion
+	APIAddr            string
+	APIAuth            APIAuthConfig
+	APICacheTTL        time.Duration
+	SandboxEnabled     bool
+	Sandbox            SandboxConfig
+	RegistryWebhook    RegistryWebhookConfig
+	AdvisoryFeeds      []string
+	AdvisoryCacheTTL   time.Duration
+	ExploitIntel       ExploitIntelConfig
+	GitHubIssues       GitHubIssuesConfig
+	// SeverityThresholds are the findings each variant may have before it breaches policy
+	SeverityThresholds SeverityThresholds
+	Jira               JiraConfig
+	Alerts             AlertConfig
+	Heartbeat          HeartbeatConfig
+	Attestation        AttestationConfig
+	Email              EmailConfig
+	DB                 DBConfig
+	SkipPreflight      bool
+	// ScriptsPath runs the pipeline scripts from this directory instead of the embedded copies
+	ScriptsPath string
+	// ReportsPath holds the scan reports, run logs and scheduler state
+	ReportsPath string
+	// ScannerDBWarmup refreshes the Trivy and Grype databases at startup
+	ScannerDBWarmup        bool
+	ScannerDBWarmupTimeout time.Duration
+	// ScannerDBMaxAge updates scanner databases older than this before each scan (0 only records them)
+	ScannerDBMaxAge time.Duration
+	// Offline scans with the databases under OfflineDBPath and downloads nothing
+	Offline       bool
+	OfflineDBPath string
+	// LeaderElection lets only the replica holding a Postgres advisory lock run scheduled cycles
+	LeaderElection bool
+	LeaderLockKey  int64
+	// QueueMode is "local" to scan in-process or "postgres" to hand jobs to external workers
+	QueueMode         string
+	QueuePollInterval time.Duration
+	// QueueStaleAfter is how long a running job may go without a worker heartbeat before it is re-queued
+	QueueStaleAfter time.Duration
+	// IntegritySchedule runs the database integrity check ("off" disables it)
+	IntegritySchedule string
+	// IntegrityRepair lets the scheduled integrity check repair the discrepancies it finds
+	IntegrityRepair bool
+	// RetentionPeriod prunes results older than this on RetentionSchedule (0 keeps everything)
+	RetentionPeriod   time.Duration
+	RetentionSchedule string
+	// DryRun logs the commands and images of each cycle instead of running them
+	DryRun bool
+	// ScanConcurrency is how many images of a variant are scanned at the same time
+	ScanConcurrency int
+	// ImageTimeout bounds the scan of a single image (0 for no limit)
+	ImageTimeout time.Duration
+	// StepTimeout bounds each pipeline step, after which its processes are stopped (0 for no limit)
+	StepTimeout time.Duration
+	// SkipUnchanged keeps the reports of images whose digest and scanner databases
+	// haven't changed since their last scan instead of rescanning them
+	SkipUnchanged bool
+	// ForceRescan scans every image even with SkipUnchanged
+	ForceRescan bool
+	// SecretScanning runs the secrets step: Trivy's secret detection on every image
+	SecretScanning bool
+	// ConfigScanPaths are the Kubernetes manifests and Dockerfiles the misconfig step
+	// checks; variants can list their own
+	ConfigScanPaths []string
+	// ConfigScanner is the misconfiguration scanner, "trivy" or "checkov"
+	ConfigScanner string
+	// LicenseScanning runs the licenses step: Trivy's license scanner on every image
+	LicenseScanning bool
+	// LicenseDenylist lists the licenses, or SPDX prefixes like "AGPL", flagged as violations
+	LicenseDenylist []string
+	// Platforms scans each listed platform of multi-arch images separately, e.g.
+	// linux/amd64 and linux/arm64, instead of the platform the tag resolves to
+	Platforms []string
+	// Tracing exports spans of each cycle over OTLP when an endpoint is configured
+	Tracing TracingConfig
+	// Events publishes scan outcomes to a Kafka topic or NATS subject when a URL is configured
+	Events EventsConfig
+	// GRPC serves the gRPC control API when an address is configured
+	GRPC GRPCConfig
+	// ScanTrigger rate limits and queues the cycles triggered over POST /scan and gRPC
+	ScanTrigger ScanTriggerConfig
+	// SeverityPolicy picks the severity of findings Trivy and Grype rate differently
+	SeverityPolicy string
+	// Executive writes an HTML executive summary of each cycle under /reports/executive
+	Executive ExecutiveConfig
+	// ExportFormats writes each cycle's findings and comparison under /reports/exports
+	// as csv and/or xlsx; off when empty
+	ExportFormats []string
+	// PassEnv adds variables of the scheduler's environment, or prefixes ending in *,
+	// that every pipeline command and hook inherits on top of the built-in allowlist
+	PassEnv []string
+	// ScriptShell and ScriptPython run the .sh and .py pipeline scripts, unless a
+	// step of the pipeline list has an interpreter of its own
+	ScriptShell  []string
+	ScriptPython []string
+	// ScanEngine scans with scan-vulnerabilities.sh ("script") or runs Trivy and Grype
+	// from the scheduler ("builtin")
+	ScanEngine string
+	// ScanLimits confines the CPU priority, memory and scratch disk of the scan step
+	ScanLimits ScanLimitsConfig
+	// MinFreeDisk is the free space the reports, scanner caches and temp directory
+	// need for a cycle to start, in bytes; 0 disables the check
+	MinFreeDisk int64
+	// GoldenBaseline sets how runs are compared against a variant's golden baseline
+	GoldenBaseline GoldenBaselineConfig
+	// Kubernetes reads the configuration from a ScanPolicy custom resource and runs
+	// the ScanJob controller
+	Kubernetes KubernetesConfig
+	// Tenants groups the variants of each team, with their own schedule, database,
+	// report directory and notifications
+	Tenants []TenantConfig
+	// MetricsPush pushes the metrics of each cycle to a Pushgateway or writes them
+	// to a file
+	MetricsPush MetricsPushConfig
+}
+
+// loadConfig reads the configuration from environment variables and, when
+// CONFIG_FILE is set, overlays the settings from that file
+func loadConfig() (*Config, error) {
+	env := &envReader{}
+
+	var variants []VariantConfig
+	for _, name := range defaultVariants {
+		variants = append(variants, VariantConfig{Name: name})
+	}
+
+	cfg := &Config{
+		ConfigFile:         os.Getenv("CONFIG_FILE"),
+		Schedule:           envString("SCAN_SCHEDULE", defaultSchedule),
+		RunImmediately:     envBool("RUN_IMMEDIATELY"),
+		MissedRunTolerance: env.Duration("MISSED_RUN_TOLERANCE", defaultMissedRunTolerance),
+		MaxCycleDuration:   env.Duration("MAX_CYCLE_DURATION", 0),
+		ScanJitter:         env.Duration("SCAN_JITTER", 0),
+		Variants:           variants,
+		Sinks:              defaultSinks,
+		Pipeline:           pipelineFromNames(defaultPipeline),
+		Hooks: HooksConfig{
+			Pre:  shellHook("pre-scan", os.Getenv("PRE_SCAN_HOOK")),
+			Post: shellHook("post-scan", os.Getenv("POST_SCAN_HOOK")),
+		},
+		Notifications: NotificationConfig{
+			WebhookURL: os.Getenv("NOTIFY_WEBHOOK_URL"),
+			On:         envString("NOTIFY_ON", notifyOnFailure),
+		},
+		APIAddr:        envString("API_ADDR", defaultAPIAddr),
+		APIAuth:        apiAuthConfigFromEnv(),
+		APICacheTTL:    env.Duration("API_CACHE_TTL", time.Minute),
+		SandboxEnabled: envBool("SANDBOX_ENABLED"),
+		Sandbox: SandboxConfig{
+			RateLimit: env.Int("SANDBOX_RATE_LIMIT", 5),
+			Timeout:   env.Duration("SANDBOX_SCAN_TIMEOUT", 5*time.Minute),
+			Store:     envBool("SANDBOX_STORE"),
+		},
+		RegistryWebhook: RegistryWebhookConfig{
+			Secret:   os.Getenv("REGISTRY_WEBHOOK_SECRET"),
+			Debounce: env.Duration("REGISTRY_WEBHOOK_DEBOUNCE", 30*time.Second),
+		},
+		AdvisoryFeeds:    envList("ADVISORY_FEEDS"),
+		AdvisoryCacheTTL: env.Duration("ADVISORY_CACHE_TTL", 24*time.Hour),
+		ExploitIntel: ExploitIntelConfig{
+			Enabled:     envBool("EXPLOIT_INTEL"),
+			EPSSFeedURL: envString("EPSS_FEED_URL", defaultEPSSFeedURL),
+			KEVFeedURL:  envString("KEV_FEED_URL", defaultKEVFeedURL),
+			CacheTTL:    env.Duration("EXPLOIT_INTEL_CACHE_TTL", 24*time.Hour),
+		},
+		GitHubIssues: GitHubIssuesConfig{
+			Repo:   os.Getenv("GITHUB_ISSUES_REPO"),
+			Token:  os.Getenv("GITHUB_TOKEN"),
+			APIURL: envString("GITHUB_API_URL", defaultGitHubAPIURL),
+			Labels: envList("GITHUB_ISSUE_LABELS"),
+		},
+		SeverityThresholds: env.Thresholds("SEVERITY_THRESHOLDS"),
+		Jira: JiraConfig{
+			URL:       os.Getenv("JIRA_URL"),
+			Project:   os.Getenv("JIRA_PROJECT"),
+			User:      os.Getenv("JIRA_USER"),
+			Token:     os.Getenv("JIRA_TOKEN"),
+			IssueType: envString("JIRA_ISSUE_TYPE", "Bug"),
+		},
+		Alerts: AlertConfig{
+			Provider:      os.Getenv("ALERT_PROVIDER"),
+			APIURL:        os.Getenv("ALERT_API_URL"),
+			AfterFailures: env.Int("ALERT_AFTER_FAILURES", 3),
+			StaleAfter:    env.Duration("ALERT_STALE_AFTER", 0),
+		},
+		Heartbeat: HeartbeatConfig{
+			URL:      os.Getenv("HEARTBEAT_URL"),
+			StartURL: os.Getenv("HEARTBEAT_START_URL"),
+			FailURL:  os.Getenv("HEARTBEAT_FAIL_URL"),
+		},
+		Attestation: AttestationConfig{
+			Enabled:       envBool("ATTEST_RESULTS"),
+			Key:           os.Getenv("COSIGN_KEY"),
+			PredicateType: envString("ATTEST_PREDICATE_TYPE", defaultPredicateType),
+		},
+		Email: EmailConfig{
+			SMTPHost: os.Getenv("SMTP_HOST"),
+			SMTPPort: env.Int("SMTP_PORT", 587),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("EMAIL_FROM"),
+		},
+		ExportFormats: envList("EXPORT_FORMATS"),
+		PassEnv:       envList("STEP_PASS_ENV"),
+		ScriptShell:   env.Command("SCRIPT_SHELL", "bash"),
+		ScriptPython:  env.Command("SCRIPT_PYTHON", defaultScriptPython()),
+		ScanEngine:    envString("SCAN_ENGINE", scanEngineScript),
+		Executive: ExecutiveConfig{
+			Enabled:   os.Getenv("EXECUTIVE_SUMMARY") != "false",
+			PublicURL: os.Getenv("API_PUBLIC_URL"),
+		},
+		DB: DBConfig{
+			Backend:          envString("DB_BACKEND", backendPostgres),
+			Path:             os.Getenv("DB_PATH"),
+			Host:             envString("DB_HOST", "postgres"),
+			Port:             env.Int("DB_PORT", 5432),
+			Name:             envString("DB_NAME", "vulndb"),
+			User:             envString("DB_USER", "vulnuser"),
+			Password:         envString("DB_PASSWORD", "vulnpass"),
+			SchemaPerVariant: envBool("DB_SCHEMA_PER_VARIANT"),
+			LoadMode:         envString("DB_LOAD_MODE", loadFull),
+			AutoMigrate:      os.Getenv("DB_AUTO_MIGRATE") != "false",
+		},
+		SkipPreflight:          envBool("SKIP_PREFLIGHT"),
+		ScriptsPath:            os.Getenv("SCRIPTS_PATH"),
+		ReportsPath:            envString("REPORTS_PATH", "/reports"),
+		ScannerDBWarmup:        os.Getenv("SCANNER_DB_WARMUP") != "false",
+		ScannerDBWarmupTimeout: env.Duration("SCANNER_DB_WARMUP_TIMEOUT", 30*time.Minute),
+		ScannerDBMaxAge:        env.Duration("SCANNER_DB_MAX_AGE", 24*time.Hour),
+		Offline:                envBool("OFFLINE"),
+		OfflineDBPath:          os.Getenv("OFFLINE_DB_PATH"),
+		LeaderElection:         envBool("LEADER_ELECTION"),
+		LeaderLockKey:          env.Int64("LEADER_LOCK_KEY", defaultLeaderLockKey),
+		QueueMode:              envString("QUEUE_MODE", queueLocal),
+		QueuePollInterval:      env.Duration("QUEUE_POLL_INTERVAL", 5*time.Second),
+		QueueStaleAfter:        env.Duration("QUEUE_STALE_AFTER", 5*time.Minute),
+		IntegritySchedule:      envString("INTEGRITY_SCHEDULE", defaultIntegritySchedule),
+		IntegrityRepair:        envBool("INTEGRITY_REPAIR"),
+		RetentionPeriod:        env.Window("RETENTION_PERIOD"),
+		RetentionSchedule:      envString("RETENTION_SCHEDULE", defaultRetentionSchedule),
+		DryRun:                 envBool("DRY_RUN"),
+		ScanConcurrency:        env.Int("SCAN_CONCURRENCY", 1),
+		ImageTimeout:           env.Duration("SCAN_IMAGE_TIMEOUT", 0),
+		StepTimeout:            env.Duration("STEP_TIMEOUT", 0),
+		SkipUnchanged:          envBool("SKIP_UNCHANGED_IMAGES"),
+		ForceRescan:            envBool("FORCE_RESCAN"),
+		SecretScanning:         envBool("SECRET_SCANNING"),
+		ConfigScanPaths:        envList("CONFIG_SCAN_PATHS"),
+		ConfigScanner:          envString("CONFIG_SCANNER", configScannerTrivy),
+		LicenseScanning:        envBool("LICENSE_SCANNING"),
+		LicenseDenylist:        envList("LICENSE_DENYLIST"),
+		Platforms:              envList("SCAN_PLATFORMS"),
+		Tracing:                tracingConfigFromEnv(),
+		Events:                 eventsConfigFromEnv(),
+		GRPC:                   grpcConfigFromEnv(),
+		ScanTrigger:            scanTriggerConfigFromEnv(env),
+		ScanLimits:             scanLimitsConfigFromEnv(env),
+		MinFreeDisk:            env.Bytes("MIN_FREE_DISK", defaultMinFreeDisk),
+		GoldenBaseline:         goldenBaselineConfigFromEnv(),
+		Kubernetes:             kubernetesConfigFromEnv(env),
+		MetricsPush:            metricsPushConfigFromEnv(),
+		SeverityPolicy:         envString("SEVERITY_POLICY", severityHighest),
+	}
+
+	if to := envList("EMAIL_TO"); len(to) > 0 {
+		cfg.Email.Recipients = []EmailRecipients{{Name: "EMAIL_TO", To: to, On: envString("EMAIL_ON", notifyAlways)}}
+	}
+
+	switch cfg.Alerts.Provider {
+	case alertPagerDuty:
+		cfg.Alerts.Key = os.Getenv("PAGERDUTY_ROUTING_KEY")
+		if cfg.Alerts.APIURL == "" {
+			cfg.Alerts.APIURL = defaultPagerDutyURL
+		}
+	case alertOpsgenie:
+		cfg.Alerts.Key = os.Getenv("OPSGENIE_API_KEY")
+		if cfg.Alerts.APIURL == "" {
+			cfg.Alerts.APIURL = defaultOpsgenieURL
+		}
+	}
+
+	if steps := envList("PIPELINE_STEPS"); len(steps) > 0 {
+		cfg.Pipeline = pipelineFromNames(steps)
+	}
+
+	if os.Getenv("GITHUB_ISSUE_LABELS") == "" {
+		cfg.GitHubIssues.Labels = []string{"vulnerability"}
+	}
+
+	cfg.FalsePositives.Heuristics = allHeuristics
+	if v := os.Getenv("FALSE_POSITIVE_HEURISTICS"); v == "none" {
+		cfg.FalsePositives.Heuristics = nil
+	} else if v != "" {
+		cfg.FalsePositives.Heuristics = envList("FALSE_POSITIVE_HEURISTICS")
+	}
+
+	if err := env.Err(); err != nil {
+		return cfg, err
+	}
+	if cfg.ConfigFile != "" {
+		if err := cfg.applyFile(cfg.ConfigFile); err != nil {
+			return cfg, err
+		}
+	}
+	// The ScanPolicy overrides the config file, so it can move into the cluster as is
+	if cfg.Kubernetes.Enabled {
+		if errs := cfg.Kubernetes.Validate(); len(errs) > 0 {
+			return cfg, errors.Join(errs...)
+		}
+		if err := cfg.applyScanPolicy(); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// applyFile overlays the settings from a JSON config file
+func (c *Config) applyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	fc, err := parseFileConfig(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	c.apply(fc)
+	return nil
+}
+
+// parseFileConfig decodes a config file document, rejecting unknown settings
+func parseFileConfig(data []byte) (fileConfig, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var fc fileConfig
+	err := dec.Decode(&fc)
+	return fc, err
+}
+
+// apply overlays the settings present in a config file document
+func (c *Config) apply(fc fileConfig) {
+	if fc.Schedule != "" {
+		c.Schedule = fc.Schedule
+	}
+	if fc.Variants != nil {
+		c.Variants = fc.Variants
+	}
+	if fc.Notifications != nil {
+		c.Notifications = *fc.Notifications
+		if c.Notifications.On == "" {
+			c.Notifications.On = notifyOnFailure
+		}
+	}
+	if fc.Sinks != nil {
+		c.Sinks = fc.Sinks
+	}
+	if fc.Pipeline != nil {
+		c.Pipeline = fc.Pipeline
+	}
+	if fc.Hooks != nil {
+		c.Hooks = *fc.Hooks
+	}
+	if fc.Exclusions != nil {
+		c.Exclusions = fc.Exclusions
+	}
+	if fc.SeverityThresholds != nil {
+		c.SeverityThresholds = fc.SeverityThresholds
+	}
+	if fc.EmailRecipients != nil {
+		c.Email.Recipients = fc.EmailRecipients
+	}
+	if fc.Tenants != nil {
+		c.Tenants = fc.Tenants
+	}
+	if fc.FalsePositives != nil {
+		if fc.FalsePositives.Heuristics == nil {
+			fc.FalsePositives.Heuristics = c.FalsePositives.Heuristics
+		}
+		c.FalsePositives = *fc.FalsePositives
+	}
+}
+
+// VariantImages returns the configured images of a variant, or nil when the variant
+// uses the image list of the scan script
+func (c *Config) VariantImages(name string) []string {
+	for _, v := range c.Variants {
+		if v.Name == name {
+			return v.Images
+		}
+	}
+	return nil
+}
+
+// VariantNames returns the names of the configured variants in scan order
+func (c *Config) VariantNames() []string {
+	names := make([]string, 0, len(c.Variants))
+	for _, v := range c.Variants {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+// Validate checks the configuration for values that would fail at run time
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, err := cron.ParseStandard(c.Schedule); err != nil {
+		errs = append(errs, fmt.Errorf("invalid scan schedule %q: %w", c.Schedule, err))
+	}
+	if c.IntegritySchedule != integrityOff {
+		if _, err := cron.ParseStandard(c.IntegritySchedule); err != nil {
+			errs = append(errs, fmt.Errorf("invalid INTEGRITY_SCHEDULE %q: %w", c.IntegritySchedule, err))
+		}
+	}
+	if c.RetentionPeriod > 0 {
+		if _, err := cron.ParseStandard(c.RetentionSchedule); err != nil {
+			errs = append(errs, fmt.Errorf("invalid RETENTION_SCHEDULE %q: %w", c.RetentionSchedule, err))
+		}
+	}
+	if c.Offline && c.OfflineDBPath == "" {
+		errs = append(errs, errors.New("OFFLINE requires OFFLINE_DB_PATH, the directory holding the trivy/ and grype/ databases"))
+	}
+	if c.ScannerDBMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("SCANNER_DB_MAX_AGE must not be negative, got %s", c.ScannerDBMaxAge))
+	}
+	if c.ScanConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("SCAN_CONCURRENCY must be at least 1, got %d", c.ScanConcurrency))
+	}
+	if c.ImageTimeout != 0 && c.ImageTimeout < time.Second {
+		errs = append(errs, fmt.Errorf("SCAN_IMAGE_TIMEOUT must be at least 1s, got %s", c.ImageTimeout))
+	}
+	if c.StepTimeout < 0 {
+		errs = append(errs, fmt.Errorf("STEP_TIMEOUT must not be negative, got %s", c.StepTimeout))
+	}
+	if c.ScanJitter < 0 {
+		errs = append(errs, fmt.Errorf("SCAN_JITTER must not be negative, got %s", c.ScanJitter))
+	}
+	if c.MaxCycleDuration < 0 {
+		errs = append(errs, fmt.Errorf("MAX_CYCLE_DURATION must not be negative, got %s", c.MaxCycleDuration))
+	}
+	if len(c.Variants) == 0 {
+		errs = append(errs, errors.New("no variants configured"))
+	}
+	seen := make(map[string]bool)
+	for _, v := range c.Variants {
+		if !variantNamePattern.MatchString(v.Name) {
+			errs = append(errs, fmt.Errorf("invalid variant name %q: use lowercase letters, digits and dashes", v.Name))
+		}
+		if seen[v.Name] {
+			errs = append(errs, fmt.Errorf("variant %q configured more than once", v.Name))
+		}
+		seen[v.Name] = true
+		for _, image := range v.Images {
+			if image == "" || strings.ContainsAny(image, ", \t") {
+				errs = append(errs, fmt.Errorf("variant %q has an invalid image %q", v.Name, image))
+			}
+		}
+		if v.RegistryAuth != nil {
+			errs = append(errs, v.RegistryAuth.Validate(v.Name)...)
+		}
+		errs = append(errs, validateVariantEnv(v)...)
+		for _, p := range v.Platforms {
+			if !validPlatform(p) {
+				errs = append(errs, fmt.Errorf("variant %q has an invalid platform %q: must be os/arch or os/arch/variant", v.Name, p))
+			}
+		}
+	}
+
+	switch c.Notifications.On {
+	case notifyOnFailure, notifyAlways:
+	default:
+		errs = append(errs, fmt.Errorf("invalid notification setting on=%q: must be %q or %q", c.Notifications.On, notifyOnFailure, notifyAlways))
+	}
+	if c.Notifications.WebhookURL != "" {
+		if u, err := url.Parse(c.Notifications.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, errors.New("invalid notification webhook URL: must be an http(s) URL"))
+		}
+	}
+	if c.SandboxEnabled {
+		if c.Sandbox.RateLimit <= 0 {
+			errs = append(errs, fmt.Errorf("SANDBOX_RATE_LIMIT must be positive, got %d", c.Sandbox.RateLimit))
+		}
+		if c.Sandbox.Timeout <= 0 {
+			errs = append(errs, fmt.Errorf("SANDBOX_SCAN_TIMEOUT must be positive, got %s", c.Sandbox.Timeout))
+		}
+	}
+
+	if c.GitHubIssues.Enabled() {
+		if owner, name, ok := strings.Cut(c.GitHubIssues.Repo, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			errs = append(errs, fmt.Errorf("invalid GITHUB_ISSUES_REPO %q: must be owner/name", c.GitHubIssues.Repo))
+		}
+		if c.GitHubIssues.Token == "" {
+			errs = append(errs, errors.New("GITHUB_ISSUES_REPO needs a GITHUB_TOKEN"))
+		}
+		if u, err := url.Parse(c.GitHubIssues.APIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, errors.New("invalid GITHUB_API_URL: must be an http(s) URL"))
+		}
+	}
+
+	errs = append(errs, c.SeverityThresholds.Validate()...)
+	errs = append(errs, c.Email.Validate()...)
+	errs = append(errs, validatePassEnv("STEP_PASS_ENV", c.PassEnv)...)
+	if len(c.ScriptShell) == 0 {
+		errs = append(errs, errors.New("SCRIPT_SHELL must name a shell"))
+	}
+	if len(c.ScriptPython) == 0 {
+		errs = append(errs, errors.New("SCRIPT_PYTHON must name a Python interpreter"))
+	}
+	switch c.ScanEngine {
+	case scanEngineScript:
+	case scanEngineBuiltin:
+		// The built-in image lists of the demo variants live in scan-vulnerabilities.sh
+		for _, v := range c.Variants {
+			if len(v.Images) == 0 {
+				errs = append(errs, fmt.Errorf("SCAN_ENGINE=builtin needs the images of variant %q in the config file", v.Name))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid SCAN_ENGINE %q: must be %q or %q", c.ScanEngine, scanEngineScript, scanEngineBuiltin))
+	}
+	for _, format := range c.ExportFormats {
+		if format != exportCSV && format != exportXLSX {
+			errs = append(errs, fmt.Errorf("EXPORT_FORMATS must list csv and/or xlsx, got %q", format))
+		}
+	}
+	if c.Jira.Enabled() {
+		if u, err := url.Parse(c.Jira.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, errors.New("invalid JIRA_URL: must be an http(s) URL"))
+		}
+		if c.Jira.Project == "" || c.Jira.Token == "" {
+			errs = append(errs, errors.New("JIRA_URL needs a JIRA_PROJECT and a JIRA_TOKEN"))
+		}
+		if len(c.SeverityThresholds) == 0 {
+			errs = append(errs, errors.New("JIRA_URL needs SEVERITY_THRESHOLDS to know when to file tickets"))
+		}
+	}
+
+	switch c.Alerts.Provider {
+	case "":
+	case alertPagerDuty, alertOpsgenie:
+		if c.Alerts.Key == "" {
+			keyVar := "PAGERDUTY_ROUTING_KEY"
+			if c.Alerts.Provider == alertOpsgenie {
+				keyVar = "OPSGENIE_API_KEY"
+			}
+			errs = append(errs, fmt.Errorf("ALERT_PROVIDER=%s needs %s", c.Alerts.Provider, keyVar))
+		}
+		if u, err := url.Parse(c.Alerts.APIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, errors.New("invalid ALERT_API_URL: must be an http(s) URL"))
+		}
+		if c.Alerts.AfterFailures < 1 {
+			errs = append(errs, fmt.Errorf("ALERT_AFTER_FAILURES must be at least 1, got %d", c.Alerts.AfterFailures))
+		}
+		if c.Alerts.StaleAfter < 0 {
+			errs = append(errs, fmt.Errorf("ALERT_STALE_AFTER must not be negative, got %s", c.Alerts.StaleAfter))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid ALERT_PROVIDER %q: must be %q or %q", c.Alerts.Provider, alertPagerDuty, alertOpsgenie))
+	}
+
+	for _, h := range []struct{ name, url string }{
+		{"HEARTBEAT_URL", c.Heartbeat.URL},
+		{"HEARTBEAT_START_URL", c.Heartbeat.StartURL},
+		{"HEARTBEAT_FAIL_URL", c.Heartbeat.FailURL},
+	} {
+		if h.url == "" {
+			continue
+		}
+		if u, err := url.Parse(h.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, fmt.Errorf("invalid %s: must be an http(s) URL", h.name))
+		}
+	}
+	if !c.Heartbeat.Enabled() && (c.Heartbeat.StartURL != "" || c.Heartbeat.FailURL != "") {
+		errs = append(errs, errors.New("HEARTBEAT_START_URL and HEARTBEAT_FAIL_URL need HEARTBEAT_URL"))
+	}
+
+	if c.RegistryWebhook.Debounce < 0 {
+		errs = append(errs, fmt.Errorf("REGISTRY_WEBHOOK_DEBOUNCE must not be negative, got %s", c.RegistryWebhook.Debounce))
+	}
+
+	if c.ScannerDBWarmup && c.ScannerDBWarmupTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("SCANNER_DB_WARMUP_TIMEOUT must be positive, got %s", c.ScannerDBWarmupTimeout))
+	}
+
+	switch c.QueueMode {
+	case queueLocal, queuePostgres:
+	default:
+		errs = append(errs, fmt.Errorf("invalid QUEUE_MODE %q: must be %q or %q", c.QueueMode, queueLocal, queuePostgres))
+	}
+	if c.QueuePollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("QUEUE_POLL_INTERVAL must be positive, got %s", c.QueuePollInterval))
+	}
+	if c.QueueStaleAfter <= workerHeartbeatInterval {
+		errs = append(errs, fmt.Errorf("QUEUE_STALE_AFTER must be longer than the %s worker heartbeat, got %s", workerHeartbeatInterval, c.QueueStaleAfter))
+	}
+
+	switch c.DB.Backend {
+	case backendPostgres:
+	case backendSQLite:
+		// The scheduler coordinates replicas and workers, and stores variants apart, in Postgres
+		if c.LeaderElection {
+			errs = append(errs, errors.New("LEADER_ELECTION needs DB_BACKEND=postgres"))
+		}
+		if c.QueueMode == queuePostgres {
+			errs = append(errs, errors.New("QUEUE_MODE=postgres needs DB_BACKEND=postgres"))
+		}
+		if c.DB.SchemaPerVariant {
+			errs = append(errs, errors.New("DB_SCHEMA_PER_VARIANT needs DB_BACKEND=postgres"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid DB_BACKEND %q: must be %q or %q", c.DB.Backend, backendPostgres, backendSQLite))
+	}
+
+	switch c.SeverityPolicy {
+	case severityHighest, severityTrivy, severityGrype:
+	default:
+		errs = append(errs, fmt.Errorf("invalid SEVERITY_POLICY %q: must be %q, %q or %q", c.SeverityPolicy, severityHighest, severityTrivy, severityGrype))
+	}
+
+	if c.Attestation.Enabled && c.Attestation.PredicateType == "" {
+		errs = append(errs, errors.New("ATTEST_PREDICATE_TYPE must not be empty with ATTEST_RESULTS"))
+	}
+
+	switch c.ConfigScanner {
+	case configScannerTrivy, configScannerCheckov:
+	default:
+		errs = append(errs, fmt.Errorf("invalid CONFIG_SCANNER %q: must be %q or %q", c.ConfigScanner, configScannerTrivy, configScannerCheckov))
+	}
+
+	for _, p := range c.Platforms {
+		if !validPlatform(p) {
+			errs = append(errs, fmt.Errorf("invalid SCAN_PLATFORMS entry %q: must be os/arch or os/arch/variant", p))
+		}
+	}
+
+	switch c.DB.LoadMode {
+	case loadFull, loadDelta:
+	default:
+		errs = append(errs, fmt.Errorf("invalid DB_LOAD_MODE %q: must be %q or %q", c.DB.LoadMode, loadFull, loadDelta))
+	}
+
+	errs = append(errs, c.FalsePositives.Validate()...)
+	errs = append(errs, validateSinks(c.Sinks)...)
+	errs = append(errs, validatePipeline(c.Pipeline)...)
+	errs = append(errs, c.Hooks.Validate()...)
+	errs = append(errs, validateExclusions(c.Exclusions)...)
+	if err := c.Tracing.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Events.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, c.APIAuth.Validate()...)
+	if err := c.GRPC.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, c.ScanTrigger.Validate()...)
+	errs = append(errs, c.ScanLimits.Validate()...)
+	if c.MinFreeDisk < 0 {
+		errs = append(errs, errors.New("MIN_FREE_DISK must not be negative"))
+	}
+	errs = append(errs, c.GoldenBaseline.Validate()...)
+	errs = append(errs, c.Kubernetes.Validate()...)
+	errs = append(errs, c.MetricsPush.Validate()...)
+	errs = append(errs, c.validateTenants()...)
+
+	if c.ExploitIntel.Enabled {
+		for name, feed := range map[string]string{"EPSS_FEED_URL": c.ExploitIntel.EPSSFeedURL, "KEV_FEED_URL": c.ExploitIntel.KEVFeedURL} {
+			if u, err := url.Parse(feed); feed != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https")) {
+				errs = append(errs, fmt.Errorf("invalid %s %q: must be an http(s) URL", name, feed))
+			}
+		}
+		if c.ExploitIntel.EPSSFeedURL == "" && c.ExploitIntel.KEVFeedURL == "" {
+			errs = append(errs, errors.New("EXPLOIT_INTEL needs EPSS_FEED_URL or KEV_FEED_URL"))
+		}
+	}
+
+	for _, feed := range c.AdvisoryFeeds {
+		if u, err := url.Parse(feed); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, fmt.Errorf("invalid ADVISORY_FEEDS entry %q: must be an http(s) URL", feed))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,97 @@
+package main
+
+import "time"
+
+// Overall outcome of a scan cycle
+const (
+	cycleSuccess = "success"
+	cyclePartial = "partial"
+	cycleFailure = "failure"
+)
+
+// Exit codes of one-shot runs
+const (
+	exitSuccess = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitPartial = 3
+)
+
+// CycleResult aggregates the per-variant outcomes of a scan cycle. It is printed by
+// one-shot runs, posted to the notification webhook and feeds the cycle metrics.
+type CycleResult struct {
+	RunID string `json:"run_id"`
+	// Tenant is set on the part of a cycle sent to a tenant's own notifications
+	Tenant     string    `json:"tenant,omitempty"`
+	Status     string    `json:"status"`
+	Success    bool      `json:"success"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Diagnostics is the path of the diagnostics bundle written when the cycle did not fully succeed
+	Diagnostics string `json:"diagnostics,omitempty"`
+	// ExecutiveReport is the URL, or the path on the reports volume, of the cycle's
+	// HTML executive summary
+	ExecutiveReport string `json:"executive_report,omitempty"`
+	// DryRun marks a cycle that only logged what it would have done
+	DryRun bool `json:"dry_run,omitempty"`
+	// Targeted marks a rescan of pushed images rather than a full cycle
+	Targeted bool            `json:"targeted,omitempty"`
+	Variants []VariantResult `json:"variants"`
+
+	// span traces the cycle; nil when tracing is off
+	span *span
+}
+
+// finish records the end time and derives the overall status: success when every
+// variant succeeded, failure when none did, partial otherwise
+func (c *CycleResult) finish() {
+	c.FinishedAt = time.Now().UTC()
+
+	failed := 0
+	for _, v := range c.Variants {
+		if !v.Success {
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+		c.Status = cycleSuccess
+	case failed == len(c.Variants):
+		c.Status = cycleFailure
+	default:
+		c.Status = cyclePartial
+	}
+	c.Success = c.Status == cycleSuccess
+}
+
+// Failed returns the variants that did not complete
+func (c *CycleResult) Failed() []string {
+	var failed []string
+	for _, v := range c.Variants {
+		if !v.Success {
+			failed = append(failed, v.Variant)
+		}
+	}
+	return failed
+}
+
+// variantNames returns the variants the cycle scanned
+func (c *CycleResult) variantNames() []string {
+	names := make([]string, 0, len(c.Variants))
+	for _, v := range c.Variants {
+		names = append(names, v.Variant)
+	}
+	return names
+}
+
+// ExitCode maps the cycle status to the exit code of a one-shot run
+func (c *CycleResult) ExitCode() int {
+	switch c.Status {
+	case cycleSuccess:
+		return exitSuccess
+	case cyclePartial:
+		return exitPartial
+	default:
+		return exitFailure
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	dashboardRecentRuns = 15
+
+	// Size of the trend charts, in SVG user units
+	chartWidth  = 600
+	chartHeight = 160
+)
+
+// dashboardFiles holds the dashboard page template and its static assets
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+var dashboardTemplate = template.Must(template.New("index.html").Funcs(template.FuncMap{
+	"severityColor": func(sev string) string { return badgeColors[sev] },
+	"lower":         strings.ToLower,
+}).ParseFS(dashboardFiles, "dashboard/index.html"))
+
+// RunSummary is one variant's share of a past cycle, as recorded in the scans table
+type RunSummary struct {
+	RunID           string    `json:"run_id"`
+	Variant         string    `json:"variant"`
+	StartedAt       time.Time `json:"started_at"`
+	Images          int       `json:"images"`
+	Failed          int       `json:"failed"`
+	Vulnerabilities int       `json:"vulnerabilities"`
+	Critical        int       `json:"critical"`
+}
+
+// dashboardVariant is the dashboard section of one variant
+type dashboardVariant struct {
+	Report *VariantReport
+	Trend  []TrendPoint
+	Chart  *trendChart
+}
+
+// dashboardPage is the data of the dashboard template
+type dashboardPage struct {
+	GeneratedAt time.Time
+	Window      string
+	Severities  []string
+	Variants    []dashboardVariant
+	Runs        []RunSummary
+	Activity    *RunActivity
+	// DBError explains why the history sections are empty
+	DBError string
+}
+
+// dashboard serves GET /dashboard: the latest results per variant, CVE counts over
+// time and recent runs, for demo viewers without database access
+type dashboard struct {
+	sched *Scheduler
+	db    *sql.DB
+}
+
+// newDashboard prepares the dashboard, reading the history from db when not nil
+func newDashboard(s *Scheduler, db *sql.DB) *dashboard {
+	return &dashboard{sched: s, db: db}
+}
+
+// staticHandler serves the embedded stylesheet under /dashboard/static/
+func (d *dashboard) staticHandler() http.Handler {
+	static, _ := fs.Sub(dashboardFiles, "dashboard/static")
+	return http.StripPrefix("/dashboard/static/", http.FileServer(http.FS(static)))
+}
+
+func (d *dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	if r.URL.Path != "/dashboard" && r.URL.Path != "/dashboard/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = defaultTrendWindow
+	}
+	period, err := parseWindow(window)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page := dashboardPage{GeneratedAt: time.Now().UTC(), Window: window, Severities: severityOrder}
+	if snap := activity.Snapshot(); snap.Run != nil {
+		page.Activity = snap.Run
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), trendQueryTimeout)
+	defer cancel()
+	since := page.GeneratedAt.Add(-period)
+
+	for _, variant := range d.sched.Config().VariantNames() {
+		report, err := buildVariantReport(variant)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		dv := dashboardVariant{Report: report}
+		if page.DBError == "" {
+			trend, runs, err := d.history(ctx, variant, since)
+			if err != nil {
+				page.DBError = err.Error()
+			}
+			dv.Trend = trend
+			dv.Chart = newTrendChart(trend, since, page.GeneratedAt)
+			page.Runs = append(page.Runs, runs...)
+		}
+		page.Variants = append(page.Variants, dv)
+	}
+
+	sort.Slice(page.Runs, func(i, j int) bool { return page.Runs[i].StartedAt.After(page.Runs[j].StartedAt) })
+	if len(page.Runs) > dashboardRecentRuns {
+		page.Runs = page.Runs[:dashboardRecentRuns]
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, page); err != nil {
+		log.Printf("⚠️  Failed to render the dashboard: %v", err)
+	}
+}
+
+// history reads a variant's daily CVE counts and recent runs from the database
+func (d *dashboard) history(ctx context.Context, variant string, since time.Time) ([]TrendPoint, []RunSummary, error) {
+	if d.db == nil {
+		if err := d.sched.Config().DB.requirePostgres("scan history"); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("database unavailable")
+	}
+	schema, err := variantSchema(ctx, d.db, variant)
+	if err != nil {
+		return nil, nil, fmt.Errorf("database unavailable: %w", err)
+	}
+	trend, err := severityTrend(ctx, d.db, schema, variant, since, defaultTrendInterval)
+	if err != nil {
+		return nil, nil, err
+	}
+	runs, err := recentRuns(ctx, d.db, schema, variant, dashboardRecentRuns)
+	return trend, runs, err
+}
+
+// recentRuns summarizes the variant's scans of its last limit cycles
+func recentRuns(ctx context.Context, db *sql.DB, schema, variant string, limit int) ([]RunSummary, error) {
+	rows, err := db.QueryContext(ctx, `SELECT run_id, MIN(created_at), COUNT(*),
+			COUNT(*) FILTER (WHERE scan_status = 'failed'),
+			COALESCE(SUM(total_vulnerabilities), 0), COALESCE(SUM(critical_count), 0)
+		FROM `+pq.QuoteIdentifier(schema)+`.scans
+		WHERE image_variant = $1 AND run_id IS NOT NULL
+		GROUP BY run_id ORDER BY MIN(created_at) DESC LIMIT $2`, variant, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunSummary
+	for rows.Next() {
+		r := RunSummary{Variant: variant}
+		if err := rows.Scan(&r.RunID, &r.StartedAt, &r.Images, &r.Failed, &r.Vulnerabilities, &r.Critical); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// trendChart is a line chart of a trend, one polyline per severity
+type trendChart struct {
+	Width, Height int
+	Max           int
+	Lines         []chartLine
+	From, To      time.Time
+}
+
+type chartLine struct {
+	Severity string
+	Points   string
+}
+
+// newTrendChart scales a trend to the chart area, with time on the x axis from
+// from to to; nil when there is nothing to draw
+func newTrendChart(trend []TrendPoint, from, to time.Time) *trendChart {
+	if len(trend) == 0 {
+		return nil
+	}
+	c := &trendChart{Width: chartWidth, Height: chartHeight, From: from, To: to}
+	for _, p := range trend {
+		for _, sev := range severityOrder {
+			if p.Severities[sev] > c.Max {
+				c.Max = p.Severities[sev]
+			}
+		}
+	}
+	span := to.Sub(from).Seconds()
+	for _, sev := range severityOrder {
+		points := make([]string, 0, len(trend))
+		for _, p := range trend {
+			x := float64(chartWidth) * p.Time.Sub(from).Seconds() / span
+			y := float64(chartHeight)
+			if c.Max > 0 {
+				y -= float64(chartHeight) * float64(p.Severities[sev]) / float64(c.Max)
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", max(x, 0), y))
+		}
+		c.Lines = append(c.Lines, chartLine{Severity: sev, Points: strings.Join(points, " ")})
+	}
+	return c
+}
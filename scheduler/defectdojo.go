@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sinkDefectDojo imports each image's merged report into DefectDojo
+const sinkDefectDojo = "defectdojo"
+
+const (
+	// defectDojoScanType is the parser the merged reports are imported with; they keep
+	// Trivy's JSON layout
+	defectDojoScanType = "Trivy Scan"
+	// defectDojoProduct is the product and product type created without a configured product
+	defectDojoProduct = "vuln-demo"
+)
+
+// defectDojoSink reimports the merged report of each image scanned this cycle into a
+// DefectDojo engagement, one test per image, so findings fixed since the last import
+// are closed instead of piling up
+type defectDojoSink struct {
+	cfg SinkConfig
+}
+
+func newDefectDojoSink(c SinkConfig) Sink {
+	return defectDojoSink{cfg: c}
+}
+
+// apiKey returns the sink's DefectDojo API v2 key
+func (s defectDojoSink) apiKey() string {
+	if s.cfg.APIKey != "" {
+		return s.cfg.APIKey
+	}
+	return os.Getenv("DEFECTDOJO_API_KEY")
+}
+
+// product returns the product the scans are imported into
+func (s defectDojoSink) product() string {
+	if s.cfg.Product != "" {
+		return s.cfg.Product
+	}
+	return defectDojoProduct
+}
+
+// engagement returns the engagement a variant is imported into, the variant itself by default
+func (s defectDojoSink) engagement(variant string) string {
+	if s.cfg.Engagement != "" {
+		return s.cfg.Engagement
+	}
+	return variant
+}
+
+func (s defectDojoSink) Publish(j *ScanJob, batch *SinkBatch) error {
+	images, err := j.scannedImages()
+	if err != nil {
+		return err
+	}
+	var imported int
+	var failed []string
+	for _, image := range images {
+		reports, err := imageReportFiles(batch.Variant, image)
+		if err != nil {
+			return err
+		}
+		for _, file := range reports {
+			report, err := readTrivyReport(file)
+			if err != nil {
+				return err
+			}
+			title := ImageReport{Image: image, Platform: report.Platform}.Label()
+			if err := s.reimport(batch, file, title); err != nil {
+				j.Log.Printf("[%s] ⚠️  Could not import %s into DefectDojo: %v", batch.Variant, title, err)
+				failed = append(failed, title)
+				continue
+			}
+			imported++
+		}
+	}
+	j.Log.Printf("[%s] 🥋 Imported %d report(s) into DefectDojo %s / %s", batch.Variant, imported,
+		s.product(), s.engagement(batch.Variant))
+	if len(failed) > 0 {
+		return fmt.Errorf("DefectDojo import failed for %d report(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// reimport uploads one report with /api/v2/reimport-scan/, which creates the product,
+// engagement and test on first import when auto_create_context is set
+func (s defectDojoSink) reimport(batch *SinkBatch, file, title string) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := [][2]string{
+		{"scan_type", defectDojoScanType},
+		{"product_type_name", defectDojoProduct},
+		{"product_name", s.product()},
+		{"engagement_name", s.engagement(batch.Variant)},
+		{"test_title", title},
+		{"auto_create_context", "true"},
+		{"active", "true"},
+		{"verified", "false"},
+		{"close_old_findings", "true"},
+		{"scan_date", batch.GeneratedAt.Format(time.DateOnly)},
+		{"version", batch.RunID},
+	}
+	if s.cfg.MinSeverity != "" {
+		fields = append(fields, [2]string{"minimum_severity", defectDojoSeverity(s.cfg.MinSeverity)})
+	}
+	for _, f := range fields {
+		if err := form.WriteField(f[0], f[1]); err != nil {
+			return err
+		}
+	}
+	part, err := form.CreateFormFile("file", filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.cfg.URL, "/")+"/api/v2/reimport-scan/", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Token "+s.apiKey())
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("DefectDojo returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// defectDojoSeverity maps a severity to DefectDojo's capitalized form, e.g. HIGH to High
+func defectDojoSeverity(severity string) string {
+	severity = strings.ToLower(severity)
+	return strings.ToUpper(severity[:1]) + severity[1:]
+}
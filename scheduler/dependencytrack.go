@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// sinkDependencyTrack uploads a CycloneDX SBOM of each image to Dependency-Track
+const sinkDependencyTrack = "dependency-track"
+
+// dependencyTrackSink generates an SBOM of each image scanned this cycle with Trivy and
+// uploads it to the Dependency-Track project of the image's repository, versioned by
+// tag. Dependency-Track then tracks the image's components against its own feeds.
+type dependencyTrackSink struct {
+	cfg SinkConfig
+}
+
+func newDependencyTrackSink(c SinkConfig) Sink {
+	return dependencyTrackSink{cfg: c}
+}
+
+// apiKey returns the sink's Dependency-Track API key, which needs the BOM_UPLOAD and
+// PROJECT_CREATION_UPLOAD permissions
+func (s dependencyTrackSink) apiKey() string {
+	if s.cfg.APIKey != "" {
+		return s.cfg.APIKey
+	}
+	return os.Getenv("DEPENDENCY_TRACK_API_KEY")
+}
+
+func (s dependencyTrackSink) Publish(j *ScanJob, batch *SinkBatch) error {
+	images, err := j.scannedImages()
+	if err != nil {
+		return err
+	}
+	authEnv, cleanupAuth, err := j.registryAuthEnv()
+	if err != nil {
+		return fmt.Errorf("registry credentials for %s: %w", j.Variant, err)
+	}
+	defer cleanupAuth()
+
+	var failed []string
+	for _, image := range images {
+		if err := s.upload(j, image, authEnv); err != nil {
+			j.Log.Printf("[%s] ⚠️  Could not upload the SBOM of %s to Dependency-Track: %v", batch.Variant, image, err)
+			failed = append(failed, image)
+		}
+	}
+	j.Log.Printf("[%s] 📦 Uploaded %d SBOM(s) to Dependency-Track", batch.Variant, len(images)-len(failed))
+	if len(failed) > 0 {
+		return fmt.Errorf("SBOM upload failed for %d image(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// upload generates the SBOM of an image and puts it with /api/v1/bom, creating the
+// project (under the configured product as parent) on first upload
+func (s dependencyTrackSink) upload(j *ScanJob, image string, authEnv []string) error {
+	sbom, err := os.CreateTemp(variantDir(j.Variant), ".sbom-*.cdx.json")
+	if err != nil {
+		return err
+	}
+	sbom.Close()
+	defer os.Remove(sbom.Name())
+
+	cmd := exec.Command("trivy", "image", "--format", "cyclonedx", "--quiet", "--output", sbom.Name(), image)
+	cmd.Env = j.commandEnv(authEnv...)
+	if err := j.runLogged("sbom-"+strings.TrimSuffix(imageReportFile(image), "_scan.json"), cmd); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(sbom.Name())
+	if err != nil {
+		return err
+	}
+
+	repository := imageRepository(image)
+	version := strings.TrimLeft(strings.TrimPrefix(image, repository), ":@")
+	if version == "" {
+		version = "latest"
+	}
+	doc := map[string]any{
+		"projectName":    repository,
+		"projectVersion": version,
+		"autoCreate":     true,
+		"bom":            base64.StdEncoding.EncodeToString(data),
+	}
+	if s.cfg.Product != "" {
+		doc["parentName"] = s.cfg.Product
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(s.cfg.URL, "/")+"/api/v1/bom", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", s.apiKey())
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Dependency-Track returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	diagnosticsFileName = "diagnostics.tar.gz"
+	diagnosticsLogLines = 500
+	redacted            = "REDACTED"
+)
+
+// scannerVersionCommands report the versions of the tools used by the pipeline
+var scannerVersionCommands = [][]string{
+	{"trivy", "--version"},
+	{"grype", "version"},
+	{"python3", "--version"},
+}
+
+// Redacted returns a copy of the configuration that is safe to share
+func (c *Config) Redacted() Config {
+	r := *c
+	if r.DB.Password != "" {
+		r.DB.Password = redacted
+	}
+	if r.GitHubIssues.Token != "" {
+		r.GitHubIssues.Token = redacted
+	}
+	if r.Email.Password != "" {
+		r.Email.Password = redacted
+	}
+	if r.Alerts.Key != "" {
+		r.Alerts.Key = redacted
+	}
+	if r.Jira.Token != "" {
+		r.Jira.Token = redacted
+	}
+	r.APIAuth.ReadTokens = redactAll(r.APIAuth.ReadTokens)
+	r.APIAuth.OperatorTokens = redactAll(r.APIAuth.OperatorTokens)
+	if r.RegistryWebhook.Secret != "" {
+		r.RegistryWebhook.Secret = redacted
+	}
+	r.Notifications.WebhookURL = redactURL(r.Notifications.WebhookURL)
+	r.Heartbeat.URL = redactURL(r.Heartbeat.URL)
+	r.Heartbeat.StartURL = redactURL(r.Heartbeat.StartURL)
+	r.Heartbeat.FailURL = redactURL(r.Heartbeat.FailURL)
+	r.Events.URL = redactURL(r.Events.URL)
+	r.MetricsPush.PushgatewayURL = redactURL(r.MetricsPush.PushgatewayURL)
+	if len(r.Tracing.Headers) > 0 {
+		headers := make(map[string]string, len(r.Tracing.Headers))
+		for name := range r.Tracing.Headers {
+			headers[name] = redacted
+		}
+		r.Tracing.Headers = headers
+	}
+	r.Variants = make([]VariantConfig, len(c.Variants))
+	for i, v := range c.Variants {
+		v.Env = redactEnv(v.Env)
+		r.Variants[i] = v
+	}
+	r.Tenants = make([]TenantConfig, len(c.Tenants))
+	for i, t := range c.Tenants {
+		t.Env = redactEnv(t.Env)
+		if t.Notifications != nil {
+			n := *t.Notifications
+			n.WebhookURL = redactURL(n.WebhookURL)
+			t.Notifications = &n
+		}
+		r.Tenants[i] = t
+	}
+	r.Sinks = make([]SinkConfig, len(c.Sinks))
+	for i, s := range c.Sinks {
+		s.URL = redactURL(s.URL)
+		if s.APIKey != "" {
+			s.APIKey = redacted
+		}
+		r.Sinks[i] = s
+	}
+	return r
+}
+
+// redactAll replaces each secret of a list, keeping how many there are
+func redactAll(secrets []string) []string {
+	if len(secrets) == 0 {
+		return secrets
+	}
+	r := make([]string, len(secrets))
+	for i := range r {
+		r[i] = redacted
+	}
+	return r
+}
+
+// redactEnv copies env with the values of secret-looking names redacted
+func redactEnv(env map[string]string) map[string]string {
+	if len(env) == 0 {
+		return env
+	}
+	r := make(map[string]string, len(env))
+	for name, value := range env {
+		if secretEnvName.MatchString(name) {
+			value = redacted
+		}
+		r[name] = value
+	}
+	return r
+}
+
+// redactURL keeps only the scheme and host of a URL; webhook paths and queries
+// usually embed tokens
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	if u, err := url.Parse(raw); err == nil && u.Host != "" {
+		return u.Scheme + "://" + u.Host + "/" + redacted
+	}
+	return redacted
+}
+
+// preflightResult is the JSON form of a PreflightCheck
+type preflightResult struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// writeDiagnosticsBundle collects what is needed to debug a failed run remotely into
+// /reports/{run_id}/diagnostics.tar.gz: the cycle results, a redacted config snapshot,
+// the tail of each step log, preflight results and scanner versions
+func writeDiagnosticsBundle(cfg *Config, runID string, results []VariantResult) (string, error) {
+	dir := filepath.Join(reportsPath, runID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, diagnosticsFileName)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	addJSON := func(name string, v any) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, append(data, '\n'))
+	}
+
+	if err := addJSON("results.json", results); err != nil {
+		return "", err
+	}
+	if err := addJSON("config.json", cfg.Redacted()); err != nil {
+		return "", err
+	}
+
+	var preflight []preflightResult
+	for _, c := range runPreflightChecks(cfg, cfg.QueueMode != queuePostgres) {
+		r := preflightResult{Name: c.Name, OK: c.Err == nil}
+		if c.Err != nil {
+			r.Error = c.Err.Error()
+		}
+		preflight = append(preflight, r)
+	}
+	if err := addJSON("preflight.json", preflight); err != nil {
+		return "", err
+	}
+
+	if err := add("versions.txt", scannerVersions()); err != nil {
+		return "", err
+	}
+
+	for _, r := range results {
+		if r.LogDir == "" {
+			continue
+		}
+		logs, _ := filepath.Glob(filepath.Join(r.LogDir, "*.log"))
+		for _, logFile := range logs {
+			tail, err := tailFile(logFile, diagnosticsLogLines)
+			if err != nil {
+				continue
+			}
+			if err := add(filepath.Join("logs", r.Variant, filepath.Base(logFile)), tail); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// collectDiagnostics writes the diagnostics bundle for a failed run, returning its
+// path or "" if it could not be written
+func collectDiagnostics(cfg *Config, runID string, results []VariantResult) string {
+	path, err := writeDiagnosticsBundle(cfg, runID, results)
+	if err != nil {
+		log.Printf("⚠️  Could not write diagnostics bundle for run %s: %v", runID, err)
+		return ""
+	}
+	log.Printf("🩺 Diagnostics bundle written to %s", path)
+	return path
+}
+
+// scannerVersions runs each version command, recording failures instead of aborting
+func scannerVersions() []byte {
+	var buf bytes.Buffer
+	for _, args := range scannerVersionCommands {
+		fmt.Fprintf(&buf, "$ %s\n", strings.Join(args, " "))
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		buf.Write(out)
+		if err != nil {
+			fmt.Fprintf(&buf, "error: %v\n", err)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// tailFile returns the last n lines of a file
+func tailFile(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
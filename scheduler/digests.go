@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// imageDigestsFile records, per image, the digest and scanner databases of its last scan
+const imageDigestsFile = ".image-digests.json"
+
+// digestResolveTimeout bounds looking up the digest of one image
+const digestResolveTimeout = 30 * time.Second
+
+// digestResolvers look up an image digest, trying the local Docker daemon before
+// the registry; images none of them resolve are always scanned
+var digestResolvers = [][]string{
+	{"docker", "image", "inspect", "--format", "{{.Id}}"},
+	{"crane", "digest"},
+	{"skopeo", "inspect", "--format", "{{.Digest}}", "--no-tags"},
+}
+
+// ImageDigest is what an image's last report was scanned from
+type ImageDigest struct {
+	Digest string `json:"digest"`
+	// DBVersion identifies the Trivy and Grype databases the image was scanned with
+	DBVersion string    `json:"db_version"`
+	RunID     string    `json:"run_id"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+// readImageDigests returns the digests recorded for a variant's images
+func readImageDigests(variant string) (map[string]ImageDigest, error) {
+	data, err := os.ReadFile(filepath.Join(variantDir(variant), imageDigestsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]ImageDigest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[string]ImageDigest)
+	if err := json.Unmarshal(data, &digests); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", imageDigestsFile, err)
+	}
+	return digests, nil
+}
+
+// resolveImageDigest returns the digest of an image, or "" when it can't be resolved
+func resolveImageDigest(image string, env []string) string {
+	return resolveDigestWith(digestResolvers, image, env)
+}
+
+// registryDigest returns the manifest digest of an image in its registry, or "";
+// unlike the local Docker daemon's image ID, that digest can be referenced remotely
+func registryDigest(image string, env []string) string {
+	return resolveDigestWith(digestResolvers[1:], image, env)
+}
+
+func resolveDigestWith(resolvers [][]string, image string, env []string) string {
+	for _, resolver := range resolvers {
+		// Registry lookups need network access
+		if offlineMode && resolver[0] != "docker" {
+			continue
+		}
+		if _, err := exec.LookPath(resolver[0]); err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), digestResolveTimeout)
+		target := image
+		if resolver[0] == "skopeo" {
+			target = "docker://" + image
+		}
+		cmd := exec.CommandContext(ctx, resolver[0], append(resolver[1:], target)...)
+		cmd.Env = append(os.Environ(), env...)
+		out, err := cmd.Output()
+		cancel()
+		if digest := strings.TrimSpace(string(out)); err == nil && strings.HasPrefix(digest, "sha256:") {
+			return digest
+		}
+	}
+	return ""
+}
+
+// unchangedImages resolves the digest of each image and returns the images whose
+// digest and scanner databases match their last scan, along with the digests to
+// record for the others once they are scanned
+func (j *ScanJob) unchangedImages(images []string, env []string) ([]string, map[string]ImageDigest) {
+	dbVersion := scannerDBFingerprint(j.ScannerDBs)
+	if dbVersion == "" {
+		j.Log.Printf("[%s] ⚠️  Could not read the scanner database versions, rescanning every image", j.Variant)
+		return nil, nil
+	}
+	previous, err := readImageDigests(j.Variant)
+	if err != nil {
+		j.Log.Printf("[%s] ⚠️  Could not read the recorded image digests: %v", j.Variant, err)
+		previous = map[string]ImageDigest{}
+	}
+
+	var unchanged []string
+	scanned := make(map[string]ImageDigest)
+	now := time.Now().UTC()
+	for _, image := range images {
+		digest := resolveImageDigest(image, env)
+		if digest == "" {
+			j.Log.Printf("[%s] ⚠️  Could not resolve the digest of %s, rescanning it", j.Variant, image)
+			continue
+		}
+		last, ok := previous[image]
+		reports, err := imageReportFiles(j.Variant, image)
+		if ok && !j.Config.ForceRescan && err == nil && len(reports) > 0 && last.Digest == digest && last.DBVersion == dbVersion {
+			unchanged = append(unchanged, image)
+			continue
+		}
+		scanned[image] = ImageDigest{Digest: digest, DBVersion: dbVersion, RunID: j.RunID, ScannedAt: now}
+	}
+	return unchanged, scanned
+}
+
+// recordImageDigests adds the digests of the images scanned by this run, leaving out
+// those the cycle time budget skipped and those that failed
+func (j *ScanJob) recordImageDigests(scanned map[string]ImageDigest) error {
+	if len(scanned) == 0 {
+		return nil
+	}
+	digests, err := readImageDigests(j.Variant)
+	if err != nil {
+		digests = map[string]ImageDigest{}
+	}
+	skipped, err := readSkippedImages(j.Variant)
+	if err != nil {
+		return err
+	}
+	failed, err := readFailedImages(j.Variant)
+	if err != nil {
+		return err
+	}
+	notScanned := make(map[string]bool, len(skipped)+len(failed))
+	for _, image := range skipped {
+		notScanned[image] = true
+	}
+	for _, f := range failed {
+		notScanned[f.Image] = true
+	}
+	for image, d := range scanned {
+		if !notScanned[image] {
+			digests[image] = d
+		}
+	}
+	return writeJSONFile(filepath.Join(variantDir(j.Variant), imageDigestsFile), digests)
+}
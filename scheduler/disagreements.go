@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const disagreementHistoryFile = "disagreements-history.jsonl"
+
+// Kinds of disagreement between Trivy and Grype
+const (
+	disagreementTrivyOnly = "trivy-only"
+	disagreementGrypeOnly = "grype-only"
+	disagreementSeverity  = "severity-mismatch"
+)
+
+// grypeReport is the subset of Grype's JSON output the scheduler reads
+type grypeReport struct {
+	Matches []struct {
+		Vulnerability struct {
+			ID                     string `json:"id"`
+			Severity               string `json:"severity"`
+			RelatedVulnerabilities []struct {
+				ID string `json:"id"`
+			} `json:"relatedVulnerabilities"`
+		} `json:"vulnerability"`
+		Artifact struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"artifact"`
+	} `json:"matches"`
+}
+
+// ScannerDisagreement is a finding where Trivy and Grype do not agree
+type ScannerDisagreement struct {
+	Image         string `json:"image"`
+	CVE           string `json:"cve"`
+	Package       string `json:"package"`
+	Version       string `json:"version"`
+	Kind          string `json:"kind"`
+	TrivySeverity string `json:"trivy_severity,omitempty"`
+	GrypeSeverity string `json:"grype_severity,omitempty"`
+}
+
+// DisagreementStats counts agreement between the scanners
+type DisagreementStats struct {
+	Agreed           int `json:"agreed"`
+	TrivyOnly        int `json:"trivy_only"`
+	GrypeOnly        int `json:"grype_only"`
+	SeverityMismatch int `json:"severity_mismatch"`
+}
+
+// DisagreementReport is written to /reports/{variant}/disagreements.json after each scan
+type DisagreementReport struct {
+	Variant     string    `json:"variant"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Images      int       `json:"images"`
+	DisagreementStats
+	Findings []ScannerDisagreement `json:"findings"`
+}
+
+// DisagreementHistoryEntry is one line of /reports/disagreements-history.jsonl
+type DisagreementHistoryEntry struct {
+	Variant     string    `json:"variant"`
+	GeneratedAt time.Time `json:"generated_at"`
+	DisagreementStats
+}
+
+// findingKey matches findings across scanners the same way merge-scan-results.py does:
+// by CVE and normalized package name, as the scanners format versions differently
+type findingKey struct {
+	cve, pkg string
+}
+
+// scannerFinding is one scanner's rating of a finding
+type scannerFinding struct {
+	version, severity string
+}
+
+// severityAliases maps the severities Grype takes from distro feeds onto Trivy's
+var severityAliases = map[string]string{"NEGLIGIBLE": "LOW", "MODERATE": "MEDIUM", "IMPORTANT": "HIGH"}
+
+// normalizeSeverity mirrors normalize_severity in merge-scan-results.py
+func normalizeSeverity(severity string) string {
+	severity = strings.ToUpper(severity)
+	if alias, ok := severityAliases[severity]; ok {
+		return alias
+	}
+	if severityRank(severity) == len(severityOrder) {
+		return "UNKNOWN"
+	}
+	return severity
+}
+
+func newFindingKey(cve, pkg string) findingKey {
+	return findingKey{cve, strings.ReplaceAll(strings.ToLower(strings.TrimSpace(pkg)), "_", "-")}
+}
+
+// addFinding records a scanner's rating, keeping the worst when it reports the finding twice
+func addFinding(findings map[findingKey]scannerFinding, key findingKey, f scannerFinding) {
+	if prev, ok := findings[key]; ok && severityRank(prev.severity) <= severityRank(f.severity) {
+		return
+	}
+	findings[key] = f
+}
+
+// buildDisagreementReport compares the raw Trivy and Grype outputs of every image in a variant
+func buildDisagreementReport(variant string) (*DisagreementReport, error) {
+	trivyFiles, err := filepath.Glob(filepath.Join(variantDir(variant), "*_trivy_scan.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(trivyFiles)
+
+	report := &DisagreementReport{Variant: variant, GeneratedAt: time.Now().UTC()}
+	for _, trivyFile := range trivyFiles {
+		grypeFile := strings.TrimSuffix(trivyFile, "_trivy_scan.json") + "_grype_scan.json"
+		if _, err := os.Stat(grypeFile); err != nil {
+			continue
+		}
+
+		trivy, err := readTrivyReport(trivyFile)
+		if err != nil {
+			return nil, err
+		}
+		grype, err := readGrypeReport(grypeFile)
+		if err != nil {
+			return nil, err
+		}
+
+		image := trivy.ArtifactName
+		if image == "" {
+			image = strings.TrimSuffix(filepath.Base(trivyFile), "_trivy_scan.json")
+		}
+		report.Images++
+		compareScannerFindings(report, image, trivy, grype)
+	}
+
+	return report, nil
+}
+
+func compareScannerFindings(report *DisagreementReport, image string, trivy *TrivyReport, grype *grypeReport) {
+	trivySev := make(map[findingKey]scannerFinding)
+	for _, result := range trivy.Results {
+		for _, v := range result.Vulnerabilities {
+			addFinding(trivySev, newFindingKey(v.VulnerabilityID, v.PkgName), scannerFinding{v.InstalledVersion, normalizeSeverity(v.Severity)})
+		}
+	}
+
+	grypeSev := make(map[findingKey]scannerFinding)
+	for _, m := range grype.Matches {
+		// Grype reports distro and GitHub advisories with the CVE among the related ones
+		cve := m.Vulnerability.ID
+		if !strings.HasPrefix(cve, "CVE-") {
+			for _, related := range m.Vulnerability.RelatedVulnerabilities {
+				if strings.HasPrefix(related.ID, "CVE-") {
+					cve = related.ID
+					break
+				}
+			}
+		}
+		addFinding(grypeSev, newFindingKey(cve, m.Artifact.Name), scannerFinding{m.Artifact.Version, normalizeSeverity(m.Vulnerability.Severity)})
+	}
+
+	for key, t := range trivySev {
+		g, found := grypeSev[key]
+		switch {
+		case !found:
+			report.TrivyOnly++
+			report.Findings = append(report.Findings, ScannerDisagreement{
+				Image: image, CVE: key.cve, Package: key.pkg, Version: t.version,
+				Kind: disagreementTrivyOnly, TrivySeverity: t.severity,
+			})
+		case g.severity != t.severity:
+			report.SeverityMismatch++
+			report.Findings = append(report.Findings, ScannerDisagreement{
+				Image: image, CVE: key.cve, Package: key.pkg, Version: t.version,
+				Kind: disagreementSeverity, TrivySeverity: t.severity, GrypeSeverity: g.severity,
+			})
+		default:
+			report.Agreed++
+		}
+	}
+	for key, g := range grypeSev {
+		if _, found := trivySev[key]; !found {
+			report.GrypeOnly++
+			report.Findings = append(report.Findings, ScannerDisagreement{
+				Image: image, CVE: key.cve, Package: key.pkg, Version: g.version,
+				Kind: disagreementGrypeOnly, GrypeSeverity: g.severity,
+			})
+		}
+	}
+
+	sort.Slice(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if a.Image != b.Image {
+			return a.Image < b.Image
+		}
+		if a.CVE != b.CVE {
+			return a.CVE < b.CVE
+		}
+		return a.Package < b.Package
+	})
+}
+
+func readGrypeReport(path string) (*grypeReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &grypeReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// writeDisagreementReport stores the per-cycle report and appends its totals to the history
+func writeDisagreementReport(report *DisagreementReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(variantDir(report.Variant), "disagreements.json"), data, 0o644); err != nil {
+		return err
+	}
+
+	entry, err := json.Marshal(DisagreementHistoryEntry{
+		Variant:           report.Variant,
+		GeneratedAt:       report.GeneratedAt,
+		DisagreementStats: report.DisagreementStats,
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(reportsPath, disagreementHistoryFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(entry, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readDisagreementHistory returns history entries newer than since, oldest first
+func readDisagreementHistory(since time.Time) ([]DisagreementHistoryEntry, error) {
+	f, err := os.Open(filepath.Join(reportsPath, disagreementHistoryFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []DisagreementHistoryEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e DisagreementHistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.GeneratedAt.After(since) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "errors"
+
+// diskFree can't measure free space on this platform; the disk check is skipped
+func diskFree(string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultMinFreeDisk is the free space a cycle needs by default (MIN_FREE_DISK)
+const defaultMinFreeDisk = 1 << 30
+
+// staleArtifactAge is how old a temporary artifact must be to be removed before a
+// cycle: older than any step, so none is still in use
+const staleArtifactAge = 24 * time.Hour
+
+// reportTempPatterns match the temporary files steps leave in the reports directory
+// and its variant directories when they are killed midway
+var reportTempPatterns = []string{"*.tmp", ".sbom-*", ".misconfig-*", ".checkov-*", ".preflight-*"}
+
+// tempDirPatterns match the temporary directories of scans in the system temp
+// directory: scan scratch space, registry credentials, sandbox scans, and the image
+// layers Grype unpacks (stereoscope), left behind by killed scans
+var tempDirPatterns = []string{"vuln-scan-*", "registry-auth-*", "sandbox-scan-*", "stereoscope-*"}
+
+// DiskLocation is a directory the scans write to, with its free space
+type DiskLocation struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Free int64  `json:"free_bytes"`
+}
+
+// scanDiskLocations lists the directories a cycle fills: the reports, and when scans
+// run in this process the scanner caches and the temp directory images are unpacked to
+func scanDiskLocations(scans bool) []DiskLocation {
+	locations := []DiskLocation{{Name: "reports directory", Path: reportsPath}}
+	if !scans {
+		return locations
+	}
+	cache, _ := os.UserCacheDir()
+	trivy := os.Getenv("TRIVY_CACHE_DIR")
+	if trivy == "" && cache != "" {
+		trivy = filepath.Join(cache, "trivy")
+	}
+	grype := os.Getenv("GRYPE_DB_CACHE_DIR")
+	if grype == "" && cache != "" {
+		grype = filepath.Join(cache, "grype", "db")
+	}
+	for _, l := range []DiskLocation{{Name: "trivy cache", Path: trivy}, {Name: "grype cache", Path: grype}, {Name: "temp directory", Path: os.TempDir()}} {
+		if l.Path != "" {
+			locations = append(locations, l)
+		}
+	}
+	return locations
+}
+
+// checkDiskSpace measures the free space of each location, returning an error naming
+// the ones below minFree. A location that doesn't exist yet is measured at the
+// nearest directory above it that does.
+func checkDiskSpace(locations []DiskLocation, minFree int64) ([]DiskLocation, error) {
+	var errs []error
+	for i, l := range locations {
+		free, err := diskFree(existingParent(l.Path))
+		if errors.Is(err, errors.ErrUnsupported) {
+			return locations, nil
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not measure the free space of the %s %s: %w", l.Name, l.Path, err))
+			continue
+		}
+		locations[i].Free = free
+		if free < minFree {
+			errs = append(errs, fmt.Errorf("only %s free for the %s %s, below MIN_FREE_DISK (%s)",
+				formatBytes(free), l.Name, l.Path, formatBytes(minFree)))
+		}
+	}
+	return locations, errors.Join(errs...)
+}
+
+// existingParent returns path, or its nearest ancestor that exists
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// cleanTempArtifacts removes the temporary files and directories that killed steps
+// left behind, returning how many it removed and the space they took
+func cleanTempArtifacts(scans bool) (int, int64) {
+	var paths []string
+	dirs := []string{reportsPath}
+	if entries, err := os.ReadDir(reportsPath); err == nil {
+		for _, e := range entries {
+			if e.IsDir() {
+				dirs = append(dirs, filepath.Join(reportsPath, e.Name()))
+			}
+		}
+	}
+	// The report directories of the tenants' variants
+	tenantDirs, _ := filepath.Glob(filepath.Join(reportsPath, tenantsDir, "*", "*"))
+	dirs = append(dirs, tenantDirs...)
+	for _, dir := range dirs {
+		for _, pattern := range reportTempPatterns {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			paths = append(paths, matches...)
+		}
+	}
+	if scans {
+		for _, pattern := range tempDirPatterns {
+			matches, _ := filepath.Glob(filepath.Join(os.TempDir(), pattern))
+			paths = append(paths, matches...)
+		}
+	}
+
+	removed, freed := 0, int64(0)
+	cutoff := time.Now().Add(-staleArtifactAge)
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		size := info.Size()
+		if info.IsDir() {
+			size = dirSize(path)
+		}
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("⚠️  Could not remove %s: %v", path, err)
+			continue
+		}
+		removed++
+		freed += size
+	}
+	return removed, freed
+}
+
+// prepareDisk cleans up stale temporary artifacts and checks that the directories a
+// cycle writes to have MIN_FREE_DISK free, so a full disk stops the cycle up front
+// rather than failing a scan or a report write midway
+func prepareDisk(cfg *Config, scans bool, logger *log.Logger) error {
+	if !cfg.DryRun {
+		if n, freed := cleanTempArtifacts(scans); n > 0 {
+			logger.Printf("🧹 Removed %d stale temporary artifact(s), freeing %s", n, formatBytes(freed))
+		}
+	}
+	if cfg.MinFreeDisk <= 0 {
+		return nil
+	}
+	locations, err := checkDiskSpace(scanDiskLocations(scans), cfg.MinFreeDisk)
+	if err != nil && cfg.DryRun {
+		logger.Printf("🧪 The cycle would stop, not enough disk space: %v", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("not enough disk space: %w", err)
+	}
+	var free []string
+	for _, l := range locations {
+		if l.Free > 0 {
+			free = append(free, fmt.Sprintf("%s %s", l.Name, formatBytes(l.Free)))
+		}
+	}
+	if len(free) > 0 {
+		logger.Printf("💾 Free disk space: %s", strings.Join(free, ", "))
+	}
+	return nil
+}
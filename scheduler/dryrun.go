@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// secretEnvName matches environment variables whose values are not logged
+var secretEnvName = regexp.MustCompile(`(?i)(PASSWORD|SECRET|TOKEN|KEY)`)
+
+// safeShellArg matches arguments that need no quoting to be pasted into a shell
+var safeShellArg = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// logPlannedCommand logs what runLogged would execute with DRY_RUN: the full argv and
+// the environment variables the command gets on top of the scheduler's own
+func (j *ScanJob) logPlannedCommand(step string, cmd *exec.Cmd) {
+	j.Log.Printf("[%s] 🧪 Would run %s: %s", j.Variant, step, shellJoin(cmd.Args))
+	if cmd.Dir != "" {
+		j.Log.Printf("[%s]      in %s", j.Variant, cmd.Dir)
+	}
+	for _, kv := range envDiff(os.Environ(), cmd.Env, j.SecretEnv) {
+		j.Log.Printf("[%s]      env %s", j.Variant, kv)
+	}
+}
+
+// envDiff returns the entries of env that are missing from or differ in base, with
+// secret values and those of the secret names redacted. A nil env inherits base unchanged.
+func envDiff(base, env []string, secret map[string]bool) []string {
+	inherited := make(map[string]bool, len(base))
+	for _, kv := range base {
+		inherited[kv] = true
+	}
+	var diff []string
+	for _, kv := range env {
+		if inherited[kv] {
+			continue
+		}
+		if name, _, _ := strings.Cut(kv, "="); secret[name] || secretEnvName.MatchString(name) {
+			kv = name + "=" + redacted
+		}
+		diff = append(diff, kv)
+	}
+	return diff
+}
+
+// shellJoin renders argv the way it would be typed in a shell
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if safeShellArg.MatchString(arg) {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// logPlannedImages logs the images a variant would scan, in scan order, and those
+// exclusion rules would keep out
+func logPlannedImages(logger *log.Logger, variant string, images []string, excluded []ExcludedImage, err error) {
+	if err != nil {
+		logger.Printf("[%s] ⚠️  %v", variant, err)
+		return
+	}
+	logger.Printf("[%s] 🧪 Would scan %d image(s):", variant, len(images))
+	for _, image := range images {
+		logger.Printf("[%s]      %s", variant, image)
+	}
+	for _, e := range excluded {
+		logger.Printf("[%s] 🧪 Would exclude %s: %s", variant, e.Image, describeExclusion(e))
+	}
+}
+
+// planScanPreparation logs what runs before the scan with DRY_RUN
+func (j *ScanJob) planScanPreparation() {
+	if j.Config.ScannerDBMaxAge > 0 && !j.Config.Offline {
+		j.Log.Printf("[%s] 🧪 Would update scanner databases older than %s", j.Variant, j.Config.ScannerDBMaxAge)
+	}
+	if j.Config.SkipUnchanged && !j.Config.ForceRescan {
+		j.Log.Printf("[%s] 🧪 Would keep the reports of images whose digest and scanner databases are unchanged", j.Variant)
+	}
+}
+
+// planSinks logs where the publish step would send the results with DRY_RUN
+func (j *ScanJob) planSinks() {
+	for _, c := range j.Config.Sinks {
+		if !c.accepts(j.Variant) {
+			continue
+		}
+		switch c.Type {
+		case sinkDatabase:
+			j.logPlannedCommand("load", j.loadCommand())
+		case sinkFile:
+			j.Log.Printf("[%s] 🧪 Would write sink %s to %s", j.Variant, c.DisplayName(),
+				filepath.Join(c.Path, j.Variant, j.RunID+".json"))
+		case sinkWebhook:
+			j.Log.Printf("[%s] 🧪 Would post sink %s to %s", j.Variant, c.DisplayName(), redactURL(c.URL))
+		case sinkS3, sinkGCS:
+			store := sinkFactories[c.Type](c).(objectStoreSink)
+			j.Log.Printf("[%s] 🧪 Would upload the reports to sink %s at %s", j.Variant, c.DisplayName(),
+				store.store.URL(objectKey(c.Prefix, j.RunID, j.Variant, "")+"/"))
+		case sinkDefectDojo:
+			j.Log.Printf("[%s] 🧪 Would import the reports into DefectDojo at %s (sink %s)", j.Variant, redactURL(c.URL), c.DisplayName())
+		case sinkDependencyTrack:
+			j.Log.Printf("[%s] 🧪 Would upload SBOMs to Dependency-Track at %s (sink %s)", j.Variant, redactURL(c.URL), c.DisplayName())
+		default:
+			j.Log.Printf("[%s] 🧪 Would publish to sink %s (%s)", j.Variant, c.DisplayName(), c.Type)
+		}
+	}
+}
+
+// planFollowUps logs what happens after the pipeline with DRY_RUN
+func (j *ScanJob) planFollowUps() {
+	if j.Config.Jira.Enabled() {
+		j.Log.Printf("[%s] 🧪 Would file a Jira ticket in %s if severity thresholds are breached", j.Variant, j.Config.Jira.Project)
+	}
+	if j.Config.GitHubIssues.Enabled() {
+		j.Log.Printf("[%s] 🧪 Would open GitHub issues in %s for new critical/high CVEs", j.Variant, j.Config.GitHubIssues.Repo)
+	}
+}
+
+// dryRunNote is logged instead of side effects outside the scan pipeline
+func dryRunNote(format string, args ...any) {
+	log.Printf("🧪 DRY_RUN: "+format, args...)
+}
+
+// dryRunBanner announces that nothing will be executed
+func dryRunBanner(logger *log.Logger) {
+	logger.Printf("🧪 DRY_RUN=true: logging the planned commands and images, nothing is executed")
+	logger.Printf("🧪 Scripts: %s   Reports: %s", scriptsPath, reportsPath)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Attachments of the cycle emails
+const (
+	attachSummary = "summary"
+	attachReport  = "report"
+	attachDiff    = "diff"
+)
+
+var allEmailAttachments = []string{attachSummary, attachReport, attachDiff}
+
+// The diff report compares these variants, when a cycle scanned both successfully
+const (
+	emailDiffFrom = "baseline"
+	emailDiffTo   = "chainguard"
+)
+
+// EmailConfig delivers the cycle summary by email over SMTP
+type EmailConfig struct {
+	SMTPHost string
+	SMTPPort int
+	Username string
+	Password string
+	From     string
+	// Recipients are the lists a cycle's summary is sent to; email is disabled without one
+	Recipients []EmailRecipients
+}
+
+// EmailRecipients is a recipient list with its own delivery settings
+type EmailRecipients struct {
+	Name string   `json:"name"`
+	To   []string `json:"to"`
+	// On is "always" (default) or "failure" to only send failed and partial cycles
+	On string `json:"on"`
+	// Attachments picks from "summary", "report" and "diff"; unset attaches all three
+	Attachments []string `json:"attachments"`
+}
+
+// Enabled reports whether cycle summaries are emailed
+func (c EmailConfig) Enabled() bool {
+	return len(c.Recipients) > 0
+}
+
+// Validate reports missing SMTP settings and malformed recipient lists
+func (c EmailConfig) Validate() []error {
+	if !c.Enabled() {
+		return nil
+	}
+	var errs []error
+	if c.SMTPHost == "" {
+		errs = append(errs, fmt.Errorf("email recipients need an SMTP_HOST"))
+	}
+	if c.SMTPPort <= 0 || c.SMTPPort > 65535 {
+		errs = append(errs, fmt.Errorf("invalid SMTP_PORT %d", c.SMTPPort))
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		errs = append(errs, fmt.Errorf("invalid EMAIL_FROM %q: %v", c.From, err))
+	}
+	for i, r := range c.Recipients {
+		name := r.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i+1)
+		}
+		if len(r.To) == 0 {
+			errs = append(errs, fmt.Errorf("email recipient list %s has no addresses", name))
+		}
+		for _, addr := range r.To {
+			if _, err := mail.ParseAddress(addr); err != nil {
+				errs = append(errs, fmt.Errorf("email recipient list %s has an invalid address %q", name, addr))
+			}
+		}
+		switch r.On {
+		case "", notifyAlways, notifyOnFailure:
+		default:
+			errs = append(errs, fmt.Errorf("email recipient list %s: invalid on=%q: must be %q or %q", name, r.On, notifyAlways, notifyOnFailure))
+		}
+		for _, a := range r.Attachments {
+			if a != attachSummary && a != attachReport && a != attachDiff {
+				errs = append(errs, fmt.Errorf("email recipient list %s: unknown attachment %q (known: %s)", name, a, strings.Join(allEmailAttachments, ", ")))
+			}
+		}
+	}
+	return errs
+}
+
+// emailAttachment is a file attached to a cycle email
+type emailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// emailCycle sends the cycle summary to every recipient list that wants it
+func emailCycle(cfg EmailConfig, cycle *CycleResult) {
+	if !cfg.Enabled() {
+		return
+	}
+	body, attachments, err := renderCycleEmail(cycle)
+	if err != nil {
+		log.Printf("⚠️  Failed to render the cycle email: %v", err)
+		return
+	}
+	subject := fmt.Sprintf("[vuln-demo] Scan cycle %s: %s", cycle.RunID, cycle.Status)
+	if cycle.Tenant != "" {
+		subject = fmt.Sprintf("[vuln-demo] %s scan cycle %s: %s", cycle.Tenant, cycle.RunID, cycle.Status)
+	}
+
+	for _, r := range cfg.Recipients {
+		if cycle.Status == cycleSuccess && r.On == notifyOnFailure {
+			continue
+		}
+		wanted := r.Attachments
+		if wanted == nil {
+			wanted = allEmailAttachments
+		}
+		var attach []emailAttachment
+		for _, name := range wanted {
+			if a, ok := attachments[name]; ok {
+				attach = append(attach, a)
+			}
+		}
+
+		msg, err := buildEmail(cfg.From, r.To, subject, body, attach)
+		if err == nil {
+			err = sendEmail(cfg, r.To, msg)
+		}
+		if err != nil {
+			log.Printf("⚠️  Failed to email the cycle summary to %s: %v", strings.Join(r.To, ", "), err)
+			continue
+		}
+		log.Printf("📧 Cycle summary emailed to %s", strings.Join(r.To, ", "))
+	}
+}
+
+// cycleEmailData feeds cycleEmailTemplate
+type cycleEmailData struct {
+	Cycle      *CycleResult
+	Severities []string
+	Diff       *DiffReport
+}
+
+var cycleEmailTemplate = template.Must(template.New("email").Funcs(template.FuncMap{
+	"percent":   func(f float64) string { return strconv.FormatFloat(f, 'f', 1, 64) },
+	"join":      strings.Join,
+	"hasPrefix": strings.HasPrefix,
+}).Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>Scan cycle {{.Cycle.RunID}}: {{.Cycle.Status}}</h2>
+<p>{{.Cycle.StartedAt.Format "2006-01-02 15:04 MST"}} to {{.Cycle.FinishedAt.Format "15:04 MST"}}</p>
+{{with .Cycle.ExecutiveReport}}<p>📈 Executive summary: {{if hasPrefix . "http"}}<a href="{{.}}">{{.}}</a>{{else}}<code>{{.}}</code>{{end}}</p>{{end}}
+<table border="1" cellpadding="4" cellspacing="0" style="border-collapse: collapse">
+<tr><th>Variant</th><th>Status</th><th>Images</th>{{range .Severities}}<th>{{.}}</th>{{end}}<th>Total</th><th>Fix available</th><th>New CVEs</th></tr>
+{{range .Cycle.Variants}}{{$v := .}}<tr>
+<td>{{.Variant}}</td><td>{{if .Success}}✅{{else}}❌ {{.Error}}{{end}}</td><td>{{.Images}}</td>
+{{range $.Severities}}<td>{{index $v.Severities .}}</td>{{end}}<td>{{.Vulnerabilities}}</td><td>{{.FixAvailable}}</td><td>{{join .NewCVEs ", "}}</td>
+</tr>{{end}}
+</table>
+{{range .Cycle.Variants}}{{if .Violations}}<p>🚨 <b>{{.Variant}}</b> breached its severity thresholds:{{range .Violations}} {{.Severity}} {{.Count}} (max {{.Max}}){{end}}</p>{{end}}{{end}}
+{{range .Cycle.Variants}}{{if .BaselineRegression}}<p>🚨 <b>{{.Variant}}</b> regressed against its golden baseline run {{.BaselineRegression.BaselineRunID}}: {{.BaselineRegression}}</p>{{end}}{{end}}
+{{range .Cycle.Variants}}{{if .ScannerChanges}}<p>🔧 <b>{{.Variant}}</b> was scanned with other scanner versions than its previous scan ({{range $i, $c := .ScannerChanges}}{{if $i}}, {{end}}{{$c}}{{end}}): finding counts may have changed with the scanners rather than the images</p>{{end}}{{end}}
+{{range .Cycle.Variants}}{{if .LicenseViolations}}<p>🚫 <b>{{.Variant}}</b> has {{.LicenseViolations}} package(s) under a denied license</p>{{end}}{{end}}
+{{with .Diff}}
+<h3>{{.From}} vs {{.To}}</h3>
+<table border="1" cellpadding="4" cellspacing="0" style="border-collapse: collapse">
+<tr><th>Severity</th><th>{{.From}}</th><th>{{.To}}</th></tr>
+{{range $.Severities}}<tr><td>{{.}}</td><td>{{(index $.Diff.Severities .).From}}</td><td>{{(index $.Diff.Severities .).To}}</td></tr>{{end}}
+<tr><td><b>Total</b></td><td><b>{{.FromTotal}}</b></td><td><b>{{.ToTotal}}</b></td></tr>
+</table>
+<p>Reduction: <b>{{percent .ReductionPct}}%</b>. Unique CVEs only in {{.From}}: {{.OnlyInFrom}}, only in {{.To}}: {{.OnlyInTo}}, in both: {{.Common}}.</p>
+{{if or .FromLicenseViolations .ToLicenseViolations}}<p>License violations in {{.From}}: {{.FromLicenseViolations}}, in {{.To}}: {{.ToLicenseViolations}}.</p>{{end}}
+{{end}}
+</body></html>
+`))
+
+// renderCycleEmail renders the HTML body and the attachments of a cycle email
+func renderCycleEmail(cycle *CycleResult) (string, map[string]emailAttachment, error) {
+	attachments := make(map[string]emailAttachment)
+
+	summary, err := json.MarshalIndent(cycle, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	attachments[attachSummary] = emailAttachment{Name: "cycle-" + cycle.RunID + ".json", ContentType: "application/json", Data: summary}
+
+	var reports []*VariantReport
+	scanned := make(map[string]bool)
+	for _, v := range cycle.Variants {
+		scanned[v.Variant] = v.Success
+		if vr, err := buildVariantReport(v.Variant); err == nil {
+			reports = append(reports, vr)
+		}
+	}
+	if len(reports) > 0 {
+		var buf bytes.Buffer
+		if err := writeVariantReportsMarkdown(&buf, reports); err != nil {
+			return "", nil, err
+		}
+		attachments[attachReport] = emailAttachment{Name: "report-" + cycle.RunID + ".md", ContentType: "text/markdown; charset=utf-8", Data: buf.Bytes()}
+	}
+
+	data := cycleEmailData{Cycle: cycle, Severities: severityOrder}
+	if scanned[emailDiffFrom] && scanned[emailDiffTo] {
+		if diff, err := buildDiffReport(emailDiffFrom, emailDiffTo); err != nil {
+			log.Printf("⚠️  Could not compare %s and %s for the cycle email: %v", emailDiffFrom, emailDiffTo, err)
+		} else {
+			data.Diff = diff
+			var buf bytes.Buffer
+			if err := writeDiffText(&buf, diff); err != nil {
+				return "", nil, err
+			}
+			attachments[attachDiff] = emailAttachment{Name: "diff-" + cycle.RunID + ".txt", ContentType: "text/plain; charset=utf-8", Data: buf.Bytes()}
+		}
+	}
+
+	var body bytes.Buffer
+	if err := cycleEmailTemplate.Execute(&body, data); err != nil {
+		return "", nil, err
+	}
+	return body.String(), attachments, nil
+}
+
+// buildEmail assembles a multipart/mixed message with an HTML body and attachments
+func buildEmail(from string, to []string, subject, html string, attachments []emailAttachment) ([]byte, error) {
+	var msg bytes.Buffer
+	mw := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%q\r\n\r\n",
+		from, strings.Join(to, ", "), mime.QEncoding.Encode("utf-8", subject), time.Now().Format(time.RFC1123Z), mw.Boundary())
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(html)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, a := range attachments {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// sendEmail delivers a message, with implicit TLS on port 465 and STARTTLS
+// whenever the server offers it on other ports
+func sendEmail(cfg EmailConfig, to []string, msg []byte) error {
+	addr := net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort))
+	sender, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.SMTPHost)
+	}
+	if cfg.SMTPPort != 465 {
+		return smtp.SendMail(addr, auth, sender.Address, to, msg)
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", addr, &tls.Config{ServerName: cfg.SMTPHost})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(sender.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
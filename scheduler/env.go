@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envString returns the environment variable or def when unset
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envBool returns true only when the variable is set to "true"
+func envBool(name string) bool {
+	return os.Getenv(name) == "true"
+}
+
+// envList splits a comma-separated environment variable, dropping empty entries
+func envList(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envReader parses typed environment variables, collecting every invalid
+// value so configuration problems can be reported together
+type envReader struct {
+	errs []error
+}
+
+// Int parses an integer environment variable
+func (e *envReader) Int(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s %q: must be an integer", name, v))
+		return def
+	}
+	return n
+}
+
+// Int64 parses a 64-bit integer environment variable
+func (e *envReader) Int64(name string, def int64) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s %q: must be an integer", name, v))
+		return def
+	}
+	return n
+}
+
+// Duration parses a Go duration environment variable such as "90s" or "1h"
+func (e *envReader) Duration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s %q: must be a duration like 30m or 1h", name, v))
+		return def
+	}
+	return d
+}
+
+// Bytes parses a size such as "512Mi", "2G" or a plain number of bytes
+func (e *envReader) Bytes(name string, def int64) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := parseByteSize(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s %q: must be a size like 512Mi or 2G", name, v))
+		return def
+	}
+	return n
+}
+
+// Window parses a period such as "90d" or "12h"; unset returns 0
+func (e *envReader) Window(name string) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	d, err := parseWindow(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s %q: must be a period like 90d or 12h", name, v))
+		return 0
+	}
+	return d
+}
+
+// Thresholds parses severity thresholds such as "CRITICAL=0,HIGH=10"; unset returns nil
+func (e *envReader) Thresholds(name string) SeverityThresholds {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	t, err := parseSeverityThresholds(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s: %w", name, err))
+		return nil
+	}
+	return t
+}
+
+// Command parses a command line such as `py -3` into its argv, with double quotes
+// around arguments holding spaces
+func (e *envReader) Command(name, def string) []string {
+	args, err := splitCommandLine(envString(name, def))
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s: %w", name, err))
+		return nil
+	}
+	return args
+}
+
+// Err returns all parse errors encountered so far
+func (e *envReader) Err() error {
+	return errors.Join(e.errs...)
+}
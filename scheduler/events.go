@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Event brokers (EVENTS_BROKER)
+const (
+	brokerKafka = "kafka"
+	brokerNATS  = "nats"
+)
+
+// Event types
+const (
+	eventCycleStarted      = "cycle.started"
+	eventCycleFinished     = "cycle.finished"
+	eventVariantCompleted  = "variant.completed"
+	eventNewCVE            = "cve.new"
+	eventPolicyViolated    = "policy.violated"
+	eventBaselineRegressed = "baseline.regressed"
+)
+
+const (
+	defaultEventsTopic = "vuln-scans"
+	natsTimeout        = 10 * time.Second
+)
+
+// EventsConfig selects the topic scan outcomes are published to. Publishing is off
+// without a URL.
+type EventsConfig struct {
+	// Broker is "kafka", published through a Kafka REST proxy, or "nats"
+	Broker string
+	// URL is the REST proxy's base URL (http://kafka-rest:8082) or the NATS server
+	// (nats://host:4222, tls://host:4222); credentials go in its user info
+	URL string
+	// Topic is the Kafka topic or NATS subject
+	Topic string
+}
+
+// eventsConfigFromEnv reads EVENTS_BROKER, EVENTS_URL and EVENTS_TOPIC
+func eventsConfigFromEnv() EventsConfig {
+	return EventsConfig{
+		Broker: envString("EVENTS_BROKER", brokerKafka),
+		URL:    envString("EVENTS_URL", ""),
+		Topic:  envString("EVENTS_TOPIC", defaultEventsTopic),
+	}
+}
+
+// Validate reports an unknown broker or a URL the broker can't be reached at
+func (ec EventsConfig) Validate() error {
+	if ec.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(ec.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid EVENTS_URL %q", ec.URL)
+	}
+	switch ec.Broker {
+	case brokerKafka:
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid EVENTS_URL %q: the Kafka REST proxy needs an http(s) URL", ec.URL)
+		}
+	case brokerNATS:
+		if u.Scheme != "nats" && u.Scheme != "tls" {
+			return fmt.Errorf("invalid EVENTS_URL %q: must be a nats:// or tls:// URL", ec.URL)
+		}
+	default:
+		return fmt.Errorf("invalid EVENTS_BROKER %q: must be %q or %q", ec.Broker, brokerKafka, brokerNATS)
+	}
+	if ec.Topic == "" || strings.ContainsAny(ec.Topic, " \t\r\n") {
+		return fmt.Errorf("invalid EVENTS_TOPIC %q", ec.Topic)
+	}
+	return nil
+}
+
+// Event is a scan outcome published to the events topic
+type Event struct {
+	Type    string    `json:"type"`
+	RunID   string    `json:"run_id"`
+	Variant string    `json:"variant,omitempty"`
+	Time    time.Time `json:"time"`
+	Data    any       `json:"data,omitempty"`
+}
+
+// events publishes scan outcomes; nil while publishing is off
+var events *EventsConfig
+
+// setupEvents starts publishing events when an events URL is configured
+func setupEvents(ec EventsConfig) {
+	if ec.URL == "" || events != nil {
+		return
+	}
+	events = &ec
+	log.Printf("📣 Publishing scan events to %s %s on %s", ec.Broker, ec.Topic, redactURL(ec.URL))
+}
+
+// publishEvent sends an event to the configured topic. A broker that can't be reached
+// is logged and never fails the scan.
+func publishEvent(eventType, runID, variant string, data any) {
+	if events == nil {
+		return
+	}
+	e := Event{Type: eventType, RunID: runID, Variant: variant, Time: time.Now().UTC(), Data: data}
+	var err error
+	if events.Broker == brokerNATS {
+		err = publishNATS(*events, e)
+	} else {
+		err = publishKafka(*events, e)
+	}
+	if err != nil {
+		log.Printf("⚠️  Could not publish the %s event: %v", eventType, err)
+	}
+}
+
+// publishKafka produces the event through a Kafka REST proxy (Confluent REST Proxy or
+// Redpanda's HTTP proxy), keyed by variant so a variant's events stay in order
+func publishKafka(ec EventsConfig, e Event) error {
+	u, err := url.Parse(ec.URL)
+	if err != nil {
+		return err
+	}
+	user := u.User
+	u.User = nil
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/topics/" + url.PathEscape(ec.Topic)
+
+	key := e.Variant
+	if key == "" {
+		key = e.RunID
+	}
+	body, err := json.Marshal(map[string]any{"records": []any{map[string]any{"key": key, "value": e}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	if user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("REST proxy returned %s", resp.Status)
+	}
+	return nil
+}
+
+// natsInfo is the part of the NATS server greeting the publisher reads
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+// publishNATS publishes the event with the NATS client protocol over a short-lived
+// connection, and waits for the server to acknowledge it with a PONG
+func publishNATS(ec EventsConfig, e Event) error {
+	u, err := url.Parse(ec.URL)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	conn, err := net.DialTimeout("tcp", host, natsTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(natsTimeout))
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading the server greeting: %w", err)
+	}
+	var info natsInfo
+	if op, payload, _ := strings.Cut(strings.TrimSpace(line), " "); op != "INFO" {
+		return fmt.Errorf("unexpected server greeting %q", strings.TrimSpace(line))
+	} else if err := json.Unmarshal([]byte(payload), &info); err != nil {
+		return fmt.Errorf("parsing the server info: %w", err)
+	}
+	if u.Scheme == "tls" || info.TLSRequired {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			return err
+		}
+		conn = tc
+		r = bufio.NewReader(conn)
+	}
+
+	connect := map[string]any{"verbose": false, "pedantic": false, "name": defaultTraceServiceName}
+	if u.User != nil {
+		// A user without a password is a token, as in nats://token@host
+		if password, ok := u.User.Password(); ok {
+			connect["user"], connect["pass"] = u.User.Username(), password
+		} else {
+			connect["auth_token"] = u.User.Username()
+		}
+	}
+	connectJSON, err := json.Marshal(connect)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("CONNECT %s\r\nPUB %s %d\r\n%s\r\nPING\r\n", connectJSON, ec.Topic, len(payload), payload)
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		return err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("waiting for the server: %w", err)
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case line == "PING":
+			conn.Write([]byte("PONG\r\n"))
+		}
+	}
+}
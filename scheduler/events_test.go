@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPublishKafka(t *testing.T) {
+	type record struct {
+		Key   string `json:"key"`
+		Value Event  `json:"value"`
+	}
+	var (
+		mu      sync.Mutex
+		records []record
+		failing = true
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			http.Error(w, "broker unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/rest/topics/vuln-scans" {
+			t.Errorf("path = %s, want /rest/topics/vuln-scans", r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/vnd.kafka.json.v2+json" {
+			t.Errorf("Content-Type = %s", ct)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "svc" || pass != "s3cret" {
+			t.Errorf("basic auth = %q %q %v, want the URL's credentials", user, pass, ok)
+		}
+		var body struct {
+			Records []record `json:"records"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding the records: %v", err)
+		}
+		records = append(records, body.Records...)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ec := EventsConfig{Broker: brokerKafka, URL: strings.Replace(srv.URL, "http://", "http://svc:s3cret@", 1) + "/rest/", Topic: defaultEventsTopic}
+	e := Event{Type: eventVariantCompleted, RunID: "run-1", Variant: "shop", Time: time.Now().UTC()}
+	if err := publishKafka(ec, e); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("err = %v, want the proxy's 503", err)
+	}
+
+	// The proxy is back: the next event goes through
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	if err := publishKafka(ec, e); err != nil {
+		t.Fatal(err)
+	}
+	e.Variant = ""
+	e.Type = eventCycleFinished
+	if err := publishKafka(ec, e); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(records) != 2 || records[0].Key != "shop" || records[1].Key != "run-1" {
+		t.Fatalf("records = %+v, want keys shop then run-1", records)
+	}
+	if records[1].Value.Type != eventCycleFinished || records[1].Value.RunID != "run-1" {
+		t.Errorf("value = %+v", records[1].Value)
+	}
+}
+
+// fakeNATS is a NATS server speaking enough of the client protocol for publishNATS
+type fakeNATS struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	connects []map[string]any
+	messages map[string][]string
+	// drop closes the next connection after the greeting
+	drop bool
+	// reject answers the next PUB with -ERR
+	reject string
+}
+
+func newFakeNATS(t *testing.T, addr string) *fakeNATS {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeNATS{ln: ln, messages: make(map[string][]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"max_payload\":1048576}\r\n")
+	s.mu.Lock()
+	drop := s.drop
+	s.drop = false
+	s.mu.Unlock()
+	if drop {
+		return
+	}
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch op {
+		case "CONNECT":
+			var connect map[string]any
+			json.Unmarshal([]byte(args), &connect)
+			s.mu.Lock()
+			s.connects = append(s.connects, connect)
+			s.mu.Unlock()
+		case "PUB":
+			fields := strings.Fields(args)
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.mu.Lock()
+			reject := s.reject
+			s.reject = ""
+			if reject == "" {
+				s.messages[fields[0]] = append(s.messages[fields[0]], string(payload[:size]))
+			}
+			s.mu.Unlock()
+			if reject != "" {
+				fmt.Fprintf(conn, "-ERR '%s'\r\n", reject)
+				return
+			}
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		}
+	}
+}
+
+func (s *fakeNATS) published(subject string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages[subject]...)
+}
+
+func TestPublishNATS(t *testing.T) {
+	s := newFakeNATS(t, "127.0.0.1:0")
+	ec := EventsConfig{Broker: brokerNATS, URL: "nats://svc:s3cret@" + s.ln.Addr().String(), Topic: "scans.events"}
+	e := Event{Type: eventNewCVE, RunID: "run-1", Variant: "shop", Time: time.Now().UTC(), Data: map[string]any{"cves": []string{"CVE-2024-0001"}}}
+	if err := publishNATS(ec, e); err != nil {
+		t.Fatal(err)
+	}
+	msgs := s.published("scans.events")
+	if len(msgs) != 1 {
+		t.Fatalf("published %d message(s), want 1", len(msgs))
+	}
+	var got Event
+	if err := json.Unmarshal([]byte(msgs[0]), &got); err != nil || got.Type != eventNewCVE || got.Variant != "shop" {
+		t.Errorf("message = %s (%v)", msgs[0], err)
+	}
+	s.mu.Lock()
+	connect := s.connects[0]
+	s.mu.Unlock()
+	if connect["user"] != "svc" || connect["pass"] != "s3cret" || connect["verbose"] != false {
+		t.Errorf("CONNECT = %v, want the URL's credentials and verbose off", connect)
+	}
+
+	// A token is sent as auth_token
+	ec.URL = "nats://tok3n@" + s.ln.Addr().String()
+	if err := publishNATS(ec, e); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	connect = s.connects[1]
+	s.mu.Unlock()
+	if connect["auth_token"] != "tok3n" || connect["user"] != nil {
+		t.Errorf("CONNECT = %v, want auth_token", connect)
+	}
+
+	s.mu.Lock()
+	s.reject = "Permissions Violation for Publish to scans.events"
+	s.mu.Unlock()
+	if err := publishNATS(ec, e); err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Errorf("err = %v, want the server's -ERR", err)
+	}
+}
+
+// TestPublishNATSReconnects publishes each event on a connection of its own, so a
+// dropped connection or a restarted server only costs the event in flight
+func TestPublishNATSReconnects(t *testing.T) {
+	s := newFakeNATS(t, "127.0.0.1:0")
+	addr := s.ln.Addr().String()
+	ec := EventsConfig{Broker: brokerNATS, URL: "nats://" + addr, Topic: "scans"}
+	e := Event{Type: eventCycleStarted, RunID: "run-1", Time: time.Now().UTC()}
+
+	s.mu.Lock()
+	s.drop = true
+	s.mu.Unlock()
+	if err := publishNATS(ec, e); err == nil {
+		t.Fatal("publishing over a dropped connection succeeded")
+	}
+	if err := publishNATS(ec, e); err != nil {
+		t.Fatalf("publishing after a dropped connection: %v", err)
+	}
+
+	// The server restarts on the same address
+	s.ln.Close()
+	if err := publishNATS(ec, e); err == nil {
+		t.Fatal("publishing to a stopped server succeeded")
+	}
+	restarted := newFakeNATS(t, addr)
+	if err := publishNATS(ec, e); err != nil {
+		t.Fatalf("publishing after the server restarted: %v", err)
+	}
+	if got := len(s.published("scans")) + len(restarted.published("scans")); got != 2 {
+		t.Errorf("%d event(s) delivered, want 2", got)
+	}
+	if len(restarted.published("scans")) != 1 {
+		t.Error("the restarted server got no event")
+	}
+}
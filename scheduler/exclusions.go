@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// excludedImagesFile is written to /reports/{variant} before each scan with the images
+// kept out of it by exclusion rules; the scan script, the loader and the reports read it
+const excludedImagesFile = ".excluded-images.json"
+
+// ImageExclusion is an entry of the "exclusions" list of the config file. Image is a
+// glob pattern on the full image reference (e.g. "postgres:*"); Expires is a date
+// (2006-01-02) or RFC 3339 time after which the image is scanned again.
+type ImageExclusion struct {
+	Image string `json:"image"`
+	// Variants limits the rule to these variants (default: all)
+	Variants []string `json:"variants,omitempty"`
+	Reason   string   `json:"reason"`
+	Expires  string   `json:"expires,omitempty"`
+}
+
+// ExcludedImage is an image left out of a variant's scan by an exclusion rule
+type ExcludedImage struct {
+	Image   string     `json:"image"`
+	Rule    string     `json:"rule"`
+	Reason  string     `json:"reason"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// expiry parses Expires, returning the zero time for rules that don't expire
+func (e ImageExclusion) expiry() (time.Time, error) {
+	if e.Expires == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", e.Expires); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, e.Expires)
+}
+
+// matches reports whether the rule covers image of variant
+func (e ImageExclusion) matches(variant, image string) bool {
+	if len(e.Variants) > 0 {
+		found := false
+		for _, v := range e.Variants {
+			found = found || v == variant
+		}
+		if !found {
+			return false
+		}
+	}
+	ok, _ := path.Match(e.Image, image)
+	return ok
+}
+
+// validateExclusions reports exclusion rules without a pattern or a reason, and
+// invalid patterns or expiry dates
+func validateExclusions(rules []ImageExclusion) []error {
+	var errs []error
+	for i, r := range rules {
+		if r.Image == "" || strings.TrimSpace(r.Reason) == "" {
+			errs = append(errs, fmt.Errorf("exclusion #%d needs an image pattern and a reason", i+1))
+		}
+		if _, err := path.Match(r.Image, ""); err != nil {
+			errs = append(errs, fmt.Errorf("exclusion %q has an invalid pattern", r.Image))
+		}
+		if _, err := r.expiry(); err != nil {
+			errs = append(errs, fmt.Errorf("exclusion %q has an invalid expiry %q: use 2006-01-02 or RFC 3339", r.Image, r.Expires))
+		}
+	}
+	return errs
+}
+
+// applyExclusions splits a variant's images into those to scan and those excluded by
+// the first active rule matching them. Rules past their expiry no longer apply.
+func applyExclusions(rules []ImageExclusion, variant string, images []string, now time.Time, logger *log.Logger) ([]string, []ExcludedImage) {
+	var scan []string
+	var excluded []ExcludedImage
+	for _, image := range images {
+		var match *ExcludedImage
+		for _, r := range rules {
+			if !r.matches(variant, image) {
+				continue
+			}
+			expires, _ := r.expiry()
+			if !expires.IsZero() && !now.Before(expires) {
+				logger.Printf("[%s] ⌛ Exclusion %q of %s expired on %s, scanning it again", variant, r.Image, image, expires.Format(time.RFC3339))
+				continue
+			}
+			match = &ExcludedImage{Image: image, Rule: r.Image, Reason: r.Reason}
+			if !expires.IsZero() {
+				match.Expires = &expires
+			}
+			break
+		}
+		if match != nil {
+			excluded = append(excluded, *match)
+		} else {
+			scan = append(scan, image)
+		}
+	}
+	return scan, excluded
+}
+
+// excludedImageNames returns the references of excluded images
+func excludedImageNames(excluded []ExcludedImage) []string {
+	names := make([]string, 0, len(excluded))
+	for _, e := range excluded {
+		names = append(names, e.Image)
+	}
+	return names
+}
+
+// writeExcludedImages records the images excluded from a variant's scan
+func writeExcludedImages(variant string, excluded []ExcludedImage) error {
+	file := filepath.Join(variantDir(variant), excludedImagesFile)
+	if len(excluded) == 0 {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(excluded, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0o644)
+}
+
+// readExcludedImages returns the images excluded from a variant's last scan
+func readExcludedImages(variant string) ([]ExcludedImage, error) {
+	data, err := os.ReadFile(filepath.Join(variantDir(variant), excludedImagesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var excluded []ExcludedImage
+	if err := json.Unmarshal(data, &excluded); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", excludedImagesFile, err)
+	}
+	return excluded, nil
+}
+
+// imageReportFile is the merged report file name scan-vulnerabilities.sh uses for an image
+func imageReportFile(image string) string {
+	return strings.NewReplacer("/", "_", ":", "_").Replace(image) + "_scan.json"
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// executiveTopPackages is how many packages the report lists per variant
+	executiveTopPackages = 10
+	// executivePieRadius is the radius of the severity pie charts, in SVG user units
+	executivePieRadius = 60
+)
+
+//go:embed executive/report.html
+var executiveFiles embed.FS
+
+var executiveTemplate = template.Must(template.New("report.html").Funcs(template.FuncMap{
+	"severityColor": func(sev string) string { return badgeColors[sev] },
+	"percent":       func(f float64) string { return strconv.FormatFloat(f, 'f', 1, 64) },
+	"reduction": func(from, to int) string {
+		if from == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(float64(from-to)/float64(from)*100, 'f', 1, 64) + "%"
+	},
+}).ParseFS(executiveFiles, "executive/report.html"))
+
+// executiveReportDir holds the executive summary of each cycle
+func executiveReportDir() string {
+	return filepath.Join(reportsPath, "executive")
+}
+
+// ExecutiveConfig controls the HTML executive summary written after each cycle
+type ExecutiveConfig struct {
+	Enabled bool
+	// PublicURL is the external URL of the HTTP API, which serves the reports;
+	// notifications link to the file on the reports volume without it
+	PublicURL string
+}
+
+// PackageCount is one of a variant's most vulnerable packages
+type PackageCount struct {
+	Package  string
+	Total    int
+	Critical int
+	High     int
+	Fixable  int
+}
+
+// pieSlice is one severity of a pie chart, as an SVG path
+type pieSlice struct {
+	Severity string
+	Count    int
+	Percent  float64
+	Path     string
+	// Full is set when the severity is the only one, drawn as a circle
+	Full bool
+}
+
+// executiveVariant is the report section of one variant
+type executiveVariant struct {
+	Result   VariantResult
+	Report   *VariantReport
+	Pie      []pieSlice
+	Packages []PackageCount
+}
+
+// executiveReport is the data of the executive summary template
+type executiveReport struct {
+	Cycle      *CycleResult
+	Duration   time.Duration
+	Severities []string
+	Radius     int
+	Diameter   int
+	Variants   []executiveVariant
+	Diff       *DiffReport
+}
+
+// writeExecutiveReport renders the executive summary of a cycle to
+// /reports/executive/{run_id}.html and records where notifications find it
+func writeExecutiveReport(cfg ExecutiveConfig, cycle *CycleResult) {
+	if !cfg.Enabled {
+		return
+	}
+	report := executiveReport{
+		Cycle:      cycle,
+		Duration:   cycle.FinishedAt.Sub(cycle.StartedAt).Round(time.Second),
+		Severities: severityOrder,
+		Radius:     executivePieRadius,
+		Diameter:   2 * executivePieRadius,
+	}
+	scanned := make(map[string]bool)
+	for _, v := range cycle.Variants {
+		ev := executiveVariant{Result: v}
+		if v.Success {
+			scanned[v.Variant] = true
+			vr, err := buildVariantReport(v.Variant)
+			if err != nil {
+				log.Printf("⚠️  Could not read the %s reports for the executive summary: %v", v.Variant, err)
+			} else {
+				ev.Report = vr
+				ev.Pie = severityPie(vr.Severities)
+			}
+			if findings, err := variantFindings(v.Variant); err == nil {
+				ev.Packages = topPackages(findings, executiveTopPackages)
+			}
+		}
+		report.Variants = append(report.Variants, ev)
+	}
+	if scanned[emailDiffFrom] && scanned[emailDiffTo] {
+		if diff, err := buildDiffReport(emailDiffFrom, emailDiffTo); err != nil {
+			log.Printf("⚠️  Could not compare %s and %s for the executive summary: %v", emailDiffFrom, emailDiffTo, err)
+		} else {
+			report.Diff = diff
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := executiveTemplate.Execute(&buf, report); err != nil {
+		log.Printf("⚠️  Could not render the executive summary of run %s: %v", cycle.RunID, err)
+		return
+	}
+	path := filepath.Join(executiveReportDir(), cycle.RunID+".html")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("⚠️  Could not write the executive summary of run %s: %v", cycle.RunID, err)
+		return
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		log.Printf("⚠️  Could not write the executive summary of run %s: %v", cycle.RunID, err)
+		return
+	}
+
+	cycle.ExecutiveReport = path
+	if cfg.PublicURL != "" {
+		cycle.ExecutiveReport = strings.TrimSuffix(cfg.PublicURL, "/") + "/executive/" + cycle.RunID + ".html"
+	}
+	log.Printf("📈 Executive summary written to %s", path)
+}
+
+// severityPie lays out the severity counts as pie slices around the origin, starting
+// at twelve o'clock and going clockwise
+func severityPie(counts map[string]int) []pieSlice {
+	total := 0
+	for _, sev := range severityOrder {
+		total += counts[sev]
+	}
+	if total == 0 {
+		return nil
+	}
+	var slices []pieSlice
+	r := float64(executivePieRadius)
+	angle := 0.0
+	for _, sev := range severityOrder {
+		n := counts[sev]
+		if n == 0 {
+			continue
+		}
+		frac := float64(n) / float64(total)
+		s := pieSlice{Severity: sev, Count: n, Percent: frac * 100}
+		if n == total {
+			s.Full = true
+		} else {
+			end := angle + frac*2*math.Pi
+			large := 0
+			if frac > 0.5 {
+				large = 1
+			}
+			s.Path = fmt.Sprintf("M0,0 L%.2f,%.2f A%.0f,%.0f 0 %d,1 %.2f,%.2f Z",
+				r*math.Sin(angle), -r*math.Cos(angle), r, r, large, r*math.Sin(end), -r*math.Cos(end))
+			angle = end
+		}
+		slices = append(slices, s)
+	}
+	return slices
+}
+
+// topPackages counts findings per package and returns the n with the most critical,
+// then high, then any findings
+func topPackages(findings []Finding, n int) []PackageCount {
+	byName := make(map[string]*PackageCount)
+	for _, f := range findings {
+		pc := byName[f.Package]
+		if pc == nil {
+			pc = &PackageCount{Package: f.Package}
+			byName[f.Package] = pc
+		}
+		pc.Total++
+		switch f.Severity {
+		case "CRITICAL":
+			pc.Critical++
+		case "HIGH":
+			pc.High++
+		}
+		if f.FixStatus == fixAvailable {
+			pc.Fixable++
+		}
+	}
+
+	packages := make([]PackageCount, 0, len(byName))
+	for _, pc := range byName {
+		packages = append(packages, *pc)
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		a, b := packages[i], packages[j]
+		if a.Critical != b.Critical {
+			return a.Critical > b.Critical
+		}
+		if a.High != b.High {
+			return a.High > b.High
+		}
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Package < b.Package
+	})
+	if len(packages) > n {
+		packages = packages[:n]
+	}
+	return packages
+}
+
+// executiveReportHandler serves GET /executive/{run_id}.html
+func executiveReportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		runID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/executive/"), ".html")
+		if !ok || !runIDDir.MatchString(runID) {
+			writeError(w, http.StatusNotFound, "no such report")
+			return
+		}
+		page, err := os.ReadFile(filepath.Join(executiveReportDir(), runID+".html"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("no executive summary for run %s", runID))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	}
+}
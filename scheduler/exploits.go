@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Public exploit intelligence feeds, overridable with EPSS_FEED_URL and KEV_FEED_URL
+const (
+	defaultEPSSFeedURL = "https://epss.cyentia.com/epss_scores-current.csv.gz"
+	defaultKEVFeedURL  = "https://www.cisa.gov/sites/default/files/feeds/known_exploited_vulnerabilities.json"
+
+	// highEPSSScore is the exploitation probability counted as likely in the summaries
+	highEPSSScore = 0.1
+)
+
+// ExploitIntelConfig enables annotating findings with EPSS scores and CISA KEV entries
+type ExploitIntelConfig struct {
+	Enabled bool
+	// EPSSFeedURL and KEVFeedURL are the feeds; an empty URL skips that feed
+	EPSSFeedURL string
+	KEVFeedURL  string
+	CacheTTL    time.Duration
+}
+
+// EPSSScore is a CVE's probability of exploitation in the next 30 days, from FIRST's EPSS
+type EPSSScore struct {
+	Score      float64 `json:"Score"`
+	Percentile float64 `json:"Percentile"`
+}
+
+// score returns the EPSS score, 0 without one
+func (s *EPSSScore) score() float64 {
+	if s == nil {
+		return 0
+	}
+	return s.Score
+}
+
+// KEVEntry marks a CVE listed in CISA's Known Exploited Vulnerabilities catalog
+type KEVEntry struct {
+	DateAdded         string `json:"DateAdded"`
+	DueDate           string `json:"DueDate,omitempty"`
+	RansomwareUse     bool   `json:"RansomwareUse,omitempty"`
+	RequiredAction    string `json:"RequiredAction,omitempty"`
+	VulnerabilityName string `json:"VulnerabilityName,omitempty"`
+}
+
+// kevCatalog is the subset of the KEV JSON feed the scheduler reads
+type kevCatalog struct {
+	Vulnerabilities []struct {
+		CVEID                      string `json:"cveID"`
+		VulnerabilityName          string `json:"vulnerabilityName"`
+		DateAdded                  string `json:"dateAdded"`
+		RequiredAction             string `json:"requiredAction"`
+		DueDate                    string `json:"dueDate"`
+		KnownRansomwareCampaignUse string `json:"knownRansomwareCampaignUse"`
+	} `json:"vulnerabilities"`
+}
+
+// ExploitIntel indexes the EPSS scores and KEV entries by CVE
+type ExploitIntel struct {
+	EPSS map[string]EPSSScore
+	KEV  map[string]KEVEntry
+}
+
+// loadExploitIntel fetches the configured feeds; a feed that can't be loaded is
+// logged and skipped, and only losing both is an error
+func loadExploitIntel(cfg ExploitIntelConfig) (*ExploitIntel, error) {
+	intel := &ExploitIntel{}
+	var errs []error
+	if cfg.EPSSFeedURL != "" {
+		data, err := fetchCached(cfg.EPSSFeedURL, "exploits", cfg.CacheTTL)
+		if err == nil {
+			intel.EPSS, err = parseEPSS(data)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("EPSS feed: %w", err))
+		}
+	}
+	if cfg.KEVFeedURL != "" {
+		data, err := fetchCached(cfg.KEVFeedURL, "exploits", cfg.CacheTTL)
+		if err == nil {
+			intel.KEV, err = parseKEV(data)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("KEV feed: %w", err))
+		}
+	}
+	if intel.EPSS == nil && intel.KEV == nil {
+		return nil, errors.Join(append(errs, errors.New("no exploit intelligence feed could be loaded"))...)
+	}
+	for _, err := range errs {
+		log.Printf("⚠️  Skipping %v", err)
+	}
+	return intel, nil
+}
+
+// parseEPSS reads the EPSS CSV (gzipped or not): a "#model_version:..." comment line,
+// then "cve,epss,percentile" rows
+func parseEPSS(data []byte) (map[string]EPSSScore, error) {
+	var r io.Reader = bytes.NewReader(data)
+	if len(data) > 1 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	scores := make(map[string]EPSSScore)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 3 || !strings.HasPrefix(rec[0], "CVE-") {
+			continue
+		}
+		score, err1 := strconv.ParseFloat(rec[1], 64)
+		percentile, err2 := strconv.ParseFloat(rec[2], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		scores[rec[0]] = EPSSScore{Score: score, Percentile: percentile}
+	}
+	if len(scores) == 0 {
+		return nil, errors.New("no scores found")
+	}
+	return scores, nil
+}
+
+func parseKEV(data []byte) (map[string]KEVEntry, error) {
+	var catalog kevCatalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, err
+	}
+	if len(catalog.Vulnerabilities) == 0 {
+		return nil, errors.New("no vulnerabilities found")
+	}
+	kev := make(map[string]KEVEntry, len(catalog.Vulnerabilities))
+	for _, v := range catalog.Vulnerabilities {
+		kev[v.CVEID] = KEVEntry{
+			DateAdded:         v.DateAdded,
+			DueDate:           v.DueDate,
+			RansomwareUse:     strings.EqualFold(v.KnownRansomwareCampaignUse, "Known"),
+			RequiredAction:    v.RequiredAction,
+			VulnerabilityName: v.VulnerabilityName,
+		}
+	}
+	return kev, nil
+}
+
+// ExploitSummary is written to /reports/{variant}/exploits.json after each scan
+type ExploitSummary struct {
+	Variant     string    `json:"variant"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Findings    int       `json:"findings"`
+	// Scored counts the findings with an EPSS score, HighEPSS those of at least highEPSSScore
+	Scored   int `json:"scored"`
+	HighEPSS int `json:"high_epss"`
+	// KnownExploited lists the CVEs in the KEV catalog, with the images they were found in
+	KnownExploited map[string][]string `json:"known_exploited"`
+}
+
+// annotateExploitIntel records the EPSS score and KEV entry of each of a variant's
+// merged findings
+func annotateExploitIntel(variant string, cfg ExploitIntelConfig) (*ExploitSummary, error) {
+	intel, err := loadExploitIntel(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &ExploitSummary{Variant: variant, GeneratedAt: time.Now().UTC(), KnownExploited: make(map[string][]string)}
+	files, err := mergedReportFiles(variant)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		image := strings.TrimSuffix(filepath.Base(file), "_scan.json")
+		seen := make(map[string]bool)
+		err := rewriteMergedReport(file, func(_, vuln map[string]any) {
+			summary.Findings++
+			cve := stringField(vuln, "VulnerabilityID")
+			if score, ok := intel.EPSS[cve]; ok {
+				vuln["EPSS"] = score
+				summary.Scored++
+				if score.Score >= highEPSSScore {
+					summary.HighEPSS++
+				}
+			} else {
+				delete(vuln, "EPSS")
+			}
+			if entry, ok := intel.KEV[cve]; ok {
+				vuln["KEV"] = entry
+				if !seen[cve] {
+					seen[cve] = true
+					summary.KnownExploited[cve] = append(summary.KnownExploited[cve], image)
+				}
+			} else {
+				delete(vuln, "KEV")
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(variantDir(variant), "exploits.json"), data, 0o644); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Export formats of EXPORT_FORMATS and `scheduler report export`
+const (
+	exportCSV  = "csv"
+	exportXLSX = "xlsx"
+)
+
+// exportComparisonFrom and exportComparisonTo are the variants each run's comparison
+// export covers, as in the cycle email
+const (
+	exportComparisonFrom = emailDiffFrom
+	exportComparisonTo   = emailDiffTo
+)
+
+// exportTable is a sheet of an export: a CSV file, or a worksheet of a workbook.
+// Cells are strings, ints or float64s.
+type exportTable struct {
+	Name   string
+	Header []string
+	Rows   [][]any
+}
+
+// findingsTable lists the findings of the given variants' latest reports
+func findingsTable(variants []string) (*exportTable, error) {
+	table := &exportTable{
+		Name: "Findings",
+		Header: []string{"Variant", "Image", "Platform", "CVE", "Severity", "Package", "Installed Version",
+			"Fixed Version", "Fix Status", "EPSS", "Known Exploited", "Disputed"},
+	}
+	for _, variant := range variants {
+		findings, err := variantFindings(variant)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s findings: %w", variant, err)
+		}
+		for _, f := range findings {
+			var epss any = ""
+			if f.EPSS != nil {
+				epss = f.EPSS.Score
+			}
+			table.Rows = append(table.Rows, []any{variant, f.Image, f.Platform, f.CVE, f.Severity, f.Package,
+				f.InstalledVersion, f.FixedVersion, f.FixStatus, epss, yesNo(f.KEV != nil), yesNo(f.Disputed)})
+		}
+	}
+	return table, nil
+}
+
+// comparisonTable lays a DiffReport out as one row per metric
+func comparisonTable(diff *DiffReport) *exportTable {
+	table := &exportTable{Name: "Comparison", Header: []string{"Metric", diff.From, diff.To, "Reduction %"}}
+	reduction := func(from, to int) any {
+		if from == 0 {
+			return ""
+		}
+		return roundPct(float64(from-to) / float64(from) * 100)
+	}
+	for _, sev := range severityOrder {
+		s := diff.Severities[sev]
+		table.Rows = append(table.Rows, []any{sev, s.From, s.To, reduction(s.From, s.To)})
+	}
+	table.Rows = append(table.Rows,
+		[]any{"Total", diff.FromTotal, diff.ToTotal, reduction(diff.FromTotal, diff.ToTotal)},
+		[]any{"Unique CVEs only in this variant", diff.OnlyInFrom, diff.OnlyInTo, ""},
+		[]any{"Unique CVEs in both", diff.Common, diff.Common, ""},
+		[]any{"License violations", diff.FromLicenseViolations, diff.ToLicenseViolations, ""},
+		[]any{"Excluded images", len(diff.FromExcluded), len(diff.ToExcluded), ""},
+	)
+	return table
+}
+
+// roundPct rounds a percentage to one decimal
+func roundPct(pct float64) float64 {
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(pct, 'f', 1, 64), 64)
+	return v
+}
+
+// cellText formats a cell for CSV
+func cellText(v any) string {
+	switch c := v.(type) {
+	case string:
+		return c
+	case int:
+		return strconv.Itoa(c)
+	case float64:
+		return strconv.FormatFloat(c, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// csvText quotes text that a spreadsheet would evaluate as a formula, such as the
+// package @babel/core, with a leading apostrophe. The workbook needs no guard: its
+// strings are inline strings, never formulas.
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// writeTableCSV writes a table as CSV with a header row
+func writeTableCSV(w io.Writer, table *exportTable) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(table.Header))
+	for i, h := range table.Header {
+		header[i] = csvText(h)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, row := range table.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = cellText(cell)
+			if _, ok := cell.(string); ok {
+				record[i] = csvText(record[i])
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// xlsxRootRels and xlsxStyles are the fixed parts of a workbook; the workbook, its
+// relationships and the content types depend on the sheets
+const (
+	xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	// Style 1 is the bold header row
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border/></borders><cellStyleXfs count="1"><xf/></cellStyleXfs><cellXfs count="2"><xf fontId="0"/><xf fontId="1" applyFont="1"/></cellXfs></styleSheet>`
+)
+
+// writeTablesXLSX writes the tables as the worksheets of an Excel workbook, each
+// with a frozen, filterable header row
+func writeTablesXLSX(w io.Writer, tables ...*exportTable) error {
+	zw := zip.NewWriter(w)
+	add := func(name, content string) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, content)
+		return err
+	}
+
+	var types, sheets, rels strings.Builder
+	types.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	rels.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i, table := range tables {
+		n := i + 1
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(table.Name), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+		if err := add(fmt.Sprintf("xl/worksheets/sheet%d.xml", n), worksheetXML(table)); err != nil {
+			return err
+		}
+	}
+	types.WriteString(`</Types>`)
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`, len(tables)+1)
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", types.String()},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` + sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, p := range parts {
+		if err := add(p.name, p.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// worksheetXML renders a table as a worksheet with inline strings
+func worksheetXML(table *exportTable) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews><sheetData>`)
+	b.WriteString(`<row r="1">`)
+	for i, h := range table.Header {
+		fmt.Fprintf(&b, `<c r="%s1" t="inlineStr" s="1"><is><t>%s</t></is></c>`, columnName(i), xmlEscape(h))
+	}
+	b.WriteString(`</row>`)
+	for r, row := range table.Rows {
+		fmt.Fprintf(&b, `<row r="%d">`, r+2)
+		for i, cell := range row {
+			ref := fmt.Sprintf("%s%d", columnName(i), r+2)
+			switch c := cell.(type) {
+			case int, float64:
+				fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, cellText(c))
+			default:
+				if text := cellText(c); text != "" {
+					fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, ref, xmlEscape(text))
+				}
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData>`)
+	fmt.Fprintf(&b, `<autoFilter ref="A1:%s%d"/>`, columnName(len(table.Header)-1), len(table.Rows)+1)
+	b.WriteString(`</worksheet>`)
+	return b.String()
+}
+
+// columnName returns the spreadsheet column of a zero-based index: A, B, ... Z, AA
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// exportTables builds the findings of the variants and, when from and to are set,
+// their comparison
+func exportTables(variants []string, from, to string) ([]*exportTable, error) {
+	findings, err := findingsTable(variants)
+	if err != nil {
+		return nil, err
+	}
+	tables := []*exportTable{findings}
+	if from != "" && to != "" {
+		diff, err := buildDiffReport(from, to)
+		if err != nil {
+			return nil, fmt.Errorf("comparing %s and %s: %w", from, to, err)
+		}
+		tables = append(tables, comparisonTable(diff))
+	}
+	return tables, nil
+}
+
+// exportCycle writes the findings of a cycle's successful variants, and the baseline
+// vs chainguard comparison when both succeeded, under /reports/exports/{run_id} in
+// each of the configured formats
+func exportCycle(formats []string, cycle *CycleResult) {
+	if len(formats) == 0 {
+		return
+	}
+	var variants []string
+	scanned := make(map[string]bool)
+	for _, v := range cycle.Variants {
+		if v.Success {
+			variants = append(variants, v.Variant)
+			scanned[v.Variant] = true
+		}
+	}
+	if len(variants) == 0 {
+		return
+	}
+	from, to := "", ""
+	if scanned[exportComparisonFrom] && scanned[exportComparisonTo] {
+		from, to = exportComparisonFrom, exportComparisonTo
+	}
+	tables, err := exportTables(variants, from, to)
+	if err != nil {
+		log.Printf("⚠️  Could not export the findings of run %s: %v", cycle.RunID, err)
+		return
+	}
+
+	dir := filepath.Join(reportsPath, "exports", cycle.RunID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("⚠️  Could not export the findings of run %s: %v", cycle.RunID, err)
+		return
+	}
+	for _, format := range formats {
+		if err := writeExport(dir, format, tables); err != nil {
+			log.Printf("⚠️  Could not write the %s export of run %s: %v", format, cycle.RunID, err)
+		}
+	}
+	log.Printf("📊 Exported the findings of run %s to %s", cycle.RunID, dir)
+}
+
+// writeExport writes one CSV file per table (findings.csv, comparison.csv), or a
+// findings.xlsx workbook with a sheet per table, into dir
+func writeExport(dir, format string, tables []*exportTable) error {
+	write := func(name string, fn func(io.Writer) error) error {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := fn(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	if format == exportXLSX {
+		return write("findings.xlsx", func(w io.Writer) error { return writeTablesXLSX(w, tables...) })
+	}
+	for _, table := range tables {
+		table := table
+		if err := write(strings.ToLower(table.Name)+".csv", func(w io.Writer) error { return writeTableCSV(w, table) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCSVTextGuardsFormulas(t *testing.T) {
+	for in, want := range map[string]string{
+		"@babel/core":       "'@babel/core",
+		"=HYPERLINK(\"x\")": "'=HYPERLINK(\"x\")",
+		"+1":                "'+1",
+		"-2+3":              "'-2+3",
+		"\tcmd":             "'\tcmd",
+		"\rcmd":             "'\rcmd",
+		"openssl":           "openssl",
+		"a=b":               "a=b",
+		"":                  "",
+	} {
+		if got := csvText(in); got != want {
+			t.Errorf("csvText(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWriteTableCSVGuardsStringCells(t *testing.T) {
+	table := &exportTable{
+		Header: []string{"Package", "=Count"},
+		Rows:   [][]any{{"@babel/core", -3}, {"lodash", 1.5}},
+	}
+	var b bytes.Buffer
+	if err := writeTableCSV(&b, table); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"Package", "'=Count"}, {"'@babel/core", "-3"}, {"lodash", "1.5"}}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d", len(records), len(want))
+	}
+	for i := range want {
+		if strings.Join(records[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("record %d = %q, want %q (numbers keep their sign)", i, records[i], want[i])
+		}
+	}
+}
+
+func TestWorksheetWritesStringsAsInlineStrings(t *testing.T) {
+	table := &exportTable{
+		Name:   "Findings",
+		Header: []string{"Package"},
+		Rows:   [][]any{{"=cmd|'/c calc'!A1"}, {"@babel/core"}},
+	}
+	var b bytes.Buffer
+	if err := writeTablesXLSX(&b, table); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			sheet = string(data)
+		}
+	}
+	if sheet == "" {
+		t.Fatal("workbook has no sheet1.xml")
+	}
+	if strings.Contains(sheet, "<f>") {
+		t.Errorf("worksheet has a formula: %s", sheet)
+	}
+	for _, cell := range []string{
+		`<c r="A2" t="inlineStr"><is><t>=cmd|&#39;/c calc&#39;!A1</t></is></c>`,
+		`<c r="A3" t="inlineStr"><is><t>@babel/core</t></is></c>`,
+	} {
+		if !strings.Contains(sheet, cell) {
+			t.Errorf("worksheet lacks %s", cell)
+		}
+	}
+}
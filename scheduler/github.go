@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultGitHubAPIURL = "https://api.github.com"
+
+// GitHubIssuesConfig opens an issue per new critical or high CVE of a variant
+type GitHubIssuesConfig struct {
+	// Repo is "owner/name"; issues are disabled without one
+	Repo   string
+	Token  string
+	APIURL string
+	Labels []string
+}
+
+// Enabled reports whether new CVEs are filed as GitHub issues
+func (c GitHubIssuesConfig) Enabled() bool {
+	return c.Repo != ""
+}
+
+type githubIssue struct {
+	Number int    `json:"number"`
+	Body   string `json:"body"`
+	// PullRequest is set on pull requests, which the issues API lists too
+	PullRequest *struct{} `json:"pull_request"`
+}
+
+// githubIssueMarker identifies the issue of a variant's CVE across title changes
+func githubIssueMarker(variant, cve string) string {
+	return fmt.Sprintf("<!-- vuln-demo:%s/%s -->", variant, cve)
+}
+
+// syncGitHubIssues opens an issue for each new CVE of the report, or comments on
+// and refreshes the issue still open from an earlier occurrence
+func syncGitHubIssues(cfg GitHubIssuesConfig, report *NewCVEReport, logger *log.Logger) error {
+	open, err := listGitHubIssues(cfg)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, c := range report.CVEs {
+		marker := githubIssueMarker(report.Variant, c.CVE)
+		title := fmt.Sprintf("%s (%s) in %s", c.CVE, c.Severity, report.Variant)
+		body := githubIssueBody(marker, report, c)
+
+		var existing *githubIssue
+		for i := range open {
+			if strings.Contains(open[i].Body, marker) {
+				existing = &open[i]
+				break
+			}
+		}
+
+		if existing == nil {
+			var created githubIssue
+			err = githubRequest(cfg, http.MethodPost, "/repos/"+cfg.Repo+"/issues",
+				map[string]any{"title": title, "body": body, "labels": cfg.Labels}, &created)
+			if err == nil {
+				logger.Printf("[%s] 🐙 Opened GitHub issue #%d for %s", report.Variant, created.Number, c.CVE)
+			}
+		} else {
+			path := fmt.Sprintf("/repos/%s/issues/%d", cfg.Repo, existing.Number)
+			err = githubRequest(cfg, http.MethodPatch, path, map[string]any{"title": title, "body": body}, nil)
+			if err == nil {
+				comment := fmt.Sprintf("Found again in %d image(s) by scan run `%s`.", len(c.Images), report.RunID)
+				err = githubRequest(cfg, http.MethodPost, path+"/comments", map[string]any{"body": comment}, nil)
+			}
+			if err == nil {
+				logger.Printf("[%s] 🐙 Updated GitHub issue #%d for %s", report.Variant, existing.Number, c.CVE)
+			}
+		}
+		if err != nil {
+			logger.Printf("⚠️  [%s] GitHub issue for %s: %v", report.Variant, c.CVE, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d issue(s) failed", failed, len(report.CVEs))
+	}
+	return nil
+}
+
+// listGitHubIssues returns the repository's open issues carrying the configured labels
+func listGitHubIssues(cfg GitHubIssuesConfig) ([]githubIssue, error) {
+	q := url.Values{"state": {"open"}, "per_page": {"100"}}
+	if len(cfg.Labels) > 0 {
+		q.Set("labels", strings.Join(cfg.Labels, ","))
+	}
+
+	var issues []githubIssue
+	for page := 1; ; page++ {
+		q.Set("page", fmt.Sprint(page))
+		var batch []githubIssue
+		if err := githubRequest(cfg, http.MethodGet, "/repos/"+cfg.Repo+"/issues?"+q.Encode(), nil, &batch); err != nil {
+			return nil, err
+		}
+		for _, issue := range batch {
+			if issue.PullRequest == nil {
+				issues = append(issues, issue)
+			}
+		}
+		if len(batch) < 100 {
+			return issues, nil
+		}
+	}
+}
+
+// githubIssueBody lists the images, packages and fix versions affected by a new CVE
+func githubIssueBody(marker string, report *NewCVEReport, c NewCVE) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n**%s** was newly found in the **%s** variant by scan run `%s`.\n\n",
+		marker, c.CVE, report.Variant, report.RunID)
+	if c.KEV {
+		b.WriteString("⚠️ This CVE is listed in CISA's Known Exploited Vulnerabilities catalog.\n\n")
+	}
+	b.WriteString("| Image | Package | Installed | Fixed in |\n|-------|---------|-----------|----------|\n")
+	for _, img := range c.Images {
+		fixed := img.FixedVersion
+		if fixed == "" {
+			fixed = "no fix yet"
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s |\n", img.Image, img.Package, img.InstalledVersion, fixed)
+	}
+	return b.String()
+}
+
+// githubRequest calls the GitHub REST API, decoding the response into out when set
+func githubRequest(cfg GitHubIssuesConfig, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(cfg.APIURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s returned %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -2,4 +2,7 @@ module github.com/vuln-demo/scheduler
 
 go 1.21
 
-require github.com/robfig/cron/v3 v3.0.1
+require (
+	github.com/lib/pq v1.10.9
+	github.com/robfig/cron/v3 v3.0.1
+)
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcService is the full name of the service in scheduler.proto
+const grpcService = "vulndemo.scheduler.v1.Scheduler"
+
+// maxGRPCMessage bounds the request messages the API reads
+const maxGRPCMessage = 1 << 20
+
+// gRPC status codes
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnauthenticated    = 16
+)
+
+// GRPCConfig enables the gRPC control API, served next to the HTTP API
+type GRPCConfig struct {
+	// Addr is the listen address; the API is off without one
+	Addr string
+	// TLSCert and TLSKey are the server certificate; without them a self-signed one
+	// is generated at startup. gRPC needs HTTP/2, which Go serves over TLS.
+	TLSCert string
+	TLSKey  string
+}
+
+// grpcConfigFromEnv reads GRPC_ADDR, GRPC_TLS_CERT and GRPC_TLS_KEY
+func grpcConfigFromEnv() GRPCConfig {
+	return GRPCConfig{
+		Addr:    envString("GRPC_ADDR", ""),
+		TLSCert: envString("GRPC_TLS_CERT", ""),
+		TLSKey:  envString("GRPC_TLS_KEY", ""),
+	}
+}
+
+// Validate reports a certificate without its key or the other way around
+func (gc GRPCConfig) Validate() error {
+	if (gc.TLSCert == "") != (gc.TLSKey == "") {
+		return errors.New("GRPC_TLS_CERT and GRPC_TLS_KEY must be set together")
+	}
+	return nil
+}
+
+// grpcError is an RPC failure with its gRPC status code
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return e.msg }
+
+func grpcErrorf(code int, format string, args ...any) error {
+	return &grpcError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// grpcServer implements the Scheduler service of scheduler.proto over net/http's HTTP/2
+// support, encoding the messages by hand
+type grpcServer struct {
+	sched   *Scheduler
+	auth    APIAuthConfig
+	trigger *scanTrigger
+}
+
+// startGRPCServer serves the gRPC control API in the background, sending the error
+// that stops it to failed
+func startGRPCServer(gc GRPCConfig, auth APIAuthConfig, sched *Scheduler, trigger *scanTrigger, failed chan<- error) error {
+	// Client certificates are checked against the HTTP API's client CA
+	tlsConfig, err := auth.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if gc.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(gc.TLSCert, gc.TLSKey)
+		if err != nil {
+			return fmt.Errorf("failed to load the gRPC certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else {
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			return fmt.Errorf("failed to generate a gRPC certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		log.Println("⚠️  No GRPC_TLS_CERT set, serving gRPC with a self-signed certificate")
+	}
+
+	srv := &http.Server{Addr: gc.Addr, Handler: &grpcServer{sched: sched, auth: auth, trigger: trigger}, TLSConfig: tlsConfig}
+	go func() {
+		log.Printf("gRPC API listening on %s", gc.Addr)
+		failed <- fmt.Errorf("gRPC API server failed: %w", srv.ListenAndServeTLS("", ""))
+	}()
+	return nil
+}
+
+// selfSignedCertificate generates a short-lived certificate for the gRPC listener
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: defaultTraceServiceName},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+func (g *grpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		writeError(w, http.StatusUnsupportedMediaType, "gRPC requests only")
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Add("Trailer", "Grpc-Status")
+	w.Header().Add("Trailer", "Grpc-Message")
+
+	method, ok := strings.CutPrefix(r.URL.Path, "/"+grpcService+"/")
+	need := roleRead
+	if method == "TriggerScan" {
+		need = roleOperator
+	}
+	var err error
+	if allowed, status := g.auth.authorize(r, need); !allowed {
+		if status == http.StatusForbidden {
+			err = grpcErrorf(grpcPermissionDenied, "%s needs operator access", method)
+		} else {
+			err = grpcErrorf(grpcUnauthenticated, "authentication required")
+		}
+	} else if !ok {
+		err = grpcErrorf(grpcUnimplemented, "unknown service %s", strings.TrimPrefix(r.URL.Path, "/"))
+	} else if req, readErr := readGRPCMessage(r.Body); readErr != nil {
+		err = readErr
+	} else {
+		switch method {
+		case "TriggerScan":
+			err = g.unary(w, req, func(req []byte) (protoMessage, error) { return g.triggerScan(r, req) })
+		case "GetStatus":
+			err = g.unary(w, req, g.getStatus)
+		case "StreamLogs":
+			err = g.streamLogs(w, r, req)
+		default:
+			err = grpcErrorf(grpcUnimplemented, "unknown method %s", method)
+		}
+	}
+
+	code := grpcOK
+	if err != nil {
+		var ge *grpcError
+		if !errors.As(err, &ge) {
+			ge = &grpcError{code: grpcInternal, msg: err.Error()}
+		}
+		code = ge.code
+		w.Header().Set("Grpc-Message", grpcPercentEncode(ge.msg))
+		log.Printf("⚠️  gRPC %s failed: %s", method, ge.msg)
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+}
+
+// unary answers a request with the single message its handler returns
+func (g *grpcServer) unary(w http.ResponseWriter, req []byte, handler func([]byte) (protoMessage, error)) error {
+	resp, err := handler(req)
+	if err != nil {
+		return err
+	}
+	return writeGRPCMessage(w, resp)
+}
+
+// readGRPCMessage reads the single length-prefixed message of a request
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(body, header[:]); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading the request: %v", err)
+	}
+	if header[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed requests are not supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxGRPCMessage {
+		return nil, grpcErrorf(grpcInvalidArgument, "request of %d bytes is too large", size)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading the request: %v", err)
+	}
+	return msg, nil
+}
+
+// writeGRPCMessage writes a length-prefixed response message and flushes it to the client
+func writeGRPCMessage(w http.ResponseWriter, msg protoMessage) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// grpcPercentEncode escapes a status message for the grpc-message trailer
+func grpcPercentEncode(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// requestStrings returns the repeated string field of a request message
+func requestStrings(req []byte, field int) ([]string, error) {
+	var values []string
+	err := protoFields(req, func(f int, _ uint64, data []byte) {
+		if f == field {
+			values = append(values, string(data))
+		}
+	})
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	return values, nil
+}
+
+// triggerScan queues a cycle over the requested variants (TriggerScanRequest.variants),
+// or all of them, and returns its run ID without waiting for it
+func (g *grpcServer) triggerScan(r *http.Request, req []byte) (protoMessage, error) {
+	variants, err := requestStrings(req, 1)
+	if err != nil {
+		return nil, err
+	}
+	scan, err := g.trigger.Trigger(clientID(r), "gRPC", variants)
+	if err != nil {
+		te := err.(*triggerError)
+		return nil, grpcErrorf(te.grpcCode(), "%s", te.msg)
+	}
+
+	var resp protoMessage
+	resp.appendString(1, scan.RunID)
+	for _, v := range scan.Variants {
+		resp.appendString(2, v)
+	}
+	resp.appendBool(3, scan.Queued)
+	resp.appendInt(4, int64(scan.Position))
+	resp.appendBool(5, scan.Coalesced)
+	return resp, nil
+}
+
+// getStatus returns the scheduler status (GetStatusResponse), with the per-variant
+// progress of the running cycle
+func (g *grpcServer) getStatus([]byte) (protoMessage, error) {
+	status := g.sched.Status()
+	var resp protoMessage
+	resp.appendString(1, status.Schedule)
+	resp.appendBool(2, status.Paused)
+	resp.appendBool(3, status.Leader)
+	if status.NextRun != nil {
+		resp.appendTime(4, *status.NextRun)
+	}
+	if run := activity.Snapshot().Run; run != nil && run.FinishedAt == nil {
+		var cycle protoMessage
+		cycle.appendString(1, run.RunID)
+		cycle.appendString(2, jobRunning)
+		cycle.appendTime(3, run.StartedAt)
+		for _, v := range run.Variants {
+			total, done, vulns := len(v.Images), 0, 0
+			for _, img := range v.Images {
+				switch img.Status {
+				case imageScanned, imageUnchanged, imageSkipped, imageFailed:
+					done++
+				}
+				if img.Vulnerabilities != nil {
+					vulns += *img.Vulnerabilities
+				}
+			}
+			if v.Progress != nil {
+				total, done = v.Progress.Total, v.Progress.Done
+			}
+			var variant protoMessage
+			variant.appendString(1, v.Variant)
+			variant.appendString(2, v.Status)
+			variant.appendString(3, v.Step)
+			variant.appendInt(4, int64(total))
+			variant.appendInt(5, int64(done))
+			variant.appendInt(6, int64(vulns))
+			cycle.appendMessage(5, variant)
+		}
+		resp.appendMessage(5, cycle)
+	}
+	if last := status.LastRun; last != nil {
+		var cycle protoMessage
+		cycle.appendString(1, last.RunID)
+		cycle.appendString(2, last.Status)
+		cycle.appendTime(3, last.StartedAt)
+		cycle.appendTime(4, last.FinishedAt)
+		for _, v := range last.Variants {
+			result := jobFailed
+			if v.Success {
+				result = jobSucceeded
+			}
+			var variant protoMessage
+			variant.appendString(1, v.Variant)
+			variant.appendString(2, result)
+			variant.appendInt(4, int64(v.Images))
+			variant.appendInt(5, int64(v.Images))
+			variant.appendInt(6, int64(v.Vulnerabilities))
+			variant.appendString(7, v.Error)
+			cycle.appendMessage(5, variant)
+		}
+		resp.appendMessage(6, cycle)
+	}
+	resp.appendInt(7, int64(status.ConsecutiveFailures))
+	return resp, nil
+}
+
+// streamLogs follows the step output of the running cycle (StreamLogsRequest.run_id,
+// by default whichever is running), optionally of some variants only, until the
+// cycle finishes or the client goes away
+func (g *grpcServer) streamLogs(w http.ResponseWriter, r *http.Request, req []byte) error {
+	var runID string
+	var variants []string
+	err := protoFields(req, func(f int, _ uint64, data []byte) {
+		switch f {
+		case 1:
+			runID = string(data)
+		case 2:
+			variants = append(variants, string(data))
+		}
+	})
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+
+	running := func() bool {
+		run := activity.Snapshot().Run
+		return run != nil && run.FinishedAt == nil && run.RunID == runID
+	}
+	lines, stop := stepLogs.Follow()
+	defer stop()
+	if runID == "" {
+		if run := activity.Snapshot().Run; run != nil && run.FinishedAt == nil {
+			runID = run.RunID
+		}
+	}
+	if runID == "" {
+		return grpcErrorf(grpcNotFound, "no cycle is running")
+	}
+	if !running() {
+		return grpcErrorf(grpcNotFound, "cycle %s is not running; its step logs are under %s", runID, runLogDir("{variant}", runID))
+	}
+
+	// Send the headers now: clients wait for them before reading a quiet stream
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case line := <-lines:
+			if line.RunID != runID || (len(variants) > 0 && !slices.Contains(variants, line.Variant)) {
+				continue
+			}
+			var msg protoMessage
+			msg.appendTime(1, line.Time)
+			msg.appendString(2, line.RunID)
+			msg.appendString(3, line.Variant)
+			msg.appendString(4, line.Step)
+			msg.appendString(5, line.Stream)
+			msg.appendString(6, line.Line)
+			if err := writeGRPCMessage(w, msg); err != nil {
+				return nil
+			}
+		case <-tick.C:
+			if !running() && len(lines) == 0 {
+				return nil
+			}
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
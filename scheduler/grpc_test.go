@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProtoMessageRoundTrip(t *testing.T) {
+	ts := time.Date(2025, 1, 16, 2, 0, 0, 500, time.UTC)
+	var nested protoMessage
+	nested.appendString(1, "shop")
+	var msg protoMessage
+	msg.appendString(1, "run-1")
+	msg.appendString(2, "") // zero values are left out
+	msg.appendInt(3, 300)
+	msg.appendBool(4, true)
+	msg.appendBool(5, false)
+	msg.appendMessage(6, nested)
+	msg.appendMessage(6, nil) // empty repeated messages are kept
+	msg.appendTime(7, ts)
+
+	var strings []string
+	ints := map[int]uint64{}
+	var messages [][]byte
+	var stamp time.Time
+	err := protoFields(msg, func(f int, v uint64, data []byte) {
+		switch f {
+		case 1:
+			strings = append(strings, string(data))
+		case 3, 4:
+			ints[f] = v
+		case 6:
+			messages = append(messages, data)
+		case 7:
+			var sec, nsec uint64
+			protoFields(data, func(f int, v uint64, _ []byte) {
+				if f == 1 {
+					sec = v
+				} else {
+					nsec = v
+				}
+			})
+			stamp = time.Unix(int64(sec), int64(nsec)).UTC()
+		default:
+			t.Errorf("unexpected field %d", f)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(strings) != 1 || strings[0] != "run-1" {
+		t.Errorf("strings = %q, want [run-1]", strings)
+	}
+	if ints[3] != 300 || ints[4] != 1 {
+		t.Errorf("varints = %v, want 3:300 4:1", ints)
+	}
+	if len(messages) != 2 || len(messages[1]) != 0 {
+		t.Fatalf("got %d nested messages, want 2 with the second empty", len(messages))
+	}
+	names, err := requestStrings(messages[0], 1)
+	if err != nil || len(names) != 1 || names[0] != "shop" {
+		t.Errorf("nested message = %q, %v", names, err)
+	}
+	if !stamp.Equal(ts) {
+		t.Errorf("timestamp = %s, want %s", stamp, ts)
+	}
+}
+
+func TestProtoFieldsSkipsFixedFields(t *testing.T) {
+	msg := []byte{1<<3 | wireFixed64, 1, 2, 3, 4, 5, 6, 7, 8, 2<<3 | wireFixed32, 1, 2, 3, 4}
+	msg = append(msg, 3<<3|wireBytes, 1, 'x')
+	values, err := requestStrings(msg, 3)
+	if err != nil || len(values) != 1 || values[0] != "x" {
+		t.Errorf("requestStrings = %q, %v", values, err)
+	}
+}
+
+func TestProtoFieldsRejectsMalformedMessages(t *testing.T) {
+	for name, msg := range map[string][]byte{
+		"truncated varint":  {1<<3 | wireVarint, 0x80},
+		"truncated bytes":   {1<<3 | wireBytes, 5, 'a'},
+		"truncated fixed64": {1<<3 | wireFixed64, 1, 2},
+		"unknown wire type": {1<<3 | 3},
+		"truncated key":     {0x80},
+		"oversized length":  {1<<3 | wireBytes, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		"truncated fixed32": {1<<3 | wireFixed32, 1},
+	} {
+		if err := protoFields(msg, func(int, uint64, []byte) {}); !errors.Is(err, errBadProto) {
+			t.Errorf("%s: err = %v, want errBadProto", name, err)
+		}
+	}
+}
+
+func TestGRPCMessageFraming(t *testing.T) {
+	var msg protoMessage
+	msg.appendString(1, "run-1")
+	w := httptest.NewRecorder()
+	if err := writeGRPCMessage(w, msg); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readGRPCMessage(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("read %x, want %x", got, msg)
+	}
+
+	compressed := []byte{1, 0, 0, 0, 0}
+	if _, err := readGRPCMessage(bytes.NewReader(compressed)); grpcCode(err) != grpcUnimplemented {
+		t.Errorf("compressed message: err = %v, want Unimplemented", err)
+	}
+	oversized := make([]byte, 5)
+	binary.BigEndian.PutUint32(oversized[1:], maxGRPCMessage+1)
+	if _, err := readGRPCMessage(bytes.NewReader(oversized)); grpcCode(err) != grpcInvalidArgument {
+		t.Errorf("oversized message: err = %v, want InvalidArgument", err)
+	}
+	if _, err := readGRPCMessage(bytes.NewReader([]byte{0, 0, 0, 0, 4, 'a'})); grpcCode(err) != grpcInvalidArgument {
+		t.Errorf("truncated message: err = %v, want InvalidArgument", err)
+	}
+}
+
+func TestGRPCPercentEncode(t *testing.T) {
+	if got, want := grpcPercentEncode("100% done\né"), "100%25 done%0A%C3%A9"; got != want {
+		t.Errorf("grpcPercentEncode = %q, want %q", got, want)
+	}
+}
+
+func grpcCode(err error) int {
+	var ge *grpcError
+	if errors.As(err, &ge) {
+		return ge.code
+	}
+	return -1
+}
+
+// newGRPCTestServer serves the gRPC API over HTTP/2 with TLS, like startGRPCServer
+func newGRPCTestServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewUnstartedServer(&grpcServer{})
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// callGRPC posts a request message to a method and returns the response body
+func callGRPC(t *testing.T, srv *httptest.Server, method string, req protoMessage) *http.Response {
+	frame := make([]byte, 5, 5+len(req))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(req)))
+	httpReq, err := http.NewRequest(http.MethodPost, srv.URL+"/"+grpcService+"/"+method, bytes.NewReader(append(frame, req...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq.Header.Set("Content-Type", "application/grpc")
+	httpReq.Header.Set("TE", "trailers")
+	resp, err := srv.Client().Do(httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("served over HTTP/%d, want HTTP/2", resp.ProtoMajor)
+	}
+	return resp
+}
+
+func TestGRPCUnknownMethod(t *testing.T) {
+	resp := callGRPC(t, newGRPCTestServer(t), "Nope", nil)
+	io.Copy(io.Discard, resp.Body)
+	if got := resp.Trailer.Get("Grpc-Status"); got != "12" {
+		t.Errorf("grpc-status = %q, want 12 (Unimplemented)", got)
+	}
+}
+
+func TestGRPCStreamLogs(t *testing.T) {
+	srv := newGRPCTestServer(t)
+	resp := callGRPC(t, srv, "StreamLogs", nil)
+	if got := resp.Trailer.Get("Grpc-Status"); got != "" {
+		t.Fatalf("trailer before the body: %q", got)
+	}
+	io.Copy(io.Discard, resp.Body)
+	if got := resp.Trailer.Get("Grpc-Status"); got != "5" {
+		t.Fatalf("without a running cycle grpc-status = %q, want 5 (NotFound)", got)
+	}
+
+	activity.StartRun("run-stream", time.Now(), []string{"shop", "pay"}, time.Time{})
+	var req protoMessage
+	req.appendString(2, "shop")
+	resp = callGRPC(t, srv, "StreamLogs", req)
+
+	// Publish once the handler follows the step output
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stepLogs.mu.Lock()
+		following := len(stepLogs.followers)
+		stepLogs.mu.Unlock()
+		if following > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("StreamLogs never followed the step output")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, line := range []StepLogLine{
+		{RunID: "run-stream", Variant: "shop", Step: "scan", Stream: "stdout", Line: "first"},
+		{RunID: "run-stream", Variant: "pay", Step: "scan", Stream: "stdout", Line: "other variant"},
+		{RunID: "run-other", Variant: "shop", Step: "scan", Stream: "stdout", Line: "other run"},
+		{RunID: "run-stream", Variant: "shop", Step: "sbom", Stream: "stderr", Line: "second"},
+	} {
+		line.Time = time.Now()
+		stepLogs.publish(line)
+	}
+	activity.FinishRun(&CycleResult{RunID: "run-stream", FinishedAt: time.Now(), Status: cycleSuccess})
+
+	var got []string
+	for {
+		msg, err := readGRPCMessage(resp.Body)
+		if err != nil {
+			break
+		}
+		var variant, step, stream, line string
+		protoFields(msg, func(f int, _ uint64, data []byte) {
+			switch f {
+			case 3:
+				variant = string(data)
+			case 4:
+				step = string(data)
+			case 5:
+				stream = string(data)
+			case 6:
+				line = string(data)
+			}
+		})
+		got = append(got, variant+"/"+step+"/"+stream+": "+line)
+	}
+	want := []string{"shop/scan/stdout: first", "shop/sbom/stderr: second"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("streamed %q, want %q", got, want)
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Errorf("grpc-status = %q, want 0 once the cycle finished", status)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// heartbeatAttempts is how often a ping is tried before giving up on it
+const heartbeatAttempts = 3
+
+// heartbeatClient keeps a slow monitor from holding up a cycle
+var heartbeatClient = &http.Client{Timeout: 10 * time.Second}
+
+// HeartbeatConfig pings a dead-man's-switch monitor (healthchecks.io, Cronitor, ...)
+// when a cycle starts and ends, so a scheduler that stops running gets noticed
+type HeartbeatConfig struct {
+	// URL is pinged when a cycle succeeds; heartbeats are disabled when empty
+	URL string
+	// StartURL is pinged when a cycle starts, by default URL + "/start"
+	StartURL string
+	// FailURL is pinged when a cycle fails or partially fails, by default URL + "/fail"
+	FailURL string
+}
+
+// Enabled reports whether heartbeats are sent
+func (c HeartbeatConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+// startURL returns the URL pinged when a cycle starts
+func (c HeartbeatConfig) startURL() string {
+	if c.StartURL != "" {
+		return c.StartURL
+	}
+	return healthcheckURL(c.URL, "start")
+}
+
+// failURL returns the URL pinged when a cycle fails
+func (c HeartbeatConfig) failURL() string {
+	if c.FailURL != "" {
+		return c.FailURL
+	}
+	return healthcheckURL(c.URL, "fail")
+}
+
+// healthcheckURL appends a healthchecks.io signal to the path of a ping URL
+func healthcheckURL(ping, signal string) string {
+	base, query, _ := strings.Cut(ping, "?")
+	u := strings.TrimSuffix(base, "/") + "/" + signal
+	if query != "" {
+		u += "?" + query
+	}
+	return u
+}
+
+// heartbeatCycleStart tells the monitor that a cycle started
+func heartbeatCycleStart(cfg HeartbeatConfig, dryRun bool) {
+	if !cfg.Enabled() {
+		return
+	}
+	if dryRun {
+		dryRunNote("would ping the heartbeat monitor at %s", redactURL(cfg.startURL()))
+		return
+	}
+	pingHeartbeat("start", cfg.startURL())
+}
+
+// heartbeatCycleEnd tells the monitor how a cycle ended. Partial cycles count as
+// failures.
+func heartbeatCycleEnd(cfg HeartbeatConfig, cycle *CycleResult) {
+	if !cfg.Enabled() {
+		return
+	}
+	event, target := "success", cfg.URL
+	if cycle.Status != cycleSuccess {
+		event, target = "fail", cfg.failURL()
+	}
+	if cycle.DryRun {
+		dryRunNote("would ping the heartbeat monitor at %s", redactURL(target))
+		return
+	}
+	pingHeartbeat(event, target)
+}
+
+// pingHeartbeat sends a ping, retrying briefly; a monitor that can't be reached
+// only costs a warning
+func pingHeartbeat(event, target string) {
+	var err error
+	for attempt := 1; attempt <= heartbeatAttempts; attempt++ {
+		if err = sendHeartbeat(target); err == nil {
+			log.Printf("💓 Heartbeat sent (%s)", event)
+			return
+		}
+		if attempt < heartbeatAttempts {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+	log.Printf("⚠️  Failed to send %s heartbeat to %s: %v", event, redactURL(target), err)
+}
+
+func sendHeartbeat(target string) error {
+	resp, err := heartbeatClient.Get(target)
+	if err != nil {
+		// The ping URL embeds the check's token
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return uerr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("monitor returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Built-in false-positive heuristics
+const (
+	heuristicVendorNotAffected = "vendor-not-affected"
+	heuristicVendorFixed       = "vendor-fix-installed"
+	heuristicOSStreamMismatch  = "os-stream-mismatch"
+	heuristicNotLinked         = "package-not-linked"
+)
+
+// allHeuristics is the default set of enabled heuristics
+var allHeuristics = []string{
+	heuristicVendorNotAffected,
+	heuristicVendorFixed,
+	heuristicOSStreamMismatch,
+	heuristicNotLinked,
+}
+
+// osPackageTypes are result types backed by a distribution's own advisory stream
+var osPackageTypes = map[string]bool{
+	"alpine": true, "wolfi": true, "chainguard": true, "debian": true, "ubuntu": true,
+	"rhel": true, "centos": true, "rocky": true, "almalinux": true, "amazon": true,
+	"oracle": true, "photon": true, "suse": true, "apk": true, "deb": true, "rpm": true,
+}
+
+// unlinkedPathMarkers identify package locations that are shipped but never loaded at runtime
+var unlinkedPathMarkers = []string{"/test/", "/tests/", "/testdata/", "/docs/", "/examples/", "/doc/"}
+
+// FalsePositiveRule is a user-defined rule from the config file. Package and Image
+// accept glob patterns; empty fields match everything.
+type FalsePositiveRule struct {
+	Name    string `json:"name"`
+	CVE     string `json:"cve"`
+	Package string `json:"package"`
+	Image   string `json:"image"`
+	Reason  string `json:"reason"`
+}
+
+// FalsePositiveConfig selects the heuristics and custom rules used to flag disputed findings
+type FalsePositiveConfig struct {
+	Heuristics []string            `json:"heuristics"`
+	Rules      []FalsePositiveRule `json:"rules"`
+}
+
+// Validate reports unknown heuristics and incomplete rules
+func (c FalsePositiveConfig) Validate() []error {
+	var errs []error
+	for _, h := range c.Heuristics {
+		known := false
+		for _, name := range allHeuristics {
+			known = known || h == name
+		}
+		if !known {
+			errs = append(errs, fmt.Errorf("unknown false-positive heuristic %q (known: %s)", h, strings.Join(allHeuristics, ", ")))
+		}
+	}
+	for i, r := range c.Rules {
+		if r.Name == "" || r.Reason == "" {
+			errs = append(errs, fmt.Errorf("false-positive rule #%d needs a name and a reason", i+1))
+		}
+		if r.CVE == "" && r.Package == "" && r.Image == "" {
+			errs = append(errs, fmt.Errorf("false-positive rule %q must match on cve, package or image", r.Name))
+		}
+		for _, pattern := range []string{r.Package, r.Image} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("false-positive rule %q has invalid pattern %q", r.Name, pattern))
+			}
+		}
+	}
+	return errs
+}
+
+// DisputedFinding is a finding flagged by at least one heuristic or rule
+type DisputedFinding struct {
+	Image    string   `json:"image"`
+	CVE      string   `json:"cve"`
+	Package  string   `json:"package"`
+	Severity string   `json:"severity"`
+	Reasons  []string `json:"reasons"`
+}
+
+// DisputedSummary is written to /reports/{variant}/disputed.json after each scan
+type DisputedSummary struct {
+	Variant     string            `json:"variant"`
+	GeneratedAt time.Time         `json:"generated_at"`
+	Total       int               `json:"total"`
+	ByRule      map[string]int    `json:"by_rule"`
+	Findings    []DisputedFinding `json:"findings"`
+}
+
+// disputeReasons evaluates a finding against the enabled heuristics and custom rules
+func (c FalsePositiveConfig) disputeReasons(image string, result, vuln map[string]any) []string {
+	var reasons []string
+	enabled := make(map[string]bool, len(c.Heuristics))
+	for _, h := range c.Heuristics {
+		enabled[h] = true
+	}
+
+	advisory, _ := vuln["VendorAdvisory"].(map[string]any)
+	advisoryStatus := stringField(advisory, "Status")
+
+	if enabled[heuristicVendorNotAffected] && advisoryStatus == advisoryNotAffected {
+		reasons = append(reasons, heuristicVendorNotAffected+": vendor advisory states the package is not affected")
+	}
+
+	if enabled[heuristicVendorFixed] && advisoryStatus == advisoryFixed &&
+		stringField(advisory, "FixedVersion") == stringField(vuln, "InstalledVersion") {
+		reasons = append(reasons, heuristicVendorFixed+": installed version is the vendor's fixed version")
+	}
+
+	// Grype falls back to CPE matching against upstream data, which ignores the backports
+	// a distribution ships in its own package stream. Trivy uses the distro's advisories.
+	if enabled[heuristicOSStreamMismatch] && osPackageTypes[strings.ToLower(stringField(result, "Type"))] &&
+		stringField(vuln, "FoundBy") == "grype" {
+		reasons = append(reasons, heuristicOSStreamMismatch+": OS package matched only by Grype, the distro's advisory stream does not list it")
+	}
+
+	if enabled[heuristicNotLinked] {
+		target := "/" + strings.TrimPrefix(stringField(result, "Target"), "/")
+		for _, marker := range unlinkedPathMarkers {
+			if strings.Contains(target, marker) {
+				reasons = append(reasons, heuristicNotLinked+": package lives under "+strings.Trim(marker, "/")+" files that are not loaded at runtime")
+				break
+			}
+		}
+	}
+
+	for _, r := range c.Rules {
+		if r.matches(image, stringField(vuln, "VulnerabilityID"), stringField(vuln, "PkgName")) {
+			reasons = append(reasons, r.Name+": "+r.Reason)
+		}
+	}
+
+	return reasons
+}
+
+func (r FalsePositiveRule) matches(image, cve, pkg string) bool {
+	if r.CVE != "" && !strings.EqualFold(r.CVE, cve) {
+		return false
+	}
+	if r.Package != "" {
+		if ok, _ := path.Match(r.Package, pkg); !ok {
+			return false
+		}
+	}
+	if r.Image != "" {
+		if ok, _ := path.Match(r.Image, image); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// flagFalsePositives marks likely false positives in a variant's merged reports as
+// "Disputed" (with the reasons) so they are reported separately instead of counted
+func flagFalsePositives(variant string, cfg FalsePositiveConfig) (*DisputedSummary, error) {
+	files, err := mergedReportFiles(variant)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &DisputedSummary{Variant: variant, GeneratedAt: time.Now().UTC(), ByRule: make(map[string]int)}
+	for _, file := range files {
+		report, err := readTrivyReport(file)
+		if err != nil {
+			return nil, err
+		}
+		image := report.ArtifactName
+
+		err = rewriteMergedReport(file, func(result, vuln map[string]any) {
+			reasons := cfg.disputeReasons(image, result, vuln)
+			if len(reasons) == 0 {
+				delete(vuln, "Disputed")
+				delete(vuln, "DisputeReasons")
+				return
+			}
+
+			vuln["Disputed"] = true
+			vuln["DisputeReasons"] = reasons
+			summary.Total++
+			for _, reason := range reasons {
+				rule, _, _ := strings.Cut(reason, ":")
+				summary.ByRule[rule]++
+			}
+			summary.Findings = append(summary.Findings, DisputedFinding{
+				Image:    image,
+				CVE:      stringField(vuln, "VulnerabilityID"),
+				Package:  stringField(vuln, "PkgName"),
+				Severity: stringField(vuln, "Severity"),
+				Reasons:  reasons,
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(summary.Findings, func(i, j int) bool {
+		a, b := summary.Findings[i], summary.Findings[j]
+		if a.Image != b.Image {
+			return a.Image < b.Image
+		}
+		return a.CVE < b.CVE
+	})
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(variantDir(variant), "disputed.json"), data, 0o644); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
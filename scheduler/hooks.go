@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// Hook phases
+const (
+	hookPre  = "pre"
+	hookPost = "post"
+)
+
+// HooksConfig lists the commands run around each variant's pipeline
+type HooksConfig struct {
+	// Pre hooks run before the pipeline, e.g. to warm caches
+	Pre []HookConfig `json:"pre,omitempty"`
+	// Post hooks run after the pipeline, whether it succeeded or not, e.g. to clean up
+	// or chown the reports
+	Post []HookConfig `json:"post,omitempty"`
+}
+
+// HookConfig is a command run before or after a variant's pipeline
+type HookConfig struct {
+	// Name identifies the hook in logs and its log file, defaulting to "pre-1", "post-1", ...
+	Name string `json:"name,omitempty"`
+	// Command is the argv of the hook, run without a shell
+	Command []string `json:"command"`
+	// Timeout stops the hook after this long, e.g. "5m" (default: STEP_TIMEOUT)
+	Timeout string `json:"timeout,omitempty"`
+	// Required hooks fail the variant when they fail, the default; a failed required pre
+	// hook also skips the pipeline
+	Required *bool `json:"required,omitempty"`
+	// PassEnv adds variables of the scheduler's environment, or prefixes ending in *,
+	// that the hook inherits
+	PassEnv []string `json:"pass_env,omitempty"`
+}
+
+// shellHook runs a command line from PRE_SCAN_HOOK or POST_SCAN_HOOK with /bin/sh, or
+// cmd on Windows
+func shellHook(name, command string) []HookConfig {
+	if command == "" {
+		return nil
+	}
+	return []HookConfig{{Name: name, Command: append(hookShell(), command)}}
+}
+
+// displayName returns the configured name or the hook's phase and position
+func (h HookConfig) displayName(phase string, i int) string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return fmt.Sprintf("%s-%d", phase, i+1)
+}
+
+// IsRequired reports whether a failure of the hook fails the variant
+func (h HookConfig) IsRequired() bool {
+	return h.Required == nil || *h.Required
+}
+
+// Validate checks the hook lists for settings that would fail at run time
+func (c HooksConfig) Validate() []error {
+	var errs []error
+	for _, phase := range []string{hookPre, hookPost} {
+		hooks := c.Pre
+		if phase == hookPost {
+			hooks = c.Post
+		}
+		names := make(map[string]bool)
+		for i, h := range hooks {
+			name := h.displayName(phase, i)
+			// The name is part of the hook's log file name
+			if !variantNamePattern.MatchString(name) {
+				errs = append(errs, fmt.Errorf("%s hook name %q must be lowercase letters, digits and dashes", phase, name))
+			}
+			if names[name] {
+				errs = append(errs, fmt.Errorf("%s hook %q configured more than once", phase, name))
+			}
+			names[name] = true
+			if len(h.Command) == 0 || h.Command[0] == "" {
+				errs = append(errs, fmt.Errorf("%s hook %q needs a command", phase, name))
+			}
+			if d, err := time.ParseDuration(h.Timeout); h.Timeout != "" && (err != nil || d < 0) {
+				errs = append(errs, fmt.Errorf("%s hook %q has an invalid timeout %q", phase, name, h.Timeout))
+			}
+			errs = append(errs, validatePassEnv(fmt.Sprintf("%s hook %q pass_env", phase, name), h.PassEnv)...)
+		}
+	}
+	return errs
+}
+
+// runHooks runs the hooks of a phase in order. For post hooks, pipelineErr is the
+// pipeline's outcome, passed on in SCAN_RESULT and SCAN_ERROR. It returns the error
+// of the first failed required hook; later hooks still run.
+func (j *ScanJob) runHooks(phase string, hooks []HookConfig, pipelineErr error) error {
+	var required error
+	for i, h := range hooks {
+		name := h.displayName(phase, i)
+		if err := j.runHook(phase, name, h, pipelineErr); err != nil {
+			if h.IsRequired() {
+				j.Log.Printf("[%s] ❌ %s hook %s failed: %v", j.Variant, phase, name, err)
+				if required == nil {
+					required = fmt.Errorf("%s hook %s: %w", phase, name, err)
+				}
+			} else {
+				j.Log.Printf("[%s] ⚠️  %s hook %s failed: %v", j.Variant, phase, name, err)
+			}
+		}
+	}
+	return required
+}
+
+func (j *ScanJob) runHook(phase, name string, h HookConfig, pipelineErr error) error {
+	cmd := exec.Command(h.Command[0], h.Command[1:]...)
+	cmd.Env = j.envWith(j.Config.passEnv("", h.PassEnv),
+		"SCAN_VARIANT="+j.Variant,
+		"SCAN_RUN_ID="+j.RunID,
+		"SCAN_HOOK="+phase,
+		"REPORTS_PATH="+variantReportsRoot(j.Variant),
+		"SCAN_REPORTS_DIR="+variantDir(j.Variant),
+	)
+	if phase == hookPost {
+		result := "success"
+		if pipelineErr != nil {
+			result = "failure"
+			cmd.Env = append(cmd.Env, "SCAN_ERROR="+pipelineErr.Error())
+		}
+		cmd.Env = append(cmd.Env, "SCAN_RESULT="+result)
+	}
+
+	timeout := j.Config.StepTimeout
+	if d, err := time.ParseDuration(h.Timeout); err == nil {
+		timeout = d
+	}
+	j.Log.Printf("[%s] 🪝 Running %s hook %s...", j.Variant, phase, name)
+	step := "hook-" + name
+	span := j.startStep(step)
+	err := j.withTimeout(timeout, func() error { return j.runLogged(step, cmd) })
+	span.End(err)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("exit code %d", exitErr.ExitCode())
+		}
+		return err
+	}
+	return nil
+}
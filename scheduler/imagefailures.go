@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// failedImagesFile is written by scan-vulnerabilities.sh for images whose scan
+// failed or ran out of SCAN_IMAGE_TIMEOUT, one "image<TAB>reason" line each
+const failedImagesFile = ".failed-images"
+
+// FailedImage is an image whose scan failed while the rest of its variant went on
+type FailedImage struct {
+	Image  string `json:"image"`
+	Reason string `json:"reason"`
+}
+
+// readFailedImages returns the images the last scan of a variant failed to scan
+func readFailedImages(variant string) ([]FailedImage, error) {
+	data, err := os.ReadFile(filepath.Join(variantDir(variant), failedImagesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var failed []FailedImage
+	for _, line := range splitLines(data) {
+		image, reason, _ := strings.Cut(line, "\t")
+		failed = append(failed, FailedImage{Image: image, Reason: reason})
+	}
+	return failed, nil
+}
+
+// describeFailedImages renders failed images for logs, e.g. "redis:7 (grype failed (exit 1))"
+func describeFailedImages(failed []FailedImage) string {
+	parts := make([]string, len(failed))
+	for i, f := range failed {
+		parts[i] = f.Image + " (" + f.Reason + ")"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultIntegritySchedule = "0 4 * * 0" // Sundays at 4 AM UTC
+	integrityOff             = "off"
+	integrityQueryTimeout    = 5 * time.Minute
+	integrityReportFile      = "integrity.json"
+)
+
+// integrityCheck finds one kind of drift between the tables. Checks without a repair
+// statement are only reported.
+type integrityCheck struct {
+	Name        string
+	Description string
+	Count       string
+	Repair      string
+}
+
+// fullLoadScans are the scans that store every finding, so their rows can be checked
+// against their summary counts. The hour of slack keeps loads still in progress out.
+const fullLoadScans = `s.scan_status = 'completed'
+	AND COALESCE(s.load_mode, 'full') = 'full'
+	AND s.created_at < NOW() - INTERVAL '1 hour'`
+
+// rawScanCounts recomputes each scan's summary counts from its finding rows
+const rawScanCounts = `WITH raw AS (
+	SELECT scan_id,
+		COUNT(*) FILTER (WHERE NOT COALESCE(disputed, FALSE) AND severity = 'CRITICAL') AS critical,
+		COUNT(*) FILTER (WHERE NOT COALESCE(disputed, FALSE) AND severity = 'HIGH') AS high,
+		COUNT(*) FILTER (WHERE NOT COALESCE(disputed, FALSE) AND severity = 'MEDIUM') AS medium,
+		COUNT(*) FILTER (WHERE NOT COALESCE(disputed, FALSE) AND severity = 'LOW') AS low,
+		COUNT(*) FILTER (WHERE COALESCE(disputed, FALSE)) AS disputed
+	FROM vulnerabilities
+	GROUP BY scan_id
+)`
+
+const scanCountsDiffer = `(s.critical_count, s.high_count, s.medium_count, s.low_count, s.disputed_count)
+	IS DISTINCT FROM (r.critical, r.high, r.medium, r.low, r.disputed)`
+
+var integrityChecks = []integrityCheck{
+	{
+		Name:        "scans_without_findings",
+		Description: "completed scans that counted findings but have no finding rows (interrupted loads); repair marks them failed",
+		Count: `SELECT COUNT(*) FROM scans s WHERE ` + fullLoadScans + `
+			AND s.total_vulnerabilities + COALESCE(s.disputed_count, 0) > 0
+			AND NOT EXISTS (SELECT 1 FROM vulnerabilities v WHERE v.scan_id = s.id)`,
+		Repair: `UPDATE scans s SET scan_status = 'failed' WHERE ` + fullLoadScans + `
+			AND s.total_vulnerabilities + COALESCE(s.disputed_count, 0) > 0
+			AND NOT EXISTS (SELECT 1 FROM vulnerabilities v WHERE v.scan_id = s.id)`,
+	},
+	{
+		Name:        "findings_missing_image",
+		Description: "findings whose image no longer exists; repair deletes them",
+		Count:       `SELECT COUNT(*) FROM vulnerabilities v WHERE NOT EXISTS (SELECT 1 FROM images i WHERE i.id = v.image_id)`,
+		Repair:      `DELETE FROM vulnerabilities v WHERE NOT EXISTS (SELECT 1 FROM images i WHERE i.id = v.image_id)`,
+	},
+	{
+		Name:        "findings_image_mismatch",
+		Description: "findings attributed to a different image than their scan; repair takes the scan's image",
+		Count:       `SELECT COUNT(*) FROM vulnerabilities v JOIN scans s ON s.id = v.scan_id WHERE v.image_id <> s.image_id`,
+		Repair:      `UPDATE vulnerabilities v SET image_id = s.image_id FROM scans s WHERE s.id = v.scan_id AND v.image_id <> s.image_id`,
+	},
+	{
+		Name:        "scan_counts_mismatch",
+		Description: "scans whose severity counts differ from their finding rows; repair recomputes the counts",
+		Count: rawScanCounts + `
+			SELECT COUNT(*) FROM scans s JOIN raw r ON r.scan_id = s.id WHERE ` + fullLoadScans + ` AND ` + scanCountsDiffer,
+		Repair: rawScanCounts + `
+			UPDATE scans s SET
+				critical_count = r.critical,
+				high_count = r.high,
+				medium_count = r.medium,
+				low_count = r.low,
+				disputed_count = r.disputed,
+				total_vulnerabilities = r.critical + r.high + r.medium + r.low
+			FROM raw r WHERE r.scan_id = s.id AND ` + fullLoadScans + ` AND ` + scanCountsDiffer,
+	},
+	{
+		Name:        "lifecycle_without_details",
+		Description: "active lifecycle entries whose finding row was deleted; the next load of the image restores them",
+		Count:       `SELECT COUNT(*) FROM vulnerability_lifecycle WHERE status = 'active' AND vuln_id IS NULL`,
+	},
+}
+
+// IntegrityIssue is a check that found discrepancies in one schema
+type IntegrityIssue struct {
+	Check string `json:"check"`
+	// Tenant names the tenant whose database the schema is in; empty for DB_*
+	Tenant      string `json:"tenant,omitempty"`
+	Schema      string `json:"schema"`
+	Description string `json:"description"`
+	Found       int64  `json:"found"`
+	Repaired    int64  `json:"repaired"`
+}
+
+// IntegrityReport is the outcome of an integrity run, saved to /reports/integrity.json
+type IntegrityReport struct {
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	Repair     bool             `json:"repair"`
+	Schemas    []string         `json:"schemas"`
+	Issues     []IntegrityIssue `json:"issues"`
+	Errors     []string         `json:"errors,omitempty"`
+}
+
+// Clean reports whether the run completed without leaving discrepancies behind
+func (r *IntegrityReport) Clean() bool {
+	if len(r.Errors) > 0 {
+		return false
+	}
+	for _, issue := range r.Issues {
+		if issue.Repaired < issue.Found {
+			return false
+		}
+	}
+	return true
+}
+
+// runIntegrityCheck runs every check against the shared tables and each per-variant
+// schema of each database, repairing what it can when repair is set
+func runIntegrityCheck(cfg *Config, repair bool) *IntegrityReport {
+	report := &IntegrityReport{StartedAt: time.Now().UTC(), Repair: repair, Issues: []IntegrityIssue{}}
+	defer func() { report.FinishedAt = time.Now().UTC() }()
+
+	for _, db := range cfg.Databases() {
+		checkIntegrityOf(db, repair, report)
+	}
+	return report
+}
+
+// checkIntegrityOf runs the checks against one database
+func checkIntegrityOf(tdb TenantDatabase, repair bool, report *IntegrityReport) {
+	fail := func(err error) {
+		if tdb.Tenant != "" {
+			err = fmt.Errorf("database of tenant %s: %w", tdb.Tenant, err)
+		}
+		report.Errors = append(report.Errors, err.Error())
+	}
+	if err := tdb.DB.requirePostgres("the integrity check"); err != nil {
+		fail(err)
+		return
+	}
+	db, err := sql.Open("postgres", tdb.DB.DSN())
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), integrityQueryTimeout)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		fail(fmt.Errorf("database unavailable: %w", err))
+		return
+	}
+
+	for _, schema := range integritySchemas(ctx, db) {
+		name := qualifiedSchema(tdb.Tenant, schema)
+		report.Schemas = append(report.Schemas, name)
+		for _, check := range integrityChecks {
+			issue, err := runIntegrityCheckIn(ctx, db, schema, check, repair)
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s in %s: %v", check.Name, name, err))
+				continue
+			}
+			if issue != nil {
+				issue.Tenant = tdb.Tenant
+				report.Issues = append(report.Issues, *issue)
+			}
+		}
+	}
+}
+
+// integritySchemas lists public plus the schemas of variants stored on their own
+func integritySchemas(ctx context.Context, db *sql.DB) []string {
+	schemas := []string{"public"}
+	rows, err := db.QueryContext(ctx, `SELECT schema_name FROM public.variant_catalog WHERE schema_name <> 'public' ORDER BY schema_name`)
+	if err != nil {
+		// Databases created before the catalog existed only have public
+		return schemas
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schema string
+		if rows.Scan(&schema) == nil {
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas
+}
+
+// runIntegrityCheckIn runs one check in one schema, returning nil when nothing was found
+func runIntegrityCheckIn(ctx context.Context, db *sql.DB, schema string, check integrityCheck, repair bool) (*IntegrityIssue, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SET LOCAL search_path TO "+pq.QuoteIdentifier(schema)+", public"); err != nil {
+		return nil, err
+	}
+
+	var found int64
+	if err := tx.QueryRowContext(ctx, check.Count).Scan(&found); err != nil {
+		return nil, err
+	}
+	if found == 0 {
+		return nil, nil
+	}
+
+	issue := &IntegrityIssue{Check: check.Name, Schema: schema, Description: check.Description, Found: found}
+	if !repair || check.Repair == "" {
+		return issue, nil
+	}
+
+	res, err := tx.ExecContext(ctx, check.Repair)
+	if err != nil {
+		return nil, fmt.Errorf("repair failed: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("repair failed: %w", err)
+	}
+	issue.Repaired, _ = res.RowsAffected()
+	return issue, nil
+}
+
+// logIntegrityReport summarizes an integrity run in the daemon log
+func logIntegrityReport(report *IntegrityReport) {
+	for _, issue := range report.Issues {
+		if issue.Repaired > 0 {
+			log.Printf("🩹 Integrity: %s in %s: %d found, %d repaired", issue.Check, qualifiedSchema(issue.Tenant, issue.Schema), issue.Found, issue.Repaired)
+		} else {
+			log.Printf("⚠️  Integrity: %s in %s: %d found (%s)", issue.Check, qualifiedSchema(issue.Tenant, issue.Schema), issue.Found, issue.Description)
+		}
+	}
+	for _, e := range report.Errors {
+		log.Printf("❌ Integrity check failed: %s", e)
+	}
+	if len(report.Issues) == 0 && len(report.Errors) == 0 {
+		log.Printf("✅ Integrity check found no discrepancies in %d schema(s)", len(report.Schemas))
+	}
+}
+
+// saveIntegrityReport keeps the latest integrity run next to the scan reports
+func saveIntegrityReport(report *IntegrityReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(reportsPath, integrityReportFile), append(data, '\n'), 0o644)
+}
+
+// writeIntegrityReportText prints an integrity run as a table
+func writeIntegrityReportText(w io.Writer, report *IntegrityReport) error {
+	if len(report.Issues) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "SCHEMA\tCHECK\tFOUND\tREPAIRED")
+		for _, issue := range report.Issues {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\n", qualifiedSchema(issue.Tenant, issue.Schema), issue.Check, issue.Found, issue.Repaired)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if len(report.Issues) == 0 && len(report.Errors) == 0 {
+		fmt.Fprintf(w, "No discrepancies found in %d schema(s)\n", len(report.Schemas))
+	}
+	for _, e := range report.Errors {
+		fmt.Fprintf(w, "error: %s\n", e)
+	}
+	return nil
+}
+
+// integrityCommand implements `scheduler integrity check`. Returns 1 when
+// discrepancies remain or a check could not run.
+func integrityCommand(cfg *Config, args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "Usage: scheduler integrity check [--repair] [--format text|json]")
+		return exitUsage
+	}
+	fs := flag.NewFlagSet("integrity check", flag.ContinueOnError)
+	repair := fs.Bool("repair", cfg.IntegrityRepair, "repair the discrepancies that can be repaired")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+
+	report := runIntegrityCheck(cfg, *repair)
+
+	var err error
+	switch *format {
+	case "json":
+		err = writeIndentedJSON(os.Stdout, report)
+	case "text":
+		err = writeIntegrityReportText(os.Stdout, report)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		return exitUsage
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to write report: %v\n", err)
+		return exitFailure
+	}
+	if !report.Clean() {
+		return exitFailure
+	}
+	return exitSuccess
+}
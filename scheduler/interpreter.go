@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Scan engines
+const (
+	// scanEngineScript scans with scan-vulnerabilities.sh and merge-scan-results.py
+	scanEngineScript = "script"
+	// scanEngineBuiltin runs Trivy and Grype from the scheduler and merges in Go,
+	// without bash, Python or jq
+	scanEngineBuiltin = "builtin"
+)
+
+// defaultScriptPython is the Python of the .py pipeline scripts; python.org installs
+// on Windows have no python3
+func defaultScriptPython() string {
+	if runtime.GOOS == "windows" {
+		return "python"
+	}
+	return "python3"
+}
+
+// hookShell runs the command lines of PRE_SCAN_HOOK and POST_SCAN_HOOK
+func hookShell() []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C"}
+	}
+	return []string{"/bin/sh", "-c"}
+}
+
+// splitCommandLine splits an interpreter setting such as `py -3` into its argv.
+// Double quotes keep spaces, e.g. `"C:\Program Files\Git\bin\bash.exe"`.
+func splitCommandLine(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// scriptInterpreter returns the command a pipeline script runs with: the step's own
+// interpreter from the pipeline list, else SCRIPT_SHELL for .sh and SCRIPT_PYTHON for
+// .py scripts
+func (c *Config) scriptInterpreter(step, script string) []string {
+	for _, p := range c.Pipeline {
+		if p.Step == step && len(p.Interpreter) > 0 {
+			return p.Interpreter
+		}
+	}
+	if strings.HasSuffix(script, ".py") {
+		return c.ScriptPython
+	}
+	return c.ScriptShell
+}
+
+// scriptCommand builds the command running a pipeline script of a step
+func (c *Config) scriptCommand(step, script string, args ...string) *exec.Cmd {
+	interpreter := c.scriptInterpreter(step, script)
+	argv := append(append(interpreter[1:len(interpreter):len(interpreter)], filepath.Join(scriptsPath, script)), args...)
+	return exec.Command(interpreter[0], argv...)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// JiraConfig files a ticket per variant breaching the severity thresholds
+type JiraConfig struct {
+	// URL is the Jira base URL; tickets are disabled without one
+	URL     string
+	Project string
+	// User and Token authenticate with basic auth (Jira Cloud: email and API token);
+	// without a user the token is sent as a bearer personal access token
+	User      string
+	Token     string
+	IssueType string
+}
+
+// Enabled reports whether policy violations are filed as Jira tickets
+func (c JiraConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+// jiraLabel marks the ticket of a variant, so repeated scans find it again
+func jiraLabel(variant string) string {
+	return "vuln-demo-policy-" + variant
+}
+
+// syncJiraTicket files a ticket for a variant's threshold breaches, or updates and
+// comments on the variant's ticket that is still open
+func syncJiraTicket(cfg JiraConfig, variant, runID string, violations []PolicyViolation, logger *log.Logger) error {
+	jql := fmt.Sprintf(`project = "%s" AND labels = "%s" AND statusCategory != Done ORDER BY created DESC`, cfg.Project, jiraLabel(variant))
+	var found struct {
+		Issues []struct {
+			Key string `json:"key"`
+		} `json:"issues"`
+	}
+	q := url.Values{"jql": {jql}, "fields": {"key"}, "maxResults": {"1"}}
+	if err := jiraRequest(cfg, http.MethodGet, "/rest/api/2/search?"+q.Encode(), nil, &found); err != nil {
+		return err
+	}
+
+	summary := fmt.Sprintf("Severity thresholds breached in %s: %s", variant, describeViolations(violations))
+	description := jiraDescription(variant, runID, violations)
+
+	if len(found.Issues) == 0 {
+		fields := map[string]any{
+			"project":     map[string]string{"key": cfg.Project},
+			"issuetype":   map[string]string{"name": cfg.IssueType},
+			"summary":     summary,
+			"description": description,
+			"labels":      []string{"vuln-demo", jiraLabel(variant)},
+		}
+		var created struct {
+			Key string `json:"key"`
+		}
+		if err := jiraRequest(cfg, http.MethodPost, "/rest/api/2/issue", map[string]any{"fields": fields}, &created); err != nil {
+			return err
+		}
+		logger.Printf("[%s] 🎫 Filed Jira ticket %s", variant, created.Key)
+		return nil
+	}
+
+	key := found.Issues[0].Key
+	fields := map[string]any{"summary": summary, "description": description}
+	if err := jiraRequest(cfg, http.MethodPut, "/rest/api/2/issue/"+key, map[string]any{"fields": fields}, nil); err != nil {
+		return err
+	}
+	comment := fmt.Sprintf("Still breached by scan run {{%s}}: %s", runID, describeViolations(violations))
+	if err := jiraRequest(cfg, http.MethodPost, "/rest/api/2/issue/"+key+"/comment", map[string]any{"body": comment}, nil); err != nil {
+		return err
+	}
+	logger.Printf("[%s] 🎫 Updated Jira ticket %s", variant, key)
+	return nil
+}
+
+// jiraDescription lists the breached thresholds and, per image, the findings of
+// the breached severities, in Jira wiki markup
+func jiraDescription(variant, runID string, violations []PolicyViolation) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Scan run {{%s}} of the *%s* variant breached the severity thresholds.\n\n", runID, variant)
+	b.WriteString("||Severity||Findings||Threshold||\n")
+	for _, v := range violations {
+		fmt.Fprintf(&b, "|%s|%d|%d|\n", v.Severity, v.Count, v.Max)
+	}
+
+	report, err := buildVariantReport(variant)
+	if err != nil {
+		return b.String()
+	}
+	b.WriteString("\n||Image||")
+	for _, v := range violations {
+		b.WriteString(v.Severity + "||")
+	}
+	b.WriteString("\n")
+	for _, img := range report.Images {
+		row, breaching := "|"+img.Label()+"|", false
+		for _, v := range violations {
+			row += fmt.Sprintf("%d|", img.Severities[v.Severity])
+			breaching = breaching || img.Severities[v.Severity] > 0
+		}
+		if breaching {
+			b.WriteString(row + "\n")
+		}
+	}
+	return b.String()
+}
+
+// jiraRequest calls the Jira REST API, decoding the response into out when set
+func jiraRequest(cfg JiraConfig, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(cfg.URL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cfg.User != "" {
+		req.SetBasicAuth(cfg.User, cfg.Token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s returned %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The custom resources of the controller mode (KUBERNETES_CRDS)
+const (
+	crdGroup   = "vuln-demo.dev"
+	crdVersion = "v1alpha1"
+
+	scanPoliciesResource = "scanpolicies"
+	scanJobsResource     = "scanjobs"
+
+	// kubeServiceAccountDir holds the in-cluster token, CA and namespace of the pod
+	kubeServiceAccountDir   = "/var/run/secrets/kubernetes.io/serviceaccount"
+	defaultScanPolicyName   = "default"
+	defaultKubeSyncInterval = 30 * time.Second
+)
+
+// ScanJob phases, written to status.phase
+const (
+	scanJobPending   = "Pending"
+	scanJobRunning   = "Running"
+	scanJobSucceeded = "Succeeded"
+	scanJobPartial   = "PartiallySucceeded"
+	scanJobFailed    = "Failed"
+)
+
+// KubernetesConfig sets up the controller mode, where a ScanPolicy custom resource
+// holds the schedule, variants and image lists and ScanJob resources trigger scans
+type KubernetesConfig struct {
+	// Enabled reads the configuration from the ScanPolicy and runs the ScanJob controller
+	Enabled bool
+	// APIURL is the API server; in-cluster it is found from KUBERNETES_SERVICE_HOST
+	APIURL string
+	// Namespace holds the resources; in-cluster it defaults to the pod's namespace
+	Namespace string
+	// Policy names the ScanPolicy the scheduler follows
+	Policy string
+	// SyncInterval is how often the resources are polled for changes
+	SyncInterval time.Duration
+}
+
+// kubernetesConfigFromEnv reads KUBERNETES_CRDS, KUBERNETES_API_URL,
+// KUBERNETES_NAMESPACE, SCAN_POLICY_NAME and KUBERNETES_SYNC_INTERVAL
+func kubernetesConfigFromEnv(env *envReader) KubernetesConfig {
+	kc := KubernetesConfig{
+		Enabled:      envBool("KUBERNETES_CRDS"),
+		APIURL:       os.Getenv("KUBERNETES_API_URL"),
+		Namespace:    os.Getenv("KUBERNETES_NAMESPACE"),
+		Policy:       envString("SCAN_POLICY_NAME", defaultScanPolicyName),
+		SyncInterval: env.Duration("KUBERNETES_SYNC_INTERVAL", defaultKubeSyncInterval),
+	}
+	if kc.APIURL == "" {
+		if host := os.Getenv("KUBERNETES_SERVICE_HOST"); host != "" {
+			kc.APIURL = "https://" + net.JoinHostPort(host, envString("KUBERNETES_SERVICE_PORT", "443"))
+		}
+	}
+	if kc.Namespace == "" {
+		if ns, err := os.ReadFile(filepath.Join(kubeServiceAccountDir, "namespace")); err == nil {
+			kc.Namespace = strings.TrimSpace(string(ns))
+		}
+	}
+	return kc
+}
+
+// Validate reports a controller mode that can't find its API server or namespace
+func (kc KubernetesConfig) Validate() []error {
+	if !kc.Enabled {
+		return nil
+	}
+	var errs []error
+	if kc.APIURL == "" {
+		errs = append(errs, errors.New("KUBERNETES_CRDS needs KUBERNETES_API_URL outside a cluster"))
+	}
+	if kc.Namespace == "" {
+		errs = append(errs, errors.New("KUBERNETES_CRDS needs KUBERNETES_NAMESPACE outside a cluster"))
+	}
+	if kc.SyncInterval <= 0 {
+		errs = append(errs, fmt.Errorf("KUBERNETES_SYNC_INTERVAL must be positive, got %s", kc.SyncInterval))
+	}
+	return errs
+}
+
+// kubeObjectMeta is the part of a resource's metadata the controller reads
+type kubeObjectMeta struct {
+	Name              string    `json:"name"`
+	UID               string    `json:"uid,omitempty"`
+	Generation        int64     `json:"generation,omitempty"`
+	CreationTimestamp time.Time `json:"creationTimestamp"`
+}
+
+// KubeScanPolicy is the ScanPolicy custom resource holding the scheduler's
+// configuration. Its spec is a CONFIG_FILE document, which overrides the config file
+// and environment.
+type KubeScanPolicy struct {
+	Metadata kubeObjectMeta  `json:"metadata"`
+	Spec     json.RawMessage `json:"spec"`
+}
+
+// KubeScanPolicyStatus is written back to the ScanPolicy on each reload and cycle
+type KubeScanPolicyStatus struct {
+	// ObservedGeneration is the generation of the spec the scheduler runs with
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Message explains why the latest spec was rejected; empty when it was applied
+	Message       string              `json:"message,omitempty"`
+	Schedule      string              `json:"schedule,omitempty"`
+	NextRun       *time.Time          `json:"nextRun,omitempty"`
+	LastRunID     string              `json:"lastRunID,omitempty"`
+	LastRunStatus string              `json:"lastRunStatus,omitempty"`
+	LastRunTime   *time.Time          `json:"lastRunTime,omitempty"`
+	Variants      []KubeVariantStatus `json:"variants,omitempty"`
+}
+
+// KubeScanJob is the ScanJob custom resource, which triggers a cycle like POST /scan
+type KubeScanJob struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     struct {
+		// Variants to scan; all of them when empty
+		Variants []string `json:"variants,omitempty"`
+	} `json:"spec"`
+	Status KubeScanJobStatus `json:"status"`
+}
+
+// KubeScanJobStatus is the progress and outcome of the cycle a ScanJob triggered
+type KubeScanJobStatus struct {
+	Phase          string              `json:"phase,omitempty"`
+	RunID          string              `json:"runID,omitempty"`
+	Message        string              `json:"message,omitempty"`
+	StartTime      *time.Time          `json:"startTime,omitempty"`
+	CompletionTime *time.Time          `json:"completionTime,omitempty"`
+	Variants       []KubeVariantStatus `json:"variants,omitempty"`
+}
+
+// KubeVariantStatus is the outcome of a variant, as shown in the resources' status
+type KubeVariantStatus struct {
+	Name             string         `json:"name"`
+	Success          bool           `json:"success"`
+	Error            string         `json:"error,omitempty"`
+	Vulnerabilities  int            `json:"vulnerabilities"`
+	Severities       map[string]int `json:"severities,omitempty"`
+	NewCVEs          int            `json:"newCVEs,omitempty"`
+	PolicyViolations int            `json:"policyViolations,omitempty"`
+	// BaselineRegressed is set when the variant regressed against its golden baseline
+	BaselineRegressed bool `json:"baselineRegressed,omitempty"`
+}
+
+// kubeVariantStatuses summarizes a cycle's variants for the resources' status
+func kubeVariantStatuses(cycle *CycleResult) []KubeVariantStatus {
+	statuses := make([]KubeVariantStatus, 0, len(cycle.Variants))
+	for _, v := range cycle.Variants {
+		statuses = append(statuses, KubeVariantStatus{
+			Name:              v.Variant,
+			Success:           v.Success,
+			Error:             v.Error,
+			Vulnerabilities:   v.Vulnerabilities,
+			Severities:        v.Severities,
+			NewCVEs:           len(v.NewCVEs),
+			PolicyViolations:  len(v.Violations),
+			BaselineRegressed: v.BaselineRegression != nil,
+		})
+	}
+	return statuses
+}
+
+// kubeClient speaks to the Kubernetes API server for the custom resources of one
+// namespace, with the pod's service account token when there is one
+type kubeClient struct {
+	cfg    KubernetesConfig
+	client *http.Client
+}
+
+func newKubeClient(cfg KubernetesConfig) (*kubeClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ca, err := os.ReadFile(filepath.Join(kubeServiceAccountDir, "ca.crt")); err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificate found in the service account CA")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &kubeClient{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second, Transport: transport}}, nil
+}
+
+// resourcePath returns the API path of a custom resource, or of the collection
+// without a name
+func (k *kubeClient) resourcePath(resource, name string) string {
+	p := fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s", crdGroup, crdVersion, k.cfg.Namespace, resource)
+	if name != "" {
+		p += "/" + name
+	}
+	return p
+}
+
+// do sends a request to the API server and decodes the response into out
+func (k *kubeClient) do(method, path, contentType string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(k.cfg.APIURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	// Projected service account tokens rotate, so the token is read for each request
+	if token, err := os.ReadFile(filepath.Join(kubeServiceAccountDir, "token")); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// patchStatus merges a status into a resource's status subresource
+func (k *kubeClient) patchStatus(resource, name string, status any) error {
+	return k.do(http.MethodPatch, k.resourcePath(resource, name)+"/status", "application/merge-patch+json",
+		map[string]any{"status": status}, nil)
+}
+
+// scanPolicy fetches the ScanPolicy the scheduler follows
+func (k *kubeClient) scanPolicy() (*KubeScanPolicy, error) {
+	var policy KubeScanPolicy
+	if err := k.do(http.MethodGet, k.resourcePath(scanPoliciesResource, k.cfg.Policy), "", nil, &policy); err != nil {
+		return nil, fmt.Errorf("failed to read ScanPolicy %s/%s: %w", k.cfg.Namespace, k.cfg.Policy, err)
+	}
+	return &policy, nil
+}
+
+// scanJobs lists the ScanJobs of the namespace, oldest first
+func (k *kubeClient) scanJobs() ([]KubeScanJob, error) {
+	var list struct {
+		Items []KubeScanJob `json:"items"`
+	}
+	if err := k.do(http.MethodGet, k.resourcePath(scanJobsResource, ""), "", nil, &list); err != nil {
+		return nil, fmt.Errorf("failed to list ScanJobs: %w", err)
+	}
+	sort.SliceStable(list.Items, func(i, j int) bool {
+		return list.Items[i].Metadata.CreationTimestamp.Before(list.Items[j].Metadata.CreationTimestamp)
+	})
+	return list.Items, nil
+}
+
+// applyScanPolicy overlays the spec of the ScanPolicy, read from the API server
+func (c *Config) applyScanPolicy() error {
+	k, err := newKubeClient(c.Kubernetes)
+	if err != nil {
+		return err
+	}
+	policy, err := k.scanPolicy()
+	if err != nil {
+		return err
+	}
+	fc, err := parseFileConfig(policy.Spec)
+	if err != nil {
+		return fmt.Errorf("invalid spec in ScanPolicy %s: %w", c.Kubernetes.Policy, err)
+	}
+	c.apply(fc)
+	return nil
+}
+
+// kubeController follows the ScanPolicy, runs the cycles ScanJobs ask for and
+// writes their outcome back to the resources
+type kubeController struct {
+	sched   *Scheduler
+	trigger *scanTrigger
+	client  *kubeClient
+
+	// generation is the ScanPolicy generation last applied or rejected
+	generation atomic.Int64
+
+	// runs are the cycles this process triggered, by ScanJob UID, until the
+	// ScanJob shows their outcome
+	mu   sync.Mutex
+	runs map[string]*kubeJobRun
+}
+
+// kubeJobRun is the cycle triggered for a ScanJob. status is the status last
+// written, or to write again when patching it failed.
+type kubeJobRun struct {
+	runID  string
+	status KubeScanJobStatus
+}
+
+func newKubeController(s *Scheduler, trigger *scanTrigger, cfg KubernetesConfig) (*kubeController, error) {
+	client, err := newKubeClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &kubeController{sched: s, trigger: trigger, client: client, runs: map[string]*kubeJobRun{}}, nil
+}
+
+// Run polls the ScanPolicy and ScanJobs every KUBERNETES_SYNC_INTERVAL
+func (kc *kubeController) Run() {
+	if policy, err := kc.client.scanPolicy(); err == nil {
+		kc.generation.Store(policy.Metadata.Generation)
+	}
+	kc.writePolicyStatus("")
+
+	ticker := time.NewTicker(kc.client.cfg.SyncInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		kc.syncPolicy()
+		if kc.sched.elector.IsLeader() {
+			kc.syncJobs()
+		} else {
+			// A standby drops the cycles it queued; the next leader requeues their ScanJobs
+			kc.mu.Lock()
+			clear(kc.runs)
+			kc.mu.Unlock()
+		}
+	}
+}
+
+// syncPolicy reloads the configuration when the ScanPolicy spec changed
+func (kc *kubeController) syncPolicy() {
+	policy, err := kc.client.scanPolicy()
+	if err != nil {
+		log.Printf("⚠️  %v", err)
+		return
+	}
+	if kc.generation.Swap(policy.Metadata.Generation) == policy.Metadata.Generation {
+		return
+	}
+	log.Printf("ScanPolicy %s changed (generation %d), reloading configuration...", policy.Metadata.Name, policy.Metadata.Generation)
+	if err := kc.sched.Reload(); err != nil {
+		log.Printf("❌ Configuration reload failed, keeping previous configuration:\n%v", err)
+		kc.writePolicyStatus(err.Error())
+		return
+	}
+	kc.sched.saveStatus(nil)
+	kc.writePolicyStatus("")
+}
+
+// writePolicyStatus records the applied generation and schedule in the ScanPolicy
+// status, or why the latest spec was rejected
+func (kc *kubeController) writePolicyStatus(message string) {
+	status := map[string]any{"message": message}
+	if message == "" {
+		next := kc.sched.NextRun()
+		status["observedGeneration"] = kc.generation.Load()
+		status["schedule"] = kc.sched.Config().Schedule
+		if !next.IsZero() {
+			status["nextRun"] = next.UTC()
+		}
+	}
+	if err := kc.client.patchStatus(scanPoliciesResource, kc.client.cfg.Policy, status); err != nil {
+		log.Printf("⚠️  Could not update the ScanPolicy status: %v", err)
+	}
+}
+
+// syncJobs triggers a cycle for each new ScanJob and marks the ScanJobs whose cycle
+// has started as running. A Pending or Running ScanJob whose cycle this process
+// doesn't know, left by a restart or a previous leader, is requeued. A ScanJob is
+// triggered once: when writing its status failed, the next sync writes it again.
+func (kc *kubeController) syncJobs() {
+	jobs, err := kc.client.scanJobs()
+	if err != nil {
+		log.Printf("⚠️  %v", err)
+		return
+	}
+	running := activity.Snapshot().Run
+	for _, job := range jobs {
+		key := kubeJobKey(job)
+		kc.mu.Lock()
+		run := kc.runs[key]
+		kc.mu.Unlock()
+		if job.Status.CompletionTime != nil {
+			kc.forgetJob(key)
+			continue
+		}
+		if run != nil {
+			if run.status.CompletionTime != nil || job.Status.RunID != run.runID {
+				kc.writeJobRun(job, run, run.status)
+				continue
+			}
+		}
+		cycleRunning := running != nil && running.RunID == job.Status.RunID && running.FinishedAt == nil
+		switch {
+		case job.Status.Phase == "":
+			kc.startJob(job, "")
+		case run == nil && !cycleRunning:
+			log.Printf("☸️  Requeueing ScanJob %s: run %s was interrupted", job.Metadata.Name, job.Status.RunID)
+			kc.startJob(job, fmt.Sprintf("requeued after run %s was interrupted; ", job.Status.RunID))
+		case job.Status.Phase == scanJobPending && cycleRunning:
+			status := KubeScanJobStatus{Phase: scanJobRunning, RunID: job.Status.RunID, Message: "scanning", StartTime: &running.StartedAt}
+			if run == nil {
+				// The cycle resumed at start-up (QUEUE_MODE=postgres)
+				run = &kubeJobRun{runID: job.Status.RunID}
+				kc.mu.Lock()
+				kc.runs[key] = run
+				kc.mu.Unlock()
+			}
+			kc.writeJobRun(job, run, status)
+		}
+	}
+}
+
+// kubeJobKey identifies a ScanJob across a delete and re-create under the same name
+func kubeJobKey(job KubeScanJob) string {
+	if job.Metadata.UID != "" {
+		return job.Metadata.UID
+	}
+	return job.Metadata.Name
+}
+
+// startJob triggers the cycle of a new or requeued ScanJob. A ScanJob refused
+// because too many cycles are waiting keeps its status and is tried again on the
+// next sync. note prefixes the status message.
+func (kc *kubeController) startJob(job KubeScanJob, note string) {
+	name := job.Metadata.Name
+	scan, err := kc.trigger.Trigger("ScanJob "+name, "Kubernetes", job.Spec.Variants)
+	var te *triggerError
+	if errors.As(err, &te) && (te.status == http.StatusTooManyRequests || te.status == http.StatusServiceUnavailable) {
+		log.Printf("⏳ ScanJob %s waits: %v", name, err)
+		return
+	}
+	now := time.Now().UTC()
+	if err != nil {
+		kc.updateJob(name, KubeScanJobStatus{Phase: scanJobFailed, Message: note + err.Error(), CompletionTime: &now})
+		return
+	}
+	message := "waiting to start"
+	if scan.Queued {
+		message = fmt.Sprintf("queued behind %d triggered cycle(s) or the running one", scan.Position)
+	}
+	run := &kubeJobRun{runID: scan.RunID}
+	kc.mu.Lock()
+	kc.runs[kubeJobKey(job)] = run
+	kc.mu.Unlock()
+	kc.writeJobRun(job, run, KubeScanJobStatus{Phase: scanJobPending, RunID: scan.RunID, Message: note + message})
+}
+
+// writeJobRun writes the status of a ScanJob's cycle, keeping it so the next sync
+// writes it again if patching fails
+func (kc *kubeController) writeJobRun(job KubeScanJob, run *kubeJobRun, status KubeScanJobStatus) {
+	kc.mu.Lock()
+	run.status = status
+	kc.mu.Unlock()
+	kc.updateJob(job.Metadata.Name, status)
+}
+
+// forgetJob drops the cycle of a ScanJob that shows its outcome
+func (kc *kubeController) forgetJob(key string) {
+	kc.mu.Lock()
+	delete(kc.runs, key)
+	kc.mu.Unlock()
+}
+
+func (kc *kubeController) updateJob(name string, status KubeScanJobStatus) {
+	if err := kc.client.patchStatus(scanJobsResource, name, status); err != nil {
+		log.Printf("⚠️  Could not update the status of ScanJob %s: %v", name, err)
+	}
+}
+
+// cycleCompleted writes a finished cycle to the ScanPolicy status and to the
+// ScanJob that triggered it. A nil controller, outside the controller mode, does
+// nothing.
+func (kc *kubeController) cycleCompleted(cycle *CycleResult) {
+	if kc == nil {
+		return
+	}
+	variants := kubeVariantStatuses(cycle)
+	finished := cycle.FinishedAt
+	policyStatus := KubeScanPolicyStatus{
+		ObservedGeneration: kc.generation.Load(),
+		Schedule:           kc.sched.Config().Schedule,
+		LastRunID:          cycle.RunID,
+		LastRunStatus:      cycle.Status,
+		LastRunTime:        &finished,
+		Variants:           variants,
+	}
+	if next := kc.sched.NextRun(); !next.IsZero() {
+		next = next.UTC()
+		policyStatus.NextRun = &next
+	}
+	if err := kc.client.patchStatus(scanPoliciesResource, kc.client.cfg.Policy, policyStatus); err != nil {
+		log.Printf("⚠️  Could not update the ScanPolicy status: %v", err)
+	}
+
+	phase := map[string]string{cycleSuccess: scanJobSucceeded, cyclePartial: scanJobPartial}[cycle.Status]
+	if phase == "" {
+		phase = scanJobFailed
+	}
+	started := cycle.StartedAt
+	status := KubeScanJobStatus{
+		Phase:          phase,
+		RunID:          cycle.RunID,
+		Message:        "cycle " + cycle.Status,
+		StartTime:      &started,
+		CompletionTime: &finished,
+		Variants:       variants,
+	}
+	// Keep the outcome first, so the next sync writes it if listing or patching fails
+	kc.mu.Lock()
+	for _, run := range kc.runs {
+		if run.runID == cycle.RunID {
+			run.status = status
+		}
+	}
+	kc.mu.Unlock()
+
+	jobs, err := kc.client.scanJobs()
+	if err != nil {
+		log.Printf("⚠️  %v", err)
+		return
+	}
+	for _, job := range jobs {
+		if job.Status.RunID != cycle.RunID || job.Status.CompletionTime != nil {
+			continue
+		}
+		kc.updateJob(job.Metadata.Name, status)
+		log.Printf("☸️  ScanJob %s %s", job.Metadata.Name, strings.ToLower(phase))
+	}
+}
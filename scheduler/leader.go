@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+const (
+	// defaultLeaderLockKey is the Postgres advisory lock shared by all replicas ("vulndemo")
+	defaultLeaderLockKey int64 = 0x76756c6e64656d6f
+	leaderRetryInterval        = 10 * time.Second
+	leaderQueryTimeout         = 5 * time.Second
+)
+
+// DSN returns a lib/pq connection URL for the database settings
+func (d DBConfig) DSN() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     d.Host + ":" + strconv.Itoa(d.Port),
+		Path:     "/" + d.Name,
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
+}
+
+// leaderElector lets only one scheduler replica run scheduled cycles. Replicas compete
+// for a session-level Postgres advisory lock; the holder is the leader until its
+// database session ends, at which point a standby takes over.
+type leaderElector struct {
+	db  *sql.DB
+	key int64
+
+	mu     sync.Mutex
+	conn   *sql.Conn // session holding the lock while leader
+	leader bool
+
+	// onElected runs (in its own goroutine) when a standby replica becomes leader
+	onElected func()
+}
+
+func newLeaderElector(cfg DBConfig, key int64) (*leaderElector, error) {
+	db, err := sql.Open("postgres", cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database for leader election: %w", err)
+	}
+	return &leaderElector{db: db, key: key}, nil
+}
+
+// IsLeader reports whether this replica should run scheduled work. A nil elector,
+// used when leader election is disabled, is always the leader.
+func (e *leaderElector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Campaign makes one attempt to acquire (or confirm) leadership and returns the result
+func (e *leaderElector) Campaign() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), leaderQueryTimeout)
+	defer cancel()
+
+	if e.leader {
+		// The lock lives as long as the session; losing the connection means losing the lock
+		if err := e.conn.PingContext(ctx); err == nil {
+			return true
+		}
+		log.Println("⚠️  Lost the leader lock (database session ended), stepping down")
+		e.conn.Close()
+		e.conn, e.leader = nil, false
+		return false
+	}
+
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		log.Printf("⚠️  Leader election: database unavailable: %v", err)
+		return false
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.key).Scan(&acquired); err != nil || !acquired {
+		if err != nil {
+			log.Printf("⚠️  Leader election: %v", err)
+		}
+		conn.Close()
+		return false
+	}
+
+	e.conn, e.leader = conn, true
+	return true
+}
+
+// Resign releases the leader lock, if held, so a standby takes over without waiting
+// for the database session to time out
+func (e *leaderElector) Resign() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leader {
+		ctx, cancel := context.WithTimeout(context.Background(), leaderQueryTimeout)
+		defer cancel()
+		if _, err := e.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", e.key); err != nil {
+			log.Printf("⚠️  Could not release the leader lock: %v", err)
+		}
+		e.conn.Close()
+		e.conn, e.leader = nil, false
+	}
+	e.db.Close()
+}
+
+// Run keeps campaigning for leadership in the background
+func (e *leaderElector) Run() {
+	ticker := time.NewTicker(leaderRetryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		wasLeader := e.IsLeader()
+		isLeader := e.Campaign()
+		if isLeader && !wasLeader {
+			log.Println("👑 Acquired the leader lock, this replica now runs scheduled scans")
+			if e.onElected != nil {
+				go e.onElected()
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// licensesFileName holds a variant's license findings, checked against LICENSE_DENYLIST
+const licensesFileName = "licenses.json"
+
+// licensesStep runs Trivy's license scanner on every freshly scanned image
+// (LICENSE_SCANNING) and flags the packages under a denied license
+type licensesStep struct{}
+
+func (licensesStep) Title() string            { return "Scanning image licenses" }
+func (licensesStep) Enabled(cfg *Config) bool { return cfg.LicenseScanning }
+func (licensesStep) Required() bool           { return false }
+
+func (licensesStep) Plan(j *ScanJob) {
+	j.Log.Printf("[%s] 🧪 Would scan the licenses of %d image(s) with Trivy (denied: %s)",
+		j.Variant, len(j.Images), strings.Join(j.Config.LicenseDenylist, ", "))
+}
+
+func (licensesStep) Run(j *ScanJob) error {
+	images, err := j.scannedImages()
+	if err != nil {
+		return err
+	}
+	failed, err := j.trivyPerImage("license", images, licensesReportFile)
+	if err != nil {
+		return err
+	}
+
+	report, err := buildLicenseReport(j.Variant, j.RunID, j.Images, j.Config.LicenseDenylist)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONFile(filepath.Join(variantDir(j.Variant), licensesFileName), report); err != nil {
+		return fmt.Errorf("could not write %s: %w", licensesFileName, err)
+	}
+	j.Log.Printf("[%s] ✅ %d license finding(s) in %d image(s), %d under a denied license",
+		j.Variant, len(report.Findings), len(images)-len(failed), report.Violations)
+	if len(failed) > 0 {
+		return fmt.Errorf("license scan failed for %d image(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// licensesReportFile returns the name of an image's license scan report
+func licensesReportFile(image string) string {
+	return strings.NewReplacer("/", "_", ":", "_").Replace(image) + "_licenses.json"
+}
+
+// LicenseReport is the licenses step's output, kept in licenses.json
+type LicenseReport struct {
+	// RunID is the cycle the licenses were checked in; the loader only loads the
+	// findings of the cycle it loads
+	RunID    string           `json:"run_id"`
+	Denylist []string         `json:"denylist"`
+	Findings []LicenseFinding `json:"findings"`
+	// Violations counts the findings under a denied license
+	Violations int `json:"violations"`
+}
+
+// LicenseFinding is a license Trivy found in an image, on a package or in a file
+type LicenseFinding struct {
+	Image   string `json:"image"`
+	Package string `json:"package,omitempty"`
+	File    string `json:"file,omitempty"`
+	License string `json:"license"`
+	// Category is Trivy's classification, e.g. restricted, reciprocal or notice
+	Category string `json:"category"`
+	Severity string `json:"severity"`
+	Denied   bool   `json:"denied,omitempty"`
+}
+
+// trivyLicenseReport is the subset of Trivy's license scan output the scheduler reads
+type trivyLicenseReport struct {
+	ArtifactName string `json:"ArtifactName"`
+	Results      []struct {
+		Licenses []struct {
+			Severity string `json:"Severity"`
+			Category string `json:"Category"`
+			PkgName  string `json:"PkgName"`
+			FilePath string `json:"FilePath"`
+			Name     string `json:"Name"`
+		} `json:"Licenses"`
+	} `json:"Results"`
+}
+
+// licenseDenied reports whether license matches an entry of the denylist: the same
+// SPDX identifier or one starting with it, so "AGPL" denies "AGPL-3.0-only"
+func licenseDenied(license string, denylist []string) bool {
+	license = strings.ToUpper(license)
+	for _, denied := range denylist {
+		if strings.HasPrefix(license, strings.ToUpper(denied)) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildLicenseReport reads the license reports of a variant's images, denied licenses
+// first
+func buildLicenseReport(variant, runID string, images, denylist []string) (*LicenseReport, error) {
+	report := &LicenseReport{RunID: runID, Denylist: denylist, Findings: []LicenseFinding{}}
+	for _, image := range images {
+		data, err := os.ReadFile(filepath.Join(variantDir(variant), licensesReportFile(image)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var trivy trivyLicenseReport
+		if err := json.Unmarshal(data, &trivy); err != nil {
+			return nil, fmt.Errorf("failed to parse the license report of %s: %w", image, err)
+		}
+		for _, r := range trivy.Results {
+			for _, l := range r.Licenses {
+				f := LicenseFinding{
+					Image:    image,
+					Package:  l.PkgName,
+					File:     l.FilePath,
+					License:  l.Name,
+					Category: l.Category,
+					Severity: l.Severity,
+					Denied:   licenseDenied(l.Name, denylist),
+				}
+				if f.Denied {
+					report.Violations++
+				}
+				report.Findings = append(report.Findings, f)
+			}
+		}
+	}
+	sort.SliceStable(report.Findings, func(a, b int) bool {
+		return report.Findings[a].Denied && !report.Findings[b].Denied
+	})
+	return report, nil
+}
+
+// readLicenseReport returns the last license scan of a variant, or nil
+func readLicenseReport(variant string) (*LicenseReport, error) {
+	data, err := os.ReadFile(filepath.Join(variantDir(variant), licensesFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report LicenseReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", licensesFileName, err)
+	}
+	return &report, nil
+}
+
+// LicenseViolations returns the findings under a denied license
+func (r *LicenseReport) LicenseViolations() []LicenseFinding {
+	var denied []LicenseFinding
+	for _, f := range r.Findings {
+		if f.Denied {
+			denied = append(denied, f)
+		}
+	}
+	return denied
+}
+
+// describeLicenseViolations counts the denied findings per license for logs, e.g.
+// "AGPL-3.0-only (2), SSPL-1.0 (1)"
+func describeLicenseViolations(denied []LicenseFinding) string {
+	counts := make(map[string]int)
+	for _, f := range denied {
+		counts[f.License]++
+	}
+	parts := make([]string, 0, len(counts))
+	for _, license := range sortedKeys(counts) {
+		parts = append(parts, fmt.Sprintf("%s (%d)", license, counts[license]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// diskQuotaPoll is how often the scratch space of a scan step is measured
+const diskQuotaPoll = 5 * time.Second
+
+// errDiskQuota stops a scan step whose scratch space outgrew SCAN_DISK_QUOTA
+var errDiskQuota = errors.New("scan scratch space exceeded SCAN_DISK_QUOTA")
+
+// ScanLimitsConfig confines the processes of the scan step, so a runaway Grype scan
+// slows down or fails on its own instead of starving or OOM-killing the scheduler
+type ScanLimitsConfig struct {
+	// Nice lowers the CPU priority of the scan processes, 1 to 19; 0 keeps the scheduler's
+	Nice int
+	// Memory caps the memory of the scan processes together, in bytes: a hard limit in a
+	// cgroup v2 of their own where available, else a soft one through GOMEMLIMIT
+	Memory int64
+	// Disk caps the scratch space (TMPDIR) the scanners unpack images to, in bytes
+	Disk int64
+}
+
+// scanLimitsConfigFromEnv reads SCAN_NICE, SCAN_MEMORY_LIMIT and SCAN_DISK_QUOTA
+func scanLimitsConfigFromEnv(env *envReader) ScanLimitsConfig {
+	return ScanLimitsConfig{
+		Nice:   env.Int("SCAN_NICE", 0),
+		Memory: env.Bytes("SCAN_MEMORY_LIMIT", 0),
+		Disk:   env.Bytes("SCAN_DISK_QUOTA", 0),
+	}
+}
+
+// Validate reports a niceness out of range and negative sizes. Raising the priority
+// (a negative niceness) needs privileges the scheduler shouldn't have.
+func (lc ScanLimitsConfig) Validate() []error {
+	var errs []error
+	if lc.Nice < 0 || lc.Nice > 19 {
+		errs = append(errs, fmt.Errorf("SCAN_NICE must be between 0 and 19, got %d", lc.Nice))
+	}
+	if lc.Memory < 0 {
+		errs = append(errs, errors.New("SCAN_MEMORY_LIMIT must not be negative"))
+	}
+	if lc.Disk < 0 {
+		errs = append(errs, errors.New("SCAN_DISK_QUOTA must not be negative"))
+	}
+	return errs
+}
+
+// Enabled tells whether any limit is set
+func (lc ScanLimitsConfig) Enabled() bool {
+	return lc.Nice > 0 || lc.Memory > 0 || lc.Disk > 0
+}
+
+func (lc ScanLimitsConfig) String() string {
+	var limits []string
+	if lc.Nice > 0 {
+		limits = append(limits, fmt.Sprintf("nice %d", lc.Nice))
+	}
+	if lc.Memory > 0 {
+		limits = append(limits, formatBytes(lc.Memory)+" of memory")
+	}
+	if lc.Disk > 0 {
+		limits = append(limits, formatBytes(lc.Disk)+" of scratch disk")
+	}
+	return strings.Join(limits, ", ")
+}
+
+// parseByteSize parses a size with an optional decimal (K, M, G, T) or binary (Ki, Mi,
+// Gi, Ti) unit, optionally followed by B: "512Mi", "2G", "1.5GiB", "1048576"
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "B")
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+	}
+	multiplier := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, multiplier = strings.TrimSuffix(s, u.suffix), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * multiplier), nil
+}
+
+// limitWarnings reports each limit the platform can't enforce once per process
+var limitWarnings sync.Map
+
+func warnLimitOnce(key, format string, args ...any) {
+	if _, seen := limitWarnings.LoadOrStore(key, true); !seen {
+		log.Printf("⚠️  "+format, args...)
+	}
+}
+
+// scanLimits applies the ScanLimitsConfig to the commands of one scan step
+type scanLimits struct {
+	cfg     ScanLimitsConfig
+	cgroup  *memoryCgroup // nil when memory isn't limited by a cgroup
+	scratch string        // private TMPDIR watched against the disk quota
+	// goMemLimit is the soft memory limit of each scanner process
+	goMemLimit int64
+	cancel     context.CancelCauseFunc
+	exceeded   atomic.Bool
+	done       chan struct{}
+}
+
+// startScanLimits sets up the limits of the job's scan step, nil when none are
+// configured. With a disk quota the step's context ends once it is exceeded.
+func (j *ScanJob) startScanLimits() (*scanLimits, error) {
+	cfg := j.Config.ScanLimits
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	if j.Config.DryRun {
+		j.Log.Printf("[%s] 🧪 Would limit the scan to %s", j.Variant, cfg)
+		return nil, nil
+	}
+	l := &scanLimits{cfg: cfg, done: make(chan struct{})}
+	if cfg.Nice > 0 && !groupNiceSupported {
+		warnLimitOnce("nice", "SCAN_NICE is not supported on this platform, scans run at normal priority")
+	}
+
+	if cfg.Memory > 0 {
+		cg, err := newMemoryCgroup(fmt.Sprintf("scan-%s-%s", j.Variant, j.RunID), cfg.Memory)
+		if err != nil {
+			warnLimitOnce("memory", "SCAN_MEMORY_LIMIT is only a soft limit (GOMEMLIMIT): %v", err)
+		}
+		l.cgroup = cg
+		// Trivy and Grype are Go programs: their garbage collector works harder as they
+		// near GOMEMLIMIT. Concurrent image scans share the limit.
+		l.goMemLimit = cfg.Memory / int64(max(j.Config.ScanConcurrency, 1))
+	}
+
+	if cfg.Disk > 0 {
+		dir, err := os.MkdirTemp("", "vuln-scan-"+j.Variant+"-")
+		if err != nil {
+			l.close()
+			return nil, fmt.Errorf("scratch directory for SCAN_DISK_QUOTA: %w", err)
+		}
+		l.scratch = dir
+		parent := j.stepCtx
+		if parent == nil {
+			parent = context.Background()
+		}
+		j.stepCtx, l.cancel = context.WithCancelCause(parent)
+		go l.watchDisk(j)
+	}
+	return l, nil
+}
+
+// watchDisk stops the step once its scratch space outgrows the disk quota
+func (l *scanLimits) watchDisk(j *ScanJob) {
+	ticker := time.NewTicker(diskQuotaPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+		if used := dirSize(l.scratch); used > l.cfg.Disk {
+			j.Log.Printf("[%s] ⛔ Scan scratch space reached %s, over SCAN_DISK_QUOTA (%s), stopping the scan",
+				j.Variant, formatBytes(used), formatBytes(l.cfg.Disk))
+			l.exceeded.Store(true)
+			l.cancel(errDiskQuota)
+			return
+		}
+	}
+}
+
+// prepare confines a command before it starts
+func (l *scanLimits) prepare(cmd *exec.Cmd) {
+	if l == nil {
+		return
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	if l.goMemLimit > 0 && !hasEnv(cmd.Env, "GOMEMLIMIT") {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GOMEMLIMIT=%d", l.goMemLimit))
+	}
+	if l.scratch != "" {
+		cmd.Env = append(cmd.Env, "TMPDIR="+l.scratch)
+	}
+	l.cgroup.apply(cmd)
+}
+
+// started lowers the priority of a started command's process group
+func (l *scanLimits) started(pgid int) {
+	if l == nil || l.cfg.Nice == 0 || !groupNiceSupported {
+		return
+	}
+	if err := setGroupNice(pgid, l.cfg.Nice); err != nil {
+		warnLimitOnce("nice-"+err.Error(), "Could not apply SCAN_NICE: %v", err)
+	}
+}
+
+// finish reports what the limits stopped and releases them, returning errDiskQuota
+// in place of the step's error when the disk quota ended it
+func (l *scanLimits) finish(j *ScanJob, err error) error {
+	if l == nil {
+		return err
+	}
+	if n := l.cgroup.oomKills(); n > 0 {
+		j.Log.Printf("[%s] ⚠️  %d scan process(es) were killed at SCAN_MEMORY_LIMIT (%s)", j.Variant, n, formatBytes(l.cfg.Memory))
+	}
+	l.close()
+	if err != nil && l.exceeded.Load() {
+		return fmt.Errorf("%w (%s)", errDiskQuota, formatBytes(l.cfg.Disk))
+	}
+	return err
+}
+
+// close stops watching the disk and removes the scratch space and the cgroup
+func (l *scanLimits) close() {
+	close(l.done)
+	if l.cancel != nil {
+		l.cancel(nil)
+	}
+	if l.scratch != "" {
+		os.RemoveAll(l.scratch)
+	}
+	l.cgroup.close()
+}
+
+// dirSize adds up the size of the files under dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// hasEnv tells whether an environment sets a variable
+func hasEnv(env []string, name string) bool {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, name+"=") {
+			return true
+		}
+	}
+	return false
+}
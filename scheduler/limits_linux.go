@@ -0,0 +1,173 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// cgroupRoot is where the unified (v2) cgroup hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// groupNiceSupported tells whether SCAN_NICE can be applied on this platform
+const groupNiceSupported = true
+
+// setGroupNice sets the niceness of every process of a group; the processes they
+// start inherit it
+func setGroupNice(pgid, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PGRP, pgid, nice)
+}
+
+// memoryCgroup is the cgroup v2 a scan step's processes are started in, its memory
+// capped at SCAN_MEMORY_LIMIT, so the kernel kills a runaway scanner and not the
+// scheduler when the limit is reached
+type memoryCgroup struct {
+	dir string
+	fd  int
+}
+
+var (
+	cgroupSetup    sync.Once
+	cgroupParent   string
+	cgroupSetupErr error
+)
+
+// newMemoryCgroup creates a cgroup for a scan step under the scheduler's own
+func newMemoryCgroup(name string, limit int64) (*memoryCgroup, error) {
+	cgroupSetup.Do(func() { cgroupParent, cgroupSetupErr = setupCgroupParent() })
+	if cgroupSetupErr != nil {
+		return nil, cgroupSetupErr
+	}
+	dir := filepath.Join(cgroupParent, name)
+	if err := os.Mkdir(dir, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(limit, 10)), 0); err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+	// Swapping would let the scan outgrow the limit; the file is missing without swap accounting
+	os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0)
+	fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+	return &memoryCgroup{dir: dir, fd: fd}, nil
+}
+
+// setupCgroupParent finds the scheduler's cgroup and lets its children use the memory
+// controller
+func setupCgroupParent() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("no cgroup v2 hierarchy at %s", cgroupRoot)
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	own := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			own = path
+		}
+	}
+	if own == "" {
+		return "", errors.New("the scheduler is not in a cgroup v2")
+	}
+	dir := filepath.Join(cgroupRoot, own)
+	controllers, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(strings.Fields(string(controllers)), "memory") {
+		return "", fmt.Errorf("the memory controller isn't available in cgroup %s", own)
+	}
+	if err := enableMemoryController(dir); err != nil {
+		return "", fmt.Errorf("could not enable the memory controller in cgroup %s: %w", own, err)
+	}
+	return dir, nil
+}
+
+// enableMemoryController delegates the memory controller to the children of a cgroup.
+// A cgroup holding processes can't, so they are moved to a "scheduler" child first:
+// in a container, the scheduler and whatever runs next to it.
+func enableMemoryController(dir string) error {
+	control := filepath.Join(dir, "cgroup.subtree_control")
+	enabled, err := os.ReadFile(control)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(strings.Fields(string(enabled)), "memory") {
+		return nil
+	}
+	err = os.WriteFile(control, []byte("+memory"), 0)
+	if !errors.Is(err, syscall.EBUSY) {
+		return err
+	}
+	leaf := filepath.Join(dir, "scheduler")
+	if err := os.Mkdir(leaf, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	procs, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, pid := range strings.Fields(string(procs)) {
+		// A process may have exited meanwhile
+		os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(pid), 0)
+	}
+	return os.WriteFile(control, []byte("+memory"), 0)
+}
+
+// apply starts a command in the cgroup, along with everything it starts
+func (c *memoryCgroup) apply(cmd *exec.Cmd) {
+	if c == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = c.fd
+}
+
+// oomKills returns how many processes of the cgroup were killed at its memory limit
+func (c *memoryCgroup) oomKills() int {
+	if c == nil {
+		return 0
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if count, ok := strings.CutPrefix(line, "oom_kill "); ok {
+			n, _ := strconv.Atoi(count)
+			return n
+		}
+	}
+	return 0
+}
+
+// close removes the cgroup; its processes are gone once their group was reaped
+func (c *memoryCgroup) close() {
+	if c == nil {
+		return
+	}
+	syscall.Close(c.fd)
+	if err := os.Remove(c.dir); err != nil {
+		log.Printf("⚠️  Could not remove cgroup %s: %v", c.dir, err)
+	}
+}
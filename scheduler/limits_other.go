@@ -0,0 +1,27 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// groupNiceSupported tells whether SCAN_NICE can be applied on this platform
+const groupNiceSupported = false
+
+// setGroupNice is only implemented on Linux
+func setGroupNice(int, int) error {
+	return errors.New("not supported on this platform")
+}
+
+// memoryCgroup needs Linux cgroups; elsewhere SCAN_MEMORY_LIMIT is a soft limit
+type memoryCgroup struct{}
+
+func newMemoryCgroup(string, int64) (*memoryCgroup, error) {
+	return nil, errors.New("cgroups are only available on Linux")
+}
+
+func (*memoryCgroup) apply(*exec.Cmd) {}
+func (*memoryCgroup) oomKills() int   { return 0 }
+func (*memoryCgroup) close()          {}
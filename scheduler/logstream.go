@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// followerBuffer is how many lines a slow follower may fall behind before lines are
+// dropped for it; a follower never holds up a step
+const followerBuffer = 1024
+
+// StepLogLine is one line of a pipeline step's output
+type StepLogLine struct {
+	Time    time.Time
+	RunID   string
+	Variant string
+	Step    string
+	// Stream is "stdout" or "stderr"
+	Stream string
+	Line   string
+}
+
+// stepLogs fans the output of running steps out to live followers (gRPC StreamLogs)
+var stepLogs = &stepLogBroadcaster{followers: make(map[chan StepLogLine]bool)}
+
+type stepLogBroadcaster struct {
+	mu        sync.Mutex
+	followers map[chan StepLogLine]bool
+}
+
+// Follow returns a channel receiving every step output line from now on, and a
+// function that stops following
+func (b *stepLogBroadcaster) Follow() (<-chan StepLogLine, func()) {
+	ch := make(chan StepLogLine, followerBuffer)
+	b.mu.Lock()
+	b.followers[ch] = true
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.followers, ch)
+		b.mu.Unlock()
+	}
+}
+
+func (b *stepLogBroadcaster) publish(line StepLogLine) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.followers {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
+
+// Writer returns a writer publishing a step's output line by line
+func (b *stepLogBroadcaster) Writer(j *ScanJob, step, stream string) *stepLogWriter {
+	return &stepLogWriter{b: b, line: StepLogLine{RunID: j.RunID, Variant: j.Variant, Step: step, Stream: stream}}
+}
+
+// stepLogWriter splits a step's output into lines for the broadcaster. Partial lines
+// wait for their newline.
+type stepLogWriter struct {
+	b       *stepLogBroadcaster
+	line    StepLogLine
+	pending []byte
+}
+
+func (w *stepLogWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.line
+		line.Time = time.Now().UTC()
+		line.Line = string(bytes.TrimRight(w.pending[:i], "\r"))
+		w.pending = w.pending[i+1:]
+		w.b.publish(line)
+	}
+	return len(p), nil
+}
@@ -1,124 +1,676 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
-	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/robfig/cron/v3"
 )
 
 const (
-	scriptsPath = "/scripts"
-	reportsPath = "/reports"
+	// defaultMissedRunTolerance is how late a scheduled run may be before it is caught up on startup
+	defaultMissedRunTolerance = time.Hour
 )
 
+// defaultVariants are scanned by every scheduled cycle
+var defaultVariants = []string{"baseline", "chainguard"}
+
+// stepOutput receives the standard output of pipeline scripts
+var stepOutput io.Writer = os.Stdout
+
 // ScanJob represents a vulnerability scanning job
 type ScanJob struct {
 	Variant string
+	Config  *Config
+	// RunID identifies the cycle; it is passed to the scripts and stored with the scans
+	RunID string
+	// Log prefixes every line with the run ID
+	Log *log.Logger
+	// Deadline is when the cycle's time budget runs out; images not started by then are skipped
+	Deadline time.Time
+	// Priority lists images to scan before the others, e.g. those skipped by the previous cycle
+	Priority []string
+	// Excluded lists images kept out of the scan by exclusion rules
+	Excluded []string
+	// Images lists the images to scan, checked for changes with SKIP_UNCHANGED_IMAGES
+	Images []string
+	// Env is set to the variant's env and secret files, added to every command it runs
+	Env []string
+	// SecretEnv names the variables of Env read from secret files, never logged
+	SecretEnv map[string]bool
+	// Unchanged is set to the images whose previous report was kept
+	Unchanged []string
+	// ScannerDBs is set to the scanner databases the images were scanned with
+	ScannerDBs map[string]ScannerDB
+	// ScannerVersions is set to the version of each scanner, ScannerChanges to those
+	// that changed since the variant's last scan
+	ScannerVersions map[string]string
+	ScannerChanges  []ScannerVersionChange
+	// LogDir receives a log file per pipeline step; empty disables capture
+	LogDir string
+	// SinkErrors records the sinks that failed to receive the results, by name
+	SinkErrors map[string]string
+	// Span traces the variant's scan; the steps are its children
+	Span *span
+	// StepSpan traces the running pipeline step
+	StepSpan *span
+
+	// stepCtx ends when the running step times out, stopping the commands it runs
+	stepCtx     context.Context
+	stepTimeout time.Duration
+	// passEnv is the running step's allowlist of inherited environment variables
+	passEnv []string
+	// limits confines the processes of the running scan step (SCAN_NICE and co)
+	limits *scanLimits
 }
 
-// RunScan executes the vulnerability scanning pipeline for a given variant
-func (j *ScanJob) RunScan() error {
-	log.Printf("========================================")
-	log.Printf("Starting vulnerability scan for variant: %s", j.Variant)
-	log.Printf("========================================")
+// startStep marks a pipeline step as running and starts its span
+func (j *ScanJob) startStep(step string) *span {
+	activity.SetStep(j.Variant, step)
+	span := startSpan(j.Span.Context(), step)
+	span.SetAttr("scan.variant", j.Variant)
+	span.SetAttr("scan.step", step)
+	return span
+}
 
-	// Step 1: Scan vulnerabilities
-	log.Printf("[%s] Step 1/2: Scanning images with Trivy and Grype...", j.Variant)
-	scanCmd := exec.Command("/bin/bash", fmt.Sprintf("%s/scan-vulnerabilities.sh", scriptsPath), j.Variant)
-	scanCmd.Stdout = os.Stdout
-	scanCmd.Stderr = os.Stderr
-	scanCmd.Env = os.Environ()
+// RunScan runs the pre hooks, the configured pipeline steps and the post hooks for a
+// variant. A failing required step stops the pipeline; other failures are logged and
+// the next step runs. Post hooks run whatever the outcome.
+func (j *ScanJob) RunScan() error {
+	j.Log.Printf("========================================")
+	j.Log.Printf("Starting vulnerability scan for variant: %s", j.Variant)
+	j.Log.Printf("========================================")
 
-	if err := scanCmd.Run(); err != nil {
-		return fmt.Errorf("scan failed for %s: %w", j.Variant, err)
+	env, secret, err := j.Config.VariantEnv(j.Variant)
+	if err != nil {
+		return fmt.Errorf("variant environment: %w", err)
 	}
-	log.Printf("[%s] ✅ Scan completed successfully", j.Variant)
+	j.Env, j.SecretEnv = env, secret
 
-	// Step 2: Load results to database
-	log.Printf("[%s] Step 2/2: Loading results to database...", j.Variant)
-	loadCmd := exec.Command("python3", fmt.Sprintf("%s/load-to-database.py", scriptsPath), "--variant", j.Variant)
-	loadCmd.Stdout = os.Stdout
-	loadCmd.Stderr = os.Stderr
-	loadCmd.Env = os.Environ()
+	err = j.runHooks(hookPre, j.Config.Hooks.Pre, nil)
+	if err == nil {
+		err = j.runPipeline()
+	} else {
+		j.Log.Printf("[%s] ⏭️  Skipping the pipeline after a failed pre hook", j.Variant)
+	}
+	if hookErr := j.runHooks(hookPost, j.Config.Hooks.Post, err); err == nil {
+		err = hookErr
+	}
+	if err != nil {
+		return err
+	}
 
-	if err := loadCmd.Run(); err != nil {
-		return fmt.Errorf("database load failed for %s: %w", j.Variant, err)
+	if !j.Config.DryRun {
+		j.Log.Printf("========================================")
+		j.Log.Printf("✅ Complete scan pipeline finished for variant: %s", j.Variant)
+		j.Log.Printf("========================================")
 	}
-	log.Printf("[%s] ✅ Results loaded to database successfully", j.Variant)
+	return nil
+}
 
-	log.Printf("========================================")
-	log.Printf("✅ Complete scan pipeline finished for variant: %s", j.Variant)
-	log.Printf("========================================")
+// runPipeline runs the enabled pipeline steps in order
+func (j *ScanJob) runPipeline() error {
+	steps := j.enabledSteps()
+	for i, s := range steps {
+		j.passEnv = j.Config.passEnv(s.name, s.policy.PassEnv)
+		if j.Config.DryRun {
+			s.step.Plan(j)
+			continue
+		}
 
+		j.Log.Printf("[%s] Step %d/%d: %s...", j.Variant, i+1, len(steps), s.step.Title())
+		if err := j.runStep(s); err != nil {
+			if s.policy.required(s.step) {
+				return err
+			}
+			j.Log.Printf("[%s] ⚠️  %s step failed: %v", j.Variant, s.name, err)
+		}
+	}
+	j.passEnv = nil
+	if j.Config.DryRun {
+		j.planFollowUps()
+	}
 	return nil
 }
 
-// RunFullScanCycle scans both baseline and chainguard variants
-func RunFullScanCycle() {
-	log.Printf("===========================================")
-	log.Printf("🚀 Starting full vulnerability scan cycle")
-	log.Printf("Time: %s", time.Now().Format(time.RFC3339))
-	log.Printf("===========================================")
+// missedScheduledRun reports whether a scheduled cycle should have run since the
+// last successful run and is overdue by more than the given tolerance
+func missedScheduledRun(schedule string, tolerance time.Duration) bool {
+	if tolerance < 0 {
+		log.Println("Missed-run catch-up disabled (negative MISSED_RUN_TOLERANCE)")
+		return false
+	}
+
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		// Config.Validate reports invalid schedules
+		return false
+	}
+
+	state, err := loadState()
+	if err != nil {
+		log.Printf("⚠️  Could not load scheduler state, skipping missed-run check: %v", err)
+		return false
+	}
+	if state.LastSuccessfulRun.IsZero() {
+		log.Println("No previous successful run recorded, skipping missed-run check")
+		return false
+	}
+
+	due := sched.Next(state.LastSuccessfulRun)
+	overdue := time.Since(due)
+	if overdue <= tolerance {
+		log.Printf("Last successful run: %s (no missed runs)", state.LastSuccessfulRun.Format(time.RFC3339))
+		return false
+	}
+
+	log.Printf("⚠️  Missed scheduled run at %s (last successful run: %s, overdue by %s), catching up now...",
+		due.Format(time.RFC3339), state.LastSuccessfulRun.Format(time.RFC3339), overdue.Round(time.Second))
+	return true
+}
+
+// VariantResult describes the outcome of scanning a single variant
+type VariantResult struct {
+	Variant         string         `json:"variant"`
+	Success         bool           `json:"success"`
+	Error           string         `json:"error,omitempty"`
+	DurationSec     float64        `json:"duration_seconds"`
+	Images          int            `json:"images"`
+	Vulnerabilities int            `json:"vulnerabilities"`
+	Severities      map[string]int `json:"severities,omitempty"`
+	Disputed        int            `json:"disputed,omitempty"`
+	// KnownExploited counts the findings listed in CISA's KEV catalog (EXPLOIT_INTEL)
+	KnownExploited int `json:"known_exploited,omitempty"`
+	// FixAvailable counts the findings with a fixed version available, the actionable ones
+	FixAvailable int `json:"fix_available"`
+	// NewCVEs lists the critical and high CVEs that were not in the previous scan
+	NewCVEs []string `json:"new_cves,omitempty"`
+	// Violations lists the severities over their SEVERITY_THRESHOLDS
+	Violations []PolicyViolation `json:"policy_violations,omitempty"`
+	// LicenseViolations counts the packages and files under a LICENSE_DENYLIST license
+	LicenseViolations int `json:"license_violations,omitempty"`
+	// Skipped lists images not scanned because the cycle ran out of time
+	Skipped []string `json:"skipped_images,omitempty"`
+	// Excluded lists images kept out of the scan by exclusion rules
+	Excluded []string `json:"excluded_images,omitempty"`
+	// Failed lists images whose scan failed or timed out; the variant's other images
+	// are still reported
+	Failed []FailedImage `json:"failed_images,omitempty"`
+	// Unchanged lists images not rescanned because their digest and scanner databases
+	// are the same as at their last scan
+	Unchanged []string `json:"unchanged_images,omitempty"`
+	// ScannerDBs lists the Trivy and Grype databases the variant was scanned with
+	ScannerDBs map[string]ScannerDB `json:"scanner_dbs,omitempty"`
+	// ScannerVersions lists the Trivy and Grype versions the variant was scanned with
+	ScannerVersions map[string]string `json:"scanner_versions,omitempty"`
+	// ScannerChanges lists the scanners whose version changed since the variant's
+	// previous scan, which skews comparisons of finding counts with it
+	ScannerChanges []ScannerVersionChange `json:"scanner_changes,omitempty"`
+	// BaselineRegression is how the findings got worse than the variant's golden baseline
+	BaselineRegression *BaselineRegression `json:"baseline_regression,omitempty"`
+	// LogDir holds the captured output of the pipeline steps
+	LogDir string `json:"log_dir,omitempty"`
+	// SinkErrors lists the sinks that failed to receive the results
+	SinkErrors map[string]string `json:"sink_errors,omitempty"`
+}
+
+// RunFullScanCycle scans each of the given variants in turn, tagging logs and scans with
+// runID. With a MAX_CYCLE_DURATION budget, images not started in time are skipped and
+// scanned first by the next cycle. With QUEUE_MODE=postgres the variants are scanned by
+// external workers instead.
+func RunFullScanCycle(cfg *Config, variants []string, runID string) *CycleResult {
+	logger := runLogger(runID)
+	cycle := &CycleResult{RunID: runID, StartedAt: time.Now().UTC()}
+
+	logger.Printf("===========================================")
+	logger.Printf("🚀 Starting full vulnerability scan cycle")
+	logger.Printf("Time: %s", time.Now().Format(time.RFC3339))
+	logger.Printf("===========================================")
+
+	var deadline time.Time
+	if cfg.MaxCycleDuration > 0 {
+		deadline = time.Now().Add(cfg.MaxCycleDuration)
+		logger.Printf("⏱️  Cycle time budget: %s (until %s)", cfg.MaxCycleDuration, deadline.Format(time.RFC3339))
+	}
+
+	pending := pendingSkippedImages()
+	variants = prioritizeVariants(variants, pending)
+	activity.StartRun(runID, cycle.StartedAt, variants, deadline)
+	cycle.span = startCycleSpan(runID, cycle.StartedAt)
+	cycle.span.SetAttr("scan.variants", len(variants))
+	cycle.span.SetAttr("scan.dry_run", cfg.DryRun)
+	if !cfg.DryRun {
+		publishEvent(eventCycleStarted, runID, "", map[string]any{"variants": variants})
+	}
+
+	var results []VariantResult
+	if cfg.DryRun {
+		// Recording jobs would let workers or a restarted scheduler pick them up
+		cycle.DryRun = true
+		dryRunBanner(logger)
+		prepareDisk(cfg, cfg.QueueMode != queuePostgres, logger)
+		for _, variant := range variants {
+			results = append(results, runVariant(cfg, variant, runID, logger, deadline, pending[variant]))
+		}
+	} else if err := prepareDisk(cfg, cfg.QueueMode != queuePostgres, logger); err != nil {
+		// Scans would fail midway, or leave truncated reports for the loader
+		logger.Printf("❌ %v", err)
+		results = failedVariants(variants, err)
+	} else {
+		results = runCycleJobs(cfg, variants, runID, logger, deadline, pending)
+	}
+	finishCycle(cycle, results, logger)
+	return cycle
+}
+
+// failedVariants returns a failed result for each variant of a cycle that could not run
+func failedVariants(variants []string, err error) []VariantResult {
+	results := make([]VariantResult, len(variants))
+	for i, variant := range variants {
+		results[i] = VariantResult{Variant: variant, Error: err.Error()}
+	}
+	return results
+}
+
+// finishCycle records the variant results of a cycle, logs its outcome and updates the metrics
+func finishCycle(cycle *CycleResult, results []VariantResult, logger *log.Logger) {
+	if !cycle.DryRun {
+		recordSkippedImages(results)
+	}
+
+	cycle.Variants = results
+	cycle.finish()
+	activity.FinishRun(cycle)
+
+	kind := "Full scan cycle"
+	if cycle.Targeted {
+		kind = "Targeted rescan"
+	}
+	logger.Printf("===========================================")
+	switch cycle.Status {
+	case cycleSuccess:
+		logger.Printf("✅ %s completed", kind)
+	case cyclePartial:
+		logger.Printf("⚠️  %s partially completed (failed variants: %s)", kind, strings.Join(cycle.Failed(), ", "))
+	default:
+		logger.Printf("❌ %s failed for every variant", kind)
+	}
+	logger.Printf("Time: %s", time.Now().Format(time.RFC3339))
+	logger.Printf("===========================================")
+
+	if !cycle.DryRun {
+		cycleMetrics.Observe(cycle)
+		publishEvent(eventCycleFinished, cycle.RunID, "", map[string]any{
+			"status":           cycle.Status,
+			"failed_variants":  cycle.Failed(),
+			"duration_seconds": cycle.FinishedAt.Sub(cycle.StartedAt).Seconds(),
+		})
+	}
+	endCycleSpan(cycle)
+}
+
+// runVariant runs the scan pipeline for one variant of a cycle. pending lists images
+// skipped by the previous cycle, which are scanned first.
+func runVariant(cfg *Config, variant, runID string, logger *log.Logger, deadline time.Time, pending []string) VariantResult {
+	start := time.Now()
+	result := VariantResult{Variant: variant, Success: true}
+
+	if !deadline.IsZero() && start.After(deadline) {
+		logger.Printf("⏭️  Cycle time budget exhausted, skipping variant %s", variant)
+		return skippedVariant(cfg, variant, logger, pending)
+	}
+
+	if len(pending) > 0 {
+		logger.Printf("[%s] Scanning %d image(s) skipped by the previous cycle first", variant, len(pending))
+	}
+	activity.SetVariantStatus(variant, jobRunning)
+	span := startSpan(runSpanContext(runID), "scan variant")
+	defer func() { endVariantSpan(span, &result) }()
+
+	listed, err := listVariantImages(cfg, variant)
+	images, excluded := applyExclusions(cfg.Exclusions, variant, mergeImageLists(pending, listed), time.Now(), logger)
+	if err == nil {
+		activity.SetImages(variant, images, excludedImageNames(excluded))
+	}
+	if cfg.DryRun {
+		logPlannedImages(logger, variant, images, excluded, err)
+	} else {
+		for _, e := range excluded {
+			logger.Printf("[%s] 🚫 Excluding %s: %s", variant, e.Image, describeExclusion(e))
+		}
+		if err := writeExcludedImages(variant, excluded); err != nil {
+			logger.Printf("⚠️  Could not record the images excluded from %s: %v", variant, err)
+		}
+	}
+	result.Excluded = excludedImageNames(excluded)
+	job := &ScanJob{
+		Variant:  variant,
+		Config:   cfg,
+		RunID:    runID,
+		Log:      logger,
+		Deadline: deadline,
+		Priority: pending,
+		Excluded: result.Excluded,
+		Images:   images,
+		LogDir:   runLogDir(variant, runID),
+		Span:     span,
+	}
+	span.SetAttr("scan.variant", variant)
+	span.SetAttr("scan.run_id", runID)
+	span.SetAttr("scan.images", len(images))
+	span.SetAttr("scan.excluded_images", len(excluded))
+	result.LogDir = job.LogDir
+	if err := job.RunScan(); err != nil {
+		logger.Printf("❌ Error scanning %s: %v", variant, err)
+		result.Success = false
+		result.Error = err.Error()
+	}
+	result.SinkErrors = job.SinkErrors
+	result.Unchanged = job.Unchanged
+	result.ScannerDBs = job.ScannerDBs
+	result.ScannerVersions = job.ScannerVersions
+	result.ScannerChanges = job.ScannerChanges
+	result.DurationSec = time.Since(start).Seconds()
+	if result.Success {
+		activity.SetVariantStatus(variant, jobSucceeded)
+	} else {
+		activity.SetVariantStatus(variant, jobFailed)
+	}
 
-	// Scan baseline variant
-	baselineJob := &ScanJob{Variant: "baseline"}
-	if err := baselineJob.RunScan(); err != nil {
-		log.Printf("❌ Error scanning baseline: %v", err)
+	// Nothing was scanned; the reports on disk are from an earlier cycle
+	if cfg.DryRun {
+		result.Images = len(images)
+		return result
 	}
 
-	// Scan chainguard variant
-	chainguardJob := &ScanJob{Variant: "chainguard"}
-	if err := chainguardJob.RunScan(); err != nil {
-		log.Printf("❌ Error scanning chainguard: %v", err)
+	// New results are published, cached read responses are stale
+	apiCache.Invalidate()
+
+	if skipped, err := readSkippedImages(variant); err != nil {
+		logger.Printf("⚠️  Could not read skipped images for %s: %v", variant, err)
+	} else if len(skipped) > 0 {
+		logger.Printf("[%s] ⏭️  %d image(s) skipped by the cycle time budget: %s", variant, len(skipped), strings.Join(skipped, ", "))
+		result.Skipped = skipped
+	}
+	if failed, err := readFailedImages(variant); err != nil {
+		logger.Printf("⚠️  Could not read failed images for %s: %v", variant, err)
+	} else if len(failed) > 0 {
+		logger.Printf("[%s] ❌ %d image(s) failed to scan: %s", variant, len(failed), describeFailedImages(failed))
+		result.Failed = failed
+	}
+
+	if summary, err := summarizeVariantReports(variant); err != nil {
+		logger.Printf("⚠️  Could not summarize %s reports: %v", variant, err)
+	} else {
+		result.Images = summary.Images
+		result.Vulnerabilities = summary.Total
+		result.Severities = summary.Severities
+		result.Disputed = summary.Disputed
+		result.KnownExploited = summary.KnownExploited
+		result.FixAvailable = summary.Fixable
+		if result.Violations = cfg.SeverityThresholds.Check(summary.Severities); len(result.Violations) > 0 {
+			logger.Printf("[%s] 🚨 Severity thresholds breached: %s", variant, describeViolations(result.Violations))
+			reportPolicyViolations(cfg, variant, runID, result.Violations, logger)
+		}
 	}
 
-	log.Printf("===========================================")
-	log.Printf("✅ Full scan cycle completed")
-	log.Printf("Time: %s", time.Now().Format(time.RFC3339))
-	log.Printf("===========================================")
+	if report, err := readLicenseReport(variant); err != nil {
+		logger.Printf("⚠️  Could not read the %s license report: %v", variant, err)
+	} else if report != nil && report.RunID == runID && report.Violations > 0 {
+		logger.Printf("[%s] 🚫 %d finding(s) under a denied license: %s", variant, report.Violations, describeLicenseViolations(report.LicenseViolations()))
+		result.LicenseViolations = report.Violations
+	}
+
+	if report, err := detectNewCVEs(variant, runID); err != nil {
+		logger.Printf("⚠️  Could not detect new CVEs in %s: %v", variant, err)
+	} else if report.Baseline {
+		logger.Printf("[%s] 📌 Recorded the critical and high CVEs as the baseline for new CVE detection", variant)
+	} else if len(report.CVEs) > 0 {
+		logger.Printf("[%s] 🆕 %d new critical/high CVE(s): %s", variant, len(report.CVEs), strings.Join(report.IDs(), ", "))
+		result.NewCVEs = report.IDs()
+		announceNewCVEs(cfg, report, logger)
+	}
+	if result.Success {
+		if checkGoldenBaseline(cfg, &result, runID, job.LogDir, logger); !result.Success {
+			activity.SetVariantStatus(variant, jobFailed)
+		}
+	}
+	publishEvent(eventVariantCompleted, runID, variant, result)
+	return result
+}
+
+// endVariantSpan records the outcome of a variant on its span
+func endVariantSpan(span *span, result *VariantResult) {
+	span.SetAttr("scan.vulnerabilities", result.Vulnerabilities)
+	span.SetAttr("scan.skipped_images", len(result.Skipped))
+	span.SetAttr("scan.duration_seconds", result.DurationSec)
+	var err error
+	if !result.Success {
+		err = errors.New(result.Error)
+	}
+	span.End(err)
+}
+
+// skippedVariant is the result of a variant not started before the cycle deadline;
+// all of its images stay pending for the next cycle
+func skippedVariant(cfg *Config, variant string, logger *log.Logger, pending []string) VariantResult {
+	images, err := listVariantImages(cfg, variant)
+	if err != nil {
+		logger.Printf("⚠️  %v", err)
+	}
+	activity.SetVariantStatus(variant, variantSkipped)
+	// Images skipped last time stay pending even if the listing failed
+	images, excluded := applyExclusions(cfg.Exclusions, variant, mergeImageLists(pending, images), time.Now(), logger)
+	return VariantResult{Variant: variant, Success: true, Skipped: images, Excluded: excludedImageNames(excluded)}
 }
 
 func main() {
 	log.SetOutput(os.Stdout)
 	log.SetFlags(log.Ldate | log.Ltime | log.LUTC)
 
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// serve runs the long-lived scheduler daemon
+func serve(cfg *Config) int {
 	log.Println("========================================")
 	log.Println("Vulnerability Scanner Scheduler")
 	log.Println("========================================")
 
-	// Get schedule from environment variable, default to daily at 2 AM
-	schedule := os.Getenv("SCAN_SCHEDULE")
-	if schedule == "" {
-		schedule = "0 2 * * *" // Daily at 2 AM UTC
+	if err := cfg.Validate(); err != nil {
+		log.Printf("❌ Invalid configuration:\n%v", err)
+		return 1
+	}
+	log.Printf("Scan schedule: %s", cfg.Schedule)
+	if cfg.ScanJitter > 0 {
+		log.Printf("Scheduled cycles start up to %s late (SCAN_JITTER)", cfg.ScanJitter)
+	}
+	if cfg.RetentionPeriod > 0 {
+		log.Printf("Retention: %s", describeRetention(cfg))
+	}
+	if cfg.DryRun {
+		log.Println("🧪 DRY_RUN=true: cycles log the commands they would run and the images they would scan")
+	}
+	if cfg.QueueMode == queuePostgres {
+		log.Println("Queue mode: postgres (scans run on 'scheduler worker' processes)")
+	}
+	setupTracing(cfg.Tracing)
+	setupEvents(cfg.Events)
+	stop := stopStepsOnSignal()
+
+	// Fail fast on environment problems instead of discovering them at 2 AM
+	if cfg.SkipPreflight {
+		log.Println("⚠️  SKIP_PREFLIGHT=true, skipping startup checks")
+	} else {
+		log.Println("Running startup checks...")
+		if err := preflightError(runPreflightChecks(cfg, cfg.QueueMode != queuePostgres)); err != nil {
+			log.Printf("❌ Startup checks failed:\n%v", err)
+			log.Println("Fix the problems above or set SKIP_PREFLIGHT=true to start anyway")
+			return 1
+		}
+		log.Println("✅ Startup checks passed")
+	}
+
+	// Create or upgrade the tables the loader writes to before the first cycle
+	// The loader creates SQLite's tables itself
+	if cfg.DB.AutoMigrate && cfg.DB.Backend != backendSQLite {
+		if err := autoMigrate(cfg); err != nil {
+			log.Printf("❌ Database migrations failed: %v", err)
+			log.Println("Fix the database or set DB_AUTO_MIGRATE=false to start anyway")
+			return 1
+		}
+	}
+
+	sched := newScheduler(cfg)
+	if sched.Paused() {
+		log.Println("⏸️  Scheduling is paused; POST /scheduler/resume to resume scheduled scans")
 	}
-	log.Printf("Scan schedule: %s", schedule)
+
+	// With several replicas, only the one holding the leader lock runs scheduled cycles
+	if cfg.LeaderElection {
+		elector, err := newLeaderElector(cfg.DB, cfg.LeaderLockKey)
+		if err != nil {
+			log.Printf("❌ %v", err)
+			return 1
+		}
+		sched.elector = elector
+		defer elector.Resign()
+		if elector.Campaign() {
+			log.Println("👑 Acquired the leader lock, this replica runs scheduled scans")
+		} else {
+			log.Println("💤 Leader lock not acquired, starting as standby")
+		}
+		// A replica taking over catches up on a run the previous leader missed
+		elector.onElected = func() {
+			if missedScheduledRun(sched.Config().Schedule, sched.Config().MissedRunTolerance) {
+				sched.runScheduledCycle()
+			}
+		}
+		go elector.Run()
+	}
+
+	// Download scanner databases before the first cycle needs them; with external
+	// workers the scans, and so the databases, live elsewhere
+	if cfg.ScannerDBWarmup && cfg.QueueMode != queuePostgres && !cfg.Offline {
+		if cfg.DryRun {
+			dryRunNote("would refresh the Trivy and Grype databases")
+		} else {
+			sched.warmer = newDBWarmer()
+			sched.warmer.Start()
+		}
+	}
+
+	// Start the HTTP API
+	mux := http.NewServeMux()
+	// Probes, the API description and the registry webhook (which checks its own
+	// secret) stay open
+	auth := cfg.APIAuth
+	read := func(h http.Handler) http.Handler { return auth.Require(roleRead, h) }
+	operator := func(h http.Handler) http.Handler { return auth.Require(roleOperator, h) }
+	mux.Handle("/readyz", sched.warmer)
+	mux.Handle("/openapi.json", openAPIHandler())
+	mux.Handle("/docs", swaggerUIHandler())
+	cycleMetrics.isLeader = sched.elector.IsLeader
+	mux.Handle("/metrics", read(cycleMetrics))
+	apiCache.SetTTL(cfg.APICacheTTL)
+	mux.Handle("/summary", read(apiCache.Wrap(summaryHandler(sched))))
+	mux.Handle("/badge/", read(apiCache.Wrap(badgeHandler(sched))))
+	mux.Handle("/findings/", read(apiCache.Wrap(findingsHandler(sched))))
+	historyDB := openHistoryDB(cfg.DB)
+	mux.Handle("/trends", read(apiCache.Wrap(trendsHandler(sched, historyDB))))
+	dash := newDashboard(sched, historyDB)
+	mux.Handle("/dashboard", read(apiCache.Wrap(dash)))
+	mux.Handle("/dashboard/", read(apiCache.Wrap(dash)))
+	mux.Handle("/dashboard/static/", read(dash.staticHandler()))
+	mux.Handle("/scheduler/pause", operator(pauseHandler(sched, true)))
+	mux.Handle("/scheduler/resume", operator(pauseHandler(sched, false)))
+	mux.Handle("/scheduler/activity", read(activityHandler(sched)))
+	mux.Handle("/status", read(statusHandler(sched)))
+	mux.Handle("/executive/", read(executiveReportHandler()))
+	mux.Handle("/baseline/", baselineHandler(sched, read, operator))
+	trigger := newScanTrigger(sched, cfg.ScanTrigger)
+	mux.Handle("/scan", operator(scanTriggerHandler(trigger)))
+	mux.Handle("/reload", operator(reloadHandler(sched)))
+	if cfg.SandboxEnabled {
+		mux.Handle("/sandbox/scan", operator(newSandboxHandler(cfg.Sandbox)))
+		log.Println("Sandbox scan endpoint enabled at POST /sandbox/scan")
+	}
+	if cfg.RegistryWebhook.Secret != "" {
+		mux.Handle("/webhooks/registry", newRegistryWebhookHandler(sched, trigger, cfg.RegistryWebhook))
+		log.Println("Registry push webhook enabled at POST /webhooks/registry")
+	}
+	if auth.Enabled() {
+		log.Printf("🔒 API authentication on (%s)", describeAPIAuth(auth))
+	}
+	if err := startAPIServer(cfg.APIAddr, mux, auth); err != nil {
+		log.Printf("❌ %v", err)
+		return 1
+	}
+	serveErr := make(chan error, 1)
+	if cfg.GRPC.Addr != "" {
+		if err := startGRPCServer(cfg.GRPC, auth, sched, trigger, serveErr); err != nil {
+			log.Printf("❌ %v", err)
+			return 1
+		}
+	}
+
+	// Reload configuration on SIGHUP or config file changes
+	go sched.WatchConfig()
+	go sched.WatchStaleness()
+
+	// Follow the ScanPolicy and run the cycles ScanJobs ask for
+	if cfg.Kubernetes.Enabled {
+		kube, err := newKubeController(sched, trigger, cfg.Kubernetes)
+		if err != nil {
+			log.Printf("❌ %v", err)
+			return 1
+		}
+		sched.kube = kube
+		log.Printf("☸️  Controller mode: following ScanPolicy %s/%s and its ScanJobs", cfg.Kubernetes.Namespace, cfg.Kubernetes.Policy)
+		go kube.Run()
+	}
+
+	// Finish a cycle that was cut short by a restart before starting new ones
+	resumed := sched.resumeInterruptedCycle()
 
 	// Check for immediate scan flag
-	runImmediately := os.Getenv("RUN_IMMEDIATELY")
-	if runImmediately == "true" {
+	if resumed {
+		log.Println("Interrupted scan cycle resumed, skipping the startup scan")
+	} else if cfg.RunImmediately {
 		log.Println("RUN_IMMEDIATELY=true detected, starting scan now...")
-		RunFullScanCycle()
+		sched.runScheduledCycle()
+	} else if missedScheduledRun(cfg.Schedule, cfg.MissedRunTolerance) {
+		// Catch up on a scheduled run that was missed while the scheduler was down
+		sched.runScheduledCycle()
 	}
 
-	// Set up cron scheduler
-	c := cron.New(cron.WithLogger(cron.VerbosePrintfLogger(log.New(os.Stdout, "cron: ", log.LstdFlags))))
-
-	_, err := c.AddFunc(schedule, RunFullScanCycle)
-	if err != nil {
-		log.Fatalf("Failed to add cron job: %v", err)
+	// Start the cron scheduler
+	if err := sched.Start(); err != nil {
+		log.Printf("%v", err)
+		return 1
 	}
 
+	sched.saveStatus(nil)
 	log.Printf("Scheduler started successfully")
-	log.Printf("Next scan scheduled for: %s", c.Entries()[0].Next)
+	log.Printf("Next scan scheduled for: %s", sched.NextRun())
 	log.Println("========================================")
 
-	// Start the cron scheduler
-	c.Start()
-
-	// Keep the program running
-	select {}
+	// Keep the program running until a signal or a failed server stops it, returning
+	// through the deferred cleanup
+	select {
+	case err := <-serveErr:
+		log.Printf("❌ %v", err)
+		return 1
+	case <-stop.Done():
+		sched.cron.Stop()
+		log.Println("Scheduler stopped")
+		return shutdownExitCode(stop)
+	}
 }
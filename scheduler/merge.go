@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// remediationLinkPatterns classify reference URLs, most specific first, as in
+// merge-scan-results.py
+var remediationLinkPatterns = []struct {
+	linkType string
+	pattern  *regexp.Regexp
+}{
+	{"fix_commit", regexp.MustCompile(`(?i)/commit/[0-9a-f]{7,40}|/-/commit/|/commits/[0-9a-f]{7,40}|git\.kernel\.org/stable/c/|[?;&](id|h)=[0-9a-f]{12,40}`)},
+	{"pull_request", regexp.MustCompile(`(?i)/pull/\d+|/-/merge_requests/\d+|/pull-requests/\d+`)},
+	{"advisory", regexp.MustCompile(`(?i)/advisories/GHSA-|/security/advisories/|nvd\.nist\.gov/vuln/detail|osv\.dev/vulnerability` +
+		`|security-tracker\.debian\.org|ubuntu\.com/security|access\.redhat\.com/(security|errata)` +
+		`|security\.alpinelinux\.org|/oss-security/|/security-advisor|/(DSA|USN|RHSA|ALAS)-`)},
+	{"changelog", regexp.MustCompile(`(?i)changelog|/CHANGES|/NEWS|release-?notes|/releases/tag/`)},
+	{"issue", regexp.MustCompile(`(?i)/issues/\d+|bugzilla|/show_bug\.cgi|bugs\.`)},
+}
+
+// remediationLinks extracts fix commits, advisories and changelogs from references
+func remediationLinks(references []string) []RemediationLink {
+	refs := sortedUnion(references, nil)
+	var links []RemediationLink
+	for i, p := range remediationLinkPatterns {
+		for _, url := range refs {
+			if classifyReference(url) == i {
+				links = append(links, RemediationLink{Type: p.linkType, URL: url})
+			}
+		}
+	}
+	return links
+}
+
+// classifyReference returns the index of the first pattern matching url, or -1
+func classifyReference(url string) int {
+	for i, p := range remediationLinkPatterns {
+		if p.pattern.MatchString(url) {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeTrivyInput is the part of Trivy's JSON output the merge reads; the rest of the
+// header is copied through
+type mergeTrivyInput struct {
+	SchemaVersion json.RawMessage `json:"SchemaVersion"`
+	ArtifactName  string          `json:"ArtifactName"`
+	ArtifactType  string          `json:"ArtifactType"`
+	Metadata      json.RawMessage `json:"Metadata"`
+	Results       []struct {
+		Target          string `json:"Target"`
+		Type            string `json:"Type"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string          `json:"VulnerabilityID"`
+			PkgName          string          `json:"PkgName"`
+			InstalledVersion string          `json:"InstalledVersion"`
+			Severity         string          `json:"Severity"`
+			Title            string          `json:"Title"`
+			Description      string          `json:"Description"`
+			FixedVersion     string          `json:"FixedVersion"`
+			References       []string        `json:"References"`
+			CVSS             json.RawMessage `json:"CVSS"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// mergeGrypeInput is the part of Grype's JSON output the merge reads
+type mergeGrypeInput struct {
+	Matches []struct {
+		Vulnerability struct {
+			ID                     string   `json:"id"`
+			Severity               string   `json:"severity"`
+			Description            string   `json:"description"`
+			URLs                   []string `json:"urls"`
+			RelatedVulnerabilities []struct {
+				ID string `json:"id"`
+			} `json:"relatedVulnerabilities"`
+			Fix struct {
+				Versions []string `json:"versions"`
+			} `json:"fix"`
+		} `json:"vulnerability"`
+		Artifact struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Type    string `json:"type"`
+		} `json:"artifact"`
+	} `json:"matches"`
+}
+
+// scanFinding is a finding of either scanner, normalized for merging
+type scanFinding struct {
+	id, pkg, version, severity, title, description, fixedVersion string
+	cvssScore, cvssV2Score, cvssV3Score                          float64
+	cvssVector, target, typ, source                              string
+	references, aliases                                          []string
+	foundBy                                                      []string
+	severities                                                   map[string]string
+}
+
+// cvssScores picks the first V2 and V3 scores and vector across the CVSS sources of a
+// Trivy finding, in the order Trivy lists them
+func cvssScores(raw json.RawMessage) (v2, v3 float64, vector string) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return 0, 0, ""
+	}
+	for dec.More() {
+		if _, err := dec.Token(); err != nil {
+			return v2, v3, vector
+		}
+		var source struct {
+			V2Score  *float64 `json:"V2Score"`
+			V3Score  *float64 `json:"V3Score"`
+			V2Vector *string  `json:"V2Vector"`
+			V3Vector *string  `json:"V3Vector"`
+		}
+		if err := dec.Decode(&source); err != nil {
+			continue
+		}
+		if v2 == 0 && source.V2Score != nil {
+			v2 = *source.V2Score
+		}
+		if v3 == 0 && source.V3Score != nil {
+			v3 = *source.V3Score
+		}
+		if vector == "" && source.V3Vector != nil {
+			vector = *source.V3Vector
+		} else if vector == "" && source.V2Vector != nil {
+			vector = *source.V2Vector
+		}
+	}
+	return v2, v3, vector
+}
+
+func parseTrivyFindings(in *mergeTrivyInput) []*scanFinding {
+	var findings []*scanFinding
+	for _, result := range in.Results {
+		for _, v := range result.Vulnerabilities {
+			v2, v3, vector := cvssScores(v.CVSS)
+			score := v3
+			if score == 0 {
+				score = v2
+			}
+			findings = append(findings, &scanFinding{
+				id: v.VulnerabilityID, pkg: v.PkgName, version: v.InstalledVersion,
+				severity: normalizeSeverity(v.Severity), title: v.Title, description: v.Description,
+				fixedVersion: v.FixedVersion, cvssScore: score, cvssV2Score: v2, cvssV3Score: v3,
+				cvssVector: vector, references: v.References, target: result.Target, typ: result.Type,
+				source: "trivy",
+			})
+		}
+	}
+	return findings
+}
+
+func parseGrypeFindings(in *mergeGrypeInput) []*scanFinding {
+	var findings []*scanFinding
+	for _, m := range in.Matches {
+		// Grype reports distro and GitHub advisories with the CVE among the related ones
+		id := m.Vulnerability.ID
+		if !strings.HasPrefix(id, "CVE-") {
+			for _, related := range m.Vulnerability.RelatedVulnerabilities {
+				if strings.HasPrefix(related.ID, "CVE-") {
+					id = related.ID
+					break
+				}
+			}
+		}
+		f := &scanFinding{
+			id: id, pkg: m.Artifact.Name, version: m.Artifact.Version,
+			severity: normalizeSeverity(m.Vulnerability.Severity), description: m.Vulnerability.Description,
+			references: m.Vulnerability.URLs, target: m.Artifact.Type, typ: m.Artifact.Type, source: "grype",
+		}
+		if m.Vulnerability.ID != "" && m.Vulnerability.ID != id {
+			f.aliases = []string{m.Vulnerability.ID}
+		}
+		if len(m.Vulnerability.Fix.Versions) > 0 {
+			f.fixedVersion = m.Vulnerability.Fix.Versions[0]
+		}
+		findings = append(findings, f)
+	}
+	return findings
+}
+
+// MergeStats is the MergeStats block of a merged report
+type MergeStats struct {
+	TrivyCount       int    `json:"trivy_count"`
+	GrypeCount       int    `json:"grype_count"`
+	MergedCount      int    `json:"merged_count"`
+	TrivyOnly        int    `json:"trivy_only"`
+	GrypeOnly        int    `json:"grype_only"`
+	FoundByBoth      int    `json:"found_by_both"`
+	SeverityMismatch int    `json:"severity_mismatch"`
+	DuplicatesMerged int    `json:"duplicates_merged"`
+	SeverityPolicy   string `json:"severity_policy"`
+}
+
+// reconcileSeverity picks the severity of a finding from each scanner's rating
+func reconcileSeverity(severities map[string]string, policy string) string {
+	if sev, ok := severities[policy]; ok && sev != "UNKNOWN" {
+		return sev
+	}
+	best := ""
+	for _, sev := range severities {
+		if best == "" || severityRank(sev) < severityRank(best) {
+			best = sev
+		}
+	}
+	return best
+}
+
+// mergeFindings deduplicates the findings of both scanners by CVE and package
+func mergeFindings(findings []*scanFinding, policy string) ([]*scanFinding, MergeStats) {
+	var merged []*scanFinding
+	byKey := make(map[findingKey]*scanFinding)
+	var stats MergeStats
+	for _, f := range findings {
+		key := newFindingKey(f.id, f.pkg)
+		existing, ok := byKey[key]
+		if !ok {
+			f.foundBy = []string{f.source}
+			f.severities = map[string]string{f.source: f.severity}
+			byKey[key] = f
+			merged = append(merged, f)
+			continue
+		}
+
+		stats.DuplicatesMerged++
+		if !slices.Contains(existing.foundBy, f.source) {
+			existing.foundBy = append(existing.foundBy, f.source)
+		}
+		// A scanner reporting a package twice (e.g. in two targets) keeps its worst rating
+		if prev, ok := existing.severities[f.source]; !ok || severityRank(f.severity) < severityRank(prev) {
+			existing.severities[f.source] = f.severity
+		}
+		if len(f.description) > len(existing.description) {
+			existing.description = f.description
+		}
+		if existing.fixedVersion == "" {
+			existing.fixedVersion = f.fixedVersion
+		}
+		existing.references = sortedUnion(existing.references, f.references)
+		existing.aliases = sortedUnion(existing.aliases, f.aliases)
+		// Grype has no CVSS scores; they come from Trivy
+		if existing.cvssScore == 0 {
+			existing.cvssScore = f.cvssScore
+		}
+		if existing.cvssV2Score == 0 {
+			existing.cvssV2Score = f.cvssV2Score
+		}
+		if existing.cvssV3Score == 0 {
+			existing.cvssV3Score = f.cvssV3Score
+		}
+		if existing.cvssVector == "" {
+			existing.cvssVector = f.cvssVector
+		}
+	}
+
+	for _, f := range merged {
+		f.severity = reconcileSeverity(f.severities, policy)
+		if distinctValues(f.severities) > 1 {
+			stats.SeverityMismatch++
+		}
+		switch {
+		case len(f.foundBy) > 1:
+			f.foundBy = []string{"trivy", "grype"}
+			stats.FoundByBoth++
+		case f.foundBy[0] == "trivy":
+			stats.TrivyOnly++
+		default:
+			stats.GrypeOnly++
+		}
+	}
+	stats.MergedCount = len(merged)
+	stats.SeverityPolicy = policy
+	return merged, stats
+}
+
+// sortedUnion returns the sorted distinct values of both lists
+func sortedUnion(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var union []string
+	for _, v := range append(append([]string(nil), a...), b...) {
+		if !seen[v] {
+			seen[v] = true
+			union = append(union, v)
+		}
+	}
+	sort.Strings(union)
+	return union
+}
+
+func distinctValues(m map[string]string) int {
+	seen := make(map[string]bool, len(m))
+	for _, v := range m {
+		seen[v] = true
+	}
+	return len(seen)
+}
+
+// mergedVuln is a finding of a merged report, in Trivy's layout plus the fields the
+// merge adds
+type mergedVuln struct {
+	VulnerabilityID   string            `json:"VulnerabilityID"`
+	PkgName           string            `json:"PkgName"`
+	InstalledVersion  string            `json:"InstalledVersion"`
+	Severity          string            `json:"Severity"`
+	Title             string            `json:"Title"`
+	Description       string            `json:"Description"`
+	FixedVersion      string            `json:"FixedVersion"`
+	References        []string          `json:"References"`
+	FoundBy           string            `json:"FoundBy"`
+	ScannerSeverities map[string]string `json:"ScannerSeverities,omitempty"`
+	Aliases           []string          `json:"Aliases,omitempty"`
+	RemediationLinks  []RemediationLink `json:"RemediationLinks,omitempty"`
+	CVSSScore         float64           `json:"CVSSScore,omitempty"`
+	CVSSV2Score       float64           `json:"CVSSV2Score,omitempty"`
+	CVSSV3Score       float64           `json:"CVSSV3Score,omitempty"`
+	CVSSVector        string            `json:"CVSSVector,omitempty"`
+}
+
+type mergedResult struct {
+	Target          string       `json:"Target"`
+	Type            string       `json:"Type"`
+	Vulnerabilities []mergedVuln `json:"Vulnerabilities"`
+}
+
+// reportVersion is the version of the merged report layout, described by
+// scan-report.schema.json and checked by load-to-database.py
+const reportVersion = 1
+
+// mergedReport is the merged report of an image, as merge-scan-results.py writes it
+type mergedReport struct {
+	ReportVersion int             `json:"ReportVersion"`
+	SchemaVersion json.RawMessage `json:"SchemaVersion"`
+	ArtifactName  string          `json:"ArtifactName"`
+	ArtifactType  string          `json:"ArtifactType"`
+	Metadata      json.RawMessage `json:"Metadata"`
+	Results       []mergedResult  `json:"Results"`
+	MergeStats    MergeStats      `json:"MergeStats"`
+	BaseImage     string          `json:"BaseImage,omitempty"`
+	Platform      string          `json:"Platform,omitempty"`
+}
+
+// mergeScanResults is the Go version of merge-scan-results.py used by the built-in
+// scan engine: it merges the Trivy and Grype reports of an image into outPath and
+// prints the same summary to w
+func mergeScanResults(w io.Writer, trivyPath, grypePath, outPath, baseImage, platform, policy string) (*mergedReport, error) {
+	data, err := os.ReadFile(trivyPath)
+	if err != nil {
+		return nil, fmt.Errorf("trivy report: %w", err)
+	}
+	var trivy mergeTrivyInput
+	if err := json.Unmarshal(data, &trivy); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", trivyPath, err)
+	}
+	var grype mergeGrypeInput
+	if data, err := os.ReadFile(grypePath); errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(w, "Warning: Grype file not found: %s, using Trivy data only\n", grypePath)
+	} else if err != nil {
+		return nil, fmt.Errorf("grype report: %w", err)
+	} else if err := json.Unmarshal(data, &grype); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", grypePath, err)
+	}
+
+	trivyFindings := parseTrivyFindings(&trivy)
+	grypeFindings := parseGrypeFindings(&grype)
+	merged, stats := mergeFindings(append(append([]*scanFinding(nil), trivyFindings...), grypeFindings...), policy)
+	stats.TrivyCount, stats.GrypeCount = len(trivyFindings), len(grypeFindings)
+
+	report := &mergedReport{
+		ReportVersion: reportVersion,
+		SchemaVersion: trivy.SchemaVersion,
+		ArtifactName:  trivy.ArtifactName,
+		ArtifactType:  trivy.ArtifactType,
+		Metadata:      trivy.Metadata,
+		Results:       []mergedResult{},
+		MergeStats:    stats,
+		BaseImage:     baseImage,
+		Platform:      platform,
+	}
+	if len(report.SchemaVersion) == 0 {
+		report.SchemaVersion = json.RawMessage("2")
+	}
+	if len(report.Metadata) == 0 {
+		report.Metadata = json.RawMessage("{}")
+	}
+	// Findings are grouped by target in the order the targets first appear
+	byTarget := make(map[string]int)
+	for _, f := range merged {
+		i, ok := byTarget[f.target]
+		if !ok {
+			i = len(report.Results)
+			byTarget[f.target] = i
+			report.Results = append(report.Results, mergedResult{Target: f.target, Type: f.typ})
+		}
+		v := mergedVuln{
+			VulnerabilityID: f.id, PkgName: f.pkg, InstalledVersion: f.version, Severity: f.severity,
+			Title: f.title, Description: f.description, FixedVersion: f.fixedVersion,
+			References: f.references, FoundBy: strings.Join(f.foundBy, ","), Aliases: f.aliases,
+			RemediationLinks: remediationLinks(f.references),
+			CVSSScore:        f.cvssScore, CVSSV2Score: f.cvssV2Score, CVSSV3Score: f.cvssV3Score, CVSSVector: f.cvssVector,
+		}
+		if v.References == nil {
+			v.References = []string{}
+		}
+		if distinctValues(f.severities) > 1 {
+			v.ScannerSeverities = f.severities
+		}
+		report.Results[i].Vulnerabilities = append(report.Results[i].Vulnerabilities, v)
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(outPath, out, 0o644); err != nil {
+		return nil, err
+	}
+
+	name := strings.ReplaceAll(strings.TrimSuffix(filepath.Base(trivyPath), ".json"), "_scan", "")
+	fmt.Fprintf(w, "✓ Merged %s:\n", name)
+	fmt.Fprintf(w, "  Trivy: %d | Grype: %d | Merged: %d\n", stats.TrivyCount, stats.GrypeCount, stats.MergedCount)
+	fmt.Fprintf(w, "  Trivy-only: %d | Grype-only: %d | Both: %d | Severity mismatches: %d (%s)\n",
+		stats.TrivyOnly, stats.GrypeOnly, stats.FoundByBoth, stats.SeverityMismatch, policy)
+	return report, nil
+}
+
+// severityCounts counts the findings of a merged report per severity
+func (r *mergedReport) severityCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, result := range r.Results {
+		for _, v := range result.Vulnerabilities {
+			counts[v.Severity]++
+		}
+	}
+	return counts
+}
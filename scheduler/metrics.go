@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// cycleMetrics tracks scan cycle outcomes for GET /metrics
+var cycleMetrics = newCycleMetricsRegistry()
+
+// cycleMetricsRegistry holds the scheduler's metrics in memory
+type cycleMetricsRegistry struct {
+	mu     sync.Mutex
+	total  map[string]int
+	last   *CycleResult
+	failed map[string]int // per-variant failure counts
+
+	// isLeader reports leader election state in daemon mode
+	isLeader func() bool
+}
+
+func newCycleMetricsRegistry() *cycleMetricsRegistry {
+	return &cycleMetricsRegistry{
+		total:  map[string]int{cycleSuccess: 0, cyclePartial: 0, cycleFailure: 0},
+		failed: make(map[string]int),
+	}
+}
+
+// Observe records a finished cycle
+func (m *cycleMetricsRegistry) Observe(c *CycleResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.total[c.Status]++
+	m.last = c
+	for _, v := range c.Variants {
+		if !v.Success {
+			m.failed[v.Variant]++
+		}
+	}
+}
+
+// WriteText writes the metrics in the Prometheus text exposition format
+func (m *cycleMetricsRegistry) WriteText(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b.WriteString("# HELP scheduler_cycles_total Scan cycles by overall status.\n")
+	b.WriteString("# TYPE scheduler_cycles_total counter\n")
+	for _, status := range []string{cycleSuccess, cyclePartial, cycleFailure} {
+		fmt.Fprintf(b, "scheduler_cycles_total{status=%q} %d\n", status, m.total[status])
+	}
+
+	b.WriteString("# HELP scheduler_variant_failures_total Failed variant scans.\n")
+	b.WriteString("# TYPE scheduler_variant_failures_total counter\n")
+	variants := make([]string, 0, len(m.failed))
+	for v := range m.failed {
+		variants = append(variants, v)
+	}
+	sort.Strings(variants)
+	for _, v := range variants {
+		fmt.Fprintf(b, "scheduler_variant_failures_total{variant=%q} %d\n", v, m.failed[v])
+	}
+
+	if m.isLeader != nil {
+		b.WriteString("# HELP scheduler_leader Whether this replica holds the leader lock and runs scheduled cycles.\n")
+		b.WriteString("# TYPE scheduler_leader gauge\n")
+		value := 0
+		if m.isLeader() {
+			value = 1
+		}
+		fmt.Fprintf(b, "scheduler_leader %d\n", value)
+	}
+
+	if m.last == nil {
+		return
+	}
+
+	b.WriteString("# HELP scheduler_last_cycle_status Status of the most recent cycle (1 for the current status).\n")
+	b.WriteString("# TYPE scheduler_last_cycle_status gauge\n")
+	for _, status := range []string{cycleSuccess, cyclePartial, cycleFailure} {
+		value := 0
+		if m.last.Status == status {
+			value = 1
+		}
+		fmt.Fprintf(b, "scheduler_last_cycle_status{status=%q} %d\n", status, value)
+	}
+
+	b.WriteString("# HELP scheduler_last_cycle_timestamp_seconds Completion time of the most recent cycle.\n")
+	b.WriteString("# TYPE scheduler_last_cycle_timestamp_seconds gauge\n")
+	fmt.Fprintf(b, "scheduler_last_cycle_timestamp_seconds %d\n", m.last.FinishedAt.Unix())
+
+	b.WriteString("# HELP scheduler_last_cycle_duration_seconds Duration of the most recent cycle.\n")
+	b.WriteString("# TYPE scheduler_last_cycle_duration_seconds gauge\n")
+	fmt.Fprintf(b, "scheduler_last_cycle_duration_seconds %g\n", m.last.FinishedAt.Sub(m.last.StartedAt).Seconds())
+
+	b.WriteString("# HELP scheduler_last_variant_success Whether each variant succeeded in the most recent cycle.\n")
+	b.WriteString("# TYPE scheduler_last_variant_success gauge\n")
+	for _, v := range m.last.Variants {
+		value := 0
+		if v.Success {
+			value = 1
+		}
+		fmt.Fprintf(b, "scheduler_last_variant_success{variant=%q} %d\n", v.Variant, value)
+	}
+
+	b.WriteString("# HELP scheduler_last_variant_vulnerabilities Findings of each variant in the most recent cycle, by fix availability.\n")
+	b.WriteString("# TYPE scheduler_last_variant_vulnerabilities gauge\n")
+	for _, v := range m.last.Variants {
+		if !v.Success {
+			continue
+		}
+		fmt.Fprintf(b, "scheduler_last_variant_vulnerabilities{variant=%q,fix_status=%q} %d\n", v.Variant, fixAvailable, v.FixAvailable)
+		fmt.Fprintf(b, "scheduler_last_variant_vulnerabilities{variant=%q,fix_status=%q} %d\n", v.Variant, fixNone, v.Vulnerabilities-v.FixAvailable)
+	}
+}
+
+// ServeHTTP handles GET /metrics
+func (m *cycleMetricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(metricsText()))
+}
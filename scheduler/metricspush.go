@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formats of METRICS_FILE
+const (
+	metricsOpenMetrics = "openmetrics"
+	metricsPrometheus  = "prometheus"
+)
+
+const (
+	defaultPushgatewayJob = "vuln-scan-scheduler"
+	pushAttempts          = 3
+)
+
+var pushClient = &http.Client{Timeout: 10 * time.Second}
+
+// MetricsPushConfig delivers the metrics of each cycle to a Prometheus Pushgateway or
+// a file, for one-shot `scheduler scan` jobs that don't live long enough to be scraped
+type MetricsPushConfig struct {
+	// PushgatewayURL is the Pushgateway the metrics are pushed to; pushing is
+	// disabled when empty
+	PushgatewayURL string
+	// Job and Instance form the grouping key the metrics are pushed under; each push
+	// replaces the metrics of the group
+	Job      string
+	Instance string
+	// File is written with the metrics after each cycle, e.g. for the node_exporter
+	// textfile collector; disabled when empty
+	File string
+	// FileFormat is openmetrics or prometheus (the text exposition format of /metrics)
+	FileFormat string
+}
+
+func metricsPushConfigFromEnv() MetricsPushConfig {
+	return MetricsPushConfig{
+		PushgatewayURL: os.Getenv("PUSHGATEWAY_URL"),
+		Job:            envString("PUSHGATEWAY_JOB", defaultPushgatewayJob),
+		Instance:       os.Getenv("PUSHGATEWAY_INSTANCE"),
+		File:           os.Getenv("METRICS_FILE"),
+		FileFormat:     strings.ToLower(envString("METRICS_FILE_FORMAT", metricsOpenMetrics)),
+	}
+}
+
+// Validate reports an invalid Pushgateway URL, grouping key or file format
+func (c MetricsPushConfig) Validate() []error {
+	var errs []error
+	if c.PushgatewayURL != "" {
+		if u, err := url.Parse(c.PushgatewayURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, errors.New("invalid PUSHGATEWAY_URL: must be an http(s) URL"))
+		}
+		if c.Job == "" || strings.Contains(c.Job, "/") {
+			errs = append(errs, fmt.Errorf("invalid PUSHGATEWAY_JOB %q: must be set and must not contain a slash", c.Job))
+		}
+		if strings.Contains(c.Instance, "/") {
+			errs = append(errs, fmt.Errorf("invalid PUSHGATEWAY_INSTANCE %q: must not contain a slash", c.Instance))
+		}
+	}
+	if c.FileFormat != metricsOpenMetrics && c.FileFormat != metricsPrometheus {
+		errs = append(errs, fmt.Errorf("invalid METRICS_FILE_FORMAT %q: must be %q or %q", c.FileFormat, metricsOpenMetrics, metricsPrometheus))
+	}
+	return errs
+}
+
+// pushURL is the Pushgateway URL of the job's group, e.g.
+// http://pushgateway:9091/metrics/job/vuln-scan-scheduler/instance/nightly
+func (c MetricsPushConfig) pushURL() string {
+	u := strings.TrimSuffix(c.PushgatewayURL, "/") + "/metrics/job/" + url.PathEscape(c.Job)
+	if c.Instance != "" {
+		u += "/instance/" + url.PathEscape(c.Instance)
+	}
+	return u
+}
+
+// metricsText returns every metric of GET /metrics in the Prometheus text format
+func metricsText() string {
+	var b strings.Builder
+	cycleMetrics.WriteText(&b)
+	retentionMetrics.WriteText(&b)
+	writeScanProgressMetrics(&b)
+	return b.String()
+}
+
+// openMetricsText converts the Prometheus text format to OpenMetrics, where counter
+// families are named without their _total suffix and the exposition ends with # EOF
+func openMetricsText(text string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if fields := strings.SplitN(line, " ", 4); len(fields) == 4 && fields[0] == "#" &&
+			(fields[1] == "HELP" || fields[1] == "TYPE") && strings.HasSuffix(fields[2], "_total") {
+			isCounter := fields[1] == "TYPE" && strings.TrimSpace(fields[3]) == "counter"
+			if fields[1] == "HELP" || isCounter {
+				fields[2] = strings.TrimSuffix(fields[2], "_total")
+				line = strings.Join(fields, " ")
+			}
+		}
+		b.WriteString(line)
+	}
+	b.WriteString("# EOF\n")
+	return b.String()
+}
+
+// pushCycleMetrics delivers the metrics after a cycle to the Pushgateway and the
+// metrics file. Failures only cost a warning: the cycle's outcome doesn't depend on
+// them.
+func pushCycleMetrics(cfg MetricsPushConfig, cycle *CycleResult) {
+	if cfg.PushgatewayURL == "" && cfg.File == "" {
+		return
+	}
+	if cycle.DryRun {
+		if cfg.PushgatewayURL != "" {
+			dryRunNote("would push the metrics to %s", redactURL(cfg.pushURL()))
+		}
+		if cfg.File != "" {
+			dryRunNote("would write the metrics to %s", cfg.File)
+		}
+		return
+	}
+
+	text := metricsText()
+	if cfg.PushgatewayURL != "" {
+		pushMetrics(cfg.pushURL(), text)
+	}
+	if cfg.File != "" {
+		if cfg.FileFormat == metricsOpenMetrics {
+			text = openMetricsText(text)
+		}
+		if err := writeMetricsFile(cfg.File, text); err != nil {
+			log.Printf("⚠️  Failed to write the metrics file: %v", err)
+		} else {
+			log.Printf("📈 Metrics written to %s", cfg.File)
+		}
+	}
+}
+
+// pushMetrics replaces the metrics of a Pushgateway group, retrying briefly
+func pushMetrics(target, text string) {
+	var err error
+	for attempt := 1; attempt <= pushAttempts; attempt++ {
+		if err = sendMetrics(target, text); err == nil {
+			log.Printf("📈 Metrics pushed to %s", redactURL(target))
+			return
+		}
+		if attempt < pushAttempts {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+	log.Printf("⚠️  Failed to push the metrics to %s: %v", redactURL(target), err)
+}
+
+func sendMetrics(target, text string) error {
+	req, err := http.NewRequest(http.MethodPut, target, strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		// The URL may embed basic auth credentials
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// writeMetricsFile replaces the metrics file in one rename, so a collector never
+// reads it half written
+func writeMetricsFile(path, text string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(text), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// migrationLockKey serializes replicas migrating the same database ("vulnmigr")
+	migrationLockKey int64 = 0x76756c6e6d696772
+	migrationTimeout       = 10 * time.Minute
+)
+
+// migrationFiles holds the schema migrations, named NNNN_description.sql. Each one runs
+// in a transaction of its own (so files have no BEGIN/COMMIT) and must be idempotent:
+// databases created from init.sql already have the objects it creates.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.sql$`)
+
+// migration is one embedded schema migration
+type migration struct {
+	Version  int
+	Name     string
+	SQL      string
+	Checksum string
+}
+
+// MigrationStatus is the state of a migration in a database
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	// Modified marks an applied migration whose file changed since: the database may
+	// differ from a fresh one
+	Modified bool `json:"modified,omitempty"`
+
+	checksum string
+}
+
+// loadMigrations reads the embedded migrations in version order
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	seen := make(map[int]string)
+	for _, e := range entries {
+		m := migrationName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_description.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, e.Name())
+		}
+		seen[version] = e.Name()
+		data, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		migrations = append(migrations, migration{Version: version, Name: m[2], SQL: string(data), Checksum: hex.EncodeToString(sum[:])})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// appliedMigrations reads the schema_migrations table, creating it on first use
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]MigrationStatus, error) {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS public.schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		checksum VARCHAR(64) NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM public.schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var s MigrationStatus
+		var at time.Time
+		if err := rows.Scan(&s.Version, &s.Name, &s.checksum, &at); err != nil {
+			return nil, err
+		}
+		s.AppliedAt = &at
+		applied[s.Version] = s
+	}
+	return applied, rows.Err()
+}
+
+// migrateDatabase applies the pending migrations, holding an advisory lock so replicas
+// starting together apply each one once. With dryRun it only returns the pending ones.
+func migrateDatabase(ctx context.Context, dbCfg DBConfig, dryRun bool) ([]migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", dbCfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return nil, fmt.Errorf("failed to take the migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	var pending []migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	if dryRun {
+		return pending, nil
+	}
+
+	for i, m := range pending {
+		if err := applyMigration(ctx, conn, m); err != nil {
+			return pending[:i], fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+// applyMigration runs one migration and records it, atomically
+func applyMigration(ctx context.Context, conn *sql.Conn, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO public.schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+		m.Version, m.Name, m.Checksum); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrationStatus lists every embedded migration with its state in the database
+func migrationStatus(ctx context.Context, dbCfg DBConfig) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", dbCfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect: %w", err)
+	}
+	defer conn.Close()
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			s.AppliedAt = a.AppliedAt
+			s.Modified = a.checksum != m.Checksum
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// autoMigrate brings the database schema up to date at startup (DB_AUTO_MIGRATE)
+func autoMigrate(cfg *Config) error {
+	if cfg.DryRun {
+		dryRunNote("would apply pending database migrations")
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+	applied, err := migrateDatabase(ctx, cfg.DB, false)
+	for _, m := range applied {
+		log.Printf("🗄️  Applied migration %04d_%s", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		log.Println("✅ Database schema is up to date")
+	}
+	return nil
+}
+
+// migrateCommand implements `scheduler migrate up|status`
+func migrateCommand(cfg *Config, args []string) int {
+	if len(args) == 0 || (args[0] != "up" && args[0] != "status") {
+		fmt.Fprintln(os.Stderr, "Usage: scheduler migrate up [--dry-run] | status [--format text|json]")
+		return exitUsage
+	}
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "list the pending migrations without applying them")
+	format := fs.String("format", "text", "status output format: text or json")
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	if err := cfg.DB.requirePostgres("migrate"); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v (the loader creates SQLite's tables itself)\n", err)
+		return exitFailure
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	if args[0] == "status" {
+		statuses, err := migrationStatus(ctx, cfg.DB)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return exitFailure
+		}
+		switch *format {
+		case "json":
+			err = writeIndentedJSON(os.Stdout, statuses)
+		case "text":
+			err = writeMigrationStatusText(os.Stdout, statuses)
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+			return exitUsage
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to write status: %v\n", err)
+			return exitFailure
+		}
+		return exitSuccess
+	}
+
+	applied, err := migrateDatabase(ctx, cfg.DB, *dryRun)
+	for _, m := range applied {
+		if *dryRun {
+			fmt.Printf("Pending: %04d_%s\n", m.Version, m.Name)
+		} else {
+			fmt.Printf("✅ Applied %04d_%s\n", m.Version, m.Name)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return exitFailure
+	}
+	if len(applied) == 0 {
+		fmt.Println("Database schema is up to date")
+	}
+	return exitSuccess
+}
+
+func writeMigrationStatusText(w io.Writer, statuses []MigrationStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		if s.Modified {
+			applied += " (file modified since)"
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,501 @@
+-- Migration 0001: the schema as of the first scheduler-managed release
+--
+-- Creates every table, index, view and function on an empty database, and brings
+-- databases created earlier from init.sql or database/schema.sql up to date: the
+-- columns added since the first release are added where missing, in the shared
+-- tables and in the tables of per-variant schemas.
+
+-- Enable UUID extension
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+-- Images table: Core information about container images
+CREATE TABLE IF NOT EXISTS images (
+    id SERIAL PRIMARY KEY,
+    image_name VARCHAR(255) UNIQUE NOT NULL,
+    image_tag VARCHAR(100) NOT NULL,
+    full_name VARCHAR(512) NOT NULL, -- image_name:image_tag
+    image_variant VARCHAR(50) DEFAULT 'baseline', -- 'baseline' or 'chainguard'
+    base_image VARCHAR(255),
+    base_image_tag VARCHAR(100),
+    created_date TIMESTAMP,
+    size_bytes BIGINT,
+    architecture VARCHAR(50),
+    os VARCHAR(100),
+    os_version VARCHAR(100),
+    docker_metadata JSONB, -- Full Docker inspect output
+    first_scanned TIMESTAMP DEFAULT NOW(),
+    last_scanned TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT unique_image_tag_variant UNIQUE(image_name, image_tag, image_variant)
+);
+
+-- Scans table: Individual scan execution records
+CREATE TABLE IF NOT EXISTS scans (
+    id SERIAL PRIMARY KEY,
+    scan_uuid UUID UNIQUE NOT NULL DEFAULT uuid_generate_v4(),
+    scan_batch_id UUID, -- Groups all images scanned in one script run
+    run_id VARCHAR(64), -- Scheduler cycle that produced the scan (matches /reports/logs/{variant}/{run_id})
+    image_id INT NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    image_variant VARCHAR(50) DEFAULT 'baseline', -- 'baseline' or 'chainguard'
+    scan_date TIMESTAMP DEFAULT NOW(),
+    trivy_version VARCHAR(50),
+    grype_version VARCHAR(50),
+    total_vulnerabilities INT DEFAULT 0,
+    critical_count INT DEFAULT 0,
+    high_count INT DEFAULT 0,
+    medium_count INT DEFAULT 0,
+    low_count INT DEFAULT 0,
+    trivy_only_count INT DEFAULT 0,
+    grype_only_count INT DEFAULT 0,
+    both_tools_count INT DEFAULT 0,
+    disputed_count INT DEFAULT 0, -- Likely false positives, excluded from the severity counts
+    scan_duration_seconds INT,
+    scan_status VARCHAR(50) DEFAULT 'completed', -- completed, failed, in_progress
+    trivy_raw_output JSONB, -- Full Trivy scan JSON
+    grype_raw_output JSONB, -- Full Grype scan JSON
+    merged_output JSONB, -- Merged scan JSON
+    scan_metadata JSONB, -- Environment, config, etc
+    load_mode VARCHAR(10) DEFAULT 'full', -- full: every finding stored; delta: only findings that changed
+    snapshot_scan_id INT, -- Latest full load of the image that a delta load builds on
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Vulnerabilities table: Individual vulnerability findings
+CREATE TABLE IF NOT EXISTS vulnerabilities (
+    id SERIAL PRIMARY KEY,
+    vuln_uuid UUID UNIQUE NOT NULL DEFAULT uuid_generate_v4(),
+    scan_id INT NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    image_id INT NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    cve_id VARCHAR(50) NOT NULL,
+    package_name VARCHAR(255) NOT NULL,
+    package_version VARCHAR(100),
+    package_type VARCHAR(50), -- 'debian', 'python-pkg', 'node-pkg', 'gobinary', etc
+    package_path VARCHAR(500), -- Path to package file
+    severity VARCHAR(20) NOT NULL,
+    title TEXT,
+    description TEXT,
+    fixed_version VARCHAR(100),
+    published_date TIMESTAMP,
+    modified_date TIMESTAMP,
+    found_by VARCHAR(50) NOT NULL, -- 'trivy', 'grype', 'both'
+    first_detected TIMESTAMP DEFAULT NOW(),
+    last_detected TIMESTAMP DEFAULT NOW(),
+    remediation TEXT,
+    reference_urls JSONB, -- Array of reference URLs
+    cvss_score DECIMAL(3,1),
+    cvss_vector VARCHAR(255),
+    cvss_v2_score DECIMAL(3,1),
+    cvss_v3_score DECIMAL(3,1),
+    exploit_available BOOLEAN DEFAULT FALSE,
+    patch_available BOOLEAN,
+    vendor_advisory JSONB, -- Vendor feed status: {"Source", "Status", "FixedVersion"}
+    disputed BOOLEAN DEFAULT FALSE, -- Flagged as a likely false positive by the scheduler
+    dispute_reasons JSONB, -- Heuristics/rules that flagged the finding
+    remediation_links JSONB, -- Fix commits, advisories and changelogs from the references: [{type, url}]
+    created_at TIMESTAMP DEFAULT NOW(),
+    package_category VARCHAR(20) DEFAULT 'unknown',
+    CONSTRAINT unique_scan_vuln UNIQUE(scan_id, cve_id, package_name, package_version)
+);
+
+-- Vulnerability lifecycle tracking table
+CREATE TABLE IF NOT EXISTS vulnerability_lifecycle (
+    id SERIAL PRIMARY KEY,
+    image_id INT NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    cve_id VARCHAR(50) NOT NULL,
+    package_name VARCHAR(255) NOT NULL,
+    package_version VARCHAR(100),
+    first_seen_scan_id INT REFERENCES scans(id),
+    last_seen_scan_id INT REFERENCES scans(id),
+    first_seen_date TIMESTAMP NOT NULL,
+    last_seen_date TIMESTAMP NOT NULL,
+    status VARCHAR(50) DEFAULT 'active', -- active, fixed, ignored
+    fixed_in_scan_id INT REFERENCES scans(id),
+    fixed_date TIMESTAMP,
+    days_to_fix INT,
+    vuln_id INT REFERENCES vulnerabilities(id) ON DELETE SET NULL, -- Row holding the finding's current details
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT unique_lifecycle UNIQUE(image_id, cve_id, package_name, package_version)
+);
+
+-- Scan comparison table: Track changes between consecutive scans
+CREATE TABLE IF NOT EXISTS scan_comparisons (
+    id SERIAL PRIMARY KEY,
+    image_id INT NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    previous_scan_id INT NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    current_scan_id INT NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    new_vulnerabilities INT DEFAULT 0,
+    fixed_vulnerabilities INT DEFAULT 0,
+    unchanged_vulnerabilities INT DEFAULT 0,
+    severity_increased INT DEFAULT 0,
+    severity_decreased INT DEFAULT 0,
+    comparison_date TIMESTAMP DEFAULT NOW(),
+    details JSONB, -- Detailed diff information
+    CONSTRAINT unique_comparison UNIQUE(previous_scan_id, current_scan_id)
+);
+
+-- Columns added since the first release, for databases created before them
+DO $$
+DECLARE
+    v_schema TEXT;
+BEGIN
+    FOR v_schema IN
+        SELECT table_schema FROM information_schema.tables
+        WHERE table_name = 'scans' AND (table_schema = 'public' OR table_schema LIKE 'variant\_%')
+    LOOP
+        EXECUTE format('ALTER TABLE %I.images ADD COLUMN IF NOT EXISTS image_variant VARCHAR(50) DEFAULT ''baseline''', v_schema);
+
+        EXECUTE format('ALTER TABLE %I.scans ADD COLUMN IF NOT EXISTS scan_batch_id UUID', v_schema);
+        EXECUTE format('ALTER TABLE %I.scans ADD COLUMN IF NOT EXISTS run_id VARCHAR(64)', v_schema);
+        EXECUTE format('ALTER TABLE %I.scans ADD COLUMN IF NOT EXISTS image_variant VARCHAR(50) DEFAULT ''baseline''', v_schema);
+        EXECUTE format('ALTER TABLE %I.scans ADD COLUMN IF NOT EXISTS disputed_count INT DEFAULT 0', v_schema);
+        EXECUTE format('ALTER TABLE %I.scans ADD COLUMN IF NOT EXISTS load_mode VARCHAR(10) DEFAULT ''full''', v_schema);
+        EXECUTE format('ALTER TABLE %I.scans ADD COLUMN IF NOT EXISTS snapshot_scan_id INT', v_schema);
+
+        EXECUTE format('ALTER TABLE %I.vulnerabilities ADD COLUMN IF NOT EXISTS package_category VARCHAR(20) DEFAULT ''unknown''', v_schema);
+        EXECUTE format('ALTER TABLE %I.vulnerabilities ADD COLUMN IF NOT EXISTS vendor_advisory JSONB', v_schema);
+        EXECUTE format('ALTER TABLE %I.vulnerabilities ADD COLUMN IF NOT EXISTS disputed BOOLEAN DEFAULT FALSE', v_schema);
+        EXECUTE format('ALTER TABLE %I.vulnerabilities ADD COLUMN IF NOT EXISTS dispute_reasons JSONB', v_schema);
+        EXECUTE format('ALTER TABLE %I.vulnerabilities ADD COLUMN IF NOT EXISTS remediation_links JSONB', v_schema);
+
+        -- Images are unique per variant (the first release had unique_image_tag)
+        IF NOT EXISTS (
+            SELECT 1 FROM pg_constraint c
+            JOIN pg_class t ON t.oid = c.conrelid
+            JOIN pg_namespace n ON n.oid = t.relnamespace
+            WHERE n.nspname = v_schema AND t.relname = 'images' AND c.contype = 'u'
+              AND c.conkey::int[] @> ARRAY[
+                  (SELECT attnum FROM pg_attribute WHERE attrelid = t.oid AND attname = 'image_variant')::int
+              ]
+        ) THEN
+            EXECUTE format('ALTER TABLE %I.images DROP CONSTRAINT IF EXISTS unique_image_tag', v_schema);
+            EXECUTE format('ALTER TABLE %I.images ADD CONSTRAINT unique_image_tag_variant UNIQUE(image_name, image_tag, image_variant)', v_schema);
+        END IF;
+
+        -- Lifecycle entries point at the row with their finding's current details
+        -- (delta loads): fill it in, and close the findings earlier loads never closed
+        IF NOT EXISTS (
+            SELECT 1 FROM information_schema.columns
+            WHERE table_schema = v_schema AND table_name = 'vulnerability_lifecycle' AND column_name = 'vuln_id'
+        ) THEN
+            EXECUTE format('ALTER TABLE %1$I.vulnerability_lifecycle ADD COLUMN vuln_id INT REFERENCES %1$I.vulnerabilities(id) ON DELETE SET NULL', v_schema);
+            EXECUTE format($sql$
+                UPDATE %1$I.vulnerability_lifecycle l
+                SET vuln_id = v.id
+                FROM %1$I.vulnerabilities v
+                WHERE v.image_id = l.image_id
+                  AND v.cve_id = l.cve_id
+                  AND v.package_name = l.package_name
+                  AND v.package_version IS NOT DISTINCT FROM l.package_version
+                  AND v.scan_id = (
+                      SELECT MAX(id) FROM %1$I.scans WHERE image_id = l.image_id AND scan_status = 'completed'
+                  )$sql$, v_schema);
+            EXECUTE format($sql$
+                UPDATE %1$I.vulnerability_lifecycle l
+                SET status = 'fixed',
+                    fixed_in_scan_id = s.id,
+                    fixed_date = s.scan_date,
+                    days_to_fix = EXTRACT(DAY FROM s.scan_date - l.first_seen_date),
+                    updated_at = NOW()
+                FROM %1$I.scans s
+                WHERE l.status = 'active'
+                  AND l.vuln_id IS NULL
+                  AND s.id = (SELECT MAX(id) FROM %1$I.scans WHERE image_id = l.image_id AND scan_status = 'completed')$sql$, v_schema);
+        END IF;
+    END LOOP;
+END;
+$$;
+
+-- Performance indexes
+CREATE INDEX IF NOT EXISTS idx_scans_image_date ON scans(image_id, scan_date DESC);
+CREATE INDEX IF NOT EXISTS idx_scans_status ON scans(scan_status);
+CREATE INDEX IF NOT EXISTS idx_scans_date ON scans(scan_date DESC);
+CREATE INDEX IF NOT EXISTS idx_scans_variant ON scans(image_variant);
+CREATE INDEX IF NOT EXISTS idx_scans_batch ON scans(scan_batch_id);
+CREATE INDEX IF NOT EXISTS idx_scans_run_id ON scans(run_id);
+
+CREATE INDEX IF NOT EXISTS idx_images_variant ON images(image_variant);
+
+CREATE INDEX IF NOT EXISTS idx_vulns_scan ON vulnerabilities(scan_id);
+CREATE INDEX IF NOT EXISTS idx_vulns_image ON vulnerabilities(image_id);
+CREATE INDEX IF NOT EXISTS idx_vulns_cve ON vulnerabilities(cve_id);
+CREATE INDEX IF NOT EXISTS idx_vulns_severity ON vulnerabilities(severity);
+CREATE INDEX IF NOT EXISTS idx_vulns_package ON vulnerabilities(package_name);
+CREATE INDEX IF NOT EXISTS idx_vulns_found_by ON vulnerabilities(found_by);
+CREATE INDEX IF NOT EXISTS idx_vulns_disputed ON vulnerabilities(disputed);
+CREATE INDEX IF NOT EXISTS idx_vulns_vendor_status ON vulnerabilities((vendor_advisory->>'Status'));
+CREATE INDEX IF NOT EXISTS idx_vulns_image_date ON vulnerabilities(image_id, last_detected DESC);
+CREATE INDEX IF NOT EXISTS idx_vulns_package_category ON vulnerabilities(package_category);
+
+CREATE INDEX IF NOT EXISTS idx_lifecycle_image ON vulnerability_lifecycle(image_id);
+CREATE INDEX IF NOT EXISTS idx_lifecycle_cve ON vulnerability_lifecycle(cve_id);
+CREATE INDEX IF NOT EXISTS idx_lifecycle_status ON vulnerability_lifecycle(status);
+CREATE INDEX IF NOT EXISTS idx_lifecycle_dates ON vulnerability_lifecycle(first_seen_date, last_seen_date);
+CREATE INDEX IF NOT EXISTS idx_lifecycle_vuln ON vulnerability_lifecycle(vuln_id);
+
+CREATE INDEX IF NOT EXISTS idx_comparisons_image ON scan_comparisons(image_id, comparison_date DESC);
+
+-- Useful views for Grafana, recreated as earlier definitions have other columns
+
+-- Current vulnerabilities by image (latest scan). Findings are resolved through the
+-- lifecycle table so images loaded with DB_LOAD_MODE=delta, whose latest scan only
+-- stores the findings that changed, are covered too.
+DROP VIEW IF EXISTS current_vulnerabilities;
+CREATE VIEW current_vulnerabilities AS
+SELECT
+    i.image_name,
+    i.image_tag,
+    i.image_variant,
+    v.cve_id,
+    v.package_name,
+    v.package_version,
+    v.severity,
+    v.found_by,
+    v.cvss_score,
+    s.scan_date,
+    s.id as scan_id
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+JOIN images i ON l.image_id = i.id
+JOIN scans s ON s.id = (
+    SELECT MAX(id)
+    FROM scans
+    WHERE image_id = l.image_id AND scan_status = 'completed'
+)
+WHERE l.status <> 'fixed';
+
+-- Vulnerability trends over time
+DROP VIEW IF EXISTS vulnerability_trends;
+CREATE VIEW vulnerability_trends AS
+SELECT
+    i.image_name,
+    i.image_tag,
+    i.image_variant,
+    s.scan_batch_id,
+    s.scan_date,
+    s.total_vulnerabilities,
+    s.critical_count,
+    s.high_count,
+    s.medium_count,
+    s.low_count,
+    s.trivy_only_count,
+    s.grype_only_count,
+    s.both_tools_count
+FROM scans s
+JOIN images i ON s.image_id = i.id
+WHERE s.scan_status = 'completed'
+ORDER BY i.image_name, s.scan_date;
+
+-- Top CVEs across all images
+DROP VIEW IF EXISTS top_cves;
+CREATE VIEW top_cves AS
+SELECT
+    v.cve_id,
+    v.severity,
+    COUNT(DISTINCT v.image_id) as affected_images,
+    COUNT(DISTINCT v.package_name) as affected_packages,
+    MAX(v.cvss_score) as max_cvss_score,
+    MIN(v.first_detected) as first_detected,
+    MAX(v.last_detected) as last_detected
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+WHERE l.status <> 'fixed'
+GROUP BY v.cve_id, v.severity
+ORDER BY affected_images DESC, max_cvss_score DESC;
+
+-- Scanner comparison statistics
+DROP VIEW IF EXISTS scanner_comparison;
+CREATE VIEW scanner_comparison AS
+SELECT
+    i.image_name,
+    i.image_tag,
+    i.image_variant,
+    s.scan_date,
+    SUM(CASE WHEN v.found_by = 'trivy' THEN 1 ELSE 0 END) as trivy_only,
+    SUM(CASE WHEN v.found_by = 'grype' THEN 1 ELSE 0 END) as grype_only,
+    SUM(CASE WHEN v.found_by LIKE '%,%' THEN 1 ELSE 0 END) as both_tools,
+    COUNT(*) as total
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+JOIN images i ON l.image_id = i.id
+JOIN scans s ON s.id = (
+    SELECT MAX(id)
+    FROM scans
+    WHERE image_id = l.image_id AND scan_status = 'completed'
+)
+WHERE l.status <> 'fixed'
+GROUP BY i.image_name, i.image_tag, i.image_variant, s.scan_date;
+
+-- Vulnerability breakdown by category
+DROP VIEW IF EXISTS vulnerability_breakdown_by_category;
+CREATE VIEW vulnerability_breakdown_by_category AS
+SELECT
+    i.image_name,
+    i.image_tag,
+    i.image_variant,
+    s.scan_date,
+    v.package_category,
+    COUNT(*) as total_vulnerabilities,
+    COUNT(CASE WHEN v.severity = 'CRITICAL' THEN 1 END) as critical_count,
+    COUNT(CASE WHEN v.severity = 'HIGH' THEN 1 END) as high_count,
+    COUNT(CASE WHEN v.severity = 'MEDIUM' THEN 1 END) as medium_count,
+    COUNT(CASE WHEN v.severity = 'LOW' THEN 1 END) as low_count
+FROM vulnerabilities v
+JOIN scans s ON v.scan_id = s.id
+JOIN images i ON v.image_id = i.id
+WHERE s.scan_status = 'completed'
+GROUP BY i.image_name, i.image_tag, i.image_variant, s.scan_date, v.package_category
+ORDER BY s.scan_date DESC, i.image_name, v.package_category;
+
+-- Latest scan breakdown by category
+DROP VIEW IF EXISTS latest_vulnerability_breakdown_by_category;
+CREATE VIEW latest_vulnerability_breakdown_by_category AS
+SELECT
+    i.image_name,
+    i.image_tag,
+    i.image_variant,
+    s.scan_date,
+    v.package_category,
+    COUNT(*) as total_vulnerabilities,
+    COUNT(CASE WHEN v.severity = 'CRITICAL' THEN 1 END) as critical_count,
+    COUNT(CASE WHEN v.severity = 'HIGH' THEN 1 END) as high_count,
+    COUNT(CASE WHEN v.severity = 'MEDIUM' THEN 1 END) as medium_count,
+    COUNT(CASE WHEN v.severity = 'LOW' THEN 1 END) as low_count
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+JOIN images i ON l.image_id = i.id
+JOIN scans s ON s.id = (
+    SELECT MAX(id)
+    FROM scans
+    WHERE image_id = l.image_id AND scan_status = 'completed'
+)
+WHERE l.status <> 'fixed'
+GROUP BY i.image_name, i.image_tag, i.image_variant, s.scan_date, v.package_category
+ORDER BY i.image_name, v.package_category;
+
+-- Variant catalog: shared metadata about every variant and where its data lives.
+-- With DB_SCHEMA_PER_VARIANT=true each variant is stored in its own schema
+-- (variant_<name>) so access can be granted, and data dropped, per variant.
+CREATE TABLE IF NOT EXISTS variant_catalog (
+    variant VARCHAR(50) PRIMARY KEY,
+    schema_name VARCHAR(63) NOT NULL DEFAULT 'public',
+    created_at TIMESTAMP DEFAULT NOW(),
+    last_loaded_at TIMESTAMP,
+    last_run_id VARCHAR(64)
+);
+
+-- Create (or reuse) the schema holding a variant's tables and views
+CREATE OR REPLACE FUNCTION create_variant_schema(p_variant TEXT) RETURNS TEXT AS $$
+DECLARE
+    v_schema TEXT := 'variant_' || replace(lower(p_variant), '-', '_');
+    v_path TEXT := current_setting('search_path');
+    v_table TEXT;
+    v_view RECORD;
+    v_def TEXT;
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = v_schema) THEN
+        INSERT INTO public.variant_catalog (variant, schema_name) VALUES (p_variant, v_schema)
+        ON CONFLICT (variant) DO UPDATE SET schema_name = EXCLUDED.schema_name;
+        RETURN v_schema;
+    END IF;
+
+    EXECUTE format('CREATE SCHEMA %I', v_schema);
+
+    -- Same columns, defaults, constraints and indexes as the shared tables
+    FOREACH v_table IN ARRAY ARRAY['images', 'scans', 'vulnerabilities', 'vulnerability_lifecycle', 'scan_comparisons'] LOOP
+        EXECUTE format('CREATE TABLE %I.%I (LIKE public.%I INCLUDING ALL)', v_schema, v_table, v_table);
+    END LOOP;
+
+    -- LIKE does not copy foreign keys
+    EXECUTE format('ALTER TABLE %1$I.scans ADD FOREIGN KEY (image_id) REFERENCES %1$I.images(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.vulnerabilities ADD FOREIGN KEY (scan_id) REFERENCES %1$I.scans(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.vulnerabilities ADD FOREIGN KEY (image_id) REFERENCES %1$I.images(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.vulnerability_lifecycle ADD FOREIGN KEY (image_id) REFERENCES %1$I.images(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.vulnerability_lifecycle ADD FOREIGN KEY (vuln_id) REFERENCES %1$I.vulnerabilities(id) ON DELETE SET NULL', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.scan_comparisons ADD FOREIGN KEY (image_id) REFERENCES %1$I.images(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.scan_comparisons ADD FOREIGN KEY (previous_scan_id) REFERENCES %1$I.scans(id) ON DELETE CASCADE', v_schema);
+    EXECUTE format('ALTER TABLE %1$I.scan_comparisons ADD FOREIGN KEY (current_scan_id) REFERENCES %1$I.scans(id) ON DELETE CASCADE', v_schema);
+
+    -- Recreate the reporting views on top of the variant's tables: the definitions
+    -- are read with only public on the path (so table names come back unqualified)
+    -- and created with the variant schema first on the path
+    FOR v_view IN SELECT viewname FROM pg_views WHERE schemaname = 'public' ORDER BY viewname LOOP
+        PERFORM set_config('search_path', 'public', true);
+        v_def := pg_get_viewdef(format('public.%I', v_view.viewname)::regclass);
+        PERFORM set_config('search_path', format('%I, public', v_schema), true);
+        EXECUTE format('CREATE VIEW %I.%I AS %s', v_schema, v_view.viewname, v_def);
+    END LOOP;
+    PERFORM set_config('search_path', v_path, true);
+
+    INSERT INTO public.variant_catalog (variant, schema_name) VALUES (p_variant, v_schema)
+    ON CONFLICT (variant) DO UPDATE SET schema_name = EXCLUDED.schema_name;
+    RETURN v_schema;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Drop a variant's schema and all of its data, and remove it from the catalog
+CREATE OR REPLACE FUNCTION drop_variant_schema(p_variant TEXT) RETURNS VOID AS $$
+DECLARE
+    v_schema TEXT;
+BEGIN
+    SELECT schema_name INTO v_schema FROM public.variant_catalog WHERE variant = p_variant;
+    IF v_schema IS NULL OR v_schema = 'public' THEN
+        RAISE EXCEPTION 'variant % is not stored in its own schema', p_variant;
+    END IF;
+    EXECUTE format('DROP SCHEMA %I CASCADE', v_schema);
+    DELETE FROM public.variant_catalog WHERE variant = p_variant;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Scan job queue: with QUEUE_MODE=postgres the scheduler enqueues one job per
+-- variant and `scheduler worker` processes claim them with FOR UPDATE SKIP LOCKED
+CREATE TABLE IF NOT EXISTS scan_jobs (
+    id SERIAL PRIMARY KEY,
+    run_id VARCHAR(64) NOT NULL,
+    variant VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued', -- queued, running, succeeded, failed, cancelled
+    deadline TIMESTAMPTZ, -- end of the cycle time budget
+    priority_images JSONB, -- images to scan first
+    worker VARCHAR(255),
+    attempts INT NOT NULL DEFAULT 0,
+    result JSONB, -- outcome reported by the worker
+    error TEXT,
+    enqueued_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMPTZ,
+    heartbeat_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_scan_jobs_status ON scan_jobs(status, enqueued_at);
+CREATE INDEX IF NOT EXISTS idx_scan_jobs_run_id ON scan_jobs(run_id);
+
+-- Image exclusions: images kept out of a cycle by an exclusion rule of the scheduler
+-- config, recorded per cycle so excluded images stay visible next to the scanned ones
+CREATE TABLE IF NOT EXISTS image_exclusions (
+    id SERIAL PRIMARY KEY,
+    image_variant VARCHAR(50) NOT NULL,
+    image_ref VARCHAR(500) NOT NULL, -- full image reference, e.g. postgres:17
+    rule VARCHAR(500) NOT NULL, -- pattern of the matching rule
+    reason TEXT NOT NULL,
+    expires_at TIMESTAMPTZ,
+    run_id VARCHAR(64),
+    excluded_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_image_exclusions_variant ON image_exclusions(image_variant, excluded_at DESC);
+CREATE INDEX IF NOT EXISTS idx_image_exclusions_run_id ON image_exclusions(run_id);
+
+-- Comments
+COMMENT ON TABLE images IS 'Container images being scanned for vulnerabilities';
+COMMENT ON TABLE scans IS 'Individual vulnerability scan executions';
+COMMENT ON TABLE vulnerabilities IS 'Individual vulnerability findings from scans';
+COMMENT ON TABLE vulnerability_lifecycle IS 'Tracks when vulnerabilities appear and get fixed';
+COMMENT ON TABLE scan_comparisons IS 'Tracks changes between consecutive scans';
+COMMENT ON TABLE variant_catalog IS 'Variants and the schema each one is stored in';
+COMMENT ON TABLE scan_jobs IS 'Per-variant scan jobs consumed by external workers';
+COMMENT ON TABLE image_exclusions IS 'Images excluded from each cycle by scheduler exclusion rules';
+
+COMMENT ON COLUMN scans.trivy_raw_output IS 'Full Trivy JSON output for audit trail';
+COMMENT ON COLUMN scans.grype_raw_output IS 'Full Grype JSON output for audit trail';
+COMMENT ON COLUMN vulnerabilities.found_by IS 'Which tool(s) detected this: trivy, grype, or both';
+COMMENT ON COLUMN scans.load_mode IS 'full stores every finding; delta stores only findings that changed since the previous scan';
+COMMENT ON COLUMN vulnerability_lifecycle.vuln_id IS 'Vulnerabilities row with the current details of an active finding';
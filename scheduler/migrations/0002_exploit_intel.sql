@@ -0,0 +1,20 @@
+-- Migration 0002: EPSS scores and CISA KEV entries of findings (EXPLOIT_INTEL)
+
+DO $$
+DECLARE
+    v_schema TEXT;
+BEGIN
+    FOR v_schema IN
+        SELECT table_schema FROM information_schema.tables
+        WHERE table_name = 'scans' AND (table_schema = 'public' OR table_schema LIKE 'variant\_%')
+    LOOP
+        EXECUTE format('ALTER TABLE %I.scans ADD COLUMN IF NOT EXISTS kev_count INT DEFAULT 0', v_schema);
+
+        EXECUTE format('ALTER TABLE %I.vulnerabilities ADD COLUMN IF NOT EXISTS epss_score DECIMAL(6,5)', v_schema);
+        EXECUTE format('ALTER TABLE %I.vulnerabilities ADD COLUMN IF NOT EXISTS epss_percentile DECIMAL(6,5)', v_schema);
+        EXECUTE format('ALTER TABLE %I.vulnerabilities ADD COLUMN IF NOT EXISTS kev JSONB', v_schema);
+    END LOOP;
+END $$;
+
+COMMENT ON COLUMN vulnerabilities.epss_score IS 'EPSS score of the CVE when loaded (EXPLOIT_INTEL)';
+COMMENT ON COLUMN vulnerabilities.kev IS 'CISA KEV catalog entry of the CVE; exploit_available is set with it';
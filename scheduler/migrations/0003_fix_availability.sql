@@ -0,0 +1,16 @@
+-- Migration 0003: per-scan counts of findings with and without a fix available
+
+DO $$
+DECLARE
+    v_schema TEXT;
+BEGIN
+    FOR v_schema IN
+        SELECT table_schema FROM information_schema.tables
+        WHERE table_name = 'scans' AND (table_schema = 'public' OR table_schema LIKE 'variant\_%')
+    LOOP
+        EXECUTE format('ALTER TABLE %I.scans ADD COLUMN IF NOT EXISTS fixable_count INT DEFAULT 0', v_schema);
+        EXECUTE format('ALTER TABLE %I.scans ADD COLUMN IF NOT EXISTS no_fix_count INT DEFAULT 0', v_schema);
+    END LOOP;
+END $$;
+
+COMMENT ON COLUMN scans.fixable_count IS 'Findings with a fixed version from the scanners or the vendor advisory; no_fix_count the others';
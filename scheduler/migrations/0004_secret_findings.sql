@@ -0,0 +1,22 @@
+-- Migration 0004: secrets found in the images by the secrets step (SECRET_SCANNING)
+
+CREATE TABLE IF NOT EXISTS secret_findings (
+    id SERIAL PRIMARY KEY,
+    image_variant VARCHAR(50) NOT NULL,
+    image_ref VARCHAR(500) NOT NULL, -- full image reference, e.g. postgres:17
+    target TEXT NOT NULL, -- file the secret was found in
+    rule_id VARCHAR(100) NOT NULL, -- Trivy rule, e.g. aws-access-key-id
+    category VARCHAR(100),
+    severity VARCHAR(20) NOT NULL,
+    title TEXT,
+    start_line INT,
+    end_line INT,
+    match_text TEXT, -- offending line with the secret masked
+    run_id VARCHAR(64),
+    found_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_secret_findings_variant ON secret_findings(image_variant, found_at DESC);
+CREATE INDEX IF NOT EXISTS idx_secret_findings_run_id ON secret_findings(run_id);
+
+COMMENT ON TABLE secret_findings IS 'Secrets found in the images by the scheduler secrets step';
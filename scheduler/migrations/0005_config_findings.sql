@@ -0,0 +1,23 @@
+-- Migration 0005: misconfigurations found in manifests by the misconfig step (CONFIG_SCAN_PATHS)
+
+CREATE TABLE IF NOT EXISTS config_findings (
+    id SERIAL PRIMARY KEY,
+    image_variant VARCHAR(50) NOT NULL,
+    category VARCHAR(50) NOT NULL, -- kind of file, e.g. kubernetes or dockerfile
+    scanner VARCHAR(20) NOT NULL, -- trivy or checkov
+    file_path TEXT NOT NULL,
+    check_id VARCHAR(100) NOT NULL, -- e.g. KSV001 or CKV_K8S_20
+    title TEXT,
+    severity VARCHAR(20) NOT NULL,
+    message TEXT,
+    resolution TEXT,
+    resource TEXT,
+    start_line INT,
+    run_id VARCHAR(64),
+    found_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_config_findings_variant ON config_findings(image_variant, found_at DESC);
+CREATE INDEX IF NOT EXISTS idx_config_findings_run_id ON config_findings(run_id);
+
+COMMENT ON TABLE config_findings IS 'Failed misconfiguration checks of the manifests and Dockerfiles scanned by the scheduler misconfig step';
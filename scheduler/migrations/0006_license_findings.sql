@@ -0,0 +1,21 @@
+-- Migration 0006: licenses found in the images by the licenses step (LICENSE_SCANNING)
+
+CREATE TABLE IF NOT EXISTS license_findings (
+    id SERIAL PRIMARY KEY,
+    image_variant VARCHAR(50) NOT NULL,
+    image_ref VARCHAR(500) NOT NULL,
+    package_name VARCHAR(255), -- unset for licenses found in files
+    file_path TEXT,
+    license VARCHAR(255) NOT NULL, -- SPDX identifier, e.g. AGPL-3.0-only
+    category VARCHAR(50), -- Trivy classification, e.g. restricted or notice
+    severity VARCHAR(20),
+    denied BOOLEAN NOT NULL DEFAULT FALSE, -- matched LICENSE_DENYLIST
+    run_id VARCHAR(64),
+    found_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_license_findings_variant ON license_findings(image_variant, found_at DESC);
+CREATE INDEX IF NOT EXISTS idx_license_findings_run_id ON license_findings(run_id);
+CREATE INDEX IF NOT EXISTS idx_license_findings_denied ON license_findings(denied) WHERE denied;
+
+COMMENT ON TABLE license_findings IS 'Licenses of the packages and files in the images, found by the scheduler licenses step';
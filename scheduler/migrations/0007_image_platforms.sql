@@ -0,0 +1,38 @@
+-- Migration 0007: one image row per platform of images scanned per platform (SCAN_PLATFORMS)
+
+DO $$
+DECLARE
+    v_schema TEXT;
+    v_constraint TEXT;
+BEGIN
+    FOR v_schema IN
+        SELECT table_schema FROM information_schema.tables
+        WHERE table_name = 'images' AND (table_schema = 'public' OR table_schema LIKE 'variant\_%')
+    LOOP
+        EXECUTE format('ALTER TABLE %I.images ADD COLUMN IF NOT EXISTS platform VARCHAR(50) NOT NULL DEFAULT ''''', v_schema);
+
+        -- Replace the unique keys without the platform: the per-variant one and the
+        -- one on image_name alone (whatever LIKE named them in variant schemas)
+        FOR v_constraint IN
+            SELECT c.conname FROM pg_constraint c
+            JOIN pg_class t ON t.oid = c.conrelid
+            JOIN pg_namespace n ON n.oid = t.relnamespace
+            WHERE n.nspname = v_schema AND t.relname = 'images' AND c.contype = 'u'
+              AND NOT c.conkey::int[] @> ARRAY[
+                  (SELECT attnum FROM pg_attribute WHERE attrelid = t.oid AND attname = 'platform')::int
+              ]
+        LOOP
+            EXECUTE format('ALTER TABLE %I.images DROP CONSTRAINT %I', v_schema, v_constraint);
+        END LOOP;
+        IF NOT EXISTS (
+            SELECT 1 FROM pg_constraint c
+            JOIN pg_class t ON t.oid = c.conrelid
+            JOIN pg_namespace n ON n.oid = t.relnamespace
+            WHERE n.nspname = v_schema AND t.relname = 'images' AND c.contype = 'u'
+        ) THEN
+            EXECUTE format('ALTER TABLE %I.images ADD CONSTRAINT unique_image_tag_variant_platform UNIQUE(image_name, image_tag, image_variant, platform)', v_schema);
+        END IF;
+    END LOOP;
+END $$;
+
+COMMENT ON COLUMN images.platform IS 'Platform of a multi-arch image the scans cover, e.g. linux/arm64; empty when scanned for the platform its tag resolves to';
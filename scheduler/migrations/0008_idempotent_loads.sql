@@ -0,0 +1,18 @@
+-- Migration 0008: loading the same run again updates its scans instead of adding more.
+-- Scans are unique per run, image and image digest; the ones loaded before have no
+-- digest (NULL) and, as NULLs are distinct, never conflict.
+
+DO $$
+DECLARE
+    v_schema TEXT;
+BEGIN
+    FOR v_schema IN
+        SELECT table_schema FROM information_schema.tables
+        WHERE table_name = 'scans' AND (table_schema = 'public' OR table_schema LIKE 'variant\_%')
+    LOOP
+        EXECUTE format('ALTER TABLE %I.scans ADD COLUMN IF NOT EXISTS image_digest VARCHAR(100)', v_schema);
+        EXECUTE format('CREATE UNIQUE INDEX IF NOT EXISTS idx_scans_run_image_digest ON %I.scans(run_id, image_id, image_digest)', v_schema);
+    END LOOP;
+END $$;
+
+COMMENT ON COLUMN scans.image_digest IS 'Digest of the scanned image (registry digest, else image ID; empty when unknown); with run_id and image_id it identifies a scan for reloads';
@@ -0,0 +1,6 @@
+-- Migration 0009: workers report the image progress of their scan step with each
+-- heartbeat, so the scheduler can show how far queued jobs got
+
+ALTER TABLE scan_jobs ADD COLUMN IF NOT EXISTS progress JSONB;
+
+COMMENT ON COLUMN scan_jobs.progress IS 'Image progress of the running scan step (ScanProgress), reported by the worker with each heartbeat';
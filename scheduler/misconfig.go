@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Misconfiguration scanners (CONFIG_SCANNER)
+const (
+	configScannerTrivy   = "trivy"
+	configScannerCheckov = "checkov"
+)
+
+// misconfigFileName holds the misconfigurations found in a variant's manifests
+const misconfigFileName = "misconfigurations.json"
+
+// misconfigStep checks Kubernetes manifests and Dockerfiles (CONFIG_SCAN_PATHS) for
+// misconfigurations with trivy config or checkov
+type misconfigStep struct{}
+
+func (misconfigStep) Title() string  { return "Scanning manifests for misconfigurations" }
+func (misconfigStep) Required() bool { return false }
+
+func (misconfigStep) Enabled(cfg *Config) bool {
+	if len(cfg.ConfigScanPaths) > 0 {
+		return true
+	}
+	for _, v := range cfg.Variants {
+		if len(v.ConfigScanPaths) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (misconfigStep) Plan(j *ScanJob) {
+	paths := j.Config.VariantConfigScanPaths(j.Variant)
+	if len(paths) == 0 {
+		j.Log.Printf("[%s] 🧪 No manifests to scan for misconfigurations", j.Variant)
+		return
+	}
+	j.Log.Printf("[%s] 🧪 Would scan %s for misconfigurations with %s", j.Variant, strings.Join(paths, ", "), j.Config.ConfigScanner)
+}
+
+func (misconfigStep) Run(j *ScanJob) error {
+	paths := j.Config.VariantConfigScanPaths(j.Variant)
+	if len(paths) == 0 {
+		j.Log.Printf("[%s] ⏭️  No manifests to scan for misconfigurations", j.Variant)
+		return nil
+	}
+
+	report := MisconfigReport{RunID: j.RunID, Scanner: j.Config.ConfigScanner, Paths: paths, Findings: []ConfigFinding{}}
+	for i, path := range paths {
+		step := "misconfig"
+		if len(paths) > 1 {
+			step = fmt.Sprintf("misconfig-%d", i+1)
+		}
+		var findings []ConfigFinding
+		var err error
+		if j.Config.ConfigScanner == configScannerCheckov {
+			findings, err = j.runCheckov(step, path)
+		} else {
+			findings, err = j.runTrivyConfig(step, path)
+		}
+		if err != nil {
+			return fmt.Errorf("misconfiguration scan of %s failed: %w", path, err)
+		}
+		report.Findings = append(report.Findings, findings...)
+	}
+	sort.SliceStable(report.Findings, func(a, b int) bool {
+		return severityRank(report.Findings[a].Severity) < severityRank(report.Findings[b].Severity)
+	})
+
+	if err := writeJSONFile(filepath.Join(variantDir(j.Variant), misconfigFileName), report); err != nil {
+		return fmt.Errorf("could not write %s: %w", misconfigFileName, err)
+	}
+	j.Log.Printf("[%s] ✅ %d misconfiguration(s) found in %s", j.Variant, len(report.Findings), strings.Join(paths, ", "))
+	return nil
+}
+
+// MisconfigReport is the misconfig step's output, kept in misconfigurations.json
+type MisconfigReport struct {
+	// RunID is the cycle the manifests were scanned in; the loader only loads the
+	// findings of the cycle it loads
+	RunID    string          `json:"run_id"`
+	Scanner  string          `json:"scanner"`
+	Paths    []string        `json:"paths"`
+	Findings []ConfigFinding `json:"findings"`
+}
+
+// ConfigFinding is a failed check of a manifest or Dockerfile
+type ConfigFinding struct {
+	File string `json:"file"`
+	// Type is the kind of file, e.g. kubernetes or dockerfile
+	Type       string `json:"type"`
+	CheckID    string `json:"check_id"`
+	Title      string `json:"title"`
+	Severity   string `json:"severity"`
+	Message    string `json:"message,omitempty"`
+	Resolution string `json:"resolution,omitempty"`
+	Resource   string `json:"resource,omitempty"`
+	Line       int    `json:"line,omitempty"`
+}
+
+// trivyConfigReport is the subset of trivy config's JSON output the scheduler reads
+type trivyConfigReport struct {
+	Results []struct {
+		Target            string `json:"Target"`
+		Type              string `json:"Type"`
+		Misconfigurations []struct {
+			ID            string `json:"ID"`
+			Title         string `json:"Title"`
+			Message       string `json:"Message"`
+			Resolution    string `json:"Resolution"`
+			Severity      string `json:"Severity"`
+			Status        string `json:"Status"`
+			CauseMetadata struct {
+				Resource  string `json:"Resource"`
+				StartLine int    `json:"StartLine"`
+			} `json:"CauseMetadata"`
+		} `json:"Misconfigurations"`
+	} `json:"Results"`
+}
+
+// runTrivyConfig scans path with trivy config
+func (j *ScanJob) runTrivyConfig(step, path string) ([]ConfigFinding, error) {
+	out, err := os.CreateTemp(variantDir(j.Variant), ".misconfig-*.json")
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	cmd := exec.Command("trivy", "config", "--format", "json", "--quiet", "--output", out.Name(), path)
+	if err := j.runLogged(step, cmd); err != nil {
+		return nil, err
+	}
+	if j.Config.DryRun {
+		return nil, nil
+	}
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		return nil, err
+	}
+	var report trivyConfigReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse the trivy config output: %w", err)
+	}
+
+	var findings []ConfigFinding
+	for _, r := range report.Results {
+		for _, m := range r.Misconfigurations {
+			if m.Status != "" && m.Status != "FAIL" {
+				continue
+			}
+			findings = append(findings, ConfigFinding{
+				File:       scannedFile(path, r.Target),
+				Type:       r.Type,
+				CheckID:    m.ID,
+				Title:      m.Title,
+				Severity:   m.Severity,
+				Message:    m.Message,
+				Resolution: m.Resolution,
+				Resource:   m.CauseMetadata.Resource,
+				Line:       m.CauseMetadata.StartLine,
+			})
+		}
+	}
+	return findings, nil
+}
+
+// checkovReport is the subset of checkov's JSON output the scheduler reads; checkov
+// writes one report per framework, as a list when there are several
+type checkovReport struct {
+	CheckType string `json:"check_type"`
+	Results   struct {
+		FailedChecks []struct {
+			CheckID       string  `json:"check_id"`
+			CheckName     string  `json:"check_name"`
+			FilePath      string  `json:"file_path"`
+			FileLineRange []int   `json:"file_line_range"`
+			Resource      string  `json:"resource"`
+			Severity      *string `json:"severity"`
+			Guideline     string  `json:"guideline"`
+		} `json:"failed_checks"`
+	} `json:"results"`
+}
+
+// runCheckov scans path, a directory or a single file, with checkov
+func (j *ScanJob) runCheckov(step, path string) ([]ConfigFinding, error) {
+	outDir, err := os.MkdirTemp(variantDir(j.Variant), ".checkov-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(outDir)
+
+	target := "-d"
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		target = "-f"
+	}
+	// --soft-fail: failed checks are findings, not a failed step
+	cmd := exec.Command("checkov", target, path, "--output", "json", "--output-file-path", outDir, "--soft-fail", "--quiet")
+	if err := j.runLogged(step, cmd); err != nil {
+		return nil, err
+	}
+	if j.Config.DryRun {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, "results_json.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		// Nothing checkov knows how to check
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var reports []checkovReport
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] != '[' {
+		data = append(append([]byte{'['}, data...), ']')
+	}
+	if err := json.Unmarshal(data, &reports); err != nil {
+		return nil, fmt.Errorf("failed to parse the checkov output: %w", err)
+	}
+
+	var findings []ConfigFinding
+	for _, r := range reports {
+		for _, c := range r.Results.FailedChecks {
+			f := ConfigFinding{
+				File:       scannedFile(path, c.FilePath),
+				Type:       r.CheckType,
+				CheckID:    c.CheckID,
+				Title:      c.CheckName,
+				Severity:   "UNKNOWN",
+				Resolution: c.Guideline,
+				Resource:   c.Resource,
+			}
+			if c.Severity != nil && *c.Severity != "" {
+				f.Severity = strings.ToUpper(*c.Severity)
+			}
+			if len(c.FileLineRange) > 0 {
+				f.Line = c.FileLineRange[0]
+			}
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+// scannedFile returns the path of a scanned file, which the scanners report relative
+// to the scanned directory
+func scannedFile(root, file string) string {
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		return root
+	}
+	return filepath.Join(root, strings.TrimPrefix(file, "/"))
+}
+
+// VariantConfigScanPaths returns the paths the misconfig step checks for a variant
+func (c *Config) VariantConfigScanPaths(name string) []string {
+	for _, v := range c.Variants {
+		if v.Name == name && len(v.ConfigScanPaths) > 0 {
+			return v.ConfigScanPaths
+		}
+	}
+	return c.ConfigScanPaths
+}
+
+// readMisconfigReport returns the last misconfiguration scan of a variant, or nil
+func readMisconfigReport(variant string) (*MisconfigReport, error) {
+	data, err := os.ReadFile(filepath.Join(variantDir(variant), misconfigFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report MisconfigReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", misconfigFileName, err)
+	}
+	return &report, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// knownCVEsFile records the critical and high CVEs of a variant's previous scan
+const knownCVEsFile = ".known-cves.json"
+
+// newCVESeverities are the severities the delta detector reports
+var newCVESeverities = map[string]bool{"CRITICAL": true, "HIGH": true}
+
+// AffectedImage is an image a new CVE was found in
+type AffectedImage struct {
+	Image            string `json:"image"`
+	Package          string `json:"package"`
+	InstalledVersion string `json:"installed_version"`
+	FixedVersion     string `json:"fixed_version,omitempty"`
+}
+
+// NewCVE is a critical or high CVE that was not in the variant's previous scan
+type NewCVE struct {
+	CVE      string          `json:"cve"`
+	Severity string          `json:"severity"`
+	KEV      bool            `json:"known_exploited,omitempty"`
+	Images   []AffectedImage `json:"images"`
+}
+
+// NewCVEReport is written to /reports/{variant}/new-cves.json after each scan
+type NewCVEReport struct {
+	Variant    string    `json:"variant"`
+	RunID      string    `json:"run_id"`
+	DetectedAt time.Time `json:"detected_at"`
+	// Baseline is set on a variant's first scan, which only records the CVEs it finds
+	Baseline bool     `json:"baseline,omitempty"`
+	CVEs     []NewCVE `json:"cves"`
+}
+
+// IDs returns the new CVE IDs, most severe first
+func (r *NewCVEReport) IDs() []string {
+	ids := make([]string, len(r.CVEs))
+	for i, c := range r.CVEs {
+		ids[i] = c.CVE
+	}
+	return ids
+}
+
+// detectNewCVEs compares the critical and high CVEs of a variant's reports with
+// those of its previous scan. Disputed findings are left out.
+func detectNewCVEs(variant, runID string) (*NewCVEReport, error) {
+	findings, err := variantFindings(variant)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]*NewCVE)
+	var order []string
+	for _, f := range findings {
+		if f.Disputed || !newCVESeverities[f.Severity] {
+			continue
+		}
+		c, ok := current[f.CVE]
+		if !ok {
+			c = &NewCVE{CVE: f.CVE, Severity: f.Severity}
+			current[f.CVE] = c
+			order = append(order, f.CVE)
+		}
+		c.KEV = c.KEV || f.KEV != nil
+		c.Images = append(c.Images, AffectedImage{
+			Image:            f.Image,
+			Package:          f.Package,
+			InstalledVersion: f.InstalledVersion,
+			FixedVersion:     f.FixedVersion,
+		})
+	}
+
+	report := &NewCVEReport{Variant: variant, RunID: runID, DetectedAt: time.Now().UTC(), CVEs: []NewCVE{}}
+	known, err := readKnownCVEs(variant)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		report.Baseline = true
+	case err != nil:
+		return nil, err
+	default:
+		// findings are sorted most severe first, so order is too
+		for _, cve := range order {
+			if !known[cve] {
+				report.CVEs = append(report.CVEs, *current[cve])
+			}
+		}
+	}
+
+	ids := append([]string(nil), order...)
+	sort.Strings(ids)
+	if err := writeJSONFile(filepath.Join(variantDir(variant), knownCVEsFile), ids); err != nil {
+		return nil, err
+	}
+	if err := writeJSONFile(filepath.Join(variantDir(variant), "new-cves.json"), report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func readKnownCVEs(variant string) (map[string]bool, error) {
+	data, err := os.ReadFile(filepath.Join(variantDir(variant), knownCVEsFile))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		known[id] = true
+	}
+	return known, nil
+}
+
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// announceNewCVEs hands the CVEs a scan introduced to the configured trackers; a
+// tracker that fails is logged and does not fail the scan
+func announceNewCVEs(cfg *Config, report *NewCVEReport, logger *log.Logger) {
+	if len(report.CVEs) == 0 {
+		return
+	}
+	for _, c := range report.CVEs {
+		publishEvent(eventNewCVE, report.RunID, report.Variant, c)
+	}
+	if cfg.GitHubIssues.Enabled() {
+		if err := syncGitHubIssues(cfg.GitHubIssues, report, logger); err != nil {
+			logger.Printf("⚠️  [%s] Could not update GitHub issues: %v", report.Variant, err)
+		}
+	}
+}
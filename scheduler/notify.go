@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// Values for NotificationConfig.On
+const (
+	notifyOnFailure = "failure"
+	notifyAlways    = "always"
+)
+
+// notifyCycle posts the cycle result to the configured webhook. Partial cycles
+// count as failures.
+func notifyCycle(cfg NotificationConfig, n *CycleResult) {
+	if cfg.WebhookURL == "" || (n.Status == cycleSuccess && cfg.On != notifyAlways) {
+		return
+	}
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		log.Printf("⚠️  Failed to encode notification: %v", err)
+		return
+	}
+
+	resp, err := httpClient.Post(cfg.WebhookURL, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("webhook returned %s", resp.Status)
+		}
+	}
+	if err != nil {
+		log.Printf("⚠️  Failed to send cycle notification: %v", err)
+		return
+	}
+	log.Println("📣 Cycle notification sent")
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Object storage sink types
+const (
+	sinkS3  = "s3"
+	sinkGCS = "gcs"
+)
+
+const (
+	gcsUploadURL   = "https://storage.googleapis.com/upload/storage/v1"
+	gcsScope       = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsMetadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// objectStore uploads one object to a bucket
+type objectStore interface {
+	Put(key string, body []byte, contentType string) error
+	// URL names an object for logs, e.g. s3://bucket/key
+	URL(key string) string
+}
+
+// objectStoreSink uploads the report files of each variant, plus the sink batch as
+// findings.json, under {prefix}/{run_id}/{variant}/ so they outlive the reports volume
+type objectStoreSink struct {
+	store  objectStore
+	prefix string
+}
+
+func newS3Sink(c SinkConfig) Sink {
+	region := c.Region
+	if region == "" {
+		region = envString("AWS_REGION", os.Getenv("AWS_DEFAULT_REGION"))
+	}
+	return objectStoreSink{store: &s3Store{bucket: c.Bucket, region: region, endpoint: c.Endpoint}, prefix: c.Prefix}
+}
+
+func newGCSSink(c SinkConfig) Sink {
+	return objectStoreSink{store: &gcsStore{bucket: c.Bucket, endpoint: c.Endpoint}, prefix: c.Prefix}
+}
+
+// objectKey is where a file of a variant's run is stored
+func objectKey(prefix, runID, variant, name string) string {
+	return path.Join(strings.Trim(prefix, "/"), runID, variant, name)
+}
+
+func (s objectStoreSink) Publish(j *ScanJob, batch *SinkBatch) error {
+	files, err := variantReportFiles(batch.Variant)
+	if err != nil {
+		return err
+	}
+	findings, err := json.MarshalIndent(batch, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.Put(objectKey(s.prefix, batch.RunID, batch.Variant, "findings.json"), append(findings, '\n'), "application/json"); err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := s.store.Put(objectKey(s.prefix, batch.RunID, batch.Variant, filepath.Base(file)), data, contentType(file)); err != nil {
+			return err
+		}
+	}
+	j.Log.Printf("[%s] ☁️  Uploaded %d report file(s) to %s", batch.Variant, len(files)+1,
+		s.store.URL(objectKey(s.prefix, batch.RunID, batch.Variant, "")+"/"))
+	return nil
+}
+
+// variantReportFiles lists the report files a variant's scan left in /reports/{variant}
+func variantReportFiles(variant string) ([]string, error) {
+	entries, err := os.ReadDir(variantDir(variant))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			files = append(files, filepath.Join(variantDir(variant), e.Name()))
+		}
+	}
+	return files, nil
+}
+
+// contentType guesses a report file's media type from its extension
+func contentType(file string) string {
+	switch filepath.Ext(file) {
+	case ".json", ".sarif":
+		return "application/json"
+	}
+	if t := mime.TypeByExtension(filepath.Ext(file)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// putObject sends an upload request and reports non-2xx responses with their body
+func putObject(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("upload of %s returned %s: %s", req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// s3Store uploads to S3, or to an S3-compatible endpoint such as MinIO with path-style
+// URLs, signing requests with AWS Signature Version 4. Credentials come from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type s3Store struct {
+	bucket   string
+	region   string
+	endpoint string
+}
+
+func (s *s3Store) URL(key string) string {
+	return "s3://" + s.bucket + "/" + key
+}
+
+func (s *s3Store) Put(key string, body []byte, contentType string) error {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	var target string
+	if s.endpoint != "" {
+		target = strings.TrimSuffix(s.endpoint, "/") + "/" + s.bucket + "/" + s3EscapePath(key)
+	} else {
+		target = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, s3EscapePath(key))
+	}
+	req, err := http.NewRequest(http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	signS3Request(req, body, s.region, accessKey, secretKey, os.Getenv("AWS_SESSION_TOKEN"), time.Now().UTC())
+	return putObject(req)
+}
+
+// s3EscapePath encodes each segment of an object key the way SigV4 canonicalizes it
+func s3EscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		var b strings.Builder
+		for _, c := range []byte(seg) {
+			if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		segments[i] = b.String()
+	}
+	return strings.Join(segments, "/")
+}
+
+// signS3Request adds the SigV4 Authorization header for a request without a query string
+func signS3Request(req *http.Request, body []byte, region, accessKey, secretKey, sessionToken string, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// gcsStore uploads to Google Cloud Storage with the JSON API. The access token comes
+// from GOOGLE_OAUTH_ACCESS_TOKEN, the service account key in
+// GOOGLE_APPLICATION_CREDENTIALS, or the GCE/GKE metadata server (workload identity).
+type gcsStore struct {
+	bucket   string
+	endpoint string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (s *gcsStore) URL(key string) string {
+	return "gs://" + s.bucket + "/" + key
+}
+
+func (s *gcsStore) Put(key string, body []byte, contentType string) error {
+	token, err := s.accessToken()
+	if err != nil {
+		return fmt.Errorf("no GCS credentials: %w", err)
+	}
+
+	base := gcsUploadURL
+	if s.endpoint != "" {
+		base = strings.TrimSuffix(s.endpoint, "/") + "/upload/storage/v1"
+	}
+	target := fmt.Sprintf("%s/b/%s/o?uploadType=media&name=%s", base, url.PathEscape(s.bucket), url.QueryEscape(key))
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return putObject(req)
+}
+
+// accessToken returns a cached token until a minute before it expires
+func (s *gcsStore) accessToken() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.expires.Add(-time.Minute)) {
+		return s.token, nil
+	}
+
+	var tok gcsToken
+	var err error
+	if file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); file != "" {
+		tok, err = serviceAccountToken(file)
+	} else {
+		tok, err = metadataToken()
+	}
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expires = tok.AccessToken, time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second)
+	return s.token, nil
+}
+
+// gcsToken is an OAuth2 token response
+type gcsToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func decodeToken(resp *http.Response) (gcsToken, error) {
+	defer resp.Body.Close()
+	var tok gcsToken
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return tok, fmt.Errorf("token request returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return tok, err
+	}
+	if tok.AccessToken == "" {
+		return tok, errors.New("token response without an access token")
+	}
+	return tok, nil
+}
+
+// metadataToken asks the metadata server for the token of the instance's service account
+func metadataToken() (gcsToken, error) {
+	req, err := http.NewRequest(http.MethodGet, gcsMetadataURL, nil)
+	if err != nil {
+		return gcsToken{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return gcsToken{}, fmt.Errorf("set GOOGLE_APPLICATION_CREDENTIALS outside GCP: %w", err)
+	}
+	return decodeToken(resp)
+}
+
+// serviceAccountToken exchanges a JWT signed with a service account key for a token
+func serviceAccountToken(file string) (gcsToken, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return gcsToken{}, err
+	}
+	var key struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return gcsToken{}, fmt.Errorf("invalid service account key %s: %w", file, err)
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return gcsToken{}, fmt.Errorf("invalid service account key %s: no PEM private key", file)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return gcsToken{}, fmt.Errorf("invalid service account key %s: %w", file, err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return gcsToken{}, fmt.Errorf("invalid service account key %s: not an RSA key", file)
+	}
+
+	now := time.Now().Unix()
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]any{
+		"iss": key.ClientEmail, "scope": gcsScope, "aud": key.TokenURI, "iat": now, "exp": now + 3600,
+	})
+	unsigned := header + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(nil, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return gcsToken{}, err
+	}
+
+	resp, err := httpClient.PostForm(key.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	})
+	if err != nil {
+		return gcsToken{}, err
+	}
+	return decodeToken(resp)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// offlineMode is set with OFFLINE: scanner databases come from OFFLINE_DB_PATH and
+// nothing the pipeline needs is downloaded
+var offlineMode bool
+
+// offlineScannerEnv keeps Trivy and Grype from reaching the network: databases are
+// read from dbPath and never updated, and images come from the local runtime
+func offlineScannerEnv(dbPath string) map[string]string {
+	return map[string]string{
+		"TRIVY_CACHE_DIR":            filepath.Join(dbPath, "trivy"),
+		"TRIVY_SKIP_DB_UPDATE":       "true",
+		"TRIVY_SKIP_JAVA_DB_UPDATE":  "true",
+		"TRIVY_OFFLINE_SCAN":         "true",
+		"TRIVY_SKIP_VERSION_CHECK":   "true",
+		"TRIVY_IMAGE_SRC":            "docker,containerd,podman",
+		"GRYPE_DB_CACHE_DIR":         filepath.Join(dbPath, "grype"),
+		"GRYPE_DB_AUTO_UPDATE":       "false",
+		"GRYPE_DB_VALIDATE_AGE":      "false",
+		"GRYPE_CHECK_FOR_APP_UPDATE": "false",
+	}
+}
+
+// setupOffline switches to offline mode when OFFLINE is set. The scanner settings are
+// exported so every scan, database check and sandbox scan inherits them.
+func setupOffline(cfg *Config) {
+	offlineMode = cfg.Offline
+	if !cfg.Offline {
+		return
+	}
+	for k, v := range offlineScannerEnv(cfg.OfflineDBPath) {
+		os.Setenv(k, v)
+	}
+}
+
+// checkOfflineDBs verifies that OFFLINE_DB_PATH holds a Trivy and a Grype database
+func checkOfflineDBs(dbPath string) []PreflightCheck {
+	trivyDB := filepath.Join(dbPath, "trivy", "db", "trivy.db")
+	var trivyErr error
+	if _, err := os.Stat(trivyDB); err != nil {
+		trivyErr = fmt.Errorf("not found (check OFFLINE_DB_PATH)")
+	}
+
+	// Grype keeps its database under a directory per schema version, e.g. grype/6/
+	grypeDir := filepath.Join(dbPath, "grype")
+	var grypeErr error
+	if matches, _ := filepath.Glob(filepath.Join(grypeDir, "*", "vulnerability.db")); len(matches) == 0 {
+		grypeErr = fmt.Errorf("no vulnerability.db under %s (check OFFLINE_DB_PATH)", grypeDir)
+	}
+	return []PreflightCheck{
+		{Name: "offline trivy database " + trivyDB, Err: trivyErr},
+		{Name: "offline grype database " + grypeDir, Err: grypeErr},
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiRoute documents one endpoint of the HTTP API in the OpenAPI document. Request
+// and response bodies are Go values whose types the schemas are generated from, so
+// the document follows the structs the handlers encode.
+type apiRoute struct {
+	Method  string
+	Path    string
+	Summary string
+	// Role is the role the endpoint needs with authentication on; "" for open endpoints
+	Role   string
+	Params []apiParam
+	// Request is a value of the JSON request body type, nil without a body
+	Request any
+	// Response is a value of the JSON response type; ContentType replaces JSON
+	Response    any
+	ContentType string
+	Status      int
+	// Errors lists the error statuses the endpoint answers with besides auth failures
+	Errors map[int]string
+}
+
+// apiParam is a path or query parameter
+type apiParam struct {
+	Name, In, Description string
+}
+
+// errorResponse is the body of every error response (writeError)
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// apiRoutes lists the endpoints registered in main.go
+var apiRoutes = []apiRoute{
+	{Method: http.MethodGet, Path: "/readyz", Summary: "Readiness: 503 until the scanner databases are downloaded",
+		Response: struct {
+			Ready     bool              `json:"ready"`
+			ScannerDB map[string]string `json:"scanner_db,omitempty"`
+		}{}, Errors: map[int]string{503: "Warm-up still running"}},
+	{Method: http.MethodGet, Path: "/metrics", Summary: "Prometheus metrics of the scan cycles", Role: roleRead,
+		ContentType: "text/plain"},
+	{Method: http.MethodGet, Path: "/summary", Summary: "Totals and per-image counts from the latest reports of every variant", Role: roleRead,
+		Response: LatestSummary{}},
+	{Method: http.MethodGet, Path: "/badge/{variant}.svg", Summary: "SVG badge with a variant's severity counts", Role: roleRead,
+		Params: []apiParam{{"variant", "path", "Variant name"}}, ContentType: "image/svg+xml",
+		Errors: map[int]string{404: "Unknown variant"}},
+	{Method: http.MethodGet, Path: "/findings/{variant}", Summary: "Latest findings of a variant, most severe first", Role: roleRead,
+		Params: []apiParam{
+			{"variant", "path", "Variant name"},
+			{"cve", "query", "Only this CVE"},
+			{"severity", "query", "Only this severity, e.g. CRITICAL"},
+			{"fix_status", "query", "fix-available or no-fix"},
+			{"links", "query", "true: only findings with remediation links"},
+		},
+		Response: struct {
+			Variant  string    `json:"variant"`
+			Findings []Finding `json:"findings"`
+		}{}, Errors: map[int]string{400: "Invalid filter", 404: "Unknown variant"}},
+	{Method: http.MethodGet, Path: "/trends", Summary: "CVE counts by severity over time", Role: roleRead,
+		Params: []apiParam{
+			{"variant", "query", "One variant (default: all)"},
+			{"window", "query", "How far back, e.g. 30d or 12w (default 30d)"},
+			{"interval", "query", "hour, day, week or month (default day)"},
+		},
+		Response: TrendsResponse{}, Errors: map[int]string{400: "Invalid window or interval", 404: "Unknown variant", 503: "Scan history unavailable"}},
+	{Method: http.MethodGet, Path: "/dashboard", Summary: "HTML dashboard of trends and recent runs", Role: roleRead,
+		Params: []apiParam{{"window", "query", "How far back, e.g. 90d (default 30d)"}}, ContentType: "text/html"},
+	{Method: http.MethodPost, Path: "/scheduler/pause", Summary: "Pause scheduled scans", Role: roleOperator,
+		Response: PauseStatus{}},
+	{Method: http.MethodPost, Path: "/scheduler/resume", Summary: "Resume scheduled scans", Role: roleOperator,
+		Response: PauseStatus{}},
+	{Method: http.MethodGet, Path: "/scheduler/activity", Summary: "What the scheduler is doing right now", Role: roleRead,
+		Response: ActivitySnapshot{}},
+	{Method: http.MethodGet, Path: "/status", Summary: "Scheduler health: schedule, running cycle and last cycle", Role: roleRead,
+		Response: SchedulerStatus{}},
+	{Method: http.MethodGet, Path: "/executive/{run_id}.html", Summary: "HTML executive summary of a cycle", Role: roleRead,
+		Params: []apiParam{{"run_id", "path", "Run ID of the cycle"}}, ContentType: "text/html",
+		Errors: map[int]string{404: "No summary for this run"}},
+	{Method: http.MethodGet, Path: "/baseline/{variant}", Summary: "Golden baseline run of a variant", Role: roleRead,
+		Params: []apiParam{{"variant", "path", "Variant name"}}, Response: GoldenBaseline{},
+		Errors: map[int]string{404: "Unknown variant or no golden baseline marked"}},
+	{Method: http.MethodPut, Path: "/baseline/{variant}", Summary: "Mark a run, by default the latest, as the golden baseline of a variant", Role: roleOperator,
+		Params: []apiParam{{"variant", "path", "Variant name"}}, Request: BaselineRequest{}, Response: GoldenBaseline{},
+		Errors: map[int]string{400: "Invalid JSON body", 404: "Unknown variant or no findings recorded for the run"}},
+	{Method: http.MethodDelete, Path: "/baseline/{variant}", Summary: "Stop comparing a variant's runs against a golden baseline", Role: roleOperator,
+		Params: []apiParam{{"variant", "path", "Variant name"}}, Status: http.StatusNoContent,
+		Errors: map[int]string{404: "Unknown variant or no golden baseline marked"}},
+	{Method: http.MethodPost, Path: "/scan", Summary: "Trigger a scan cycle over all variants or the listed ones", Role: roleOperator,
+		Request: ScanTriggerRequest{}, Response: TriggeredScan{}, Status: http.StatusAccepted,
+		Errors: map[int]string{400: "Invalid JSON body", 404: "Unknown variant", 429: "Rate limited or too many triggered cycles waiting", 503: "Standby replica"}},
+	{Method: http.MethodPost, Path: "/reload", Summary: "Retry loading the quarantined reports of all variants or the listed ones", Role: roleOperator,
+		Request: ReloadRequest{}, Response: []ReloadResult{},
+		Errors: map[int]string{400: "Invalid JSON body", 404: "Unknown variant", 409: "A scan cycle is running"}},
+	{Method: http.MethodPost, Path: "/sandbox/scan", Summary: "Scan one image ad hoc (SANDBOX_ENABLED)", Role: roleOperator,
+		Request: SandboxRequest{}, Response: SandboxSummary{},
+		Errors: map[int]string{400: "Invalid image reference", 429: "Rate limited or another sandbox scan running", 502: "Scan failed"}},
+	{Method: http.MethodPost, Path: "/webhooks/registry", Summary: "Rescan pushed images (Docker Hub, Harbor, GHCR payloads)",
+		Params:   []apiParam{{"token", "query", "Webhook secret, unless sent as a bearer token or an X-Hub-Signature-256 signature"}},
+		Response: registryWebhookResponse{}, Status: http.StatusAccepted,
+		Errors: map[int]string{400: "Unrecognized payload", 401: "Invalid webhook secret", 409: "Scheduling paused", 503: "Standby replica"}},
+}
+
+// openAPIDocument builds the OpenAPI 3 document of the HTTP API
+func openAPIDocument() map[string]any {
+	schemas := make(map[string]any)
+	paths := make(map[string]any)
+	for _, route := range apiRoutes {
+		op := map[string]any{"summary": route.Summary, "operationId": operationID(route)}
+		var params []any
+		for _, p := range route.Params {
+			params = append(params, map[string]any{
+				"name": p.Name, "in": p.In, "description": p.Description,
+				"required": p.In == "path", "schema": map[string]any{"type": "string"},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if route.Request != nil {
+			op["requestBody"] = map[string]any{"required": true, "content": map[string]any{
+				"application/json": map[string]any{"schema": jsonSchema(reflect.TypeOf(route.Request), schemas)},
+			}}
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ok := map[string]any{"description": http.StatusText(status)}
+		switch {
+		case route.ContentType != "":
+			ok["content"] = map[string]any{route.ContentType: map[string]any{"schema": map[string]any{"type": "string"}}}
+		case route.Response != nil:
+			ok["content"] = map[string]any{"application/json": map[string]any{"schema": jsonSchema(reflect.TypeOf(route.Response), schemas)}}
+		}
+		responses := map[string]any{fmt.Sprint(status): ok}
+		errorBody := map[string]any{"application/json": map[string]any{"schema": jsonSchema(reflect.TypeOf(errorResponse{}), schemas)}}
+		for code, desc := range route.Errors {
+			responses[fmt.Sprint(code)] = map[string]any{"description": desc, "content": errorBody}
+		}
+		if route.Role != "" {
+			responses["401"] = map[string]any{"description": "Authentication required", "content": errorBody}
+			if route.Role == roleOperator {
+				responses["403"] = map[string]any{"description": "Needs operator access", "content": errorBody}
+			}
+			op["security"] = []any{map[string]any{"bearerAuth": []string{}}}
+			op["x-required-role"] = route.Role
+		}
+		op["responses"] = responses
+
+		item, _ := paths[route.Path].(map[string]any)
+		if item == nil {
+			item = make(map[string]any)
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Vulnerability scan scheduler API",
+			"version":     "1",
+			"description": "Results, status and control of the scan scheduler. Endpoints with a security requirement need a bearer token or client certificate when API authentication is on; without it the API is open.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// operationID names an operation after its method and path, e.g. getFindingsVariant
+func operationID(route apiRoute) string {
+	id := strings.ToLower(route.Method)
+	for _, part := range strings.FieldsFunc(route.Path, func(r rune) bool { return strings.ContainsRune("/{}._", r) }) {
+		if part == "svg" || part == "html" {
+			continue
+		}
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema returns the schema of a type as encoding/json encodes it. Named structs
+// are added to schemas once and referenced.
+func jsonSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		return jsonSchema(t.Elem(), schemas)
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		// Exported names only; unexported response types are named after their JSON role
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // placeholder for recursive types
+			schemas[name] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// structSchema describes a struct's JSON fields; fields without omitempty are required
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	props := make(map[string]any)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = jsonSchema(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": props}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  map[string]any
+)
+
+// openAPIHandler serves GET /openapi.json
+func openAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		openAPIOnce.Do(func() { openAPIDoc = openAPIDocument() })
+		writeJSON(w, http.StatusOK, openAPIDoc)
+	}
+}
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at /openapi.json
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Scan scheduler API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// swaggerUIHandler serves GET /docs
+func swaggerUIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, swaggerUIPage)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// reportsPath is where scan reports, logs and scheduler state are kept (REPORTS_PATH)
+var reportsPath = "/reports"
+
+// parsePathFlags handles the global --scripts-path and --reports-path flags given
+// before the command. They are exported as SCRIPTS_PATH and REPORTS_PATH so they
+// outlive configuration reloads, and the remaining arguments are returned.
+func parsePathFlags(args []string) ([]string, error) {
+	fs := flag.NewFlagSet("scheduler", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	scripts := fs.String("scripts-path", "", "run the pipeline scripts from this directory (SCRIPTS_PATH)")
+	reports := fs.String("reports-path", "", "keep reports, logs and state in this directory (REPORTS_PATH)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *scripts != "" {
+		os.Setenv("SCRIPTS_PATH", *scripts)
+	}
+	if *reports != "" {
+		os.Setenv("REPORTS_PATH", *reports)
+	}
+	return fs.Args(), nil
+}
+
+// setupPaths points reportsPath and scriptsPath at the configured directories,
+// failing when they don't exist. The returned function cleans up extracted scripts.
+func setupPaths(cfg *Config) (func(), error) {
+	// Set even when missing, so `config validate` reports the configured directory
+	dir, err := existingDir("REPORTS_PATH", cfg.ReportsPath)
+	reportsPath = dir
+	setVariantTenants(cfg.Tenants)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ScriptsPath != "" {
+		dir, err := existingDir("SCRIPTS_PATH", cfg.ScriptsPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ScriptsPath = dir
+	}
+	return setupScripts(cfg)
+}
+
+// existingDir resolves path to an absolute path, which the pipeline scripts need
+// since they don't run from the scheduler's working directory, and checks that it
+// is a directory
+func existingDir(setting, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path, fmt.Errorf("invalid %s %q: %w", setting, path, err)
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return abs, fmt.Errorf("%s %s does not exist", setting, abs)
+	}
+	if !info.IsDir() {
+		return abs, fmt.Errorf("%s %s is not a directory", setting, abs)
+	}
+	return abs, nil
+}
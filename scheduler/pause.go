@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// PauseStatus is returned by the /scheduler/pause and /scheduler/resume endpoints
+type PauseStatus struct {
+	Paused   bool       `json:"paused"`
+	PausedAt *time.Time `json:"paused_at,omitempty"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+}
+
+// Paused reports whether scheduled scans are currently suspended
+func (s *Scheduler) Paused() bool {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	return s.paused
+}
+
+// SetPaused suspends or resumes scheduled scans and persists the flag so it survives restarts
+func (s *Scheduler) SetPaused(paused bool) error {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	if paused == s.paused {
+		return nil
+	}
+
+	var pausedAt *time.Time
+	if paused {
+		now := time.Now().UTC()
+		pausedAt = &now
+	}
+	err := updateState(func(state *SchedulerState) {
+		state.Paused = paused
+		state.PausedAt = pausedAt
+	})
+	if err != nil {
+		return err
+	}
+
+	s.paused, s.pausedAt = paused, pausedAt
+	return nil
+}
+
+// PauseStatus returns the current pause state and, when running, the next scan time
+func (s *Scheduler) PauseStatus() PauseStatus {
+	s.pauseMu.Lock()
+	status := PauseStatus{Paused: s.paused, PausedAt: s.pausedAt}
+	s.pauseMu.Unlock()
+
+	if !status.Paused {
+		if next := s.NextRun(); !next.IsZero() {
+			status.NextRun = &next
+		}
+	}
+	return status
+}
+
+// pauseHandler serves POST /scheduler/pause (paused=true) and POST /scheduler/resume
+func pauseHandler(s *Scheduler, paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+
+		if err := s.SetPaused(paused); err != nil {
+			log.Printf("❌ Failed to update pause state: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to persist pause state")
+			return
+		}
+
+		if paused {
+			log.Printf("⏸️  Scheduled scans paused (requested by %s)", clientID(r))
+			activity.Event("Scheduled scans paused by %s", clientID(r))
+		} else {
+			log.Printf("▶️  Scheduled scans resumed (requested by %s)", clientID(r))
+			activity.Event("Scheduled scans resumed by %s", clientID(r))
+		}
+		s.saveStatus(nil)
+		writeJSON(w, http.StatusOK, s.PauseStatus())
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Pipeline steps
+const (
+	stepScan       = "scan"
+	stepSecrets    = "secrets"
+	stepMisconfig  = "misconfig"
+	stepLicenses   = "licenses"
+	stepCompare    = "compare"
+	stepAdvisories = "advisories"
+	stepExploits   = "exploits"
+	stepHeuristics = "heuristics"
+	stepPublish    = "publish"
+	stepAttest     = "attest"
+)
+
+// defaultPipeline is the order the steps run in unless PIPELINE_STEPS or the config
+// file's "pipeline" list says otherwise
+var defaultPipeline = []string{stepScan, stepSecrets, stepMisconfig, stepLicenses, stepCompare, stepAdvisories, stepExploits, stepHeuristics, stepPublish, stepAttest}
+
+// defaultRetryDelay is the pause before retrying a failed step
+const defaultRetryDelay = 30 * time.Second
+
+// Step is a stage of a variant's scan pipeline. Steps run one after the other on the
+// same ScanJob and hand results on through the variant's reports directory.
+type Step interface {
+	// Title describes the step in the "Step 2/5: ..." log line
+	Title() string
+	// Enabled reports whether the step has anything to do with cfg; disabled steps
+	// are left out of the pipeline
+	Enabled(cfg *Config) bool
+	// Required reports whether, by default, a failure of the step fails the variant and
+	// stops the pipeline; other failures are only logged
+	Required() bool
+	Run(j *ScanJob) error
+	// Plan logs what Run would do with DRY_RUN
+	Plan(j *ScanJob)
+}
+
+// stepFactories build the pipeline steps by name; new stages register here. Loading
+// and uploading results are sinks of the publish step. The variant summary and the
+// cycle's notifications follow the pipeline: they need every step's reports, and
+// notifications cover all variants.
+var stepFactories = map[string]func() Step{
+	stepScan:       func() Step { return scanStep{} },
+	stepSecrets:    func() Step { return secretsStep{} },
+	stepMisconfig:  func() Step { return misconfigStep{} },
+	stepLicenses:   func() Step { return licensesStep{} },
+	stepCompare:    func() Step { return compareStep{} },
+	stepAdvisories: func() Step { return advisoriesStep{} },
+	stepExploits:   func() Step { return exploitsStep{} },
+	stepHeuristics: func() Step { return heuristicsStep{} },
+	stepPublish:    func() Step { return publishStep{} },
+	stepAttest:     func() Step { return attestStep{} },
+}
+
+// StepPolicy configures one entry of the "pipeline" list of the config file
+type StepPolicy struct {
+	Step string `json:"step"`
+	// Timeout stops the commands of each attempt after this long, e.g. "2h" (default: STEP_TIMEOUT)
+	Timeout string `json:"timeout,omitempty"`
+	// Retries re-runs a failed step up to this many times
+	Retries int `json:"retries,omitempty"`
+	// RetryDelay is the pause before each retry, e.g. "1m" (default: 30s)
+	RetryDelay string `json:"retry_delay,omitempty"`
+	// Required overrides whether a failure of the step fails the variant
+	Required *bool `json:"required,omitempty"`
+	// PassEnv adds variables of the scheduler's environment, or prefixes ending in *,
+	// that the step's commands inherit
+	PassEnv []string `json:"pass_env,omitempty"`
+	// Interpreter runs the step's script instead of SCRIPT_SHELL or SCRIPT_PYTHON, e.g.
+	// ["C:\\Program Files\\Git\\bin\\bash.exe"]; the script and its arguments are appended
+	Interpreter []string `json:"interpreter,omitempty"`
+}
+
+// pipelineFromNames returns the default policies of the named steps
+func pipelineFromNames(names []string) []StepPolicy {
+	policies := make([]StepPolicy, len(names))
+	for i, name := range names {
+		policies[i] = StepPolicy{Step: name}
+	}
+	return policies
+}
+
+// timeout returns the step timeout, falling back to STEP_TIMEOUT
+func (p StepPolicy) timeout(fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(p.Timeout); err == nil {
+		return d
+	}
+	return fallback
+}
+
+func (p StepPolicy) retryDelay() time.Duration {
+	if d, err := time.ParseDuration(p.RetryDelay); err == nil {
+		return d
+	}
+	return defaultRetryDelay
+}
+
+// required applies the policy's override to the step's default
+func (p StepPolicy) required(step Step) bool {
+	if p.Required != nil {
+		return *p.Required
+	}
+	return step.Required()
+}
+
+// validatePipeline checks the step list for unknown steps and invalid policies
+func validatePipeline(pipeline []StepPolicy) []error {
+	var errs []error
+	if len(pipeline) == 0 {
+		return []error{errors.New("the pipeline needs at least one step")}
+	}
+	seen := make(map[string]bool)
+	for i, p := range pipeline {
+		if _, ok := stepFactories[p.Step]; !ok {
+			errs = append(errs, fmt.Errorf("pipeline step #%d is unknown: %q (known steps: %s)", i+1, p.Step, strings.Join(sortedKeys(stepFactories), ", ")))
+			continue
+		}
+		if seen[p.Step] {
+			errs = append(errs, fmt.Errorf("pipeline step %q is listed more than once", p.Step))
+		}
+		seen[p.Step] = true
+		if p.Step == stepScan && i > 0 {
+			errs = append(errs, errors.New("pipeline step \"scan\" must come first; the other steps read its reports"))
+		}
+		if d, err := time.ParseDuration(p.Timeout); p.Timeout != "" && (err != nil || d < 0) {
+			errs = append(errs, fmt.Errorf("pipeline step %q has an invalid timeout %q", p.Step, p.Timeout))
+		}
+		if d, err := time.ParseDuration(p.RetryDelay); p.RetryDelay != "" && (err != nil || d < 0) {
+			errs = append(errs, fmt.Errorf("pipeline step %q has an invalid retry_delay %q", p.Step, p.RetryDelay))
+		}
+		if p.Retries < 0 {
+			errs = append(errs, fmt.Errorf("pipeline step %q: retries must not be negative, got %d", p.Step, p.Retries))
+		}
+		errs = append(errs, validatePassEnv(fmt.Sprintf("pipeline step %q pass_env", p.Step), p.PassEnv)...)
+		if len(p.Interpreter) > 0 && (p.Interpreter[0] == "" || (p.Step != stepScan && p.Step != stepPublish)) {
+			errs = append(errs, fmt.Errorf("pipeline step %q: interpreter must name a command and only applies to the scan and publish scripts", p.Step))
+		}
+	}
+	return errs
+}
+
+// pipelineStep is a configured step enabled for the job's configuration
+type pipelineStep struct {
+	name   string
+	step   Step
+	policy StepPolicy
+}
+
+// enabledSteps returns the configured steps that have something to do, in order
+func (j *ScanJob) enabledSteps() []pipelineStep {
+	var steps []pipelineStep
+	for _, p := range j.Config.Pipeline {
+		step := stepFactories[p.Step]()
+		if step.Enabled(j.Config) {
+			steps = append(steps, pipelineStep{name: p.Step, step: step, policy: p})
+		}
+	}
+	return steps
+}
+
+// runStep runs one step of the pipeline with its timeout and retries
+func (j *ScanJob) runStep(s pipelineStep) error {
+	timeout := s.policy.timeout(j.Config.StepTimeout)
+	var err error
+	for attempt := 0; attempt <= s.policy.Retries; attempt++ {
+		if attempt > 0 {
+			delay := s.policy.retryDelay()
+			j.Log.Printf("[%s] 🔁 Retrying the %s step in %s (attempt %d/%d): %v", j.Variant, s.name, delay, attempt+1, s.policy.Retries+1, err)
+			time.Sleep(delay)
+		}
+
+		span := j.startStep(s.name)
+		j.StepSpan = span
+		err = j.withTimeout(timeout, func() error { return s.step.Run(j) })
+		span.End(err)
+		j.StepSpan = nil
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// withTimeout runs fn, stopping the commands it runs through runLogged after timeout
+// (0 for no limit)
+func (j *ScanJob) withTimeout(timeout time.Duration, fn func() error) error {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	j.stepCtx, j.stepTimeout = ctx, timeout
+	defer func() { j.stepCtx = nil }()
+	return fn()
+}
+
+// scanStep runs scan-vulnerabilities.sh, or the built-in engine with SCAN_ENGINE=builtin,
+// which scans every image with Trivy and Grype and merges their findings into the
+// variant's reports
+type scanStep struct{}
+
+func (scanStep) Title() string          { return "Scanning images with Trivy and Grype" }
+func (scanStep) Enabled(*Config) bool   { return true }
+func (scanStep) Required() bool         { return true }
+func (s scanStep) Plan(j *ScanJob)      { s.run(j) }
+func (s scanStep) Run(j *ScanJob) error { return s.run(j) }
+
+func (scanStep) run(j *ScanJob) error {
+	scanCmd := j.Config.scriptCommand(stepScan, "scan-vulnerabilities.sh", j.Variant)
+	scanCmd.Env = j.commandEnv("SCAN_RUN_ID="+j.RunID, "REPORTS_PATH="+variantReportsRoot(j.Variant), "SEVERITY_POLICY="+j.Config.SeverityPolicy)
+	if !j.Deadline.IsZero() {
+		scanCmd.Env = append(scanCmd.Env, fmt.Sprintf("SCAN_DEADLINE=%d", j.Deadline.Unix()))
+	}
+	scanCmd.Env = append(scanCmd.Env, fmt.Sprintf("SCAN_CONCURRENCY=%d", j.Config.ScanConcurrency))
+	if j.Config.ImageTimeout > 0 {
+		scanCmd.Env = append(scanCmd.Env, fmt.Sprintf("SCAN_IMAGE_TIMEOUT=%d", int(j.Config.ImageTimeout.Seconds())))
+	}
+	if images := j.Config.VariantImages(j.Variant); len(images) > 0 {
+		scanCmd.Env = append(scanCmd.Env, "SCAN_IMAGES="+strings.Join(images, ","))
+	}
+	if platforms := j.Config.VariantPlatforms(j.Variant); len(platforms) > 0 {
+		scanCmd.Env = append(scanCmd.Env, "SCAN_PLATFORMS="+strings.Join(platforms, ","))
+	}
+	if len(j.Priority) > 0 {
+		scanCmd.Env = append(scanCmd.Env, "SCAN_PRIORITY_IMAGES="+strings.Join(j.Priority, ","))
+	}
+	if len(j.Excluded) > 0 {
+		scanCmd.Env = append(scanCmd.Env, "SCAN_EXCLUDE_IMAGES="+strings.Join(j.Excluded, ","))
+	}
+	authEnv, cleanupAuth, err := j.registryAuthEnv()
+	if err != nil {
+		return fmt.Errorf("registry credentials for %s: %w", j.Variant, err)
+	}
+	defer cleanupAuth()
+	scanCmd.Env = append(scanCmd.Env, authEnv...)
+	var digests map[string]ImageDigest
+	if j.Config.DryRun {
+		j.planScanPreparation()
+	} else {
+		j.ScannerDBs = j.ensureScannerDBs()
+		j.ScannerVersions = j.scannerVersions()
+		j.Log.Printf("[%s] 🔧 Scanner versions: %s", j.Variant, describeScannerVersions(j.ScannerVersions))
+		j.ScannerChanges = j.scannerVersionChanges(j.ScannerVersions)
+	}
+	if j.Config.SkipUnchanged && !j.Config.DryRun {
+		j.Unchanged, digests = j.unchangedImages(j.Images, append(authEnv, j.Env...))
+		if len(j.Unchanged) > 0 {
+			j.Log.Printf("[%s] ♻️  Keeping the reports of %d unchanged image(s): %s", j.Variant, len(j.Unchanged), strings.Join(j.Unchanged, ", "))
+			scanCmd.Env = append(scanCmd.Env, "SCAN_UNCHANGED_IMAGES="+strings.Join(j.Unchanged, ","))
+		}
+	}
+
+	limits, err := j.startScanLimits()
+	if err != nil {
+		return err
+	}
+	j.limits = limits
+	if j.Config.ScanEngine == scanEngineBuiltin {
+		if j.Config.DryRun {
+			j.Log.Printf("[%s] 🧪 Would scan %d image(s) with the built-in engine", j.Variant, len(j.Images))
+		} else {
+			err = j.builtinScan(authEnv)
+		}
+	} else {
+		err = j.runLogged("scan", scanCmd)
+	}
+	j.limits = nil
+	if err = limits.finish(j, err); err != nil {
+		return fmt.Errorf("scan failed for %s: %w", j.Variant, err)
+	}
+	if j.Config.DryRun {
+		return nil
+	}
+	j.Log.Printf("[%s] ✅ Scan completed successfully", j.Variant)
+	if err := j.recordImageDigests(digests); err != nil {
+		j.Log.Printf("⚠️  Could not record the image digests of %s: %v", j.Variant, err)
+	}
+	if err := j.recordScannerVersions(j.ScannerVersions); err != nil {
+		j.Log.Printf("⚠️  Could not record the scanner versions of %s: %v", j.Variant, err)
+	}
+	return nil
+}
+
+// compareStep reports where Trivy and Grype disagree
+type compareStep struct{}
+
+func (compareStep) Title() string        { return "Comparing Trivy and Grype findings" }
+func (compareStep) Enabled(*Config) bool { return true }
+func (compareStep) Required() bool       { return false }
+
+func (compareStep) Plan(j *ScanJob) {
+	j.Log.Printf("[%s] 🧪 Would compare Trivy and Grype findings", j.Variant)
+}
+
+func (compareStep) Run(j *ScanJob) error {
+	report, err := buildDisagreementReport(j.Variant)
+	if err != nil {
+		return fmt.Errorf("scanner disagreement report failed: %w", err)
+	}
+	if err := writeDisagreementReport(report); err != nil {
+		return fmt.Errorf("could not write scanner disagreement report: %w", err)
+	}
+	j.Log.Printf("[%s] ✅ Scanners agreed on %d findings (Trivy-only: %d, Grype-only: %d, severity mismatches: %d)",
+		j.Variant, report.Agreed, report.TrivyOnly, report.GrypeOnly, report.SeverityMismatch)
+	return nil
+}
+
+// advisoriesStep cross-checks findings against vendor advisory feeds (ADVISORY_FEEDS)
+type advisoriesStep struct{}
+
+func (advisoriesStep) Title() string            { return "Cross-checking vendor advisory feeds" }
+func (advisoriesStep) Enabled(cfg *Config) bool { return len(cfg.AdvisoryFeeds) > 0 }
+func (advisoriesStep) Required() bool           { return false }
+
+func (advisoriesStep) Plan(j *ScanJob) {
+	j.Log.Printf("[%s] 🧪 Would cross-check vendor advisories from %s", j.Variant, strings.Join(j.Config.AdvisoryFeeds, ", "))
+}
+
+func (advisoriesStep) Run(j *ScanJob) error {
+	summary, err := annotateVendorAdvisories(j.Variant, j.Config.AdvisoryFeeds, j.Config.AdvisoryCacheTTL)
+	if err != nil {
+		return fmt.Errorf("advisory cross-check failed: %w", err)
+	}
+	j.Log.Printf("[%s] ✅ Vendor advisories: %d fixed, %d not affected, %d unacknowledged, %d not covered",
+		j.Variant, summary.Statuses[advisoryFixed], summary.Statuses[advisoryNotAffected],
+		summary.Statuses[advisoryUnacknowledged], summary.Uncovered)
+	return nil
+}
+
+// exploitsStep annotates findings with EPSS scores and CISA KEV entries (EXPLOIT_INTEL)
+type exploitsStep struct{}
+
+func (exploitsStep) Title() string            { return "Enriching findings with EPSS and KEV" }
+func (exploitsStep) Enabled(cfg *Config) bool { return cfg.ExploitIntel.Enabled }
+func (exploitsStep) Required() bool           { return false }
+
+func (exploitsStep) Plan(j *ScanJob) {
+	j.Log.Printf("[%s] 🧪 Would enrich findings with EPSS and KEV", j.Variant)
+}
+
+func (exploitsStep) Run(j *ScanJob) error {
+	summary, err := annotateExploitIntel(j.Variant, j.Config.ExploitIntel)
+	if err != nil {
+		return fmt.Errorf("exploit intelligence enrichment failed: %w", err)
+	}
+	j.Log.Printf("[%s] ✅ Exploit intelligence: %d known exploited CVE(s), %d of %d findings with EPSS >= %.0f%%",
+		j.Variant, len(summary.KnownExploited), summary.HighEPSS, summary.Findings, highEPSSScore*100)
+	return nil
+}
+
+// heuristicsStep flags likely false positives so they are reported as disputed
+// instead of counted
+type heuristicsStep struct{}
+
+func (heuristicsStep) Title() string  { return "Applying false-positive heuristics" }
+func (heuristicsStep) Required() bool { return false }
+
+func (heuristicsStep) Enabled(cfg *Config) bool {
+	return len(cfg.FalsePositives.Heuristics) > 0 || len(cfg.FalsePositives.Rules) > 0
+}
+
+func (heuristicsStep) Plan(j *ScanJob) {
+	j.Log.Printf("[%s] 🧪 Would apply false-positive heuristics %v and %d rule(s)",
+		j.Variant, j.Config.FalsePositives.Heuristics, len(j.Config.FalsePositives.Rules))
+}
+
+func (heuristicsStep) Run(j *ScanJob) error {
+	summary, err := flagFalsePositives(j.Variant, j.Config.FalsePositives)
+	if err != nil {
+		return fmt.Errorf("false-positive heuristics failed: %w", err)
+	}
+	j.Log.Printf("[%s] ✅ %d findings marked as disputed %v", j.Variant, summary.Total, summary.ByRule)
+	return nil
+}
+
+// publishStep hands the results to the configured sinks (database, files, webhooks, ...)
+type publishStep struct{}
+
+func (publishStep) Title() string        { return "Publishing results to the sinks" }
+func (publishStep) Enabled(*Config) bool { return true }
+func (publishStep) Required() bool       { return true }
+func (publishStep) Plan(j *ScanJob)      { j.planSinks() }
+
+func (publishStep) Run(j *ScanJob) error {
+	sinks := j.Config.Sinks
+	j.StepSpan.SetAttr("scan.sinks", len(sinks))
+	failures, err := j.publishToSinks(sinks)
+	j.StepSpan.SetAttr("scan.sink_failures", len(failures))
+	if len(failures) > 0 {
+		j.SinkErrors = failures
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// platformPattern matches an OCI platform: os/arch with an optional variant, e.g. linux/arm64/v8
+var platformPattern = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
+
+// validPlatform reports whether p is a platform Trivy and Grype accept with --platform
+func validPlatform(p string) bool {
+	return platformPattern.MatchString(p)
+}
+
+// VariantPlatforms returns the platforms a variant's images are scanned for; none
+// scans the platform each tag resolves to
+func (c *Config) VariantPlatforms(name string) []string {
+	for _, v := range c.Variants {
+		if v.Name == name && len(v.Platforms) > 0 {
+			return v.Platforms
+		}
+	}
+	return c.Platforms
+}
+
+// platformReportFile is the merged report file name scan-vulnerabilities.sh uses for
+// one platform of an image. '+' can't appear in an image reference, so the linux/arm64
+// report of nginx:1.27 is nginx_1.27+linux-arm64_scan.json.
+func platformReportFile(image, platform string) string {
+	if platform == "" {
+		return imageReportFile(image)
+	}
+	return strings.TrimSuffix(imageReportFile(image), "_scan.json") + "+" + strings.ReplaceAll(platform, "/", "-") + "_scan.json"
+}
+
+// imageReportFiles lists the merged reports of an image in a variant: one per
+// scanned platform, or the single report of an image scanned without SCAN_PLATFORMS
+func imageReportFiles(variant, image string) ([]string, error) {
+	name := strings.TrimSuffix(imageReportFile(image), "_scan.json")
+	files, err := filepath.Glob(filepath.Join(variantDir(variant), name+"_scan.json"))
+	if err != nil {
+		return nil, err
+	}
+	perPlatform, err := filepath.Glob(filepath.Join(variantDir(variant), name+"+*_scan.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range perPlatform {
+		if !strings.HasSuffix(f, "_trivy_scan.json") && !strings.HasSuffix(f, "_grype_scan.json") {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// reportImageFile maps a merged report file name to the one of its image without a
+// platform, which the exclusions and skipped images are matched against
+func reportImageFile(file string) string {
+	name := strings.TrimSuffix(filepath.Base(file), "_scan.json")
+	if i := strings.Index(name, "+"); i >= 0 {
+		name = name[:i]
+	}
+	return name + "_scan.json"
+}
+
+// Label names an image report in listings, with its platform when it was scanned for one
+func (r ImageReport) Label() string {
+	if r.Platform == "" {
+		return r.Image
+	}
+	return r.Image + " (" + r.Platform + ")"
+}
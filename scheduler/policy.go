@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SeverityThresholds is the most findings of each severity a variant may have,
+// e.g. {"CRITICAL": 0, "HIGH": 10}; severities without a threshold are unlimited
+type SeverityThresholds map[string]int
+
+// parseSeverityThresholds reads "CRITICAL=0,HIGH=10"
+func parseSeverityThresholds(s string) (SeverityThresholds, error) {
+	t := make(SeverityThresholds)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		sev, limit, ok := strings.Cut(item, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if !ok || err != nil {
+			return nil, fmt.Errorf("%q must be SEVERITY=count", item)
+		}
+		t[strings.ToUpper(strings.TrimSpace(sev))] = n
+	}
+	return t, nil
+}
+
+// Validate reports unknown severities and negative thresholds
+func (t SeverityThresholds) Validate() []error {
+	sevs := make([]string, 0, len(t))
+	for sev := range t {
+		sevs = append(sevs, sev)
+	}
+	sort.Strings(sevs)
+
+	var errs []error
+	for _, sev := range sevs {
+		limit := t[sev]
+		if severityRank(sev) == len(severityOrder) {
+			errs = append(errs, fmt.Errorf("unknown severity %q in severity thresholds (known: %s)", sev, strings.Join(severityOrder, ", ")))
+		}
+		if limit < 0 {
+			errs = append(errs, fmt.Errorf("severity threshold for %s must not be negative, got %d", sev, limit))
+		}
+	}
+	return errs
+}
+
+// PolicyViolation is a severity whose findings exceed its threshold
+type PolicyViolation struct {
+	Severity string `json:"severity"`
+	Count    int    `json:"count"`
+	Max      int    `json:"max"`
+}
+
+func (v PolicyViolation) String() string {
+	return fmt.Sprintf("%s %d (max %d)", v.Severity, v.Count, v.Max)
+}
+
+// Check returns the severities of counts over their threshold, most severe first
+func (t SeverityThresholds) Check(counts map[string]int) []PolicyViolation {
+	var violations []PolicyViolation
+	for _, sev := range severityOrder {
+		if limit, ok := t[sev]; ok && counts[sev] > limit {
+			violations = append(violations, PolicyViolation{Severity: sev, Count: counts[sev], Max: limit})
+		}
+	}
+	return violations
+}
+
+// describeViolations renders violations for logs, e.g. "CRITICAL 3 (max 0), HIGH 12 (max 10)"
+func describeViolations(violations []PolicyViolation) string {
+	parts := make([]string, len(violations))
+	for i, v := range violations {
+		parts[i] = v.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// reportPolicyViolations hands a variant's threshold breaches to the configured
+// trackers; a tracker that fails is logged and does not fail the scan
+func reportPolicyViolations(cfg *Config, variant, runID string, violations []PolicyViolation, logger *log.Logger) {
+	publishEvent(eventPolicyViolated, runID, variant, map[string]any{"violations": violations})
+	if cfg.Jira.Enabled() {
+		if err := syncJiraTicket(cfg.Jira, variant, runID, violations, logger); err != nil {
+			logger.Printf("⚠️  [%s] Could not update the Jira ticket: %v", variant, err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const preflightDBTimeout = 10 * time.Second
+
+// PreflightCheck is the outcome of a single environment check
+type PreflightCheck struct {
+	Name string
+	Err  error
+}
+
+// pipelineScripts returns the scripts invoked by the scan pipeline, relative to
+// scriptsPath; the built-in scan engine only needs the loader
+func (c *Config) pipelineScripts() []string {
+	if c.ScanEngine == scanEngineBuiltin {
+		return []string{"load-to-database.py", "scan-report.schema.json"}
+	}
+	return []string{"scan-vulnerabilities.sh", "merge-scan-results.py", "load-to-database.py", "scan-report.schema.json"}
+}
+
+// pipelineTools returns the executables the pipeline and its scripts depend on
+func (c *Config) pipelineTools() []string {
+	tools := []string{c.scriptInterpreter(stepPublish, "load-to-database.py")[0], "trivy", "grype"}
+	if c.ScanEngine == scanEngineScript {
+		tools = append(tools, c.scriptInterpreter(stepScan, "scan-vulnerabilities.sh")[0], c.ScriptPython[0], "jq")
+	}
+	slices.Sort(tools)
+	return slices.Compact(tools)
+}
+
+// runPreflightChecks verifies that the environment can actually run a scan cycle.
+// The pipeline scripts and tools are only checked when scans run in this process.
+func runPreflightChecks(cfg *Config, scans bool) []PreflightCheck {
+	var checks []PreflightCheck
+
+	if scans {
+		for _, script := range cfg.pipelineScripts() {
+			path := filepath.Join(scriptsPath, script)
+			checks = append(checks, PreflightCheck{Name: "script " + path, Err: checkReadableFile(path)})
+		}
+
+		for _, tool := range cfg.pipelineTools() {
+			_, err := exec.LookPath(tool)
+			if err != nil {
+				err = fmt.Errorf("%s not found on PATH", tool)
+			}
+			checks = append(checks, PreflightCheck{Name: "tool " + tool, Err: err})
+		}
+
+		if cfg.Offline {
+			checks = append(checks, checkOfflineDBs(cfg.OfflineDBPath)...)
+		}
+
+		// Credential helpers named in registry_auth, e.g. docker-credential-ecr-login
+		for _, v := range cfg.Variants {
+			if v.RegistryAuth == nil {
+				continue
+			}
+			for _, helper := range v.RegistryAuth.helpers() {
+				_, err := exec.LookPath(helper)
+				if err != nil {
+					err = fmt.Errorf("%s not found on PATH", helper)
+				}
+				checks = append(checks, PreflightCheck{Name: fmt.Sprintf("credential helper %s (%s)", helper, v.Name), Err: err})
+			}
+		}
+	}
+
+	for _, variant := range cfg.VariantNames() {
+		dir := variantDir(variant)
+		checks = append(checks, PreflightCheck{Name: "reports directory " + dir, Err: checkWritableDir(dir)})
+	}
+
+	if cfg.MinFreeDisk > 0 {
+		for _, l := range scanDiskLocations(scans) {
+			_, err := checkDiskSpace([]DiskLocation{l}, cfg.MinFreeDisk)
+			checks = append(checks, PreflightCheck{Name: "free disk space " + l.Path, Err: err})
+		}
+	}
+
+	if cfg.DB.Backend == backendSQLite {
+		// The loader creates the file and its tables on first use
+		path := cfg.DB.SQLitePath()
+		checks = append(checks, PreflightCheck{Name: "database sqlite " + path, Err: checkWritableDir(filepath.Dir(path))})
+	} else {
+		checks = append(checks, PreflightCheck{
+			Name: fmt.Sprintf("database %s@%s:%d/%s", cfg.DB.User, cfg.DB.Host, cfg.DB.Port, cfg.DB.Name),
+			Err:  checkDatabase(cfg.DB, cfg.scriptInterpreter(stepPublish, "load-to-database.py")),
+		})
+	}
+
+	return checks
+}
+
+// preflightError combines failed checks into a single error
+func preflightError(checks []PreflightCheck) error {
+	var errs []error
+	for _, c := range checks {
+		if c.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, c.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkReadableFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("not found (check SCRIPTS_PATH)")
+	}
+	if info.IsDir() {
+		return fmt.Errorf("is a directory")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("not readable: %w", err)
+	}
+	return f.Close()
+}
+
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("cannot create: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".preflight-")
+	if err != nil {
+		return fmt.Errorf("not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkDatabase connects with psycopg2, exactly as the loader does, so both
+// connectivity and the Python database driver are verified
+func checkDatabase(db DBConfig, python []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightDBTimeout)
+	defer cancel()
+
+	const probe = `import os, psycopg2
+psycopg2.connect(host=os.environ["DB_HOST"], port=int(os.environ["DB_PORT"]), dbname=os.environ["DB_NAME"],
+                 user=os.environ["DB_USER"], password=os.environ["DB_PASSWORD"], connect_timeout=5).close()`
+
+	cmd := exec.CommandContext(ctx, python[0], append(python[1:len(python):len(python)], "-c", probe)...)
+	cmd.Env = append(os.Environ(), db.Env()...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("connection timed out after %s", preflightDBTimeout)
+		}
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		return fmt.Errorf("cannot connect: %s", lines[len(lines)-1])
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// killGracePeriod is how long a step's processes get to exit after SIGTERM before
+// they are killed
+const killGracePeriod = 10 * time.Second
+
+// orphanWaitDelay bounds waiting for the output of processes a step left behind
+const orphanWaitDelay = 5 * time.Second
+
+// processGroups tracks the process groups of running steps, so they can be stopped
+// as a whole when the scheduler shuts down
+var processGroups = &groupRegistry{groups: make(map[int]*exec.Cmd)}
+
+type groupRegistry struct {
+	mu     sync.Mutex
+	groups map[int]*exec.Cmd
+	// stopping is set by TerminateAll; groups started after it are refused
+	stopping bool
+}
+
+// add tracks a running step's group, reporting false when the scheduler is shutting down
+func (r *groupRegistry) add(pgid int, cmd *exec.Cmd) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopping {
+		return false
+	}
+	r.groups[pgid] = cmd
+	return true
+}
+
+func (r *groupRegistry) remove(pgid int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.groups, pgid)
+}
+
+// TerminateAll stops every running step along with the processes it started,
+// returning how many steps were running. Steps started afterwards are killed at once.
+func (r *groupRegistry) TerminateAll() int {
+	r.mu.Lock()
+	r.stopping = true
+	groups := make(map[int]*exec.Cmd, len(r.groups))
+	for pgid, cmd := range r.groups {
+		groups[pgid] = cmd
+	}
+	r.mu.Unlock()
+
+	for pgid, cmd := range groups {
+		signalGroup(pgid, cmd, false)
+	}
+	deadline := time.Now().Add(killGracePeriod)
+	for pgid, cmd := range groups {
+		if !waitGroupGone(pgid, time.Until(deadline)) {
+			signalGroup(pgid, cmd, true)
+		}
+	}
+	return len(groups)
+}
+
+// runInGroup runs cmd in its own process group, so everything it starts (Trivy and
+// Grype under bash) can be stopped together: when ctx ends, and when the command
+// exits leaving children behind
+func runInGroup(ctx context.Context, name string, cmd *exec.Cmd) error {
+	return runLimited(ctx, name, cmd, nil)
+}
+
+// runLimited runs cmd like runInGroup, its process group confined by the scan step's
+// limits when they are set
+func runLimited(ctx context.Context, name string, cmd *exec.Cmd, limits *scanLimits) error {
+	setProcessGroup(cmd)
+	limits.prepare(cmd)
+	cmd.WaitDelay = orphanWaitDelay
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pgid := cmd.Process.Pid
+	limits.started(pgid)
+	if !processGroups.add(pgid, cmd) {
+		// The scheduler is shutting down
+		signalGroup(pgid, cmd, true)
+	}
+	defer processGroups.remove(pgid)
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		signalGroup(pgid, cmd, false)
+		select {
+		case err = <-done:
+		case <-time.After(killGracePeriod):
+			signalGroup(pgid, cmd, true)
+			err = <-done
+		}
+		err = fmt.Errorf("%w (%v)", ctx.Err(), err)
+	}
+	reapGroup(pgid, name, cmd)
+	return err
+}
+
+// reapGroup kills the processes a command left behind in its group and verifies
+// that none of them survive
+func reapGroup(pgid int, name string, cmd *exec.Cmd) {
+	left := groupSurvivors(pgid)
+	if len(left) == 0 {
+		return
+	}
+	signalGroup(pgid, cmd, true)
+	if waitGroupGone(pgid, 2*time.Second) {
+		log.Printf("🧹 Killed %d process(es) left behind by %s: %s", len(left), name, strings.Join(left, ", "))
+		return
+	}
+	log.Printf("⚠️  Processes left behind by %s are still running after being killed: %s", name, strings.Join(groupSurvivors(pgid), ", "))
+}
+
+// waitGroupGone waits up to timeout for every process of a group to exit
+func waitGroupGone(pgid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if len(groupSurvivors(pgid)) == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// signalError is the cause of the shutdown context: the signal that stops the scheduler
+type signalError struct{ sig os.Signal }
+
+func (e *signalError) Error() string { return "received " + e.sig.String() }
+
+// stopStepsOnSignal returns a context canceled on SIGINT or SIGTERM once the running
+// steps and their children are stopped; in their own process groups they don't get
+// the signal. Commands then return through their deferred cleanup with
+// shutdownExitCode. A second signal kills the process at once.
+func stopStepsOnSignal() context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		log.Printf("Received %s, stopping the running steps...", sig)
+		if n := processGroups.TerminateAll(); n > 0 {
+			log.Printf("Stopped %d running step(s)", n)
+		}
+		cancel(&signalError{sig: sig})
+	}()
+	return ctx
+}
+
+// shutdownExitCode is 128 plus the number of the signal that canceled ctx, the code
+// a shell reports for a process the signal killed
+func shutdownExitCode(ctx context.Context) int {
+	var se *signalError
+	if errors.As(context.Cause(ctx), &se) {
+		if sig, ok := se.sig.(syscall.Signal); ok {
+			return 128 + int(sig)
+		}
+	}
+	return exitFailure
+}
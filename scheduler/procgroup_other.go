@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// setProcessGroup is a no-op without Unix process groups
+func setProcessGroup(*exec.Cmd) {}
+
+// signalGroup kills the command itself; without process groups its children
+// can't be reached
+func signalGroup(_ int, cmd *exec.Cmd, _ bool) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// groupSurvivors can't list the children of a command without process groups
+func groupSurvivors(int) []string {
+	return nil
+}
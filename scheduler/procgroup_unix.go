@@ -0,0 +1,65 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// setProcessGroup makes cmd the leader of a new process group
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalGroup sends SIGTERM, or SIGKILL when force is set, to every process of a group
+func signalGroup(pgid int, _ *exec.Cmd, force bool) {
+	sig := syscall.SIGTERM
+	if force {
+		sig = syscall.SIGKILL
+	}
+	syscall.Kill(-pgid, sig)
+}
+
+// groupSurvivors lists the live processes of a group as "name[pid]". Zombies, which
+// only wait to be reaped by their parent, don't count. Without /proc any process
+// still in the group is reported.
+func groupSurvivors(pgid int) []string {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil || len(stats) == 0 {
+		if syscall.Kill(-pgid, 0) == nil {
+			return []string{fmt.Sprintf("process group %d", pgid)}
+		}
+		return nil
+	}
+
+	var left []string
+	for _, path := range stats {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// "pid (comm) state ppid pgrp ...", where comm may contain spaces and parentheses
+		stat := string(data)
+		open, end := strings.IndexByte(stat, '('), strings.LastIndexByte(stat, ')')
+		if open < 0 || end < open {
+			continue
+		}
+		fields := strings.Fields(stat[end+1:])
+		if len(fields) < 3 || fields[0] == "Z" || fields[0] == "X" {
+			continue
+		}
+		if pgrp, _ := strconv.Atoi(fields[2]); pgrp == pgid {
+			left = append(left, fmt.Sprintf("%s[%s]", stat[open+1:end], strings.TrimSpace(stat[:open])))
+		}
+	}
+	return left
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ScanProgress counts the images of a variant's scan step that are done, so a long
+// cycle shows how far it got in the logs, GET /scheduler/activity, GET /status and
+// the metrics
+type ScanProgress struct {
+	// Total is the number of images to scan, excluded images left out
+	Total   int `json:"total"`
+	Done    int `json:"done"`
+	Scanned int `json:"scanned"`
+	// Unchanged images kept the report of their last scan (SKIP_UNCHANGED_IMAGES)
+	Unchanged int `json:"unchanged,omitempty"`
+	Failed    int `json:"failed,omitempty"`
+	Skipped   int `json:"skipped,omitempty"`
+}
+
+func (p ScanProgress) String() string {
+	s := fmt.Sprintf("%d/%d images done", p.Done, p.Total)
+	var details []string
+	for _, c := range []struct {
+		n    int
+		name string
+	}{{p.Unchanged, "unchanged"}, {p.Failed, "failed"}, {p.Skipped, "skipped"}} {
+		if c.n > 0 {
+			details = append(details, fmt.Sprintf("%d %s", c.n, c.name))
+		}
+	}
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, ", ") + ")"
+	}
+	return s
+}
+
+// count records an image reaching a final status
+func (p *ScanProgress) count(status string) {
+	switch status {
+	case imageScanned:
+		p.Scanned++
+	case imageUnchanged:
+		p.Unchanged++
+	case imageFailed:
+		p.Failed++
+	case imageSkipped:
+		p.Skipped++
+	default:
+		return
+	}
+	p.Done++
+}
+
+// jobProgressKey identifies the scan step of a variant in a run
+type jobProgressKey struct{ runID, variant string }
+
+// jobProgress holds the progress of the scan steps running in this process, which
+// `scheduler worker` reports to the scheduler with each heartbeat of a queued job
+var jobProgress sync.Map
+
+// runningScanProgress returns the progress of a variant's running scan step, or nil
+func runningScanProgress(runID, variant string) *ScanProgress {
+	if p, ok := jobProgress.Load(jobProgressKey{runID, variant}); ok {
+		progress := p.(ScanProgress)
+		return &progress
+	}
+	return nil
+}
+
+// writeScanProgressMetrics writes the image progress of the running cycle's variants
+func writeScanProgressMetrics(b *strings.Builder) {
+	run := activity.Snapshot().Run
+	if run == nil || run.FinishedAt != nil {
+		return
+	}
+	b.WriteString("# HELP scheduler_scan_images Images of the running cycle's variants by scan progress (total: images to scan).\n")
+	b.WriteString("# TYPE scheduler_scan_images gauge\n")
+	for _, v := range run.Variants {
+		p := v.Progress
+		if p == nil {
+			continue
+		}
+		for _, c := range []struct {
+			state string
+			n     int
+		}{
+			{"total", p.Total}, {"done", p.Done}, {imageScanned, p.Scanned},
+			{imageUnchanged, p.Unchanged}, {imageFailed, p.Failed}, {imageSkipped, p.Skipped},
+		} {
+			fmt.Fprintf(b, "scheduler_scan_images{variant=%q,state=%q} %d\n", v.Variant, c.state, c.n)
+		}
+	}
+}
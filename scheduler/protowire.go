@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoMessage builds a message in the protobuf wire format, for the gRPC API. Like
+// proto3, fields holding their zero value are left out.
+type protoMessage []byte
+
+func (m *protoMessage) tag(field, wireType int) {
+	*m = binary.AppendUvarint(*m, uint64(field)<<3|uint64(wireType))
+}
+
+func (m *protoMessage) appendString(field int, s string) {
+	if s == "" {
+		return
+	}
+	m.tag(field, wireBytes)
+	*m = binary.AppendUvarint(*m, uint64(len(s)))
+	*m = append(*m, s...)
+}
+
+func (m *protoMessage) appendInt(field int, v int64) {
+	if v == 0 {
+		return
+	}
+	m.tag(field, wireVarint)
+	*m = binary.AppendUvarint(*m, uint64(v))
+}
+
+func (m *protoMessage) appendBool(field int, v bool) {
+	if v {
+		m.appendInt(field, 1)
+	}
+}
+
+// appendMessage embeds a nested message; unlike scalars it is written even when empty, so
+// repeated messages keep their count
+func (m *protoMessage) appendMessage(field int, sub protoMessage) {
+	m.tag(field, wireBytes)
+	*m = binary.AppendUvarint(*m, uint64(len(sub)))
+	*m = append(*m, sub...)
+}
+
+// appendTime embeds a google.protobuf.Timestamp
+func (m *protoMessage) appendTime(field int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	var ts protoMessage
+	ts.appendInt(1, t.Unix())
+	ts.appendInt(2, int64(t.Nanosecond()))
+	m.appendMessage(field, ts)
+}
+
+var errBadProto = errors.New("malformed protobuf message")
+
+// protoFields calls fn with each field of a message: varints in v, length-delimited
+// fields in data. Fixed-size fields, which the API's requests don't use, are skipped.
+func protoFields(b []byte, fn func(field int, v uint64, data []byte)) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errBadProto
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errBadProto
+			}
+			b = b[n:]
+			fn(field, v, nil)
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errBadProto
+			}
+			fn(field, 0, b[n:n+int(size)])
+			b = b[n+int(size):]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errBadProto
+			}
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errBadProto
+			}
+			b = b[4:]
+		default:
+			return errBadProto
+		}
+	}
+	return nil
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Where variant scans run
+const (
+	queueLocal    = "local"    // inside the scheduler process
+	queuePostgres = "postgres" // on `scheduler worker` processes fed by the scan_jobs table
+)
+
+// Scan job states
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+const (
+	// localWorkerPrefix marks jobs claimed by the scheduler itself rather than an external worker
+	localWorkerPrefix = "scheduler/"
+
+	queueQueryTimeout       = 10 * time.Second
+	workerHeartbeatInterval = 30 * time.Second
+	// maxJobAttempts bounds how often a job is re-queued after its worker disappeared
+	maxJobAttempts = 3
+)
+
+// queuedJob is a scan job claimed by a worker
+type queuedJob struct {
+	ID       int64
+	RunID    string
+	Variant  string
+	Deadline time.Time
+	Priority []string
+}
+
+// jobQueue is the Postgres-backed scan job queue shared by the scheduler, which
+// enqueues one job per variant and cycle, and the workers that run them
+type jobQueue struct {
+	db *sql.DB
+}
+
+func openJobQueue(cfg DBConfig) (*jobQueue, error) {
+	if err := cfg.requirePostgres("the scan job queue"); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database for the job queue: %w", err)
+	}
+	return &jobQueue{db: db}, nil
+}
+
+func (q *jobQueue) Close() error {
+	return q.db.Close()
+}
+
+// Claim marks the oldest queued job as running on worker and returns it, or nil when
+// the queue is empty. An empty variants list accepts jobs for any variant, an empty
+// runID jobs of any run.
+func (q *jobQueue) Claim(worker string, variants []string, runID string) (*queuedJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueQueryTimeout)
+	defer cancel()
+
+	var (
+		job      queuedJob
+		deadline sql.NullTime
+		prio     []byte
+	)
+	err := q.db.QueryRowContext(ctx, `
+		UPDATE scan_jobs
+		SET status = $1, worker = $2, attempts = attempts + 1, started_at = NOW(), heartbeat_at = NOW(), progress = NULL
+		WHERE id = (
+			SELECT id FROM scan_jobs
+			WHERE status = $3 AND (COALESCE(cardinality($4::text[]), 0) = 0 OR variant = ANY($4::text[]))
+				AND ($5::text = '' OR run_id = $5::text)
+			ORDER BY enqueued_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, run_id, variant, deadline, priority_images`,
+		jobRunning, worker, jobQueued, pq.Array(variants), runID,
+	).Scan(&job.ID, &job.RunID, &job.Variant, &deadline, &prio)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim a job: %w", err)
+	}
+
+	if deadline.Valid {
+		job.Deadline = deadline.Time
+	}
+	if len(prio) > 0 {
+		if err := json.Unmarshal(prio, &job.Priority); err != nil {
+			return nil, fmt.Errorf("job %d has invalid priority images: %w", job.ID, err)
+		}
+	}
+	return &job, nil
+}
+
+// Heartbeat tells the scheduler that the worker running a job is still alive, and
+// how far its scan step got when progress is not nil
+func (q *jobQueue) Heartbeat(id int64, progress *ScanProgress) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queueQueryTimeout)
+	defer cancel()
+
+	var data sql.NullString
+	if progress != nil {
+		b, err := json.Marshal(progress)
+		if err != nil {
+			return err
+		}
+		data = sql.NullString{String: string(b), Valid: true}
+	}
+	_, err := q.db.ExecContext(ctx,
+		`UPDATE scan_jobs SET heartbeat_at = NOW(), progress = COALESCE($2::jsonb, progress) WHERE id = $1`,
+		id, data)
+	return err
+}
+
+// RunningProgress returns the scan progress the workers reported for the running
+// jobs of a run, by variant
+func (q *jobQueue) RunningProgress(runID string) (map[string]ScanProgress, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueQueryTimeout)
+	defer cancel()
+
+	rows, err := q.db.QueryContext(ctx,
+		`SELECT variant, progress FROM scan_jobs WHERE run_id = $1 AND status = $2 AND progress IS NOT NULL`,
+		runID, jobRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	progress := make(map[string]ScanProgress)
+	for rows.Next() {
+		var (
+			variant string
+			data    []byte
+			p       ScanProgress
+		)
+		if err := rows.Scan(&variant, &data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, fmt.Errorf("job of %s has invalid progress: %w", variant, err)
+		}
+		progress[variant] = p
+	}
+	return progress, rows.Err()
+}
+
+// Complete stores the outcome of a job. It is ignored if the job was meanwhile
+// re-queued for another worker.
+func (q *jobQueue) Complete(id int64, worker string, result VariantResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queueQueryTimeout)
+	defer cancel()
+
+	status := jobSucceeded
+	if !result.Success {
+		status = jobFailed
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = q.db.ExecContext(ctx,
+		`UPDATE scan_jobs SET status = $2, result = $3, error = NULLIF($4, ''), finished_at = NOW()
+		WHERE id = $1 AND status = $5 AND worker = $6`,
+		id, status, string(data), result.Error, jobRunning, worker)
+	return err
+}
+
+// Cancel withdraws a job that no worker has claimed yet, reporting whether it was
+func (q *jobQueue) Cancel(id int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueQueryTimeout)
+	defer cancel()
+
+	res, err := q.db.ExecContext(ctx,
+		`UPDATE scan_jobs SET status = $2, finished_at = NOW() WHERE id = $1 AND status = $3`,
+		id, jobCancelled, jobQueued)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Result returns the status of a job and, once it finished, the worker's result
+func (q *jobQueue) Result(id int64) (string, *VariantResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueQueryTimeout)
+	defer cancel()
+
+	var (
+		status string
+		data   []byte
+		errMsg sql.NullString
+	)
+	err := q.db.QueryRowContext(ctx, `SELECT status, result, error FROM scan_jobs WHERE id = $1`, id).
+		Scan(&status, &data, &errMsg)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(data) == 0 {
+		if status == jobFailed {
+			return status, &VariantResult{Error: errMsg.String}, nil
+		}
+		return status, nil, nil
+	}
+	var result VariantResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", nil, fmt.Errorf("job %d has an invalid result: %w", id, err)
+	}
+	return status, &result, nil
+}
+
+// RequeueStale returns running jobs whose worker stopped sending heartbeats to the
+// queue, failing those that already used up their attempts
+func (q *jobQueue) RequeueStale(after time.Duration) (requeued, failed int64, err error) {
+	return q.requeueRunning(`heartbeat_at < NOW() - make_interval(secs => $1)`, "worker stopped responding", after.Seconds())
+}
+
+// RequeueAbandoned returns running jobs claimed by a previous scheduler process, which
+// ran them in-process and is gone after a restart
+func (q *jobQueue) RequeueAbandoned() (requeued, failed int64, err error) {
+	return q.requeueRunning(`worker LIKE $1`, "scheduler restarted during the scan", localWorkerPrefix+"%")
+}
+
+// requeueRunning re-queues running jobs matching cond, which refers to arg as $1, and
+// fails those that already used up their attempts with reason
+func (q *jobQueue) requeueRunning(cond, reason string, arg any) (requeued, failed int64, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueQueryTimeout)
+	defer cancel()
+
+	res, err := q.db.ExecContext(ctx, `
+		UPDATE scan_jobs SET status = $2, error = $3, finished_at = NOW()
+		WHERE status = $4 AND attempts >= $5 AND `+cond,
+		arg, jobFailed, reason, jobRunning, maxJobAttempts)
+	if err != nil {
+		return 0, 0, err
+	}
+	failed, _ = res.RowsAffected()
+
+	res, err = q.db.ExecContext(ctx, `
+		UPDATE scan_jobs SET status = $2, worker = NULL
+		WHERE status = $3 AND `+cond,
+		arg, jobQueued, jobRunning)
+	if err != nil {
+		return 0, failed, err
+	}
+	requeued, _ = res.RowsAffected()
+	return requeued, failed, nil
+}
+
+// InterruptedRun returns the most recent run that still has queued or running jobs,
+// or "" if every cycle finished
+func (q *jobQueue) InterruptedRun() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueQueryTimeout)
+	defer cancel()
+
+	var runID string
+	err := q.db.QueryRowContext(ctx,
+		`SELECT run_id FROM scan_jobs WHERE status IN ($1, $2) ORDER BY enqueued_at DESC, id DESC LIMIT 1`,
+		jobQueued, jobRunning).Scan(&runID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return runID, err
+}
+
+// cycleJobs describes the jobs of one cycle, in scan order
+type cycleJobs struct {
+	IDs       []int64
+	Variants  []string
+	Deadline  time.Time
+	Pending   map[string][]string
+	StartedAt time.Time
+}
+
+// CycleJobs loads the jobs enqueued for a run
+func (q *jobQueue) CycleJobs(runID string) (*cycleJobs, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueQueryTimeout)
+	defer cancel()
+
+	rows, err := q.db.QueryContext(ctx,
+		`SELECT id, variant, deadline, priority_images, enqueued_at FROM scan_jobs WHERE run_id = $1 ORDER BY id`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := &cycleJobs{Pending: make(map[string][]string)}
+	for rows.Next() {
+		var (
+			id       int64
+			variant  string
+			deadline sql.NullTime
+			prio     []byte
+			enqueued time.Time
+		)
+		if err := rows.Scan(&id, &variant, &deadline, &prio, &enqueued); err != nil {
+			return nil, err
+		}
+		var pending []string
+		if len(prio) > 0 {
+			if err := json.Unmarshal(prio, &pending); err != nil {
+				return nil, fmt.Errorf("job %d has invalid priority images: %w", id, err)
+			}
+		}
+		jobs.IDs = append(jobs.IDs, id)
+		jobs.Variants = append(jobs.Variants, variant)
+		jobs.Pending[variant] = pending
+		if deadline.Valid {
+			jobs.Deadline = deadline.Time
+		}
+		if jobs.StartedAt.IsZero() || enqueued.Before(jobs.StartedAt) {
+			jobs.StartedAt = enqueued
+		}
+	}
+	return jobs, rows.Err()
+}
+
+// runCycleJobs records a job per variant in the scan_jobs table and runs them: in this
+// process, or on external workers with QUEUE_MODE=postgres. Persisting the jobs lets a
+// restarted scheduler resume the cycle instead of dropping it.
+func runCycleJobs(cfg *Config, variants []string, runID string, logger *log.Logger, deadline time.Time, pending map[string][]string) []VariantResult {
+	q, err := openJobQueue(cfg.DB)
+	var ids []int64
+	if err == nil {
+		ids, err = q.EnqueueCycle(runID, variants, deadline, pending)
+		if err != nil {
+			q.Close()
+		}
+	}
+
+	if err != nil {
+		if cfg.QueueMode == queuePostgres {
+			logger.Printf("❌ %v", err)
+			return failedVariants(variants, err)
+		}
+		// Scanning doesn't depend on the job table; run the cycle without persisting it
+		if cfg.DB.Backend != backendSQLite {
+			logger.Printf("⚠️  Could not record the cycle's jobs, it will not be resumed after a restart: %v", err)
+		}
+		results := make([]VariantResult, 0, len(variants))
+		for _, variant := range variants {
+			results = append(results, runVariant(cfg, variant, runID, logger, deadline, pending[variant]))
+		}
+		return results
+	}
+	defer q.Close()
+
+	for i, variant := range variants {
+		logger.Printf("[%s] 📨 Enqueued scan job %d", variant, ids[i])
+	}
+	return q.awaitCycle(cfg, runID, logger, ids, variants, deadline, pending)
+}
+
+// EnqueueCycle adds a job for each variant of a cycle in one transaction and returns
+// their IDs in the same order
+func (q *jobQueue) EnqueueCycle(runID string, variants []string, deadline time.Time, pending map[string][]string) ([]int64, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to enqueue the cycle's jobs: %w", err)
+	}
+	defer tx.Rollback()
+
+	var dl sql.NullTime
+	if !deadline.IsZero() {
+		dl = sql.NullTime{Time: deadline, Valid: true}
+	}
+
+	ids := make([]int64, len(variants))
+	for i, variant := range variants {
+		prio, err := json.Marshal(pending[variant])
+		if err != nil {
+			return nil, err
+		}
+		err = tx.QueryRow(
+			`INSERT INTO scan_jobs (run_id, variant, deadline, priority_images) VALUES ($1, $2, $3, $4) RETURNING id`,
+			runID, variant, dl, string(prio)).Scan(&ids[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to enqueue %s: %w", variant, err)
+		}
+	}
+	return ids, tx.Commit()
+}
+
+// awaitCycle runs or waits for the given jobs of a cycle until all of them finished.
+// Jobs still queued when the cycle deadline passes are withdrawn and their images
+// left pending for the next cycle.
+func (q *jobQueue) awaitCycle(cfg *Config, runID string, logger *log.Logger, ids []int64, variants []string, deadline time.Time, pending map[string][]string) []VariantResult {
+	results := make([]VariantResult, len(variants))
+	// Index of each outstanding job's variant in results
+	outstanding := make(map[int64]int)
+	for i, variant := range variants {
+		results[i] = VariantResult{Variant: variant}
+		outstanding[ids[i]] = i
+	}
+	worker := localWorkerPrefix + defaultWorkerName()
+
+	for {
+		// Without external workers the scheduler runs its queued jobs itself, one at a time
+		if cfg.QueueMode != queuePostgres {
+			if job, err := q.Claim(worker, nil, runID); err != nil {
+				logger.Printf("⚠️  %v", err)
+			} else if job != nil {
+				runQueuedJob(cfg, q, worker, job)
+			}
+		}
+
+		if requeued, failed, err := q.RequeueStale(cfg.QueueStaleAfter); err != nil {
+			logger.Printf("⚠️  Could not check for stale jobs: %v", err)
+		} else if requeued+failed > 0 {
+			logger.Printf("⚠️  Workers stopped responding: %d job(s) re-queued, %d failed", requeued, failed)
+		}
+
+		// Workers report their progress with each heartbeat
+		if cfg.QueueMode == queuePostgres {
+			if progress, err := q.RunningProgress(runID); err != nil {
+				logger.Printf("⚠️  Could not read the progress of scan jobs: %v", err)
+			} else {
+				for variant, p := range progress {
+					activity.SetProgress(variant, p)
+				}
+			}
+		}
+
+		for id, i := range outstanding {
+			variant := variants[i]
+			status, result, err := q.Result(id)
+			if err != nil {
+				logger.Printf("⚠️  Could not read scan job %d: %v", id, err)
+				continue
+			}
+			activity.SetVariantStatus(variant, status)
+
+			switch status {
+			case jobSucceeded, jobFailed:
+				if result != nil {
+					results[i] = *result
+				}
+				results[i].Variant = variant
+				if status == jobSucceeded {
+					logger.Printf("[%s] ✅ Scan job %d finished", variant, id)
+				} else {
+					logger.Printf("[%s] ❌ Scan job %d failed: %s", variant, id, results[i].Error)
+				}
+				// New results are published, cached read responses are stale
+				apiCache.Invalidate()
+				delete(outstanding, id)
+			case jobCancelled:
+				logger.Printf("[%s] ❌ Scan job %d was cancelled", variant, id)
+				results[i].Error = "scan job cancelled"
+				delete(outstanding, id)
+			case jobQueued:
+				if deadline.IsZero() || time.Now().Before(deadline) {
+					continue
+				}
+				if cancelled, err := q.Cancel(id); err != nil {
+					logger.Printf("⚠️  Could not withdraw scan job %d: %v", id, err)
+				} else if cancelled {
+					logger.Printf("⏭️  Cycle time budget exhausted, skipping variant %s (job %d withdrawn)", variant, id)
+					results[i] = skippedVariant(cfg, variant, logger, pending[variant])
+					delete(outstanding, id)
+				}
+			}
+		}
+
+		if len(outstanding) == 0 {
+			return results
+		}
+		// Local jobs left in the queue are claimed straight away
+		if cfg.QueueMode == queuePostgres || !q.hasQueued(runID) {
+			time.Sleep(cfg.QueuePollInterval)
+		}
+	}
+}
+
+// hasQueued reports whether a run still has unclaimed jobs
+func (q *jobQueue) hasQueued(runID string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), queueQueryTimeout)
+	defer cancel()
+
+	var queued bool
+	err := q.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM scan_jobs WHERE run_id = $1 AND status = $2)`, runID, jobQueued).Scan(&queued)
+	return err == nil && queued
+}
+
+// ResumeCycle finishes a cycle interrupted by a scheduler restart: jobs that already
+// finished keep their results and the remaining ones are run (or awaited) again
+func ResumeCycle(cfg *Config, runID string) *CycleResult {
+	logger := runLogger(runID)
+	cycle := &CycleResult{RunID: runID, StartedAt: time.Now().UTC()}
+
+	q, err := openJobQueue(cfg.DB)
+	if err != nil {
+		logger.Printf("❌ %v", err)
+		return nil
+	}
+	defer q.Close()
+
+	jobs, err := q.CycleJobs(runID)
+	if err != nil || len(jobs.IDs) == 0 {
+		logger.Printf("❌ Could not load the jobs of run %s: %v", runID, err)
+		return nil
+	}
+	cycle.StartedAt = jobs.StartedAt.UTC()
+
+	logger.Printf("===========================================")
+	logger.Printf("🔁 Resuming interrupted scan cycle started %s", cycle.StartedAt.Format(time.RFC3339))
+	logger.Printf("===========================================")
+
+	activity.StartRun(runID, cycle.StartedAt, jobs.Variants, jobs.Deadline)
+	cycle.span = startCycleSpan(runID, cycle.StartedAt)
+	cycle.span.SetAttr("scan.variants", len(jobs.Variants))
+	cycle.span.SetAttr("scan.resumed", true)
+	results := q.awaitCycle(cfg, runID, logger, jobs.IDs, jobs.Variants, jobs.Deadline, jobs.Pending)
+	finishCycle(cycle, results, logger)
+	return cycle
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter allows at most limit events per client within a sliding window
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+	// pruned is when idle clients were last dropped
+	pruned time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// Allow records an event for the client and reports whether it is within the limit.
+// When the limit is exceeded it also returns how long until the next event is allowed.
+func (l *rateLimiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-l.window)
+
+	recent := l.events[client][:0]
+	for _, t := range l.events[client] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= l.limit {
+		l.events[client] = recent
+		return false, recent[0].Add(l.window).Sub(now)
+	}
+
+	l.events[client] = append(recent, now)
+	l.prune(cutoff)
+	return true, 0
+}
+
+// prune forgets the clients without an event in the window, so the map doesn't grow
+// with every client ever seen. It runs at most once per window.
+func (l *rateLimiter) prune(cutoff time.Time) {
+	if l.pruned.After(cutoff) {
+		return
+	}
+	l.pruned = time.Now()
+	for client, events := range l.events {
+		if len(events) == 0 || !events[len(events)-1].After(cutoff) {
+			delete(l.events, client)
+		}
+	}
+}
+
+// clientID identifies the caller of an HTTP request for rate limiting purposes
+func clientID(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterForgetsIdleClients(t *testing.T) {
+	l := newRateLimiter(1, 20*time.Millisecond)
+	if ok, _ := l.Allow("10.0.0.1"); !ok {
+		t.Fatal("first event refused")
+	}
+	if ok, retry := l.Allow("10.0.0.1"); ok || retry <= 0 {
+		t.Fatalf("second event in the window: allowed=%v retry=%s, want refused with a retry delay", ok, retry)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if ok, _ := l.Allow("10.0.0.2"); !ok {
+		t.Fatal("event of another client refused")
+	}
+	l.mu.Lock()
+	_, idle := l.events["10.0.0.1"]
+	clients := len(l.events)
+	l.mu.Unlock()
+	if idle || clients != 1 {
+		t.Errorf("limiter keeps %d client(s) (idle client kept: %v), want only the active one", clients, idle)
+	}
+	if ok, _ := l.Allow("10.0.0.1"); !ok {
+		t.Error("idle client refused after the window")
+	}
+}
+
+// TestSandboxBusyDoesNotChargeRateLimit refuses a sandbox scan while another one is
+// running without using up the client's allowance
+func TestSandboxBusyDoesNotChargeRateLimit(t *testing.T) {
+	h := newSandboxHandler(SandboxConfig{RateLimit: 1})
+	h.slots <- struct{}{}
+	req := httptest.NewRequest(http.MethodPost, "/sandbox/scan", strings.NewReader(`{"image": "cgr.dev/chainguard/static:latest"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "already running") {
+		t.Fatalf("status %d %s, want 429 for the running scan", w.Code, w.Body)
+	}
+	<-h.slots
+	if ok, _ := h.limiter.Allow(clientID(req)); !ok {
+		t.Error("the refused scan was charged against the rate limit")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LatestSummary is returned by GET /summary
+type LatestSummary struct {
+	GeneratedAt time.Time        `json:"generated_at"`
+	Variants    []*VariantReport `json:"variants"`
+}
+
+// summaryHandler serves GET /summary: the latest report totals of every configured variant
+func summaryHandler(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+
+		summary := LatestSummary{GeneratedAt: time.Now().UTC()}
+		for _, variant := range s.Config().VariantNames() {
+			report, err := buildVariantReport(variant)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			summary.Variants = append(summary.Variants, report)
+		}
+		writeJSON(w, http.StatusOK, summary)
+	}
+}
+
+// findingsHandler serves GET /findings/{variant}: the variant's latest findings with
+// their remediation links, optionally filtered with ?cve=, ?severity=, ?fix_status=
+// and ?links=true
+func findingsHandler(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		variant := strings.TrimPrefix(r.URL.Path, "/findings/")
+		if !s.knownVariant(variant) {
+			writeError(w, http.StatusNotFound, "unknown variant")
+			return
+		}
+
+		findings, err := variantFindings(variant)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		q := r.URL.Query()
+		cve, severity, withLinks := q.Get("cve"), strings.ToUpper(q.Get("severity")), q.Get("links") == "true"
+		fixStatus := q.Get("fix_status")
+		if fixStatus != "" && fixStatus != fixAvailable && fixStatus != fixNone {
+			writeError(w, http.StatusBadRequest, "fix_status must be fix-available or no-fix")
+			return
+		}
+		filtered := make([]Finding, 0, len(findings))
+		for _, f := range findings {
+			if (cve != "" && f.CVE != cve) || (severity != "" && f.Severity != severity) || (withLinks && len(f.Links) == 0) ||
+				(fixStatus != "" && f.FixStatus != fixStatus) {
+				continue
+			}
+			filtered = append(filtered, f)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"variant": variant, "findings": filtered})
+	}
+}
+
+// knownVariant reports whether name is a configured variant
+func (s *Scheduler) knownVariant(name string) bool {
+	for _, v := range s.Config().VariantNames() {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// badgeColors maps the most severe finding to a shields.io style color
+var badgeColors = map[string]string{
+	"CRITICAL": "#e05d44",
+	"HIGH":     "#fe7d37",
+	"MEDIUM":   "#dfb317",
+	"LOW":      "#a4a61d",
+	"":         "#4c1",
+}
+
+// badgeHandler serves GET /badge/{variant}: an SVG badge with the variant's severity counts
+func badgeHandler(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		variant := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/badge/"), ".svg")
+		if !s.knownVariant(variant) {
+			writeError(w, http.StatusNotFound, "unknown variant")
+			return
+		}
+
+		report, err := buildVariantReport(variant)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		worst := ""
+		for _, sev := range severityOrder {
+			if report.Severities[sev] > 0 {
+				worst = sev
+				break
+			}
+		}
+		value := fmt.Sprintf("C:%d H:%d M:%d L:%d", report.Severities["CRITICAL"], report.Severities["HIGH"],
+			report.Severities["MEDIUM"], report.Severities["LOW"])
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Cache-Control", "no-cache")
+		fmt.Fprint(w, renderBadge(variant+" vulnerabilities", value, badgeColors[worst]))
+	}
+}
+
+// renderBadge draws a flat two-part badge, estimating text width at 7px per character
+func renderBadge(label, value, color string) string {
+	lw, vw := 10+7*len(label), 10+7*len(value)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[3]s: %[4]s">`+
+		`<rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[5]d" height="20" fill="%[6]s"/>`+
+		`<g fill="#fff" font-family="Verdana,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="5" y="14">%[3]s</text><text x="%[7]d" y="14">%[4]s</text></g></svg>`,
+		lw+vw, lw, html.EscapeString(label), html.EscapeString(value), vw, color, lw+5)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RegistryAuth supplies the registry credentials a variant's images are pulled with.
+// They are written to a private Docker config that Trivy, Grype and credential
+// helpers read through DOCKER_CONFIG, instead of the ambient auth of the container.
+type RegistryAuth struct {
+	// DockerConfig is a config.json to start from, e.g. a mounted pull secret
+	DockerConfig string `json:"docker_config,omitempty"`
+	// Credentials are username/token pairs whose token is read from the environment
+	Credentials []RegistryCredential `json:"credentials,omitempty"`
+	// CredHelpers maps registries to docker-credential-* helpers, e.g. "ecr-login" or "gcloud"
+	CredHelpers map[string]string `json:"cred_helpers,omitempty"`
+}
+
+// RegistryCredential logs in to a registry with a token kept out of the config file
+type RegistryCredential struct {
+	Registry string `json:"registry"`
+	Username string `json:"username"`
+	// PasswordEnv names the environment variable holding the password or token
+	PasswordEnv string `json:"password_env"`
+}
+
+// VariantRegistryAuth returns the registry credentials of a variant, or nil
+func (c *Config) VariantRegistryAuth(name string) *RegistryAuth {
+	for _, v := range c.Variants {
+		if v.Name == name {
+			return v.RegistryAuth
+		}
+	}
+	return nil
+}
+
+// Validate reports unreadable Docker configs and incomplete credentials
+func (a *RegistryAuth) Validate(variant string) []error {
+	var errs []error
+	if a.DockerConfig != "" {
+		if _, err := readDockerConfig(a.DockerConfig); err != nil {
+			errs = append(errs, fmt.Errorf("variant %q registry_auth.docker_config: %w", variant, err))
+		}
+	}
+	for i, c := range a.Credentials {
+		if c.Registry == "" || c.Username == "" || c.PasswordEnv == "" {
+			errs = append(errs, fmt.Errorf("variant %q registry credential #%d needs a registry, username and password_env", variant, i+1))
+		} else if os.Getenv(c.PasswordEnv) == "" {
+			errs = append(errs, fmt.Errorf("variant %q registry credential for %s: %s is not set", variant, c.Registry, c.PasswordEnv))
+		}
+	}
+	for registry, helper := range a.CredHelpers {
+		if registry == "" || helper == "" {
+			errs = append(errs, fmt.Errorf("variant %q cred_helpers entries need a registry and a helper", variant))
+		}
+	}
+	return errs
+}
+
+// helpers returns the docker-credential-* executables the credentials rely on
+func (a *RegistryAuth) helpers() []string {
+	seen := make(map[string]bool)
+	var helpers []string
+	for _, h := range a.CredHelpers {
+		if name := "docker-credential-" + h; !seen[name] {
+			seen[name] = true
+			helpers = append(helpers, name)
+		}
+	}
+	sort.Strings(helpers)
+	return helpers
+}
+
+func readDockerConfig(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := make(map[string]any)
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return config, nil
+}
+
+// writeDockerConfig merges the credentials into the base Docker config and writes
+// it to dir/config.json, readable only by the scheduler
+func (a *RegistryAuth) writeDockerConfig(dir string) error {
+	config := make(map[string]any)
+	if a.DockerConfig != "" {
+		base, err := readDockerConfig(a.DockerConfig)
+		if err != nil {
+			return fmt.Errorf("docker_config: %w", err)
+		}
+		config = base
+	}
+
+	if len(a.Credentials) > 0 {
+		auths, _ := config["auths"].(map[string]any)
+		if auths == nil {
+			auths = make(map[string]any)
+		}
+		for _, c := range a.Credentials {
+			password := os.Getenv(c.PasswordEnv)
+			if password == "" {
+				return fmt.Errorf("%s is not set for %s", c.PasswordEnv, c.Registry)
+			}
+			auths[c.Registry] = map[string]string{"auth": base64.StdEncoding.EncodeToString([]byte(c.Username + ":" + password))}
+		}
+		config["auths"] = auths
+	}
+
+	if len(a.CredHelpers) > 0 {
+		helpers, _ := config["credHelpers"].(map[string]any)
+		if helpers == nil {
+			helpers = make(map[string]any)
+		}
+		for registry, helper := range a.CredHelpers {
+			helpers[registry] = helper
+		}
+		config["credHelpers"] = helpers
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "config.json"), data, 0o600)
+}
+
+// registryAuthEnv prepares the variant's Docker config for a scan, returning the
+// environment pointing the scanners at it and a cleanup removing it afterwards.
+// Variants without registry_auth keep the ambient credentials.
+func (j *ScanJob) registryAuthEnv() ([]string, func(), error) {
+	auth := j.Config.VariantRegistryAuth(j.Variant)
+	if auth == nil {
+		return nil, func() {}, nil
+	}
+	if j.Config.DryRun {
+		var registries []string
+		for _, c := range auth.Credentials {
+			registries = append(registries, c.Registry)
+		}
+		for registry := range auth.CredHelpers {
+			registries = append(registries, registry)
+		}
+		sort.Strings(registries)
+		j.Log.Printf("[%s] 🧪 Would scan with a private Docker config for %s", j.Variant, strings.Join(registries, ", "))
+		return nil, func() {}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "registry-auth-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	if err := auth.writeDockerConfig(dir); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return []string{"DOCKER_CONFIG=" + dir}, cleanup, nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// quarantineDir is where load-to-database.py moves the reports it failed to load,
+// under the variant's reports directory, with their Trivy and Grype inputs
+const quarantineDir = "quarantine"
+
+// loadStateFile is the per-file load state load-to-database.py keeps in a variant's
+// reports directory
+const loadStateFile = ".load-state.json"
+
+// Load states of a report file
+const (
+	loadLoaded      = "loaded"
+	loadQuarantined = "quarantined"
+	loadSuperseded  = "superseded"
+)
+
+// FileLoadState is the outcome of the last load of one report file
+type FileLoadState struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Attempts counts the failed loads since the file last loaded
+	Attempts  int       `json:"attempts"`
+	RunID     string    `json:"run_id,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// QuarantinedReport is a report waiting in quarantine for `scheduler reload`
+type QuarantinedReport struct {
+	File string `json:"file"`
+	FileLoadState
+}
+
+// ReloadRequest is the optional body of POST /reload
+type ReloadRequest struct {
+	// Variants to reload; all of them when empty
+	Variants []string `json:"variants,omitempty"`
+}
+
+// ReloadResult is the outcome of reloading one variant's quarantined reports
+type ReloadResult struct {
+	Variant  string   `json:"variant"`
+	RunID    string   `json:"run_id,omitempty"`
+	Reloaded []string `json:"reloaded,omitempty"`
+	// Superseded reports were dropped: a later cycle scanned their image again
+	Superseded []string            `json:"superseded,omitempty"`
+	Failed     []QuarantinedReport `json:"failed,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// readLoadState returns the load state of a variant's report files, keyed by file name
+func readLoadState(variant string) (map[string]FileLoadState, error) {
+	data, err := os.ReadFile(filepath.Join(variantDir(variant), loadStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state struct {
+		Files map[string]FileLoadState `json:"files"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", loadStateFile, err)
+	}
+	return state.Files, nil
+}
+
+// quarantinedReports lists the merged reports of a variant waiting in quarantine
+func quarantinedReports(variant string) ([]QuarantinedReport, error) {
+	files, err := filepath.Glob(filepath.Join(variantDir(variant), quarantineDir, "*_scan.json"))
+	if err != nil {
+		return nil, err
+	}
+	state, err := readLoadState(variant)
+	if err != nil {
+		return nil, err
+	}
+	var reports []QuarantinedReport
+	for _, f := range files {
+		name := filepath.Base(f)
+		if strings.HasSuffix(name, "_trivy_scan.json") || strings.HasSuffix(name, "_grype_scan.json") {
+			continue
+		}
+		reports = append(reports, QuarantinedReport{File: name, FileLoadState: state[name]})
+	}
+	return reports, nil
+}
+
+// reloadVariant runs load-to-database.py --reload for a variant's quarantined reports
+func reloadVariant(cfg *Config, variant string, logger *log.Logger) ReloadResult {
+	result := ReloadResult{Variant: variant}
+	before, err := quarantinedReports(variant)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if len(before) == 0 {
+		return result
+	}
+
+	j := &ScanJob{Variant: variant, Config: cfg, RunID: newRunID(time.Now()), Log: logger}
+	result.RunID = j.RunID
+	if j.Env, j.SecretEnv, err = cfg.VariantEnv(variant); err != nil {
+		result.Error = fmt.Sprintf("variant environment: %v", err)
+		return result
+	}
+	var extra []string
+	for _, p := range cfg.Pipeline {
+		if p.Step == stepPublish {
+			extra = p.PassEnv
+		}
+	}
+	j.passEnv = cfg.passEnv(stepPublish, extra)
+
+	logger.Printf("[%s] 🔁 Reloading %d quarantined report(s)", variant, len(before))
+	cmd := j.loadCommand()
+	cmd.Args = append(cmd.Args, "--reload")
+	loadErr := j.runLogged("load", cmd)
+	if cfg.DryRun {
+		return result
+	}
+
+	state, err := readLoadState(variant)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	after, err := quarantinedReports(variant)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	still := make(map[string]bool, len(after))
+	for _, r := range after {
+		still[r.File] = true
+		result.Failed = append(result.Failed, r)
+	}
+	for _, r := range before {
+		switch {
+		case still[r.File]:
+		case state[r.File].Status == loadSuperseded:
+			result.Superseded = append(result.Superseded, r.File)
+		default:
+			result.Reloaded = append(result.Reloaded, r.File)
+		}
+	}
+	if loadErr != nil && len(result.Failed) == 0 {
+		result.Error = loadErr.Error()
+	}
+	return result
+}
+
+// runReload reloads the quarantined reports of the given variants, or all of them
+func runReload(cfg *Config, variants []string, logger *log.Logger) []ReloadResult {
+	if len(variants) == 0 {
+		variants = cfg.VariantNames()
+	}
+	results := make([]ReloadResult, 0, len(variants))
+	for _, variant := range variants {
+		r := reloadVariant(cfg, variant, logger)
+		switch {
+		case r.Error != "":
+			logger.Printf("[%s] ❌ Reload failed: %s", variant, r.Error)
+		case len(r.Failed) > 0:
+			logger.Printf("[%s] ⚠️  Reloaded %d report(s), %d still quarantined", variant, len(r.Reloaded), len(r.Failed))
+		case len(r.Reloaded) > 0:
+			logger.Printf("[%s] ✅ Reloaded %d report(s)", variant, len(r.Reloaded))
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// reloadCommand implements `scheduler reload`: retry the reports whose database load
+// failed, or list them with --list. Exits 1 when some are still quarantined.
+func reloadCommand(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	var variants variantList
+	fs.Var(&variants, "variant", "variant to reload (repeatable or comma-separated, default: all)")
+	list := fs.Bool("list", false, "only list the quarantined reports")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		return exitUsage
+	}
+	for _, v := range variants {
+		if !slices.Contains(cfg.VariantNames(), v) {
+			fmt.Fprintf(os.Stderr, "❌ Unknown variant %q\n", v)
+			return exitUsage
+		}
+	}
+	if len(variants) == 0 {
+		variants = cfg.VariantNames()
+	}
+
+	if *list {
+		quarantined := make(map[string][]QuarantinedReport)
+		for _, v := range variants {
+			reports, err := quarantinedReports(v)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %s: %v\n", v, err)
+				return exitFailure
+			}
+			quarantined[v] = reports
+		}
+		if *format == "json" {
+			if err := writeIndentedJSON(os.Stdout, quarantined); err != nil {
+				return exitFailure
+			}
+			return exitSuccess
+		}
+		writeQuarantineText(os.Stdout, variants, quarantined)
+		return exitSuccess
+	}
+
+	// Script output goes to stderr so stdout only carries the results
+	stepOutput = os.Stderr
+	results := runReload(cfg, variants, log.New(os.Stderr, "", log.LstdFlags))
+	if *format == "json" {
+		if err := writeIndentedJSON(os.Stdout, results); err != nil {
+			return exitFailure
+		}
+	} else {
+		writeReloadText(os.Stdout, results)
+	}
+	for _, r := range results {
+		if r.Error != "" || len(r.Failed) > 0 {
+			return exitFailure
+		}
+	}
+	return exitSuccess
+}
+
+// writeQuarantineText lists quarantined reports per variant
+func writeQuarantineText(w io.Writer, variants []string, quarantined map[string][]QuarantinedReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VARIANT\tFILE\tATTEMPTS\tERROR")
+	empty := true
+	for _, v := range variants {
+		reports := quarantined[v]
+		sort.Slice(reports, func(i, k int) bool { return reports[i].File < reports[k].File })
+		for _, r := range reports {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", v, r.File, r.Attempts, r.Error)
+			empty = false
+		}
+	}
+	if empty {
+		fmt.Fprintln(w, "No quarantined reports")
+		return
+	}
+	tw.Flush()
+}
+
+// writeReloadText summarizes a reload per variant
+func writeReloadText(w io.Writer, results []ReloadResult) {
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			fmt.Fprintf(w, "%s: failed: %s\n", r.Variant, r.Error)
+		case r.RunID == "":
+			fmt.Fprintf(w, "%s: nothing quarantined\n", r.Variant)
+		default:
+			fmt.Fprintf(w, "%s: %d reloaded, %d superseded, %d still quarantined\n",
+				r.Variant, len(r.Reloaded), len(r.Superseded), len(r.Failed))
+			for _, f := range r.Failed {
+				fmt.Fprintf(w, "  %s: %s\n", f.File, f.Error)
+			}
+		}
+	}
+}
+
+// reloadHandler serves POST /reload: reload the quarantined reports of the listed
+// variants, or all of them, once no cycle is running
+func reloadHandler(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+
+		// The body is optional: no body reloads every variant
+		var req ReloadRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, scanTriggerMaxBodySize)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		for _, v := range req.Variants {
+			if !s.knownVariant(v) {
+				writeError(w, http.StatusNotFound, fmt.Sprintf("unknown variant %q", v))
+				return
+			}
+		}
+		// A cycle's own load would race the reload for the same files
+		unlock, ok := s.tryLockCycle()
+		if !ok {
+			writeError(w, http.StatusConflict, "a scan cycle is running; reload once it has finished")
+			return
+		}
+		defer unlock()
+
+		log.Printf("🔁 Reload of quarantined reports requested by %s", clientID(r))
+		activity.Event("Reload of quarantined reports requested by %s", clientID(r))
+		writeJSON(w, http.StatusOK, runReload(s.Config(), req.Variants, log.Default()))
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseWindow parses a look-back window such as "30d", "12h" or "90m"
+func parseWindow(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+	return d, nil
+}
+
+// VariantSummary aggregates the merged scan reports of a variant
+type VariantSummary struct {
+	Images     int
+	Total      int
+	Severities map[string]int
+	Disputed   int
+	// KnownExploited counts the findings listed in the KEV catalog
+	KnownExploited int
+	// Fixable counts the findings with a fixed version available
+	Fixable int
+}
+
+// mergedReportFiles lists the merged per-image scan files for a variant, excluding
+// the raw Trivy and Grype outputs stored alongside them and the earlier reports of
+// images excluded from the last scan. Images scanned per platform have a report for
+// each platform.
+func mergedReportFiles(variant string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(variantDir(variant), "*_scan.json"))
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := readExcludedImages(variant)
+	if err != nil {
+		return nil, err
+	}
+	excludedFiles := make(map[string]bool, len(excluded))
+	for _, e := range excluded {
+		excludedFiles[imageReportFile(e.Image)] = true
+	}
+
+	files := matches[:0]
+	for _, m := range matches {
+		base := filepath.Base(m)
+		if strings.HasSuffix(base, "_trivy_scan.json") || strings.HasSuffix(base, "_grype_scan.json") || excludedFiles[reportImageFile(base)] {
+			continue
+		}
+		files = append(files, m)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// summarizeVariantReports counts vulnerabilities by severity across a variant's merged reports
+func summarizeVariantReports(variant string) (*VariantSummary, error) {
+	files, err := mergedReportFiles(variant)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &VariantSummary{Severities: make(map[string]int)}
+	for _, f := range files {
+		report, err := readTrivyReport(f)
+		if err != nil {
+			return nil, err
+		}
+		summary.Images++
+		summary.Disputed += report.DisputedCount()
+		summary.KnownExploited += report.KnownExploitedCount()
+		summary.Fixable += report.FixCounts()[fixAvailable]
+		for sev, n := range report.SeverityCounts() {
+			summary.Severities[sev] += n
+			summary.Total += n
+		}
+	}
+	return summary, nil
+}
+
+// ImageReport summarizes the merged scan report of a single image, or of one platform
+// of a multi-arch image
+type ImageReport struct {
+	Image      string         `json:"image"`
+	Platform   string         `json:"platform,omitempty"`
+	Total      int            `json:"total"`
+	Severities map[string]int `json:"severities"`
+	// FixAvailability splits the total into fix-available and no-fix findings
+	FixAvailability map[string]int `json:"fix_availability"`
+}
+
+// VariantReport summarizes every scanned image of a variant
+type VariantReport struct {
+	Variant    string         `json:"variant"`
+	Total      int            `json:"total"`
+	Severities map[string]int `json:"severities"`
+	// FixAvailability splits the total into fix-available and no-fix findings
+	FixAvailability map[string]int `json:"fix_availability"`
+	Images          []ImageReport  `json:"images"`
+	// Excluded lists the images kept out of the last scan by exclusion rules
+	Excluded []ExcludedImage `json:"excluded,omitempty"`
+	// Secrets lists the secrets found by the secrets step (SECRET_SCANNING)
+	Secrets []SecretFinding `json:"secrets,omitempty"`
+	// Misconfigurations lists the failed checks of the variant's manifests (CONFIG_SCAN_PATHS)
+	Misconfigurations []ConfigFinding `json:"misconfigurations,omitempty"`
+	// LicenseViolations lists the packages and files under a LICENSE_DENYLIST license
+	LicenseViolations []LicenseFinding `json:"license_violations,omitempty"`
+}
+
+// buildVariantReport reads a variant's merged reports into a VariantReport
+func buildVariantReport(variant string) (*VariantReport, error) {
+	files, err := mergedReportFiles(variant)
+	if err != nil {
+		return nil, err
+	}
+
+	vr := &VariantReport{Variant: variant, Severities: make(map[string]int), FixAvailability: map[string]int{fixAvailable: 0, fixNone: 0}}
+	for _, f := range files {
+		report, err := readTrivyReport(f)
+		if err != nil {
+			return nil, err
+		}
+
+		img := ImageReport{Image: report.ArtifactName, Platform: report.Platform, Severities: report.SeverityCounts(), FixAvailability: report.FixCounts()}
+		if img.Image == "" {
+			img.Image = strings.TrimSuffix(filepath.Base(f), "_scan.json")
+		}
+		for sev, n := range img.Severities {
+			img.Total += n
+			vr.Severities[sev] += n
+		}
+		vr.Total += img.Total
+		for status, n := range img.FixAvailability {
+			vr.FixAvailability[status] += n
+		}
+		vr.Images = append(vr.Images, img)
+	}
+
+	if vr.Excluded, err = readExcludedImages(variant); err != nil {
+		return nil, err
+	}
+	if vr.Secrets, err = readSecretFindings(variant); err != nil {
+		return nil, err
+	}
+	misconfig, err := readMisconfigReport(variant)
+	if err != nil {
+		return nil, err
+	}
+	if misconfig != nil {
+		vr.Misconfigurations = misconfig.Findings
+	}
+	licenses, err := readLicenseReport(variant)
+	if err != nil {
+		return nil, err
+	}
+	if licenses != nil {
+		vr.LicenseViolations = licenses.LicenseViolations()
+	}
+	return vr, nil
+}
+
+// Finding is a single finding of a variant's latest reports
+type Finding struct {
+	Image            string            `json:"image"`
+	Platform         string            `json:"platform,omitempty"`
+	CVE              string            `json:"cve"`
+	Package          string            `json:"package"`
+	InstalledVersion string            `json:"installed_version"`
+	FixedVersion     string            `json:"fixed_version,omitempty"`
+	Severity         string            `json:"severity"`
+	FixStatus        string            `json:"fix_status"`
+	Disputed         bool              `json:"disputed,omitempty"`
+	Links            []RemediationLink `json:"remediation_links,omitempty"`
+	EPSS             *EPSSScore        `json:"epss,omitempty"`
+	KEV              *KEVEntry         `json:"kev,omitempty"`
+}
+
+// variantFindings lists the findings of a variant's merged reports, most severe first
+// and, within a severity, known exploited then most likely to be exploited first
+func variantFindings(variant string) ([]Finding, error) {
+	files, err := mergedReportFiles(variant)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	for _, f := range files {
+		report, err := readTrivyReport(f)
+		if err != nil {
+			return nil, err
+		}
+		image := report.ArtifactName
+		if image == "" {
+			image = strings.TrimSuffix(filepath.Base(f), "_scan.json")
+		}
+		for _, result := range report.Results {
+			for _, v := range result.Vulnerabilities {
+				findings = append(findings, Finding{
+					Image:            image,
+					Platform:         report.Platform,
+					CVE:              v.VulnerabilityID,
+					Package:          v.PkgName,
+					InstalledVersion: v.InstalledVersion,
+					FixedVersion:     v.FixedVersion,
+					Severity:         v.Severity,
+					FixStatus:        v.FixStatus(),
+					Disputed:         v.Disputed,
+					Links:            v.RemediationLinks,
+					EPSS:             v.EPSS,
+					KEV:              v.KEV,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if ra, rb := severityRank(a.Severity), severityRank(b.Severity); ra != rb {
+			return ra < rb
+		}
+		if (a.KEV != nil) != (b.KEV != nil) {
+			return a.KEV != nil
+		}
+		return a.EPSS.score() > b.EPSS.score()
+	})
+	return findings, nil
+}
+
+// variantCVEs returns the set of vulnerability IDs found across a variant's merged reports
+func variantCVEs(variant string) (map[string]bool, error) {
+	files, err := mergedReportFiles(variant)
+	if err != nil {
+		return nil, err
+	}
+
+	cves := make(map[string]bool)
+	for _, f := range files {
+		report, err := readTrivyReport(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range report.Results {
+			for _, v := range result.Vulnerabilities {
+				cves[v.VulnerabilityID] = true
+			}
+		}
+	}
+	return cves, nil
+}
+
+// SeverityDiff compares the count of a single severity between two variants
+type SeverityDiff struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// DiffReport compares the findings of two variants
+type DiffReport struct {
+	From         string                  `json:"from"`
+	To           string                  `json:"to"`
+	FromTotal    int                     `json:"from_total"`
+	ToTotal      int                     `json:"to_total"`
+	ReductionPct float64                 `json:"reduction_percent"`
+	Severities   map[string]SeverityDiff `json:"severities"`
+	OnlyInFrom   int                     `json:"unique_cves_only_in_from"`
+	OnlyInTo     int                     `json:"unique_cves_only_in_to"`
+	Common       int                     `json:"unique_cves_in_both"`
+	// FromExcluded and ToExcluded list the images exclusion rules kept out of the comparison
+	FromExcluded []string `json:"from_excluded_images,omitempty"`
+	ToExcluded   []string `json:"to_excluded_images,omitempty"`
+	// FromLicenseViolations and ToLicenseViolations count the findings under a denied license
+	FromLicenseViolations int `json:"from_license_violations,omitempty"`
+	ToLicenseViolations   int `json:"to_license_violations,omitempty"`
+}
+
+// buildDiffReport compares the merged reports of two variants
+func buildDiffReport(from, to string) (*DiffReport, error) {
+	fromReport, err := buildVariantReport(from)
+	if err != nil {
+		return nil, err
+	}
+	toReport, err := buildVariantReport(to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &DiffReport{
+		From:         from,
+		To:           to,
+		FromTotal:    fromReport.Total,
+		ToTotal:      toReport.Total,
+		Severities:   make(map[string]SeverityDiff, len(severityOrder)),
+		FromExcluded: excludedImageNames(fromReport.Excluded),
+		ToExcluded:   excludedImageNames(toReport.Excluded),
+
+		FromLicenseViolations: len(fromReport.LicenseViolations),
+		ToLicenseViolations:   len(toReport.LicenseViolations),
+	}
+	for _, sev := range severityOrder {
+		diff.Severities[sev] = SeverityDiff{From: fromReport.Severities[sev], To: toReport.Severities[sev]}
+	}
+	if diff.FromTotal > 0 {
+		diff.ReductionPct = float64(diff.FromTotal-diff.ToTotal) / float64(diff.FromTotal) * 100
+	}
+
+	fromCVEs, err := variantCVEs(from)
+	if err != nil {
+		return nil, err
+	}
+	toCVEs, err := variantCVEs(to)
+	if err != nil {
+		return nil, err
+	}
+	for id := range fromCVEs {
+		if toCVEs[id] {
+			diff.Common++
+		} else {
+			diff.OnlyInFrom++
+		}
+	}
+	for id := range toCVEs {
+		if !fromCVEs[id] {
+			diff.OnlyInTo++
+		}
+	}
+
+	return diff, nil
+}
+
+// rewriteMergedReport calls fn for every vulnerability (and the result it belongs
+// to) in a merged report and writes the file back. Findings are handled as generic maps so fields the
+// scheduler does not know about survive the round trip.
+func rewriteMergedReport(path string, fn func(result, vuln map[string]any)) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var report map[string]any
+	if err := json.Unmarshal(data, &report); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	results, _ := report["Results"].([]any)
+	for _, r := range results {
+		result, _ := r.(map[string]any)
+		vulns, _ := result["Vulnerabilities"].([]any)
+		for _, v := range vulns {
+			if vuln, ok := v.(map[string]any); ok {
+				fn(result, vuln)
+			}
+		}
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// stringField returns a string field of a generic JSON object
+func stringField(obj map[string]any, key string) string {
+	s, _ := obj[key].(string)
+	return s
+}
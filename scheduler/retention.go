@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultRetentionSchedule = "30 4 * * *" // daily at 4:30 AM UTC
+	retentionQueryTimeout    = 10 * time.Minute
+)
+
+// runIDDir matches the per-run directories newRunID names, e.g. the diagnostics bundles
+var runIDDir = regexp.MustCompile(`^\d{8}T\d{6}Z-[0-9a-f]{6}$`)
+
+// prunedScans selects the scans older than the cutoff ($1) that are safe to delete:
+// the latest completed scan of each image, the full loads that newer delta loads build
+// on and the scans holding the details of active findings are kept
+const prunedScans = `SELECT s.id FROM scans s
+	WHERE s.created_at < $1
+	AND s.id NOT IN (
+		SELECT DISTINCT ON (image_id) id FROM scans
+		WHERE scan_status = 'completed'
+		ORDER BY image_id, created_at DESC, id DESC)
+	AND s.id NOT IN (SELECT snapshot_scan_id FROM scans WHERE snapshot_scan_id IS NOT NULL AND created_at >= $1)
+	AND NOT EXISTS (
+		SELECT 1 FROM vulnerability_lifecycle l JOIN vulnerabilities v ON v.id = l.vuln_id
+		WHERE l.status = 'active' AND v.scan_id = s.id)`
+
+// unlinkPrunedScans clears the lifecycle references to the pruned scans, which have no
+// ON DELETE action; the lifecycle entries keep their first/last seen and fixed dates
+const unlinkPrunedScans = `UPDATE vulnerability_lifecycle SET
+		first_seen_scan_id = CASE WHEN first_seen_scan_id IN (SELECT id FROM pruned_scans) THEN NULL ELSE first_seen_scan_id END,
+		last_seen_scan_id = CASE WHEN last_seen_scan_id IN (SELECT id FROM pruned_scans) THEN NULL ELSE last_seen_scan_id END,
+		fixed_in_scan_id = CASE WHEN fixed_in_scan_id IN (SELECT id FROM pruned_scans) THEN NULL ELSE fixed_in_scan_id END
+	WHERE first_seen_scan_id IN (SELECT id FROM pruned_scans)
+		OR last_seen_scan_id IN (SELECT id FROM pruned_scans)
+		OR fixed_in_scan_id IN (SELECT id FROM pruned_scans)`
+
+// retentionStep deletes one kind of row. Cascaded steps only count the rows deleted
+// along with the pruned scans; shared steps only run against public.
+type retentionStep struct {
+	Table    string
+	Shared   bool
+	Cascaded bool
+	Query    string
+}
+
+// retentionSteps run in order in one transaction per schema, once the scans to delete
+// are collected into pruned_scans. Queries take the cutoff as $1.
+var retentionSteps = []retentionStep{
+	{
+		Table: "vulnerability_lifecycle",
+		Query: `DELETE FROM vulnerability_lifecycle WHERE status = 'fixed' AND fixed_date < $1`,
+	},
+	{
+		Table:    "vulnerabilities",
+		Cascaded: true,
+		Query:    `SELECT COUNT(*) FROM vulnerabilities WHERE scan_id IN (SELECT id FROM pruned_scans)`,
+	},
+	{
+		Table:    "scan_comparisons",
+		Cascaded: true,
+		Query: `SELECT COUNT(*) FROM scan_comparisons
+			WHERE previous_scan_id IN (SELECT id FROM pruned_scans) OR current_scan_id IN (SELECT id FROM pruned_scans)`,
+	},
+	{
+		Table: "scans",
+		Query: `DELETE FROM scans WHERE id IN (SELECT id FROM pruned_scans) AND created_at < $1`,
+	},
+	{
+		Table:  "scan_jobs",
+		Shared: true,
+		Query:  `DELETE FROM scan_jobs WHERE finished_at < $1 AND status IN ('succeeded', 'failed', 'cancelled')`,
+	},
+	{
+		Table:  "image_exclusions",
+		Shared: true,
+		Query:  `DELETE FROM image_exclusions WHERE excluded_at < $1`,
+	},
+}
+
+// PrunedRows is the number of rows a pruning run deleted from one table
+type PrunedRows struct {
+	// Tenant names the tenant whose database the schema is in; empty for DB_*
+	Tenant  string `json:"tenant,omitempty"`
+	Schema  string `json:"schema"`
+	Table   string `json:"table"`
+	Deleted int64  `json:"deleted"`
+}
+
+// PrunedFiles is what a pruning run deleted from one location under the reports
+type PrunedFiles struct {
+	Location string `json:"location"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// RetentionReport is the outcome of a pruning run
+type RetentionReport struct {
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Cutoff     time.Time     `json:"cutoff"`
+	DryRun     bool          `json:"dry_run,omitempty"`
+	Rows       []PrunedRows  `json:"rows"`
+	Files      []PrunedFiles `json:"files"`
+	// HistoryEntries is the number of entries dropped from the disagreement history
+	HistoryEntries int      `json:"history_entries,omitempty"`
+	Errors         []string `json:"errors,omitempty"`
+}
+
+// runRetention deletes scan results older than period from the database and the
+// reports. With dryRun the deletions are counted, then rolled back or skipped.
+func runRetention(cfg *Config, period time.Duration, dryRun bool) *RetentionReport {
+	now := time.Now().UTC()
+	report := &RetentionReport{StartedAt: now, Cutoff: now.Add(-period), DryRun: dryRun, Rows: []PrunedRows{}, Files: []PrunedFiles{}}
+	defer func() { report.FinishedAt = time.Now().UTC() }()
+
+	for _, db := range cfg.Databases() {
+		pruneDatabase(db, report)
+	}
+	pruneReportFiles(cfg, report)
+	return report
+}
+
+// empty reports whether the run found nothing to delete
+func (r *RetentionReport) empty() bool {
+	return len(r.Rows) == 0 && len(r.Files) == 0 && r.HistoryEntries == 0
+}
+
+// pruneDatabase prunes the shared tables and each per-variant schema of a database
+func pruneDatabase(tdb TenantDatabase, report *RetentionReport) {
+	// SQLite demo databases are thrown away with the reports; only files are pruned
+	if tdb.DB.Backend == backendSQLite {
+		return
+	}
+	fail := func(err error) {
+		if tdb.Tenant != "" {
+			err = fmt.Errorf("database of tenant %s: %w", tdb.Tenant, err)
+		}
+		report.Errors = append(report.Errors, err.Error())
+	}
+	db, err := sql.Open("postgres", tdb.DB.DSN())
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), retentionQueryTimeout)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		fail(fmt.Errorf("database unavailable: %w", err))
+		return
+	}
+
+	for _, schema := range integritySchemas(ctx, db) {
+		rows, err := pruneSchema(ctx, db, schema, report.Cutoff, report.DryRun)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("pruning %s: %v", qualifiedSchema(tdb.Tenant, schema), err))
+			continue
+		}
+		for i := range rows {
+			rows[i].Tenant = tdb.Tenant
+		}
+		report.Rows = append(report.Rows, rows...)
+	}
+}
+
+// pruneSchema runs the retention steps in one schema, in a transaction that is only
+// committed when every step succeeded and dryRun is off
+func pruneSchema(ctx context.Context, db *sql.DB, schema string, cutoff time.Time, dryRun bool) ([]PrunedRows, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SET LOCAL search_path TO "+pq.QuoteIdentifier(schema)+", public"); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "CREATE TEMP TABLE pruned_scans (id INT PRIMARY KEY) ON COMMIT DROP"); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO pruned_scans "+prunedScans, cutoff); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, unlinkPrunedScans); err != nil {
+		return nil, err
+	}
+
+	var pruned []PrunedRows
+	for _, step := range retentionSteps {
+		if step.Shared && schema != "public" {
+			continue
+		}
+		n, err := runRetentionStep(ctx, tx, step, cutoff)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", step.Table, err)
+		}
+		if n > 0 {
+			pruned = append(pruned, PrunedRows{Schema: schema, Table: step.Table, Deleted: n})
+		}
+	}
+
+	if dryRun {
+		return pruned, nil
+	}
+	return pruned, tx.Commit()
+}
+
+// runRetentionStep returns the number of rows a step deletes
+func runRetentionStep(ctx context.Context, tx *sql.Tx, step retentionStep, cutoff time.Time) (int64, error) {
+	if step.Cascaded {
+		var n int64
+		err := tx.QueryRowContext(ctx, step.Query).Scan(&n)
+		return n, err
+	}
+	res, err := tx.ExecContext(ctx, step.Query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// pruneLocation is a set of per-run files or directories; match filters their names
+type pruneLocation struct {
+	name    string
+	pattern string
+	match   func(name string) bool
+}
+
+// pruneReportFiles deletes the per-run files older than the cutoff: run logs,
+// diagnostics bundles, stored sandbox results, findings exports, executive summaries
+// and file sink exports. The latest reports of each variant are overwritten by every
+// scan and are left alone.
+func pruneReportFiles(cfg *Config, report *RetentionReport) {
+	locations := []pruneLocation{
+		{"logs", filepath.Join(reportsPath, "logs", "*", "*"), nil},
+		{"diagnostics", filepath.Join(reportsPath, "*"), runIDDir.MatchString},
+		{"sandbox", filepath.Join(reportsPath, "sandbox", "*"), nil},
+		{"exports", filepath.Join(reportsPath, "exports", "*"), nil},
+		{"executive summaries", filepath.Join(reportsPath, "executive", "*.html"), nil},
+	}
+	for _, c := range cfg.Sinks {
+		if c.Type == sinkFile {
+			locations = append(locations, pruneLocation{"sink " + c.DisplayName(), filepath.Join(c.Path, "*", "*.json"), nil})
+		}
+	}
+
+	for _, loc := range locations {
+		pruned, err := pruneEntries(loc.pattern, loc.match, report.Cutoff, report.DryRun)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("pruning %s: %v", loc.name, err))
+		}
+		if pruned.Files > 0 {
+			pruned.Location = loc.name
+			report.Files = append(report.Files, pruned)
+		}
+	}
+
+	if n, err := pruneDisagreementHistory(report.Cutoff, report.DryRun); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("pruning %s: %v", disagreementHistoryFile, err))
+	} else {
+		report.HistoryEntries = n
+	}
+}
+
+// pruneEntries removes the files and directories matching pattern that were last
+// modified before cutoff, counting the files and bytes they held
+func pruneEntries(pattern string, match func(string) bool, cutoff time.Time, dryRun bool) (PrunedFiles, error) {
+	var pruned PrunedFiles
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return pruned, err
+	}
+	for _, entry := range matches {
+		info, err := os.Stat(entry)
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if match != nil && !match(filepath.Base(entry)) {
+			continue
+		}
+		filepath.WalkDir(entry, func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				if fi, err := d.Info(); err == nil {
+					pruned.Files++
+					pruned.Bytes += fi.Size()
+				}
+			}
+			return nil
+		})
+		if dryRun {
+			continue
+		}
+		if err := os.RemoveAll(entry); err != nil {
+			return pruned, err
+		}
+	}
+	return pruned, nil
+}
+
+// pruneDisagreementHistory drops the history entries generated before cutoff,
+// returning how many were dropped
+func pruneDisagreementHistory(cutoff time.Time, dryRun bool) (int, error) {
+	file := filepath.Join(reportsPath, disagreementHistoryFile)
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var kept []byte
+	dropped := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var e DisagreementHistoryEntry
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if json.Unmarshal(line, &e) == nil && e.GeneratedAt.Before(cutoff) {
+			dropped++
+			continue
+		}
+		kept = append(kept, line...)
+	}
+	if dropped == 0 || dryRun {
+		return dropped, nil
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, kept, 0o644); err != nil {
+		return 0, err
+	}
+	return dropped, os.Rename(tmp, file)
+}
+
+// logRetentionReport summarizes a pruning run in the daemon log
+func logRetentionReport(report *RetentionReport) {
+	verb := "Pruned"
+	if report.DryRun {
+		verb = "Would prune"
+	}
+	for _, r := range report.Rows {
+		log.Printf("🧹 %s %d row(s) from %s.%s", verb, r.Deleted, qualifiedSchema(r.Tenant, r.Schema), r.Table)
+	}
+	for _, f := range report.Files {
+		log.Printf("🧹 %s %d file(s) (%s) from %s", verb, f.Files, formatBytes(f.Bytes), f.Location)
+	}
+	if report.HistoryEntries > 0 {
+		log.Printf("🧹 %s %d disagreement history entries", verb, report.HistoryEntries)
+	}
+	for _, e := range report.Errors {
+		log.Printf("❌ Retention failed: %s", e)
+	}
+	if report.empty() && len(report.Errors) == 0 {
+		log.Printf("🧹 Nothing older than %s to prune", report.Cutoff.Format(time.RFC3339))
+	}
+}
+
+// formatBytes renders a size with a binary unit, e.g. 12.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// writeRetentionReportText prints a pruning run as a table
+func writeRetentionReportText(w io.Writer, report *RetentionReport) error {
+	verb := "Deleted"
+	if report.DryRun {
+		verb = "Would delete"
+	}
+	fmt.Fprintf(w, "Cutoff: %s\n\n", report.Cutoff.Format(time.RFC3339))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "WHERE\tWHAT\t%s\n", strings.ToUpper(verb))
+	for _, r := range report.Rows {
+		fmt.Fprintf(tw, "%s\t%s\t%d row(s)\n", qualifiedSchema(r.Tenant, r.Schema), r.Table, r.Deleted)
+	}
+	for _, f := range report.Files {
+		fmt.Fprintf(tw, "%s\tfiles\t%d (%s)\n", f.Location, f.Files, formatBytes(f.Bytes))
+	}
+	if report.HistoryEntries > 0 {
+		fmt.Fprintf(tw, "reports\t%s\t%d entries\n", disagreementHistoryFile, report.HistoryEntries)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if report.empty() {
+		fmt.Fprintln(w, "Nothing to prune")
+	}
+	for _, e := range report.Errors {
+		fmt.Fprintf(w, "error: %s\n", e)
+	}
+	return nil
+}
+
+// retentionCommand implements `scheduler retention prune`. Returns 1 when part of
+// the pruning failed.
+func retentionCommand(cfg *Config, args []string) int {
+	if len(args) == 0 || args[0] != "prune" {
+		fmt.Fprintln(os.Stderr, "Usage: scheduler retention prune [--older-than 90d] [--dry-run] [--format text|json]")
+		return exitUsage
+	}
+	fs := flag.NewFlagSet("retention prune", flag.ContinueOnError)
+	olderThan := fs.String("older-than", "", "delete results older than this, e.g. 90d (default: RETENTION_PERIOD)")
+	dryRun := fs.Bool("dry-run", cfg.DryRun, "only count what would be deleted")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	period := cfg.RetentionPeriod
+	if *olderThan != "" {
+		var err error
+		if period, err = parseWindow(*olderThan); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return exitUsage
+		}
+	}
+	if period <= 0 {
+		fmt.Fprintln(os.Stderr, "❌ No retention period: set RETENTION_PERIOD or pass --older-than")
+		return exitUsage
+	}
+
+	report := runRetention(cfg, period, *dryRun)
+
+	var err error
+	switch *format {
+	case "json":
+		err = writeIndentedJSON(os.Stdout, report)
+	case "text":
+		err = writeRetentionReportText(os.Stdout, report)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		return exitUsage
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to write report: %v\n", err)
+		return exitFailure
+	}
+	if len(report.Errors) > 0 {
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+// retentionMetrics tracks pruning runs for GET /metrics
+var retentionMetrics = &retentionMetricsRegistry{runs: make(map[bool]int), rows: make(map[string]int64)}
+
+type retentionMetricsRegistry struct {
+	mu    sync.Mutex
+	runs  map[bool]int
+	rows  map[string]int64 // per table, over every schema
+	files int
+	bytes int64
+	last  *RetentionReport
+}
+
+// Observe records a finished pruning run; dry runs are not counted
+func (m *retentionMetricsRegistry) Observe(report *RetentionReport) {
+	if report.DryRun {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.runs[len(report.Errors) == 0]++
+	for _, r := range report.Rows {
+		m.rows[r.Table] += r.Deleted
+	}
+	for _, f := range report.Files {
+		m.files += f.Files
+		m.bytes += f.Bytes
+	}
+	m.last = report
+}
+
+// WriteText writes the retention metrics in the Prometheus text exposition format
+func (m *retentionMetricsRegistry) WriteText(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.last == nil {
+		return
+	}
+
+	b.WriteString("# HELP scheduler_retention_runs_total Retention pruning runs by outcome.\n")
+	b.WriteString("# TYPE scheduler_retention_runs_total counter\n")
+	fmt.Fprintf(b, "scheduler_retention_runs_total{status=%q} %d\n", "success", m.runs[true])
+	fmt.Fprintf(b, "scheduler_retention_runs_total{status=%q} %d\n", "failure", m.runs[false])
+
+	b.WriteString("# HELP scheduler_retention_rows_deleted_total Rows deleted by retention pruning.\n")
+	b.WriteString("# TYPE scheduler_retention_rows_deleted_total counter\n")
+	tables := make([]string, 0, len(m.rows))
+	for t := range m.rows {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	for _, t := range tables {
+		fmt.Fprintf(b, "scheduler_retention_rows_deleted_total{table=%q} %d\n", t, m.rows[t])
+	}
+
+	b.WriteString("# HELP scheduler_retention_files_deleted_total Report files deleted by retention pruning.\n")
+	b.WriteString("# TYPE scheduler_retention_files_deleted_total counter\n")
+	fmt.Fprintf(b, "scheduler_retention_files_deleted_total %d\n", m.files)
+
+	b.WriteString("# HELP scheduler_retention_bytes_freed_total Bytes of report files deleted by retention pruning.\n")
+	b.WriteString("# TYPE scheduler_retention_bytes_freed_total counter\n")
+	fmt.Fprintf(b, "scheduler_retention_bytes_freed_total %d\n", m.bytes)
+
+	b.WriteString("# HELP scheduler_retention_last_run_timestamp_seconds Completion time of the most recent pruning run.\n")
+	b.WriteString("# TYPE scheduler_retention_last_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(b, "scheduler_retention_last_run_timestamp_seconds %d\n", m.last.FinishedAt.Unix())
+
+	b.WriteString("# HELP scheduler_retention_last_run_duration_seconds Duration of the most recent pruning run.\n")
+	b.WriteString("# TYPE scheduler_retention_last_run_duration_seconds gauge\n")
+	fmt.Fprintf(b, "scheduler_retention_last_run_duration_seconds %g\n", m.last.FinishedAt.Sub(m.last.StartedAt).Seconds())
+}
+
+// describeRetention renders the retention policy for logs, e.g. "90d on 30 4 * * *"
+func describeRetention(cfg *Config) string {
+	if cfg.RetentionPeriod <= 0 {
+		return "off"
+	}
+	return formatPeriod(cfg.RetentionPeriod) + " on " + cfg.RetentionSchedule
+}
+
+// formatPeriod renders whole days the way parseWindow reads them
+func formatPeriod(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
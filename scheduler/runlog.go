@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// newRunID identifies a scan cycle: its start time plus a random suffix so cycles
+// started in the same second (e.g. a CI run next to the daemon) stay distinct
+func newRunID(at time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return at.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// runLogger returns a logger that tags every line with the run ID
+func runLogger(runID string) *log.Logger {
+	return log.New(log.Writer(), "[run "+runID+"] ", log.Flags()|log.Lmsgprefix)
+}
+
+// prefixWriter prepends a prefix to every line written through it
+type prefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	var buf []byte
+	for _, c := range b {
+		if !p.midLine {
+			buf = append(buf, p.prefix...)
+			p.midLine = true
+		}
+		buf = append(buf, c)
+		if c == '\n' {
+			p.midLine = false
+		}
+	}
+	if _, err := p.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// runLogDir is where the step logs of a variant's run are kept
+func runLogDir(variant, runID string) string {
+	return filepath.Join(reportsPath, "logs", variant, runID)
+}
+
+// runLogged runs a pipeline step, tee-ing its output to stdout/stderr (tagged with the
+// run ID) and to a timestamped log file under the job's log directory so it survives restarts.
+// The step runs in its own process group, stopped as a whole when the pipeline step's
+// timeout runs out. Commands built without an environment get the step's.
+// With DRY_RUN the command is only logged.
+func (j *ScanJob) runLogged(step string, cmd *exec.Cmd) error {
+	if cmd.Env == nil {
+		cmd.Env = j.commandEnv()
+	}
+	if j.Config.DryRun {
+		j.logPlannedCommand(step, cmd)
+		return nil
+	}
+
+	var closeLog func()
+	cmd.Stdout, cmd.Stderr, closeLog = j.stepWriters(step)
+	defer closeLog()
+
+	ctx, timeout, cancel := j.stepContext()
+	defer cancel()
+	err := runLimited(ctx, step+" step of "+j.Variant, cmd, j.limits)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%s step timed out after %s", step, timeout)
+	}
+	return err
+}
+
+// stepWriters returns where the output of a step goes: stdout/stderr tagged with the
+// run ID, the live log followers and the step's log file, closed by the returned func
+func (j *ScanJob) stepWriters(step string) (stdout, stderr io.Writer, closeLog func()) {
+	prefix := []byte("[run " + j.RunID + "] ")
+	stdout = &prefixWriter{w: stepOutput, prefix: prefix}
+	stderr = &prefixWriter{w: os.Stderr, prefix: prefix}
+	// Live followers of the run's logs (gRPC StreamLogs)
+	stdout = io.MultiWriter(stdout, stepLogs.Writer(j, step, "stdout"))
+	stderr = io.MultiWriter(stderr, stepLogs.Writer(j, step, "stderr"))
+	closeLog = func() {}
+	if step == "scan" {
+		// Follow the per-image progress for the logs, GET /scheduler/activity and the metrics
+		progress := newScanProgressWriter(j)
+		stdout = io.MultiWriter(stdout, progress)
+		closeLog = progress.Close
+	}
+
+	if j.LogDir != "" {
+		f, err := openStepLog(j.LogDir, step)
+		if err != nil {
+			j.Log.Printf("[%s] ⚠️  Could not capture %s output: %v", j.Variant, step, err)
+		} else {
+			stdout = io.MultiWriter(stdout, f)
+			stderr = io.MultiWriter(stderr, f)
+			closeProgress := closeLog
+			closeLog = func() {
+				closeProgress()
+				f.Close()
+				j.Log.Printf("[%s] %s output saved to %s", j.Variant, step, f.Name())
+			}
+		}
+	}
+	return stdout, stderr, closeLog
+}
+
+// stepContext returns the context of the running step, which ends when its timeout
+// runs out, or a fresh one bounded by STEP_TIMEOUT outside the pipeline
+func (j *ScanJob) stepContext() (context.Context, time.Duration, context.CancelFunc) {
+	if j.stepCtx != nil {
+		return j.stepCtx, j.stepTimeout, func() {}
+	}
+	if timeout := j.Config.StepTimeout; timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		return ctx, timeout, cancel
+	}
+	return context.Background(), 0, func() {}
+}
+
+func openStepLog(dir, step string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%s-%s.log", now.Format("150405"), step)))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(f, "# %s started at %s\n", step, now.Format(time.RFC3339))
+	return f, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sandboxTopFindings = 10
+	sandboxMaxBodySize = 4 << 10
+)
+
+// sandboxEnvAllowlist is the environment the sandbox's Trivy inherits: the basics
+// of a process, Trivy's settings and the registry credential helpers
+var sandboxEnvAllowlist = append(append([]string{"TRIVY_*"}, baseEnvAllowlist...), registryEnvAllowlist...)
+
+// imageRefPattern accepts registry/repository[:tag][@digest] references and
+// rejects anything that could be interpreted as a command-line flag
+var imageRefPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._\-/]*(:[a-zA-Z0-9._\-]+)?(@sha256:[a-f0-9]{64})?$`)
+
+// SandboxConfig controls the ad-hoc "try an image" scan endpoint
+type SandboxConfig struct {
+	RateLimit int           // scans allowed per client per hour
+	Timeout   time.Duration // maximum duration of a single scan
+	Store     bool          // keep raw results under /reports/sandbox
+}
+
+// SandboxRequest is the body accepted by POST /sandbox/scan
+type SandboxRequest struct {
+	Image string `json:"image"`
+}
+
+// SandboxFinding is a single vulnerability included in a sandbox summary
+type SandboxFinding struct {
+	ID           string `json:"id"`
+	Package      string `json:"package"`
+	Version      string `json:"version"`
+	FixedVersion string `json:"fixed_version,omitempty"`
+	Severity     string `json:"severity"`
+}
+
+// SandboxSummary is the one-off scan result returned to the caller
+type SandboxSummary struct {
+	Image       string           `json:"image"`
+	ScannedAt   time.Time        `json:"scanned_at"`
+	DurationSec float64          `json:"duration_seconds"`
+	Total       int              `json:"total"`
+	Severities  map[string]int   `json:"severities"`
+	TopFindings []SandboxFinding `json:"top_findings"`
+	StoredAt    string           `json:"stored_at,omitempty"`
+}
+
+type sandboxHandler struct {
+	cfg     SandboxConfig
+	limiter *rateLimiter
+	slots   chan struct{}
+}
+
+func newSandboxHandler(cfg SandboxConfig) *sandboxHandler {
+	return &sandboxHandler{
+		cfg:     cfg,
+		limiter: newRateLimiter(cfg.RateLimit, time.Hour),
+		// Only one sandbox scan runs at a time so demos can't starve scheduled scans
+		slots: make(chan struct{}, 1),
+	}
+}
+
+// ServeHTTP handles POST /sandbox/scan
+func (h *sandboxHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	var req SandboxRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, sandboxMaxBodySize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	req.Image = strings.TrimSpace(req.Image)
+	if len(req.Image) > 255 || !imageRefPattern.MatchString(req.Image) {
+		writeError(w, http.StatusBadRequest, "invalid image reference")
+		return
+	}
+
+	// A scan refused because another one is running doesn't count against the
+	// client's rate limit
+	select {
+	case h.slots <- struct{}{}:
+		defer func() { <-h.slots }()
+	default:
+		writeError(w, http.StatusTooManyRequests, "another sandbox scan is already running")
+		return
+	}
+
+	client := clientID(r)
+	if ok, retry := h.limiter.Allow(client); !ok {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retry.Seconds())+1))
+		writeError(w, http.StatusTooManyRequests, "sandbox scan rate limit exceeded")
+		return
+	}
+
+	log.Printf("[SANDBOX] Scanning %s (requested by %s)...", req.Image, client)
+	summary, err := h.scan(r.Context(), req.Image)
+	if err != nil {
+		log.Printf("[SANDBOX] ❌ Scan of %s failed: %v", req.Image, err)
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	log.Printf("[SANDBOX] ✅ %s: %d vulnerabilities", req.Image, summary.Total)
+
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// scan runs Trivy against the image and summarizes the result
+func (h *sandboxHandler) scan(ctx context.Context, image string) (*SandboxSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	tmp, err := os.MkdirTemp("", "sandbox-scan-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	output := filepath.Join(tmp, "trivy_scan.json")
+
+	start := time.Now()
+	cmd := exec.Command("trivy", "image",
+		"--quiet",
+		"--severity", strings.Join(severityOrder, ","),
+		"--format", "json",
+		"--output", output,
+		image)
+	// Like a scan step, Trivy only inherits the allowlisted environment and runs in
+	// its own process group, stopped as a whole on timeout or shutdown
+	cmd.Env = inheritedEnv(sandboxEnvAllowlist)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := runInGroup(ctx, "sandbox trivy", cmd); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("scan timed out after %s", h.cfg.Timeout)
+		}
+		return nil, fmt.Errorf("trivy failed: %s", strings.TrimSpace(out.String()))
+	}
+
+	report, err := readTrivyReport(output)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &SandboxSummary{
+		Image:       image,
+		ScannedAt:   start.UTC(),
+		DurationSec: time.Since(start).Seconds(),
+		Severities:  report.SeverityCounts(),
+		TopFindings: topFindings(report, sandboxTopFindings),
+	}
+	for _, n := range summary.Severities {
+		summary.Total += n
+	}
+
+	if h.cfg.Store {
+		stored, err := storeSandboxResult(image, start, output)
+		if err != nil {
+			log.Printf("[SANDBOX] ⚠️  Could not store result for %s: %v", image, err)
+		} else {
+			summary.StoredAt = stored
+		}
+	}
+
+	return summary, nil
+}
+
+// topFindings returns up to n findings ordered by severity
+func topFindings(report *TrivyReport, n int) []SandboxFinding {
+	var findings []SandboxFinding
+	for _, result := range report.Results {
+		for _, v := range result.Vulnerabilities {
+			findings = append(findings, SandboxFinding{
+				ID:           v.VulnerabilityID,
+				Package:      v.PkgName,
+				Version:      v.InstalledVersion,
+				FixedVersion: v.FixedVersion,
+				Severity:     v.Severity,
+			})
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityRank(findings[i].Severity) < severityRank(findings[j].Severity)
+	})
+	if len(findings) > n {
+		findings = findings[:n]
+	}
+	return findings
+}
+
+// storeSandboxResult copies the raw Trivy result into the sandbox reports project
+func storeSandboxResult(image string, at time.Time, src string) (string, error) {
+	dir := filepath.Join(reportsPath, "sandbox")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(image)
+	dest := filepath.Join(dir, fmt.Sprintf("%s_%s_trivy_scan.json", name, at.UTC().Format("20060102T150405Z")))
+	if err := os.WriteFile(dest, data, 0o644); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// builtinScanner scans a variant's images with SCAN_ENGINE=builtin: Trivy and Grype
+// run straight from the scheduler and their reports are merged in Go, the way
+// scan-vulnerabilities.sh does it, so no bash, Python or jq is needed
+type builtinScanner struct {
+	j         *ScanJob
+	ctx       context.Context
+	env       []string
+	dir       string
+	platforms []string
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// builtinScan scans the job's images with the built-in engine. Like the script, an
+// image that fails is recorded in .failed-images and the step only fails when every
+// image did.
+func (j *ScanJob) builtinScan(authEnv []string) error {
+	stdout, _, closeLog := j.stepWriters(stepScan)
+	defer closeLog()
+	ctx, timeout, cancel := j.stepContext()
+	defer cancel()
+
+	s := &builtinScanner{
+		j:         j,
+		ctx:       ctx,
+		env:       j.commandEnv(authEnv...),
+		dir:       variantDir(j.Variant),
+		platforms: j.Config.VariantPlatforms(j.Variant),
+		out:       stdout,
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(s.dir, skippedImagesFile))
+	os.Remove(filepath.Join(s.dir, failedImagesFile))
+
+	s.println("==========================================")
+	s.println("Scanning Images for Vulnerabilities (" + j.Variant + ")")
+	s.println("Run ID: " + j.RunID)
+	s.println("==========================================")
+	s.println("")
+	s.println(fmt.Sprintf("Scanning %d images...", len(j.Images)))
+	if len(s.platforms) > 0 {
+		s.println("Scanning each image for " + strings.Join(s.platforms, " "))
+	}
+	if j.Config.ScanConcurrency > 1 {
+		s.println(fmt.Sprintf("Running up to %d image scans at a time", j.Config.ScanConcurrency))
+	}
+	s.println("")
+
+	slots := make(chan struct{}, j.Config.ScanConcurrency)
+	var wg sync.WaitGroup
+	started := 0
+	for _, image := range j.Images {
+		if slices.Contains(j.Unchanged, image) && s.hasReports(image) {
+			s.println("♻️  " + image + " is unchanged since its last scan, keeping its report")
+			continue
+		}
+		// Wait for a free slot, so the deadline is checked when the image would start
+		slots <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		if !j.Deadline.IsZero() && !time.Now().Before(j.Deadline) {
+			s.println("⏭️  Cycle time budget exhausted, skipping " + image)
+			s.record(skippedImagesFile, image)
+			<-slots
+			continue
+		}
+		started++
+		wg.Add(1)
+		go func(image string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			s.scanImage(image)
+		}(image)
+	}
+	wg.Wait()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s step timed out after %s", stepScan, timeout)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	failed, err := readFailedImages(j.Variant)
+	if err != nil {
+		return err
+	}
+	s.println("==========================================")
+	if len(failed) > 0 {
+		s.println(fmt.Sprintf("⚠️  Vulnerability Scanning Complete, %d of %d image(s) failed", len(failed), started))
+	} else {
+		s.println("✅ Vulnerability Scanning Complete!")
+	}
+	s.println("==========================================")
+	s.println("")
+	s.println("📊 Reports available in: " + s.dir + "/")
+	s.println("")
+	s.println("Summary of all images:")
+	for _, image := range j.Images {
+		if slices.ContainsFunc(failed, func(f FailedImage) bool { return f.Image == image }) {
+			s.println("  " + image + ": failed")
+			continue
+		}
+		for _, platform := range s.reportPlatforms() {
+			report, err := readTrivyReport(filepath.Join(s.dir, platformReportFile(image, platform)))
+			if err != nil {
+				continue
+			}
+			name := image
+			if report.Platform != "" {
+				name += " (" + report.Platform + ")"
+			}
+			s.println("  " + name + ": " + describeSeverityCounts(report.SeverityCounts()))
+		}
+	}
+	for _, image := range j.Excluded {
+		s.println("  " + image + ": excluded")
+	}
+
+	// Failed images are reported by the scheduler; the scan only fails when none succeeded
+	if started > 0 && len(failed) == started {
+		s.println("❌ Every image failed to scan")
+		return errors.New("every image failed to scan")
+	}
+	return nil
+}
+
+// scanImage scans one image, once per platform of the variant
+func (s *builtinScanner) scanImage(image string) {
+	ctx := s.ctx
+	if timeout := s.j.Config.ImageTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Secret and license findings are refreshed by the scheduler's secrets and licenses
+	// steps; drop the previous ones so they aren't reported once a step is turned off
+	os.Remove(filepath.Join(s.dir, strings.TrimSuffix(imageReportFile(image), "_scan.json")+"_secrets.json"))
+	os.Remove(filepath.Join(s.dir, strings.TrimSuffix(imageReportFile(image), "_scan.json")+"_licenses.json"))
+
+	// Images without a Dockerfile of their own are their base image
+	s.println("📦 Base image: " + image)
+
+	total := make(map[string]int)
+	for _, platform := range s.reportPlatforms() {
+		counts, ok := s.scanPlatform(ctx, image, platform)
+		if !ok {
+			return
+		}
+		for sev, n := range counts {
+			total[sev] += n
+		}
+	}
+	s.println("   ✅ Merged " + image + ": " + describeSeverityCounts(total) + "\n")
+}
+
+// scanPlatform scans one platform of an image, or the platform its tag resolves to
+// with an empty platform, and merges the results into its report
+func (s *builtinScanner) scanPlatform(ctx context.Context, image, platform string) (map[string]int, bool) {
+	var platformArgs []string
+	tool := func(name string) string { return name }
+	if platform != "" {
+		platformArgs = []string{"--platform", platform}
+		tool = func(name string) string { return name + " (" + platform + ")" }
+		s.println("   🖥️  Platform " + platform)
+	}
+	report := filepath.Join(s.dir, strings.TrimSuffix(platformReportFile(image, platform), "_scan.json"))
+
+	s.println("🔍 Scanning " + image + " with Trivy...")
+	for _, output := range [][2]string{{"json", report + "_trivy_scan.json"}, {"table", report + "_scan.txt"}} {
+		args := append([]string{"image"}, platformArgs...)
+		args = append(args, "--severity", "CRITICAL,HIGH,MEDIUM,LOW", "--format", output[0], "--output", output[1], image)
+		if err := s.run(ctx, exec.Command("trivy", args...), nil); err != nil {
+			s.fail(ctx, image, tool("trivy"), err)
+			return nil, false
+		}
+	}
+
+	s.println("   🔍 Scanning " + image + " with Grype...")
+	f, err := os.Create(report + "_grype_scan.json")
+	if err != nil {
+		s.fail(ctx, image, tool("grype"), err)
+		return nil, false
+	}
+	err = s.run(ctx, exec.Command("grype", append(append([]string{"-q"}, platformArgs...), image, "-o", "json")...), f)
+	f.Close()
+	if err != nil {
+		s.fail(ctx, image, tool("grype"), err)
+		return nil, false
+	}
+
+	s.println("   🔀 Merging results for " + image + "...")
+	var merged strings.Builder
+	result, err := mergeScanResults(&merged, report+"_trivy_scan.json", report+"_grype_scan.json", report+"_scan.json",
+		image, platform, s.j.Config.SeverityPolicy)
+	s.print(merged.String())
+	if err != nil {
+		s.println("Error: " + err.Error())
+		s.fail(ctx, image, tool("merge"), err)
+		return nil, false
+	}
+	counts := result.severityCounts()
+	if platform != "" {
+		s.println("   ✅ " + platform + ": " + describeSeverityCounts(counts))
+	}
+	return counts, true
+}
+
+// run runs a scanner within the image's context, its output discarded unless stdout is given
+func (s *builtinScanner) run(ctx context.Context, cmd *exec.Cmd, stdout io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cmd.Env = s.env
+	cmd.Stdout = stdout
+	return runLimited(ctx, cmd.Args[0]+" of "+s.j.Variant, cmd, s.j.limits)
+}
+
+// fail records a failed image and removes its reports, those of every platform
+// included, so an outdated or partial report isn't loaded as the image's current result
+func (s *builtinScanner) fail(ctx context.Context, image, step string, err error) {
+	if s.ctx.Err() != nil {
+		// The whole step ran out of time or the scheduler is stopping
+		return
+	}
+	reason := fmt.Sprintf("%s failed (%v)", step, err)
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		reason = fmt.Sprintf("timed out after %ds during %s", int(s.j.Config.ImageTimeout.Seconds()), step)
+	case errors.As(err, &exitErr):
+		reason = fmt.Sprintf("%s failed (exit %d)", step, exitErr.ExitCode())
+	}
+	s.println("❌ Failed to scan " + image + ": " + reason)
+	s.record(failedImagesFile, image+"\t"+reason)
+
+	name := strings.TrimSuffix(imageReportFile(image), "_scan.json")
+	for _, platform := range s.reportPlatforms() {
+		report := filepath.Join(s.dir, strings.TrimSuffix(platformReportFile(image, platform), "_scan.json"))
+		for _, suffix := range []string{"_trivy_scan.json", "_grype_scan.json", "_scan.json", "_scan.txt"} {
+			os.Remove(report + suffix)
+		}
+	}
+	os.Remove(filepath.Join(s.dir, name+"_secrets.json"))
+	os.Remove(filepath.Join(s.dir, name+"_licenses.json"))
+}
+
+// reportPlatforms lists the platforms an image gets a report for, a single empty one
+// for images scanned without platforms
+func (s *builtinScanner) reportPlatforms() []string {
+	if len(s.platforms) == 0 {
+		return []string{""}
+	}
+	return s.platforms
+}
+
+// hasReports tells whether every report of an image is on disk
+func (s *builtinScanner) hasReports(image string) bool {
+	for _, platform := range s.reportPlatforms() {
+		if _, err := os.Stat(filepath.Join(s.dir, platformReportFile(image, platform))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// record appends a line to one of the variant's image lists
+func (s *builtinScanner) record(file, line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(filepath.Join(s.dir, file), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		s.j.Log.Printf("[%s] ⚠️  Could not record %s in %s: %v", s.j.Variant, line, file, err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// print writes output of concurrent image scans a whole chunk at a time
+func (s *builtinScanner) print(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	io.WriteString(s.out, text)
+}
+
+func (s *builtinScanner) println(line string) { s.print(line + "\n") }
+
+// describeSeverityCounts formats counts like the scan script's summary lines
+func describeSeverityCounts(counts map[string]int) string {
+	c, h, m, l := counts["CRITICAL"], counts["HIGH"], counts["MEDIUM"], counts["LOW"]
+	return fmt.Sprintf("%d vulnerabilities (C:%d H:%d M:%d L:%d)", c+h+m+l, c, h, m, l)
+}
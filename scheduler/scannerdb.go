@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// scannerDBsFile records the scanner databases of a variant's run in its log directory
+const scannerDBsFile = "scanner-dbs.json"
+
+// ScannerDB is the vulnerability database a scanner used, so results can be
+// reproduced and compared across runs
+type ScannerDB struct {
+	ScannerVersion string `json:"scanner_version,omitempty"`
+	// Schema is the database schema version
+	Schema string `json:"schema,omitempty"`
+	// BuiltAt is when the database was published
+	BuiltAt time.Time `json:"built_at"`
+}
+
+// Age is how long ago the database was published
+func (db ScannerDB) Age() time.Duration {
+	return time.Since(db.BuiltAt)
+}
+
+// scannerDBReaders read the installed database of each scanner
+var scannerDBReaders = map[string]func() (ScannerDB, error){
+	"trivy": readTrivyDB,
+	"grype": readGrypeDB,
+}
+
+// readTrivyDB reads `trivy version`, which reports the database next to the scanner
+func readTrivyDB() (ScannerDB, error) {
+	out, err := exec.Command("trivy", "version", "--format", "json").Output()
+	if err != nil {
+		return ScannerDB{}, fmt.Errorf("trivy version: %w", err)
+	}
+	var v struct {
+		Version         string
+		VulnerabilityDB *struct {
+			Version   int
+			UpdatedAt time.Time
+		}
+	}
+	if err := json.Unmarshal(out, &v); err != nil {
+		return ScannerDB{}, fmt.Errorf("trivy version: %w", err)
+	}
+	if v.VulnerabilityDB == nil {
+		return ScannerDB{}, errors.New("no Trivy database downloaded")
+	}
+	return ScannerDB{ScannerVersion: v.Version, Schema: fmt.Sprint(v.VulnerabilityDB.Version), BuiltAt: v.VulnerabilityDB.UpdatedAt.UTC()}, nil
+}
+
+// readGrypeDB reads `grype db status`, as JSON where supported and as the
+// "Built:"/"Schema:" lines of older releases otherwise
+func readGrypeDB() (ScannerDB, error) {
+	var db ScannerDB
+	if out, err := exec.Command("grype", "version", "-o", "json").Output(); err == nil {
+		var v struct {
+			Version string `json:"version"`
+		}
+		if json.Unmarshal(out, &v) == nil {
+			db.ScannerVersion = v.Version
+		}
+	}
+
+	fields := make(map[string]string)
+	if out, err := exec.Command("grype", "db", "status", "-o", "json").Output(); err == nil {
+		var status map[string]any
+		if json.Unmarshal(out, &status) == nil {
+			for k, v := range status {
+				fields[strings.ToLower(k)] = fmt.Sprint(v)
+			}
+		}
+	}
+	if fields["built"] == "" {
+		out, err := exec.Command("grype", "db", "status").Output()
+		if err != nil {
+			return ScannerDB{}, fmt.Errorf("grype db status: %w", err)
+		}
+		for _, line := range splitLines(out) {
+			if k, v, ok := strings.Cut(line, ":"); ok {
+				fields[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+			}
+		}
+	}
+
+	db.Schema = fields["schemaversion"]
+	if db.Schema == "" {
+		db.Schema = fields["schema"]
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05 -0700 MST"} {
+		if t, err := time.Parse(layout, fields["built"]); err == nil {
+			db.BuiltAt = t.UTC()
+			return db, nil
+		}
+	}
+	return ScannerDB{}, errors.New("no Grype database downloaded")
+}
+
+// ensureScannerDBs reads the scanner databases and updates those older than
+// SCANNER_DB_MAX_AGE, or missing, before a scan. A failed update is logged and the
+// scan goes ahead with the database it has; the versions actually used are returned.
+func (j *ScanJob) ensureScannerDBs() map[string]ScannerDB {
+	maxAge := j.Config.ScannerDBMaxAge
+	dbs := make(map[string]ScannerDB)
+	for _, scanner := range sortedKeys(scannerDBReaders) {
+		read := scannerDBReaders[scanner]
+		db, err := read()
+		if offlineMode {
+			// The databases are whatever was copied into OFFLINE_DB_PATH
+			if err != nil {
+				j.Log.Printf("[%s] ⚠️  Could not read the offline %s database: %v", j.Variant, scanner, err)
+				continue
+			}
+			if maxAge > 0 && db.Age() > maxAge {
+				j.Log.Printf("[%s] ⚠️  Offline %s database built %s is older than SCANNER_DB_MAX_AGE, refresh OFFLINE_DB_PATH", j.Variant, scanner, db.BuiltAt.Format(time.RFC3339))
+			}
+			dbs[scanner] = db
+			continue
+		}
+		if err == nil && (maxAge <= 0 || db.Age() <= maxAge) {
+			dbs[scanner] = db
+			continue
+		}
+		if err != nil {
+			j.Log.Printf("[%s] 📥 %v, downloading it", j.Variant, err)
+		} else {
+			j.Log.Printf("[%s] 📥 %s database is %s old (SCANNER_DB_MAX_AGE %s), updating it", j.Variant, scanner, db.Age().Round(time.Minute), maxAge)
+		}
+		for _, args := range scannerDBCommands[scanner] {
+			if err := runLoggingLines("db update "+scanner, args); err != nil {
+				j.Log.Printf("[%s] ⚠️  Could not update the %s database: %v", j.Variant, scanner, err)
+				break
+			}
+		}
+		if db, err = read(); err != nil {
+			j.Log.Printf("[%s] ⚠️  Could not read the %s database: %v", j.Variant, scanner, err)
+			continue
+		}
+		if maxAge > 0 && db.Age() > maxAge {
+			j.Log.Printf("[%s] ⚠️  Scanning with a stale %s database built %s", j.Variant, scanner, db.BuiltAt.Format(time.RFC3339))
+		}
+		dbs[scanner] = db
+	}
+
+	j.Log.Printf("[%s] 🗃️  Scanner databases: %s", j.Variant, describeScannerDBs(dbs))
+	if j.LogDir != "" {
+		err := os.MkdirAll(j.LogDir, 0o755)
+		if err == nil {
+			err = writeJSONFile(filepath.Join(j.LogDir, scannerDBsFile), dbs)
+		}
+		if err != nil {
+			j.Log.Printf("⚠️  Could not record the scanner databases of %s: %v", j.Variant, err)
+		}
+	}
+	return dbs
+}
+
+// describeScannerDBs renders the databases for logs, e.g. "grype v6.0.2 built 2026-10-14T04:12:00Z"
+func describeScannerDBs(dbs map[string]ScannerDB) string {
+	if len(dbs) == 0 {
+		return "unknown"
+	}
+	parts := make([]string, 0, len(dbs))
+	for _, scanner := range sortedKeys(dbs) {
+		db := dbs[scanner]
+		parts = append(parts, fmt.Sprintf("%s %s built %s", scanner, db.Schema, db.BuiltAt.Format(time.RFC3339)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// scannerDBFingerprint identifies the exact scanners and databases of a scan, or
+// returns "" when either scanner's database is unknown
+func scannerDBFingerprint(dbs map[string]ScannerDB) string {
+	if len(dbs) < len(scannerDBReaders) {
+		return ""
+	}
+	fingerprint := describeScannerDBs(dbs)
+	for _, scanner := range sortedKeys(dbs) {
+		if v := dbs[scanner].ScannerVersion; v != "" {
+			fingerprint += ", " + scanner + " " + v
+		}
+	}
+	return fingerprint
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// scannerVersionsFile records, per variant, the scanner versions of its last scan
+const scannerVersionsFile = ".scanner-versions.json"
+
+// scannerVersionReaders read the installed version of each scanner, for scanners
+// whose database couldn't be read
+var scannerVersionReaders = map[string]func() (string, error){
+	"trivy": readTrivyVersion,
+	"grype": readGrypeVersion,
+}
+
+// ScannerVersion is the version of a scanner a variant was last scanned with
+type ScannerVersion struct {
+	Version   string    `json:"version"`
+	RunID     string    `json:"run_id"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+// ScannerVersionChange is a scanner upgraded or downgraded since the variant's
+// previous scan: findings may come and go without any change to the images
+type ScannerVersionChange struct {
+	Scanner       string `json:"scanner"`
+	Previous      string `json:"previous"`
+	Current       string `json:"current"`
+	PreviousRunID string `json:"previous_run_id,omitempty"`
+}
+
+func (c ScannerVersionChange) String() string {
+	return fmt.Sprintf("%s %s → %s", c.Scanner, c.Previous, c.Current)
+}
+
+func readTrivyVersion() (string, error) {
+	out, err := exec.Command("trivy", "version", "--format", "json").Output()
+	if err != nil {
+		return "", fmt.Errorf("trivy version: %w", err)
+	}
+	var v struct{ Version string }
+	if err := json.Unmarshal(out, &v); err != nil || v.Version == "" {
+		return "", errors.New("trivy version: no version reported")
+	}
+	return v.Version, nil
+}
+
+func readGrypeVersion() (string, error) {
+	out, err := exec.Command("grype", "version", "-o", "json").Output()
+	if err != nil {
+		return "", fmt.Errorf("grype version: %w", err)
+	}
+	var v struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(out, &v); err != nil || v.Version == "" {
+		return "", errors.New("grype version: no version reported")
+	}
+	return v.Version, nil
+}
+
+// scannerVersions returns the version of each scanner, taken from its database
+// where it was read and from the scanner itself otherwise
+func (j *ScanJob) scannerVersions() map[string]string {
+	versions := make(map[string]string)
+	for _, scanner := range sortedKeys(scannerVersionReaders) {
+		if v := j.ScannerDBs[scanner].ScannerVersion; v != "" {
+			versions[scanner] = strings.TrimPrefix(v, "v")
+			continue
+		}
+		v, err := scannerVersionReaders[scanner]()
+		if err != nil {
+			j.Log.Printf("[%s] ⚠️  Could not read the %s version: %v", j.Variant, scanner, err)
+			continue
+		}
+		versions[scanner] = strings.TrimPrefix(v, "v")
+	}
+	return versions
+}
+
+// readScannerVersions returns the scanner versions of a variant's last scan
+func readScannerVersions(variant string) (map[string]ScannerVersion, error) {
+	data, err := os.ReadFile(filepath.Join(variantDir(variant), scannerVersionsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]ScannerVersion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]ScannerVersion)
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", scannerVersionsFile, err)
+	}
+	return versions, nil
+}
+
+// scannerVersionChanges compares the scanner versions of this scan to those of the
+// variant's last one and warns about each that changed, since finding counts are
+// then not comparable between the two runs
+func (j *ScanJob) scannerVersionChanges(versions map[string]string) []ScannerVersionChange {
+	previous, err := readScannerVersions(j.Variant)
+	if err != nil {
+		j.Log.Printf("[%s] ⚠️  Could not read the scanner versions of the last scan: %v", j.Variant, err)
+		return nil
+	}
+	var changes []ScannerVersionChange
+	for _, scanner := range sortedKeys(versions) {
+		last, ok := previous[scanner]
+		if !ok || last.Version == versions[scanner] {
+			continue
+		}
+		change := ScannerVersionChange{Scanner: scanner, Previous: last.Version, Current: versions[scanner], PreviousRunID: last.RunID}
+		j.Log.Printf("[%s] ⚠️  %s changed from %s to %s since run %s: finding counts may shift without any image change",
+			j.Variant, scanner, last.Version, versions[scanner], last.RunID)
+		activity.Event("[%s] Scanner version changed: %s", j.Variant, change)
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// recordScannerVersions keeps the scanner versions of a completed scan for the
+// variant's next one to compare against
+func (j *ScanJob) recordScannerVersions(versions map[string]string) error {
+	if len(versions) == 0 {
+		return nil
+	}
+	recorded, err := readScannerVersions(j.Variant)
+	if err != nil {
+		recorded = map[string]ScannerVersion{}
+	}
+	now := time.Now().UTC()
+	for scanner, version := range versions {
+		recorded[scanner] = ScannerVersion{Version: version, RunID: j.RunID, ScannedAt: now}
+	}
+	return writeJSONFile(filepath.Join(variantDir(j.Variant), scannerVersionsFile), recorded)
+}
+
+// describeScannerVersions renders versions for logs, e.g. "grype 0.82.1, trivy 0.56.2"
+func describeScannerVersions(versions map[string]string) string {
+	parts := make([]string, 0, len(versions))
+	for _, scanner := range sortedKeys(versions) {
+		parts = append(parts, scanner+" "+versions[scanner])
+	}
+	return strings.Join(parts, ", ")
+}
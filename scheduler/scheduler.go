@@ -0,0 +1,522 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const configPollInterval = 30 * time.Second
+
+// Scheduler owns the cron instance and the live configuration of the daemon
+type Scheduler struct {
+	mu      sync.RWMutex
+	cfg     *Config
+	cron    *cron.Cron
+	entryID cron.EntryID
+	started bool
+
+	// integrityID is the integrity check entry; zero when INTEGRITY_SCHEDULE=off
+	integrityID cron.EntryID
+	// retentionID is the pruning entry; zero without a RETENTION_PERIOD
+	retentionID cron.EntryID
+	// tenantIDs are the entries of the tenants with a schedule of their own
+	tenantIDs map[string]cron.EntryID
+
+	pauseMu  sync.Mutex
+	paused   bool
+	pausedAt *time.Time
+
+	// cycleMu is held for the whole of each cycle, from the warm-up wait to the
+	// notifications, so cycles of the schedule, tenants, triggers and webhooks never
+	// overlap on the reports and the database
+	cycleMu sync.Mutex
+	// cycleBusy is set while cycleMu is held
+	cycleBusy atomic.Bool
+
+	// warmer gates the first cycles on the scanner database warm-up; nil when disabled
+	warmer *dbWarmer
+	// elector decides which replica runs scheduled cycles; nil when leader election is disabled
+	elector *leaderElector
+	// kube writes cycle outcomes to the custom resources; nil outside the controller mode
+	kube *kubeController
+}
+
+func newScheduler(cfg *Config) *Scheduler {
+	s := &Scheduler{
+		cfg:  cfg,
+		cron: cron.New(cron.WithLogger(cron.VerbosePrintfLogger(log.New(os.Stdout, "cron: ", log.LstdFlags)))),
+	}
+
+	// Stay paused across restarts until an operator resumes scheduling
+	if state, err := loadState(); err != nil {
+		log.Printf("⚠️  Could not load scheduler state: %v", err)
+	} else if state.Paused {
+		s.paused, s.pausedAt = true, state.PausedAt
+	}
+	return s
+}
+
+// Config returns the current configuration snapshot
+func (s *Scheduler) Config() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// Start registers the scan schedule and starts the cron scheduler
+func (s *Scheduler) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := s.cron.AddFunc(s.cfg.Schedule, s.runJitteredCycle)
+	if err != nil {
+		return fmt.Errorf("failed to add cron job: %w", err)
+	}
+	s.entryID = id
+	if err := s.scheduleTenants(s.cfg); err != nil {
+		return err
+	}
+
+	if s.cfg.IntegritySchedule != integrityOff {
+		id, err := s.cron.AddFunc(s.cfg.IntegritySchedule, s.runScheduledIntegrityCheck)
+		if err != nil {
+			return fmt.Errorf("failed to add integrity check job: %w", err)
+		}
+		s.integrityID = id
+	}
+
+	if s.cfg.RetentionPeriod > 0 {
+		id, err := s.cron.AddFunc(s.cfg.RetentionSchedule, s.runScheduledRetention)
+		if err != nil {
+			return fmt.Errorf("failed to add retention job: %w", err)
+		}
+		s.retentionID = id
+	}
+
+	s.started = true
+	s.cron.Start()
+	return nil
+}
+
+// NextRun returns the time of the next scheduled scan
+func (s *Scheduler) NextRun() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if next := s.cron.Entry(s.entryID).Next; !next.IsZero() || !s.started {
+		return next
+	}
+	// The cron scheduler fills in the next run shortly after Start
+	schedule, err := cron.ParseStandard(s.cfg.Schedule)
+	if err != nil {
+		return time.Time{}
+	}
+	return schedule.Next(time.Now())
+}
+
+// UpcomingRuns returns the next n cycles of SCAN_SCHEDULE and of each tenant with a
+// schedule of its own, soonest first, or none while scheduling is paused
+func (s *Scheduler) UpcomingRuns(n int) []UpcomingRun {
+	if s.Paused() {
+		return nil
+	}
+	cfg := s.Config()
+	var runs []UpcomingRun
+	add := func(spec, tenant string, variants []string, next time.Time) {
+		schedule, err := cron.ParseStandard(spec)
+		if err != nil || next.IsZero() {
+			return
+		}
+		for i := 0; i < n; i++ {
+			runs = append(runs, UpcomingRun{Time: next.UTC(), Schedule: spec, Tenant: tenant, Variants: variants})
+			next = schedule.Next(next)
+		}
+	}
+	if variants := cfg.ScheduledVariants(); len(variants) > 0 {
+		add(cfg.Schedule, "", variants, s.NextRun())
+	}
+	tenantNext := s.TenantNextRuns()
+	for _, t := range cfg.Tenants {
+		if t.Schedule != "" {
+			add(t.Schedule, t.Name, t.Variants, tenantNext[t.Name])
+		}
+	}
+	slices.SortStableFunc(runs, func(a, b UpcomingRun) int { return a.Time.Compare(b.Time) })
+	return runs
+}
+
+// Reload re-reads the configuration and reschedules the scan if the schedule changed.
+// The previous configuration stays active if the new one is invalid.
+func (s *Scheduler) Reload() error {
+	cfg, err := loadConfig()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		if err := s.reschedule(cfg); err != nil {
+			return err
+		}
+	}
+
+	s.cfg = cfg
+	setVariantTenants(cfg.Tenants)
+	log.Printf("🔄 Configuration reloaded (variants: %v)", cfg.VariantNames())
+	return nil
+}
+
+// reschedule moves the cron entries to the schedules of cfg. Every new entry is added
+// before any old one is removed, so a failure leaves the current schedules in place
+// and the caller keeps the current configuration. Callers hold s.mu.
+func (s *Scheduler) reschedule(cfg *Config) (err error) {
+	var added []cron.EntryID
+	defer func() {
+		if err != nil {
+			for _, id := range added {
+				s.cron.Remove(id)
+			}
+		}
+	}()
+	add := func(enabled bool, spec string, run func(), what string) (cron.EntryID, error) {
+		if !enabled {
+			return 0, nil
+		}
+		id, err := s.cron.AddFunc(spec, run)
+		if err != nil {
+			return 0, fmt.Errorf("failed to reschedule %s: %w", what, err)
+		}
+		added = append(added, id)
+		return id, nil
+	}
+
+	scheduleChanged := cfg.Schedule != s.cfg.Schedule
+	tenantsChanged := !maps.Equal(tenantSchedules(cfg), tenantSchedules(s.cfg))
+	integrityChanged := cfg.IntegritySchedule != s.cfg.IntegritySchedule
+	retentionChanged := cfg.RetentionSchedule != s.cfg.RetentionSchedule || (cfg.RetentionPeriod > 0) != (s.cfg.RetentionPeriod > 0)
+
+	entryID, err := add(scheduleChanged, cfg.Schedule, s.runJitteredCycle, "the scan cycle")
+	if err != nil {
+		return err
+	}
+	var tenantIDs map[string]cron.EntryID
+	if tenantsChanged {
+		if tenantIDs, err = s.addTenantEntries(cfg); err != nil {
+			return err
+		}
+		for _, id := range tenantIDs {
+			added = append(added, id)
+		}
+	}
+	integrityID, err := add(integrityChanged && cfg.IntegritySchedule != integrityOff, cfg.IntegritySchedule, s.runScheduledIntegrityCheck, "the integrity check")
+	if err != nil {
+		return err
+	}
+	retentionID, err := add(retentionChanged && cfg.RetentionPeriod > 0, cfg.RetentionSchedule, s.runScheduledRetention, "retention pruning")
+	if err != nil {
+		return err
+	}
+
+	// Every entry is in place: drop the old ones
+	if scheduleChanged {
+		s.cron.Remove(s.entryID)
+		s.entryID = entryID
+		log.Printf("🔄 Scan schedule changed: %s -> %s", s.cfg.Schedule, cfg.Schedule)
+	}
+	if tenantsChanged {
+		s.replaceTenantEntries(tenantIDs)
+		log.Printf("🔄 Tenant schedules changed: %s", describeTenantSchedules(cfg))
+	}
+	if integrityChanged {
+		s.cron.Remove(s.integrityID)
+		s.integrityID = integrityID
+		log.Printf("🔄 Integrity check schedule changed: %s -> %s", s.cfg.IntegritySchedule, cfg.IntegritySchedule)
+	}
+	if retentionChanged {
+		s.cron.Remove(s.retentionID)
+		s.retentionID = retentionID
+		log.Printf("🔄 Retention changed: %s -> %s", describeRetention(s.cfg), describeRetention(cfg))
+	}
+	return nil
+}
+
+// WatchConfig reloads the configuration on SIGHUP and whenever the config file changes
+func (s *Scheduler) WatchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	lastMod := configFileModTime(s.Config().ConfigFile)
+	for {
+		select {
+		case <-hup:
+			log.Println("SIGHUP received, reloading configuration...")
+		case <-ticker.C:
+			mod := configFileModTime(s.Config().ConfigFile)
+			if mod.Equal(lastMod) {
+				continue
+			}
+			lastMod = mod
+			log.Println("Config file changed, reloading configuration...")
+		}
+
+		if err := s.Reload(); err != nil {
+			log.Printf("❌ Configuration reload failed, keeping previous configuration:\n%v", err)
+			continue
+		}
+		s.saveStatus(nil)
+	}
+}
+
+func configFileModTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// runJitteredCycle runs a cycle fired by the schedule after a random delay of up to
+// SCAN_JITTER, so schedulers sharing a schedule don't hit the registries at once
+func (s *Scheduler) runJitteredCycle() {
+	s.jitter()
+	s.runScheduledCycle()
+}
+
+// jitter sleeps a random delay of up to SCAN_JITTER before a scheduled cycle
+func (s *Scheduler) jitter() {
+	if jitter := s.Config().ScanJitter; jitter > 0 {
+		delay := time.Duration(rand.Int63n(int64(jitter))).Round(time.Second)
+		log.Printf("🎲 Delaying the scheduled cycle by %s (SCAN_JITTER=%s)", delay, jitter)
+		time.Sleep(delay)
+	}
+}
+
+// runScheduledCycle runs a full cycle over the variants on SCAN_SCHEDULE from the
+// daemon: all of them but those of tenants with a schedule of their own
+func (s *Scheduler) runScheduledCycle() {
+	if s.Paused() {
+		log.Println("⏸️  Scheduling is paused, skipping scan cycle")
+		return
+	}
+	if !s.elector.IsLeader() {
+		log.Println("💤 Standby replica (not the leader), skipping scan cycle")
+		return
+	}
+	cfg := s.Config()
+	variants := cfg.ScheduledVariants()
+	if len(variants) == 0 {
+		log.Println("Every variant belongs to a tenant with its own schedule, skipping the SCAN_SCHEDULE cycle")
+		return
+	}
+
+	defer s.lockCycle()()
+	s.waitForWarmup(cfg)
+	heartbeatCycleStart(cfg.Heartbeat, cfg.DryRun)
+	s.completeCycle(cfg, RunFullScanCycle(cfg, variants, newRunID(time.Now())), true)
+}
+
+// lockCycle takes the cycle lock, waiting for the running cycle to finish, and
+// returns the function that releases it
+func (s *Scheduler) lockCycle() (unlock func()) {
+	if !s.cycleMu.TryLock() {
+		log.Println("⏳ Waiting for the running cycle to finish...")
+		s.cycleMu.Lock()
+	}
+	s.cycleBusy.Store(true)
+	return func() {
+		s.cycleBusy.Store(false)
+		s.cycleMu.Unlock()
+	}
+}
+
+// tryLockCycle takes the cycle lock unless a cycle holds it
+func (s *Scheduler) tryLockCycle() (unlock func(), ok bool) {
+	if !s.cycleMu.TryLock() {
+		return nil, false
+	}
+	s.cycleBusy.Store(true)
+	return func() {
+		s.cycleBusy.Store(false)
+		s.cycleMu.Unlock()
+	}, true
+}
+
+// CycleRunning reports whether a cycle holds the cycle lock
+func (s *Scheduler) CycleRunning() bool {
+	return s.cycleBusy.Load()
+}
+
+// runScheduledIntegrityCheck checks (and with INTEGRITY_REPAIR=true repairs) the
+// consistency of the scan tables from the daemon
+func (s *Scheduler) runScheduledIntegrityCheck() {
+	if s.Paused() {
+		log.Println("⏸️  Scheduling is paused, skipping integrity check")
+		return
+	}
+	if !s.elector.IsLeader() {
+		return
+	}
+	cfg := s.Config()
+	if cfg.DB.Backend == backendSQLite {
+		return
+	}
+	if cfg.DryRun {
+		dryRunNote("would run the database integrity check (repair: %t)", cfg.IntegrityRepair)
+		return
+	}
+
+	log.Printf("🔎 Running database integrity check (repair: %t)...", cfg.IntegrityRepair)
+	report := runIntegrityCheck(cfg, cfg.IntegrityRepair)
+	logIntegrityReport(report)
+	if err := saveIntegrityReport(report); err != nil {
+		log.Printf("⚠️  Could not save integrity report: %v", err)
+	}
+}
+
+// runScheduledRetention prunes results older than RETENTION_PERIOD from the daemon
+func (s *Scheduler) runScheduledRetention() {
+	if s.Paused() {
+		log.Println("⏸️  Scheduling is paused, skipping retention pruning")
+		return
+	}
+	if !s.elector.IsLeader() {
+		return
+	}
+	cfg := s.Config()
+	if cfg.DryRun {
+		dryRunNote("would prune results older than %s", formatPeriod(cfg.RetentionPeriod))
+		return
+	}
+
+	log.Printf("🧹 Pruning results older than %s...", formatPeriod(cfg.RetentionPeriod))
+	report := runRetention(cfg, cfg.RetentionPeriod, false)
+	logRetentionReport(report)
+	retentionMetrics.Observe(report)
+}
+
+// resumeInterruptedCycle finishes the most recent cycle a previous scheduler process
+// left with queued or running jobs, reporting whether there was one
+func (s *Scheduler) resumeInterruptedCycle() bool {
+	if s.Paused() || !s.elector.IsLeader() {
+		return false
+	}
+	cfg := s.Config()
+	if cfg.DryRun {
+		dryRunNote("not resuming interrupted cycles")
+		return false
+	}
+	// Without the job table there is nothing to resume from
+	if cfg.DB.Backend == backendSQLite {
+		return false
+	}
+
+	q, err := openJobQueue(cfg.DB)
+	if err != nil {
+		log.Printf("⚠️  Could not check for interrupted cycles: %v", err)
+		return false
+	}
+	defer q.Close()
+
+	// Scans the previous process ran itself died with it
+	if requeued, failed, err := q.RequeueAbandoned(); err != nil {
+		log.Printf("⚠️  Could not check for interrupted cycles: %v", err)
+		return false
+	} else if requeued+failed > 0 {
+		log.Printf("🔁 %d scan job(s) interrupted by the restart re-queued, %d failed after too many attempts", requeued, failed)
+	}
+
+	runID, err := q.InterruptedRun()
+	if err != nil {
+		log.Printf("⚠️  Could not check for interrupted cycles: %v", err)
+		return false
+	}
+	if runID == "" {
+		return false
+	}
+
+	defer s.lockCycle()()
+	s.waitForWarmup(cfg)
+	heartbeatCycleStart(cfg.Heartbeat, false)
+	cycle := ResumeCycle(cfg, runID)
+	if cycle == nil {
+		return false
+	}
+	s.completeCycle(cfg, cycle, cfg.coversScheduledVariants(cycle.variantNames()))
+	return true
+}
+
+// waitForWarmup gives the scanner database warm-up a chance to finish before scanning
+func (s *Scheduler) waitForWarmup(cfg *Config) {
+	if !s.warmer.Ready() {
+		log.Printf("⏳ Waiting up to %s for the scanner database warm-up to finish...", cfg.ScannerDBWarmupTimeout)
+		if !s.warmer.Wait(cfg.ScannerDBWarmupTimeout) {
+			log.Println("⚠️  Scanner database warm-up is still running, starting the cycle anyway")
+		}
+	}
+}
+
+// completeCycle records, reports and announces a finished cycle. scheduled tells
+// whether the cycle covered every variant of SCAN_SCHEDULE.
+func (s *Scheduler) completeCycle(cfg *Config, cycle *CycleResult, scheduled bool) {
+	if cycle.DryRun {
+		if cfg.Notifications.WebhookURL != "" && (cycle.Status != cycleSuccess || cfg.Notifications.On == notifyAlways) {
+			dryRunNote("would post the cycle result to %s", redactURL(cfg.Notifications.WebhookURL))
+		}
+		if cfg.Email.Enabled() {
+			dryRunNote("would email the cycle summary to %d recipient list(s)", len(cfg.Email.Recipients))
+		}
+		if cfg.Executive.Enabled {
+			dryRunNote("would write the executive summary to %s", filepath.Join(executiveReportDir(), cycle.RunID+".html"))
+		}
+		if len(cfg.ExportFormats) > 0 {
+			dryRunNote("would export the findings as %s", strings.Join(cfg.ExportFormats, " and "))
+		}
+		heartbeatCycleEnd(cfg.Heartbeat, cycle)
+		pushCycleMetrics(cfg.MetricsPush, cycle)
+		return
+	}
+
+	// Only a fully successful cycle over the scheduled variants counts towards
+	// missed-run detection; a tenant's cycle or a targeted rescan leaves the other
+	// images as old as they were
+	if cycle.Success && scheduled {
+		recordSuccessfulRun(time.Now())
+	}
+	if !cycle.Success {
+		cycle.Diagnostics = collectDiagnostics(cfg, cycle.RunID, cycle.Variants)
+	}
+	recordCycleOutcome(cfg.Alerts, cycle)
+	writeExecutiveReport(cfg.Executive, cycle)
+	s.saveStatus(cycle)
+	s.kube.cycleCompleted(cycle)
+
+	exportCycle(cfg.ExportFormats, cycle)
+	notifyCycle(cfg.Notifications, cycle)
+	emailCycle(cfg.Email, cycle)
+	notifyTenants(cfg, cycle)
+	heartbeatCycleEnd(cfg.Heartbeat, cycle)
+	pushCycleMetrics(cfg.MetricsPush, cycle)
+}
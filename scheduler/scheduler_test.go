@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestRescheduleKeepsEntriesOnFailure(t *testing.T) {
+	old := &Config{Schedule: "0 2 * * *", IntegritySchedule: "0 3 * * *"}
+	s := &Scheduler{cfg: old, cron: cron.New()}
+	s.entryID, _ = s.cron.AddFunc(old.Schedule, func() {})
+	s.integrityID, _ = s.cron.AddFunc(old.IntegritySchedule, func() {})
+
+	bad := &Config{
+		Schedule:          "0 4 * * *",
+		IntegritySchedule: "0 5 * * *",
+		Tenants:           []TenantConfig{{Name: "acme", Schedule: "0 6 * * *"}},
+		RetentionSchedule: "not a schedule",
+		RetentionPeriod:   1,
+	}
+	if err := s.reschedule(bad); err == nil {
+		t.Fatal("reschedule accepted an invalid retention schedule")
+	}
+	if got := len(s.cron.Entries()); got != 2 {
+		t.Errorf("%d cron entries after a failed reschedule, want the 2 old ones", got)
+	}
+	if s.cron.Entry(s.entryID).ID == 0 || s.cron.Entry(s.integrityID).ID == 0 || len(s.tenantIDs) != 0 {
+		t.Error("a failed reschedule replaced the old entries")
+	}
+
+	good := *bad
+	good.RetentionSchedule = "0 7 * * *"
+	if err := s.reschedule(&good); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(s.cron.Entries()); got != 4 {
+		t.Errorf("%d cron entries, want the scan, tenant, integrity and retention entries", got)
+	}
+	for name, id := range map[string]cron.EntryID{"scan": s.entryID, "tenant": s.tenantIDs["acme"], "integrity": s.integrityID, "retention": s.retentionID} {
+		if s.cron.Entry(id).ID == 0 {
+			t.Errorf("no %s entry after the reschedule", name)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:generate sh -c "rm -rf scripts && mkdir scripts && cp ../scripts/scan-vulnerabilities.sh ../scripts/merge-scan-results.py ../scripts/load-to-database.py ../scripts/scan-report.schema.json scripts/"
+
+// embeddedScripts are copies of the pipeline scripts in the repository's scripts/
+// directory, refreshed with `go generate` so the binary doesn't need a /scripts volume
+//
+//go:embed scripts
+var embeddedScripts embed.FS
+
+// scriptsPath is the directory the pipeline scripts run from: SCRIPTS_PATH when set,
+// otherwise the embedded scripts extracted by setupScripts
+var scriptsPath = "/scripts"
+
+// setupScripts points scriptsPath at SCRIPTS_PATH, or extracts the embedded scripts to
+// a temporary directory. The returned function removes the extracted copy.
+func setupScripts(cfg *Config) (func(), error) {
+	if cfg.ScriptsPath != "" {
+		scriptsPath = cfg.ScriptsPath
+		return func() {}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "scheduler-scripts-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract embedded scripts: %w", err)
+	}
+	if err := extractScripts(dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to extract embedded scripts: %w", err)
+	}
+	scriptsPath = dir
+	return func() { os.RemoveAll(dir) }, nil
+}
+
+// extractScripts writes the embedded pipeline scripts to dir
+func extractScripts(dir string) error {
+	entries, err := fs.ReadDir(embeddedScripts, "scripts")
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		data, err := embeddedScripts.ReadFile("scripts/" + e.Name())
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(0o644)
+		if strings.HasSuffix(e.Name(), ".sh") {
+			mode = 0o755
+		}
+		if err := os.WriteFile(filepath.Join(dir, e.Name()), data, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
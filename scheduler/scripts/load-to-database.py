@@ -0,0 +1,1459 @@
+#!/usr/bin/env python3
+"""
+Load vulnerability scan results into PostgreSQL (or SQLite) database
+"""
+
+import json
+import sys
+import os
+import subprocess
+import uuid
+import argparse
+import re
+from pathlib import Path
+from datetime import datetime, timezone
+
+# 'postgres', or 'sqlite' to write to a single file at DB_PATH (local demos
+# without a database server)
+DB_BACKEND = os.getenv('DB_BACKEND', 'postgres').lower()
+DB_PATH = os.getenv('DB_PATH', 'vulndb.sqlite')
+
+if DB_BACKEND == 'sqlite':
+    import sqlite3
+
+    class Json:
+        """A JSON column value, stored as text"""
+        def __init__(self, adapted):
+            self.adapted = adapted
+
+    sqlite3.register_adapter(Json, lambda j: json.dumps(j.adapted))
+
+    class UndefinedTable(Exception):
+        """Never raised: the loader creates every SQLite table itself"""
+else:
+    import psycopg2
+    from psycopg2 import sql
+    from psycopg2.extras import Json, execute_values
+    from psycopg2.errors import UndefinedTable
+
+# Database configuration from environment
+DB_CONFIG = {
+    'host': os.getenv('DB_HOST', 'localhost'),
+    'port': int(os.getenv('DB_PORT', '5432')),
+    'database': os.getenv('DB_NAME', 'vulndb'),
+    'user': os.getenv('DB_USER', 'vulnuser'),
+    'password': os.getenv('DB_PASSWORD', 'vulnpass')
+}
+
+# Image variant - can be 'baseline' or 'chainguard'
+IMAGE_VARIANT = os.getenv('IMAGE_VARIANT', 'baseline')
+
+# Scheduler run that produced the reports (ties DB rows back to its logs)
+SCAN_RUN_ID = os.getenv('SCAN_RUN_ID')
+
+# Trivy and Grype databases the reports were scanned with (JSON), kept in scan_metadata
+SCANNER_DBS = json.loads(os.getenv('SCANNER_DBS') or '{}')
+# Scanner versions of the run, e.g. {"trivy": "0.56.2", "grype": "0.82.1"}, set by the scheduler
+SCANNER_VERSIONS = json.loads(os.getenv('SCANNER_VERSIONS') or '{}')
+
+# Store each variant in its own schema (variant_<name>) instead of public
+SCHEMA_PER_VARIANT = os.getenv('DB_SCHEMA_PER_VARIANT', 'false').lower() == 'true'
+
+# 'full' stores every finding of every scan; 'delta' only stores the findings that
+# are new or changed since the previous scan and closes the ones that disappeared
+LOAD_MODE = os.getenv('DB_LOAD_MODE', 'full').lower()
+
+# SQLite version of the tables the loader writes to, created on first use. Columns
+# match the Postgres schema (init.sql) so the loader's queries run on both.
+SQLITE_SCHEMA = """
+CREATE TABLE IF NOT EXISTS images (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_name TEXT NOT NULL,
+    image_tag TEXT NOT NULL,
+    full_name TEXT NOT NULL,
+    image_variant TEXT DEFAULT 'baseline',
+    base_image TEXT,
+    base_image_tag TEXT,
+    created_date TEXT,
+    size_bytes INTEGER,
+    architecture TEXT,
+    os TEXT,
+    os_version TEXT,
+    docker_metadata TEXT,
+    platform TEXT NOT NULL DEFAULT '',
+    first_scanned TEXT DEFAULT CURRENT_TIMESTAMP,
+    last_scanned TEXT DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (image_name, image_tag, image_variant, platform)
+);
+
+CREATE TABLE IF NOT EXISTS scans (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    scan_uuid TEXT UNIQUE NOT NULL DEFAULT (lower(hex(randomblob(16)))),
+    scan_batch_id TEXT,
+    run_id TEXT,
+    image_id INTEGER NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    image_variant TEXT DEFAULT 'baseline',
+    scan_date TEXT DEFAULT CURRENT_TIMESTAMP,
+    trivy_version TEXT,
+    grype_version TEXT,
+    total_vulnerabilities INTEGER DEFAULT 0,
+    critical_count INTEGER DEFAULT 0,
+    high_count INTEGER DEFAULT 0,
+    medium_count INTEGER DEFAULT 0,
+    low_count INTEGER DEFAULT 0,
+    trivy_only_count INTEGER DEFAULT 0,
+    grype_only_count INTEGER DEFAULT 0,
+    both_tools_count INTEGER DEFAULT 0,
+    disputed_count INTEGER DEFAULT 0,
+    kev_count INTEGER DEFAULT 0,
+    fixable_count INTEGER DEFAULT 0,
+    no_fix_count INTEGER DEFAULT 0,
+    scan_duration_seconds INTEGER,
+    scan_status TEXT DEFAULT 'completed',
+    trivy_raw_output TEXT,
+    grype_raw_output TEXT,
+    merged_output TEXT,
+    scan_metadata TEXT,
+    load_mode TEXT DEFAULT 'full',
+    snapshot_scan_id INTEGER,
+    created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+    image_digest TEXT
+);
+
+CREATE TABLE IF NOT EXISTS vulnerabilities (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    vuln_uuid TEXT UNIQUE NOT NULL DEFAULT (lower(hex(randomblob(16)))),
+    scan_id INTEGER NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    image_id INTEGER NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    cve_id TEXT NOT NULL,
+    package_name TEXT NOT NULL,
+    package_version TEXT,
+    package_type TEXT,
+    package_category TEXT DEFAULT 'unknown',
+    package_path TEXT,
+    severity TEXT NOT NULL,
+    title TEXT,
+    description TEXT,
+    fixed_version TEXT,
+    published_date TEXT,
+    modified_date TEXT,
+    found_by TEXT NOT NULL,
+    first_detected TEXT DEFAULT CURRENT_TIMESTAMP,
+    last_detected TEXT DEFAULT CURRENT_TIMESTAMP,
+    remediation TEXT,
+    reference_urls TEXT,
+    cvss_score REAL,
+    cvss_vector TEXT,
+    cvss_v2_score REAL,
+    cvss_v3_score REAL,
+    exploit_available INTEGER DEFAULT 0,
+    patch_available INTEGER,
+    vendor_advisory TEXT,
+    disputed INTEGER DEFAULT 0,
+    dispute_reasons TEXT,
+    remediation_links TEXT,
+    epss_score REAL,
+    epss_percentile REAL,
+    kev TEXT,
+    created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (scan_id, cve_id, package_name, package_version)
+);
+
+CREATE TABLE IF NOT EXISTS vulnerability_lifecycle (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_id INTEGER NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    cve_id TEXT NOT NULL,
+    package_name TEXT NOT NULL,
+    package_version TEXT,
+    first_seen_scan_id INTEGER REFERENCES scans(id),
+    last_seen_scan_id INTEGER REFERENCES scans(id),
+    first_seen_date TEXT NOT NULL,
+    last_seen_date TEXT NOT NULL,
+    status TEXT DEFAULT 'active',
+    fixed_in_scan_id INTEGER REFERENCES scans(id),
+    fixed_date TEXT,
+    days_to_fix INTEGER,
+    vuln_id INTEGER REFERENCES vulnerabilities(id) ON DELETE SET NULL,
+    created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (image_id, cve_id, package_name, package_version)
+);
+
+CREATE TABLE IF NOT EXISTS scan_comparisons (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_id INTEGER NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    previous_scan_id INTEGER NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    current_scan_id INTEGER NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    new_vulnerabilities INTEGER DEFAULT 0,
+    fixed_vulnerabilities INTEGER DEFAULT 0,
+    unchanged_vulnerabilities INTEGER DEFAULT 0,
+    severity_increased INTEGER DEFAULT 0,
+    severity_decreased INTEGER DEFAULT 0,
+    comparison_date TEXT DEFAULT CURRENT_TIMESTAMP,
+    details TEXT,
+    UNIQUE (previous_scan_id, current_scan_id)
+);
+
+CREATE TABLE IF NOT EXISTS variant_catalog (
+    variant TEXT PRIMARY KEY,
+    schema_name TEXT NOT NULL DEFAULT 'public',
+    created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+    last_loaded_at TEXT,
+    last_run_id TEXT
+);
+
+CREATE TABLE IF NOT EXISTS image_exclusions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_variant TEXT NOT NULL,
+    image_ref TEXT NOT NULL,
+    rule TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    expires_at TEXT,
+    run_id TEXT,
+    excluded_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS secret_findings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_variant TEXT NOT NULL,
+    image_ref TEXT NOT NULL,
+    target TEXT NOT NULL,
+    rule_id TEXT NOT NULL,
+    category TEXT,
+    severity TEXT NOT NULL,
+    title TEXT,
+    start_line INTEGER,
+    end_line INTEGER,
+    match_text TEXT,
+    run_id TEXT,
+    found_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS config_findings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_variant TEXT NOT NULL,
+    category TEXT NOT NULL,
+    scanner TEXT NOT NULL,
+    file_path TEXT NOT NULL,
+    check_id TEXT NOT NULL,
+    title TEXT,
+    severity TEXT NOT NULL,
+    message TEXT,
+    resolution TEXT,
+    resource TEXT,
+    start_line INTEGER,
+    run_id TEXT,
+    found_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS license_findings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    image_variant TEXT NOT NULL,
+    image_ref TEXT NOT NULL,
+    package_name TEXT,
+    file_path TEXT,
+    license TEXT NOT NULL,
+    category TEXT,
+    severity TEXT,
+    denied INTEGER NOT NULL DEFAULT 0,
+    run_id TEXT,
+    found_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_scans_image_date ON scans(image_id, scan_date DESC);
+CREATE INDEX IF NOT EXISTS idx_scans_run_id ON scans(run_id);
+CREATE INDEX IF NOT EXISTS idx_vulns_scan ON vulnerabilities(scan_id);
+CREATE INDEX IF NOT EXISTS idx_vulns_cve ON vulnerabilities(cve_id);
+CREATE INDEX IF NOT EXISTS idx_lifecycle_vuln ON vulnerability_lifecycle(vuln_id);
+
+CREATE VIEW IF NOT EXISTS current_vulnerabilities AS
+SELECT i.image_name, i.image_tag, i.image_variant, v.cve_id, v.package_name, v.package_version,
+       v.severity, v.found_by, v.cvss_score, s.scan_date, s.id AS scan_id
+FROM vulnerability_lifecycle l
+JOIN vulnerabilities v ON v.id = l.vuln_id
+JOIN images i ON l.image_id = i.id
+JOIN scans s ON s.id = (SELECT MAX(id) FROM scans WHERE image_id = l.image_id AND scan_status = 'completed')
+WHERE l.status <> 'fixed';
+
+CREATE VIEW IF NOT EXISTS vulnerability_trends AS
+SELECT i.image_name, i.image_tag, i.image_variant, s.scan_batch_id, s.scan_date,
+       s.total_vulnerabilities, s.critical_count, s.high_count, s.medium_count, s.low_count,
+       s.trivy_only_count, s.grype_only_count, s.both_tools_count
+FROM scans s
+JOIN images i ON s.image_id = i.id
+WHERE s.scan_status = 'completed'
+ORDER BY i.image_name, s.scan_date;
+"""
+
+class SQLiteCursor:
+    """Runs the loader's psycopg2-style queries (%s placeholders) on SQLite"""
+    def __init__(self, cur):
+        self.cur = cur
+
+    def execute(self, query, params=()):
+        self.cur.execute(query.replace('%s', '?'), params)
+
+    def fetchone(self):
+        return self.cur.fetchone()
+
+    def fetchall(self):
+        return self.cur.fetchall()
+
+    def close(self):
+        self.cur.close()
+
+class SQLiteConnection:
+    """The subset of a psycopg2 connection the loader uses"""
+    def __init__(self, conn):
+        self.conn = conn
+
+    def cursor(self):
+        return SQLiteCursor(self.conn.cursor())
+
+    def commit(self):
+        self.conn.commit()
+
+    def rollback(self):
+        self.conn.rollback()
+
+    def close(self):
+        self.conn.close()
+
+def open_sqlite(path):
+    """Open (and create if needed) the SQLite database"""
+    conn = sqlite3.connect(path)
+    # Timestamps are stored as UTC text, like CURRENT_TIMESTAMP
+    conn.create_function('NOW', 0, lambda: datetime.now(timezone.utc).strftime('%Y-%m-%d %H:%M:%S'))
+    conn.execute("PRAGMA foreign_keys = ON")
+    conn.executescript(SQLITE_SCHEMA)
+    # Databases created before multi-arch scanning keep their unique key without the
+    # platform, so one platform per image fits in them
+    if 'platform' not in [row[1] for row in conn.execute("PRAGMA table_info(images)")]:
+        conn.execute("ALTER TABLE images ADD COLUMN platform TEXT NOT NULL DEFAULT ''")
+    # Scans loaded before idempotent loads have no digest (NULL) and never match a rerun
+    if 'image_digest' not in [row[1] for row in conn.execute("PRAGMA table_info(scans)")]:
+        conn.execute("ALTER TABLE scans ADD COLUMN image_digest TEXT")
+    conn.execute("CREATE UNIQUE INDEX IF NOT EXISTS idx_scans_run_image_digest ON scans(run_id, image_id, image_digest)")
+    return SQLiteConnection(conn)
+
+def get_db_connection():
+    """Create database connection"""
+    try:
+        if DB_BACKEND == 'sqlite':
+            return open_sqlite(DB_PATH)
+        conn = psycopg2.connect(**DB_CONFIG)
+        return conn
+    except Exception as e:
+        print(f"❌ Database connection failed: {e}")
+        sys.exit(1)
+
+def insert_values(cur, query, rows, template=None, fetch=False):
+    """execute_values on Postgres; one INSERT per row on SQLite, which has no multi-row helper"""
+    if DB_BACKEND != 'sqlite':
+        return execute_values(cur, query, rows, template=template, fetch=fetch)
+    template = template or '(' + ', '.join(['%s'] * len(rows[0])) + ')'
+    query = query.replace('VALUES %s', 'VALUES ' + template)
+    results = []
+    for row in rows:
+        cur.execute(query, row)
+        if fetch:
+            results.extend(cur.fetchall())
+    return results
+
+def shared_table(name):
+    """Qualify a table shared by every variant (per-variant schemas only exist on Postgres)"""
+    return name if DB_BACKEND == 'sqlite' else 'public.' + name
+
+# Days between a lifecycle entry's first sighting and now
+DAYS_SINCE_FIRST_SEEN = (
+    "CAST(julianday(NOW()) - julianday(first_seen_date) AS INTEGER)" if DB_BACKEND == 'sqlite'
+    else "EXTRACT(DAY FROM NOW() - first_seen_date)"
+)
+
+def image_report_file(scan_file):
+    """The merged report file name of a report's image without its platform: reports of
+    images scanned per platform are named like nginx_1.27+linux-arm64_scan.json"""
+    return scan_file.name.replace('_scan.json', '').split('+', 1)[0] + '_scan.json'
+
+def extract_image_metadata(image_full_name, base_image_from_scan=None):
+    """Extract metadata about the image using docker inspect"""
+    try:
+        result = subprocess.run(
+            ['docker', 'inspect', image_full_name],
+            capture_output=True,
+            text=True,
+            check=True
+        )
+        metadata = json.loads(result.stdout)[0]
+
+        # Use base image from scan if provided, otherwise try docker history
+        base_image = None
+        base_image_tag = None
+
+        if base_image_from_scan:
+            if ':' in base_image_from_scan:
+                base_image, base_image_tag = base_image_from_scan.rsplit(':', 1)
+            else:
+                base_image = base_image_from_scan
+                base_image_tag = 'latest'
+        else:
+            # Fallback: Extract base image from docker history
+            # For multi-stage builds, we want the LAST FROM statement (the final runtime stage)
+            history_result = subprocess.run(
+                ['docker', 'history', image_full_name, '--no-trunc', '--format', '{{.CreatedBy}}'],
+                capture_output=True,
+                text=True
+            )
+            last_from_line = None
+            for line in history_result.stdout.split('\n'):
+                if 'FROM' in line:
+                    last_from_line = line
+
+            if last_from_line:
+                parts = last_from_line.split('FROM')
+                if len(parts) > 1:
+                    base_full = parts[1].strip().split()[0]
+                    # Remove AS builder/stage aliases if present
+                    if ' AS ' in base_full.upper():
+                        base_full = base_full.split()[0]
+                    if ':' in base_full:
+                        base_image, base_image_tag = base_full.split(':', 1)
+                    else:
+                        base_image = base_full
+                        base_image_tag = 'latest'
+
+        return {
+            'created_date': metadata['Created'],
+            'size_bytes': metadata['Size'],
+            'architecture': metadata['Architecture'],
+            'os': metadata['Os'],
+            'os_version': metadata.get('OsVersion', ''),
+            'base_image': base_image,
+            'base_image_tag': base_image_tag,
+            'docker_metadata': metadata
+        }
+    except Exception as e:
+        print(f"⚠️  Could not extract metadata for {image_full_name}: {e}")
+        return None
+
+def get_or_create_image(conn, image_name, image_tag, variant, metadata=None, platform=''):
+    """Get or create image record, one per platform of images scanned per platform"""
+    cur = conn.cursor()
+
+    full_name = f"{image_name}:{image_tag}"
+
+    # Check if image exists with this variant
+    cur.execute("""
+        SELECT id FROM images WHERE image_name = %s AND image_tag = %s AND image_variant = %s AND platform = %s
+    """, (image_name, image_tag, variant, platform))
+
+    result = cur.fetchone()
+    if result:
+        image_id = result[0]
+        # Update last_scanned
+        cur.execute("""
+            UPDATE images SET last_scanned = NOW() WHERE id = %s
+        """, (image_id,))
+    else:
+        # Create new image record
+        if metadata:
+            cur.execute("""
+                INSERT INTO images (
+                    image_name, image_tag, full_name, image_variant, base_image, base_image_tag,
+                    created_date, size_bytes, architecture, os, os_version, docker_metadata, platform
+                ) VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s)
+                RETURNING id
+            """, (
+                image_name, image_tag, full_name, variant,
+                metadata.get('base_image'), metadata.get('base_image_tag'),
+                metadata.get('created_date'), metadata.get('size_bytes'),
+                metadata.get('architecture'), metadata.get('os'), metadata.get('os_version'),
+                Json(metadata.get('docker_metadata', {})), platform
+            ))
+        else:
+            cur.execute("""
+                INSERT INTO images (image_name, image_tag, full_name, image_variant, platform)
+                VALUES (%s, %s, %s, %s, %s)
+                RETURNING id
+            """, (image_name, image_tag, full_name, variant, platform))
+
+        image_id = cur.fetchone()[0]
+
+    conn.commit()
+    cur.close()
+    return image_id
+
+def use_variant_schema(conn, variant):
+    """Create the variant's schema if needed and point the session at it"""
+    cur = conn.cursor()
+    cur.execute("SELECT create_variant_schema(%s)", (variant,))
+    schema = cur.fetchone()[0]
+    cur.execute(sql.SQL("SET search_path TO {}, public").format(sql.Identifier(schema)))
+    conn.commit()
+    cur.close()
+    return schema
+
+def register_variant(conn, variant, schema, run_id):
+    """Record the load in the shared variant catalog"""
+    cur = conn.cursor()
+    try:
+        cur.execute(f"""
+            INSERT INTO {shared_table('variant_catalog')} (variant, schema_name, last_loaded_at, last_run_id)
+            VALUES (%s, %s, NOW(), %s)
+            ON CONFLICT (variant) DO UPDATE SET
+                schema_name = EXCLUDED.schema_name,
+                last_loaded_at = EXCLUDED.last_loaded_at,
+                last_run_id = EXCLUDED.last_run_id
+        """, (variant, schema, run_id))
+        conn.commit()
+    except UndefinedTable:
+        # Databases created before the catalog existed (see migrate-add-variant-schemas.sql)
+        conn.rollback()
+        print("⚠️  variant_catalog table not found, skipping catalog update")
+    finally:
+        cur.close()
+
+def record_exclusions(conn, variant, excluded, run_id):
+    """Record the images the scheduler's exclusion rules kept out of this cycle"""
+    cur = conn.cursor()
+    try:
+        insert_values(cur, f"""
+            INSERT INTO {shared_table('image_exclusions')} (image_variant, image_ref, rule, reason, expires_at, run_id)
+            VALUES %s
+        """, [
+            (variant, e['image'], e['rule'], e['reason'], e.get('expires'), run_id)
+            for e in excluded
+        ])
+        conn.commit()
+    except UndefinedTable:
+        # Databases created before exclusions existed (see migrate-add-image-exclusions.sql)
+        conn.rollback()
+        print("⚠️  image_exclusions table not found, not recording excluded images")
+    finally:
+        cur.close()
+
+def record_secret_findings(conn, variant, scan_files, run_id):
+    """Record the secrets found by the scheduler's secrets step in the loaded images"""
+    rows = []
+    # Secrets are scanned once per image, whatever platforms its vulnerabilities were scanned for
+    secrets_files = sorted({scan_file.parent / image_report_file(scan_file).replace('_scan.json', '_secrets.json') for scan_file in scan_files})
+    for secrets_file in secrets_files:
+        if not secrets_file.exists():
+            continue
+        with open(secrets_file) as f:
+            report = json.load(f)
+        image_ref = report.get('ArtifactName') or secrets_file.stem.replace('_secrets', '')
+        for result in report.get('Results') or []:
+            for secret in result.get('Secrets') or []:
+                rows.append((
+                    variant, image_ref, result.get('Target', ''), secret.get('RuleID', ''),
+                    secret.get('Category'), secret.get('Severity', 'UNKNOWN'), secret.get('Title'),
+                    secret.get('StartLine'), secret.get('EndLine'), secret.get('Match'), run_id
+                ))
+    if not rows:
+        return 0
+
+    cur = conn.cursor()
+    try:
+        insert_values(cur, f"""
+            INSERT INTO {shared_table('secret_findings')}
+                (image_variant, image_ref, target, rule_id, category, severity, title, start_line, end_line, match_text, run_id)
+            VALUES %s
+        """, rows)
+        conn.commit()
+    except UndefinedTable:
+        # Databases created before secret scanning existed (see migration 0004)
+        conn.rollback()
+        print("⚠️  secret_findings table not found, not recording secrets")
+        return 0
+    finally:
+        cur.close()
+    return len(rows)
+
+def record_config_findings(conn, variant, reports_dir, run_id):
+    """Record the misconfigurations found by the scheduler's misconfig step this cycle"""
+    report_file = reports_dir / "misconfigurations.json"
+    if not report_file.exists():
+        return 0
+    with open(report_file) as f:
+        report = json.load(f)
+    # The report of an earlier cycle was loaded with that cycle
+    if run_id and report.get('run_id') != run_id:
+        return 0
+    rows = [
+        (variant, m.get('type') or 'unknown', report.get('scanner', ''), m['file'], m['check_id'], m.get('title'),
+         m.get('severity', 'UNKNOWN'), m.get('message'), m.get('resolution'), m.get('resource'), m.get('line'), run_id)
+        for m in report.get('findings') or []
+    ]
+    if not rows:
+        return 0
+
+    cur = conn.cursor()
+    try:
+        insert_values(cur, f"""
+            INSERT INTO {shared_table('config_findings')}
+                (image_variant, category, scanner, file_path, check_id, title, severity, message, resolution, resource, start_line, run_id)
+            VALUES %s
+        """, rows)
+        conn.commit()
+    except UndefinedTable:
+        # Databases created before misconfiguration scanning existed (see migration 0005)
+        conn.rollback()
+        print("⚠️  config_findings table not found, not recording misconfigurations")
+        return 0
+    finally:
+        cur.close()
+    return len(rows)
+
+def record_license_findings(conn, variant, reports_dir, run_id):
+    """Record the licenses found by the scheduler's licenses step this cycle"""
+    report_file = reports_dir / "licenses.json"
+    if not report_file.exists():
+        return 0, 0
+    with open(report_file) as f:
+        report = json.load(f)
+    # The report of an earlier cycle was loaded with that cycle
+    if run_id and report.get('run_id') != run_id:
+        return 0, 0
+    rows = [
+        (variant, l['image'], l.get('package'), l.get('file'), l['license'], l.get('category'),
+         l.get('severity'), bool(l.get('denied')), run_id)
+        for l in report.get('findings') or []
+    ]
+    if not rows:
+        return 0, 0
+
+    cur = conn.cursor()
+    try:
+        insert_values(cur, f"""
+            INSERT INTO {shared_table('license_findings')}
+                (image_variant, image_ref, package_name, file_path, license, category, severity, denied, run_id)
+            VALUES %s
+        """, rows)
+        conn.commit()
+    except UndefinedTable:
+        # Databases created before license scanning existed (see migration 0006)
+        conn.rollback()
+        print("⚠️  license_findings table not found, not recording licenses")
+        return 0, 0
+    finally:
+        cur.close()
+    return len(rows), report.get('violations', 0)
+
+def report_digest(merged_data):
+    """The digest of the image a report was scanned from: its registry digest, else the
+    local image ID, else '' when the scanner recorded neither"""
+    metadata = merged_data.get('Metadata') or {}
+    for repo_digest in metadata.get('RepoDigests') or []:
+        if '@' in repo_digest:
+            return repo_digest.split('@', 1)[1]
+    return metadata.get('ImageID') or ''
+
+# Columns of a scans row that come from its report, refreshed when a run is loaded again
+SCAN_REPORT_COLUMNS = (
+    'scan_batch_id', 'image_variant', 'trivy_version', 'grype_version',
+    'total_vulnerabilities', 'critical_count', 'high_count', 'medium_count', 'low_count',
+    'trivy_only_count', 'grype_only_count', 'both_tools_count', 'disputed_count', 'kev_count',
+    'fixable_count', 'no_fix_count',
+    'trivy_raw_output', 'grype_raw_output', 'merged_output', 'scan_metadata',
+    'scan_status', 'load_mode'
+)
+
+def create_scan_record(conn, image_id, merged_data, trivy_data, grype_data, batch_id, variant, run_id=None, load_mode='full'):
+    """Create the scan record, or update the one an earlier load of the same run and
+    image digest created, so loading a run again doesn't add a second scan.
+
+    Returns the scan's id and uuid, and whether it already existed."""
+    cur = conn.cursor()
+
+    # Tool versions: from the scheduler, else from the reports (newer Trivy releases
+    # and Grype record their version)
+    trivy_version = SCANNER_VERSIONS.get('trivy') or ((trivy_data or {}).get('Trivy') or {}).get('Version')
+    grype_version = SCANNER_VERSIONS.get('grype') or (((grype_data or {}).get('descriptor') or {}).get('version'))
+
+    # Get merge stats
+    merge_stats = merged_data.get('MergeStats', {})
+
+    # Count vulnerabilities by severity, leaving out findings the scheduler
+    # flagged as likely false positives (they are tracked in disputed_count)
+    counts = {'CRITICAL': 0, 'HIGH': 0, 'MEDIUM': 0, 'LOW': 0}
+    disputed_count = 0
+    kev_count = 0
+    fixable_count = 0
+    no_fix_count = 0
+    for result in merged_data.get('Results', []):
+        for vuln in result.get('Vulnerabilities', []):
+            if vuln.get('Disputed'):
+                disputed_count += 1
+                continue
+            if vuln.get('KEV'):
+                kev_count += 1
+            if fix_available(vuln):
+                fixable_count += 1
+            else:
+                no_fix_count += 1
+            severity = vuln.get('Severity', 'UNKNOWN').upper()
+            if severity in counts:
+                counts[severity] += 1
+
+    total = sum(counts.values())
+    image_digest = report_digest(merged_data)
+
+    # Scans without a run ID (manual loads) never match, as NULLs are distinct
+    cur.execute("""
+        SELECT id FROM scans WHERE run_id = %s AND image_id = %s AND image_digest = %s
+    """, (run_id, image_id, image_digest))
+    existing = cur.fetchone() is not None
+
+    # Create scan record, or refresh it from the report when the run was loaded before
+    cur.execute(f"""
+        INSERT INTO scans (
+            image_id, run_id, image_digest, {', '.join(SCAN_REPORT_COLUMNS)}
+        ) VALUES ({', '.join(['%s'] * (len(SCAN_REPORT_COLUMNS) + 3))})
+        ON CONFLICT (run_id, image_id, image_digest) DO UPDATE SET
+            {', '.join(f'{column} = EXCLUDED.{column}' for column in SCAN_REPORT_COLUMNS)}
+        RETURNING id, scan_uuid
+    """, (
+        image_id, run_id, image_digest,
+        batch_id, variant, trivy_version, grype_version,
+        total, counts['CRITICAL'], counts['HIGH'], counts['MEDIUM'], counts['LOW'],
+        merge_stats.get('trivy_only', 0),
+        merge_stats.get('grype_only', 0),
+        merge_stats.get('found_by_both', 0),
+        disputed_count,
+        kev_count,
+        fixable_count,
+        no_fix_count,
+        Json(trivy_data) if trivy_data else None,
+        Json(grype_data) if grype_data else None,
+        Json(merged_data),
+        Json({'scanner_dbs': SCANNER_DBS}) if SCANNER_DBS else None,
+        'completed',
+        load_mode
+    ))
+
+    scan_id, scan_uuid = cur.fetchone()
+
+    # A delta load is read on top of the image's latest full load (or itself when full)
+    cur.execute("""
+        UPDATE scans SET snapshot_scan_id = (
+            SELECT MAX(id) FROM scans WHERE image_id = %s AND load_mode = 'full' AND id <= %s
+        )
+        WHERE id = %s
+    """, (image_id, scan_id, scan_id))
+    # Committed by load_vulnerabilities, so a scan is never stored without its findings
+    cur.close()
+    return scan_id, scan_uuid, existing
+
+def categorize_package_type(package_type):
+    """Categorize package type as OS, application, binary, or unknown"""
+    os_types = {'debian', 'ubuntu', 'alpine', 'rhel', 'centos', 'fedora',
+                'amazonlinux', 'photon', 'rocky', 'almalinux', 'oraclelinux',
+                'suse', 'opensuse', 'arch', 'wolfi'}
+    app_types = {'python-pkg', 'python', 'npm', 'nodejs', 'yarn', 'pnpm',
+                 'go-module', 'gomod', 'java', 'jar', 'maven', 'gradle',
+                 'ruby', 'gem', 'bundler', 'php', 'composer', 'rust', 'cargo',
+                 'nuget', 'dotnet', 'swift', 'cocoapods', 'hex', 'mix'}
+    binary_types = {'binary', 'gobinary'}
+
+    package_type_lower = package_type.lower()
+    if package_type_lower in os_types:
+        return 'os'
+    elif package_type_lower in app_types:
+        return 'application'
+    elif package_type_lower in binary_types:
+        return 'binary'
+    else:
+        return 'unknown'
+
+def fix_available(vuln):
+    """Whether the scanners or the vendor advisory name a fixed version"""
+    return bool(vuln.get('FixedVersion')) or (vuln.get('VendorAdvisory') or {}).get('Status') == 'fixed'
+
+def vulnerability_records(scan_id, image_id, merged_data):
+    """Build the vulnerabilities rows of a scan, keyed by (cve, package, version)"""
+    records = {}
+
+    for result in merged_data.get('Results', []):
+        package_type = result.get('Type', '')
+        target = result.get('Target', '')
+
+        for vuln in result.get('Vulnerabilities', []):
+            package_category = categorize_package_type(package_type)
+            vuln_record = (
+                scan_id,
+                image_id,
+                vuln.get('VulnerabilityID', ''),
+                vuln.get('PkgName', ''),
+                vuln.get('InstalledVersion', ''),
+                package_type,
+                package_category,
+                target,
+                vuln.get('Severity', 'UNKNOWN').upper(),
+                vuln.get('Title', ''),
+                vuln.get('Description', ''),
+                vuln.get('FixedVersion', ''),
+                None,  # published_date - would need to parse
+                None,  # modified_date
+                vuln.get('FoundBy', 'unknown'),
+                Json(vuln.get('References', [])),
+                vuln.get('CVSSScore'),  # cvss_score
+                vuln.get('CVSSVector'),  # cvss_vector
+                vuln.get('CVSSV2Score'),  # cvss_v2_score
+                vuln.get('CVSSV3Score'),  # cvss_v3_score
+                bool(vuln.get('KEV')),  # exploit_available
+                fix_available(vuln),  # patch_available
+                Json(vuln['VendorAdvisory']) if vuln.get('VendorAdvisory') else None,  # vendor_advisory
+                bool(vuln.get('Disputed')),  # disputed
+                Json(vuln['DisputeReasons']) if vuln.get('DisputeReasons') else None,  # dispute_reasons
+                Json(vuln['RemediationLinks']) if vuln.get('RemediationLinks') else None,  # remediation_links
+                (vuln.get('EPSS') or {}).get('Score'),  # epss_score
+                (vuln.get('EPSS') or {}).get('Percentile'),  # epss_percentile
+                Json(vuln['KEV']) if vuln.get('KEV') else None  # kev
+            )
+            # The same finding can be reported for several targets; the first one is kept
+            records.setdefault(finding_key(vuln_record[2], vuln_record[3], vuln_record[4]), vuln_record)
+
+    return records
+
+def finding_key(cve_id, package_name, package_version):
+    """Identify a finding within an image"""
+    return (cve_id, package_name, package_version or '')
+
+def finding_details(record):
+    """The fields that make a finding count as changed between scans"""
+    # severity, fixed_version, found_by, disputed
+    return (record[8], record[11] or '', record[14], record[23])
+
+def diff_findings(cur, image_id, records):
+    """Compare a scan's findings with the image's open lifecycle entries"""
+    cur.execute("""
+        SELECT l.id, l.cve_id, l.package_name, l.package_version, l.status,
+               v.severity, v.fixed_version, v.found_by, v.disputed
+        FROM vulnerability_lifecycle l
+        LEFT JOIN vulnerabilities v ON v.id = l.vuln_id
+        WHERE l.image_id = %s
+    """, (image_id,))
+
+    known = {}
+    for row in cur.fetchall():
+        lifecycle_id, cve_id, package_name, package_version, status = row[:5]
+        details = None
+        if row[5] is not None:
+            details = (row[5], row[6] or '', row[7], bool(row[8]))
+        known[finding_key(cve_id, package_name, package_version)] = (lifecycle_id, status, details)
+
+    changes = {'new': [], 'reopened': [], 'changed': [], 'unchanged': [], 'closed': []}
+    for key, record in records.items():
+        if key not in known:
+            changes['new'].append(key)
+            continue
+        _, status, details = known[key]
+        if status == 'fixed':
+            changes['reopened'].append(key)
+        elif details != finding_details(record):
+            changes['changed'].append(key)
+        else:
+            changes['unchanged'].append(key)
+
+    closed_ids = []
+    for key, (lifecycle_id, status, _) in known.items():
+        if key not in records and status != 'fixed':
+            changes['closed'].append(key)
+            closed_ids.append(lifecycle_id)
+
+    return changes, closed_ids, known
+
+# Columns of a vulnerabilities row, in the order of vulnerability_records
+VULNERABILITY_COLUMNS = (
+    'scan_id', 'image_id', 'cve_id', 'package_name', 'package_version',
+    'package_type', 'package_category', 'package_path', 'severity', 'title', 'description',
+    'fixed_version', 'published_date', 'modified_date', 'found_by',
+    'reference_urls', 'cvss_score', 'cvss_vector', 'cvss_v2_score', 'cvss_v3_score',
+    'exploit_available', 'patch_available', 'vendor_advisory',
+    'disputed', 'dispute_reasons', 'remediation_links',
+    'epss_score', 'epss_percentile', 'kev'
+)
+
+def upsert_vulnerabilities(cur, records, keys):
+    """Write the vulnerabilities rows of the given findings, updating the rows an earlier
+    load of the same scan wrote; returns (id, cve_id, package_name, package_version)"""
+    if not keys:
+        return []
+    return insert_values(cur, f"""
+        INSERT INTO vulnerabilities ({', '.join(VULNERABILITY_COLUMNS)}) VALUES %s
+        ON CONFLICT (scan_id, cve_id, package_name, package_version) DO UPDATE SET
+            {', '.join(f'{column} = EXCLUDED.{column}' for column in VULNERABILITY_COLUMNS[5:])},
+            last_detected = NOW()
+        RETURNING id, cve_id, package_name, package_version
+    """, [records[key] for key in keys], fetch=True)
+
+def load_vulnerabilities(conn, scan_id, image_id, merged_data, load_mode='full', rerun=False):
+    """Load vulnerabilities from merged scan data and update their lifecycle.
+
+    Full loads store every finding; delta loads only store the new, reopened and
+    changed ones. Either way the lifecycle points each open finding at the row
+    with its current details, closes the findings that disappeared, and the
+    changeset is recorded in scan_comparisons.
+
+    A rerun loads a scan that exists already (same run and image digest): its rows
+    are updated in place and the ones the report no longer has are deleted. When a
+    later scan of the image was loaded since, only the scan's own rows are refreshed;
+    the lifecycle and changesets follow the later scans. Returns the number of rows
+    written and the changes, None for such a backfill.
+    """
+    cur = conn.cursor()
+
+    records = vulnerability_records(scan_id, image_id, merged_data)
+
+    stored = {}
+    if rerun:
+        cur.execute("""
+            SELECT id, cve_id, package_name, package_version FROM vulnerabilities WHERE scan_id = %s
+        """, (scan_id,))
+        stored = {finding_key(cve_id, package_name, package_version): vuln_id
+                  for vuln_id, cve_id, package_name, package_version in cur.fetchall()}
+        gone = [vuln_id for key, vuln_id in stored.items() if key not in records]
+        if gone:
+            cur.execute(f"DELETE FROM vulnerabilities WHERE id IN ({', '.join(['%s'] * len(gone))})", gone)
+
+        cur.execute("SELECT MAX(id) FROM scans WHERE image_id = %s", (image_id,))
+        if cur.fetchone()[0] != scan_id:
+            written = list(records) if load_mode == 'full' else [key for key in records if key in stored]
+            rows = upsert_vulnerabilities(cur, records, written)
+            conn.commit()
+            cur.close()
+            return len(rows), None
+
+    changes, closed_ids, known = diff_findings(cur, image_id, records)
+
+    if load_mode == 'delta':
+        written = changes['new'] + changes['reopened'] + changes['changed']
+    else:
+        written = list(records)
+
+    inserted_count = 0
+    if written:
+        rows = upsert_vulnerabilities(cur, records, written)
+        inserted_count = len(rows)
+
+        # Open (or reopen) the lifecycle entries and point them at the new rows
+        insert_values(cur, """
+            INSERT INTO vulnerability_lifecycle (
+                image_id, cve_id, package_name, package_version,
+                first_seen_scan_id, last_seen_scan_id,
+                first_seen_date, last_seen_date, status, vuln_id
+            ) VALUES %s
+            ON CONFLICT (image_id, cve_id, package_name, package_version)
+            DO UPDATE SET
+                last_seen_scan_id = EXCLUDED.last_seen_scan_id,
+                last_seen_date = EXCLUDED.last_seen_date,
+                vuln_id = EXCLUDED.vuln_id,
+                status = CASE WHEN vulnerability_lifecycle.status = 'ignored' THEN 'ignored' ELSE 'active' END,
+                fixed_in_scan_id = NULL,
+                fixed_date = NULL,
+                days_to_fix = NULL,
+                updated_at = NOW()
+        """, [
+            (image_id, cve_id, package_name, package_version, scan_id, scan_id, 'active', vuln_id)
+            for vuln_id, cve_id, package_name, package_version in rows
+        ], template="(%s, %s, %s, %s, %s, %s, NOW(), NOW(), %s, %s)")
+
+    if closed_ids:
+        cur.execute(f"""
+            UPDATE vulnerability_lifecycle
+            SET status = 'fixed',
+                fixed_in_scan_id = %s,
+                fixed_date = NOW(),
+                days_to_fix = {DAYS_SINCE_FIRST_SEEN},
+                updated_at = NOW()
+            WHERE id IN ({', '.join(['%s'] * len(closed_ids))})
+        """, (scan_id, *closed_ids))
+
+    record_changeset(cur, scan_id, image_id, records, changes, known, load_mode)
+
+    conn.commit()
+    cur.close()
+    return inserted_count, changes
+
+def record_changeset(cur, scan_id, image_id, records, changes, known, load_mode):
+    """Store what changed since the image's previous scan in scan_comparisons. A rerun
+    keeps the changeset of the scan's first load, as it diffs against that load."""
+    cur.execute("""
+        SELECT MAX(id) FROM scans
+        WHERE image_id = %s AND id < %s AND scan_status = 'completed'
+    """, (image_id, scan_id))
+    previous_scan_id = cur.fetchone()[0]
+    if previous_scan_id is None:
+        return
+
+    severity_rank = {'CRITICAL': 0, 'HIGH': 1, 'MEDIUM': 2, 'LOW': 3}
+    increased = decreased = 0
+    for key in changes['changed']:
+        before = known[key][2]
+        if before is None:
+            continue
+        old_rank = severity_rank.get(before[0], 4)
+        new_rank = severity_rank.get(records[key][8], 4)
+        if new_rank < old_rank:
+            increased += 1
+        elif new_rank > old_rank:
+            decreased += 1
+
+    details = {'load_mode': load_mode}
+    for kind in ('new', 'reopened', 'changed', 'closed'):
+        details[kind] = [
+            {'cve_id': cve_id, 'package_name': package_name, 'package_version': package_version}
+            for cve_id, package_name, package_version in changes[kind]
+        ]
+
+    cur.execute("""
+        INSERT INTO scan_comparisons (
+            image_id, previous_scan_id, current_scan_id,
+            new_vulnerabilities, fixed_vulnerabilities, unchanged_vulnerabilities,
+            severity_increased, severity_decreased, details
+        ) VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s)
+        ON CONFLICT (previous_scan_id, current_scan_id) DO NOTHING
+    """, (
+        image_id, previous_scan_id, scan_id,
+        len(changes['new']) + len(changes['reopened']),
+        len(changes['closed']),
+        len(changes['unchanged']),
+        increased, decreased,
+        Json(details)
+    ))
+
+# Newest merged report layout this loader reads, described by scan-report.schema.json.
+# Reports without ReportVersion predate it and follow version 1.
+REPORT_VERSION = 1
+
+# Schema errors printed per rejected report
+MAX_REPORT_ERRORS = 10
+
+def json_type_matches(value, name):
+    """Whether a JSON value has one of the types of a JSON Schema 'type'"""
+    if name == 'integer':
+        return isinstance(value, int) and not isinstance(value, bool)
+    if name == 'number':
+        return isinstance(value, (int, float)) and not isinstance(value, bool)
+    return isinstance(value, {
+        'object': dict, 'array': list, 'string': str, 'boolean': bool, 'null': type(None)
+    }[name])
+
+def json_type_name(value):
+    """The JSON type of a value, for error messages"""
+    for name in ('null', 'boolean', 'integer', 'number', 'string', 'array', 'object'):
+        if json_type_matches(value, name):
+            return name
+    return type(value).__name__
+
+def schema_errors(value, schema, path='$'):
+    """Check a value against the subset of JSON Schema scan-report.schema.json uses:
+    type, enum, required, properties, items, minimum and minLength"""
+    types = schema.get('type')
+    if types:
+        types = [types] if isinstance(types, str) else types
+        if not any(json_type_matches(value, t) for t in types):
+            return [f"{path}: expected {' or '.join(types)}, got {json_type_name(value)}"]
+    errors = []
+    if 'enum' in schema and value not in schema['enum']:
+        errors.append(f"{path}: {value!r} is not one of {', '.join(schema['enum'])}")
+    if 'minimum' in schema and json_type_matches(value, 'number') and value < schema['minimum']:
+        errors.append(f"{path}: {value} is less than {schema['minimum']}")
+    if 'minLength' in schema and isinstance(value, str) and len(value) < schema['minLength']:
+        errors.append(f"{path}: must not be empty")
+    if isinstance(value, dict):
+        for name in schema.get('required', []):
+            if name not in value:
+                errors.append(f"{path}: missing {name}")
+        for name, subschema in schema.get('properties', {}).items():
+            if name in value:
+                errors.extend(schema_errors(value[name], subschema, f"{path}.{name}"))
+    if isinstance(value, list) and 'items' in schema:
+        for i, item in enumerate(value):
+            errors.extend(schema_errors(item, schema['items'], f"{path}[{i}]"))
+    return errors
+
+def read_scan_report(scan_file, schema):
+    """Read a merged scan report, returning its data, or None and why it can't be loaded"""
+    try:
+        with open(scan_file) as f:
+            data = json.load(f)
+    except json.JSONDecodeError as e:
+        return None, [f"not valid JSON: {e}"]
+    except OSError as e:
+        return None, [str(e)]
+
+    version = data.get('ReportVersion', 1) if isinstance(data, dict) else None
+    if json_type_matches(version, 'integer') and version > REPORT_VERSION:
+        return None, [f"ReportVersion {version} is newer than this loader reads ({REPORT_VERSION}), upgrade the scheduler"]
+    errors = schema_errors(data, schema)
+    if errors:
+        return None, errors
+    return data, []
+
+# Reports that failed to load are moved here, under the variant's reports directory,
+# with their Trivy and Grype inputs, until `scheduler reload` loads them
+QUARANTINE_DIR = 'quarantine'
+
+# Load state of each report file, kept next to the reports for `scheduler reload`
+LOAD_STATE_FILE = '.load-state.json'
+
+def report_artifacts(scan_file):
+    """The files of one merged report: the report, its scanner inputs and the table"""
+    base = scan_file.name[:-len('_scan.json')]
+    return [scan_file.parent / f"{base}{suffix}"
+            for suffix in ('_scan.json', '_trivy_scan.json', '_grype_scan.json', '_scan.txt')]
+
+def move_report(scan_file, dest_dir):
+    """Move a report and its artifacts to dest_dir, replacing older copies there"""
+    dest_dir.mkdir(exist_ok=True)
+    for artifact in report_artifacts(scan_file):
+        if artifact.exists():
+            artifact.replace(dest_dir / artifact.name)
+
+def read_load_state(reports_dir):
+    """The load state of a variant's reports, empty when there is none yet"""
+    try:
+        return json.loads((reports_dir / LOAD_STATE_FILE).read_text())
+    except (OSError, json.JSONDecodeError):
+        return {}
+
+def update_load_state(reports_dir, results, run_ids):
+    """Record the outcome of each report: loaded, quarantined with the error, or
+    superseded by a newer scan while it was quarantined. run_ids maps each report
+    to the run it was loaded as."""
+    state_file = reports_dir / LOAD_STATE_FILE
+    state = read_load_state(reports_dir)
+    files = state.setdefault('files', {})
+    now = datetime.now(timezone.utc).isoformat()
+    for name, (status, error) in results.items():
+        previous = files.get(name, {})
+        attempts = previous.get('attempts', 0) + 1 if status == 'quarantined' else 0
+        entry = {'status': status, 'attempts': attempts, 'updated_at': now}
+        if error:
+            entry['error'] = error
+        if run_ids.get(name):
+            entry['run_id'] = run_ids[name]
+        files[name] = entry
+    tmp = state_file.with_suffix('.tmp')
+    tmp.write_text(json.dumps(state, indent=2))
+    tmp.replace(state_file)
+
+def process_scan_file(conn, scan_file, merged_data, batch_id, variant, run_id=None):
+    """Process a single merged scan file, already validated"""
+    print(f"\n📄 Processing {scan_file.name}...")
+
+    # Get image name from filename, without the platform of a per-platform report
+    image_stem = image_report_file(scan_file).replace('_scan.json', '')
+    image_name_parts = image_stem.replace('_', '/', 1).rsplit('_', 1)
+    if len(image_name_parts) == 2:
+        image_name = image_name_parts[0].replace('_', '/')
+        image_tag = image_name_parts[1]
+    else:
+        image_name = image_stem.replace('_', '/')
+        image_tag = 'latest'
+
+    full_image_name = f"{image_name}:{image_tag}"
+    platform = merged_data.get('Platform') or ''
+
+    # Extract base image from scan data if available
+    base_image_from_scan = merged_data.get('BaseImage')
+
+    # Extract image metadata
+    print(f"  🔍 Extracting metadata for {full_image_name}...")
+    if base_image_from_scan:
+        print(f"      Base image from scan: {base_image_from_scan}")
+    metadata = extract_image_metadata(full_image_name, base_image_from_scan)
+    if metadata and platform:
+        # docker inspect describes the local pull, not the platform that was scanned
+        metadata['os'], metadata['architecture'] = platform.split('/')[:2]
+
+    # Get or create image record
+    if platform:
+        print(f"  🖥️  Platform: {platform}")
+    print(f"  💾 Creating/updating image record (variant: {variant})...")
+    image_id = get_or_create_image(conn, image_name, image_tag, variant, metadata, platform)
+
+    # Load individual scan files
+    base_name = scan_file.stem.replace('_scan', '')
+    trivy_file = scan_file.parent / f"{base_name}_trivy_scan.json"
+    grype_file = scan_file.parent / f"{base_name}_grype_scan.json"
+
+    trivy_data = None
+    grype_data = None
+
+    if trivy_file.exists():
+        with open(trivy_file) as f:
+            trivy_data = json.load(f)
+
+    if grype_file.exists():
+        with open(grype_file) as f:
+            grype_data = json.load(f)
+
+    # Create scan record
+    print(f"  📊 Creating scan record...")
+    scan_id, scan_uuid, rerun = create_scan_record(conn, image_id, merged_data, trivy_data, grype_data, batch_id, variant, run_id, LOAD_MODE)
+    if rerun:
+        print(f"  ♻️  Run {run_id} already loaded this image, updating scan {scan_id} in place")
+
+    # Load vulnerabilities and update their lifecycle
+    print(f"  🐛 Loading vulnerabilities ({LOAD_MODE})...")
+    vuln_count, changes = load_vulnerabilities(conn, scan_id, image_id, merged_data, LOAD_MODE, rerun)
+
+    if changes is None:
+        print("  📈 A later scan of the image was loaded since, its lifecycle is left as is")
+    else:
+        print(f"  📈 Changes: {len(changes['new'])} new, {len(changes['reopened'])} reopened, "
+              f"{len(changes['changed'])} changed, {len(changes['closed'])} fixed, {len(changes['unchanged'])} unchanged")
+    print(f"  ✅ Loaded {vuln_count} vulnerabilities (scan_id: {scan_id}, uuid: {scan_uuid})")
+
+    return scan_id, vuln_count
+
+def cycle_scan_files(reports_dir):
+    """The merged reports a cycle loads and the images excluded by rule, or None for the
+    reports when no image was freshly scanned"""
+    # Images skipped by a time-boxed scan cycle still have last cycle's reports on disk
+    skipped_file = reports_dir / ".skipped-images"
+    skipped = set()
+    if skipped_file.exists():
+        skipped = {
+            line.strip().replace('/', '_').replace(':', '_') + "_scan.json"
+            for line in skipped_file.read_text().splitlines() if line.strip()
+        }
+
+    # Images excluded by a scheduler exclusion rule keep their old reports on disk too
+    excluded_file = reports_dir / ".excluded-images.json"
+    excluded = json.loads(excluded_file.read_text()) if excluded_file.exists() else []
+
+    # Find all merged scan files (exclude _trivy_scan and _grype_scan)
+    scan_files = [
+        f for f in sorted(reports_dir.glob("*_scan.json"))
+        if '_trivy_scan' not in f.name and '_grype_scan' not in f.name
+    ]
+
+    # SCAN_IMAGES limits the load to the images just scanned, e.g. by a targeted rescan
+    scan_images = [i.strip() for i in os.getenv('SCAN_IMAGES', '').split(',') if i.strip()]
+    if scan_images:
+        wanted = {i.replace('/', '_').replace(':', '_') + "_scan.json" for i in scan_images}
+        scan_files = [f for f in scan_files if image_report_file(f) in wanted]
+
+    if excluded:
+        print(f"🚫 {len(excluded)} image(s) excluded by rule")
+        excluded_files = {e['image'].replace('/', '_').replace(':', '_') + "_scan.json" for e in excluded}
+        scan_files = [f for f in scan_files if image_report_file(f) not in excluded_files]
+
+    if skipped:
+        print(f"⏭️  Skipping {len(skipped)} image(s) not scanned this cycle")
+        scan_files = [f for f in scan_files if image_report_file(f) not in skipped]
+        if not scan_files and not excluded:
+            print("No freshly scanned images to load")
+            return None, excluded
+
+    # Nothing to load is an error unless every image was excluded (the exclusions are still recorded)
+    if not scan_files and not excluded:
+        print(f"❌ No scan files found in {reports_dir}")
+        sys.exit(1)
+
+    return scan_files, excluded
+
+def variant_name(value):
+    """Accept variant names as the scheduler configures them (lowercase, digits and dashes)"""
+    if not re.match(r'^[a-z0-9][a-z0-9-]*$', value):
+        raise argparse.ArgumentTypeError(f"invalid variant name: {value}")
+    return value
+
+def main():
+    # Parse command-line arguments
+    parser = argparse.ArgumentParser(description='Load vulnerability scan results into PostgreSQL or SQLite database')
+    parser.add_argument('--variant',
+                        type=variant_name,
+                        default=IMAGE_VARIANT,
+                        help='Image variant, e.g. baseline or chainguard (default: from IMAGE_VARIANT env var or baseline)')
+    parser.add_argument('--run-id',
+                        default=SCAN_RUN_ID,
+                        help='Scheduler run ID recorded on each scan (default: from SCAN_RUN_ID env var)')
+    parser.add_argument('--reload',
+                        action='store_true',
+                        help=f'Retry the reports that failed to load, from the {QUARANTINE_DIR}/ directory')
+    args = parser.parse_args()
+
+    variant = args.variant
+
+    print("=" * 50)
+    print("Loading Vulnerability Scans to Database")
+    print("=" * 50)
+    print(f"Image Variant: {variant}")
+    print(f"Load Mode: {LOAD_MODE}")
+    if args.reload:
+        print("Reloading quarantined reports")
+    print()
+
+    if DB_BACKEND not in ('postgres', 'sqlite'):
+        print(f"❌ Unknown DB_BACKEND: {DB_BACKEND} (use postgres or sqlite)")
+        sys.exit(1)
+    if DB_BACKEND == 'sqlite' and SCHEMA_PER_VARIANT:
+        print("❌ DB_SCHEMA_PER_VARIANT is not supported with DB_BACKEND=sqlite")
+        sys.exit(1)
+
+    # Get script directory and reports directory (REPORTS_PATH overrides ../reports)
+    script_dir = Path(__file__).parent
+    project_root = script_dir.parent
+    reports_dir = Path(os.getenv('REPORTS_PATH', project_root / "reports")) / variant
+
+    if not reports_dir.exists():
+        print(f"❌ Reports directory not found: {reports_dir}")
+        sys.exit(1)
+
+    quarantine_dir = reports_dir / QUARANTINE_DIR
+    results = {}
+    run_ids = {}
+    if args.reload:
+        # Reports are loaded again as the run that scanned them, so a report an earlier
+        # attempt partly loaded updates that run's scan instead of adding one
+        loaded_as = read_load_state(reports_dir).get('files', {})
+        scan_files, excluded = [], []
+        for scan_file in sorted(quarantine_dir.glob("*_scan.json")):
+            if '_trivy_scan' in scan_file.name or '_grype_scan' in scan_file.name:
+                continue
+            # A later cycle scanned the image again: its report is newer than this one
+            current = reports_dir / scan_file.name
+            if current.exists() and current.stat().st_mtime > scan_file.stat().st_mtime:
+                print(f"♻️  {scan_file.name} was superseded by a newer scan, dropping it")
+                for artifact in report_artifacts(scan_file):
+                    artifact.unlink(missing_ok=True)
+                results[scan_file.name] = ('superseded', None)
+                continue
+            scan_files.append(scan_file)
+        run_ids = {name: entry.get('run_id') for name, entry in loaded_as.items()}
+        if not scan_files:
+            if results:
+                update_load_state(reports_dir, results, run_ids)
+            print("No quarantined reports to reload")
+            return
+    else:
+        scan_files, excluded = cycle_scan_files(reports_dir)
+        if scan_files is None:
+            return
+
+    print(f"📂 Found {len(scan_files)} scan files to process")
+
+    # Every report is checked against the schema before anything is written, so a
+    # truncated or malformed report is rejected instead of loaded as an empty scan
+    schema_file = script_dir / "scan-report.schema.json"
+    try:
+        schema = json.loads(schema_file.read_text())
+    except (OSError, json.JSONDecodeError) as e:
+        print(f"❌ Could not read the scan report schema {schema_file}: {e}")
+        sys.exit(1)
+    reports = {}
+    failed = []
+    for scan_file in scan_files:
+        data, errors = read_scan_report(scan_file, schema)
+        if errors:
+            print(f"❌ Rejected {scan_file.name}: {len(errors)} schema error(s)")
+            for error in errors[:MAX_REPORT_ERRORS]:
+                print(f"     {error}")
+            if len(errors) > MAX_REPORT_ERRORS:
+                print(f"     ... and {len(errors) - MAX_REPORT_ERRORS} more")
+            results[scan_file.name] = ('quarantined', f"schema: {errors[0]}")
+            failed.append(scan_file)
+        else:
+            reports[scan_file] = data
+    scan_files = [f for f in scan_files if f in reports]
+
+    # Connect to database
+    if DB_BACKEND == 'sqlite':
+        print(f"🔌 Opening SQLite database {DB_PATH}...")
+    else:
+        print(f"🔌 Connecting to database at {DB_CONFIG['host']}:{DB_CONFIG['port']}...")
+    conn = get_db_connection()
+    print("✅ Connected to database")
+
+    schema = 'public'
+    if SCHEMA_PER_VARIANT:
+        schema = use_variant_schema(conn, variant)
+        print(f"🗂️  Using schema {schema} for variant {variant}")
+
+    # Generate a batch ID for this scan run
+    batch_id = str(uuid.uuid4())
+    print(f"📦 Scan Batch ID: {batch_id}")
+    if args.run_id:
+        print(f"🏷️  Run ID: {args.run_id}")
+    print()
+
+    # Process each scan file
+    total_scans = 0
+    total_vulns = 0
+
+    for scan_file in scan_files:
+        try:
+            scan_id, vuln_count = process_scan_file(conn, scan_file, reports[scan_file], batch_id, variant,
+                                                    run_ids.get(scan_file.name) or args.run_id)
+            total_scans += 1
+            total_vulns += vuln_count
+        except Exception as e:
+            # Nothing of the report is kept, so it can be loaded again as a whole
+            conn.rollback()
+            print(f"❌ Error processing {scan_file.name}: {e}")
+            import traceback
+            traceback.print_exc()
+            results[scan_file.name] = ('quarantined', str(e) or type(e).__name__)
+            failed.append(scan_file)
+            continue
+        results[scan_file.name] = ('loaded', None)
+        if args.reload:
+            move_report(scan_file, reports_dir)
+
+    # Failed reports are set aside so the next cycle's load doesn't trip over them
+    # and `scheduler reload` can retry them; reloaded ones are already there
+    if not args.reload:
+        for scan_file in failed:
+            move_report(scan_file, quarantine_dir)
+    for name in results:
+        run_ids[name] = run_ids.get(name) or args.run_id
+    update_load_state(reports_dir, results, run_ids)
+
+    total_secrets = total_misconfigs = total_licenses = denied_licenses = 0
+    if not args.reload:
+        if excluded:
+            record_exclusions(conn, variant, excluded, args.run_id)
+        total_secrets = record_secret_findings(conn, variant, scan_files, args.run_id)
+        total_misconfigs = record_config_findings(conn, variant, reports_dir, args.run_id)
+        total_licenses, denied_licenses = record_license_findings(conn, variant, reports_dir, args.run_id)
+
+    register_variant(conn, variant, schema, args.run_id)
+    conn.close()
+
+    print()
+    print("=" * 50)
+    print("✅ Database Loading Complete!")
+    print("=" * 50)
+    print()
+    print(f"Variant: {variant}")
+    print(f"Processed: {total_scans} scans")
+    print(f"Loaded: {total_vulns} vulnerabilities")
+    if total_secrets:
+        print(f"Secrets: {total_secrets}")
+    if total_misconfigs:
+        print(f"Misconfigurations: {total_misconfigs}")
+    if total_licenses:
+        print(f"Licenses: {total_licenses} ({denied_licenses} denied)")
+    print()
+    if failed:
+        print(f"❌ {len(failed)} report(s) failed to load and are quarantined in {quarantine_dir}: "
+              f"{', '.join(f.name for f in failed)}")
+        print("   Retry them with `scheduler reload`")
+        sys.exit(1)
+    print("Query examples:")
+    if DB_BACKEND == 'sqlite':
+        print(f"  sqlite3 {DB_PATH} \"SELECT * FROM current_vulnerabilities WHERE image_variant = '{variant}' LIMIT 10;\"")
+        print(f"  sqlite3 {DB_PATH} \"SELECT * FROM vulnerability_trends WHERE image_variant = '{variant}';\"")
+        print()
+        return
+    print(f"  psql -h {DB_CONFIG['host']} -U {DB_CONFIG['user']} -d {DB_CONFIG['database']} -c 'SELECT * FROM current_vulnerabilities WHERE image_variant = \\'{variant}\\' LIMIT 10;'")
+    print(f"  psql -h {DB_CONFIG['host']} -U {DB_CONFIG['user']} -d {DB_CONFIG['database']} -c 'SELECT * FROM vulnerability_trends WHERE image_variant = \\'{variant}\\';'")
+    print()
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,376 @@
+#!/usr/bin/env python3
+"""
+Merge Trivy and Grype scan results, deduplicating vulnerabilities
+"""
+
+import json
+import os
+import re
+import sys
+from pathlib import Path
+from collections import defaultdict
+
+# Version of the merged report layout, bumped with scan-report.schema.json when it changes
+REPORT_VERSION = 1
+
+# Severities ranked most severe first; Grype's NEGLIGIBLE is reported as LOW
+SEVERITY_RANK = ["CRITICAL", "HIGH", "MEDIUM", "LOW", "UNKNOWN"]
+SEVERITY_ALIASES = {"NEGLIGIBLE": "LOW", "MODERATE": "MEDIUM", "IMPORTANT": "HIGH"}
+
+# How to pick the severity of a finding the scanners rate differently (SEVERITY_POLICY):
+# highest (default), trivy or grype
+SEVERITY_POLICIES = ("highest", "trivy", "grype")
+
+def normalize_severity(severity):
+    """Normalize severity to one of SEVERITY_RANK"""
+    severity = severity.upper() if severity else "UNKNOWN"
+    severity = SEVERITY_ALIASES.get(severity, severity)
+    return severity if severity in SEVERITY_RANK else "UNKNOWN"
+
+def normalize_package(name):
+    """Normalize a package name for matching across scanners"""
+    return name.strip().lower().replace("_", "-")
+
+def grype_cve_id(vuln):
+    """Return the CVE a Grype finding is about: Grype often reports the distro or GitHub
+    advisory (GHSA-..., ALAS-...) and lists the CVE among the related vulnerabilities"""
+    vuln_id = vuln.get("id", "")
+    if vuln_id.startswith("CVE-"):
+        return vuln_id
+    for related in vuln.get("relatedVulnerabilities") or []:
+        if related.get("id", "").startswith("CVE-"):
+            return related["id"]
+    return vuln_id
+
+# Reference URL patterns useful for remediation, most specific first
+REMEDIATION_LINK_PATTERNS = [
+    ("fix_commit", re.compile(r"/commit/[0-9a-f]{7,40}|/-/commit/|/commits/[0-9a-f]{7,40}|git\.kernel\.org/stable/c/|[?;&](id|h)=[0-9a-f]{12,40}", re.I)),
+    ("pull_request", re.compile(r"/pull/\d+|/-/merge_requests/\d+|/pull-requests/\d+", re.I)),
+    ("advisory", re.compile(r"/advisories/GHSA-|/security/advisories/|nvd\.nist\.gov/vuln/detail|osv\.dev/vulnerability"
+                            r"|security-tracker\.debian\.org|ubuntu\.com/security|access\.redhat\.com/(security|errata)"
+                            r"|security\.alpinelinux\.org|/oss-security/|/security-advisor|/(DSA|USN|RHSA|ALAS)-", re.I)),
+    ("changelog", re.compile(r"changelog|/CHANGES|/NEWS|release-?notes|/releases/tag/", re.I)),
+    ("issue", re.compile(r"/issues/\d+|bugzilla|/show_bug\.cgi|bugs\.", re.I)),
+]
+
+def classify_reference(url):
+    """Return the remediation link type of a reference URL, or None"""
+    for link_type, pattern in REMEDIATION_LINK_PATTERNS:
+        if pattern.search(url):
+            return link_type
+    return None
+
+def remediation_links(references):
+    """Extract fix commits, advisories and changelogs from a finding's references"""
+    order = [t for t, _ in REMEDIATION_LINK_PATTERNS]
+    links = []
+    for url in sorted(set(references)):
+        link_type = classify_reference(url)
+        if link_type:
+            links.append({"type": link_type, "url": url})
+    links.sort(key=lambda l: order.index(l["type"]))
+    return links
+
+def parse_trivy_results(trivy_data):
+    """Parse Trivy JSON format and extract vulnerabilities"""
+    vulnerabilities = []
+
+    for result in trivy_data.get("Results", []):
+        target = result.get("Target", "")
+        vuln_type = result.get("Type", "")
+
+        for vuln in result.get("Vulnerabilities", []):
+            # Extract CVSS scores from CVSS field
+            cvss_data = vuln.get("CVSS", {})
+            cvss_v2_score = None
+            cvss_v3_score = None
+            cvss_vector = None
+
+            # Try to get scores from various sources (nvd, redhat, etc.)
+            for source_data in cvss_data.values():
+                if isinstance(source_data, dict):
+                    if not cvss_v2_score and "V2Score" in source_data:
+                        cvss_v2_score = source_data["V2Score"]
+                    if not cvss_v3_score and "V3Score" in source_data:
+                        cvss_v3_score = source_data["V3Score"]
+                    if not cvss_vector and "V3Vector" in source_data:
+                        cvss_vector = source_data["V3Vector"]
+                    elif not cvss_vector and "V2Vector" in source_data:
+                        cvss_vector = source_data["V2Vector"]
+
+            # Use highest score as main CVSS score
+            cvss_score = cvss_v3_score or cvss_v2_score
+
+            normalized = {
+                "id": vuln.get("VulnerabilityID", ""),
+                "package": vuln.get("PkgName", ""),
+                "version": vuln.get("InstalledVersion", ""),
+                "severity": normalize_severity(vuln.get("Severity", "")),
+                "title": vuln.get("Title", ""),
+                "description": vuln.get("Description", ""),
+                "fixed_version": vuln.get("FixedVersion", ""),
+                "cvss_score": cvss_score,
+                "cvss_v2_score": cvss_v2_score,
+                "cvss_v3_score": cvss_v3_score,
+                "cvss_vector": cvss_vector,
+                "references": vuln.get("References", []),
+                "target": target,
+                "type": vuln_type,
+                "source": "trivy"
+            }
+            vulnerabilities.append(normalized)
+
+    return vulnerabilities
+
+def parse_grype_results(grype_data):
+    """Parse Grype JSON format and extract vulnerabilities"""
+    vulnerabilities = []
+
+    for match in grype_data.get("matches", []):
+        vuln = match.get("vulnerability", {})
+        artifact = match.get("artifact", {})
+
+        # Grype doesn't have CVSS in the same format, set to None
+        vuln_id = grype_cve_id(vuln)
+        normalized = {
+            "id": vuln_id,
+            "aliases": [vuln["id"]] if vuln.get("id") and vuln["id"] != vuln_id else [],
+            "package": artifact.get("name", ""),
+            "version": artifact.get("version", ""),
+            "severity": normalize_severity(vuln.get("severity", "")),
+            "title": "",  # Grype doesn't provide title
+            "description": vuln.get("description", ""),
+            "fixed_version": vuln.get("fix", {}).get("versions", [""])[0] if vuln.get("fix", {}).get("versions") else "",
+            "cvss_score": None,
+            "cvss_v2_score": None,
+            "cvss_v3_score": None,
+            "cvss_vector": None,
+            "references": vuln.get("urls", []),
+            "target": artifact.get("type", ""),
+            "type": artifact.get("type", ""),
+            "source": "grype"
+        }
+        vulnerabilities.append(normalized)
+
+    return vulnerabilities
+
+def create_vuln_key(vuln):
+    """Create unique key for deduplication"""
+    # Key = CVE ID + Package Name. The version is left out: scanners format it
+    # differently (epochs, distro suffixes) for the same installed package.
+    return (
+        vuln["id"],
+        normalize_package(vuln["package"])
+    )
+
+def reconcile_severity(severities, policy):
+    """Pick the severity of a finding from each scanner's rating"""
+    if policy in severities and severities[policy] != "UNKNOWN":
+        return severities[policy]
+    return min(severities.values(), key=SEVERITY_RANK.index)
+
+def merge_vulnerabilities(trivy_vulns, grype_vulns, policy="highest"):
+    """Merge vulnerabilities from both sources, removing duplicates"""
+    merged = {}
+    stats = {
+        "trivy_only": 0,
+        "grype_only": 0,
+        "both": 0,
+        "severity_mismatch": 0,
+        "duplicates": 0
+    }
+
+    for vuln in trivy_vulns + grype_vulns:
+        key = create_vuln_key(vuln)
+        source = vuln["source"]
+
+        if key not in merged:
+            vuln["found_by"] = [source]
+            vuln["severities"] = {source: vuln["severity"]}
+            merged[key] = vuln
+            continue
+
+        # Duplicate found - merge information
+        existing = merged[key]
+        stats["duplicates"] += 1
+        if source not in existing["found_by"]:
+            existing["found_by"].append(source)
+        # A scanner reporting a package twice (e.g. in two targets) keeps its worst rating
+        previous = existing["severities"].get(source)
+        if previous is None or SEVERITY_RANK.index(vuln["severity"]) < SEVERITY_RANK.index(previous):
+            existing["severities"][source] = vuln["severity"]
+
+        # Keep more detailed description
+        if len(vuln["description"]) > len(existing["description"]):
+            existing["description"] = vuln["description"]
+
+        # Keep fixed version if not present
+        if not existing["fixed_version"] and vuln["fixed_version"]:
+            existing["fixed_version"] = vuln["fixed_version"]
+
+        # Merge references (URLs) and advisory IDs from both sources
+        existing["references"] = list(set(existing.get("references", [])) | set(vuln.get("references", [])))
+        existing["aliases"] = sorted(set(existing.get("aliases", [])) | set(vuln.get("aliases", [])))
+
+        # Keep CVSS from Trivy (Grype doesn't have it)
+        for field in ("cvss_score", "cvss_v2_score", "cvss_v3_score", "cvss_vector"):
+            if existing.get(field) is None and vuln.get(field) is not None:
+                existing[field] = vuln[field]
+
+    for vuln in merged.values():
+        vuln["severity"] = reconcile_severity(vuln["severities"], policy)
+        if len(set(vuln["severities"].values())) > 1:
+            stats["severity_mismatch"] += 1
+        if len(vuln["found_by"]) > 1:
+            vuln["found_by"].sort(key=["trivy", "grype"].index)
+            stats["both"] += 1
+        elif vuln["found_by"] == ["trivy"]:
+            stats["trivy_only"] += 1
+        else:
+            stats["grype_only"] += 1
+
+    return list(merged.values()), stats
+
+def create_trivy_compatible_output(merged_vulns, original_trivy_data):
+    """Create output in Trivy JSON format with merged results"""
+
+    # Group vulnerabilities by target
+    by_target = defaultdict(list)
+    for vuln in merged_vulns:
+        target = vuln.get("target", "merged")
+        by_target[target].append(vuln)
+
+    # Create Results array
+    results = []
+    for target, vulns in by_target.items():
+        # Convert back to Trivy format
+        trivy_vulns = []
+        for v in vulns:
+            trivy_vuln = {
+                "VulnerabilityID": v["id"],
+                "PkgName": v["package"],
+                "InstalledVersion": v["version"],
+                "Severity": v["severity"],
+                "Title": v["title"],
+                "Description": v["description"],
+                "FixedVersion": v["fixed_version"],
+                "References": v.get("references", []),
+                "FoundBy": ",".join(v["found_by"])  # Custom field
+            }
+
+            # Each scanner's rating, when they disagree on the severity
+            if len(set(v["severities"].values())) > 1:
+                trivy_vuln["ScannerSeverities"] = v["severities"]  # Custom field
+            if v.get("aliases"):
+                trivy_vuln["Aliases"] = v["aliases"]  # Custom field
+
+            links = remediation_links(v.get("references", []))
+            if links:
+                trivy_vuln["RemediationLinks"] = links  # Custom field
+
+            # Add CVSS scores if available
+            if v.get("cvss_score"):
+                trivy_vuln["CVSSScore"] = v["cvss_score"]
+            if v.get("cvss_v2_score"):
+                trivy_vuln["CVSSV2Score"] = v["cvss_v2_score"]
+            if v.get("cvss_v3_score"):
+                trivy_vuln["CVSSV3Score"] = v["cvss_v3_score"]
+            if v.get("cvss_vector"):
+                trivy_vuln["CVSSVector"] = v["cvss_vector"]
+
+            trivy_vulns.append(trivy_vuln)
+
+        result = {
+            "Target": target,
+            "Type": vulns[0]["type"] if vulns else "",
+            "Vulnerabilities": trivy_vulns
+        }
+        results.append(result)
+
+    # Create full output; ReportVersion is the layout of scan-report.schema.json,
+    # SchemaVersion Trivy's own
+    output = {
+        "ReportVersion": REPORT_VERSION,
+        "SchemaVersion": original_trivy_data.get("SchemaVersion", 2),
+        "ArtifactName": original_trivy_data.get("ArtifactName", ""),
+        "ArtifactType": original_trivy_data.get("ArtifactType", ""),
+        "Metadata": original_trivy_data.get("Metadata", {}),
+        "Results": results
+    }
+
+    return output
+
+def main():
+    if len(sys.argv) < 4:
+        print("Usage: merge-scan-results.py <trivy.json> <grype.json> <output.json> [base_image] [platform]")
+        sys.exit(1)
+
+    trivy_file = Path(sys.argv[1])
+    grype_file = Path(sys.argv[2])
+    output_file = Path(sys.argv[3])
+    base_image = sys.argv[4] if len(sys.argv) > 4 else None
+    platform = sys.argv[5] if len(sys.argv) > 5 else None
+    policy = os.environ.get("SEVERITY_POLICY", "highest")
+    if policy not in SEVERITY_POLICIES:
+        print(f"Error: SEVERITY_POLICY must be one of {', '.join(SEVERITY_POLICIES)}, got {policy!r}")
+        sys.exit(1)
+
+    # Load input files
+    try:
+        with open(trivy_file) as f:
+            trivy_data = json.load(f)
+    except FileNotFoundError:
+        print(f"Error: Trivy file not found: {trivy_file}")
+        sys.exit(1)
+
+    try:
+        with open(grype_file) as f:
+            grype_data = json.load(f)
+    except FileNotFoundError:
+        print(f"Warning: Grype file not found: {grype_file}, using Trivy data only")
+        grype_data = {"matches": []}
+
+    # Parse vulnerabilities
+    trivy_vulns = parse_trivy_results(trivy_data)
+    grype_vulns = parse_grype_results(grype_data)
+
+    # Merge
+    merged_vulns, stats = merge_vulnerabilities(trivy_vulns, grype_vulns, policy)
+
+    # Create output
+    output = create_trivy_compatible_output(merged_vulns, trivy_data)
+
+    # Add merge statistics as metadata
+    output["MergeStats"] = {
+        "trivy_count": len(trivy_vulns),
+        "grype_count": len(grype_vulns),
+        "merged_count": len(merged_vulns),
+        "trivy_only": stats["trivy_only"],
+        "grype_only": stats["grype_only"],
+        "found_by_both": stats["both"],
+        "severity_mismatch": stats["severity_mismatch"],
+        "duplicates_merged": stats["duplicates"],
+        "severity_policy": policy
+    }
+
+    # Add base image metadata if provided
+    if base_image:
+        output["BaseImage"] = base_image
+
+    # Record the platform of a multi-arch image the scanners were pointed at
+    if platform:
+        output["Platform"] = platform
+
+    # Write output
+    with open(output_file, "w") as f:
+        json.dump(output, f, indent=2)
+
+    # Print summary
+    image_name = trivy_file.stem.replace("_scan", "")
+    print(f"✓ Merged {image_name}:")
+    print(f"  Trivy: {len(trivy_vulns)} | Grype: {len(grype_vulns)} | Merged: {len(merged_vulns)}")
+    print(f"  Trivy-only: {stats['trivy_only']} | Grype-only: {stats['grype_only']} | Both: {stats['both']}"
+          f" | Severity mismatches: {stats['severity_mismatch']} ({policy})")
+
+if __name__ == "__main__":
+    main()
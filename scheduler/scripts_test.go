@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestEmbeddedScriptsMatchRepository fails when the embedded copies of the pipeline
+// scripts drift from the repository's scripts/ directory
+func TestEmbeddedScriptsMatchRepository(t *testing.T) {
+	entries, err := fs.ReadDir(embeddedScripts, "scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		t.Fatal("no scripts embedded")
+	}
+	for _, e := range entries {
+		embedded, err := embeddedScripts.ReadFile("scripts/" + e.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		source, err := os.ReadFile(filepath.Join("..", "scripts", e.Name()))
+		if err != nil {
+			t.Errorf("embedded %s has no source in ../scripts: %v", e.Name(), err)
+			continue
+		}
+		if !bytes.Equal(embedded, source) {
+			t.Errorf("scheduler/scripts/%s differs from scripts/%s; run `go generate` in scheduler/", e.Name(), e.Name())
+		}
+	}
+}
+
+// TestPipelineScripts runs the unittest suites of the scripts, which load reports into
+// a SQLite database and merge scanner results
+func TestPipelineScripts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the script tests in short mode")
+	}
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not installed")
+	}
+	cmd := exec.Command(python, "-m", "unittest", "discover", "-s", filepath.Join("..", "scripts", "tests"))
+	cmd.Env = append(os.Environ(), "PYTHONDONTWRITEBYTECODE=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+}
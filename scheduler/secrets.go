@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// secretsStep runs Trivy's secret detection on every freshly scanned image
+// (SECRET_SCANNING) and keeps its findings in {image}_secrets.json next to the
+// merged reports
+type secretsStep struct{}
+
+func (secretsStep) Title() string            { return "Scanning images for secrets" }
+func (secretsStep) Enabled(cfg *Config) bool { return cfg.SecretScanning }
+func (secretsStep) Required() bool           { return false }
+
+func (secretsStep) Plan(j *ScanJob) {
+	j.Log.Printf("[%s] 🧪 Would scan %d image(s) for secrets with Trivy", j.Variant, len(j.Images))
+}
+
+func (secretsStep) Run(j *ScanJob) error {
+	images, err := j.scannedImages()
+	if err != nil {
+		return err
+	}
+	failed, err := j.trivyPerImage("secret", images, secretsReportFile)
+	if err != nil {
+		return err
+	}
+
+	findings, err := readSecretFindings(j.Variant)
+	if err != nil {
+		return err
+	}
+	j.Log.Printf("[%s] ✅ %d secret(s) found in %d image(s)", j.Variant, len(findings), len(images)-len(failed))
+	if len(failed) > 0 {
+		return fmt.Errorf("secret scan failed for %d image(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// trivyPerImage runs `trivy image --scanners scanner` on each image, writing its JSON
+// output to the report file named by reportFile, and returns the images it failed on
+func (j *ScanJob) trivyPerImage(scanner string, images []string, reportFile func(string) string) ([]string, error) {
+	authEnv, cleanupAuth, err := j.registryAuthEnv()
+	if err != nil {
+		return nil, fmt.Errorf("registry credentials for %s: %w", j.Variant, err)
+	}
+	defer cleanupAuth()
+
+	var failed []string
+	for _, image := range images {
+		out := filepath.Join(variantDir(j.Variant), reportFile(image))
+		cmd := exec.Command("trivy", "image", "--scanners", scanner, "--format", "json", "--quiet", "--output", out, image)
+		cmd.Env = j.commandEnv(authEnv...)
+		step := strings.TrimSuffix(reportFile(image), ".json")
+		if err := j.runLogged(step, cmd); err != nil {
+			j.Log.Printf("[%s] ⚠️  Trivy %s scan of %s failed: %v", j.Variant, scanner, image, err)
+			os.Remove(out)
+			failed = append(failed, image)
+		}
+	}
+	return failed, nil
+}
+
+// scannedImages returns the job's images that were scanned this cycle, leaving out
+// those kept unchanged, skipped by the time budget or failed
+func (j *ScanJob) scannedImages() ([]string, error) {
+	skip := make(map[string]bool)
+	for _, image := range j.Unchanged {
+		skip[image] = true
+	}
+	skipped, err := readSkippedImages(j.Variant)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range skipped {
+		skip[image] = true
+	}
+
+	var images []string
+	for _, image := range j.Images {
+		if skip[image] {
+			continue
+		}
+		if reports, err := imageReportFiles(j.Variant, image); err != nil || len(reports) == 0 {
+			continue
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// secretsReportFile returns the name of an image's secret scan report
+func secretsReportFile(image string) string {
+	return strings.NewReplacer("/", "_", ":", "_").Replace(image) + "_secrets.json"
+}
+
+// trivySecretReport is the subset of Trivy's secret scan output the scheduler reads
+type trivySecretReport struct {
+	ArtifactName string `json:"ArtifactName"`
+	Results      []struct {
+		Target  string        `json:"Target"`
+		Secrets []TrivySecret `json:"Secrets"`
+	} `json:"Results"`
+}
+
+// TrivySecret is a single secret found by Trivy; Match is the offending line with the
+// secret itself masked
+type TrivySecret struct {
+	RuleID    string `json:"RuleID"`
+	Category  string `json:"Category"`
+	Severity  string `json:"Severity"`
+	Title     string `json:"Title"`
+	StartLine int    `json:"StartLine"`
+	EndLine   int    `json:"EndLine"`
+	Match     string `json:"Match"`
+}
+
+// SecretFinding is a secret found in one of a variant's images
+type SecretFinding struct {
+	Image    string `json:"image"`
+	Target   string `json:"target"`
+	RuleID   string `json:"rule_id"`
+	Category string `json:"category"`
+	Severity string `json:"severity"`
+	Title    string `json:"title"`
+	Line     int    `json:"line,omitempty"`
+	Match    string `json:"match,omitempty"`
+}
+
+// readSecretFindings lists the secrets of a variant's secret scan reports, most severe
+// first. Excluded images are left out, like in mergedReportFiles.
+func readSecretFindings(variant string) ([]SecretFinding, error) {
+	matches, err := filepath.Glob(filepath.Join(variantDir(variant), "*_secrets.json"))
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := readExcludedImages(variant)
+	if err != nil {
+		return nil, err
+	}
+	excludedFiles := make(map[string]bool, len(excluded))
+	for _, e := range excluded {
+		excludedFiles[secretsReportFile(e.Image)] = true
+	}
+
+	var findings []SecretFinding
+	for _, f := range matches {
+		if excludedFiles[filepath.Base(f)] {
+			continue
+		}
+		data, err := os.ReadFile(f)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var report trivySecretReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", f, err)
+		}
+		image := report.ArtifactName
+		if image == "" {
+			image = strings.TrimSuffix(filepath.Base(f), "_secrets.json")
+		}
+		for _, r := range report.Results {
+			for _, s := range r.Secrets {
+				findings = append(findings, SecretFinding{
+					Image:    image,
+					Target:   r.Target,
+					RuleID:   s.RuleID,
+					Category: s.Category,
+					Severity: s.Severity,
+					Title:    s.Title,
+					Line:     s.StartLine,
+					Match:    s.Match,
+				})
+			}
+		}
+	}
+	sort.SliceStable(findings, func(a, b int) bool {
+		return severityRank(findings[a].Severity) < severityRank(findings[b].Severity)
+	})
+	return findings, nil
+}
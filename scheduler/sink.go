@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Sink types
+const (
+	sinkDatabase = "database"
+	sinkFile     = "file"
+	sinkWebhook  = "webhook"
+)
+
+// Sink is a destination for the results of each scanned variant. Sinks are
+// independent: a failing sink doesn't keep the others from receiving the results.
+type Sink interface {
+	Publish(job *ScanJob, batch *SinkBatch) error
+}
+
+// SinkBatch is what a sink receives after a variant scan, with the findings already
+// narrowed down by the sink's filters
+type SinkBatch struct {
+	RunID       string    `json:"run_id"`
+	Variant     string    `json:"variant"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Findings    []Finding `json:"findings"`
+}
+
+// SinkConfig configures one sink in the "sinks" list of the config file
+type SinkConfig struct {
+	Type string `json:"type"`
+	// Name identifies the sink in logs and results, defaulting to its type
+	Name string `json:"name,omitempty"`
+	// Required sinks fail the variant when publishing fails; only the database is required by default
+	Required *bool `json:"required,omitempty"`
+
+	// Variants limits the sink to these variants (default: all)
+	Variants []string `json:"variants,omitempty"`
+	// MinSeverity drops findings below this severity
+	MinSeverity string `json:"min_severity,omitempty"`
+
+	// Path is the output directory of file sinks
+	Path string `json:"path,omitempty"`
+	// URL receives a JSON POST per variant from webhook sinks, and is the server of
+	// defectdojo and dependency-track sinks
+	URL string `json:"url,omitempty"`
+
+	// Bucket receives the reports of s3 and gcs sinks under {prefix}/{run_id}/{variant}/
+	Bucket string `json:"bucket,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	// Region of an s3 bucket (default: AWS_REGION)
+	Region string `json:"region,omitempty"`
+	// Endpoint replaces the S3 or GCS API URL, e.g. for MinIO or an emulator
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// APIKey authenticates defectdojo and dependency-track sinks (default:
+	// DEFECTDOJO_API_KEY or DEPENDENCY_TRACK_API_KEY)
+	APIKey string `json:"api_key,omitempty"`
+	// Product is the DefectDojo product, or the parent project in Dependency-Track
+	Product string `json:"product,omitempty"`
+	// Engagement is the DefectDojo engagement scans are imported into (default: the variant)
+	Engagement string `json:"engagement,omitempty"`
+}
+
+// defaultSinks keeps the historical behavior: results are loaded into the database
+var defaultSinks = []SinkConfig{{Type: sinkDatabase}}
+
+// sinkFactories build a sink from its configuration; new destinations register here
+var sinkFactories = map[string]func(SinkConfig) Sink{
+	sinkDatabase: func(SinkConfig) Sink { return databaseSink{} },
+	sinkFile:     func(c SinkConfig) Sink { return fileSink{dir: c.Path} },
+	sinkWebhook:  func(c SinkConfig) Sink { return webhookSink{url: c.URL} },
+	sinkS3:       newS3Sink,
+	sinkGCS:      newGCSSink,
+
+	sinkDefectDojo:      newDefectDojoSink,
+	sinkDependencyTrack: newDependencyTrackSink,
+}
+
+// DisplayName returns the configured name or the sink type
+func (c SinkConfig) DisplayName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Type
+}
+
+// IsRequired reports whether a publishing failure fails the variant
+func (c SinkConfig) IsRequired() bool {
+	if c.Required != nil {
+		return *c.Required
+	}
+	return c.Type == sinkDatabase
+}
+
+// accepts reports whether the sink receives results for variant
+func (c SinkConfig) accepts(variant string) bool {
+	if len(c.Variants) == 0 {
+		return true
+	}
+	for _, v := range c.Variants {
+		if v == variant {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the findings at or above the sink's minimum severity
+func (c SinkConfig) filter(findings []Finding) []Finding {
+	if c.MinSeverity == "" {
+		return findings
+	}
+	limit := severityRank(strings.ToUpper(c.MinSeverity))
+	filtered := make([]Finding, 0, len(findings))
+	for _, f := range findings {
+		if severityRank(f.Severity) <= limit {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
+
+// validateSinks checks the sink list for settings that would fail at publish time
+func validateSinks(sinks []SinkConfig) []error {
+	var errs []error
+	names := make(map[string]bool)
+	for i, c := range sinks {
+		name := c.DisplayName()
+		if _, ok := sinkFactories[c.Type]; !ok {
+			errs = append(errs, fmt.Errorf("sink #%d has unknown type %q", i+1, c.Type))
+			continue
+		}
+		if names[name] {
+			errs = append(errs, fmt.Errorf("sink %q configured more than once; give each sink a unique name", name))
+		}
+		names[name] = true
+
+		if c.MinSeverity != "" && severityRank(strings.ToUpper(c.MinSeverity)) == len(severityOrder) {
+			errs = append(errs, fmt.Errorf("sink %q has invalid min_severity %q", name, c.MinSeverity))
+		}
+		switch c.Type {
+		case sinkDatabase:
+			// The loader stores complete reports, including the severity counts of each scan
+			if c.MinSeverity != "" {
+				errs = append(errs, fmt.Errorf("sink %q: min_severity is not supported by database sinks", name))
+			}
+		case sinkFile:
+			if c.Path == "" {
+				errs = append(errs, fmt.Errorf("sink %q needs a path", name))
+			}
+		case sinkWebhook:
+			if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				errs = append(errs, fmt.Errorf("sink %q needs an http(s) url", name))
+			}
+		case sinkS3, sinkGCS:
+			if c.Bucket == "" {
+				errs = append(errs, fmt.Errorf("sink %q needs a bucket", name))
+			}
+			if c.Endpoint != "" {
+				if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+					errs = append(errs, fmt.Errorf("sink %q has an invalid endpoint: must be an http(s) URL", name))
+				}
+			}
+			if c.Type == sinkS3 && c.Region == "" && os.Getenv("AWS_REGION") == "" && os.Getenv("AWS_DEFAULT_REGION") == "" {
+				errs = append(errs, fmt.Errorf("sink %q needs a region (or AWS_REGION)", name))
+			}
+		case sinkDefectDojo, sinkDependencyTrack:
+			if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				errs = append(errs, fmt.Errorf("sink %q needs an http(s) url", name))
+			}
+			if c.Type == sinkDefectDojo && c.APIKey == "" && os.Getenv("DEFECTDOJO_API_KEY") == "" {
+				errs = append(errs, fmt.Errorf("sink %q needs an api_key (or DEFECTDOJO_API_KEY)", name))
+			}
+			if c.Type == sinkDependencyTrack && c.APIKey == "" && os.Getenv("DEPENDENCY_TRACK_API_KEY") == "" {
+				errs = append(errs, fmt.Errorf("sink %q needs an api_key (or DEPENDENCY_TRACK_API_KEY)", name))
+			}
+		}
+	}
+	return errs
+}
+
+// publishToSinks fans a variant's results out to every configured sink that accepts
+// the variant. It returns the error of each failed sink by name, and an error if a
+// required sink failed.
+func (j *ScanJob) publishToSinks(sinks []SinkConfig) (map[string]string, error) {
+	findings, err := variantFindings(j.Variant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s reports: %w", j.Variant, err)
+	}
+
+	failures := make(map[string]string)
+	var required []error
+	for _, c := range sinks {
+		name := c.DisplayName()
+		if !c.accepts(j.Variant) {
+			continue
+		}
+
+		batch := &SinkBatch{
+			RunID:       j.RunID,
+			Variant:     j.Variant,
+			GeneratedAt: time.Now().UTC(),
+			Findings:    c.filter(findings),
+		}
+		if err := sinkFactories[c.Type](c).Publish(j, batch); err != nil {
+			failures[name] = err.Error()
+			if c.IsRequired() {
+				j.Log.Printf("[%s] ❌ Sink %s failed: %v", j.Variant, name, err)
+				required = append(required, fmt.Errorf("sink %s: %w", name, err))
+			} else {
+				j.Log.Printf("[%s] ⚠️  Sink %s failed: %v", j.Variant, name, err)
+			}
+			continue
+		}
+		j.Log.Printf("[%s] ✅ Published %d finding(s) to sink %s", j.Variant, len(batch.Findings), name)
+	}
+	return failures, errors.Join(required...)
+}
+
+// databaseSink loads the merged reports into PostgreSQL with load-to-database.py
+type databaseSink struct{}
+
+func (databaseSink) Publish(j *ScanJob, _ *SinkBatch) error {
+	if err := j.runLogged("load", j.loadCommand()); err != nil {
+		if quarantined, _ := quarantinedReports(j.Variant); len(quarantined) > 0 {
+			return fmt.Errorf("database load failed for %s, %d report(s) quarantined for `scheduler reload`: %w", j.Variant, len(quarantined), err)
+		}
+		return fmt.Errorf("database load failed for %s: %w", j.Variant, err)
+	}
+	return nil
+}
+
+// loadCommand builds the load-to-database.py invocation for the job's variant
+func (j *ScanJob) loadCommand() *exec.Cmd {
+	cmd := j.Config.scriptCommand(stepPublish, "load-to-database.py", "--variant", j.Variant)
+	cmd.Env = j.commandEnv(append(j.Config.DB.Env(), "SCAN_RUN_ID="+j.RunID, "REPORTS_PATH="+variantReportsRoot(j.Variant))...)
+	// Only the images just scanned are loaded, e.g. those of a targeted rescan
+	if images := j.Config.VariantImages(j.Variant); len(images) > 0 {
+		cmd.Env = append(cmd.Env, "SCAN_IMAGES="+strings.Join(images, ","))
+	}
+	// Recorded in scans.scan_metadata with each loaded scan
+	if len(j.ScannerDBs) > 0 {
+		if data, err := json.Marshal(j.ScannerDBs); err == nil {
+			cmd.Env = append(cmd.Env, "SCANNER_DBS="+string(data))
+		}
+	}
+	// Recorded in scans.trivy_version and grype_version
+	if len(j.ScannerVersions) > 0 {
+		if data, err := json.Marshal(j.ScannerVersions); err == nil {
+			cmd.Env = append(cmd.Env, "SCANNER_VERSIONS="+string(data))
+		}
+	}
+	return cmd
+}
+
+// fileSink writes each batch to {path}/{variant}/{run_id}.json
+type fileSink struct {
+	dir string
+}
+
+func (s fileSink) Publish(_ *ScanJob, batch *SinkBatch) error {
+	dir := filepath.Join(s.dir, batch.Variant)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(batch, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, batch.RunID+".json"), append(data, '\n'), 0o644)
+}
+
+// webhookSink posts each batch as JSON
+type webhookSink struct {
+	url string
+}
+
+func (s webhookSink) Publish(_ *ScanJob, batch *SinkBatch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const stateFileName = ".scheduler-state.json"
+
+// errCorruptState is returned for a state file that exists but can't be parsed
+var errCorruptState = errors.New("state file is corrupt")
+
+// SchedulerState is persisted to the reports volume so it survives restarts
+type SchedulerState struct {
+	LastSuccessfulRun time.Time  `json:"last_successful_run"`
+	Paused            bool       `json:"paused,omitempty"`
+	PausedAt          *time.Time `json:"paused_at,omitempty"`
+	// SkippedImages lists, per variant, the images a time-boxed cycle did not reach
+	SkippedImages map[string][]string `json:"skipped_images,omitempty"`
+	// ConsecutiveFailures counts the failed or partial cycles since the last successful one
+	ConsecutiveFailures int `json:"consecutive_failures,omitempty"`
+	// OpenAlerts records when each alert still open with the paging provider was raised
+	OpenAlerts map[string]*time.Time `json:"open_alerts,omitempty"`
+}
+
+// stateMu serializes read-modify-write cycles of the state file
+var stateMu sync.Mutex
+
+func stateFilePath() string {
+	return filepath.Join(reportsPath, stateFileName)
+}
+
+// loadState reads the persisted scheduler state, returning an empty state if none exists yet
+func loadState() (*SchedulerState, error) {
+	state := &SchedulerState{}
+
+	data, err := os.ReadFile(stateFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("%w: %w", errCorruptState, err)
+	}
+	return state, nil
+}
+
+// saveState atomically writes the scheduler state to disk
+func saveState(state *SchedulerState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	tmp := stateFilePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, stateFilePath()); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+	return nil
+}
+
+// updateState applies fn to the persisted state and saves the result. A corrupt
+// state file is moved aside and replaced; a state file that can't be read is left
+// alone and the error returned.
+func updateState(fn func(*SchedulerState)) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	state, err := loadState()
+	if errors.Is(err, errCorruptState) {
+		aside := fmt.Sprintf("%s.corrupt-%s", stateFilePath(), time.Now().UTC().Format("20060102T150405Z"))
+		if err := os.Rename(stateFilePath(), aside); err != nil {
+			return fmt.Errorf("failed to move the corrupt state file aside: %w", err)
+		}
+		log.Printf("⚠️  Moved the corrupt scheduler state file to %s and started afresh: %v", aside, err)
+		state = &SchedulerState{}
+	} else if err != nil {
+		return err
+	}
+
+	fn(state)
+	return saveState(state)
+}
+
+// recordSuccessfulRun persists the completion time of a fully successful scan cycle
+func recordSuccessfulRun(at time.Time) {
+	err := updateState(func(state *SchedulerState) {
+		state.LastSuccessfulRun = at
+	})
+	if err != nil {
+		log.Printf("⚠️  Could not persist last successful run: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateStateMovesCorruptFileAside(t *testing.T) {
+	dir := useReportsDir(t)
+	corrupt := []byte(`{"last_successful_run": "2025-01-`)
+	if err := os.WriteFile(stateFilePath(), corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadState(); !errors.Is(err, errCorruptState) {
+		t.Fatalf("loadState err = %v, want errCorruptState", err)
+	}
+
+	if err := updateState(func(s *SchedulerState) { s.Paused = true }); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadState()
+	if err != nil || !state.Paused {
+		t.Fatalf("state after the update = %+v, %v", state, err)
+	}
+	aside, _ := filepath.Glob(filepath.Join(dir, stateFileName+".corrupt-*"))
+	if len(aside) != 1 {
+		t.Fatalf("corrupt files kept: %v, want 1", aside)
+	}
+	if data, _ := os.ReadFile(aside[0]); string(data) != string(corrupt) {
+		t.Errorf("the corrupt file was not kept as it was: %q", data)
+	}
+}
+
+func TestUpdateStateKeepsUnreadableFile(t *testing.T) {
+	useReportsDir(t)
+	// A directory in place of the state file can't be read
+	if err := os.Mkdir(stateFilePath(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	if err := updateState(func(*SchedulerState) { called = true }); err == nil {
+		t.Fatal("updateState saved over a state file it could not read")
+	}
+	if called {
+		t.Error("the update was applied to an empty state")
+	}
+	if info, err := os.Stat(stateFilePath()); err != nil || !info.IsDir() {
+		t.Errorf("the unreadable state file was replaced: %v", err)
+	}
+}
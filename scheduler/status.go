@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// statusFileName is the status document under the reports directory, for scripts
+// and dashboards that read the volume instead of calling the API
+const statusFileName = "status.json"
+
+// SchedulerStatus is the health summary kept in status.json and served on GET /status
+type SchedulerStatus struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	Schedule  string    `json:"schedule"`
+	Paused    bool      `json:"paused"`
+	Leader    bool      `json:"leader"`
+	// NextRun is when the next scheduled cycle starts; unset while paused
+	NextRun *time.Time `json:"next_run,omitempty"`
+	// Tenants lists the tenants and when their next cycle starts
+	Tenants []TenantStatus `json:"tenants,omitempty"`
+	// Running is the cycle in progress (GET /status only)
+	Running             *RunningCycle `json:"running,omitempty"`
+	LastSuccessfulRun   *time.Time    `json:"last_successful_run,omitempty"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	LastRun             *LastRun      `json:"last_run,omitempty"`
+}
+
+// TenantStatus is the schedule of a tenant's variants
+type TenantStatus struct {
+	Name     string   `json:"name"`
+	Variants []string `json:"variants"`
+	// Schedule is the tenant's own schedule, or SCAN_SCHEDULE
+	Schedule string     `json:"schedule"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+}
+
+// RunningCycle identifies the cycle in progress
+type RunningCycle struct {
+	RunID     string    `json:"run_id"`
+	StartedAt time.Time `json:"started_at"`
+	// Progress is the image progress of each variant whose scan step has started
+	Progress map[string]ScanProgress `json:"progress,omitempty"`
+}
+
+// LastRun summarizes the most recent cycle the daemon completed
+type LastRun struct {
+	RunID       string          `json:"run_id"`
+	Status      string          `json:"status"`
+	StartedAt   time.Time       `json:"started_at"`
+	FinishedAt  time.Time       `json:"finished_at"`
+	DurationSec float64         `json:"duration_seconds"`
+	Variants    []VariantStatus `json:"variants"`
+}
+
+// VariantStatus is the outcome of one variant in the last cycle
+type VariantStatus struct {
+	Variant         string         `json:"variant"`
+	Success         bool           `json:"success"`
+	Error           string         `json:"error,omitempty"`
+	DurationSec     float64        `json:"duration_seconds"`
+	Images          int            `json:"images"`
+	Vulnerabilities int            `json:"vulnerabilities"`
+	Severities      map[string]int `json:"severities,omitempty"`
+	FailedImages    int            `json:"failed_images,omitempty"`
+	SkippedImages   int            `json:"skipped_images,omitempty"`
+	// ScannerVersions are the scanners the variant was scanned with, ScannerChanges
+	// those whose version changed since its previous scan
+	ScannerVersions map[string]string      `json:"scanner_versions,omitempty"`
+	ScannerChanges  []ScannerVersionChange `json:"scanner_changes,omitempty"`
+}
+
+// statusMu serializes writes of the status file
+var statusMu sync.Mutex
+
+func statusFilePath() string {
+	return filepath.Join(reportsPath, statusFileName)
+}
+
+// newLastRun summarizes a completed cycle
+func newLastRun(cycle *CycleResult) *LastRun {
+	last := &LastRun{
+		RunID:       cycle.RunID,
+		Status:      cycle.Status,
+		StartedAt:   cycle.StartedAt,
+		FinishedAt:  cycle.FinishedAt,
+		DurationSec: cycle.FinishedAt.Sub(cycle.StartedAt).Seconds(),
+	}
+	for _, v := range cycle.Variants {
+		last.Variants = append(last.Variants, VariantStatus{
+			Variant:         v.Variant,
+			Success:         v.Success,
+			Error:           v.Error,
+			DurationSec:     v.DurationSec,
+			Images:          v.Images,
+			Vulnerabilities: v.Vulnerabilities,
+			Severities:      v.Severities,
+			FailedImages:    len(v.Failed),
+			SkippedImages:   len(v.Skipped),
+			ScannerVersions: v.ScannerVersions,
+			ScannerChanges:  v.ScannerChanges,
+		})
+	}
+	return last
+}
+
+// readLastRun returns the last cycle recorded in the status file, or nil
+func readLastRun() (*LastRun, error) {
+	data, err := os.ReadFile(statusFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status file: %w", err)
+	}
+	var status SchedulerStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse status file: %w", err)
+	}
+	return status.LastRun, nil
+}
+
+// Status returns the scheduler's current status, with the last cycle as recorded in
+// the status file
+func (s *Scheduler) Status() SchedulerStatus {
+	pause := s.PauseStatus()
+	cfg := s.Config()
+	status := SchedulerStatus{
+		UpdatedAt: time.Now().UTC(),
+		Schedule:  cfg.Schedule,
+		Paused:    pause.Paused,
+		Leader:    s.elector.IsLeader(),
+		NextRun:   pause.NextRun,
+	}
+	tenantNext := s.TenantNextRuns()
+	for _, t := range cfg.Tenants {
+		ts := TenantStatus{Name: t.Name, Variants: t.Variants, Schedule: cfg.Schedule, NextRun: status.NextRun}
+		if t.Schedule != "" {
+			ts.Schedule, ts.NextRun = t.Schedule, nil
+			if next, ok := tenantNext[t.Name]; ok && !pause.Paused {
+				next = next.UTC()
+				ts.NextRun = &next
+			}
+		}
+		status.Tenants = append(status.Tenants, ts)
+	}
+	if run := activity.Snapshot().Run; run != nil && run.FinishedAt == nil {
+		status.Running = &RunningCycle{RunID: run.RunID, StartedAt: run.StartedAt}
+		for _, v := range run.Variants {
+			if v.Progress == nil {
+				continue
+			}
+			if status.Running.Progress == nil {
+				status.Running.Progress = make(map[string]ScanProgress)
+			}
+			status.Running.Progress[v.Variant] = *v.Progress
+		}
+	}
+
+	if state, err := loadState(); err != nil {
+		log.Printf("⚠️  Could not load scheduler state: %v", err)
+	} else {
+		if !state.LastSuccessfulRun.IsZero() {
+			last := state.LastSuccessfulRun.UTC()
+			status.LastSuccessfulRun = &last
+		}
+		status.ConsecutiveFailures = state.ConsecutiveFailures
+	}
+
+	last, err := readLastRun()
+	if err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	status.LastRun = last
+	return status
+}
+
+// saveStatus rewrites the status file, recording cycle as the last run when it is
+// not nil. The file is refreshed at startup, after each cycle and when scheduling is
+// paused, resumed or reloaded.
+func (s *Scheduler) saveStatus(cycle *CycleResult) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	status := s.Status()
+	status.Running = nil
+	if cycle != nil {
+		status.LastRun = newLastRun(cycle)
+	}
+
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err == nil {
+		tmp := statusFilePath() + ".tmp"
+		if err = os.WriteFile(tmp, append(data, '\n'), 0o644); err == nil {
+			err = os.Rename(tmp, statusFilePath())
+		}
+	}
+	if err != nil {
+		log.Printf("⚠️  Could not write the status file: %v", err)
+	}
+}
+
+// statusHandler serves GET /status
+func statusHandler(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Status())
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// tenantsDir holds the report directories of the tenants' variants, under reportsPath
+const tenantsDir = "tenants"
+
+// TenantConfig groups the variants of one team, so a scheduler can serve several
+// teams with their own schedule, database, report directory and notifications
+type TenantConfig struct {
+	Name     string   `json:"name"`
+	Variants []string `json:"variants"`
+	// Schedule scans the tenant's variants on a cron schedule of their own instead of
+	// SCAN_SCHEDULE
+	Schedule string `json:"schedule,omitempty"`
+	// Env is added to the commands of each of the tenant's variants, under the
+	// variant's own env, e.g. DB_NAME for a database per tenant
+	Env map[string]string `json:"env,omitempty"`
+	// Notifications posts the tenant's variants of each cycle to a webhook of its own
+	Notifications *NotificationConfig `json:"notifications,omitempty"`
+	// EmailRecipients are emailed the tenant's variants of each cycle
+	EmailRecipients []EmailRecipients `json:"email_recipients,omitempty"`
+}
+
+// validateTenants reports invalid tenant names, schedules and env, unknown variants
+// and variants claimed by two tenants
+func (c *Config) validateTenants() []error {
+	var errs []error
+	seen := make(map[string]bool)
+	owner := make(map[string]string)
+	for _, t := range c.Tenants {
+		if !variantNamePattern.MatchString(t.Name) {
+			errs = append(errs, fmt.Errorf("invalid tenant name %q: use lowercase letters, digits and dashes", t.Name))
+		}
+		if seen[t.Name] {
+			errs = append(errs, fmt.Errorf("tenant %q configured more than once", t.Name))
+		}
+		seen[t.Name] = true
+		if len(t.Variants) == 0 {
+			errs = append(errs, fmt.Errorf("tenant %q has no variants", t.Name))
+		}
+		for _, v := range t.Variants {
+			switch {
+			case !slices.Contains(c.VariantNames(), v):
+				errs = append(errs, fmt.Errorf("tenant %q has an unknown variant %q", t.Name, v))
+			case owner[v] != "":
+				errs = append(errs, fmt.Errorf("variant %q belongs to both tenant %q and tenant %q", v, owner[v], t.Name))
+			default:
+				owner[v] = t.Name
+			}
+		}
+		if t.Schedule != "" {
+			if _, err := cron.ParseStandard(t.Schedule); err != nil {
+				errs = append(errs, fmt.Errorf("tenant %q has an invalid schedule %q: %w", t.Name, t.Schedule, err))
+			}
+		}
+		for _, name := range sortedKeys(t.Env) {
+			switch {
+			case !envVarName.MatchString(name):
+				errs = append(errs, fmt.Errorf("tenant %q env has an invalid variable name %q", t.Name, name))
+			case name == "DB_PORT":
+				if port, err := strconv.Atoi(t.Env[name]); err != nil || port <= 0 || port > 65535 {
+					errs = append(errs, fmt.Errorf("tenant %q env has an invalid DB_PORT %q", t.Name, t.Env[name]))
+				}
+			case reservedEnvVar(name):
+				errs = append(errs, fmt.Errorf("tenant %q env can't set %s: the scheduler sets it", t.Name, name))
+			case name == "DOCKER_CONFIG":
+				for _, v := range c.Variants {
+					if v.RegistryAuth != nil && slices.Contains(t.Variants, v.Name) {
+						errs = append(errs, fmt.Errorf("tenant %q env can't set DOCKER_CONFIG: variant %q has registry_auth", t.Name, v.Name))
+					}
+				}
+			}
+		}
+		if n := t.Notifications; n != nil && n.On != "" && n.On != notifyOnFailure && n.On != notifyAlways {
+			errs = append(errs, fmt.Errorf("tenant %q: invalid notification setting on=%q: must be %q or %q", t.Name, n.On, notifyOnFailure, notifyAlways))
+		}
+		if len(t.EmailRecipients) > 0 {
+			email := c.Email
+			email.Recipients = t.EmailRecipients
+			for _, err := range email.Validate() {
+				errs = append(errs, fmt.Errorf("tenant %q: %w", t.Name, err))
+			}
+		}
+	}
+	if len(c.Tenants) > 0 && slices.Contains(c.VariantNames(), tenantsDir) {
+		errs = append(errs, fmt.Errorf("variant name %q is reserved for the tenants' report directories", tenantsDir))
+	}
+	return errs
+}
+
+// TenantOf returns the tenant a variant belongs to, or nil
+func (c *Config) TenantOf(variant string) *TenantConfig {
+	for i, t := range c.Tenants {
+		if slices.Contains(t.Variants, variant) {
+			return &c.Tenants[i]
+		}
+	}
+	return nil
+}
+
+// Tenant returns the named tenant, or nil
+func (c *Config) Tenant(name string) *TenantConfig {
+	for i, t := range c.Tenants {
+		if t.Name == name {
+			return &c.Tenants[i]
+		}
+	}
+	return nil
+}
+
+// ScheduledVariants returns the variants scanned on SCAN_SCHEDULE: all of them but
+// those of tenants with a schedule of their own
+func (c *Config) ScheduledVariants() []string {
+	var variants []string
+	for _, v := range c.VariantNames() {
+		if t := c.TenantOf(v); t == nil || t.Schedule == "" {
+			variants = append(variants, v)
+		}
+	}
+	return variants
+}
+
+// coversScheduledVariants reports whether variants include every variant scanned on
+// SCAN_SCHEDULE
+func (c *Config) coversScheduledVariants(variants []string) bool {
+	scheduled := c.ScheduledVariants()
+	if len(scheduled) == 0 {
+		return false
+	}
+	for _, v := range scheduled {
+		if !slices.Contains(variants, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// TenantDatabase is a database scan results are loaded into; Tenant names the tenant
+// whose env points its variants at it, and is empty for the DB_* database
+type TenantDatabase struct {
+	Tenant string
+	DB     DBConfig
+}
+
+// Databases returns the DB_* database followed by each other database a tenant's
+// env points its variants at, for the maintenance run against every database
+func (c *Config) Databases() []TenantDatabase {
+	dbs := []TenantDatabase{{DB: c.DB}}
+	seen := map[string]bool{c.DB.key(): true}
+	for _, t := range c.Tenants {
+		db := c.DB.withEnv(t.Env)
+		if seen[db.key()] {
+			continue
+		}
+		seen[db.key()] = true
+		dbs = append(dbs, TenantDatabase{Tenant: t.Name, DB: db})
+	}
+	return dbs
+}
+
+// withEnv returns the settings with the DB_* variables of env applied, the way the
+// loader scripts of a tenant's variants see them
+func (d DBConfig) withEnv(env map[string]string) DBConfig {
+	for name, value := range env {
+		switch name {
+		case "DB_BACKEND":
+			d.Backend = value
+		case "DB_PATH":
+			d.Path = value
+		case "DB_HOST":
+			d.Host = value
+		case "DB_PORT":
+			if port, err := strconv.Atoi(value); err == nil {
+				d.Port = port
+			}
+		case "DB_NAME":
+			d.Name = value
+		case "DB_USER":
+			d.User = value
+		case "DB_PASSWORD":
+			d.Password = value
+		case "DB_SCHEMA_PER_VARIANT":
+			d.SchemaPerVariant = value == "true"
+		}
+	}
+	return d
+}
+
+// key identifies the database the settings point at
+func (d DBConfig) key() string {
+	if d.Backend == backendSQLite {
+		return backendSQLite + ":" + d.SQLitePath()
+	}
+	return fmt.Sprintf("%s:%d/%s", d.Host, d.Port, d.Name)
+}
+
+// qualifiedSchema names a schema of a tenant's database in logs, e.g. acme/public
+func qualifiedSchema(tenant, schema string) string {
+	if tenant == "" {
+		return schema
+	}
+	return tenant + "/" + schema
+}
+
+// tenantEnv returns the env a variant inherits from its tenant, without the
+// variables the variant sets itself in env or secret_files
+func (c *Config) tenantEnv(v VariantConfig) map[string]string {
+	t := c.TenantOf(v.Name)
+	if t == nil {
+		return nil
+	}
+	env := maps.Clone(t.Env)
+	for k := range v.Env {
+		delete(env, k)
+	}
+	for k := range v.SecretFiles {
+		delete(env, k)
+	}
+	return env
+}
+
+var (
+	variantTenantsMu sync.RWMutex
+	// variantTenants maps the tenants' variants to their tenant, for variantDir
+	variantTenants map[string]string
+)
+
+// setVariantTenants records which tenant each variant belongs to, so its reports
+// are kept in the tenant's directory. Called when the configuration is (re)loaded.
+func setVariantTenants(tenants []TenantConfig) {
+	m := make(map[string]string)
+	for _, t := range tenants {
+		for _, v := range t.Variants {
+			m[v] = t.Name
+		}
+	}
+	variantTenantsMu.Lock()
+	defer variantTenantsMu.Unlock()
+	variantTenants = m
+}
+
+// variantDir is the reports directory of a variant: reports/{variant}, or
+// reports/tenants/{tenant}/{variant} for a tenant's variant
+func variantDir(variant string) string {
+	return filepath.Join(variantReportsRoot(variant), variant)
+}
+
+// variantReportsRoot is the REPORTS_PATH the pipeline scripts of a variant get,
+// which they add the variant's name to
+func variantReportsRoot(variant string) string {
+	variantTenantsMu.RLock()
+	defer variantTenantsMu.RUnlock()
+	if t := variantTenants[variant]; t != "" {
+		return filepath.Join(reportsPath, tenantsDir, t)
+	}
+	return reportsPath
+}
+
+// forTenant returns the part of a cycle covering a tenant's variants, with the
+// status those variants alone give, or nil when the cycle scanned none of them
+func (c *CycleResult) forTenant(t TenantConfig) *CycleResult {
+	part := *c
+	part.Tenant = t.Name
+	part.Variants = nil
+	for _, v := range c.Variants {
+		if slices.Contains(t.Variants, v.Variant) {
+			part.Variants = append(part.Variants, v)
+		}
+	}
+	if len(part.Variants) == 0 {
+		return nil
+	}
+	finished := part.FinishedAt
+	part.finish()
+	part.FinishedAt = finished
+	return &part
+}
+
+// notifyTenants sends each tenant with notifications or email recipients of its
+// own the part of a cycle covering its variants
+func notifyTenants(cfg *Config, cycle *CycleResult) {
+	for _, t := range cfg.Tenants {
+		if t.Notifications == nil && len(t.EmailRecipients) == 0 {
+			continue
+		}
+		part := cycle.forTenant(t)
+		if part == nil {
+			continue
+		}
+		if t.Notifications != nil {
+			n := *t.Notifications
+			if n.On == "" {
+				n.On = notifyOnFailure
+			}
+			notifyCycle(n, part)
+		}
+		if len(t.EmailRecipients) > 0 {
+			email := cfg.Email
+			email.Recipients = t.EmailRecipients
+			emailCycle(email, part)
+		}
+		log.Printf("📣 Tenant %s notified of its %d variant(s)", t.Name, len(part.Variants))
+	}
+}
+
+// tenantSchedules maps the tenants with a schedule of their own to it
+func tenantSchedules(cfg *Config) map[string]string {
+	schedules := make(map[string]string)
+	for _, t := range cfg.Tenants {
+		if t.Schedule != "" {
+			schedules[t.Name] = t.Schedule
+		}
+	}
+	return schedules
+}
+
+// describeTenantSchedules lists the tenant schedules for logs, e.g. "team-a: 0 3 * * *"
+func describeTenantSchedules(cfg *Config) string {
+	schedules := tenantSchedules(cfg)
+	if len(schedules) == 0 {
+		return "none"
+	}
+	parts := make([]string, 0, len(schedules))
+	for _, name := range sortedKeys(schedules) {
+		parts = append(parts, name+": "+schedules[name])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// scheduleTenants replaces the cron entries of the tenants with a schedule of their
+// own by those of cfg. Callers hold s.mu.
+func (s *Scheduler) scheduleTenants(cfg *Config) error {
+	ids, err := s.addTenantEntries(cfg)
+	if err != nil {
+		return err
+	}
+	s.replaceTenantEntries(ids)
+	return nil
+}
+
+// addTenantEntries adds the cron entries of cfg's tenants with a schedule of their
+// own, next to the current ones. On failure none of them is left behind.
+func (s *Scheduler) addTenantEntries(cfg *Config) (map[string]cron.EntryID, error) {
+	ids := make(map[string]cron.EntryID)
+	for _, t := range cfg.Tenants {
+		if t.Schedule == "" {
+			continue
+		}
+		name := t.Name
+		id, err := s.cron.AddFunc(t.Schedule, func() {
+			s.jitter()
+			s.runTenantCycle(name)
+		})
+		if err != nil {
+			for _, id := range ids {
+				s.cron.Remove(id)
+			}
+			return nil, fmt.Errorf("failed to schedule tenant %s: %w", name, err)
+		}
+		ids[name] = id
+	}
+	return ids, nil
+}
+
+// replaceTenantEntries removes the current tenant entries in favour of ids
+func (s *Scheduler) replaceTenantEntries(ids map[string]cron.EntryID) {
+	for _, id := range s.tenantIDs {
+		s.cron.Remove(id)
+	}
+	s.tenantIDs = ids
+}
+
+// runTenantCycle runs a cycle over the variants of a tenant with a schedule of its own
+func (s *Scheduler) runTenantCycle(name string) {
+	if s.Paused() {
+		log.Printf("⏸️  Scheduling is paused, skipping the cycle of tenant %s", name)
+		return
+	}
+	if !s.elector.IsLeader() {
+		log.Printf("💤 Standby replica (not the leader), skipping the cycle of tenant %s", name)
+		return
+	}
+	cfg := s.Config()
+	t := cfg.Tenant(name)
+	if t == nil || t.Schedule == "" {
+		return
+	}
+
+	defer s.lockCycle()()
+	s.waitForWarmup(cfg)
+	log.Printf("🏢 Scheduled cycle of tenant %s (%s)", name, strings.Join(t.Variants, ", "))
+	heartbeatCycleStart(cfg.Heartbeat, cfg.DryRun)
+	s.completeCycle(cfg, RunFullScanCycle(cfg, t.Variants, newRunID(time.Now())), false)
+}
+
+// TenantNextRuns returns the next scheduled cycle of each tenant with a schedule of
+// its own
+func (s *Scheduler) TenantNextRuns() map[string]time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	next := make(map[string]time.Time)
+	for name, id := range s.tenantIDs {
+		if at := s.cron.Entry(id).Next; !at.IsZero() {
+			next[name] = at
+		}
+	}
+	return next
+}
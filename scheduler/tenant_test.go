@@ -0,0 +1,212 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// useReportsDir points reportsPath at a temporary directory for the test
+func useReportsDir(t *testing.T) string {
+	dir := t.TempDir()
+	old := reportsPath
+	reportsPath = dir
+	t.Cleanup(func() { reportsPath = old })
+	return dir
+}
+
+func tenantTestConfig() *Config {
+	return &Config{
+		Schedule: "0 2 * * *",
+		Variants: []VariantConfig{{Name: "shop"}, {Name: "pay"}, {Name: "blog"}},
+		Tenants: []TenantConfig{
+			{Name: "acme", Variants: []string{"shop", "pay"}, Schedule: "0 6 * * *", Env: map[string]string{"DB_NAME": "acme"}},
+			{Name: "news", Variants: []string{"blog"}},
+		},
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	if errs := tenantTestConfig().validateTenants(); len(errs) != 0 {
+		t.Fatalf("valid tenants rejected: %v", errs)
+	}
+
+	for name, tc := range map[string]struct {
+		edit func(*Config)
+		want string
+	}{
+		"invalid name":    {func(c *Config) { c.Tenants[0].Name = "Acme Corp" }, "invalid tenant name"},
+		"duplicate":       {func(c *Config) { c.Tenants[1].Name = "acme" }, "configured more than once"},
+		"no variants":     {func(c *Config) { c.Tenants[1].Variants = nil }, "has no variants"},
+		"unknown variant": {func(c *Config) { c.Tenants[1].Variants = []string{"wiki"} }, `unknown variant "wiki"`},
+		"shared variant":  {func(c *Config) { c.Tenants[1].Variants = []string{"blog", "shop"} }, `variant "shop" belongs to both`},
+		"bad schedule":    {func(c *Config) { c.Tenants[0].Schedule = "every day" }, "invalid schedule"},
+		"bad env name":    {func(c *Config) { c.Tenants[0].Env["DB-NAME"] = "x" }, "invalid variable name"},
+		"reserved env":    {func(c *Config) { c.Tenants[0].Env["REPORTS_PATH"] = "/tmp" }, "the scheduler sets it"},
+		"bad DB_PORT":     {func(c *Config) { c.Tenants[0].Env["DB_PORT"] = "54x" }, "invalid DB_PORT"},
+		"bad notify on":   {func(c *Config) { c.Tenants[0].Notifications = &NotificationConfig{On: "sometimes"} }, "invalid notification setting"},
+		"reserved variant": {func(c *Config) {
+			c.Variants = append(c.Variants, VariantConfig{Name: tenantsDir})
+		}, "reserved for the tenants' report directories"},
+	} {
+		cfg := tenantTestConfig()
+		tc.edit(cfg)
+		errs := cfg.validateTenants()
+		if !slices.ContainsFunc(errs, func(err error) bool { return strings.Contains(err.Error(), tc.want) }) {
+			t.Errorf("%s: errors %v, want one containing %q", name, errs, tc.want)
+		}
+	}
+}
+
+func TestScheduledVariants(t *testing.T) {
+	cfg := tenantTestConfig()
+	if got := cfg.ScheduledVariants(); !slices.Equal(got, []string{"blog"}) {
+		t.Errorf("ScheduledVariants = %v, want the variants of tenants without a schedule", got)
+	}
+	for _, tc := range []struct {
+		variants []string
+		want     bool
+	}{
+		{[]string{"shop", "pay", "blog"}, true},
+		{[]string{"blog"}, true},
+		{[]string{"shop", "pay"}, false},
+		{nil, false},
+	} {
+		if got := cfg.coversScheduledVariants(tc.variants); got != tc.want {
+			t.Errorf("coversScheduledVariants(%v) = %v, want %v", tc.variants, got, tc.want)
+		}
+	}
+
+	cfg.Tenants[1].Schedule = "0 7 * * *"
+	if got := cfg.ScheduledVariants(); len(got) != 0 || cfg.coversScheduledVariants(cfg.VariantNames()) {
+		t.Errorf("with every tenant scheduled ScheduledVariants = %v, want none covered", got)
+	}
+}
+
+func TestScheduleTenants(t *testing.T) {
+	cfg := tenantTestConfig()
+	s := &Scheduler{cfg: cfg, cron: cron.New()}
+	if err := s.scheduleTenants(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.tenantIDs) != 1 || s.tenantIDs["acme"] == 0 {
+		t.Fatalf("tenant entries = %v, want acme only", s.tenantIDs)
+	}
+	s.cron.Start()
+	defer s.cron.Stop()
+	next := s.TenantNextRuns()
+	if at, ok := next["acme"]; !ok || at.Hour() != 6 || at.Minute() != 0 {
+		t.Errorf("TenantNextRuns = %v, want acme at 06:00", next)
+	}
+
+	// A new schedule replaces the entries instead of adding to them
+	cfg = tenantTestConfig()
+	cfg.Tenants[0].Schedule = ""
+	cfg.Tenants[1].Schedule = "30 1 * * *"
+	if err := s.scheduleTenants(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.cron.Entries()) != 1 || s.tenantIDs["news"] == 0 || s.tenantIDs["acme"] != 0 {
+		t.Errorf("after rescheduling: %d entries, ids %v, want news only", len(s.cron.Entries()), s.tenantIDs)
+	}
+	if got := describeTenantSchedules(cfg); got != "news: 30 1 * * *" {
+		t.Errorf("describeTenantSchedules = %q", got)
+	}
+}
+
+func TestTenantReportDirs(t *testing.T) {
+	dir := useReportsDir(t)
+	setVariantTenants(tenantTestConfig().Tenants)
+	t.Cleanup(func() { setVariantTenants(nil) })
+	if got, want := variantDir("shop"), filepath.Join(dir, tenantsDir, "acme", "shop"); got != want {
+		t.Errorf("variantDir(shop) = %s, want %s", got, want)
+	}
+	if got, want := variantDir("other"), filepath.Join(dir, "other"); got != want {
+		t.Errorf("variantDir(other) = %s, want %s", got, want)
+	}
+}
+
+func TestForTenant(t *testing.T) {
+	cycle := &CycleResult{RunID: "run-1", Variants: []VariantResult{
+		{Variant: "shop", Success: true},
+		{Variant: "pay", Success: false},
+		{Variant: "blog", Success: true},
+	}}
+	cfg := tenantTestConfig()
+	acme := cycle.forTenant(cfg.Tenants[0])
+	if acme == nil || len(acme.Variants) != 2 || acme.Tenant != "acme" || acme.Status != cyclePartial {
+		t.Errorf("acme's part = %+v, want shop and pay, partial", acme)
+	}
+	news := cycle.forTenant(cfg.Tenants[1])
+	if news == nil || news.Status != cycleSuccess {
+		t.Errorf("news's part = %+v, want a success", news)
+	}
+	if part := (&CycleResult{Variants: []VariantResult{{Variant: "blog"}}}).forTenant(cfg.Tenants[0]); part != nil {
+		t.Errorf("a cycle without the tenant's variants gave %+v", part)
+	}
+}
+
+func TestDatabases(t *testing.T) {
+	cfg := tenantTestConfig()
+	cfg.DB = DBConfig{Host: "postgres", Port: 5432, Name: "vulndb", User: "vulnuser", Password: "vulnpass"}
+	cfg.Tenants[0].Env = map[string]string{"DB_NAME": "acme", "DB_USER": "acme", "DB_PASSWORD": "acme-pass", "DB_PORT": "6432"}
+	cfg.Tenants = append(cfg.Tenants,
+		TenantConfig{Name: "shared", Env: map[string]string{"DB_NAME": "vulndb"}},
+		TenantConfig{Name: "acme-too", Env: map[string]string{"DB_NAME": "acme", "DB_PORT": "6432"}},
+	)
+
+	dbs := cfg.Databases()
+	if len(dbs) != 2 {
+		t.Fatalf("Databases = %+v, want DB_* and acme's", dbs)
+	}
+	if dbs[0].Tenant != "" || dbs[0].DB != cfg.DB {
+		t.Errorf("first database = %+v, want DB_*", dbs[0])
+	}
+	acme := dbs[1]
+	if acme.Tenant != "acme" || acme.DB.Name != "acme" || acme.DB.User != "acme" || acme.DB.Password != "acme-pass" || acme.DB.Port != 6432 || acme.DB.Host != "postgres" {
+		t.Errorf("acme's database = %+v", acme)
+	}
+}
+
+func TestIntegrityCheckCoversTenantDatabases(t *testing.T) {
+	useReportsDir(t)
+	cfg := tenantTestConfig()
+	cfg.DB = DBConfig{Backend: backendSQLite}
+	cfg.Tenants[0].Env = map[string]string{"DB_PATH": "/data/acme.sqlite"}
+
+	// Both databases are checked, so both report that SQLite can't be
+	report := runIntegrityCheck(cfg, false)
+	if len(report.Errors) != 2 || !strings.Contains(report.Errors[1], "database of tenant acme") {
+		t.Errorf("errors = %q, want one per database", report.Errors)
+	}
+}
+
+// TestCompleteCycleRecordsScheduledRuns only counts cycles over the scheduled
+// variants towards missed-run detection
+func TestCompleteCycleRecordsScheduledRuns(t *testing.T) {
+	useReportsDir(t)
+	cfg := tenantTestConfig()
+	s := newScheduler(cfg)
+	success := func(variants ...string) *CycleResult {
+		cycle := &CycleResult{RunID: newRunID(time.Now()), StartedAt: time.Now()}
+		for _, v := range variants {
+			cycle.Variants = append(cycle.Variants, VariantResult{Variant: v, Success: true})
+		}
+		cycle.finish()
+		return cycle
+	}
+
+	s.completeCycle(cfg, success("shop", "pay"), false)
+	if state, err := loadState(); err != nil || !state.LastSuccessfulRun.IsZero() {
+		t.Fatalf("a tenant cycle recorded a successful run: %+v, %v", state, err)
+	}
+	s.completeCycle(cfg, success("blog"), true)
+	state, err := loadState()
+	if err != nil || state.LastSuccessfulRun.IsZero() {
+		t.Fatalf("the scheduled cycle was not recorded: %+v, %v", state, err)
+	}
+}
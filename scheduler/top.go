@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	topRecentEvents = 10
+	// topOverviewRows is how many upcoming runs, past runs and events the overview lists
+	topOverviewRows = 5
+	clearScreen     = "\033[H\033[2J"
+
+	// Escape sequences of the interactive console
+	altScreenOn  = "\033[?1049h\033[?25l"
+	altScreenOff = "\033[?25h\033[?1049l"
+	cursorHome   = "\033[H"
+	clearLine    = "\033[K"
+	clearBelow   = "\033[J"
+	reverseVideo = "\033[7m"
+	resetVideo   = "\033[0m"
+)
+
+// topView is a screen of the interactive console
+type topView int
+
+const (
+	topOverview topView = iota
+	topImages
+	topRuns
+	topEvents
+)
+
+var topViewNames = []string{"Overview", "Images", "Runs", "Events"}
+
+// topCommand implements `scheduler top`: a live view of a running scheduler, polling
+// GET /scheduler/activity of its HTTP API. On a terminal it is an interactive console
+// with a screen each for the overview, the images being scanned, the past and
+// upcoming runs and the events.
+func topCommand(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("top", flag.ContinueOnError)
+	addr := fs.String("addr", localAPIURL(cfg.APIAddr, cfg.APIAuth.TLSCert != ""), "base URL of the scheduler API")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+	once := fs.Bool("once", false, "print the current activity once and exit")
+	plain := fs.Bool("plain", false, "redraw a plain screen instead of the interactive console")
+	token := fs.String("token", os.Getenv("API_TOKEN"), "bearer token of the scheduler API (default: API_TOKEN)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "--interval must be positive")
+		return exitUsage
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	url := strings.TrimSuffix(*addr, "/") + "/scheduler/activity"
+	fetch := func() (*ActivitySnapshot, error) { return fetchActivity(client, url, *token) }
+	if *once {
+		snap, err := fetch()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return exitFailure
+		}
+		writeActivityText(os.Stdout, *addr, snap)
+		return exitSuccess
+	}
+
+	if !*plain && isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+		term, err := openTerminal()
+		if err == nil {
+			defer term.Restore()
+			runConsole(term, *addr, *interval, fetch)
+			return exitSuccess
+		}
+		fmt.Fprintf(os.Stderr, "⚠️  No interactive console (%v), falling back to --plain\n", err)
+	}
+
+	for {
+		snap, err := fetch()
+		fmt.Print(clearScreen)
+		if err != nil {
+			fmt.Printf("scheduler top — %s\n\n❌ %v\nRetrying every %s (Ctrl-C to quit)\n", *addr, err, *interval)
+		} else {
+			writeActivityText(os.Stdout, *addr, snap)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// topFetch is the outcome of one poll of the activity
+type topFetch struct {
+	snap *ActivitySnapshot
+	err  error
+}
+
+// runConsole runs the interactive console until q or Ctrl-C. The activity is polled
+// in the background so keys stay responsive when the API is slow.
+func runConsole(term *terminal, addr string, interval time.Duration, fetch func() (*ActivitySnapshot, error)) {
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			} else if n == 1 {
+				keys <- buf[0]
+			}
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	results := make(chan topFetch, 1)
+	fetching := false
+	poll := func() {
+		if fetching {
+			return
+		}
+		fetching = true
+		go func() {
+			snap, err := fetch()
+			results <- topFetch{snap, err}
+		}()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last topFetch
+	view := topOverview
+	poll()
+	for {
+		select {
+		case <-signals:
+			return
+		case <-ticker.C:
+			poll()
+			continue
+		case res := <-results:
+			fetching = false
+			if res.err == nil {
+				last.snap = res.snap
+			}
+			last.err = res.err
+		case key, ok := <-keys:
+			if !ok {
+				return
+			}
+			switch key {
+			case 'q', 'Q':
+				return
+			case 'r', 'R':
+				poll()
+			case '\t':
+				view = (view + 1) % topView(len(topViewNames))
+			case '1', '2', '3', '4':
+				view = topView(key - '1')
+			default:
+				continue
+			}
+		}
+		rows, cols := term.Size()
+		term.Draw(renderConsole(addr, view, last, interval, rows), rows, cols)
+	}
+}
+
+// renderConsole renders a view of the interactive console, with its tabs and keys.
+// The events view lists the most recent events that fit in rows.
+func renderConsole(addr string, view topView, last topFetch, interval time.Duration, rows int) []byte {
+	var b bytes.Buffer
+	for i, name := range topViewNames {
+		if topView(i) == view {
+			fmt.Fprintf(&b, "%s %d %s %s ", reverseVideo, i+1, name, resetVideo)
+		} else {
+			fmt.Fprintf(&b, " %d %s  ", i+1, name)
+		}
+	}
+	fmt.Fprint(&b, "  tab next · r refresh · q quit\n\n")
+
+	if last.err != nil {
+		fmt.Fprintf(&b, "❌ %v\nRetrying every %s\n\n", last.err, interval)
+	}
+	snap := last.snap
+	if snap == nil {
+		if last.err == nil {
+			fmt.Fprintf(&b, "Connecting to %s...\n", addr)
+		}
+		return b.Bytes()
+	}
+
+	writeTopHeader(&b, addr, snap)
+	switch view {
+	case topOverview:
+		writeRunProgress(&b, snap)
+		writeUpcomingRuns(&b, snap, topOverviewRows)
+		writeRunHistory(&b, snap, topOverviewRows)
+		writeRecentEvents(&b, snap, topOverviewRows)
+	case topImages:
+		if !writeRunImages(&b, snap.Run) {
+			fmt.Fprintln(&b, "No variant is being scanned by the scheduler itself")
+		}
+	case topRuns:
+		writeUpcomingRuns(&b, snap, len(snap.Upcoming))
+		writeRunHistory(&b, snap, len(snap.History))
+	case topEvents:
+		writeRecentEvents(&b, snap, max(rows-bytes.Count(b.Bytes(), []byte("\n"))-2, 1))
+	}
+	return b.Bytes()
+}
+
+// localAPIURL turns an API listen address like ":8080" into a URL on this host
+func localAPIURL(listen string, tls bool) string {
+	scheme := "http://"
+	if tls {
+		scheme = "https://"
+	}
+	if strings.HasPrefix(listen, ":") {
+		return scheme + "localhost" + listen
+	}
+	return scheme + listen
+}
+
+func fetchActivity(client *http.Client, url, token string) (*ActivitySnapshot, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("scheduler API unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	var snap ActivitySnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("invalid activity response: %w", err)
+	}
+	return &snap, nil
+}
+
+// writeActivityText renders an activity snapshot as a terminal screen
+func writeActivityText(w io.Writer, addr string, snap *ActivitySnapshot) {
+	writeTopHeader(w, addr, snap)
+	writeRunProgress(w, snap)
+	writeRunImages(w, snap.Run)
+	writeUpcomingRuns(w, snap, topOverviewRows)
+	writeRunHistory(w, snap, topOverviewRows)
+	writeRecentEvents(w, snap, topRecentEvents)
+}
+
+// writeTopHeader writes the scheduler's state: leader, paused, queue and next run
+func writeTopHeader(w io.Writer, addr string, snap *ActivitySnapshot) {
+	fmt.Fprintf(w, "scheduler top — %s   %s\n", addr, snap.Time.Format("15:04:05 MST"))
+	fmt.Fprintf(w, "Leader: %s   Paused: %s   Queue: %s (%d waiting)",
+		yesNo(snap.Leader), yesNo(snap.Paused), snap.QueueMode, snap.QueueDepth)
+	if !snap.NextRun.IsZero() && !snap.Paused {
+		fmt.Fprintf(w, "   Next run: %s", snap.NextRun.Format(time.RFC3339))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w)
+}
+
+// writeRunProgress writes the current or last cycle with the status of each variant
+func writeRunProgress(w io.Writer, snap *ActivitySnapshot) {
+	run := snap.Run
+	if run == nil {
+		fmt.Fprintln(w, "No scan cycle since the scheduler started")
+		return
+	}
+	if run.FinishedAt != nil {
+		fmt.Fprintf(w, "Last run %s: %s after %s (finished %s ago)\n", run.RunID, run.Status,
+			run.FinishedAt.Sub(run.StartedAt).Round(time.Second), snap.Time.Sub(*run.FinishedAt).Round(time.Second))
+	} else {
+		fmt.Fprintf(w, "Run %s: running for %s", run.RunID, snap.Time.Sub(run.StartedAt).Round(time.Second))
+		if run.Deadline != nil {
+			fmt.Fprintf(w, " (time budget ends in %s)", run.Deadline.Sub(snap.Time).Round(time.Second))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VARIANT\tSTATUS\tSTEP\tIMAGES\tRUNNING FOR")
+	for _, v := range run.Variants {
+		elapsed := ""
+		if v.StartedAt != nil && v.Status == jobRunning {
+			elapsed = snap.Time.Sub(*v.StartedAt).Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", v.Variant, v.Status, v.Step, imageProgress(v), elapsed)
+	}
+	tw.Flush()
+}
+
+// writeRunImages writes the images of each running variant and reports whether
+// there were any
+func writeRunImages(w io.Writer, run *RunActivity) bool {
+	if run == nil {
+		return false
+	}
+	shown := false
+	for _, v := range run.Variants {
+		if v.Status != jobRunning || len(v.Images) == 0 {
+			continue
+		}
+		shown = true
+		fmt.Fprintf(w, "\n%s images\n", v.Variant)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  IMAGE\tSTATUS\tVULNS")
+		for _, img := range v.Images {
+			vulns := ""
+			if img.Vulnerabilities != nil {
+				vulns = fmt.Sprint(*img.Vulnerabilities)
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", img.Image, img.Status, vulns)
+		}
+		tw.Flush()
+	}
+	return shown
+}
+
+// writeUpcomingRuns writes up to n of the next scheduled cycles
+func writeUpcomingRuns(w io.Writer, snap *ActivitySnapshot, n int) {
+	fmt.Fprintln(w, "\nNext runs")
+	switch {
+	case snap.Paused:
+		fmt.Fprintln(w, "  (scheduling is paused)")
+		return
+	case len(snap.Upcoming) == 0:
+		fmt.Fprintln(w, "  (none)")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, r := range snap.Upcoming[:min(n, len(snap.Upcoming))] {
+		scope := "all"
+		if r.Tenant != "" {
+			scope = "tenant " + r.Tenant
+		}
+		in := strings.TrimSuffix(r.Time.Sub(snap.Time).Round(time.Minute).String(), "0s")
+		fmt.Fprintf(tw, "  %s\tin %s\t%s\t%s\n", r.Time.Local().Format("Mon 15:04"), in, scope, strings.Join(r.Variants, ", "))
+	}
+	tw.Flush()
+}
+
+// writeRunHistory writes up to n of the cycles finished since the scheduler started
+func writeRunHistory(w io.Writer, snap *ActivitySnapshot, n int) {
+	fmt.Fprintln(w, "\nRecent runs")
+	if len(snap.History) == 0 {
+		fmt.Fprintln(w, "  (none since the scheduler started)")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, r := range snap.History[:min(n, len(snap.History))] {
+		failed := ""
+		if len(r.Failed) > 0 {
+			failed = "failed: " + strings.Join(r.Failed, ", ")
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%d variant(s)\t%s\t%s\n", r.StartedAt.Local().Format("Jan 02 15:04"), r.RunID,
+			r.Status, len(r.Variants), r.FinishedAt.Sub(r.StartedAt).Round(time.Second), failed)
+	}
+	tw.Flush()
+}
+
+// writeRecentEvents writes up to n of the most recent events
+func writeRecentEvents(w io.Writer, snap *ActivitySnapshot, n int) {
+	fmt.Fprintln(w, "\nRecent events")
+	events := snap.Events
+	if len(events) > n {
+		events = events[len(events)-n:]
+	}
+	if len(events) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, e := range events {
+		fmt.Fprintf(w, "  %s  %s\n", e.Time.Local().Format("15:04:05"), e.Message)
+	}
+}
+
+// imageProgress summarizes how many of a variant's images are done
+func imageProgress(v *VariantActivity) string {
+	if v.Progress != nil {
+		return fmt.Sprintf("%d/%d", v.Progress.Done, v.Progress.Total)
+	}
+	if len(v.Images) == 0 {
+		return ""
+	}
+	done := 0
+	for _, img := range v.Images {
+		switch img.Status {
+		case imageScanned, imageUnchanged, imageSkipped, imageExcluded, imageFailed:
+			done++
+		}
+	}
+	return fmt.Sprintf("%d/%d", done, len(v.Images))
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// terminal is the terminal of the interactive console, switched with stty to read
+// single keys without echo and to an alternate screen restored on exit
+type terminal struct {
+	saved string
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func openTerminal() (*terminal, error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1", "time", "0"); err != nil {
+		return nil, err
+	}
+	fmt.Print(altScreenOn)
+	return &terminal{saved: strings.TrimSpace(saved)}, nil
+}
+
+// Restore leaves the alternate screen and restores the terminal settings
+func (t *terminal) Restore() {
+	fmt.Print(altScreenOff)
+	stty(t.saved)
+}
+
+// Size returns the rows and columns of the terminal, 24x80 when unknown
+func (t *terminal) Size() (rows, cols int) {
+	out, err := stty("size")
+	if err == nil {
+		if _, err := fmt.Sscan(out, &rows, &cols); err == nil && rows > 0 && cols > 0 {
+			return rows, cols
+		}
+	}
+	return 24, 80
+}
+
+// Draw redraws the screen in place, cutting the lines that don't fit so the
+// layout doesn't wrap or scroll
+func (t *terminal) Draw(screen []byte, rows, cols int) {
+	var b bytes.Buffer
+	b.WriteString(cursorHome)
+	lines := strings.Split(strings.TrimSuffix(string(screen), "\n"), "\n")
+	for i, line := range lines[:min(len(lines), rows)] {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(truncateLine(line, cols))
+		b.WriteString(clearLine)
+	}
+	b.WriteString(clearBelow)
+	os.Stdout.Write(b.Bytes())
+}
+
+// truncateLine cuts a line to the terminal width, not counting escape sequences
+func truncateLine(line string, cols int) string {
+	width, escape := 0, false
+	for i, r := range line {
+		switch {
+		case r == '\033':
+			escape = true
+		case escape:
+			escape = !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+		default:
+			if width == cols {
+				return line[:i] + resetVideo
+			}
+			width++
+		}
+	}
+	return line
+}
+
+// stty runs stty on the terminal of stdin
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty %s: %w", strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
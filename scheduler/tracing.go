@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTraceServiceName = "scanner-scheduler"
+	traceExportInterval     = 5 * time.Second
+	traceScopeName          = "github.com/vuln-demo/scheduler"
+
+	// OTLP span kinds and status codes
+	spanKindInternal = 1
+	statusOK         = 1
+	statusError      = 2
+)
+
+// TracingConfig selects the OTLP/HTTP collector spans are exported to, following the
+// standard OTEL_* environment variables. Tracing is off without an endpoint.
+type TracingConfig struct {
+	// Endpoint is the full traces URL, e.g. http://tempo:4318/v1/traces
+	Endpoint    string
+	Headers     map[string]string
+	ServiceName string
+}
+
+// tracingConfigFromEnv reads OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or the base
+// OTEL_EXPORTER_OTLP_ENDPOINT with /v1/traces appended, plus the headers and service name
+func tracingConfigFromEnv() TracingConfig {
+	tc := TracingConfig{
+		Endpoint:    envString("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", ""),
+		ServiceName: envString("OTEL_SERVICE_NAME", defaultTraceServiceName),
+		Headers:     make(map[string]string),
+	}
+	if base := envString("OTEL_EXPORTER_OTLP_ENDPOINT", ""); tc.Endpoint == "" && base != "" {
+		tc.Endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	for _, kv := range envList("OTEL_EXPORTER_OTLP_HEADERS") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			if unescaped, err := url.QueryUnescape(v); err == nil {
+				v = unescaped
+			}
+			tc.Headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return tc
+}
+
+// Validate reports an endpoint that is not an http(s) URL
+func (tc TracingConfig) Validate() error {
+	if tc.Endpoint == "" {
+		return nil
+	}
+	if u, err := url.Parse(tc.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid OTLP traces endpoint %q: must be an http(s) URL", tc.Endpoint)
+	}
+	return nil
+}
+
+// traces exports the spans of the scan pipeline; nil while tracing is off, in which
+// case spans are not recorded
+var traces *otlpExporter
+
+// setupTracing starts exporting spans when an OTLP endpoint is configured
+func setupTracing(tc TracingConfig) {
+	if tc.Endpoint == "" || traces != nil {
+		return
+	}
+	traces = &otlpExporter{cfg: tc}
+	go traces.run()
+	log.Printf("📡 Exporting traces to %s", tc.Endpoint)
+}
+
+// spanContext identifies a span across processes
+type spanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// runSpanContext derives the trace and cycle span IDs from a run ID, so the variant
+// spans of external workers and of resumed cycles join the trace of their cycle
+func runSpanContext(runID string) spanContext {
+	sum := sha256.Sum256([]byte("run:" + runID))
+	var sc spanContext
+	copy(sc.TraceID[:], sum[:16])
+	copy(sc.SpanID[:], sum[16:24])
+	return sc
+}
+
+// span is an operation of the scan pipeline. A nil span (tracing off) ignores every call.
+type span struct {
+	spanContext
+	parent [8]byte
+	name   string
+	start  time.Time
+	attrs  map[string]any
+}
+
+// startSpan starts a span under parent, or a new trace when parent is empty
+func startSpan(parent spanContext, name string) *span {
+	if traces == nil {
+		return nil
+	}
+	s := &span{name: name, start: time.Now(), attrs: make(map[string]any)}
+	s.TraceID = parent.TraceID
+	s.parent = parent.SpanID
+	if s.TraceID == [16]byte{} {
+		rand.Read(s.TraceID[:])
+	}
+	rand.Read(s.SpanID[:])
+	return s
+}
+
+// startCycleSpan starts the root span of a cycle, with the IDs derived from its run ID
+func startCycleSpan(runID string, startedAt time.Time) *span {
+	if traces == nil {
+		return nil
+	}
+	return &span{spanContext: runSpanContext(runID), name: "scan cycle", start: startedAt, attrs: map[string]any{"scan.run_id": runID}}
+}
+
+// Context returns the span's IDs for its children; a nil span gives a new trace
+func (s *span) Context() spanContext {
+	if s == nil {
+		return spanContext{}
+	}
+	return s.spanContext
+}
+
+// SetAttr records an attribute; ints, float64s and bools keep their type, anything
+// else is recorded as a string
+func (s *span) SetAttr(key string, value any) {
+	if s != nil {
+		s.attrs[key] = value
+	}
+}
+
+// End finishes the span, marking it failed when err is not nil, and queues it for export
+func (s *span) End(err error) {
+	if s == nil {
+		return
+	}
+	traces.add(s.otlp(time.Now(), err))
+}
+
+// endCycleSpan records the outcome of a finished cycle on its span and exports the
+// spans right away, before a one-shot run exits
+func endCycleSpan(cycle *CycleResult) {
+	if cycle.span == nil {
+		return
+	}
+	images, vulns := 0, 0
+	for _, v := range cycle.Variants {
+		images += v.Images
+		vulns += v.Vulnerabilities
+	}
+	cycle.span.SetAttr("scan.status", cycle.Status)
+	cycle.span.SetAttr("scan.images", images)
+	cycle.span.SetAttr("scan.vulnerabilities", vulns)
+	cycle.span.SetAttr("scan.duration_seconds", cycle.FinishedAt.Sub(cycle.StartedAt).Seconds())
+	var err error
+	if failed := cycle.Failed(); len(failed) > 0 {
+		err = fmt.Errorf("failed variants: %s", strings.Join(failed, ", "))
+	}
+	cycle.span.End(err)
+
+	if err := traces.Flush(); err != nil {
+		log.Printf("⚠️  Trace export failed: %v", err)
+	}
+}
+
+// otlpSpan is a span in the OTLP/JSON encoding
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func (s *span) otlp(end time.Time, err error) otlpSpan {
+	o := otlpSpan{
+		TraceID:           hex.EncodeToString(s.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.SpanID[:]),
+		Name:              s.name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        otlpAttributes(s.attrs),
+		Status:            otlpStatus{Code: statusOK},
+	}
+	if s.parent != [8]byte{} {
+		o.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	if err != nil {
+		o.Status = otlpStatus{Code: statusError, Message: err.Error()}
+	}
+	return o
+}
+
+func otlpAttributes(attrs map[string]any) []otlpAttribute {
+	out := make([]otlpAttribute, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]any
+		switch v := v.(type) {
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, otlpAttribute{Key: k, Value: value})
+	}
+	return out
+}
+
+// otlpExporter batches finished spans and posts them to the collector
+type otlpExporter struct {
+	cfg     TracingConfig
+	mu      sync.Mutex
+	pending []otlpSpan
+}
+
+func (e *otlpExporter) add(s otlpSpan) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending = append(e.pending, s)
+}
+
+func (e *otlpExporter) run() {
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := e.Flush(); err != nil {
+			log.Printf("⚠️  Trace export failed: %v", err)
+		}
+	}
+}
+
+// Flush exports the pending spans. Failed batches are dropped rather than retried so
+// an unreachable collector can't grow the buffer.
+func (e *otlpExporter) Flush() error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	spans := e.pending
+	e.pending = nil
+	e.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": e.cfg.ServiceName}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": traceScopeName},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%d span(s) dropped: %w", len(spans), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%d span(s) dropped: collector returned %s", len(spans), resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultTrendWindow   = "30d"
+	defaultTrendInterval = "day"
+	trendQueryTimeout    = 10 * time.Second
+)
+
+// trendIntervals are the accepted ?interval= values, passed to date_trunc
+var trendIntervals = map[string]bool{"hour": true, "day": true, "week": true, "month": true}
+
+// TrendPoint is the CVE count by severity of a variant's latest completed scan of
+// each image in one interval
+type TrendPoint struct {
+	Time       time.Time      `json:"time"`
+	Total      int            `json:"total"`
+	Severities map[string]int `json:"severities"`
+}
+
+// VariantTrend is a variant's CVE counts over a window, with the change between
+// its first and last points
+type VariantTrend struct {
+	Variant string       `json:"variant"`
+	Points  []TrendPoint `json:"points"`
+	First   int          `json:"first"`
+	Last    int          `json:"last"`
+	// ChangePercent is the change of the total from the first to the last point,
+	// negative for a reduction; absent without a non-zero first point
+	ChangePercent *float64 `json:"change_percent,omitempty"`
+}
+
+// TrendsResponse is the response of GET /trends
+type TrendsResponse struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Window      string         `json:"window"`
+	Interval    string         `json:"interval"`
+	Since       time.Time      `json:"since"`
+	Variants    []VariantTrend `json:"variants"`
+}
+
+// openHistoryDB returns a handle on the results database for the read endpoints.
+// It connects on first use, so the API starts even while the database is down.
+func openHistoryDB(dbCfg DBConfig) *sql.DB {
+	if err := dbCfg.requirePostgres("scan history"); err != nil {
+		log.Printf("ℹ️  Trends and dashboard history disabled: %v", err)
+		return nil
+	}
+	db, err := sql.Open("postgres", dbCfg.DSN())
+	if err != nil {
+		log.Printf("⚠️  Scan history unavailable: %v", err)
+		return nil
+	}
+	return db
+}
+
+// trendsHandler serves GET /trends: the CVE counts by severity over time of one
+// variant (?variant=) or all of them, over ?window= (default 30d) in ?interval=
+// steps (default day), for Grafana and the dashboard
+func trendsHandler(s *Scheduler, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+
+		q := r.URL.Query()
+		variants := s.Config().VariantNames()
+		if v := q.Get("variant"); v != "" {
+			if !s.knownVariant(v) {
+				writeError(w, http.StatusNotFound, "unknown variant")
+				return
+			}
+			variants = []string{v}
+		}
+		resp := TrendsResponse{GeneratedAt: time.Now().UTC(), Window: q.Get("window"), Interval: q.Get("interval")}
+		if resp.Window == "" {
+			resp.Window = defaultTrendWindow
+		}
+		if resp.Interval == "" {
+			resp.Interval = defaultTrendInterval
+		}
+		window, err := parseWindow(resp.Window)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !trendIntervals[resp.Interval] {
+			writeError(w, http.StatusBadRequest, "interval must be hour, day, week or month")
+			return
+		}
+		resp.Since = resp.GeneratedAt.Add(-window)
+		if db == nil {
+			msg := "database unavailable"
+			if err := s.Config().DB.requirePostgres("scan history"); err != nil {
+				msg = err.Error()
+			}
+			writeError(w, http.StatusServiceUnavailable, msg)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), trendQueryTimeout)
+		defer cancel()
+		for _, variant := range variants {
+			schema, err := variantSchema(ctx, db, variant)
+			if err != nil {
+				writeError(w, http.StatusServiceUnavailable, "database unavailable: "+err.Error())
+				return
+			}
+			points, err := severityTrend(ctx, db, schema, variant, resp.Since, resp.Interval)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			resp.Variants = append(resp.Variants, newVariantTrend(variant, points))
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// newVariantTrend summarizes the change over a variant's trend
+func newVariantTrend(variant string, points []TrendPoint) VariantTrend {
+	vt := VariantTrend{Variant: variant, Points: points}
+	if vt.Points == nil {
+		vt.Points = []TrendPoint{}
+	}
+	if len(points) > 0 {
+		vt.First, vt.Last = points[0].Total, points[len(points)-1].Total
+		if vt.First > 0 {
+			change := float64(vt.Last-vt.First) * 100 / float64(vt.First)
+			vt.ChangePercent = &change
+		}
+	}
+	return vt
+}
+
+// variantSchema returns the schema holding a variant's tables: its own with
+// DB_SCHEMA_PER_VARIANT, otherwise public
+func variantSchema(ctx context.Context, db *sql.DB, variant string) (string, error) {
+	var schema string
+	err := db.QueryRowContext(ctx, `SELECT schema_name FROM public.variant_catalog WHERE variant = $1`, variant).Scan(&schema)
+	if err == sql.ErrNoRows {
+		return "public", nil
+	}
+	if err != nil {
+		// Databases created before the catalog existed only have public
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "42P01" {
+			return "public", nil
+		}
+		return "", err
+	}
+	return schema, nil
+}
+
+// severityTrend sums, for each interval since since, the severity counts of the last
+// completed scan of each of the variant's images in that interval
+func severityTrend(ctx context.Context, db *sql.DB, schema, variant string, since time.Time, interval string) ([]TrendPoint, error) {
+	rows, err := db.QueryContext(ctx, `SELECT bucket, SUM(critical_count), SUM(high_count), SUM(medium_count), SUM(low_count)
+		FROM (
+			SELECT DISTINCT ON (image_id, date_trunc($3, created_at))
+				date_trunc($3, created_at) AS bucket, critical_count, high_count, medium_count, low_count
+			FROM `+pq.QuoteIdentifier(schema)+`.scans
+			WHERE image_variant = $1 AND scan_status = 'completed' AND created_at >= $2
+			ORDER BY image_id, date_trunc($3, created_at), created_at DESC
+		) latest
+		GROUP BY bucket ORDER BY bucket`, variant, since, interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trend []TrendPoint
+	for rows.Next() {
+		var p TrendPoint
+		var critical, high, medium, low int
+		if err := rows.Scan(&p.Time, &critical, &high, &medium, &low); err != nil {
+			return nil, err
+		}
+		p.Severities = map[string]int{"CRITICAL": critical, "HIGH": high, "MEDIUM": medium, "LOW": low}
+		p.Total = critical + high + medium + low
+		trend = append(trend, p)
+	}
+	return trend, rows.Err()
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const scanTriggerMaxBodySize = 64 * 1024
+
+// ScanTriggerConfig bounds the cycles operators trigger through POST /scan and the
+// gRPC TriggerScan, so a client hammering them can't pile up heavyweight scans
+type ScanTriggerConfig struct {
+	RateLimit int // triggers allowed per client per hour
+	QueueSize int // triggered cycles allowed to wait for the running one
+}
+
+// scanTriggerConfigFromEnv reads SCAN_TRIGGER_RATE_LIMIT and SCAN_TRIGGER_QUEUE
+func scanTriggerConfigFromEnv(env *envReader) ScanTriggerConfig {
+	return ScanTriggerConfig{
+		RateLimit: env.Int("SCAN_TRIGGER_RATE_LIMIT", 10),
+		QueueSize: env.Int("SCAN_TRIGGER_QUEUE", 2),
+	}
+}
+
+// Validate reports a non-positive rate limit or a negative queue size
+func (tc ScanTriggerConfig) Validate() []error {
+	var errs []error
+	if tc.RateLimit <= 0 {
+		errs = append(errs, fmt.Errorf("SCAN_TRIGGER_RATE_LIMIT must be positive, got %d", tc.RateLimit))
+	}
+	if tc.QueueSize < 0 {
+		errs = append(errs, fmt.Errorf("SCAN_TRIGGER_QUEUE must not be negative, got %d", tc.QueueSize))
+	}
+	return errs
+}
+
+// ScanTriggerRequest is the body of POST /scan
+type ScanTriggerRequest struct {
+	// Variants to scan; all of them when empty
+	Variants []string `json:"variants,omitempty"`
+}
+
+// TriggeredScan is an accepted trigger
+type TriggeredScan struct {
+	RunID    string   `json:"run_id"`
+	Variants []string `json:"variants"`
+	// Queued is set when the cycle waits for a running cycle or earlier triggers
+	Queued bool `json:"queued"`
+	// Position counts the triggered cycles waiting ahead of this one
+	Position int `json:"position"`
+	// Coalesced is set when a waiting trigger of the same variants was returned
+	// instead of queueing another
+	Coalesced bool `json:"coalesced,omitempty"`
+
+	// images are the pushed images of each variant a targeted rescan scans; nil
+	// for a full cycle
+	images map[string][]string
+}
+
+// triggerError refuses a trigger with the HTTP status it maps to
+type triggerError struct {
+	status     int
+	retryAfter time.Duration
+	msg        string
+}
+
+func (e *triggerError) Error() string { return e.msg }
+
+// grpcCode maps the refusal to a gRPC status code
+func (e *triggerError) grpcCode() int {
+	switch e.status {
+	case http.StatusNotFound:
+		return grpcNotFound
+	case http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	}
+	return grpcFailedPrecondition
+}
+
+// scanTrigger runs triggered cycles one at a time, after any running cycle, keeping
+// at most QueueSize of them waiting and rate limiting each client
+type scanTrigger struct {
+	sched   *Scheduler
+	cfg     ScanTriggerConfig
+	limiter *rateLimiter
+
+	mu      sync.Mutex
+	pending []*TriggeredScan
+	// active is set while a triggered cycle runs, including its warm-up wait
+	active bool
+	wake   chan struct{}
+}
+
+func newScanTrigger(s *Scheduler, cfg ScanTriggerConfig) *scanTrigger {
+	t := &scanTrigger{
+		sched:   s,
+		cfg:     cfg,
+		limiter: newRateLimiter(cfg.RateLimit, time.Hour),
+		wake:    make(chan struct{}, 1),
+	}
+	go t.run()
+	return t
+}
+
+// Trigger queues a cycle over the given variants, or all of them, for a client.
+// via names the API for the logs.
+func (t *scanTrigger) Trigger(client, via string, variants []string) (*TriggeredScan, error) {
+	for _, v := range variants {
+		if !t.sched.knownVariant(v) {
+			return nil, &triggerError{status: http.StatusNotFound, msg: fmt.Sprintf("unknown variant %q", v)}
+		}
+	}
+	cfg := t.sched.Config()
+	if len(variants) == 0 {
+		variants = cfg.VariantNames()
+	}
+	if !t.sched.elector.IsLeader() {
+		return nil, &triggerError{status: http.StatusServiceUnavailable, msg: "this replica is on standby; trigger scans on the leader"}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, waiting := range t.pending {
+		if waiting.images == nil && slices.Equal(sortedCopy(waiting.Variants), sortedCopy(variants)) {
+			if err := t.allow(client); err != nil {
+				return nil, err
+			}
+			coalesced := *waiting
+			coalesced.Position, coalesced.Coalesced = i, true
+			return &coalesced, nil
+		}
+	}
+	// A trigger refused for a full queue doesn't count against the client's rate limit
+	busy := t.active || t.sched.CycleRunning()
+	if len(t.pending) >= t.cfg.QueueSize && (len(t.pending) > 0 || busy) {
+		msg := "a scan cycle is already running"
+		if len(t.pending) > 0 {
+			msg = fmt.Sprintf("%d triggered cycle(s) already waiting; try again once they have run", len(t.pending))
+		}
+		return nil, &triggerError{status: http.StatusTooManyRequests, msg: msg}
+	}
+	if err := t.allow(client); err != nil {
+		return nil, err
+	}
+
+	scan := &TriggeredScan{
+		RunID:    newRunID(time.Now()),
+		Variants: variants,
+		Queued:   len(t.pending) > 0 || busy,
+		Position: len(t.pending),
+	}
+	t.pending = append(t.pending, scan)
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+	log.Printf("▶️  Scan of %s triggered over %s by %s (run %s)", strings.Join(variants, ", "), via, client, scan.RunID)
+	activity.Event("Scan of %d variant(s) triggered over %s", len(variants), via)
+	result := *scan
+	return &result, nil
+}
+
+// Rescan queues a targeted rescan of pushed images, merged into a rescan that is
+// still waiting. Rescans skip the rate limit and the queue bound: the registry
+// webhook checks its secret and debounces pushes.
+func (t *scanTrigger) Rescan(images map[string][]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, waiting := range t.pending {
+		if waiting.images != nil {
+			for variant, list := range images {
+				waiting.images[variant] = mergeImageLists(waiting.images[variant], list)
+			}
+			waiting.Variants = sortedKeys(waiting.images)
+			return
+		}
+	}
+	scan := &TriggeredScan{RunID: newRunID(time.Now()), Variants: sortedKeys(images), images: images}
+	t.pending = append(t.pending, scan)
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// allow charges an accepted trigger to the client's rate limit
+func (t *scanTrigger) allow(client string) error {
+	if ok, retry := t.limiter.Allow(client); !ok {
+		log.Printf("🚫 Scan trigger from %s rate limited", client)
+		return &triggerError{status: http.StatusTooManyRequests, retryAfter: retry, msg: "scan trigger rate limit exceeded"}
+	}
+	return nil
+}
+
+// sortedCopy returns a sorted copy of a variant list
+func sortedCopy(values []string) []string {
+	values = slices.Clone(values)
+	slices.Sort(values)
+	return values
+}
+
+// run starts the queued cycles one after the other, each once it holds the cycle lock
+func (t *scanTrigger) run() {
+	for range t.wake {
+		for {
+			t.mu.Lock()
+			if len(t.pending) == 0 {
+				t.mu.Unlock()
+				break
+			}
+			scan := t.pending[0]
+			t.pending = t.pending[1:]
+			t.active = true
+			t.mu.Unlock()
+
+			t.runCycle(scan)
+
+			t.mu.Lock()
+			t.active = false
+			t.mu.Unlock()
+		}
+	}
+}
+
+func (t *scanTrigger) runCycle(scan *TriggeredScan) {
+	defer t.sched.lockCycle()()
+	if !t.sched.elector.IsLeader() {
+		log.Printf("⚠️  Dropping triggered run %s: this replica lost the leader lock", scan.RunID)
+		return
+	}
+	cfg := t.sched.Config()
+	t.sched.waitForWarmup(cfg)
+	heartbeatCycleStart(cfg.Heartbeat, cfg.DryRun)
+	if scan.images != nil {
+		t.sched.completeCycle(cfg, RunTargetedScan(cfg, scan.images, scan.RunID), false)
+		return
+	}
+	// A trigger for some of the variants leaves the others as old as they were
+	t.sched.completeCycle(cfg, RunFullScanCycle(cfg, scan.Variants, scan.RunID), cfg.coversScheduledVariants(scan.Variants))
+}
+
+// scanTriggerHandler serves POST /scan
+func scanTriggerHandler(t *scanTrigger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+
+		// The body is optional: no body scans every variant
+		var req ScanTriggerRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, scanTriggerMaxBodySize)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+
+		scan, err := t.Trigger(clientID(r), "HTTP", req.Variants)
+		if err != nil {
+			te := err.(*triggerError)
+			if te.retryAfter > 0 {
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", int(te.retryAfter.Seconds())+1))
+			}
+			writeError(w, te.status, te.msg)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, scan)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// severityOrder lists the severities reported by the pipeline, most severe first
+var severityOrder = []string{"CRITICAL", "HIGH", "MEDIUM", "LOW"}
+
+// Fix availability of a finding
+const (
+	fixAvailable = "fix-available"
+	fixNone      = "no-fix"
+)
+
+// severityRank orders severities for sorting, placing unknown severities last
+func severityRank(severity string) int {
+	for i, sev := range severityOrder {
+		if sev == severity {
+			return i
+		}
+	}
+	return len(severityOrder)
+}
+
+// TrivyReport is the subset of Trivy's JSON output (and the merged scan files,
+// which use the same layout) that the scheduler reads
+type TrivyReport struct {
+	ArtifactName string        `json:"ArtifactName"`
+	Platform     string        `json:"Platform,omitempty"`
+	Results      []TrivyResult `json:"Results"`
+}
+
+// TrivyResult groups vulnerabilities found in a single scan target
+type TrivyResult struct {
+	Target          string      `json:"Target"`
+	Type            string      `json:"Type"`
+	Vulnerabilities []TrivyVuln `json:"Vulnerabilities"`
+}
+
+// TrivyVuln is a single vulnerability finding
+type TrivyVuln struct {
+	VulnerabilityID  string `json:"VulnerabilityID"`
+	PkgName          string `json:"PkgName"`
+	InstalledVersion string `json:"InstalledVersion"`
+	FixedVersion     string `json:"FixedVersion"`
+	Severity         string `json:"Severity"`
+	Title            string `json:"Title"`
+	Disputed         bool   `json:"Disputed,omitempty"`
+	// VendorAdvisory is set by the vendor advisory cross-check (ADVISORY_FEEDS)
+	VendorAdvisory *VendorAdvisory `json:"VendorAdvisory,omitempty"`
+	// RemediationLinks are the fix commits, advisories and changelogs among the references
+	RemediationLinks []RemediationLink `json:"RemediationLinks,omitempty"`
+	// EPSS and KEV are set with EXPLOIT_INTEL when the feeds list the CVE
+	EPSS *EPSSScore `json:"EPSS,omitempty"`
+	KEV  *KEVEntry  `json:"KEV,omitempty"`
+}
+
+// RemediationLink is a reference URL classified by merge-scan-results.py, with Type one
+// of fix_commit, pull_request, advisory, changelog or issue
+type RemediationLink struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// readTrivyReport parses a Trivy-format JSON report from disk
+func readTrivyReport(path string) (*TrivyReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &TrivyReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// SeverityCounts returns the number of findings per severity across all results.
+// Findings flagged as likely false positives are not counted.
+func (r *TrivyReport) SeverityCounts() map[string]int {
+	counts := make(map[string]int, len(severityOrder))
+	for _, sev := range severityOrder {
+		counts[sev] = 0
+	}
+	for _, result := range r.Results {
+		for _, v := range result.Vulnerabilities {
+			if !v.Disputed {
+				counts[v.Severity]++
+			}
+		}
+	}
+	return counts
+}
+
+// FixStatus classifies a finding as fixable when the scanners or the vendor name a
+// fixed version
+func (v TrivyVuln) FixStatus() string {
+	if v.FixedVersion != "" || (v.VendorAdvisory != nil && v.VendorAdvisory.Status == advisoryFixed) {
+		return fixAvailable
+	}
+	return fixNone
+}
+
+// FixCounts returns the number of counted findings per fix availability
+func (r *TrivyReport) FixCounts() map[string]int {
+	counts := map[string]int{fixAvailable: 0, fixNone: 0}
+	for _, result := range r.Results {
+		for _, v := range result.Vulnerabilities {
+			if !v.Disputed {
+				counts[v.FixStatus()]++
+			}
+		}
+	}
+	return counts
+}
+
+// KnownExploitedCount returns the number of counted findings listed in the KEV catalog
+func (r *TrivyReport) KnownExploitedCount() int {
+	n := 0
+	for _, result := range r.Results {
+		for _, v := range result.Vulnerabilities {
+			if v.KEV != nil && !v.Disputed {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// DisputedCount returns the number of findings flagged as likely false positives
+func (r *TrivyReport) DisputedCount() int {
+	n := 0
+	for _, result := range r.Results {
+		for _, v := range result.Vulnerabilities {
+			if v.Disputed {
+				n++
+			}
+		}
+	}
+	return n
+}
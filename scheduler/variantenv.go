@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envVarName matches the names variants may set in their commands' environment
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedEnvVar reports names the scheduler sets itself for the pipeline commands
+func reservedEnvVar(name string) bool {
+	return strings.HasPrefix(name, "SCAN_") || name == "REPORTS_PATH" || name == "SEVERITY_POLICY"
+}
+
+// validateVariantEnv reports invalid or reserved names in a variant's env and
+// secret_files, and secret files that can't be read
+func validateVariantEnv(v VariantConfig) []error {
+	var errs []error
+	check := func(field, name string) {
+		switch {
+		case !envVarName.MatchString(name):
+			errs = append(errs, fmt.Errorf("variant %q %s has an invalid variable name %q", v.Name, field, name))
+		case reservedEnvVar(name):
+			errs = append(errs, fmt.Errorf("variant %q %s can't set %s: the scheduler sets it", v.Name, field, name))
+		case name == "DOCKER_CONFIG" && v.RegistryAuth != nil:
+			errs = append(errs, fmt.Errorf("variant %q %s can't set DOCKER_CONFIG together with registry_auth", v.Name, field))
+		}
+	}
+	for _, name := range sortedKeys(v.Env) {
+		check("env", name)
+		if _, ok := v.SecretFiles[name]; ok {
+			errs = append(errs, fmt.Errorf("variant %q sets %s in both env and secret_files", v.Name, name))
+		}
+	}
+	for _, name := range sortedKeys(v.SecretFiles) {
+		check("secret_files", name)
+		if _, err := os.ReadFile(v.SecretFiles[name]); err != nil {
+			errs = append(errs, fmt.Errorf("variant %q secret_files %s: %w", v.Name, name, err))
+		}
+	}
+	return errs
+}
+
+// VariantEnv returns the extra environment of a variant's commands, sorted, with the
+// secret files read now so rotated secrets are picked up by the next cycle. The map
+// lists the variables whose values came from secret files.
+func (c *Config) VariantEnv(name string) ([]string, map[string]bool, error) {
+	var env []string
+	var secret map[string]bool
+	for _, v := range c.Variants {
+		if v.Name != name {
+			continue
+		}
+		for k, val := range c.tenantEnv(v) {
+			env = append(env, k+"="+val)
+		}
+		for k, val := range v.Env {
+			env = append(env, k+"="+val)
+		}
+		for k, path := range v.SecretFiles {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, nil, fmt.Errorf("secret_files %s: %w", k, err)
+			}
+			if secret == nil {
+				secret = make(map[string]bool)
+			}
+			secret[k] = true
+			env = append(env, k+"="+strings.TrimRight(string(data), "\r\n"))
+		}
+	}
+	sort.Strings(env)
+	return env, secret, nil
+}
+
+// commandEnv returns the environment of a command run by the job's running step: the
+// variables of the scheduler's own the step's allowlist lets through, then extra,
+// then the variant's env and secret files, which take precedence
+func (j *ScanJob) commandEnv(extra ...string) []string {
+	patterns := j.passEnv
+	if patterns == nil {
+		patterns = j.Config.passEnv("", nil)
+	}
+	return j.envWith(patterns, extra...)
+}
+
+// envWith returns the allowlisted environment with extra and the variant's env added
+func (j *ScanJob) envWith(patterns []string, extra ...string) []string {
+	env := append(inheritedEnv(patterns), extra...)
+	return append(env, j.Env...)
+}
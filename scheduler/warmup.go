@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const warmupProgressInterval = 30 * time.Second
+
+// Scanner database warm-up states
+const (
+	warmupPending     = "pending"
+	warmupDownloading = "downloading"
+	warmupReady       = "ready"
+	warmupFailed      = "failed"
+)
+
+// scannerDBCommands download or refresh each scanner's vulnerability database
+var scannerDBCommands = map[string][][]string{
+	"trivy": {
+		{"trivy", "image", "--download-db-only", "--no-progress"},
+		{"trivy", "image", "--download-java-db-only", "--no-progress"},
+	},
+	"grype": {
+		{"grype", "db", "update"},
+	},
+}
+
+// dbWarmer refreshes the scanner databases in the background at startup so the
+// first scheduled cycle doesn't spend its time downloading them
+type dbWarmer struct {
+	mu     sync.Mutex
+	status map[string]string
+	done   chan struct{}
+}
+
+func newDBWarmer() *dbWarmer {
+	w := &dbWarmer{status: make(map[string]string), done: make(chan struct{})}
+	for scanner := range scannerDBCommands {
+		w.status[scanner] = warmupPending
+	}
+	return w
+}
+
+// Start downloads every scanner database concurrently
+func (w *dbWarmer) Start() {
+	log.Println("📥 Warming up scanner vulnerability databases in the background...")
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for scanner, commands := range scannerDBCommands {
+		wg.Add(1)
+		go func(scanner string, commands [][]string) {
+			defer wg.Done()
+			w.warm(scanner, commands)
+		}(scanner, commands)
+	}
+
+	go func() {
+		wg.Wait()
+		close(w.done)
+		log.Printf("✅ Scanner database warm-up finished in %s %v", time.Since(start).Round(time.Second), w.Status())
+	}()
+}
+
+func (w *dbWarmer) warm(scanner string, commands [][]string) {
+	w.setStatus(scanner, warmupDownloading)
+	start := time.Now()
+
+	// Downloads can take several minutes without output; report that they're still going
+	ticker := time.NewTicker(warmupProgressInterval)
+	defer ticker.Stop()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				log.Printf("[warmup %s] still downloading (%s elapsed)", scanner, time.Since(start).Round(time.Second))
+			case <-finished:
+				return
+			}
+		}
+	}()
+
+	for _, args := range commands {
+		log.Printf("[warmup %s] Running %s", scanner, strings.Join(args, " "))
+		if err := runLoggingLines("warmup "+scanner, args); err != nil {
+			log.Printf("[warmup %s] ⚠️  %v (scans will download the database themselves)", scanner, err)
+			w.setStatus(scanner, warmupFailed)
+			return
+		}
+	}
+
+	log.Printf("[warmup %s] ✅ Database ready after %s", scanner, time.Since(start).Round(time.Second))
+	w.setStatus(scanner, warmupReady)
+}
+
+// runLoggingLines runs a command and logs each line of its combined output with a prefix
+func runLoggingLines(prefix string, args []string) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	lines := make(chan struct{})
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				log.Printf("[%s] %s", prefix, line)
+			}
+		}
+		io.Copy(io.Discard, pr)
+	}()
+
+	err := runInGroup(context.Background(), prefix, cmd)
+	pw.Close()
+	<-lines
+	return err
+}
+
+func (w *dbWarmer) setStatus(scanner, status string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status[scanner] = status
+}
+
+// Status returns a copy of the per-scanner warm-up state
+func (w *dbWarmer) Status() map[string]string {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := make(map[string]string, len(w.status))
+	for k, v := range w.status {
+		status[k] = v
+	}
+	return status
+}
+
+// Ready reports whether the warm-up has finished (successfully or not). A nil
+// warmer, used when the warm-up is disabled, is always ready.
+func (w *dbWarmer) Ready() bool {
+	if w == nil {
+		return true
+	}
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait blocks until the warm-up finishes or the timeout expires
+func (w *dbWarmer) Wait(timeout time.Duration) bool {
+	if w == nil {
+		return true
+	}
+	select {
+	case <-w.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// ServeHTTP handles GET /readyz, returning 503 until the warm-up has finished
+func (w *dbWarmer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	ready := w.Ready()
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(rw, status, map[string]any{"ready": ready, "scanner_db": w.Status()})
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const registryWebhookMaxBodySize = 1 << 20
+
+// RegistryWebhookConfig enables POST /webhooks/registry, which rescans images as
+// soon as they are pushed
+type RegistryWebhookConfig struct {
+	// Secret authenticates the registry; the endpoint is disabled without one
+	Secret string
+	// Debounce collects the pushes arriving within this window into one rescan
+	Debounce time.Duration
+}
+
+// registryPush is the union of the push payloads the endpoint understands
+type registryPush struct {
+	// Harbor: {"type": "PUSH_ARTIFACT", "event_data": {"resources": [{"resource_url": ...}]}}
+	Type      string `json:"type"`
+	EventData *struct {
+		Resources []struct {
+			ResourceURL string `json:"resource_url"`
+			Tag         string `json:"tag"`
+		} `json:"resources"`
+	} `json:"event_data"`
+
+	// Docker Hub: {"push_data": {"tag": ...}, "repository": {"repo_name": ...}}
+	PushData *struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	Repository *struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+
+	// GitHub Container Registry: the "package" (or older "registry_package") event
+	Action          string         `json:"action"`
+	Package         *githubPackage `json:"package"`
+	RegistryPackage *githubPackage `json:"registry_package"`
+}
+
+type githubPackage struct {
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace"`
+	PackageType string `json:"package_type"`
+	Owner       struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	PackageVersion struct {
+		PackageURL        string `json:"package_url"`
+		ContainerMetadata struct {
+			Tag struct {
+				Name string `json:"name"`
+			} `json:"tag"`
+		} `json:"container_metadata"`
+	} `json:"package_version"`
+}
+
+// images returns the registry that sent the push and the image references pushed
+func (p *registryPush) images() (string, []string, error) {
+	switch {
+	case p.EventData != nil:
+		if p.Type != "PUSH_ARTIFACT" && p.Type != "pushImage" {
+			return "harbor", nil, nil
+		}
+		var images []string
+		for _, r := range p.EventData.Resources {
+			if r.ResourceURL != "" {
+				images = append(images, r.ResourceURL)
+			}
+		}
+		return "harbor", images, nil
+
+	case p.PushData != nil && p.Repository != nil:
+		if p.Repository.RepoName == "" || p.PushData.Tag == "" {
+			return "dockerhub", nil, errors.New("push has no repository or tag")
+		}
+		return "dockerhub", []string{p.Repository.RepoName + ":" + p.PushData.Tag}, nil
+
+	case p.Package != nil || p.RegistryPackage != nil:
+		pkg := p.Package
+		if pkg == nil {
+			pkg = p.RegistryPackage
+		}
+		if p.Action != "published" || !strings.EqualFold(pkg.PackageType, "container") {
+			return "ghcr", nil, nil
+		}
+		if url := pkg.PackageVersion.PackageURL; url != "" {
+			return "ghcr", []string{url}, nil
+		}
+		tag := pkg.PackageVersion.ContainerMetadata.Tag.Name
+		owner := pkg.Namespace
+		if owner == "" {
+			owner = pkg.Owner.Login
+		}
+		if tag == "" || owner == "" {
+			return "ghcr", nil, errors.New("package has no owner or tag")
+		}
+		return "ghcr", []string{"ghcr.io/" + owner + "/" + pkg.Name + ":" + tag}, nil
+	}
+	return "", nil, errors.New("unrecognized payload: expected a Harbor, GHCR or Docker Hub push")
+}
+
+// normalizeImageRef spells an image reference the way the registry resolves it, so
+// "nginx" and "docker.io/library/nginx:latest" compare equal
+func normalizeImageRef(ref string) string {
+	ref = strings.TrimPrefix(strings.TrimPrefix(ref, "https://"), "http://")
+	name, digest, _ := strings.Cut(ref, "@")
+	if i := strings.LastIndex(name, ":"); i < 0 || strings.Contains(name[i:], "/") {
+		if digest == "" {
+			name += ":latest"
+		}
+	}
+
+	host, rest, found := strings.Cut(name, "/")
+	if !found || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		host, rest = "docker.io", name
+	}
+	if host == "index.docker.io" || host == "registry-1.docker.io" {
+		host = "docker.io"
+	}
+	if host == "docker.io" && !strings.Contains(rest, "/") {
+		rest = "library/" + rest
+	}
+	normalized := strings.ToLower(host) + "/" + rest
+	if digest != "" {
+		normalized += "@" + digest
+	}
+	return normalized
+}
+
+// RescanTarget is an image queued for rescanning in one of the variants scanning it
+type RescanTarget struct {
+	Variant string `json:"variant"`
+	Image   string `json:"image"`
+}
+
+// registryWebhookResponse is the body returned to the registry
+type registryWebhookResponse struct {
+	Source string         `json:"source"`
+	Pushed []string       `json:"pushed"`
+	Queued []RescanTarget `json:"queued"`
+	// Message explains a push that queued nothing
+	Message string `json:"message,omitempty"`
+}
+
+type registryWebhookHandler struct {
+	sched     *Scheduler
+	secret    string
+	rescanner *registryRescanner
+}
+
+func newRegistryWebhookHandler(s *Scheduler, trigger *scanTrigger, cfg RegistryWebhookConfig) *registryWebhookHandler {
+	return &registryWebhookHandler{
+		sched:     s,
+		secret:    cfg.Secret,
+		rescanner: &registryRescanner{trigger: trigger, delay: cfg.Debounce, pending: make(map[string][]string)},
+	}
+}
+
+// ServeHTTP handles POST /webhooks/registry
+func (h *registryWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, registryWebhookMaxBodySize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "payload too large")
+		return
+	}
+	if !h.authorized(r, body) {
+		log.Printf("🚫 Rejected registry webhook from %s: bad or missing secret", clientID(r))
+		writeError(w, http.StatusUnauthorized, "invalid webhook secret")
+		return
+	}
+
+	var push registryPush
+	if err := json.Unmarshal(body, &push); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON payload")
+		return
+	}
+	source, pushed, err := push.images()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp := registryWebhookResponse{Source: source, Pushed: pushed, Queued: []RescanTarget{}}
+	if len(pushed) == 0 {
+		resp.Message = "not an image push event"
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	if h.sched.Paused() {
+		writeError(w, http.StatusConflict, "scheduled scans are paused")
+		return
+	}
+	if !h.sched.elector.IsLeader() {
+		writeError(w, http.StatusServiceUnavailable, "standby replica, send the webhook to the leader")
+		return
+	}
+
+	resp.Queued = append(resp.Queued, matchPushedImages(h.sched.Config(), pushed)...)
+	if len(resp.Queued) == 0 {
+		resp.Message = "no variant scans the pushed image(s)"
+		log.Printf("ℹ️  Ignoring %s push of %s: no variant scans it", source, strings.Join(pushed, ", "))
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	for _, t := range resp.Queued {
+		h.rescanner.Enqueue(t)
+	}
+	log.Printf("📦 %s push of %s: rescan of %d image(s) queued", source, strings.Join(pushed, ", "), len(resp.Queued))
+	activity.Event("%s push queued a rescan of %d image(s)", source, len(resp.Queued))
+	writeJSON(w, http.StatusAccepted, resp)
+}
+
+// authorized checks the secret the way each registry can send it: a GitHub HMAC
+// signature, an Authorization header (Harbor's auth header, or a bearer token), or
+// a ?token= query parameter for Docker Hub, which can't set headers
+func (h *registryWebhookHandler) authorized(r *http.Request, body []byte) bool {
+	if sig, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256="); ok {
+		mac := hmac.New(sha256.New, []byte(h.secret))
+		mac.Write(body)
+		expected := hex.EncodeToString(mac.Sum(nil))
+		return hmac.Equal([]byte(sig), []byte(expected))
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return secretEqual(strings.TrimPrefix(auth, "Bearer "), h.secret)
+	}
+	return secretEqual(r.URL.Query().Get("token"), h.secret)
+}
+
+func secretEqual(given, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(secret)) == 1
+}
+
+// matchPushedImages finds the variants scanning each pushed image, as spelled in
+// the variant's image list
+func matchPushedImages(cfg *Config, pushed []string) []RescanTarget {
+	wanted := make(map[string]bool, len(pushed))
+	for _, image := range pushed {
+		wanted[normalizeImageRef(image)] = true
+	}
+
+	var targets []RescanTarget
+	for _, variant := range cfg.VariantNames() {
+		images, err := listVariantImages(cfg, variant)
+		if err != nil {
+			log.Printf("⚠️  %v", err)
+			continue
+		}
+		for _, image := range images {
+			if wanted[normalizeImageRef(image)] {
+				targets = append(targets, RescanTarget{Variant: variant, Image: image})
+			}
+		}
+	}
+	return targets
+}
+
+// registryRescanner collects pushed images and, once no push has arrived for the
+// debounce window, queues their rescan with the scan trigger, which runs it after
+// any running cycle and completes it like every other cycle
+type registryRescanner struct {
+	trigger *scanTrigger
+	delay   time.Duration
+
+	mu      sync.Mutex
+	pending map[string][]string
+	timer   *time.Timer
+}
+
+// Enqueue adds an image to the next rescan, restarting the debounce window
+func (q *registryRescanner) Enqueue(t RescanTarget) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending[t.Variant] = mergeImageLists(q.pending[t.Variant], []string{t.Image})
+	if q.timer == nil {
+		q.timer = time.AfterFunc(q.delay, q.run)
+	} else {
+		q.timer.Reset(q.delay)
+	}
+}
+
+func (q *registryRescanner) run() {
+	q.mu.Lock()
+	pending := q.pending
+	q.pending = make(map[string][]string)
+	q.timer = nil
+	q.mu.Unlock()
+
+	q.trigger.Rescan(pending)
+}
+
+// RunTargetedScan rescans only the given images of each variant, leaving the reports
+// of the variants' other images as they are
+func RunTargetedScan(cfg *Config, images map[string][]string, runID string) *CycleResult {
+	logger := runLogger(runID)
+	cycle := &CycleResult{RunID: runID, StartedAt: time.Now().UTC(), DryRun: cfg.DryRun, Targeted: true}
+
+	var variants []string
+	for _, variant := range cfg.VariantNames() {
+		if len(images[variant]) > 0 {
+			variants = append(variants, variant)
+		}
+	}
+
+	logger.Printf("===========================================")
+	logger.Printf("🎯 Starting targeted rescan of pushed images")
+	for _, variant := range variants {
+		logger.Printf("[%s] %s", variant, strings.Join(images[variant], ", "))
+	}
+	logger.Printf("===========================================")
+
+	activity.StartRun(runID, cycle.StartedAt, variants, time.Time{})
+	cycle.span = startCycleSpan(runID, cycle.StartedAt)
+	cycle.span.SetAttr("scan.variants", len(variants))
+	cycle.span.SetAttr("scan.targeted", true)
+	if cfg.DryRun {
+		dryRunBanner(logger)
+	} else {
+		publishEvent(eventCycleStarted, runID, "", map[string]any{"variants": variants, "targeted": true})
+	}
+	var results []VariantResult
+	for _, variant := range variants {
+		results = append(results, runVariant(cfg.withVariantImages(variant, images[variant]), variant, runID, logger, time.Time{}, nil))
+	}
+	finishCycle(cycle, results, logger)
+	return cycle
+}
+
+// withVariantImages returns a copy of the configuration scanning only images for variant
+func (c *Config) withVariantImages(variant string, images []string) *Config {
+	r := *c
+	r.Variants = make([]VariantConfig, len(c.Variants))
+	for i, v := range c.Variants {
+		if v.Name == variant {
+			v.Images = images
+		}
+		r.Variants[i] = v
+	}
+	return &r
+}
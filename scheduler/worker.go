@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// workerCommand implements `scheduler worker`: a process that claims scan jobs
+// enqueued by a scheduler running with QUEUE_MODE=postgres and runs them locally
+func workerCommand(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	var variants variantList
+	fs.Var(&variants, "variant", "only run jobs for this variant (repeatable or comma-separated, default: all)")
+	name := fs.String("name", defaultWorkerName(), "worker name recorded on claimed jobs")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	log.Println("========================================")
+	log.Println("Vulnerability Scanner Worker")
+	log.Println("========================================")
+
+	if err := cfg.Validate(); err != nil {
+		log.Printf("❌ Invalid configuration:\n%v", err)
+		return exitFailure
+	}
+	// Claimed jobs would be completed without being scanned
+	if cfg.DryRun {
+		log.Println("❌ DRY_RUN is not supported by workers; dry-run the scheduler instead")
+		return exitFailure
+	}
+	setupTracing(cfg.Tracing)
+	setupEvents(cfg.Events)
+
+	if cfg.SkipPreflight {
+		log.Println("⚠️  SKIP_PREFLIGHT=true, skipping startup checks")
+	} else {
+		log.Println("Running startup checks...")
+		if err := preflightError(runPreflightChecks(cfg, true)); err != nil {
+			log.Printf("❌ Startup checks failed:\n%v", err)
+			log.Println("Fix the problems above or set SKIP_PREFLIGHT=true to start anyway")
+			return exitFailure
+		}
+		log.Println("✅ Startup checks passed")
+	}
+
+	q, err := openJobQueue(cfg.DB)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return exitFailure
+	}
+	defer q.Close()
+
+	// Don't claim jobs before the scanner databases are in place
+	if cfg.ScannerDBWarmup {
+		warmer := newDBWarmer()
+		warmer.Start()
+		if !warmer.Wait(cfg.ScannerDBWarmupTimeout) {
+			log.Printf("⚠️  Scanner database warm-up still running after %s, accepting jobs anyway", cfg.ScannerDBWarmupTimeout)
+		}
+	}
+
+	// Finish the current job on SIGINT/SIGTERM instead of abandoning it
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	if len(variants) > 0 {
+		log.Printf("👷 Worker %s waiting for %v jobs", *name, []string(variants))
+	} else {
+		log.Printf("👷 Worker %s waiting for jobs", *name)
+	}
+	for {
+		select {
+		case sig := <-stop:
+			log.Printf("Received %s, worker stopped", sig)
+			return exitSuccess
+		default:
+		}
+
+		job, err := q.Claim(*name, variants, "")
+		if err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+		if job == nil {
+			select {
+			case sig := <-stop:
+				log.Printf("Received %s, worker stopped", sig)
+				return exitSuccess
+			case <-time.After(cfg.QueuePollInterval):
+			}
+			continue
+		}
+
+		runQueuedJob(cfg, q, *name, job)
+	}
+}
+
+// runQueuedJob runs a claimed job, sending heartbeats until it finishes, and reports
+// the outcome to the queue
+func runQueuedJob(cfg *Config, q *jobQueue, worker string, job *queuedJob) {
+	log.Printf("📥 Claimed scan job %d: variant %s (run %s)", job.ID, job.Variant, job.RunID)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(workerHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := q.Heartbeat(job.ID, runningScanProgress(job.RunID, job.Variant)); err != nil {
+					log.Printf("⚠️  Heartbeat for job %d failed: %v", job.ID, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	logger := runLogger(job.RunID)
+	var result VariantResult
+	if err := prepareDisk(cfg, true, logger); err != nil {
+		logger.Printf("❌ %v", err)
+		result = failedVariants([]string{job.Variant}, err)[0]
+	} else {
+		result = runVariant(cfg, job.Variant, job.RunID, logger, job.Deadline, job.Priority)
+	}
+	close(done)
+
+	if err := q.Complete(job.ID, worker, result); err != nil {
+		log.Printf("❌ Could not report the result of job %d: %v", job.ID, err)
+		return
+	}
+	log.Printf("📤 Finished scan job %d (success: %t)", job.ID, result.Success)
+}
+
+// defaultWorkerName identifies the worker by host and process
+func defaultWorkerName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
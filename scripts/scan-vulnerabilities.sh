@@ -2,46 +2,77 @@
 
 set -e
 
+# --list prints the variant's images without scanning them
+LIST_ONLY=false
+if [[ "$1" == "--list" ]]; then
+    LIST_ONLY=true
+    shift
+fi
+
 # Get variant from argument (default: baseline)
 VARIANT="${1:-baseline}"
 
-if [[ "$VARIANT" != "baseline" && "$VARIANT" != "chainguard" ]]; then
-    echo "❌ Invalid variant. Use 'baseline' or 'chainguard'"
+# SCAN_IMAGES (comma-separated) replaces the built-in image lists, for variants
+# configured with their own images
+if [[ -n "$SCAN_IMAGES" ]]; then
+    if [[ ! "$VARIANT" =~ ^[a-z0-9][a-z0-9-]*$ ]]; then
+        echo "❌ Invalid variant name: $VARIANT"
+        exit 1
+    fi
+elif [[ "$VARIANT" != "baseline" && "$VARIANT" != "chainguard" ]]; then
+    echo "❌ Invalid variant. Use 'baseline' or 'chainguard', or set SCAN_IMAGES"
     echo "Usage: $0 [baseline|chainguard]"
     exit 1
 fi
 
-echo "=========================================="
-echo "Scanning Images for Vulnerabilities ($VARIANT)"
-echo "=========================================="
-echo ""
+if [[ "$LIST_ONLY" == false ]]; then
+    echo "=========================================="
+    echo "Scanning Images for Vulnerabilities ($VARIANT)"
+    if [[ -n "$SCAN_RUN_ID" ]]; then
+        echo "Run ID: $SCAN_RUN_ID"
+    fi
+    echo "=========================================="
+    echo ""
 
-# Check if Trivy is installed
-if ! command -v trivy &> /dev/null; then
-    echo "📥 Trivy not found. Installing..."
-    if [[ "$OSTYPE" == "darwin"* ]]; then
-        brew install aquasecurity/trivy/trivy
-    elif [[ "$OSTYPE" == "linux-gnu"* ]]; then
-        wget -qO - https://aquasecurity.github.io/trivy-repo/deb/public.key | sudo apt-key add -
-        echo "deb https://aquasecurity.github.io/trivy-repo/deb $(lsb_release -sc) main" | sudo tee -a /etc/apt/sources.list.d/trivy.list
-        sudo apt-get update
-        sudo apt-get install trivy
+    # Offline (OFFLINE=true) the scanners can't be downloaded, they must be installed
+    if [[ "$OFFLINE" == "true" ]]; then
+        for TOOL in trivy grype; do
+            if ! command -v "$TOOL" &> /dev/null; then
+                echo "❌ $TOOL not found and OFFLINE is set, install it in the image"
+                exit 1
+            fi
+        done
+    fi
+
+    # Check if Trivy is installed
+    if ! command -v trivy &> /dev/null; then
+        echo "📥 Trivy not found. Installing..."
+        if [[ "$OSTYPE" == "darwin"* ]]; then
+            brew install aquasecurity/trivy/trivy
+        elif [[ "$OSTYPE" == "linux-gnu"* ]]; then
+            wget -qO - https://aquasecurity.github.io/trivy-repo/deb/public.key | sudo apt-key add -
+            echo "deb https://aquasecurity.github.io/trivy-repo/deb $(lsb_release -sc) main" | sudo tee -a /etc/apt/sources.list.d/trivy.list
+            sudo apt-get update
+            sudo apt-get install trivy
+        fi
     fi
-fi
 
-# Check if Grype is installed
-if ! command -v grype &> /dev/null; then
-    echo "📥 Grype not found. Installing..."
-    if [[ "$OSTYPE" == "darwin"* ]]; then
-        brew install anchore/grype/grype
-    elif [[ "$OSTYPE" == "linux-gnu"* ]]; then
-        curl -sSfL https://raw.githubusercontent.com/anchore/grype/main/install.sh | sh -s -- -b /usr/local/bin
+    # Check if Grype is installed
+    if ! command -v grype &> /dev/null; then
+        echo "📥 Grype not found. Installing..."
+        if [[ "$OSTYPE" == "darwin"* ]]; then
+            brew install anchore/grype/grype
+        elif [[ "$OSTYPE" == "linux-gnu"* ]]; then
+            curl -sSfL https://raw.githubusercontent.com/anchore/grype/main/install.sh | sh -s -- -b /usr/local/bin
+        fi
     fi
 fi
 
-# Create reports directory with variant subdirectory
-REPORTS_DIR="./reports/$VARIANT"
-mkdir -p "$REPORTS_DIR"
+# Sibling scripts are found next to this one, wherever it was installed
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+
+# Create reports directory with variant subdirectory (REPORTS_PATH overrides ./reports)
+REPORTS_DIR="${REPORTS_PATH:-./reports}/$VARIANT"
 
 # Application images with variant tags
 APP_IMAGES=(
@@ -72,6 +103,34 @@ fi
 
 # Combine all images
 IMAGES=("${APP_IMAGES[@]}" "${INFRA_IMAGES[@]}")
+if [[ -n "$SCAN_IMAGES" ]]; then
+    IFS=',' read -r -a IMAGES <<< "$SCAN_IMAGES"
+fi
+
+if [[ "$LIST_ONLY" == true ]]; then
+    printf '%s\n' "${IMAGES[@]}"
+    exit 0
+fi
+
+mkdir -p "$REPORTS_DIR"
+
+# Images skipped by a previous time-boxed cycle (comma-separated) are scanned first
+if [[ -n "$SCAN_PRIORITY_IMAGES" ]]; then
+    PRIORITY=()
+    REMAINING=()
+    for IMAGE in "${IMAGES[@]}"; do
+        if [[ ",$SCAN_PRIORITY_IMAGES," == *",$IMAGE,"* ]]; then
+            PRIORITY+=("$IMAGE")
+        else
+            REMAINING+=("$IMAGE")
+        fi
+    done
+    IMAGES=("${PRIORITY[@]}" "${REMAINING[@]}")
+fi
+
+# Images not started before SCAN_DEADLINE (Unix time) are recorded here instead of scanned
+SKIPPED_FILE="$REPORTS_DIR/.skipped-images"
+rm -f "$SKIPPED_FILE"
 
 # Function to extract base image from Dockerfile
 get_base_image() {
@@ -91,58 +150,242 @@ get_base_image() {
     fi
 }
 
-echo "Scanning ${#IMAGES[@]} images..."
-echo ""
+# Images scanned at the same time (SCAN_CONCURRENCY) and the seconds each may take
+# (SCAN_IMAGE_TIMEOUT, 0 for no limit)
+CONCURRENCY="${SCAN_CONCURRENCY:-1}"
+IMAGE_TIMEOUT="${SCAN_IMAGE_TIMEOUT:-0}"
 
-for IMAGE in "${IMAGES[@]}"; do
-    IMAGE_NAME=$(echo "$IMAGE" | tr '/:' '_')
+# Images whose scan failed or timed out are recorded here with the reason; the
+# other images are scanned regardless
+FAILED_FILE="$REPORTS_DIR/.failed-images"
+rm -f "$FAILED_FILE"
 
-    # Extract base image info
-    BASE_IMAGE=$(get_base_image "$IMAGE" "$VARIANT")
-    echo "📦 Base image: $BASE_IMAGE"
+# run_step runs a scan command within what is left of the image's deadline (0: none)
+run_step() {
+    local deadline=$1
+    shift
+    if [[ "$deadline" -eq 0 ]]; then
+        "$@"
+        return
+    fi
+    local remaining=$((deadline - $(date +%s)))
+    if [[ $remaining -le 0 ]]; then
+        return 124
+    fi
+    timeout "$remaining" "$@"
+}
+
+# fail_image records a failed image and removes its reports, those of every
+# platform included, so an outdated or partial report isn't loaded as the image's
+# current result
+fail_image() {
+    local image=$1 image_name=$2 step=$3 status=$4
+    local reason="$step failed (exit $status)"
+    if [[ $status -eq 124 ]]; then
+        reason="timed out after ${IMAGE_TIMEOUT}s during $step"
+    fi
+    echo "❌ Failed to scan $image: $reason"
+    printf '%s\t%s\n' "$image" "$reason" >> "$FAILED_FILE"
+    local report
+    for report in "$image_name" "$image_name"+*; do
+        rm -f "$REPORTS_DIR/${report}_trivy_scan.json" "$REPORTS_DIR/${report}_grype_scan.json" \
+            "$REPORTS_DIR/${report}_scan.json" "$REPORTS_DIR/${report}_scan.txt"
+    done
+    rm -f "$REPORTS_DIR/${image_name}_secrets.json" "$REPORTS_DIR/${image_name}_licenses.json"
+}
+
+# Platforms of multi-arch images scanned separately (SCAN_PLATFORMS, comma-separated,
+# e.g. linux/amd64,linux/arm64). Without any, an image is scanned once, for the
+# platform its tag resolves to.
+PLATFORMS=()
+if [[ -n "$SCAN_PLATFORMS" ]]; then
+    IFS=',' read -r -a PLATFORMS <<< "$SCAN_PLATFORMS"
+fi
+
+# report_names prints the name of each report of an image: one per platform, joined
+# with '+' since it can't appear in an image reference (nginx_1.27+linux-arm64)
+report_names() {
+    local image_name=$1
+    if [[ ${#PLATFORMS[@]} -eq 0 ]]; then
+        echo "$image_name"
+        return
+    fi
+    local platform
+    for platform in "${PLATFORMS[@]}"; do
+        echo "${image_name}+${platform//\//-}"
+    done
+}
+
+# has_reports tells whether every report of an image is on disk
+has_reports() {
+    local report
+    for report in $(report_names "$1"); do
+        [[ -f "$REPORTS_DIR/${report}_scan.json" ]] || return 1
+    done
+}
+
+# scan_platform scans one image with Trivy and Grype and merges the results into the
+# report named REPORT, for PLATFORM when one is given. It adds the findings to the
+# caller's CRITICAL, HIGH, MEDIUM and LOW counts.
+scan_platform() {
+    local IMAGE=$1 IMAGE_NAME=$2 REPORT=$3 PLATFORM=$4 DEADLINE=$5 BASE_IMAGE=$6
+    local STATUS=0
+    local PLATFORM_ARGS=()
+    if [[ -n "$PLATFORM" ]]; then
+        PLATFORM_ARGS=(--platform "$PLATFORM")
+        echo "   🖥️  Platform $PLATFORM"
+    fi
 
     echo "🔍 Scanning $IMAGE with Trivy..."
 
     # Trivy scan
-    trivy image \
+    run_step "$DEADLINE" trivy image "${PLATFORM_ARGS[@]}" \
         --severity CRITICAL,HIGH,MEDIUM,LOW \
         --format json \
-        --output "$REPORTS_DIR/${IMAGE_NAME}_trivy_scan.json" \
-        "$IMAGE" 2>/dev/null
+        --output "$REPORTS_DIR/${REPORT}_trivy_scan.json" \
+        "$IMAGE" 2>/dev/null || STATUS=$?
+    if [[ $STATUS -ne 0 ]]; then
+        fail_image "$IMAGE" "$IMAGE_NAME" "trivy${PLATFORM:+ ($PLATFORM)}" $STATUS
+        return 1
+    fi
 
-    trivy image \
+    run_step "$DEADLINE" trivy image "${PLATFORM_ARGS[@]}" \
         --severity CRITICAL,HIGH,MEDIUM,LOW \
         --format table \
-        --output "$REPORTS_DIR/${IMAGE_NAME}_scan.txt" \
-        "$IMAGE" 2>/dev/null
+        --output "$REPORTS_DIR/${REPORT}_scan.txt" \
+        "$IMAGE" 2>/dev/null || STATUS=$?
+    if [[ $STATUS -ne 0 ]]; then
+        fail_image "$IMAGE" "$IMAGE_NAME" "trivy${PLATFORM:+ ($PLATFORM)}" $STATUS
+        return 1
+    fi
 
     echo "   🔍 Scanning $IMAGE with Grype..."
 
     # Grype scan
-    grype -q "$IMAGE" -o json > "$REPORTS_DIR/${IMAGE_NAME}_grype_scan.json" 2>/dev/null
+    run_step "$DEADLINE" grype -q "${PLATFORM_ARGS[@]}" "$IMAGE" -o json > "$REPORTS_DIR/${REPORT}_grype_scan.json" 2>/dev/null || STATUS=$?
+    if [[ $STATUS -ne 0 ]]; then
+        fail_image "$IMAGE" "$IMAGE_NAME" "grype${PLATFORM:+ ($PLATFORM)}" $STATUS
+        return 1
+    fi
 
-    echo "   🔀 Merging results..."
+    echo "   🔀 Merging results for $IMAGE..."
 
-    # Merge results with base image metadata
-    python3 ./scripts/merge-scan-results.py \
-        "$REPORTS_DIR/${IMAGE_NAME}_trivy_scan.json" \
-        "$REPORTS_DIR/${IMAGE_NAME}_grype_scan.json" \
-        "$REPORTS_DIR/${IMAGE_NAME}_scan.json" \
-        "$BASE_IMAGE"
+    # Merge results with base image metadata (and the platform, when scanned for one)
+    run_step "$DEADLINE" python3 "$SCRIPT_DIR/merge-scan-results.py" \
+        "$REPORTS_DIR/${REPORT}_trivy_scan.json" \
+        "$REPORTS_DIR/${REPORT}_grype_scan.json" \
+        "$REPORTS_DIR/${REPORT}_scan.json" \
+        "$BASE_IMAGE" ${PLATFORM:+"$PLATFORM"} || STATUS=$?
+    if [[ $STATUS -ne 0 ]]; then
+        fail_image "$IMAGE" "$IMAGE_NAME" "merge${PLATFORM:+ ($PLATFORM)}" $STATUS
+        return 1
+    fi
 
     # Quick summary from merged results
-    CRITICAL=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="CRITICAL")] | length' "$REPORTS_DIR/${IMAGE_NAME}_scan.json")
-    HIGH=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="HIGH")] | length' "$REPORTS_DIR/${IMAGE_NAME}_scan.json")
-    MEDIUM=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="MEDIUM")] | length' "$REPORTS_DIR/${IMAGE_NAME}_scan.json")
-    LOW=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="LOW")] | length' "$REPORTS_DIR/${IMAGE_NAME}_scan.json")
+    local C H M L
+    C=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="CRITICAL")] | length' "$REPORTS_DIR/${REPORT}_scan.json")
+    H=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="HIGH")] | length' "$REPORTS_DIR/${REPORT}_scan.json")
+    M=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="MEDIUM")] | length' "$REPORTS_DIR/${REPORT}_scan.json")
+    L=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="LOW")] | length' "$REPORTS_DIR/${REPORT}_scan.json")
+    if [[ -n "$PLATFORM" ]]; then
+        echo "   ✅ $PLATFORM: $((C + H + M + L)) vulnerabilities (C:$C H:$H M:$M L:$L)"
+    fi
+    CRITICAL=$((CRITICAL + C))
+    HIGH=$((HIGH + H))
+    MEDIUM=$((MEDIUM + M))
+    LOW=$((LOW + L))
+}
+
+# scan_image scans one image, once per platform of SCAN_PLATFORMS
+scan_image() {
+    local IMAGE=$1
+    local IMAGE_NAME
+    IMAGE_NAME=$(echo "$IMAGE" | tr '/:' '_')
+    local DEADLINE=0
+    if [[ "$IMAGE_TIMEOUT" -gt 0 ]]; then
+        DEADLINE=$(($(date +%s) + IMAGE_TIMEOUT))
+    fi
+
+    # Secret and license findings are refreshed by the scheduler's secrets and licenses
+    # steps; drop the previous ones so they aren't reported once a step is turned off
+    rm -f "$REPORTS_DIR/${IMAGE_NAME}_secrets.json" "$REPORTS_DIR/${IMAGE_NAME}_licenses.json"
+
+    # Extract base image info
+    local BASE_IMAGE
+    BASE_IMAGE=$(get_base_image "$IMAGE" "$VARIANT")
+    echo "📦 Base image: $BASE_IMAGE"
+
+    local CRITICAL=0 HIGH=0 MEDIUM=0 LOW=0 TOTAL
+    if [[ ${#PLATFORMS[@]} -eq 0 ]]; then
+        scan_platform "$IMAGE" "$IMAGE_NAME" "$IMAGE_NAME" "" "$DEADLINE" "$BASE_IMAGE" || return 0
+    else
+        local PLATFORM
+        for PLATFORM in "${PLATFORMS[@]}"; do
+            scan_platform "$IMAGE" "$IMAGE_NAME" "${IMAGE_NAME}+${PLATFORM//\//-}" "$PLATFORM" "$DEADLINE" "$BASE_IMAGE" || return 0
+        done
+    fi
     TOTAL=$((CRITICAL + HIGH + MEDIUM + LOW))
 
-    echo "   ✅ Merged: $TOTAL vulnerabilities (C:$CRITICAL H:$HIGH M:$MEDIUM L:$LOW)"
+    echo "   ✅ Merged $IMAGE: $TOTAL vulnerabilities (C:$CRITICAL H:$HIGH M:$MEDIUM L:$LOW)"
     echo ""
+}
+
+echo "Scanning ${#IMAGES[@]} images..."
+if [[ ${#PLATFORMS[@]} -gt 0 ]]; then
+    echo "Scanning each image for ${PLATFORMS[*]}"
+fi
+if [[ "$CONCURRENCY" -gt 1 ]]; then
+    echo "Running up to $CONCURRENCY image scans at a time"
+fi
+echo ""
+
+STARTED=0
+for IMAGE in "${IMAGES[@]}"; do
+    IMAGE_NAME=$(echo "$IMAGE" | tr '/:' '_')
+
+    # Images excluded by a scheduler exclusion rule (comma-separated) are not scanned
+    if [[ ",$SCAN_EXCLUDE_IMAGES," == *",$IMAGE,"* ]]; then
+        echo "🚫 $IMAGE is excluded by rule, not scanning"
+        continue
+    fi
+
+    # Images whose digest and scanner databases match their last scan keep that report
+    if [[ ",$SCAN_UNCHANGED_IMAGES," == *",$IMAGE,"* ]] && has_reports "$IMAGE_NAME"; then
+        echo "♻️  $IMAGE is unchanged since its last scan, keeping its report"
+        continue
+    fi
+
+    # Wait for a free slot, so the deadline is checked when the image would start
+    while [[ $(jobs -rp | wc -l) -ge $CONCURRENCY ]]; do
+        wait -n || true
+    done
+
+    if [[ -n "$SCAN_DEADLINE" && $(date +%s) -ge $SCAN_DEADLINE ]]; then
+        echo "⏭️  Cycle time budget exhausted, skipping $IMAGE"
+        echo "$IMAGE" >> "$SKIPPED_FILE"
+        continue
+    fi
+
+    STARTED=$((STARTED + 1))
+    if [[ "$CONCURRENCY" -gt 1 ]]; then
+        scan_image "$IMAGE" &
+    else
+        scan_image "$IMAGE"
+    fi
 done
+wait
+
+FAILED=0
+if [[ -f "$FAILED_FILE" ]]; then
+    FAILED=$(wc -l < "$FAILED_FILE")
+fi
 
 echo "=========================================="
-echo "✅ Vulnerability Scanning Complete!"
+if [[ $FAILED -gt 0 ]]; then
+    echo "⚠️  Vulnerability Scanning Complete, $FAILED of $STARTED image(s) failed"
+else
+    echo "✅ Vulnerability Scanning Complete!"
+fi
 echo "=========================================="
 echo ""
 echo "📊 Reports available in: $REPORTS_DIR/"
@@ -150,13 +393,28 @@ echo ""
 echo "Summary of all images:"
 for IMAGE in "${IMAGES[@]}"; do
     IMAGE_NAME=$(echo "$IMAGE" | tr '/:' '_')
-    if [ -f "$REPORTS_DIR/${IMAGE_NAME}_scan.json" ]; then
-        CRITICAL=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="CRITICAL")] | length' "$REPORTS_DIR/${IMAGE_NAME}_scan.json")
-        HIGH=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="HIGH")] | length' "$REPORTS_DIR/${IMAGE_NAME}_scan.json")
-        MEDIUM=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="MEDIUM")] | length' "$REPORTS_DIR/${IMAGE_NAME}_scan.json")
-        LOW=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="LOW")] | length' "$REPORTS_DIR/${IMAGE_NAME}_scan.json")
+    if [[ ",$SCAN_EXCLUDE_IMAGES," == *",$IMAGE,"* ]]; then
+        echo "  $IMAGE: excluded"
+    elif [[ -f "$FAILED_FILE" ]] && cut -f1 "$FAILED_FILE" | grep -qxF "$IMAGE"; then
+        echo "  $IMAGE: failed"
+    else
+        for REPORT in $(report_names "$IMAGE_NAME"); do
+            if [ -f "$REPORTS_DIR/${REPORT}_scan.json" ]; then
+                CRITICAL=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="CRITICAL")] | length' "$REPORTS_DIR/${REPORT}_scan.json")
+                HIGH=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="HIGH")] | length' "$REPORTS_DIR/${REPORT}_scan.json")
+                MEDIUM=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="MEDIUM")] | length' "$REPORTS_DIR/${REPORT}_scan.json")
+                LOW=$(jq '[.Results[].Vulnerabilities[]? | select(.Severity=="LOW")] | length' "$REPORTS_DIR/${REPORT}_scan.json")
 
-        TOTAL=$((CRITICAL + HIGH + MEDIUM + LOW))
-        echo "  $IMAGE: $TOTAL vulnerabilities (C:$CRITICAL H:$HIGH M:$MEDIUM L:$LOW)"
+                TOTAL=$((CRITICAL + HIGH + MEDIUM + LOW))
+                PLATFORM=$(jq -r '.Platform // empty' "$REPORTS_DIR/${REPORT}_scan.json")
+                echo "  $IMAGE${PLATFORM:+ ($PLATFORM)}: $TOTAL vulnerabilities (C:$CRITICAL H:$HIGH M:$MEDIUM L:$LOW)"
+            fi
+        done
     fi
 done
+
+# Failed images are reported by the scheduler; the scan only fails when none succeeded
+if [[ $STARTED -gt 0 && $FAILED -eq $STARTED ]]; then
+    echo "❌ Every image failed to scan"
+    exit 1
+fi
@@ -0,0 +1,194 @@
+#!/usr/bin/env python3
+"""
+Load merged reports into a SQLite database with load-to-database.py and check what
+it stored: loading a run again updates its scans in place, delta loads only store
+changed findings, and the lifecycle and changesets follow the scans
+
+Run from the repository root: python3 -m unittest discover -s scripts/tests
+"""
+
+import json
+import sqlite3
+import subprocess
+import sys
+import tempfile
+import unittest
+from pathlib import Path
+
+LOADER = Path(__file__).resolve().parent.parent / 'load-to-database.py'
+VARIANT = 'shop'
+REPORT = 'nginx_1.27_scan.json'
+
+
+def finding(cve, package, version='1.0', severity='HIGH', fixed='', found_by='trivy, grype'):
+    return {'VulnerabilityID': cve, 'PkgName': package, 'InstalledVersion': version,
+            'Severity': severity, 'FixedVersion': fixed, 'FoundBy': found_by}
+
+
+def report(findings, digest='sha256:aaa'):
+    """A merged report of nginx:1.27 scanned from the image with the given digest"""
+    return {
+        'ReportVersion': 1,
+        'ArtifactName': 'nginx:1.27',
+        'Metadata': {'RepoDigests': [f'nginx@{digest}']},
+        'MergeStats': {'trivy_only': 0, 'grype_only': 0, 'found_by_both': len(findings)},
+        'Results': [{'Target': 'nginx:1.27 (debian 12)', 'Type': 'debian', 'Vulnerabilities': findings}],
+    }
+
+
+FINDINGS = [
+    finding('CVE-2024-0001', 'openssl', '3.0.11', 'CRITICAL', fixed='3.0.13'),
+    finding('CVE-2024-0002', 'zlib', '1.2.13', 'HIGH'),
+    finding('CVE-2024-0003', 'curl', '7.88.1', 'MEDIUM'),
+]
+
+
+class LoaderTest(unittest.TestCase):
+    load_mode = 'full'
+
+    def setUp(self):
+        tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(tmp.cleanup)
+        self.reports = Path(tmp.name)
+        (self.reports / VARIANT).mkdir()
+        self.db_path = self.reports / 'vulndb.sqlite'
+
+    def load(self, findings, run_id='run-1', digest='sha256:aaa'):
+        """Write the report and load it as run_id, failing the test when the load fails"""
+        (self.reports / VARIANT / REPORT).write_text(json.dumps(report(findings, digest)))
+        args = [sys.executable, str(LOADER), '--variant', VARIANT]
+        if run_id:
+            args += ['--run-id', run_id]
+        env = {
+            # No docker: the image metadata is left out
+            'PATH': '/nonexistent',
+            'REPORTS_PATH': str(self.reports),
+            'DB_BACKEND': 'sqlite',
+            'DB_PATH': str(self.db_path),
+            'DB_LOAD_MODE': self.load_mode,
+        }
+        result = subprocess.run(args, env=env, capture_output=True, text=True)
+        self.assertEqual(result.returncode, 0, result.stdout + result.stderr)
+        return result.stdout
+
+    def query(self, sql, *params):
+        conn = sqlite3.connect(self.db_path)
+        try:
+            return conn.execute(sql, params).fetchall()
+        finally:
+            conn.close()
+
+    def count(self, table):
+        return self.query(f'SELECT COUNT(*) FROM {table}')[0][0]
+
+    def lifecycle(self):
+        """The status of each finding of the image, by CVE"""
+        return dict(self.query('SELECT cve_id, status FROM vulnerability_lifecycle'))
+
+
+class FullLoadTest(LoaderTest):
+    def test_loading_a_run_twice_keeps_one_scan(self):
+        self.load(FINDINGS)
+        out = self.load(FINDINGS)
+        self.assertIn('already loaded this image', out)
+        self.assertEqual(self.count('images'), 1)
+        self.assertEqual(self.count('scans'), 1)
+        self.assertEqual(self.count('vulnerabilities'), 3)
+        self.assertEqual(self.count('vulnerability_lifecycle'), 3)
+        self.assertEqual(self.count('scan_comparisons'), 0)
+
+    def test_rerun_updates_the_scan_in_place(self):
+        self.load(FINDINGS)
+        (scan_id,), = self.query('SELECT id FROM scans')
+        changed = [FINDINGS[0], dict(FINDINGS[1], Severity='CRITICAL')]
+        self.load(changed)
+
+        self.assertEqual(self.query('SELECT id, total_vulnerabilities, critical_count FROM scans'), [(scan_id, 2, 2)])
+        self.assertEqual(sorted(self.query('SELECT cve_id, severity FROM vulnerabilities')),
+                         [('CVE-2024-0001', 'CRITICAL'), ('CVE-2024-0002', 'CRITICAL')])
+        self.assertEqual(self.lifecycle()['CVE-2024-0003'], 'fixed')
+
+    def test_new_run_adds_a_scan_and_a_changeset(self):
+        self.load(FINDINGS)
+        self.load(FINDINGS[:2] + [finding('CVE-2024-0004', 'libxml2')], run_id='run-2')
+
+        self.assertEqual(self.count('scans'), 2)
+        self.assertEqual(self.count('vulnerabilities'), 6)
+        self.assertEqual(self.lifecycle(), {
+            'CVE-2024-0001': 'active', 'CVE-2024-0002': 'active',
+            'CVE-2024-0003': 'fixed', 'CVE-2024-0004': 'active',
+        })
+        self.assertEqual(self.query(
+            'SELECT new_vulnerabilities, fixed_vulnerabilities, unchanged_vulnerabilities FROM scan_comparisons'),
+            [(1, 1, 2)])
+
+        # Loading the second run again doesn't add a changeset
+        self.load(FINDINGS[:2] + [finding('CVE-2024-0004', 'libxml2')], run_id='run-2')
+        self.assertEqual(self.count('scans'), 2)
+        self.assertEqual(self.count('scan_comparisons'), 1)
+
+    def test_same_run_with_another_digest_adds_a_scan(self):
+        self.load(FINDINGS, digest='sha256:aaa')
+        self.load(FINDINGS, digest='sha256:bbb')
+        self.assertEqual(sorted(self.query('SELECT run_id, image_digest FROM scans')),
+                         [('run-1', 'sha256:aaa'), ('run-1', 'sha256:bbb')])
+
+    def test_loads_without_a_run_id_always_add_a_scan(self):
+        self.load(FINDINGS, run_id=None)
+        self.load(FINDINGS, run_id=None)
+        self.assertEqual(self.count('scans'), 2)
+
+    def test_rerun_of_an_older_run_leaves_the_lifecycle_alone(self):
+        self.load(FINDINGS)
+        self.load(FINDINGS[:1], run_id='run-2')
+        before = self.lifecycle()
+
+        out = self.load(FINDINGS[:2])
+        self.assertIn('lifecycle is left as is', out)
+        self.assertEqual(self.count('scans'), 2)
+        self.assertEqual(self.lifecycle(), before)
+        self.assertEqual(self.query(
+            "SELECT COUNT(*) FROM vulnerabilities v JOIN scans s ON s.id = v.scan_id WHERE s.run_id = 'run-1'"),
+            [(2,)])
+
+
+class DeltaLoadTest(LoaderTest):
+    load_mode = 'delta'
+
+    def test_delta_load_stores_only_changes(self):
+        self.load(FINDINGS)
+        self.assertEqual(self.count('vulnerabilities'), 3)
+
+        later = [
+            FINDINGS[0],                                 # unchanged
+            dict(FINDINGS[1], FixedVersion='1.3.1'),     # changed
+            finding('CVE-2024-0004', 'libxml2', 'LOW'),  # new; CVE-2024-0003 is gone
+        ]
+        self.load(later, run_id='run-2')
+
+        (first,), (second,) = self.query('SELECT id FROM scans ORDER BY id')
+        self.assertEqual(sorted(self.query('SELECT cve_id FROM vulnerabilities WHERE scan_id = ?', second)),
+                         [('CVE-2024-0002',), ('CVE-2024-0004',)])
+        self.assertEqual(self.query('SELECT load_mode FROM scans WHERE id = ?', second), [('delta',)])
+        self.assertEqual(self.lifecycle()['CVE-2024-0003'], 'fixed')
+        # The unchanged finding still points at the row of the first scan
+        self.assertEqual(self.query(
+            "SELECT v.scan_id FROM vulnerability_lifecycle l JOIN vulnerabilities v ON v.id = l.vuln_id "
+            "WHERE l.cve_id = 'CVE-2024-0001'"), [(first,)])
+        (details,), = self.query('SELECT details FROM scan_comparisons')
+        details = json.loads(details)
+        self.assertEqual([f['cve_id'] for f in details['changed']], ['CVE-2024-0002'])
+        self.assertEqual([f['cve_id'] for f in details['closed']], ['CVE-2024-0003'])
+
+    def test_delta_rerun_is_idempotent(self):
+        self.load(FINDINGS)
+        later = FINDINGS[:2] + [finding('CVE-2024-0004', 'libxml2', 'LOW')]
+        self.load(later, run_id='run-2')
+        counts = [self.count(t) for t in ('scans', 'vulnerabilities', 'vulnerability_lifecycle', 'scan_comparisons')]
+        self.load(later, run_id='run-2')
+        self.assertEqual([self.count(t) for t in ('scans', 'vulnerabilities', 'vulnerability_lifecycle', 'scan_comparisons')],
+                         counts)
+
+
+if __name__ == '__main__':
+    unittest.main()
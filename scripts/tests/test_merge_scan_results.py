@@ -0,0 +1,112 @@
+#!/usr/bin/env python3
+"""
+Check how merge-scan-results.py deduplicates the findings of Trivy and Grype and
+reconciles the severities they rate differently
+
+Run from the repository root: python3 -m unittest discover -s scripts/tests
+"""
+
+import importlib.util
+import unittest
+from pathlib import Path
+
+spec = importlib.util.spec_from_file_location(
+    'merge_scan_results', Path(__file__).resolve().parent.parent / 'merge-scan-results.py')
+merge = importlib.util.module_from_spec(spec)
+spec.loader.exec_module(merge)
+
+
+def trivy(*vulns):
+    return {'Results': [{'Target': 'nginx:1.27 (debian 12)', 'Type': 'debian', 'Vulnerabilities': list(vulns)}]}
+
+
+def trivy_vuln(cve, package, severity, version='1.0', **extra):
+    return dict({'VulnerabilityID': cve, 'PkgName': package, 'InstalledVersion': version, 'Severity': severity}, **extra)
+
+
+def grype(*matches):
+    return {'matches': list(matches)}
+
+
+def grype_match(vuln_id, package, severity, version='1.0', related=(), fixes=()):
+    return {
+        'vulnerability': {
+            'id': vuln_id, 'severity': severity,
+            'relatedVulnerabilities': [{'id': r} for r in related],
+            'fix': {'versions': list(fixes)},
+        },
+        'artifact': {'name': package, 'version': version, 'type': 'deb'},
+    }
+
+
+def merged(trivy_data, grype_data, policy='highest'):
+    vulns, stats = merge.merge_vulnerabilities(
+        merge.parse_trivy_results(trivy_data), merge.parse_grype_results(grype_data), policy)
+    return {(v['id'], v['package']): v for v in vulns}, stats
+
+
+class MergeTest(unittest.TestCase):
+    def test_findings_of_both_scanners_are_merged(self):
+        vulns, stats = merged(
+            trivy(trivy_vuln('CVE-2024-0001', 'openssl', 'HIGH'), trivy_vuln('CVE-2024-0002', 'zlib', 'LOW')),
+            grype(grype_match('CVE-2024-0001', 'openssl', 'High', fixes=['3.0.13']),
+                  grype_match('CVE-2024-0003', 'curl', 'Medium')))
+
+        self.assertEqual(sorted(vulns), [('CVE-2024-0001', 'openssl'), ('CVE-2024-0002', 'zlib'), ('CVE-2024-0003', 'curl')])
+        openssl = vulns[('CVE-2024-0001', 'openssl')]
+        self.assertEqual(openssl['found_by'], ['trivy', 'grype'])
+        self.assertEqual(openssl['fixed_version'], '3.0.13')
+        self.assertEqual((stats['both'], stats['trivy_only'], stats['grype_only'], stats['duplicates']), (1, 1, 1, 1))
+
+    def test_packages_match_across_naming_and_version_formats(self):
+        vulns, stats = merged(
+            trivy(trivy_vuln('CVE-2024-0001', 'Python_Dateutil', 'MEDIUM', version='1:2.8.2-3')),
+            grype(grype_match('CVE-2024-0001', 'python-dateutil', 'Medium', version='2.8.2')))
+        self.assertEqual(len(vulns), 1)
+        self.assertEqual(stats['both'], 1)
+
+    def test_grype_advisories_are_matched_by_their_cve(self):
+        vulns, _ = merged(
+            trivy(trivy_vuln('CVE-2024-0001', 'openssl', 'HIGH')),
+            grype(grype_match('GHSA-aaaa-bbbb-cccc', 'openssl', 'High', related=['CVE-2024-0001'])))
+        self.assertEqual(list(vulns), [('CVE-2024-0001', 'openssl')])
+        self.assertEqual(vulns[('CVE-2024-0001', 'openssl')]['aliases'], ['GHSA-aaaa-bbbb-cccc'])
+
+    def test_severity_policies(self):
+        data = (trivy(trivy_vuln('CVE-2024-0001', 'openssl', 'MEDIUM')),
+                grype(grype_match('CVE-2024-0001', 'openssl', 'Critical')))
+        for policy, want in (('highest', 'CRITICAL'), ('trivy', 'MEDIUM'), ('grype', 'CRITICAL')):
+            vulns, stats = merged(*data, policy=policy)
+            vuln = vulns[('CVE-2024-0001', 'openssl')]
+            self.assertEqual(vuln['severity'], want, policy)
+            self.assertEqual(vuln['severities'], {'trivy': 'MEDIUM', 'grype': 'CRITICAL'})
+            self.assertEqual(stats['severity_mismatch'], 1)
+
+    def test_policy_falls_back_when_the_scanner_has_no_rating(self):
+        vulns, _ = merged(
+            trivy(trivy_vuln('CVE-2024-0001', 'openssl', 'UNKNOWN')),
+            grype(grype_match('CVE-2024-0001', 'openssl', 'Negligible')),
+            policy='trivy')
+        self.assertEqual(vulns[('CVE-2024-0001', 'openssl')]['severity'], 'LOW')
+
+    def test_a_scanner_reporting_a_package_twice_keeps_its_worst_rating(self):
+        vulns, stats = merged(
+            trivy(trivy_vuln('CVE-2024-0001', 'openssl', 'LOW'), trivy_vuln('CVE-2024-0001', 'openssl', 'HIGH')),
+            grype())
+        vuln = vulns[('CVE-2024-0001', 'openssl')]
+        self.assertEqual((vuln['severity'], vuln['found_by']), ('HIGH', ['trivy']))
+        self.assertEqual((stats['duplicates'], stats['trivy_only'], stats['severity_mismatch']), (1, 1, 0))
+
+    def test_merged_output_records_disagreements(self):
+        vulns, _ = merged(
+            trivy(trivy_vuln('CVE-2024-0001', 'openssl', 'MEDIUM')),
+            grype(grype_match('CVE-2024-0001', 'openssl', 'High')))
+        output = merge.create_trivy_compatible_output(list(vulns.values()), {'ArtifactName': 'nginx:1.27'})
+        vuln, = output['Results'][0]['Vulnerabilities']
+        self.assertEqual(vuln['FoundBy'], 'trivy,grype')
+        self.assertEqual(vuln['ScannerSeverities'], {'trivy': 'MEDIUM', 'grype': 'HIGH'})
+        self.assertEqual(output['ReportVersion'], merge.REPORT_VERSION)
+
+
+if __name__ == '__main__':
+    unittest.main()